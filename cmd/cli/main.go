@@ -7,6 +7,7 @@ import (
 	"net/http"
 
 	"github.com/kerelape/gophkeeper/internal/cli"
+	"github.com/kerelape/gophkeeper/pkg/gophkeeper/encrypted"
 	"github.com/kerelape/gophkeeper/pkg/gophkeeper/rest"
 )
 
@@ -20,9 +21,13 @@ func main() {
 	}
 
 	application := cli.CLI{
-		Gophkeeper: &rest.Gophkeeper{
-			Server: *server,
-			Client: http.Client{},
+		Gophkeeper: encrypted.Gophkeeper{
+			Origin: &rest.Gophkeeper{
+				Server: *server,
+				Client: http.Client{},
+			},
+			Cipher:   encrypted.CFBCipher{},
+			Verifier: encrypted.Verifier,
 		},
 		CommandLine: flag.Args(),
 	}
@@ -18,9 +18,38 @@ type Config struct {
 		Lifespan time.Duration `env:"LIFESPAN" env-description:"JWT Token lifespan in milliseconds" env-default:"15m"`
 		Secret   string        `env:"SECRET" env-description:"Base64 encoded JWT Token secret" env-required:"true"`
 	} `env-prefix:"TOKEN_"`
-	UsernameMinLength uint   `env:"USERNAME_MIN_LENGTH" env-description:"Username minimum length" env-default:"0"`
-	PasswordMinLength uint   `env:"PASSWORD_MIN_LENGTH" env-description:"Password minimum length" env-default:"0"`
-	DatabaseDSN       string `env:"DATABASE_DSN" env-description:"Database connection URL" env-required:"true"`
+	UsernameMinLength uint          `env:"USERNAME_MIN_LENGTH" env-description:"Username minimum length" env-default:"0"`
+	PasswordMinLength uint          `env:"PASSWORD_MIN_LENGTH" env-description:"Password minimum length" env-default:"0"`
+	DatabaseDSN       string        `env:"DATABASE_DSN" env-description:"Database connection URL, sqlite://<path> stores the vault in a sqlite database file" env-required:"true"`
+	VersionsLimit     uint          `env:"VERSIONS_LIMIT" env-description:"Number of previous versions kept for each resource" env-default:"10"`
+	RequireEncryption bool          `env:"REQUIRE_ENCRYPTION" env-description:"Refuse pieces and blobs not encrypted by the client" env-default:"false"`
+	VaultSessionIdle  time.Duration `env:"VAULT_SESSION_IDLE" env-description:"How long an unused vault session lasts" env-default:"5m"`
+	BlobCompression   bool          `env:"BLOB_COMPRESSION" env-description:"Let clients compress blobs before encrypting them" env-default:"true"`
+	MasterKeyFile     string        `env:"MASTER_KEY_FILE" env-description:"File the master keys encrypting the vault at rest are read from, re-read once modified"`
+	MasterKeys        string        `env:"MASTER_KEYS" env-description:"Master keys encrypting the vault at rest, <id>:<base64 key> separated by commas, the first is primary"`
+	RequireAtRest     bool          `env:"REQUIRE_AT_REST_ENCRYPTION" env-description:"Refuse stored content not encrypted at rest, set once rotate-master-key has encrypted all of it" env-default:"false"`
+	AdminToken        string        `env:"ADMIN_TOKEN" env-description:"Token authorizing the admin endpoints, they are disabled if empty"`
+	Trash             struct {
+		Retention     time.Duration `env:"RETENTION" env-description:"How long deleted resources are kept in the trash" env-default:"720h"`
+		PurgeInterval time.Duration `env:"PURGE_INTERVAL" env-description:"How often the trash is checked for expired resources" env-default:"1h"`
+	} `env-prefix:"TRASH_"`
+	BlobStore struct {
+		Driver string `env:"DRIVER" env-description:"Storage of the blob files, fs, s3 or postgres" env-default:"fs"`
+		Dir    string `env:"DIR" env-description:"Directory of the blob files of the fs storage" env-default:"blobs"`
+		Dedup  bool   `env:"DEDUP" env-description:"Store every unique chunk of the blob files once, run migrate-blobs once it is changed" env-default:"false"`
+		S3     struct {
+			Endpoint  string `env:"ENDPOINT" env-description:"URL of the S3-compatible storage, e.g. http://localhost:9000"`
+			Region    string `env:"REGION" env-description:"Region of the bucket" env-default:"us-east-1"`
+			Bucket    string `env:"BUCKET" env-description:"Bucket the blob files are kept in"`
+			Prefix    string `env:"PREFIX" env-description:"Prefix of the keys of the blob files"`
+			AccessKey string `env:"ACCESS_KEY" env-description:"Access key of the S3-compatible storage"`
+			SecretKey string `env:"SECRET_KEY" env-description:"Secret key of the S3-compatible storage"`
+		} `env-prefix:"S3_"`
+	} `env-prefix:"BLOB_STORE_"`
+	DatabasePool struct {
+		Size              int32         `env:"SIZE" env-description:"Maximum number of the connections to the postgres database" env-default:"10"`
+		HealthCheckPeriod time.Duration `env:"HEALTH_CHECK_PERIOD" env-description:"How often the idle connections to the postgres database are checked" env-default:"1m"`
+	} `env-prefix:"DATABASE_POOL_"`
 }
 
 // Read reads the config.
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/kerelape/gophkeeper/internal/server"
+	"github.com/kerelape/gophkeeper/internal/server/postgres"
+)
+
+// fsck cross-checks the resources, their content and the blob files.
+//
+// With -repair the orphans and the resources that cannot be restored are
+// moved to the quarantine instead of being removed. It fails if there are
+// problems left unrepaired.
+func fsck(database *postgres.Gophkeeper, args []string) error {
+	var (
+		flags  = flag.NewFlagSet("fsck", flag.ContinueOnError)
+		repair = flags.Bool("repair", false, "Move the problems found to the quarantine")
+		grace  = flags.Duration("grace", server.DefaultFsckGrace, "How old a blob file must be to be reported as an orphan")
+	)
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	runResult := make(chan error, 1)
+	go func() {
+		runResult <- database.Run(ctx)
+	}()
+
+	var (
+		report    server.FsckReport
+		fsckError error
+		done      = make(chan struct{})
+	)
+	go func() {
+		defer close(done)
+		report, fsckError = database.Fsck(ctx, server.FsckOptions{Repair: *repair, Grace: *grace})
+	}()
+
+	select {
+	case <-done:
+		cancel()
+		<-runResult
+	case err := <-runResult:
+		cancel()
+		<-done
+		if fsckError == nil || errors.Is(fsckError, context.Canceled) {
+			fsckError = err
+		}
+	}
+
+	unrepaired := 0
+	for _, problem := range report.Problems {
+		log.Println(problem.String())
+		if !problem.Quarantined {
+			unrepaired++
+		}
+	}
+	log.Printf(
+		"checked %d resources, %d pieces, %d blobs and %d files, found %d problems\n",
+		report.Resources, report.Pieces, report.Blobs, report.Files, len(report.Problems),
+	)
+	if fsckError != nil {
+		return fsckError
+	}
+	if unrepaired > 0 {
+		return fmt.Errorf("%d problems are not repaired", unrepaired)
+	}
+	return nil
+}
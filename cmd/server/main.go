@@ -3,15 +3,20 @@ package main
 import (
 	"context"
 	"encoding/base64"
+	"errors"
+	"fmt"
 	"log"
 	"net/http"
 	"os"
-	"path"
+	"path/filepath"
 
 	"github.com/kerelape/gophkeeper/cmd/server/config"
+	"github.com/kerelape/gophkeeper/internal/blobstore"
 	"github.com/kerelape/gophkeeper/internal/server"
 	"github.com/kerelape/gophkeeper/internal/server/postgres"
 	"github.com/kerelape/gophkeeper/internal/server/rest"
+	"github.com/kerelape/gophkeeper/internal/server/sqlite"
+	"github.com/kerelape/gophkeeper/pkg/gophkeeper"
 	"github.com/pior/runnable"
 	"golang.org/x/crypto/acme/autocert"
 )
@@ -28,23 +33,75 @@ func main() {
 		log.Fatalf("failed to parse token secret: %s", decodeSecretError.Error())
 	}
 
-	wd, wdError := os.Getwd()
-	if wdError != nil {
-		log.Fatalf(wdError.Error())
+	keys, keysError := keyProvider(configuration)
+	if keysError != nil {
+		log.Fatalf("failed to read master keys: %s", keysError.Error())
 	}
 
+	blobs, blobsError := blobStore(configuration)
+	if blobsError != nil {
+		log.Fatalf("failed to configure the blob store: %s", blobsError.Error())
+	}
+
+	database, databaseError := storage(
+		configuration,
+		server.NewJWTSource(
+			secret,
+			configuration.Token.Lifespan,
+		),
+		blobs,
+		keys,
+	)
+	if databaseError != nil {
+		log.Fatalf("failed to configure the database: %s", databaseError.Error())
+	}
+
+	if len(os.Args) > 1 && os.Args[1] == "migrate-crypto" {
+		if err := migrateCrypto(database, os.Args[2:]); err != nil {
+			log.Fatalf("failed to migrate: %s", err.Error())
+		}
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "migrate-blobs" {
+		if err := migrateBlobs(database, os.Args[2:]); err != nil {
+			log.Fatalf("failed to migrate the blob files: %s", err.Error())
+		}
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "fsck" {
+		postgresDatabase, ok := database.(*postgres.Gophkeeper)
+		if !ok {
+			log.Fatalf("fsck requires a postgres database")
+		}
+		if err := fsck(postgresDatabase, os.Args[2:]); err != nil {
+			log.Fatalf("failed to check the vault: %s", err.Error())
+		}
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "rotate-master-key" {
+		postgresDatabase, ok := database.(*postgres.Gophkeeper)
+		if !ok {
+			log.Fatalf("rotate-master-key requires a postgres database")
+		}
+		if err := rotateMasterKey(postgresDatabase, os.Args[2:]); err != nil {
+			log.Fatalf("failed to rotate the master key: %s", err.Error())
+		}
+		return
+	}
+
+	checker, _ := database.(server.Checker)
 	var (
-		database = postgres.New(
-			postgres.DSNSource(configuration.DatabaseDSN),
-			server.NewJWTSource(
-				secret,
-				configuration.Token.Lifespan,
-			),
-			postgres.WithBlobsDir(path.Join(wd, "blobs")),
-			postgres.WithPasswordEncoding(base64.RawStdEncoding),
-		)
+		purger = server.TrashPurger{
+			Trash:     database,
+			Retention: configuration.Trash.Retention,
+			Interval:  configuration.Trash.PurgeInterval,
+		}
 		rst = rest.Entry{
-			Gophkeeper: database,
+			Gophkeeper:        database,
+			RequireEncryption: configuration.RequireEncryption,
+			VaultSessionIdle:  configuration.VaultSessionIdle,
+			Checker:           checker,
+			AdminToken:        configuration.AdminToken,
 		}
 		srv = http.Server{
 			Addr:    configuration.Rest.Address,
@@ -54,6 +111,7 @@ func main() {
 
 	manager := runnable.NewManager()
 	manager.Add(database)
+	manager.Add(&purger, database)
 
 	if configuration.Rest.UseTLS {
 		m := autocert.Manager{
@@ -79,3 +137,117 @@ func main() {
 
 	runnable.Run(manager.Build())
 }
+
+// backend is the storage of the server.
+type backend interface {
+	gophkeeper.Gophkeeper
+	runnable.Runnable
+	server.Trash
+	server.EnvelopeStore
+	blobDatabase
+}
+
+// storage returns the database the DSN of the configuration points to,
+// a sqlite one for sqlite:// DSNs and a postgres one otherwise.
+func storage(
+	configuration config.Config,
+	tokenSource server.UsernameBasedTokenSource,
+	blobs blobstore.BlobStore,
+	keys server.KeyProvider,
+) (backend, error) {
+	if path, ok := sqlite.Path(configuration.DatabaseDSN); ok {
+		if keys != nil {
+			return nil, errors.New("master keys are not supported by sqlite databases")
+		}
+		if blobs == nil {
+			return nil, errors.New("the postgres blob store requires a postgres database")
+		}
+		database := sqlite.New(
+			path,
+			tokenSource,
+			sqlite.WithBlobStore(blobs),
+			sqlite.WithVersionsLimit((int)(configuration.VersionsLimit)),
+			sqlite.WithPasswordEncoding(base64.RawStdEncoding),
+			sqlite.WithCompression(configuration.BlobCompression),
+			sqlite.WithDeduplication(configuration.BlobStore.Dedup),
+		)
+		return database, nil
+	}
+	if configuration.DatabasePool.Size <= 0 {
+		return nil, errors.New("DATABASE_POOL_SIZE must be positive")
+	}
+	if configuration.DatabasePool.HealthCheckPeriod <= 0 {
+		return nil, errors.New("DATABASE_POOL_HEALTH_CHECK_PERIOD must be positive")
+	}
+	blobsOption := postgres.WithDatabaseBlobStore()
+	if blobs != nil {
+		blobsOption = postgres.WithBlobStore(blobs)
+	}
+	database := postgres.New(
+		postgres.DSNSource(configuration.DatabaseDSN),
+		tokenSource,
+		blobsOption,
+		postgres.WithVersionsLimit((int)(configuration.VersionsLimit)),
+		postgres.WithPasswordEncoding(base64.RawStdEncoding),
+		postgres.WithKeyProvider(keys),
+		postgres.WithRequireSealed(configuration.RequireAtRest),
+		postgres.WithCompression(configuration.BlobCompression),
+		postgres.WithDeduplication(configuration.BlobStore.Dedup),
+		postgres.WithPoolSize(configuration.DatabasePool.Size),
+		postgres.WithHealthCheckPeriod(configuration.DatabasePool.HealthCheckPeriod),
+	)
+	return database, nil
+}
+
+// blobStore returns the storage of the blob files of the configuration,
+// nil if the database keeps them.
+func blobStore(configuration config.Config) (blobstore.BlobStore, error) {
+	switch configuration.BlobStore.Driver {
+	case "fs":
+		dir, dirError := filepath.Abs(configuration.BlobStore.Dir)
+		if dirError != nil {
+			return nil, dirError
+		}
+		return &blobstore.FS{Dir: dir}, nil
+	case "s3":
+		s3 := configuration.BlobStore.S3
+		if s3.Endpoint == "" || s3.Bucket == "" {
+			return nil, errors.New("BLOB_STORE_S3_ENDPOINT and BLOB_STORE_S3_BUCKET are required by the s3 blob store")
+		}
+		store := &blobstore.S3{
+			Endpoint:  s3.Endpoint,
+			Region:    s3.Region,
+			Bucket:    s3.Bucket,
+			Prefix:    s3.Prefix,
+			AccessKey: s3.AccessKey,
+			SecretKey: s3.SecretKey,
+		}
+		return store, nil
+	case "postgres":
+		return nil, nil
+	default:
+		return nil, fmt.Errorf("unknown blob store driver %q", configuration.BlobStore.Driver)
+	}
+}
+
+// keyProvider returns the provider of the master keys
+// of the configuration, nil if there are none.
+func keyProvider(configuration config.Config) (server.KeyProvider, error) {
+	var keys server.KeyProvider
+	switch {
+	case configuration.MasterKeyFile != "" && configuration.MasterKeys != "":
+		return nil, errors.New("MASTER_KEY_FILE and MASTER_KEYS are mutually exclusive")
+	case configuration.MasterKeyFile != "":
+		keys = server.NewFileKeyProvider(configuration.MasterKeyFile)
+	case configuration.MasterKeys != "":
+		keys = server.EnvKeyProvider{Variable: "MASTER_KEYS"}
+	case configuration.RequireAtRest:
+		return nil, errors.New("REQUIRE_AT_REST_ENCRYPTION requires MASTER_KEY_FILE or MASTER_KEYS")
+	default:
+		return nil, nil
+	}
+	if _, _, err := keys.PrimaryKey(context.Background()); err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
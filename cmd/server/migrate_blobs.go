@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/pior/runnable"
+)
+
+// blobDatabase is a database whose blob files can be migrated.
+type blobDatabase interface {
+	runnable.Runnable
+	MigrateBlobs(ctx context.Context, batchSize int) (int, error)
+}
+
+// migrateBlobs moves the blob files to the chunks once BLOB_STORE_DEDUP
+// is enabled, or back from the chunks once it is disabled.
+//
+// The servers must be stopped while the files are moved, an interrupted
+// migration is resumed by running it again.
+func migrateBlobs(database blobDatabase, args []string) error {
+	var (
+		flags     = flag.NewFlagSet("migrate-blobs", flag.ContinueOnError)
+		batchSize = flags.Int("batch", 100, "Number of blob files read at once")
+	)
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	runResult := make(chan error, 1)
+	go func() {
+		runResult <- database.Run(ctx)
+	}()
+
+	var (
+		migrated     int
+		migrateError error
+		done         = make(chan struct{})
+	)
+	go func() {
+		defer close(done)
+		migrated, migrateError = database.MigrateBlobs(ctx, *batchSize)
+	}()
+
+	select {
+	case <-done:
+		cancel()
+		<-runResult
+	case err := <-runResult:
+		cancel()
+		<-done
+		if migrateError == nil || errors.Is(migrateError, context.Canceled) {
+			migrateError = err
+		}
+	}
+
+	log.Printf("moved %d blob files\n", migrated)
+	return migrateError
+}
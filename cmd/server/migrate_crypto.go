@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/kerelape/gophkeeper/internal/server"
+	"github.com/kerelape/gophkeeper/pkg/gophkeeper"
+	"github.com/kerelape/gophkeeper/pkg/gophkeeper/encrypted"
+	"github.com/pior/runnable"
+)
+
+// checkpoint is the last envelope of the last migrated batch.
+type checkpoint struct {
+	Resource int64 `json:"resource"`
+	Version  int   `json:"version"`
+}
+
+// envelopeDatabase is a database whose envelopes can be migrated.
+type envelopeDatabase interface {
+	runnable.Runnable
+	server.EnvelopeStore
+}
+
+// migrateCrypto upgrades the envelopes of every identity's resources
+// to the newest envelope version.
+//
+// The last envelope of every migrated batch is saved to the checkpoint
+// file, an interrupted migration resumes from it and the file is removed
+// once the migration is complete.
+func migrateCrypto(database envelopeDatabase, args []string) error {
+	var (
+		flags          = flag.NewFlagSet("migrate-crypto", flag.ContinueOnError)
+		batchSize      = flags.Int("batch", 100, "Number of resources upgraded in a single transaction")
+		checkpointPath = flags.String("checkpoint", "migrate-crypto.checkpoint", "File the progress is saved to")
+	)
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	after, readError := readCheckpoint(*checkpointPath)
+	if readError != nil {
+		return readError
+	}
+	if after != (server.Envelope{}) {
+		log.Printf("resuming after resource %d version %d\n", after.Resource, after.Version)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	runResult := make(chan error, 1)
+	go func() {
+		runResult <- database.Run(ctx)
+	}()
+
+	var (
+		migrator = server.CryptoMigrator{
+			Store:     database,
+			Upgrade:   encrypted.UpgradeEnvelope,
+			BatchSize: *batchSize,
+			Batch: func(last server.Envelope, failures []server.CryptoMigrationFailure) error {
+				for _, failure := range failures {
+					log.Printf(
+						"failed to upgrade resource %d version %d of %s: %s\n",
+						failure.Envelope.Resource,
+						failure.Envelope.Version,
+						failure.Envelope.Owner,
+						failure.Err.Error(),
+					)
+				}
+				return writeCheckpoint(*checkpointPath, last)
+			},
+		}
+		report       server.CryptoMigrationReport
+		migrateError error
+		migrated     = make(chan struct{})
+	)
+	go func() {
+		defer close(migrated)
+		report, migrateError = migrator.Migrate(ctx, after)
+	}()
+
+	select {
+	case <-migrated:
+		cancel()
+		<-runResult
+	case err := <-runResult:
+		cancel()
+		<-migrated
+		if migrateError == nil || errors.Is(migrateError, context.Canceled) {
+			migrateError = err
+		}
+	}
+
+	log.Printf(
+		"scanned %d, upgraded %d, failed %d resources\n",
+		report.Scanned, report.Upgraded, len(report.Failures),
+	)
+	if migrateError != nil {
+		return migrateError
+	}
+	if err := os.Remove(*checkpointPath); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	if len(report.Failures) > 0 {
+		return fmt.Errorf("failed to upgrade %d resources", len(report.Failures))
+	}
+	return nil
+}
+
+// readCheckpoint returns the envelope saved to the checkpoint file,
+// the zero envelope if there is no file.
+func readCheckpoint(path string) (server.Envelope, error) {
+	content, readError := os.ReadFile(path)
+	if readError != nil {
+		if errors.Is(readError, fs.ErrNotExist) {
+			return server.Envelope{}, nil
+		}
+		return server.Envelope{}, readError
+	}
+	var c checkpoint
+	if err := json.Unmarshal(content, &c); err != nil {
+		return server.Envelope{}, fmt.Errorf("malformed checkpoint %s: %w", path, err)
+	}
+	envelope := server.Envelope{
+		Resource: (gophkeeper.ResourceID)(c.Resource),
+		Version:  c.Version,
+	}
+	return envelope, nil
+}
+
+// writeCheckpoint saves the envelope to the checkpoint file.
+func writeCheckpoint(path string, last server.Envelope) error {
+	content, marshalError := json.Marshal(checkpoint{Resource: (int64)(last.Resource), Version: last.Version})
+	if marshalError != nil {
+		return marshalError
+	}
+	temporary := path + ".tmp"
+	if err := os.WriteFile(temporary, content, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(temporary, path)
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/kerelape/gophkeeper/internal/server/postgres"
+)
+
+// rotateMasterKey re-wraps the stored content with the primary master key.
+//
+// The rotation runs along with the servers, an interrupted
+// rotation is resumed by running it again.
+func rotateMasterKey(database *postgres.Gophkeeper, args []string) error {
+	var (
+		flags     = flag.NewFlagSet("rotate-master-key", flag.ContinueOnError)
+		batchSize = flags.Int("batch", 100, "Number of pieces and blob files read at once")
+	)
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	runResult := make(chan error, 1)
+	go func() {
+		runResult <- database.Run(ctx)
+	}()
+
+	var (
+		rotated     int
+		rotateError error
+		done        = make(chan struct{})
+	)
+	go func() {
+		defer close(done)
+		rotated, rotateError = database.RotateMasterKey(ctx, *batchSize)
+	}()
+
+	select {
+	case <-done:
+		cancel()
+		<-runResult
+	case err := <-runResult:
+		cancel()
+		<-done
+		if rotateError == nil || errors.Is(rotateError, context.Canceled) {
+			rotateError = err
+		}
+	}
+
+	log.Printf("re-wrapped %d pieces and blob files\n", rotated)
+	return rotateError
+}
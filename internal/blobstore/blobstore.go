@@ -0,0 +1,54 @@
+package blobstore
+
+import (
+	"context"
+	"errors"
+	"io"
+	"time"
+)
+
+// ErrNotFound is returned when there is no blob with the key.
+var ErrNotFound = errors.New("blob not found")
+
+type (
+	// Info describes a stored blob.
+	Info struct {
+		Key     string
+		Size    int64
+		ModTime time.Time
+	}
+
+	// BlobStore stores the blob files.
+	//
+	// Keys are opaque to the callers, they are made by NewKey and
+	// the keys of the blobs nested under a key, such as the quarantine,
+	// are made with path.Join.
+	BlobStore interface {
+		// NewKey returns a key no blob is stored with.
+		NewKey() string
+
+		// Put stores the content with the key, the blob stored with the key
+		// before is replaced at once. It returns the size of the content.
+		Put(ctx context.Context, key string, content io.Reader) (int64, error)
+
+		// Get opens length bytes of the blob from the offset,
+		// the rest of the blob if the length is negative.
+		Get(ctx context.Context, key string, offset, length int64) (io.ReadCloser, error)
+
+		// Delete deletes the blob, deleting a blob
+		// that does not exist is not an error.
+		Delete(ctx context.Context, key string) error
+
+		// Stat returns the info of the blob.
+		Stat(ctx context.Context, key string) (Info, error)
+
+		// List returns the blobs stored with the keys made by NewKey,
+		// the nested blobs are skipped.
+		List(ctx context.Context) ([]Info, error)
+	}
+)
+
+// Open opens the whole blob.
+func Open(ctx context.Context, store BlobStore, key string) (io.ReadCloser, error) {
+	return store.Get(ctx, key, 0, -1)
+}
@@ -0,0 +1,476 @@
+package blobstore
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"os"
+	"path"
+)
+
+// DedupChunkSize is the size of the chunks Dedup splits the blobs into.
+const DedupChunkSize = 1024 * 1024
+
+// ChunksDir is the key under which Dedup keeps the chunks,
+// next to the blobs of the store.
+const ChunksDir = "chunks"
+
+// manifestMarker starts the manifests.
+const manifestMarker = "gophkeeper-dedup-manifest\n"
+
+// ErrNotDeduplicated is returned by Dedup when the blob is not a manifest,
+// that is it was stored before the deduplication was enabled, see Split.
+var ErrNotDeduplicated = errors.New("the blob is not deduplicated")
+
+type (
+	// Dedup is a BlobStore that keeps every unique chunk of the blobs once,
+	// so the storage grows with the unique content only.
+	//
+	// The blobs are split into the chunks of DedupChunkSize, the chunks are
+	// stored in the Store keyed by the SHA-256 hash of their content and the
+	// blob itself is stored as the manifest listing them. Deleting a blob
+	// releases its references to the chunks, the chunks nothing references
+	// are deleted by Collect.
+	//
+	// The blobs stored before the deduplication was enabled are not read,
+	// they are moved to the chunks by Split once, and back by Join once
+	// it is disabled.
+	Dedup struct {
+		Store      BlobStore
+		References References
+
+		// AtRest encrypts the chunks and the manifests,
+		// they are stored as they are if it is nil.
+		AtRest Sealer
+	}
+
+	// Sealer encrypts the content Dedup stores.
+	Sealer interface {
+		// Seal returns a writer that writes the content sealed to the
+		// origin, the content is not complete until the writer is closed.
+		Seal(ctx context.Context, origin io.Writer) (io.WriteCloser, error)
+
+		// Open returns a reader of the sealed content of the origin.
+		Open(ctx context.Context, origin io.Reader) (io.Reader, error)
+	}
+
+	// References counts the references to the chunks of Dedup.
+	References interface {
+		// Acquire adds a reference to the chunk.
+		Acquire(ctx context.Context, hash string) error
+
+		// Release removes a reference to each of the chunks,
+		// a chunk listed twice loses two references.
+		Release(ctx context.Context, hashes []string) error
+
+		// Collect calls collect with each chunk nothing references and
+		// forgets the chunks once it returns. A chunk must not be acquired
+		// until it is forgotten, so a chunk acquired again is stored anew.
+		Collect(ctx context.Context, collect func(hash string) error) error
+	}
+
+	// manifest is the content of a blob of Dedup.
+	manifest struct {
+		Size      int64    `json:"size"`
+		ChunkSize int64    `json:"chunk_size"`
+		Chunks    []string `json:"chunks"`
+	}
+)
+
+var _ BlobStore = (*Dedup)(nil)
+
+// NewKey implements BlobStore.
+func (d *Dedup) NewKey() string {
+	return d.Store.NewKey()
+}
+
+// Put implements BlobStore.
+func (d *Dedup) Put(ctx context.Context, key string, content io.Reader) (int64, error) {
+	replaced, replacedError := d.manifest(ctx, key)
+	if replacedError != nil && !errors.Is(replacedError, ErrNotFound) && !errors.Is(replacedError, ErrNotDeduplicated) {
+		return 0, replacedError
+	}
+
+	m, writeError := d.writeChunks(ctx, content)
+	if writeError != nil {
+		return 0, writeError
+	}
+	encoded, encodeError := json.Marshal(m)
+	if encodeError != nil {
+		d.release(m.Chunks)
+		return 0, encodeError
+	}
+	if err := d.put(ctx, key, append(([]byte)(manifestMarker), encoded...)); err != nil {
+		d.release(m.Chunks)
+		return 0, err
+	}
+	d.release(replaced.Chunks)
+	return m.Size, nil
+}
+
+// Get implements BlobStore.
+func (d *Dedup) Get(ctx context.Context, key string, offset, length int64) (io.ReadCloser, error) {
+	m, manifestError := d.manifest(ctx, key)
+	if manifestError != nil {
+		return nil, manifestError
+	}
+	reader := &dedupReader{ctx: ctx, dedup: d}
+	if offset < m.Size && length != 0 {
+		first := offset / m.ChunkSize
+		reader.chunks = m.Chunks[first:]
+		reader.skip = offset - first*m.ChunkSize
+	}
+	if length < 0 {
+		return reader, nil
+	}
+	return struct {
+		io.Reader
+		io.Closer
+	}{io.LimitReader(reader, length), reader}, nil
+}
+
+// Delete implements BlobStore.
+//
+// The chunks of the blob are released, not deleted.
+func (d *Dedup) Delete(ctx context.Context, key string) error {
+	m, manifestError := d.manifest(ctx, key)
+	if errors.Is(manifestError, ErrNotFound) {
+		return nil
+	}
+	if manifestError != nil {
+		return manifestError
+	}
+	if err := d.Store.Delete(ctx, key); err != nil {
+		return err
+	}
+	return d.References.Release(ctx, m.Chunks)
+}
+
+// Stat implements BlobStore.
+func (d *Dedup) Stat(ctx context.Context, key string) (Info, error) {
+	info, statError := d.Store.Stat(ctx, key)
+	if statError != nil {
+		return Info{}, statError
+	}
+	m, manifestError := d.manifest(ctx, key)
+	if manifestError != nil {
+		return Info{}, manifestError
+	}
+	info.Size = m.Size
+	return info, nil
+}
+
+// List implements BlobStore.
+//
+// The sizes are the sizes of the manifests, not of the blobs.
+func (d *Dedup) List(ctx context.Context) ([]Info, error) {
+	return d.Store.List(ctx)
+}
+
+// Collect deletes the chunks nothing references.
+func (d *Dedup) Collect(ctx context.Context) error {
+	return d.References.Collect(ctx, func(hash string) error {
+		return d.Store.Delete(ctx, d.chunkKey(hash))
+	})
+}
+
+// Keys returns the keys of the Store the blob is kept under,
+// the key of the manifest followed by the keys of its chunks.
+func (d *Dedup) Keys(ctx context.Context, key string) ([]string, error) {
+	m, manifestError := d.manifest(ctx, key)
+	if manifestError != nil {
+		return nil, manifestError
+	}
+	var (
+		keys = []string{key}
+		seen = make(map[string]bool)
+	)
+	for _, hash := range m.Chunks {
+		if !seen[hash] {
+			seen[hash] = true
+			keys = append(keys, d.chunkKey(hash))
+		}
+	}
+	return keys, nil
+}
+
+// Split moves the blob stored before the deduplication was enabled
+// to the chunks, the blob is opened with AtRest. It returns false
+// if the blob is deduplicated already.
+func (d *Dedup) Split(ctx context.Context, key string) (bool, error) {
+	_, manifestError := d.manifest(ctx, key)
+	if manifestError == nil {
+		return false, nil
+	}
+	if !errors.Is(manifestError, ErrNotDeduplicated) {
+		return false, manifestError
+	}
+
+	content, contentError := d.open(ctx, key)
+	if contentError != nil {
+		return false, contentError
+	}
+	defer content.Close()
+	temporary, temporaryError := os.CreateTemp("", "split-*")
+	if temporaryError != nil {
+		return false, temporaryError
+	}
+	defer os.Remove(temporary.Name())
+	defer temporary.Close()
+	if _, err := io.Copy(temporary, content); err != nil {
+		return false, err
+	}
+	if _, err := temporary.Seek(0, io.SeekStart); err != nil {
+		return false, err
+	}
+
+	if _, err := d.Put(ctx, key, temporary); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// Join moves the chunks of the blob back to the blob sealed with AtRest
+// when the deduplication is disabled and releases them, the chunks are
+// deleted by Collect. It returns false if the blob is not deduplicated.
+func (d *Dedup) Join(ctx context.Context, key string) (bool, error) {
+	m, manifestError := d.manifest(ctx, key)
+	if errors.Is(manifestError, ErrNotDeduplicated) {
+		return false, nil
+	}
+	if manifestError != nil {
+		return false, manifestError
+	}
+
+	content, contentError := d.Get(ctx, key, 0, -1)
+	if contentError != nil {
+		return false, contentError
+	}
+	defer content.Close()
+	temporary, temporaryError := os.CreateTemp("", "join-*")
+	if temporaryError != nil {
+		return false, temporaryError
+	}
+	defer os.Remove(temporary.Name())
+	defer temporary.Close()
+	sealer, sealError := d.seal(ctx, temporary)
+	if sealError != nil {
+		return false, sealError
+	}
+	if _, err := io.Copy(sealer, content); err != nil {
+		return false, err
+	}
+	if err := sealer.Close(); err != nil {
+		return false, err
+	}
+	if _, err := temporary.Seek(0, io.SeekStart); err != nil {
+		return false, err
+	}
+
+	if _, err := d.Store.Put(ctx, key, temporary); err != nil {
+		return false, err
+	}
+	return true, d.References.Release(ctx, m.Chunks)
+}
+
+// writeChunks stores the chunks of the content that are not stored yet,
+// acquires all of them and returns the manifest of the content.
+func (d *Dedup) writeChunks(ctx context.Context, content io.Reader) (manifest, error) {
+	var (
+		m      = manifest{ChunkSize: DedupChunkSize, Chunks: make([]string, 0)}
+		buffer = make([]byte, DedupChunkSize)
+	)
+	for {
+		read, readError := io.ReadFull(content, buffer)
+		if read > 0 {
+			if err := d.writeChunk(ctx, buffer[:read], &m); err != nil {
+				d.release(m.Chunks)
+				return manifest{}, err
+			}
+		}
+		switch {
+		case errors.Is(readError, io.EOF), errors.Is(readError, io.ErrUnexpectedEOF):
+			return m, nil
+		case readError != nil:
+			d.release(m.Chunks)
+			return manifest{}, readError
+		}
+	}
+}
+
+// writeChunk acquires the chunk, stores it if it is not stored
+// yet and appends it to the manifest.
+func (d *Dedup) writeChunk(ctx context.Context, chunk []byte, m *manifest) error {
+	sum := sha256.Sum256(chunk)
+	hash := hex.EncodeToString(sum[:])
+	if err := d.References.Acquire(ctx, hash); err != nil {
+		return err
+	}
+	m.Chunks = append(m.Chunks, hash)
+	m.Size += (int64)(len(chunk))
+
+	key := d.chunkKey(hash)
+	_, statError := d.Store.Stat(ctx, key)
+	if statError == nil {
+		return nil
+	}
+	if !errors.Is(statError, ErrNotFound) {
+		return statError
+	}
+	return d.put(ctx, key, chunk)
+}
+
+// put stores the content sealed with AtRest.
+func (d *Dedup) put(ctx context.Context, key string, content []byte) error {
+	var sealed bytes.Buffer
+	sealer, sealError := d.seal(ctx, &sealed)
+	if sealError != nil {
+		return sealError
+	}
+	if _, err := sealer.Write(content); err != nil {
+		return err
+	}
+	if err := sealer.Close(); err != nil {
+		return err
+	}
+	_, putError := d.Store.Put(ctx, key, &sealed)
+	return putError
+}
+
+// seal returns a writer that seals the content with AtRest.
+func (d *Dedup) seal(ctx context.Context, origin io.Writer) (io.WriteCloser, error) {
+	if d.AtRest == nil {
+		return nopWriteCloser{origin}, nil
+	}
+	return d.AtRest.Seal(ctx, origin)
+}
+
+// open opens the content of the key sealed with AtRest.
+func (d *Dedup) open(ctx context.Context, key string) (io.ReadCloser, error) {
+	file, fileError := Open(ctx, d.Store, key)
+	if fileError != nil || d.AtRest == nil {
+		return file, fileError
+	}
+	content, contentError := d.AtRest.Open(ctx, file)
+	if contentError != nil {
+		file.Close()
+		return nil, contentError
+	}
+	opened := struct {
+		io.Reader
+		io.Closer
+	}{content, file}
+	return opened, nil
+}
+
+// manifest reads the manifest of the blob,
+// ErrNotDeduplicated if the blob is not one.
+func (d *Dedup) manifest(ctx context.Context, key string) (manifest, error) {
+	content, contentError := d.open(ctx, key)
+	if contentError != nil {
+		return manifest{}, contentError
+	}
+	defer content.Close()
+	marker := make([]byte, len(manifestMarker))
+	_, markerError := io.ReadFull(content, marker)
+	if errors.Is(markerError, io.EOF) || errors.Is(markerError, io.ErrUnexpectedEOF) || (string)(marker) != manifestMarker {
+		return manifest{}, ErrNotDeduplicated
+	}
+	if markerError != nil {
+		return manifest{}, markerError
+	}
+	var m manifest
+	if err := json.NewDecoder(content).Decode(&m); err != nil {
+		return manifest{}, err
+	}
+	if m.ChunkSize <= 0 {
+		return manifest{}, errors.New("malformed manifest")
+	}
+	return m, nil
+}
+
+// release releases the chunks even if the request is canceled,
+// otherwise they are kept until the references are released.
+func (d *Dedup) release(hashes []string) {
+	if len(hashes) == 0 {
+		return
+	}
+	if err := d.References.Release(context.Background(), hashes); err != nil {
+		log.Printf("failed to release the chunks: %s\n", err.Error())
+	}
+}
+
+// chunkKey returns the key of the chunk.
+func (d *Dedup) chunkKey(hash string) string {
+	return path.Join(path.Dir(d.Store.NewKey()), ChunksDir, hash)
+}
+
+// dedupReader reads the chunks one by one.
+type dedupReader struct {
+	ctx   context.Context
+	dedup *Dedup
+
+	// chunks are the chunks left to read, skip is
+	// how many bytes of the first of them are skipped.
+	chunks []string
+	skip   int64
+
+	current io.ReadCloser
+}
+
+// Read implements io.Reader.
+func (r *dedupReader) Read(p []byte) (int, error) {
+	for {
+		if r.current == nil {
+			if len(r.chunks) == 0 {
+				return 0, io.EOF
+			}
+			chunk, chunkError := r.dedup.open(r.ctx, r.dedup.chunkKey(r.chunks[0]))
+			if chunkError != nil {
+				return 0, chunkError
+			}
+			if _, err := io.CopyN(io.Discard, chunk, r.skip); err != nil {
+				chunk.Close()
+				return 0, err
+			}
+			r.current, r.chunks, r.skip = chunk, r.chunks[1:], 0
+		}
+		n, readError := r.current.Read(p)
+		if errors.Is(readError, io.EOF) {
+			if err := r.current.Close(); err != nil {
+				return n, err
+			}
+			r.current = nil
+			readError = nil
+		}
+		if n > 0 || readError != nil {
+			return n, readError
+		}
+	}
+}
+
+// Close implements io.Closer.
+func (r *dedupReader) Close() error {
+	r.chunks = nil
+	if r.current == nil {
+		return nil
+	}
+	closeError := r.current.Close()
+	r.current = nil
+	return closeError
+}
+
+// nopWriteCloser is a writer with the Close that does nothing.
+type nopWriteCloser struct {
+	io.Writer
+}
+
+// Close implements io.Closer.
+func (nopWriteCloser) Close() error {
+	return nil
+}
@@ -0,0 +1,216 @@
+package blobstore
+
+import (
+	"context"
+	"io"
+	"path"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDedup(t *testing.T) {
+	testBlobStore(t, newDedup(t))
+
+	t.Run("Chunks", func(t *testing.T) {
+		var (
+			ctx   = context.Background()
+			store = newDedup(t)
+			blobs = store.Store.(*FS)
+			key   = store.NewKey()
+			other = store.NewKey()
+
+			content = strings.Repeat("a", DedupChunkSize) + strings.Repeat("b", DedupChunkSize) + "tail"
+		)
+		size, putError := store.Put(ctx, key, strings.NewReader(content))
+		assert.Nil(t, putError, "expected to put the blob")
+		assert.Equal(t, (int64)(len(content)), size)
+		_, putError = store.Put(ctx, other, strings.NewReader(content))
+		assert.Nil(t, putError, "expected to put the same blob again")
+
+		chunks, chunksError := (&FS{Dir: path.Join(blobs.Dir, ChunksDir)}).List(ctx)
+		assert.Nil(t, chunksError, "expected to list the chunks")
+		assert.Len(t, chunks, 3, "expected the chunks to be stored once")
+
+		assert.Equal(t, content, get(t, store, key, 0, -1))
+		assert.Equal(t, "abbbb", get(t, store, key, DedupChunkSize-1, 5))
+		assert.Equal(t, "btail", get(t, store, other, 2*DedupChunkSize-1, -1))
+
+		info, statError := store.Stat(ctx, other)
+		assert.Nil(t, statError, "expected to stat the blob")
+		assert.Equal(t, (int64)(len(content)), info.Size)
+
+		assert.Nil(t, store.Delete(ctx, key), "expected to delete the blob")
+		assert.Nil(t, store.Collect(ctx), "expected to collect the chunks")
+		assert.Equal(t, content, get(t, store, other, 0, -1), "expected the chunks to be kept while referenced")
+
+		assert.Nil(t, store.Delete(ctx, other), "expected to delete the blob")
+		assert.Nil(t, store.Collect(ctx), "expected to collect the chunks")
+		chunks, chunksError = (&FS{Dir: path.Join(blobs.Dir, ChunksDir)}).List(ctx)
+		assert.Nil(t, chunksError, "expected to list the chunks")
+		assert.Empty(t, chunks, "expected the chunks nothing references to be deleted")
+	})
+
+	t.Run("Sealed", func(t *testing.T) {
+		var (
+			ctx   = context.Background()
+			store = newDedup(t)
+			blobs = store.Store.(*FS)
+			key   = store.NewKey()
+
+			content = strings.Repeat("a", DedupChunkSize) + "tail"
+		)
+		store.AtRest = xorSealer{}
+		_, putError := store.Put(ctx, key, strings.NewReader(content))
+		assert.Nil(t, putError, "expected to put the blob")
+		assert.Equal(t, "atai", get(t, store, key, DedupChunkSize-1, 4))
+
+		keys, keysError := store.Keys(ctx, key)
+		assert.Nil(t, keysError, "expected to list the keys of the blob")
+		assert.Len(t, keys, 3, "expected the manifest and two chunks")
+		for _, stored := range keys {
+			raw := get(t, blobs, stored, 0, -1)
+			assert.True(t, strings.HasPrefix(raw, xorSealerMarker), "expected %s to be sealed", stored)
+			assert.NotContains(t, raw, "tail", "expected %s to be sealed", stored)
+		}
+	})
+
+	t.Run("Split and join", func(t *testing.T) {
+		var (
+			ctx   = context.Background()
+			store = newDedup(t)
+			blobs = store.Store.(*FS)
+			key   = store.NewKey()
+
+			content = strings.Repeat("a", DedupChunkSize) + "tail"
+		)
+		store.AtRest = xorSealer{}
+		_, putError := blobs.Put(ctx, key, strings.NewReader(xorSealerMarker+xor(content)))
+		assert.Nil(t, putError, "expected to put the blob")
+		_, getError := store.Get(ctx, key, 0, -1)
+		assert.ErrorIs(t, getError, ErrNotDeduplicated, "expected the blob to be split first")
+		joined, joinError := store.Join(ctx, key)
+		assert.Nil(t, joinError, "did not expect an error")
+		assert.False(t, joined, "expected the blob not to be joined")
+
+		split, splitError := store.Split(ctx, key)
+		assert.Nil(t, splitError, "expected to split the blob")
+		assert.True(t, split, "expected the blob to be split")
+		assert.Equal(t, content, get(t, store, key, 0, -1))
+		split, splitError = store.Split(ctx, key)
+		assert.Nil(t, splitError, "did not expect an error")
+		assert.False(t, split, "expected the blob to be split once")
+
+		joined, joinError = store.Join(ctx, key)
+		assert.Nil(t, joinError, "expected to join the blob")
+		assert.True(t, joined, "expected the blob to be joined")
+		assert.Equal(t, xorSealerMarker+xor(content), get(t, blobs, key, 0, -1), "expected the blob to be sealed whole")
+		assert.Nil(t, store.Collect(ctx), "expected to collect the chunks")
+		chunks, chunksError := (&FS{Dir: path.Join(blobs.Dir, ChunksDir)}).List(ctx)
+		assert.Nil(t, chunksError, "expected to list the chunks")
+		assert.Empty(t, chunks, "expected the chunks of the joined blob to be deleted")
+	})
+}
+
+// newDedup returns a new Dedup storing the
+// chunks in a temporary directory of the test.
+func newDedup(t *testing.T) *Dedup {
+	return &Dedup{
+		Store:      &FS{Dir: path.Join(t.TempDir(), "blobs")},
+		References: &countedReferences{counts: make(map[string]int)},
+	}
+}
+
+// countedReferences are the References counted in memory.
+type countedReferences struct {
+	mutex  sync.Mutex
+	counts map[string]int
+}
+
+// Acquire implements References.
+func (r *countedReferences) Acquire(_ context.Context, hash string) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.counts[hash]++
+	return nil
+}
+
+// Release implements References.
+func (r *countedReferences) Release(_ context.Context, hashes []string) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	for _, hash := range hashes {
+		r.counts[hash]--
+	}
+	return nil
+}
+
+// Collect implements References.
+func (r *countedReferences) Collect(_ context.Context, collect func(hash string) error) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	for hash, count := range r.counts {
+		if count > 0 {
+			continue
+		}
+		if err := collect(hash); err != nil {
+			return err
+		}
+		delete(r.counts, hash)
+	}
+	return nil
+}
+
+// xorSealerMarker starts the content sealed by xorSealer.
+const xorSealerMarker = "xor:"
+
+// xorSealer seals the content by flipping its bits,
+// the content that is not sealed is opened as is.
+type xorSealer struct{}
+
+// Seal implements Sealer.
+func (xorSealer) Seal(_ context.Context, origin io.Writer) (io.WriteCloser, error) {
+	if _, err := io.WriteString(origin, xorSealerMarker); err != nil {
+		return nil, err
+	}
+	return xorWriter{origin}, nil
+}
+
+// Open implements Sealer.
+func (xorSealer) Open(_ context.Context, origin io.Reader) (io.Reader, error) {
+	content, readError := io.ReadAll(origin)
+	if readError != nil {
+		return nil, readError
+	}
+	sealed, ok := strings.CutPrefix((string)(content), xorSealerMarker)
+	if !ok {
+		return strings.NewReader((string)(content)), nil
+	}
+	return strings.NewReader(xor(sealed)), nil
+}
+
+// xorWriter flips the bits of the content it writes.
+type xorWriter struct {
+	io.Writer
+}
+
+// Write implements io.Writer.
+func (w xorWriter) Write(p []byte) (int, error) {
+	return io.WriteString(w.Writer, xor((string)(p)))
+}
+
+// Close implements io.Closer.
+func (xorWriter) Close() error {
+	return nil
+}
+
+// xor flips the bits of the content.
+func xor(content string) string {
+	flipped := []byte(content)
+	for n := range flipped {
+		flipped[n] ^= 0xff
+	}
+	return (string)(flipped)
+}
@@ -0,0 +1,3 @@
+// Package blobstore provides storages of the blob files,
+// either the local filesystem or an S3-compatible object storage.
+package blobstore
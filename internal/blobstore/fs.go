@@ -0,0 +1,142 @@
+package blobstore
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+// FS is a BlobStore that keeps the blobs as files of the directory.
+//
+// The keys are the paths of the files, so the locations
+// stored before there were blob stores are the keys too.
+type FS struct {
+	Dir string
+}
+
+// temporaryPrefix is the prefix of the files the blobs are being
+// written into, they are not blobs yet and are not listed.
+const temporaryPrefix = ".put-"
+
+var _ BlobStore = (*FS)(nil)
+
+// NewKey implements BlobStore.
+func (s *FS) NewKey() string {
+	return path.Join(s.Dir, uuid.New().String())
+}
+
+// Put implements BlobStore.
+//
+// The content is written into a temporary file next to
+// the blob first and then the file is renamed to the key.
+func (s *FS) Put(_ context.Context, key string, content io.Reader) (int64, error) {
+	if err := os.MkdirAll(path.Dir(key), fs.ModePerm); err != nil {
+		return 0, err
+	}
+	temporary, temporaryError := os.CreateTemp(path.Dir(key), temporaryPrefix+path.Base(key)+".*")
+	if temporaryError != nil {
+		return 0, temporaryError
+	}
+	size, writeError := io.Copy(temporary, content)
+	if writeError == nil {
+		writeError = temporary.Sync()
+	}
+	if err := temporary.Close(); err != nil && writeError == nil {
+		writeError = err
+	}
+	if writeError == nil {
+		writeError = os.Rename(temporary.Name(), key)
+	}
+	if writeError != nil {
+		os.Remove(temporary.Name())
+		return 0, writeError
+	}
+	return size, nil
+}
+
+// Get implements BlobStore.
+func (s *FS) Get(_ context.Context, key string, offset, length int64) (io.ReadCloser, error) {
+	file, fileError := os.Open(key)
+	if fileError != nil {
+		if errors.Is(fileError, fs.ErrNotExist) {
+			return nil, errors.Join(ErrNotFound, fileError)
+		}
+		return nil, fileError
+	}
+	if offset > 0 {
+		if _, err := file.Seek(offset, io.SeekStart); err != nil {
+			file.Close()
+			return nil, err
+		}
+	}
+	if length < 0 {
+		return file, nil
+	}
+	content := struct {
+		io.Reader
+		io.Closer
+	}{io.LimitReader(file, length), file}
+	return content, nil
+}
+
+// Delete implements BlobStore.
+func (s *FS) Delete(_ context.Context, key string) error {
+	if err := os.Remove(key); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// Stat implements BlobStore.
+func (s *FS) Stat(_ context.Context, key string) (Info, error) {
+	info, statError := os.Stat(key)
+	if statError != nil {
+		if errors.Is(statError, fs.ErrNotExist) {
+			return Info{}, errors.Join(ErrNotFound, statError)
+		}
+		return Info{}, statError
+	}
+	if !info.Mode().IsRegular() {
+		return Info{}, ErrNotFound
+	}
+	return Info{Key: key, Size: info.Size(), ModTime: info.ModTime()}, nil
+}
+
+// List implements BlobStore.
+//
+// The regular files of the directory are listed, the subdirectories
+// such as the quarantine and the blobs being put are skipped.
+func (s *FS) List(_ context.Context) ([]Info, error) {
+	entries, readError := os.ReadDir(s.Dir)
+	if readError != nil {
+		if errors.Is(readError, fs.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, readError
+	}
+	blobs := make([]Info, 0, len(entries))
+	for _, entry := range entries {
+		if !entry.Type().IsRegular() || strings.HasPrefix(entry.Name(), temporaryPrefix) {
+			continue
+		}
+		info, infoError := entry.Info()
+		if infoError != nil {
+			if errors.Is(infoError, fs.ErrNotExist) {
+				continue
+			}
+			return nil, infoError
+		}
+		blobs = append(blobs, Info{
+			Key:     path.Join(s.Dir, entry.Name()),
+			Size:    info.Size(),
+			ModTime: info.ModTime(),
+		})
+	}
+	return blobs, nil
+}
@@ -0,0 +1,145 @@
+package blobstore
+
+import (
+	"context"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFS(t *testing.T) {
+	store := &FS{Dir: path.Join(t.TempDir(), "blobs")}
+	testBlobStore(t, store)
+
+	key := store.NewKey()
+	assert.Equal(t, store.Dir, path.Dir(key), "expected the keys to be the paths of the files of the directory")
+}
+
+func TestFSListPutting(t *testing.T) {
+	var (
+		ctx     = context.Background()
+		store   = &FS{Dir: t.TempDir()}
+		reader  = &blockingReader{started: make(chan struct{}), release: make(chan struct{})}
+		key     = store.NewKey()
+		putDone = make(chan error)
+	)
+	go func() {
+		_, err := store.Put(ctx, key, reader)
+		putDone <- err
+	}()
+	<-reader.started
+
+	entries, readError := os.ReadDir(store.Dir)
+	assert.Nil(t, readError, "expected to read the directory")
+	assert.Equal(t, 1, len(entries), "expected the blob to be written into a temporary file")
+	blobs, listError := store.List(ctx)
+	assert.Nil(t, listError, "expected to list the blobs")
+	assert.Empty(t, blobs, "expected the blob being put to be skipped")
+
+	close(reader.release)
+	assert.Nil(t, <-putDone, "expected to put the blob")
+	blobs, listError = store.List(ctx)
+	assert.Nil(t, listError, "expected to list the blobs")
+	if assert.Equal(t, 1, len(blobs), "expected the blob to be listed once it is put") {
+		assert.Equal(t, key, blobs[0].Key)
+	}
+}
+
+// blockingReader reads "content" once it is released.
+type blockingReader struct {
+	started chan struct{}
+	release chan struct{}
+	done    bool
+}
+
+// Read implements io.Reader.
+func (r *blockingReader) Read(p []byte) (int, error) {
+	if r.done {
+		return 0, io.EOF
+	}
+	close(r.started)
+	<-r.release
+	r.done = true
+	return copy(p, "content"), nil
+}
+
+// testBlobStore checks that the store behaves as a BlobStore.
+func testBlobStore(t *testing.T, store BlobStore) {
+	ctx := context.Background()
+
+	blobs, listError := store.List(ctx)
+	assert.Nil(t, listError, "expected to list an empty store")
+	assert.Empty(t, blobs)
+
+	var (
+		key   = store.NewKey()
+		other = store.NewKey()
+	)
+	assert.NotEqual(t, key, other, "expected the keys to be unique")
+
+	_, missingError := store.Get(ctx, key, 0, -1)
+	assert.ErrorIs(t, missingError, ErrNotFound, "unexpected error on getting a missing blob")
+	_, missingError = store.Stat(ctx, key)
+	assert.ErrorIs(t, missingError, ErrNotFound, "unexpected error on stating a missing blob")
+	assert.Nil(t, store.Delete(ctx, key), "expected to delete a missing blob")
+
+	size, putError := store.Put(ctx, key, strings.NewReader("0123456789"))
+	assert.Nil(t, putError, "expected to put the blob")
+	assert.Equal(t, (int64)(10), size)
+	_, putError = store.Put(ctx, other, strings.NewReader(""))
+	assert.Nil(t, putError, "expected to put an empty blob")
+
+	assert.Equal(t, "0123456789", get(t, store, key, 0, -1))
+	assert.Equal(t, "3456", get(t, store, key, 3, 4))
+	assert.Equal(t, "789", get(t, store, key, 7, -1))
+	assert.Equal(t, "", get(t, store, key, 2, 0))
+	assert.Equal(t, "", get(t, store, other, 0, -1))
+
+	info, statError := store.Stat(ctx, key)
+	assert.Nil(t, statError, "expected to stat the blob")
+	assert.Equal(t, key, info.Key)
+	assert.Equal(t, (int64)(10), info.Size)
+	assert.False(t, info.ModTime.IsZero(), "expected the modification time to be set")
+
+	_, putError = store.Put(ctx, key, strings.NewReader("replaced"))
+	assert.Nil(t, putError, "expected to replace the blob")
+	assert.Equal(t, "replaced", get(t, store, key, 0, -1))
+
+	nested := path.Join(path.Dir(key), "quarantine", path.Base(key))
+	_, putError = store.Put(ctx, nested, strings.NewReader("nested"))
+	assert.Nil(t, putError, "expected to put a nested blob")
+	assert.Equal(t, "nested", get(t, store, nested, 0, -1))
+
+	blobs, listError = store.List(ctx)
+	assert.Nil(t, listError, "expected to list the blobs")
+	keys := make([]string, 0, len(blobs))
+	for _, blob := range blobs {
+		keys = append(keys, blob.Key)
+	}
+	expected := []string{key, other}
+	sort.Strings(expected)
+	sort.Strings(keys)
+	assert.Equal(t, expected, keys, "expected the nested blobs to be skipped")
+
+	assert.Nil(t, store.Delete(ctx, key), "expected to delete the blob")
+	_, missingError = store.Stat(ctx, key)
+	assert.ErrorIs(t, missingError, ErrNotFound, "expected the blob to be deleted")
+}
+
+// get reads the range of the blob.
+func get(t *testing.T, store BlobStore, key string, offset, length int64) string {
+	t.Helper()
+	content, getError := store.Get(context.Background(), key, offset, length)
+	if !assert.Nil(t, getError, "expected to get the blob") {
+		return ""
+	}
+	defer content.Close()
+	read, readError := io.ReadAll(content)
+	assert.Nil(t, readError, "expected to read the blob")
+	return (string)(read)
+}
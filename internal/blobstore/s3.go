@@ -0,0 +1,279 @@
+package blobstore
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// S3 is a BlobStore that keeps the blobs as objects
+// of a bucket of an S3-compatible storage, such as MinIO.
+//
+// The objects are addressed in the path style,
+// the keys are the object keys.
+type S3 struct {
+	// Endpoint is the URL of the storage, e.g. http://localhost:9000.
+	Endpoint string
+
+	Region string
+	Bucket string
+
+	// Prefix is prepended to the keys, so the bucket can be shared.
+	Prefix string
+
+	AccessKey string
+	SecretKey string
+
+	// Client sends the requests, http.DefaultClient if nil.
+	Client *http.Client
+}
+
+var _ BlobStore = (*S3)(nil)
+
+// s3Error is an error response of the storage.
+type s3Error struct {
+	Status  int    `xml:"-"`
+	Code    string `xml:"Code"`
+	Message string `xml:"Message"`
+}
+
+// Error implements error.
+func (e *s3Error) Error() string {
+	if e.Code == "" {
+		return fmt.Sprintf("s3: %s", http.StatusText(e.Status))
+	}
+	return fmt.Sprintf("s3: %s: %s", e.Code, e.Message)
+}
+
+// listBucketResult is the response of ListObjectsV2.
+type listBucketResult struct {
+	Contents []struct {
+		Key          string    `xml:"Key"`
+		Size         int64     `xml:"Size"`
+		LastModified time.Time `xml:"LastModified"`
+	} `xml:"Contents"`
+	IsTruncated           bool   `xml:"IsTruncated"`
+	NextContinuationToken string `xml:"NextContinuationToken"`
+}
+
+// NewKey implements BlobStore.
+func (s *S3) NewKey() string {
+	return path.Join(s.Prefix, uuid.New().String())
+}
+
+// Put implements BlobStore.
+//
+// A request must tell the size and the hash of the content it carries,
+// so the content is written into a temporary file first.
+func (s *S3) Put(ctx context.Context, key string, content io.Reader) (int64, error) {
+	temporary, temporaryError := os.CreateTemp("", "blob-*")
+	if temporaryError != nil {
+		return 0, temporaryError
+	}
+	defer os.Remove(temporary.Name())
+	defer temporary.Close()
+
+	hash := sha256.New()
+	size, writeError := io.Copy(temporary, io.TeeReader(content, hash))
+	if writeError != nil {
+		return 0, writeError
+	}
+	if _, err := temporary.Seek(0, io.SeekStart); err != nil {
+		return 0, err
+	}
+
+	request, requestError := s.request(ctx, http.MethodPut, key, "", temporary)
+	if requestError != nil {
+		return 0, requestError
+	}
+	request.ContentLength = size
+	if size == 0 {
+		request.Body = http.NoBody
+	}
+	response, responseError := s.do(request, hex.EncodeToString(hash.Sum(nil)))
+	if responseError != nil {
+		return 0, responseError
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return 0, readS3Error(response)
+	}
+	return size, nil
+}
+
+// Get implements BlobStore.
+func (s *S3) Get(ctx context.Context, key string, offset, length int64) (io.ReadCloser, error) {
+	if length == 0 {
+		return io.NopCloser(strings.NewReader("")), nil
+	}
+	request, requestError := s.request(ctx, http.MethodGet, key, "", nil)
+	if requestError != nil {
+		return nil, requestError
+	}
+	switch {
+	case length > 0:
+		request.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", offset, offset+length-1))
+	case offset > 0:
+		request.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	}
+	response, responseError := s.do(request, emptyPayloadHash)
+	if responseError != nil {
+		return nil, responseError
+	}
+	switch response.StatusCode {
+	case http.StatusOK, http.StatusPartialContent:
+		return response.Body, nil
+	case http.StatusRequestedRangeNotSatisfiable:
+		// The offset is beyond the end of the blob.
+		response.Body.Close()
+		return io.NopCloser(strings.NewReader("")), nil
+	default:
+		defer response.Body.Close()
+		return nil, readS3Error(response)
+	}
+}
+
+// Delete implements BlobStore.
+func (s *S3) Delete(ctx context.Context, key string) error {
+	request, requestError := s.request(ctx, http.MethodDelete, key, "", nil)
+	if requestError != nil {
+		return requestError
+	}
+	response, responseError := s.do(request, emptyPayloadHash)
+	if responseError != nil {
+		return responseError
+	}
+	defer response.Body.Close()
+	switch response.StatusCode {
+	case http.StatusOK, http.StatusNoContent, http.StatusNotFound:
+		return nil
+	default:
+		return readS3Error(response)
+	}
+}
+
+// Stat implements BlobStore.
+func (s *S3) Stat(ctx context.Context, key string) (Info, error) {
+	request, requestError := s.request(ctx, http.MethodHead, key, "", nil)
+	if requestError != nil {
+		return Info{}, requestError
+	}
+	response, responseError := s.do(request, emptyPayloadHash)
+	if responseError != nil {
+		return Info{}, responseError
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return Info{}, readS3Error(response)
+	}
+
+	size, sizeError := strconv.ParseInt(response.Header.Get("Content-Length"), 10, 64)
+	if sizeError != nil {
+		return Info{}, sizeError
+	}
+	modTime, modTimeError := http.ParseTime(response.Header.Get("Last-Modified"))
+	if modTimeError != nil {
+		return Info{}, modTimeError
+	}
+	return Info{Key: key, Size: size, ModTime: modTime}, nil
+}
+
+// List implements BlobStore.
+func (s *S3) List(ctx context.Context) ([]Info, error) {
+	prefix := s.Prefix
+	if prefix != "" && !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+	var (
+		blobs = make([]Info, 0)
+		token = ""
+	)
+	for {
+		query := "delimiter=%2F&list-type=2&prefix=" + escape(prefix, true)
+		if token != "" {
+			query = "continuation-token=" + escape(token, true) + "&" + query
+		}
+		request, requestError := s.request(ctx, http.MethodGet, "", query, nil)
+		if requestError != nil {
+			return nil, requestError
+		}
+		response, responseError := s.do(request, emptyPayloadHash)
+		if responseError != nil {
+			return nil, responseError
+		}
+		if response.StatusCode != http.StatusOK {
+			listError := readS3Error(response)
+			response.Body.Close()
+			return nil, listError
+		}
+		var result listBucketResult
+		decodeError := xml.NewDecoder(response.Body).Decode(&result)
+		response.Body.Close()
+		if decodeError != nil {
+			return nil, decodeError
+		}
+		for _, object := range result.Contents {
+			blobs = append(blobs, Info{Key: object.Key, Size: object.Size, ModTime: object.LastModified})
+		}
+		if !result.IsTruncated {
+			return blobs, nil
+		}
+		token = result.NextContinuationToken
+	}
+}
+
+// request returns a new request to the object of the bucket with the key,
+// to the bucket itself if the key is empty. The query must be canonical,
+// the parameters sorted and escaped.
+func (s *S3) request(ctx context.Context, method, key, query string, body io.Reader) (*http.Request, error) {
+	target := strings.TrimSuffix(s.Endpoint, "/") + "/" + escape(s.Bucket, false)
+	if key != "" {
+		target += "/" + escape(key, false)
+	}
+	if query != "" {
+		target += "?" + query
+	}
+	return http.NewRequestWithContext(ctx, method, target, body)
+}
+
+// do signs the request carrying the content with the hash and sends it.
+func (s *S3) do(request *http.Request, payloadHash string) (*http.Response, error) {
+	region := s.Region
+	if region == "" {
+		region = defaultRegion
+	}
+	signer := signer{accessKey: s.AccessKey, secretKey: s.SecretKey, region: region}
+	signer.sign(request, payloadHash, time.Now())
+
+	client := s.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return client.Do(request)
+}
+
+// readS3Error reads the error of the response,
+// ErrNotFound if there is no such object.
+func readS3Error(response *http.Response) error {
+	if response.StatusCode == http.StatusNotFound {
+		return ErrNotFound
+	}
+	e := &s3Error{Status: response.StatusCode}
+	content, readError := io.ReadAll(io.LimitReader(response.Body, 64*1024))
+	if readError != nil || xml.Unmarshal(content, e) != nil {
+		// The error is told by the status alone.
+		e.Code, e.Message = "", ""
+	}
+	return e
+}
@@ -0,0 +1,158 @@
+package blobstore
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	signAlgorithm = "AWS4-HMAC-SHA256"
+	amzDateFormat = "20060102T150405Z"
+	defaultRegion = "us-east-1"
+	s3Service     = "s3"
+)
+
+// emptyPayloadHash is the hash of the requests that carry no content.
+const emptyPayloadHash = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+// signer signs the requests with AWS Signature Version 4.
+type signer struct {
+	accessKey string
+	secretKey string
+	region    string
+}
+
+// sign signs the request carrying the content with the hash at the time.
+// The host, the range and the x-amz-* headers are signed.
+func (s signer) sign(request *http.Request, payloadHash string, now time.Time) {
+	amzDate := now.UTC().Format(amzDateFormat)
+	request.Header.Set("X-Amz-Date", amzDate)
+	request.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	signedHeaders := signedHeaders(request.Header)
+	signature := s.signature(
+		amzDate,
+		canonicalRequest(request.Method, request.URL, request.URL.Host, request.Header, signedHeaders, payloadHash),
+	)
+	request.Header.Set(
+		"Authorization",
+		fmt.Sprintf(
+			"%s Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+			signAlgorithm, s.accessKey, s.scope(amzDate), strings.Join(signedHeaders, ";"), signature,
+		),
+	)
+}
+
+// signature returns the signature of the canonical request made at the date.
+func (s signer) signature(amzDate, canonicalRequest string) string {
+	canonicalHash := sha256.Sum256(([]byte)(canonicalRequest))
+	stringToSign := strings.Join(
+		[]string{signAlgorithm, amzDate, s.scope(amzDate), hex.EncodeToString(canonicalHash[:])},
+		"\n",
+	)
+	key := hmacSHA256(([]byte)("AWS4"+s.secretKey), amzDate[:8])
+	key = hmacSHA256(key, s.region)
+	key = hmacSHA256(key, s3Service)
+	key = hmacSHA256(key, "aws4_request")
+	return hex.EncodeToString(hmacSHA256(key, stringToSign))
+}
+
+// scope returns the credential scope of the date.
+func (s signer) scope(amzDate string) string {
+	return strings.Join([]string{amzDate[:8], s.region, s3Service, "aws4_request"}, "/")
+}
+
+// signedHeaders returns the sorted lowercase names of the signed headers.
+func signedHeaders(header http.Header) []string {
+	names := []string{"host"}
+	for name := range header {
+		name = strings.ToLower(name)
+		if name == "range" || strings.HasPrefix(name, "x-amz-") {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// canonicalRequest returns the canonical form of the request.
+func canonicalRequest(
+	method string,
+	target *url.URL,
+	host string,
+	header http.Header,
+	signedHeaders []string,
+	payloadHash string,
+) string {
+	var headers strings.Builder
+	for _, name := range signedHeaders {
+		value := header.Get(name)
+		if name == "host" {
+			value = host
+		}
+		fmt.Fprintf(&headers, "%s:%s\n", name, strings.TrimSpace(value))
+	}
+	return strings.Join(
+		[]string{
+			method,
+			target.EscapedPath(),
+			canonicalQuery(target.RawQuery),
+			headers.String(),
+			strings.Join(signedHeaders, ";"),
+			payloadHash,
+		},
+		"\n",
+	)
+}
+
+// canonicalQuery returns the query with its parameters
+// escaped and sorted by their names and values.
+func canonicalQuery(query string) string {
+	if query == "" {
+		return ""
+	}
+	parameters := strings.Split(query, "&")
+	for n, parameter := range parameters {
+		name, value, _ := strings.Cut(parameter, "=")
+		if unescaped, err := url.QueryUnescape(name); err == nil {
+			name = unescaped
+		}
+		if unescaped, err := url.QueryUnescape(value); err == nil {
+			value = unescaped
+		}
+		parameters[n] = escape(name, true) + "=" + escape(value, true)
+	}
+	sort.Strings(parameters)
+	return strings.Join(parameters, "&")
+}
+
+// escape escapes the value the way the signature expects it,
+// the slashes are kept unless the value is a query parameter.
+func escape(value string, query bool) string {
+	var b strings.Builder
+	for _, c := range ([]byte)(value) {
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9',
+			c == '-', c == '_', c == '.', c == '~':
+			b.WriteByte(c)
+		case c == '/' && !query:
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write(([]byte)(data))
+	return h.Sum(nil)
+}
@@ -0,0 +1,223 @@
+// Package chunked provides the chunked AEAD framing
+// the content is sealed with, by the clients and at rest.
+package chunked
+
+import (
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"io"
+
+	"github.com/kerelape/gophkeeper/pkg/gophkeeper"
+)
+
+// The content is sealed in chunks of Size bytes, the last chunk
+// is always shorter, so it is empty if the content fills the chunks up.
+// The nonce of a chunk is the nonce prefix followed by the number of
+// the chunk and the last chunk flag, so chunks can not be reordered,
+// dropped or appended without being noticed.
+const (
+	Size       = 64 * 1024
+	counterLen = 4
+	flagLen    = 1
+
+	// NonceSuffixLen is the length of the nonce
+	// of a chunk that follows the nonce prefix.
+	NonceSuffixLen = counterLen + flagLen
+)
+
+// NewNoncePrefix returns a random nonce prefix for the aead.
+func NewNoncePrefix(aead cipher.AEAD) ([]byte, error) {
+	prefix := make([]byte, aead.NonceSize()-NonceSuffixLen)
+	if _, err := rand.Read(prefix); err != nil {
+		return nil, err
+	}
+	return prefix, nil
+}
+
+// nonce returns the nonce of the chunk.
+func nonce(prefix []byte, counter uint32, last bool) []byte {
+	nonce := make([]byte, len(prefix)+NonceSuffixLen)
+	copy(nonce, prefix)
+	binary.BigEndian.PutUint32(nonce[len(prefix):], counter)
+	if last {
+		nonce[len(nonce)-1] = 1
+	}
+	return nonce
+}
+
+// SealingReader reads the origin sealed in chunks.
+type SealingReader struct {
+	aead    cipher.AEAD
+	prefix  []byte
+	origin  io.Reader
+	counter uint32
+
+	plain   []byte
+	sealed  []byte
+	pending []byte
+	done    bool
+}
+
+// NewSealingReader returns a reader of the origin
+// sealed in chunks with the aead and the nonce prefix.
+func NewSealingReader(aead cipher.AEAD, prefix []byte, origin io.Reader) *SealingReader {
+	return &SealingReader{
+		aead:   aead,
+		prefix: prefix,
+		origin: origin,
+		plain:  make([]byte, Size),
+		sealed: make([]byte, 0, Size+aead.Overhead()),
+	}
+}
+
+// Read implements io.Reader.
+func (r *SealingReader) Read(p []byte) (int, error) {
+	if len(r.pending) == 0 {
+		if r.done {
+			return 0, io.EOF
+		}
+		n, err := io.ReadFull(r.origin, r.plain)
+		last := errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF)
+		if err != nil && !last {
+			return 0, err
+		}
+		r.pending = r.aead.Seal(r.sealed[:0], nonce(r.prefix, r.counter, last), r.plain[:n], nil)
+		r.counter++
+		r.done = last
+	}
+	n := copy(p, r.pending)
+	r.pending = r.pending[n:]
+	return n, nil
+}
+
+// SealingWriter writes the content sealed in chunks to the origin.
+type SealingWriter struct {
+	aead    cipher.AEAD
+	prefix  []byte
+	origin  io.Writer
+	counter uint32
+
+	plain  []byte
+	sealed []byte
+}
+
+// NewSealingWriter returns a writer that writes the content sealed
+// in chunks with the aead and the nonce prefix to the origin, the last
+// chunk is written once the writer is closed.
+func NewSealingWriter(aead cipher.AEAD, prefix []byte, origin io.Writer) *SealingWriter {
+	return &SealingWriter{
+		aead:   aead,
+		prefix: prefix,
+		origin: origin,
+		plain:  make([]byte, 0, Size),
+		sealed: make([]byte, 0, Size+aead.Overhead()),
+	}
+}
+
+// Write implements io.Writer.
+func (w *SealingWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		n := copy(w.plain[len(w.plain):cap(w.plain)], p)
+		w.plain = w.plain[:len(w.plain)+n]
+		p, written = p[n:], written+n
+		// A full chunk is never the last one.
+		if len(w.plain) == cap(w.plain) {
+			if err := w.seal(false); err != nil {
+				return written, err
+			}
+		}
+	}
+	return written, nil
+}
+
+// Close seals the last chunk.
+func (w *SealingWriter) Close() error {
+	return w.seal(true)
+}
+
+// seal writes the buffered chunk sealed to the origin.
+func (w *SealingWriter) seal(last bool) error {
+	w.sealed = w.aead.Seal(w.sealed[:0], nonce(w.prefix, w.counter, last), w.plain, nil)
+	w.plain = w.plain[:0]
+	w.counter++
+	_, err := w.origin.Write(w.sealed)
+	return err
+}
+
+// OpeningReader reads the origin sealed in chunks.
+type OpeningReader struct {
+	aead    cipher.AEAD
+	prefix  []byte
+	origin  io.Reader
+	counter uint32
+
+	sealed  []byte
+	plain   []byte
+	pending []byte
+	done    bool
+}
+
+// NewOpeningReader returns a reader of the origin sealed by a sealing
+// reader or writer, it fails with gophkeeper.ErrIntegrity if the origin
+// is not intact.
+func NewOpeningReader(aead cipher.AEAD, prefix []byte, origin io.Reader) *OpeningReader {
+	return &OpeningReader{
+		aead:   aead,
+		prefix: prefix,
+		origin: origin,
+		sealed: make([]byte, Size+aead.Overhead()),
+		plain:  make([]byte, 0, Size),
+	}
+}
+
+// Read implements io.Reader.
+func (r *OpeningReader) Read(p []byte) (int, error) {
+	// The last chunk may be empty.
+	for len(r.pending) == 0 {
+		if err := r.open(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, r.pending)
+	r.pending = r.pending[n:]
+	return n, nil
+}
+
+// Prefetch opens the first chunk ahead of the reads, so the content
+// sealed with another key or tampered with fails before it is read.
+func (r *OpeningReader) Prefetch() error {
+	if len(r.pending) > 0 || r.counter > 0 {
+		return nil
+	}
+	if err := r.open(); err != nil && !errors.Is(err, io.EOF) {
+		return err
+	}
+	return nil
+}
+
+// open opens the next chunk.
+func (r *OpeningReader) open() error {
+	if r.done {
+		var trailing [1]byte
+		if n, _ := io.ReadFull(r.origin, trailing[:]); n > 0 {
+			return errors.Join(gophkeeper.ErrIntegrity, errors.New("trailing data after the last chunk"))
+		}
+		return io.EOF
+	}
+	n, err := io.ReadFull(r.origin, r.sealed)
+	last := errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF)
+	if err != nil && !last {
+		return err
+	}
+	plain, openError := r.aead.Open(r.plain[:0], nonce(r.prefix, r.counter, last), r.sealed[:n], nil)
+	if openError != nil {
+		return errors.Join(gophkeeper.ErrIntegrity, openError)
+	}
+	r.pending = plain
+	r.counter++
+	r.done = last
+	return nil
+}
@@ -0,0 +1,87 @@
+package chunked
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"io"
+	"testing"
+
+	"github.com/kerelape/gophkeeper/pkg/gophkeeper"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestChunked(t *testing.T) {
+	aead := testAEAD(t)
+	prefix, prefixError := NewNoncePrefix(aead)
+	assert.Nil(t, prefixError, "did not expect an error")
+	assert.Len(t, prefix, aead.NonceSize()-NonceSuffixLen, "unexpected nonce prefix length")
+
+	for _, size := range []int{0, 1, Size - 1, Size, 3*Size + 7} {
+		content := bytes.Repeat(([]byte)("a"), size)
+
+		sealed, sealError := io.ReadAll(NewSealingReader(aead, prefix, bytes.NewReader(content)))
+		assert.Nil(t, sealError, "did not expect an error")
+		var written bytes.Buffer
+		writer := NewSealingWriter(aead, prefix, &written)
+		_, writeError := writer.Write(content)
+		assert.Nil(t, writeError, "did not expect an error")
+		assert.Nil(t, writer.Close(), "did not expect an error")
+		assert.Equal(t, sealed, written.Bytes(), "expected the reader and the writer to seal alike, size %d", size)
+
+		opened, openError := io.ReadAll(NewOpeningReader(aead, prefix, bytes.NewReader(sealed)))
+		assert.Nil(t, openError, "did not expect an error")
+		assert.Equal(t, content, opened, "unexpected content of size %d", size)
+	}
+
+	content := bytes.Repeat(([]byte)("a"), 2*Size+7)
+	sealed, sealError := io.ReadAll(NewSealingReader(aead, prefix, bytes.NewReader(content)))
+	assert.Nil(t, sealError, "did not expect an error")
+	chunk := Size + aead.Overhead()
+
+	t.Run("Tampered", func(t *testing.T) {
+		tampered := bytes.Clone(sealed)
+		tampered[len(tampered)-1] ^= 1
+		_, openError := io.ReadAll(NewOpeningReader(aead, prefix, bytes.NewReader(tampered)))
+		assert.ErrorIs(t, openError, gophkeeper.ErrIntegrity, "unexpected error")
+	})
+
+	t.Run("Truncated", func(t *testing.T) {
+		_, openError := io.ReadAll(NewOpeningReader(aead, prefix, bytes.NewReader(sealed[:2*chunk])))
+		assert.ErrorIs(t, openError, gophkeeper.ErrIntegrity, "unexpected error")
+	})
+
+	t.Run("Reordered", func(t *testing.T) {
+		reordered := append(append(bytes.Clone(sealed[chunk:2*chunk]), sealed[:chunk]...), sealed[2*chunk:]...)
+		_, openError := io.ReadAll(NewOpeningReader(aead, prefix, bytes.NewReader(reordered)))
+		assert.ErrorIs(t, openError, gophkeeper.ErrIntegrity, "unexpected error")
+	})
+
+	t.Run("Trailing", func(t *testing.T) {
+		trailing := append(bytes.Clone(sealed), 0)
+		_, openError := io.ReadAll(NewOpeningReader(aead, prefix, bytes.NewReader(trailing)))
+		assert.ErrorIs(t, openError, gophkeeper.ErrIntegrity, "unexpected error")
+	})
+
+	t.Run("Prefetch", func(t *testing.T) {
+		other, otherError := NewNoncePrefix(aead)
+		assert.Nil(t, otherError, "did not expect an error")
+		prefetchError := NewOpeningReader(aead, other, bytes.NewReader(sealed)).Prefetch()
+		assert.ErrorIs(t, prefetchError, gophkeeper.ErrIntegrity, "expected the first chunk to be opened")
+
+		reader := NewOpeningReader(aead, prefix, bytes.NewReader(sealed))
+		assert.Nil(t, reader.Prefetch(), "did not expect an error")
+		opened, openError := io.ReadAll(reader)
+		assert.Nil(t, openError, "did not expect an error")
+		assert.Equal(t, content, opened, "expected the prefetched chunk to be read")
+	})
+}
+
+func testAEAD(t *testing.T) cipher.AEAD {
+	t.Helper()
+	block, blockError := aes.NewCipher(bytes.Repeat(([]byte)("k"), 32))
+	assert.Nil(t, blockError, "did not expect an error")
+	aead, aeadError := cipher.NewGCM(block)
+	assert.Nil(t, aeadError, "did not expect an error")
+	return aead
+}
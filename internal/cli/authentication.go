@@ -35,7 +35,11 @@ func authenticate(ctx context.Context, g gophkeeper.Gophkeeper) (gophkeeper.Iden
 	if tokenError != nil {
 		return nil, tokenError
 	}
-	return g.Identity(ctx, token)
+	identity, identityError := g.Identity(ctx, token)
+	if identityError != nil {
+		return nil, identityError
+	}
+	return withKeyFile(ctx, identity)
 }
 
 type authenticationModel struct {
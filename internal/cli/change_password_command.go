@@ -0,0 +1,59 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"syscall"
+
+	"github.com/kerelape/gophkeeper/internal/stack"
+	"github.com/kerelape/gophkeeper/pkg/gophkeeper"
+	"golang.org/x/term"
+)
+
+type changePasswordCommand struct {
+	gophkeeper gophkeeper.Gophkeeper
+}
+
+var _ command = (*changePasswordCommand)(nil)
+
+// Description implements command.
+func (c *changePasswordCommand) Description() string {
+	return "Change the login password."
+}
+
+// Help implements command.
+func (c *changePasswordCommand) Help() string {
+	return ""
+}
+
+// Execute implements command.
+func (c *changePasswordCommand) Execute(ctx context.Context, args stack.Stack[string]) (bool, error) {
+	if len(args) > 0 {
+		return false, errors.New("expected 0 arguments")
+	}
+
+	identity, identityError := authenticate(ctx, c.gophkeeper)
+	if identityError != nil {
+		return true, identityError
+	}
+
+	fmt.Print("Type current password: ")
+	oldPassword, oldPasswordError := term.ReadPassword((int)(syscall.Stdin))
+	if oldPasswordError != nil {
+		return true, oldPasswordError
+	}
+	fmt.Println()
+
+	newPassword, newPasswordError := readNewPassword("new password")
+	if newPasswordError != nil {
+		return true, newPasswordError
+	}
+
+	if err := identity.ChangePassword(ctx, (string)(oldPassword), newPassword); err != nil {
+		return true, err
+	}
+
+	fmt.Println("Password changed.")
+	return true, nil
+}
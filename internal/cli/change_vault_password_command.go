@@ -0,0 +1,92 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/kerelape/gophkeeper/internal/stack"
+	"github.com/kerelape/gophkeeper/pkg/gophkeeper"
+)
+
+type changeVaultPasswordCommand struct {
+	gophkeeper gophkeeper.Gophkeeper
+}
+
+var _ command = (*changeVaultPasswordCommand)(nil)
+
+// Description implements command.
+func (c *changeVaultPasswordCommand) Description() string {
+	return "Change the vault password and re-encrypt the vault with it, optionally switching the key file."
+}
+
+// Help implements command.
+func (c *changeVaultPasswordCommand) Help() string {
+	return "[--new-key-file <path>|--no-key-file]"
+}
+
+// Execute implements command.
+func (c *changeVaultPasswordCommand) Execute(ctx context.Context, args stack.Stack[string]) (bool, error) {
+	var (
+		newKeyFile    []byte
+		switchKeyFile bool
+	)
+	switch path, consumed, ok := keyFileArg("--new-key-file", args); {
+	case len(args) == 0:
+	case ok && consumed == len(args) && path != "":
+		keyFile, keyFileError := readKeyFile(path)
+		if keyFileError != nil {
+			return true, keyFileError
+		}
+		newKeyFile, switchKeyFile = keyFile, true
+	case len(args) == 1 && args[0] == "--no-key-file":
+		switchKeyFile = true
+	default:
+		return false, errors.New("expected --new-key-file <path>, --no-key-file or nothing")
+	}
+
+	identity, identityError := authenticate(ctx, c.gophkeeper)
+	if identityError != nil {
+		return true, identityError
+	}
+
+	oldPassword, oldPasswordError := vaultPassword(ctx)
+	if oldPasswordError != nil {
+		return true, oldPasswordError
+	}
+
+	newPassword, newPasswordError := readNewPassword("new vault password")
+	if newPasswordError != nil {
+		return true, newPasswordError
+	}
+
+	progress := gophkeeper.WithProgress(ctx, func(done, total int) {
+		fmt.Printf("\rRe-encrypting resources: %d/%d", done, total)
+	})
+	var changeError error
+	if switchKeyFile {
+		changeError = changeVaultKey(progress, identity, oldPassword, newPassword, newKeyFile)
+	} else {
+		changeError = identity.ChangeVaultPassword(progress, oldPassword, newPassword)
+	}
+	fmt.Println()
+	if changeError != nil {
+		if !errors.Is(changeError, gophkeeper.ErrBadCredential) {
+			fmt.Println("The vault password is not changed, run the command again to resume.")
+		}
+		return true, changeError
+	}
+
+	fmt.Println("Vault password changed.")
+	return true, nil
+}
+
+// changeVaultKey changes the vault password and turns the key file
+// on with the key file, or off if it is nil.
+func changeVaultKey(ctx context.Context, identity gophkeeper.Identity, oldPassword, newPassword string, keyFile []byte) error {
+	origin, ok := identity.(keyed)
+	if !ok {
+		return errKeyFilesUnsupported
+	}
+	return origin.ChangeVaultKey(ctx, oldPassword, newPassword, keyFile)
+}
@@ -21,7 +21,16 @@ type CLI struct {
 var _ runnable.Runnable = (*CLI)(nil)
 
 // Run implements runnable.Runnable.
+//
+// The vault is opened with the key file given by the --key-file
+// option along with the vault password, the option may be given
+// to any command.
 func (c *CLI) Run(ctx context.Context) error {
+	commandLine, keyFile, keyFileError := withoutKeyFile(c.CommandLine)
+	if keyFileError != nil {
+		return keyFileError
+	}
+	ctx = withVaultPassword(ctx, keyFile)
 	commands := map[string]command{
 		"register": &registerCommand{
 			gophkeeper: c.Gophkeeper,
@@ -29,6 +38,9 @@ func (c *CLI) Run(ctx context.Context) error {
 		"list": &listCommand{
 			gophkeeper: c.Gophkeeper,
 		},
+		"search": &searchCommand{
+			gophkeeper: c.Gophkeeper,
+		},
 		"store-credential": &storeCredentialCommand{
 			gophkeeper: c.Gophkeeper,
 		},
@@ -53,21 +65,76 @@ func (c *CLI) Run(ctx context.Context) error {
 		"restore-card": &restoreCardCommand{
 			gophkeeper: c.Gophkeeper,
 		},
+		"edit-credential": &editCredentialCommand{
+			gophkeeper: c.Gophkeeper,
+		},
+		"edit-text": &editTextCommand{
+			gophkeeper: c.Gophkeeper,
+		},
+		"edit-card": &editCardCommand{
+			gophkeeper: c.Gophkeeper,
+		},
+		"replace-file": &replaceFileCommand{
+			gophkeeper: c.Gophkeeper,
+		},
+		"history": &historyCommand{
+			gophkeeper: c.Gophkeeper,
+		},
+		"revert": &revertCommand{
+			gophkeeper: c.Gophkeeper,
+		},
 		"delete": &deleteCommand{
 			gophkeeper: c.Gophkeeper,
 		},
+		"trash": &trashCommand{
+			gophkeeper: c.Gophkeeper,
+		},
+		"undelete": &undeleteCommand{
+			gophkeeper: c.Gophkeeper,
+		},
+		"purge": &purgeCommand{
+			gophkeeper: c.Gophkeeper,
+		},
+		"folders": &foldersCommand{
+			gophkeeper: c.Gophkeeper,
+		},
+		"create-folder": &createFolderCommand{
+			gophkeeper: c.Gophkeeper,
+		},
+		"move-folder": &moveFolderCommand{
+			gophkeeper: c.Gophkeeper,
+		},
+		"move": &moveCommand{
+			gophkeeper: c.Gophkeeper,
+		},
+		"tag": &tagCommand{
+			gophkeeper: c.Gophkeeper,
+		},
+		"untag": &untagCommand{
+			gophkeeper: c.Gophkeeper,
+		},
+		"change-password": &changePasswordCommand{
+			gophkeeper: c.Gophkeeper,
+		},
+		"change-vault-password": &changeVaultPasswordCommand{
+			gophkeeper: c.Gophkeeper,
+		},
+		"migrate": &migrateCommand{
+			gophkeeper: c.Gophkeeper,
+		},
 	}
 
-	if (len(c.CommandLine) < 1) || (c.CommandLine[0] == "help") {
+	if (len(commandLine) < 1) || (commandLine[0] == "help") {
 		for n, c := range commands {
 			fmt.Printf("%s %s - %s\n", n, c.Help(), c.Description())
 		}
+		fmt.Printf("Any command takes %s <path> to open the vault with a key file.\n", keyFileOption)
 		return errors.New("command not specified")
 	}
 
-	if command, ok := commands[c.CommandLine[0]]; ok {
+	if command, ok := commands[commandLine[0]]; ok {
 		var (
-			input = (stack.Stack[string])(c.CommandLine[1:])
+			input = (stack.Stack[string])(commandLine[1:])
 			args  = make(stack.Stack[string], 0, len(input))
 		)
 
@@ -76,12 +143,15 @@ func (c *CLI) Run(ctx context.Context) error {
 		}
 
 		correct, err := command.Execute(ctx, args)
+		if lockError := lockVault(ctx); lockError != nil && err == nil {
+			err = lockError
+		}
 		if !correct {
-			fmt.Printf("%s %s\n", c.CommandLine[0], command.Help())
+			fmt.Printf("%s %s\n", commandLine[0], command.Help())
 		}
 
 		if err != nil {
-			return fmt.Errorf("failed to execute command %s: %w", c.CommandLine[0], err)
+			return fmt.Errorf("failed to execute command %s: %w", commandLine[0], err)
 		}
 		return nil
 	}
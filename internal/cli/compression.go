@@ -0,0 +1,25 @@
+package cli
+
+import (
+	"context"
+
+	"github.com/kerelape/gophkeeper/internal/stack"
+	"github.com/kerelape/gophkeeper/pkg/gophkeeper"
+)
+
+const noCompressOption = "--no-compress"
+
+// withNoCompress removes the --no-compress option from the args and
+// disables the compression of the blobs stored with the returned context
+// if the option is given.
+func withNoCompress(ctx context.Context, args stack.Stack[string]) (context.Context, stack.Stack[string]) {
+	rest := make(stack.Stack[string], 0, len(args))
+	for _, arg := range args {
+		if arg == noCompressOption {
+			ctx = gophkeeper.WithoutCompression(ctx)
+			continue
+		}
+		rest = append(rest, arg)
+	}
+	return ctx, rest
+}
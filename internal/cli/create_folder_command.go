@@ -0,0 +1,64 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/kerelape/gophkeeper/internal/stack"
+	"github.com/kerelape/gophkeeper/pkg/gophkeeper"
+)
+
+type createFolderCommand struct {
+	gophkeeper gophkeeper.Gophkeeper
+}
+
+var _ command = (*createFolderCommand)(nil)
+
+// Description implements command.
+func (c *createFolderCommand) Description() string {
+	return "Create a folder."
+}
+
+// Help implements command.
+func (c *createFolderCommand) Help() string {
+	return "<name: string> [<parent FID: int>]"
+}
+
+// Execute implements command.
+func (c *createFolderCommand) Execute(ctx context.Context, args stack.Stack[string]) (bool, error) {
+	if len(args) != 1 && len(args) != 2 {
+		return false, errors.New("expected 1 or 2 arguments")
+	}
+
+	name := args.Pop()
+	if name == "" {
+		return false, errors.New("folder name must not be empty")
+	}
+	parent := gophkeeper.RootFolder
+	if len(args) > 0 {
+		fid, fidError := strconv.Atoi(args.Pop())
+		if fidError != nil {
+			return false, fidError
+		}
+		parent = (gophkeeper.FolderID)(fid)
+	}
+
+	gophkeeperIdentity, identityError := authenticate(ctx, c.gophkeeper)
+	if identityError != nil {
+		return true, identityError
+	}
+	identity := identity{
+		origin: gophkeeperIdentity,
+	}
+
+	fid, createError := identity.CreateFolder(ctx, name, parent)
+	if createError != nil {
+		return true, createError
+	}
+
+	fmt.Printf("Successfully created folder (FID: %d).\n", fid)
+
+	return true, nil
+}
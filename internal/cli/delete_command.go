@@ -18,7 +18,7 @@ var _ command = (*deleteCommand)(nil)
 
 // Description implements command.
 func (d *deleteCommand) Description() string {
-	return "Delete resource."
+	return "Move resource to the trash."
 }
 
 // Help implements command.
@@ -45,7 +45,7 @@ func (d *deleteCommand) Execute(ctx context.Context, args stack.Stack[string]) (
 		return true, err
 	}
 
-	fmt.Printf("Successfully deleted resource (RID: %d),\n", rid)
+	fmt.Printf("Successfully moved resource (RID: %d) to the trash.\n", rid)
 
 	return true, nil
 }
@@ -0,0 +1,76 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/kerelape/gophkeeper/internal/stack"
+	"github.com/kerelape/gophkeeper/pkg/gophkeeper"
+)
+
+type editCardCommand struct {
+	gophkeeper gophkeeper.Gophkeeper
+}
+
+var _ command = (*editCardCommand)(nil)
+
+// Description implements command.
+func (e *editCardCommand) Description() string {
+	return "Edit card information keeping its RID."
+}
+
+// Help implements command.
+func (e *editCardCommand) Help() string {
+	return "<RID: int>"
+}
+
+// Execute implements command.
+func (e *editCardCommand) Execute(ctx context.Context, args stack.Stack[string]) (bool, error) {
+	if len(args) != 1 {
+		return false, errors.New("expected 1 argument")
+	}
+
+	rid, ridError := strconv.Atoi(args.Pop())
+	if ridError != nil {
+		return false, ridError
+	}
+
+	gophkeeperIdentity, gophkeeperIdentityError := authenticate(ctx, e.gophkeeper)
+	if gophkeeperIdentityError != nil {
+		return true, gophkeeperIdentityError
+	}
+
+	vaultPassword, vaultPasswordError := vaultPassword(ctx)
+	if vaultPasswordError != nil {
+		return true, vaultPasswordError
+	}
+
+	description, descriptionError := description(ctx)
+	if descriptionError != nil {
+		return true, descriptionError
+	}
+
+	card, cardError := cardCredential(ctx)
+	if cardError != nil {
+		return true, cardError
+	}
+
+	var (
+		identity = identity{
+			origin: gophkeeperIdentity,
+		}
+		resource = cardResource{
+			cardInfo:    card,
+			description: description,
+		}
+	)
+	if err := identity.UpdateCard(ctx, (gophkeeper.ResourceID)(rid), resource, vaultPassword); err != nil {
+		return true, err
+	}
+
+	fmt.Printf("Successfully updated card (RID: %d).\n", rid)
+
+	return true, nil
+}
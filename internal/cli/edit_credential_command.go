@@ -0,0 +1,77 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/kerelape/gophkeeper/internal/stack"
+	"github.com/kerelape/gophkeeper/pkg/gophkeeper"
+)
+
+type editCredentialCommand struct {
+	gophkeeper gophkeeper.Gophkeeper
+}
+
+var _ command = (*editCredentialCommand)(nil)
+
+// Description implements command.
+func (e *editCredentialCommand) Description() string {
+	return "Edit a username-password pair keeping its RID."
+}
+
+// Help implements command.
+func (e *editCredentialCommand) Help() string {
+	return "<RID: int>"
+}
+
+// Execute implements command.
+func (e *editCredentialCommand) Execute(ctx context.Context, args stack.Stack[string]) (bool, error) {
+	if len(args) != 1 {
+		return false, errors.New("expected 1 argument")
+	}
+
+	rid, ridError := strconv.Atoi(args.Pop())
+	if ridError != nil {
+		return false, ridError
+	}
+
+	gophkeeperIdentity, gophkeeperIdentityError := authenticate(ctx, e.gophkeeper)
+	if gophkeeperIdentityError != nil {
+		return true, gophkeeperIdentityError
+	}
+
+	vaultPassword, vaultPasswordError := vaultPassword(ctx)
+	if vaultPasswordError != nil {
+		return true, vaultPasswordError
+	}
+
+	description, descriptionError := description(ctx)
+	if descriptionError != nil {
+		return true, descriptionError
+	}
+
+	username, password, credentialError := credential(ctx)
+	if credentialError != nil {
+		return true, credentialError
+	}
+
+	var (
+		identity = identity{
+			origin: gophkeeperIdentity,
+		}
+		resource = credentialResource{
+			description: description,
+			username:    username,
+			password:    password,
+		}
+	)
+	if err := identity.UpdateCredential(ctx, (gophkeeper.ResourceID)(rid), resource, vaultPassword); err != nil {
+		return true, err
+	}
+
+	fmt.Printf("Successfully updated credential (RID: %d).\n", rid)
+
+	return true, nil
+}
@@ -0,0 +1,76 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/kerelape/gophkeeper/internal/stack"
+	"github.com/kerelape/gophkeeper/pkg/gophkeeper"
+)
+
+type editTextCommand struct {
+	gophkeeper gophkeeper.Gophkeeper
+}
+
+var _ command = (*editTextCommand)(nil)
+
+// Description implements command.
+func (e *editTextCommand) Description() string {
+	return "Edit a text note keeping its RID."
+}
+
+// Help implements command.
+func (e *editTextCommand) Help() string {
+	return "<RID: int>"
+}
+
+// Execute implements command.
+func (e *editTextCommand) Execute(ctx context.Context, args stack.Stack[string]) (bool, error) {
+	if len(args) != 1 {
+		return false, errors.New("expected 1 argument")
+	}
+
+	rid, ridError := strconv.Atoi(args.Pop())
+	if ridError != nil {
+		return false, ridError
+	}
+
+	gophkeeperIdentity, gophkeeperIdentityError := authenticate(ctx, e.gophkeeper)
+	if gophkeeperIdentityError != nil {
+		return true, gophkeeperIdentityError
+	}
+
+	vaultPassword, vaultPasswordError := vaultPassword(ctx)
+	if vaultPasswordError != nil {
+		return true, vaultPasswordError
+	}
+
+	description, descriptionError := description(ctx)
+	if descriptionError != nil {
+		return true, descriptionError
+	}
+
+	content, contentError := text(ctx)
+	if contentError != nil {
+		return true, contentError
+	}
+
+	var (
+		identity = identity{
+			origin: gophkeeperIdentity,
+		}
+		resource = textResource{
+			description: description,
+			content:     content,
+		}
+	)
+	if err := identity.UpdateText(ctx, (gophkeeper.ResourceID)(rid), resource, vaultPassword); err != nil {
+		return true, err
+	}
+
+	fmt.Printf("\nSuccessfully updated text note (RID: %d).\n", rid)
+
+	return true, nil
+}
@@ -0,0 +1,82 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path"
+	"sort"
+
+	"github.com/kerelape/gophkeeper/internal/stack"
+	"github.com/kerelape/gophkeeper/pkg/gophkeeper"
+)
+
+type foldersCommand struct {
+	gophkeeper gophkeeper.Gophkeeper
+}
+
+var _ command = (*foldersCommand)(nil)
+
+// Description implements command.
+func (f *foldersCommand) Description() string {
+	return "List out all folders."
+}
+
+// Help implements command.
+func (f *foldersCommand) Help() string {
+	return ""
+}
+
+// Execute implements command.
+func (f *foldersCommand) Execute(ctx context.Context, args stack.Stack[string]) (bool, error) {
+	if len(args) > 0 {
+		return false, errors.New("expected 0 arguments")
+	}
+	gophkeeperIdentity, identityError := authenticate(ctx, f.gophkeeper)
+	if identityError != nil {
+		return true, identityError
+	}
+	identity := identity{
+		origin: gophkeeperIdentity,
+	}
+	folders, foldersError := identity.Folders(ctx)
+	if foldersError != nil {
+		return true, foldersError
+	}
+	paths := folderPaths(folders)
+	sort.Slice(folders, func(i, j int) bool {
+		return paths[folders[i].ID] < paths[folders[j].ID]
+	})
+	fmt.Printf("%d folders found\n", len(folders))
+	for _, folder := range folders {
+		fmt.Printf("(FID: %d) %s\n", folder.ID, paths[folder.ID])
+	}
+	return true, nil
+}
+
+// folderPaths returns full paths of the folders and of the root folder.
+func folderPaths(folders []gophkeeper.Folder) map[gophkeeper.FolderID]string {
+	parents := make(map[gophkeeper.FolderID]gophkeeper.Folder, len(folders))
+	for _, folder := range folders {
+		parents[folder.ID] = folder
+	}
+	paths := map[gophkeeper.FolderID]string{
+		gophkeeper.RootFolder: "/",
+	}
+	var resolve func(id gophkeeper.FolderID) string
+	resolve = func(id gophkeeper.FolderID) string {
+		if p, ok := paths[id]; ok {
+			return p
+		}
+		folder, ok := parents[id]
+		if !ok {
+			return "/"
+		}
+		paths[id] = path.Join(resolve(folder.Parent), folder.Name)
+		return paths[id]
+	}
+	for _, folder := range folders {
+		resolve(folder.ID)
+	}
+	return paths
+}
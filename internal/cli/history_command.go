@@ -0,0 +1,66 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kerelape/gophkeeper/internal/stack"
+	"github.com/kerelape/gophkeeper/pkg/gophkeeper"
+)
+
+type historyCommand struct {
+	gophkeeper gophkeeper.Gophkeeper
+}
+
+var _ command = (*historyCommand)(nil)
+
+// Description implements command.
+func (h *historyCommand) Description() string {
+	return "List out previous versions of a resource."
+}
+
+// Help implements command.
+func (h *historyCommand) Help() string {
+	return "<RID: int>"
+}
+
+// Execute implements command.
+func (h *historyCommand) Execute(ctx context.Context, args stack.Stack[string]) (bool, error) {
+	if len(args) != 1 {
+		return false, errors.New("expected 1 argument")
+	}
+
+	rid, ridError := strconv.Atoi(args.Pop())
+	if ridError != nil {
+		return false, ridError
+	}
+
+	gophkeeperIdentity, gophkeeperIdentityError := authenticate(ctx, h.gophkeeper)
+	if gophkeeperIdentityError != nil {
+		return true, gophkeeperIdentityError
+	}
+
+	identity := identity{
+		origin: gophkeeperIdentity,
+	}
+	versions, versionsError := identity.History(ctx, (gophkeeper.ResourceID)(rid))
+	if versionsError != nil {
+		return true, versionsError
+	}
+
+	fmt.Printf("%d versions found for resource (RID: %d)\n", len(versions), rid)
+	for _, v := range versions {
+		fmt.Printf(
+			"(Version: %d)\n\tSaved: %s\n\tDescription: %s\n",
+			v.Version,
+			v.CreatedAt.Local().Format(time.DateTime),
+			strings.ReplaceAll(v.Description, "\n", " "),
+		)
+	}
+
+	return true, nil
+}
@@ -6,6 +6,7 @@ import (
 	"encoding/json"
 	"errors"
 	"os"
+	"time"
 
 	"github.com/kerelape/gophkeeper/pkg/gophkeeper"
 )
@@ -14,6 +15,13 @@ type identity struct {
 	origin gophkeeper.Identity
 }
 
+// lockable is an identity that lists the resources
+// only after it is unlocked with the vault password.
+type lockable interface {
+	gophkeeper.Unlocker
+	Locked() bool
+}
+
 type resourceType int
 
 const (
@@ -42,6 +50,26 @@ type resource struct {
 	RID         gophkeeper.ResourceID
 	Description string
 	Type        resourceType
+
+	Folder gophkeeper.FolderID
+	Tags   []string
+
+	CreatedAt  time.Time
+	UpdatedAt  time.Time
+	AccessedAt time.Time
+	Size       int64
+	Hash       []byte
+}
+
+type trashedResource struct {
+	resource
+	DeletedAt time.Time
+}
+
+type resourceVersion struct {
+	Version     int
+	Description string
+	CreatedAt   time.Time
 }
 
 type (
@@ -64,15 +92,81 @@ type (
 	}
 )
 
-func (i identity) List(ctx context.Context) ([]resource, error) {
-	resources, resourcesError := i.origin.List(ctx)
+func (i identity) List(ctx context.Context, options gophkeeper.ListOptions) ([]resource, error) {
+	origin, originError := i.unlocked(ctx)
+	if originError != nil {
+		return nil, originError
+	}
+	resources := make([]gophkeeper.Resource, 0)
+	for {
+		page, pageError := origin.List(ctx, options)
+		if pageError != nil {
+			return nil, pageError
+		}
+		resources = append(resources, page.Resources...)
+		if page.Next == "" {
+			break
+		}
+		options.Cursor = page.Next
+	}
+	return resourcesOf(resources), nil
+}
+
+func (i identity) Search(ctx context.Context, query string) ([]resource, error) {
+	origin, originError := i.unlocked(ctx)
+	if originError != nil {
+		return nil, originError
+	}
+	resources, resourcesError := origin.Search(ctx, terms(query))
 	if resourcesError != nil {
 		return nil, resourcesError
 	}
-	result := make([]resource, 0, len(resources))
+	return resourcesOf(resources), nil
+}
+
+func (i identity) Tag(ctx context.Context, rid gophkeeper.ResourceID, tag string) error {
+	origin, originError := i.unlocked(ctx)
+	if originError != nil {
+		return originError
+	}
+	return origin.Tag(ctx, rid, tag, terms(tag)...)
+}
+
+func (i identity) Untag(ctx context.Context, rid gophkeeper.ResourceID, tag string) error {
+	origin, originError := i.unlocked(ctx)
+	if originError != nil {
+		return originError
+	}
+	return origin.Untag(ctx, rid, tag)
+}
+
+func (i identity) CreateFolder(ctx context.Context, name string, parent gophkeeper.FolderID) (gophkeeper.FolderID, error) {
+	origin, originError := i.unlocked(ctx)
+	if originError != nil {
+		return -1, originError
+	}
+	return origin.CreateFolder(ctx, name, parent)
+}
+
+func (i identity) Folders(ctx context.Context) ([]gophkeeper.Folder, error) {
+	origin, originError := i.unlocked(ctx)
+	if originError != nil {
+		return nil, originError
+	}
+	return origin.ListFolders(ctx)
+}
+
+func (i identity) Trash(ctx context.Context) ([]trashedResource, error) {
+	origin, originError := i.unlocked(ctx)
+	if originError != nil {
+		return nil, originError
+	}
+	resources, resourcesError := origin.ListTrash(ctx)
+	if resourcesError != nil {
+		return nil, resourcesError
+	}
+	result := make([]trashedResource, 0, len(resources))
 	for _, r := range resources {
-		var resource resource
-		resource.RID = r.ID
 		var meta struct {
 			Type        resourceType `json:"type"`
 			Description string       `json:"description"`
@@ -80,39 +174,72 @@ func (i identity) List(ctx context.Context) ([]resource, error) {
 		if err := json.Unmarshal(([]byte)(r.Meta), &meta); err != nil {
 			continue
 		}
-		resource.Type = meta.Type
-		resource.Description = meta.Description
+		resource := trashedResource{
+			resource: resource{
+				RID:         r.ID,
+				Type:        meta.Type,
+				Description: meta.Description,
+				Folder:      r.Folder,
+				Tags:        r.Tags,
+				CreatedAt:   r.CreatedAt,
+				UpdatedAt:   r.UpdatedAt,
+				AccessedAt:  r.AccessedAt,
+				Size:        r.Size,
+				Hash:        r.Hash,
+			},
+			DeletedAt: r.DeletedAt,
+		}
 		result = append(result, resource)
 	}
 	return result, nil
 }
 
-func (i identity) StoreCredential(ctx context.Context, cred credentialResource, vaultPassword string) (gophkeeper.ResourceID, error) {
-	meta, metaError := json.Marshal(
-		map[string]any{
-			"type":        (int)(resourceTypeCredential),
-			"description": cred.description,
-		},
-	)
-	if metaError != nil {
-		return -1, metaError
+func (i identity) History(ctx context.Context, rid gophkeeper.ResourceID) ([]resourceVersion, error) {
+	origin, originError := i.unlocked(ctx)
+	if originError != nil {
+		return nil, originError
 	}
-	content, contentError := json.Marshal(
-		map[string]any{
-			"username": cred.username,
-			"password": cred.password,
-		},
-	)
-	if contentError != nil {
-		return -1, contentError
+	versions, versionsError := origin.ListVersions(ctx, rid)
+	if versionsError != nil {
+		return nil, versionsError
 	}
-	piece := gophkeeper.Piece{
-		Meta:    (string)(meta),
-		Content: content,
+	result := make([]resourceVersion, 0, len(versions))
+	for _, v := range versions {
+		var meta struct {
+			Description string `json:"description"`
+		}
+		if err := json.Unmarshal(([]byte)(v.Meta), &meta); err != nil {
+			continue
+		}
+		version := resourceVersion{
+			Version:     v.Version,
+			Description: meta.Description,
+			CreatedAt:   v.CreatedAt,
+		}
+		result = append(result, version)
+	}
+	return result, nil
+}
+
+func (i identity) StoreCredential(ctx context.Context, cred credentialResource, vaultPassword string) (gophkeeper.ResourceID, error) {
+	piece, pieceError := cred.piece()
+	if pieceError != nil {
+		return -1, pieceError
 	}
 	return i.origin.StorePiece(ctx, piece, vaultPassword)
 }
 
+func (i identity) UpdateCredential(ctx context.Context, rid gophkeeper.ResourceID, cred credentialResource, vaultPassword string) error {
+	if err := i.checkType(ctx, rid, resourceTypeCredential); err != nil {
+		return err
+	}
+	piece, pieceError := cred.piece()
+	if pieceError != nil {
+		return pieceError
+	}
+	return i.origin.UpdatePiece(ctx, rid, piece, vaultPassword)
+}
+
 func (i identity) RestoreCredential(ctx context.Context, rid gophkeeper.ResourceID, vaultPassword string) (credentialResource, error) {
 	piece, pieceError := i.origin.RestorePiece(ctx, rid, vaultPassword)
 	if pieceError != nil {
@@ -147,22 +274,24 @@ func (i identity) RestoreCredential(ctx context.Context, rid gophkeeper.Resource
 }
 
 func (i identity) StoreText(ctx context.Context, resource textResource, vaultPassword string) (gophkeeper.ResourceID, error) {
-	meta, metaError := json.Marshal(
-		map[string]any{
-			"type":        (int)(resourceTypeText),
-			"description": resource.description,
-		},
-	)
-	if metaError != nil {
-		return -1, metaError
-	}
-	piece := gophkeeper.Piece{
-		Meta:    (string)(meta),
-		Content: ([]byte)(resource.content),
+	piece, pieceError := resource.piece()
+	if pieceError != nil {
+		return -1, pieceError
 	}
 	return i.origin.StorePiece(ctx, piece, vaultPassword)
 }
 
+func (i identity) UpdateText(ctx context.Context, rid gophkeeper.ResourceID, resource textResource, vaultPassword string) error {
+	if err := i.checkType(ctx, rid, resourceTypeText); err != nil {
+		return err
+	}
+	piece, pieceError := resource.piece()
+	if pieceError != nil {
+		return pieceError
+	}
+	return i.origin.UpdatePiece(ctx, rid, piece, vaultPassword)
+}
+
 func (i identity) RestoreText(ctx context.Context, rid gophkeeper.ResourceID, vaultPassword string) (textResource, error) {
 	piece, pieceError := i.origin.RestorePiece(ctx, rid, vaultPassword)
 	if pieceError != nil {
@@ -188,24 +317,9 @@ func (i identity) RestoreText(ctx context.Context, rid gophkeeper.ResourceID, va
 }
 
 func (i identity) StoreFile(ctx context.Context, resource fileResource, vaultPassword string) (gophkeeper.ResourceID, error) {
-	meta, metaError := json.Marshal(
-		map[string]any{
-			"type":        (int)(resourceTypeFile),
-			"description": resource.description,
-		},
-	)
-	if metaError != nil {
-		return -1, metaError
-	}
-
-	file, fileError := os.Open(resource.path)
-	if fileError != nil {
-		return -1, fileError
-	}
-
-	blob := gophkeeper.Blob{
-		Meta:    (string)(meta),
-		Content: file,
+	blob, blobError := resource.blob()
+	if blobError != nil {
+		return -1, blobError
 	}
 	rid, ridError := i.origin.StoreBlob(ctx, blob, vaultPassword)
 	if ridError != nil {
@@ -215,6 +329,17 @@ func (i identity) StoreFile(ctx context.Context, resource fileResource, vaultPas
 	return rid, nil
 }
 
+func (i identity) ReplaceFile(ctx context.Context, rid gophkeeper.ResourceID, resource fileResource, vaultPassword string) error {
+	if err := i.checkType(ctx, rid, resourceTypeFile); err != nil {
+		return err
+	}
+	blob, blobError := resource.blob()
+	if blobError != nil {
+		return blobError
+	}
+	return i.origin.UpdateBlob(ctx, rid, blob, vaultPassword)
+}
+
 func (i identity) RestoreFile(ctx context.Context, rid gophkeeper.ResourceID, path, vaultPassword string) (fileResource, error) {
 	blob, blobError := i.origin.RestoreBlob(ctx, rid, vaultPassword)
 	if blobError != nil {
@@ -252,31 +377,9 @@ func (i identity) RestoreFile(ctx context.Context, rid gophkeeper.ResourceID, pa
 }
 
 func (i identity) StoreCard(ctx context.Context, resource cardResource, vaultPassword string) (gophkeeper.ResourceID, error) {
-	meta, metaError := json.Marshal(
-		map[string]any{
-			"type":        (int)(resourceTypeCard),
-			"description": resource.description,
-		},
-	)
-	if metaError != nil {
-		return -1, metaError
-	}
-
-	content, contentError := json.Marshal(
-		map[string]any{
-			"ccn":    resource.ccn,
-			"exp":    resource.exp,
-			"cvv":    resource.cvv,
-			"holder": resource.holder,
-		},
-	)
-	if contentError != nil {
-		return -1, contentError
-	}
-
-	piece := gophkeeper.Piece{
-		Meta:    (string)(meta),
-		Content: content,
+	piece, pieceError := resource.piece()
+	if pieceError != nil {
+		return -1, pieceError
 	}
 	rid, ridError := i.origin.StorePiece(ctx, piece, vaultPassword)
 	if ridError != nil {
@@ -286,6 +389,17 @@ func (i identity) StoreCard(ctx context.Context, resource cardResource, vaultPas
 	return rid, nil
 }
 
+func (i identity) UpdateCard(ctx context.Context, rid gophkeeper.ResourceID, resource cardResource, vaultPassword string) error {
+	if err := i.checkType(ctx, rid, resourceTypeCard); err != nil {
+		return err
+	}
+	piece, pieceError := resource.piece()
+	if pieceError != nil {
+		return pieceError
+	}
+	return i.origin.UpdatePiece(ctx, rid, piece, vaultPassword)
+}
+
 func (i identity) RestoreCard(ctx context.Context, rid gophkeeper.ResourceID, vaultPassword string) (cardResource, error) {
 	piece, pieceError := i.origin.RestorePiece(ctx, rid, vaultPassword)
 	if pieceError != nil {
@@ -324,3 +438,169 @@ func (i identity) RestoreCard(ctx context.Context, rid gophkeeper.ResourceID, va
 	}
 	return resource, nil
 }
+
+// unlocked returns the origin unlocked with the vault password if it
+// needs to be unlocked, the vault is unlocked once per command.
+func (i identity) unlocked(ctx context.Context) (gophkeeper.Identity, error) {
+	origin, ok := i.origin.(lockable)
+	if !ok || !origin.Locked() {
+		return i.origin, nil
+	}
+	cached := vaultCredentialOf(ctx)
+	if cached.unlocked != nil {
+		return cached.unlocked, nil
+	}
+	vaultPassword, vaultPasswordError := vaultPassword(ctx)
+	if vaultPasswordError != nil {
+		return nil, vaultPasswordError
+	}
+	unlocked, unlockError := origin.Unlock(ctx, vaultPassword)
+	if unlockError != nil {
+		return nil, unlockError
+	}
+	cached.unlocked = unlocked
+	return unlocked, nil
+}
+
+// resourcesOf returns the resources with the meta written by the CLI.
+func resourcesOf(resources []gophkeeper.Resource) []resource {
+	result := make([]resource, 0, len(resources))
+	for _, r := range resources {
+		var resource resource
+		resource.RID = r.ID
+		var meta struct {
+			Type        resourceType `json:"type"`
+			Description string       `json:"description"`
+		}
+		if err := json.Unmarshal(([]byte)(r.Meta), &meta); err != nil {
+			continue
+		}
+		resource.Type = meta.Type
+		resource.Description = meta.Description
+		resource.Folder = r.Folder
+		resource.Tags = r.Tags
+		resource.CreatedAt = r.CreatedAt
+		resource.UpdatedAt = r.UpdatedAt
+		resource.AccessedAt = r.AccessedAt
+		resource.Size = r.Size
+		resource.Hash = r.Hash
+		result = append(result, resource)
+	}
+	return result
+}
+
+// checkType checks the resource is of the expected type,
+// only the resource itself is listed.
+func (i identity) checkType(ctx context.Context, rid gophkeeper.ResourceID, expected resourceType) error {
+	resources, resourcesError := i.List(ctx, gophkeeper.ListOptions{ID: &rid, Limit: 1})
+	if resourcesError != nil {
+		return resourcesError
+	}
+	if len(resources) == 0 {
+		return gophkeeper.ErrResourceNotFound
+	}
+	if resources[0].Type != expected {
+		return errors.New("invalid resource type")
+	}
+	return nil
+}
+
+func (r credentialResource) piece() (gophkeeper.Piece, error) {
+	meta, metaError := json.Marshal(
+		map[string]any{
+			"type":        (int)(resourceTypeCredential),
+			"description": r.description,
+		},
+	)
+	if metaError != nil {
+		return gophkeeper.Piece{}, metaError
+	}
+	content, contentError := json.Marshal(
+		map[string]any{
+			"username": r.username,
+			"password": r.password,
+		},
+	)
+	if contentError != nil {
+		return gophkeeper.Piece{}, contentError
+	}
+	piece := gophkeeper.Piece{
+		Meta:    (string)(meta),
+		Content: content,
+		Index:   terms(r.description),
+	}
+	return piece, nil
+}
+
+func (r textResource) piece() (gophkeeper.Piece, error) {
+	meta, metaError := json.Marshal(
+		map[string]any{
+			"type":        (int)(resourceTypeText),
+			"description": r.description,
+		},
+	)
+	if metaError != nil {
+		return gophkeeper.Piece{}, metaError
+	}
+	piece := gophkeeper.Piece{
+		Meta:    (string)(meta),
+		Content: ([]byte)(r.content),
+		Index:   terms(r.description),
+	}
+	return piece, nil
+}
+
+func (r fileResource) blob() (gophkeeper.Blob, error) {
+	meta, metaError := json.Marshal(
+		map[string]any{
+			"type":        (int)(resourceTypeFile),
+			"description": r.description,
+		},
+	)
+	if metaError != nil {
+		return gophkeeper.Blob{}, metaError
+	}
+
+	file, fileError := os.Open(r.path)
+	if fileError != nil {
+		return gophkeeper.Blob{}, fileError
+	}
+
+	blob := gophkeeper.Blob{
+		Meta:    (string)(meta),
+		Content: file,
+		Index:   terms(r.description),
+	}
+	return blob, nil
+}
+
+func (r cardResource) piece() (gophkeeper.Piece, error) {
+	meta, metaError := json.Marshal(
+		map[string]any{
+			"type":        (int)(resourceTypeCard),
+			"description": r.description,
+		},
+	)
+	if metaError != nil {
+		return gophkeeper.Piece{}, metaError
+	}
+
+	content, contentError := json.Marshal(
+		map[string]any{
+			"ccn":    r.ccn,
+			"exp":    r.exp,
+			"cvv":    r.cvv,
+			"holder": r.holder,
+		},
+	)
+	if contentError != nil {
+		return gophkeeper.Piece{}, contentError
+	}
+
+	piece := gophkeeper.Piece{
+		Meta:    (string)(meta),
+		Content: content,
+		Index:   terms(r.description),
+	}
+	return piece, nil
+}
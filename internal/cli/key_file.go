@@ -0,0 +1,106 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"os"
+	"strings"
+
+	"github.com/kerelape/gophkeeper/pkg/gophkeeper"
+)
+
+// keyFileOption is the option that gives the key file
+// the vault is opened with along with the vault password.
+const keyFileOption = "--key-file"
+
+// keyed is an identity that opens the vault
+// with a key file along with the vault password.
+type keyed interface {
+	WithKeyFile(keyFile []byte) gophkeeper.Identity
+	ChangeVaultKey(ctx context.Context, oldPassword, newPassword string, keyFile []byte) error
+}
+
+// errKeyFilesUnsupported is returned when a key file is
+// given but the identity does not encrypt the vault itself.
+var errKeyFilesUnsupported = errors.New("key files require client-side encryption")
+
+// keyFileArg returns the value of the option if the arg is the option,
+// the value is the next arg unless it is given with an equals sign.
+func keyFileArg(option string, args []string) (string, int, bool) {
+	if len(args) == 0 {
+		return "", 0, false
+	}
+	if value, ok := strings.CutPrefix(args[0], option+"="); ok {
+		return value, 1, true
+	}
+	if args[0] != option {
+		return "", 0, false
+	}
+	if len(args) < 2 {
+		return "", 1, true
+	}
+	return args[1], 2, true
+}
+
+// withoutKeyFile returns the command line without the key file option
+// and the path to the key file it gives.
+func withoutKeyFile(commandLine []string) ([]string, string, error) {
+	var (
+		rest    = make([]string, 0, len(commandLine))
+		keyFile string
+	)
+	for n := 0; n < len(commandLine); {
+		value, consumed, ok := keyFileArg(keyFileOption, commandLine[n:])
+		if !ok {
+			rest = append(rest, commandLine[n])
+			n++
+			continue
+		}
+		if value == "" {
+			return nil, "", errors.New("expected a path to the key file")
+		}
+		keyFile = value
+		n += consumed
+	}
+	return rest, keyFile, nil
+}
+
+// readKeyFile reads the content of the key file.
+func readKeyFile(path string) ([]byte, error) {
+	content, contentError := os.ReadFile(path)
+	if contentError != nil {
+		return nil, contentError
+	}
+	if len(content) == 0 {
+		return nil, errors.New("key file is empty")
+	}
+	return content, nil
+}
+
+// withKeyFile returns the identity that opens the vault with the key file
+// of the context if there is one. If the account settings tell that the
+// vault needs a key file and there is none, the vault password is not
+// asked for.
+func withKeyFile(ctx context.Context, identity gophkeeper.Identity) (gophkeeper.Identity, error) {
+	settings, settingsError := identity.Settings(ctx)
+	if settingsError != nil {
+		return nil, settingsError
+	}
+	credential := vaultCredentialOf(ctx)
+	if credential.keyFile == "" {
+		credential.keyFileRequired = settings.KeyFile
+		return identity, nil
+	}
+	if !settings.KeyFile {
+		return nil, errors.New("the vault does not use a key file")
+	}
+	origin, ok := identity.(keyed)
+	if !ok {
+		return nil, errKeyFilesUnsupported
+	}
+	keyFile, keyFileError := readKeyFile(credential.keyFile)
+	if keyFileError != nil {
+		return nil, keyFileError
+	}
+	return origin.WithKeyFile(keyFile), nil
+}
@@ -4,7 +4,10 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"sort"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/kerelape/gophkeeper/internal/stack"
 	"github.com/kerelape/gophkeeper/pkg/gophkeeper"
@@ -16,6 +19,25 @@ type listCommand struct {
 
 var _ command = (*listCommand)(nil)
 
+// resourceOrders are the orders resources can be listed in.
+var resourceOrders = map[string]func(a, b resource) bool{
+	"rid": func(a, b resource) bool {
+		return a.RID < b.RID
+	},
+	"created": func(a, b resource) bool {
+		return a.CreatedAt.Before(b.CreatedAt)
+	},
+	"updated": func(a, b resource) bool {
+		return a.UpdatedAt.Before(b.UpdatedAt)
+	},
+	"accessed": func(a, b resource) bool {
+		return a.AccessedAt.Before(b.AccessedAt)
+	},
+	"size": func(a, b resource) bool {
+		return a.Size < b.Size
+	},
+}
+
 // Description implements command.
 func (l *listCommand) Description() string {
 	return "List out all available resources."
@@ -23,14 +45,56 @@ func (l *listCommand) Description() string {
 
 // Help implements command.
 func (l *listCommand) Help() string {
-	return ""
+	return "[folder=<FID: int>] [tag=<tag: string>] [<sort: rid|created|updated|accessed|size>] [<order: asc|desc>]"
 }
 
 // Execute implements command.
 func (l *listCommand) Execute(ctx context.Context, args stack.Stack[string]) (bool, error) {
+	var (
+		options    gophkeeper.ListOptions
+		positional = make(stack.Stack[string], 0, len(args))
+	)
+	for len(args) > 0 {
+		arg := args.Pop()
+		switch {
+		case strings.HasPrefix(arg, "folder="):
+			folder, folderError := strconv.Atoi(strings.TrimPrefix(arg, "folder="))
+			if folderError != nil {
+				return false, folderError
+			}
+			options.Folder = new(gophkeeper.FolderID)
+			*options.Folder = (gophkeeper.FolderID)(folder)
+		case strings.HasPrefix(arg, "tag="):
+			options.Tag = strings.TrimPrefix(arg, "tag=")
+		default:
+			positional = append(stack.Stack[string]{arg}, positional...)
+		}
+	}
+	args = positional
+	if len(args) > 2 {
+		return false, errors.New("expected at most 2 positional arguments")
+	}
+
+	less := resourceOrders["rid"]
+	if len(args) > 0 {
+		key := args.Pop()
+		order, ok := resourceOrders[key]
+		if !ok {
+			return false, fmt.Errorf("unknown sort key: %s", key)
+		}
+		less = order
+	}
+	descending := false
 	if len(args) > 0 {
-		return false, errors.New("expected 0 arguments")
+		switch direction := args.Pop(); direction {
+		case "asc":
+		case "desc":
+			descending = true
+		default:
+			return false, fmt.Errorf("unknown order: %s", direction)
+		}
 	}
+
 	gophkeeperIdentity, identityError := authenticate(ctx, l.gophkeeper)
 	if identityError != nil {
 		return true, identityError
@@ -38,18 +102,46 @@ func (l *listCommand) Execute(ctx context.Context, args stack.Stack[string]) (bo
 	identity := identity{
 		origin: gophkeeperIdentity,
 	}
-	resources, resourcesError := identity.List(ctx)
+	resources, resourcesError := identity.List(ctx, options)
 	if resourcesError != nil {
 		return true, resourcesError
 	}
+	folders, foldersError := identity.Folders(ctx)
+	if foldersError != nil {
+		return true, foldersError
+	}
+	paths := folderPaths(folders)
+	sort.SliceStable(resources, func(i, j int) bool {
+		if descending {
+			return less(resources[j], resources[i])
+		}
+		return less(resources[i], resources[j])
+	})
+	printResources(resources, paths)
+	return true, nil
+}
+
+// printResources prints out the resources
+// with the paths of the folders they are in.
+func printResources(resources []resource, paths map[gophkeeper.FolderID]string) {
 	fmt.Printf("%d resources found\n", len(resources))
 	for _, r := range resources {
+		accessed := "never"
+		if !r.AccessedAt.IsZero() {
+			accessed = r.AccessedAt.Local().Format(time.DateTime)
+		}
 		fmt.Printf(
-			"(RID: %d)\n\tType: %s\n\tDescription: %s\n",
+			"(RID: %d)\n\tType: %s\n\tDescription: %s\n\tFolder: %s\n\tTags: %s\n\tCreated: %s\n\tUpdated: %s\n\tAccessed: %s\n\tSize: %d bytes\n\tSHA-256: %x\n",
 			r.RID,
 			r.Type.String(),
 			strings.ReplaceAll(r.Description, "\n", " "),
+			paths[r.Folder],
+			strings.Join(r.Tags, ", "),
+			r.CreatedAt.Local().Format(time.DateTime),
+			r.UpdatedAt.Local().Format(time.DateTime),
+			accessed,
+			r.Size,
+			r.Hash,
 		)
 	}
-	return true, nil
 }
@@ -0,0 +1,81 @@
+package cli
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/kerelape/gophkeeper/internal/stack"
+	"github.com/kerelape/gophkeeper/pkg/gophkeeper"
+)
+
+// migrator is an identity that encrypts the resources
+// stored in clear text before it encrypted the vault.
+type migrator interface {
+	Migrate(ctx context.Context, password string, index func(meta string) []string) (int, error)
+}
+
+type migrateCommand struct {
+	gophkeeper gophkeeper.Gophkeeper
+}
+
+var _ command = (*migrateCommand)(nil)
+
+// Description implements command.
+func (m *migrateCommand) Description() string {
+	return "Encrypt the resources stored in plain by the CLI from before the client-side encryption."
+}
+
+// Help implements command.
+func (m *migrateCommand) Help() string {
+	return ""
+}
+
+// Execute implements command.
+func (m *migrateCommand) Execute(ctx context.Context, args stack.Stack[string]) (bool, error) {
+	if len(args) > 0 {
+		return false, errors.New("expected 0 arguments")
+	}
+
+	identity, identityError := authenticate(ctx, m.gophkeeper)
+	if identityError != nil {
+		return true, identityError
+	}
+	origin, ok := identity.(migrator)
+	if !ok {
+		return true, errors.New("the vault is not encrypted by the client")
+	}
+
+	password, passwordError := vaultPassword(ctx)
+	if passwordError != nil {
+		return true, passwordError
+	}
+
+	progress := gophkeeper.WithProgress(ctx, func(done, total int) {
+		fmt.Printf("\rEncrypting resources: %d/%d", done, total)
+	})
+	migrated, migrateError := origin.Migrate(progress, password, metaTerms)
+	fmt.Println()
+	if migrateError != nil {
+		if !errors.Is(migrateError, gophkeeper.ErrBadCredential) {
+			fmt.Println("The migration is not complete, run the command again to resume.")
+		}
+		return true, migrateError
+	}
+
+	fmt.Printf("Encrypted %d resources.\n", migrated)
+	return true, nil
+}
+
+// metaTerms returns the terms of the description
+// of the meta the resource is searched by.
+func metaTerms(meta string) []string {
+	var m struct {
+		Description string `json:"description"`
+	}
+	if err := json.Unmarshal(([]byte)(meta), &m); err != nil {
+		return nil
+	}
+	return terms(m.Description)
+}
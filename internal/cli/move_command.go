@@ -0,0 +1,56 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/kerelape/gophkeeper/internal/stack"
+	"github.com/kerelape/gophkeeper/pkg/gophkeeper"
+)
+
+type moveCommand struct {
+	gophkeeper gophkeeper.Gophkeeper
+}
+
+var _ command = (*moveCommand)(nil)
+
+// Description implements command.
+func (m *moveCommand) Description() string {
+	return "Move resource into a folder."
+}
+
+// Help implements command.
+func (m *moveCommand) Help() string {
+	return "<RID: int> <FID: int>"
+}
+
+// Execute implements command.
+func (m *moveCommand) Execute(ctx context.Context, args stack.Stack[string]) (bool, error) {
+	if len(args) != 2 {
+		return false, errors.New("expected 2 arguments")
+	}
+
+	rid, ridError := strconv.Atoi(args.Pop())
+	if ridError != nil {
+		return false, ridError
+	}
+	fid, fidError := strconv.Atoi(args.Pop())
+	if fidError != nil {
+		return false, fidError
+	}
+
+	identity, identityError := authenticate(ctx, m.gophkeeper)
+	if identityError != nil {
+		return true, identityError
+	}
+
+	if err := identity.MoveResource(ctx, (gophkeeper.ResourceID)(rid), (gophkeeper.FolderID)(fid)); err != nil {
+		return true, err
+	}
+
+	fmt.Printf("Successfully moved resource (RID: %d) into folder (FID: %d).\n", rid, fid)
+
+	return true, nil
+}
@@ -0,0 +1,56 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/kerelape/gophkeeper/internal/stack"
+	"github.com/kerelape/gophkeeper/pkg/gophkeeper"
+)
+
+type moveFolderCommand struct {
+	gophkeeper gophkeeper.Gophkeeper
+}
+
+var _ command = (*moveFolderCommand)(nil)
+
+// Description implements command.
+func (m *moveFolderCommand) Description() string {
+	return "Move folder into another folder."
+}
+
+// Help implements command.
+func (m *moveFolderCommand) Help() string {
+	return "<FID: int> <parent FID: int>"
+}
+
+// Execute implements command.
+func (m *moveFolderCommand) Execute(ctx context.Context, args stack.Stack[string]) (bool, error) {
+	if len(args) != 2 {
+		return false, errors.New("expected 2 arguments")
+	}
+
+	fid, fidError := strconv.Atoi(args.Pop())
+	if fidError != nil {
+		return false, fidError
+	}
+	parent, parentError := strconv.Atoi(args.Pop())
+	if parentError != nil {
+		return false, parentError
+	}
+
+	identity, identityError := authenticate(ctx, m.gophkeeper)
+	if identityError != nil {
+		return true, identityError
+	}
+
+	if err := identity.MoveFolder(ctx, (gophkeeper.FolderID)(fid), (gophkeeper.FolderID)(parent)); err != nil {
+		return true, err
+	}
+
+	fmt.Printf("Successfully moved folder (FID: %d) into folder (FID: %d).\n", fid, parent)
+
+	return true, nil
+}
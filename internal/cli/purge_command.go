@@ -0,0 +1,52 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/kerelape/gophkeeper/internal/stack"
+	"github.com/kerelape/gophkeeper/pkg/gophkeeper"
+)
+
+type purgeCommand struct {
+	gophkeeper gophkeeper.Gophkeeper
+}
+
+var _ command = (*purgeCommand)(nil)
+
+// Description implements command.
+func (p *purgeCommand) Description() string {
+	return "Permanently delete resource from the trash."
+}
+
+// Help implements command.
+func (p *purgeCommand) Help() string {
+	return "<RID: int>"
+}
+
+// Execute implements command.
+func (p *purgeCommand) Execute(ctx context.Context, args stack.Stack[string]) (bool, error) {
+	if len(args) != 1 {
+		return false, errors.New("expected 1 arguments")
+	}
+
+	rid, ridError := strconv.Atoi(args.Pop())
+	if ridError != nil {
+		return false, ridError
+	}
+
+	identity, identityError := authenticate(ctx, p.gophkeeper)
+	if identityError != nil {
+		return true, identityError
+	}
+
+	if err := identity.Purge(ctx, (gophkeeper.ResourceID)(rid)); err != nil {
+		return true, err
+	}
+
+	fmt.Printf("Successfully purged resource (RID: %d).\n", rid)
+
+	return true, nil
+}
@@ -26,6 +26,16 @@ func (r *registerCommand) Execute(ctx context.Context, args stack.Stack[string])
 	if len(args) > 0 {
 		return false, errors.New("expected 0 arguments")
 	}
+
+	var keyFile []byte
+	if path := vaultCredentialOf(ctx).keyFile; path != "" {
+		content, contentError := readKeyFile(path)
+		if contentError != nil {
+			return true, contentError
+		}
+		keyFile = content
+	}
+
 	input := bufio.NewReader(os.Stdin)
 
 	fmt.Print("Type new identity's username: ")
@@ -35,31 +45,39 @@ func (r *registerCommand) Execute(ctx context.Context, args stack.Stack[string])
 	}
 	username = strings.TrimSuffix(username, "\n")
 
-	fmt.Print("Type new identity's password: ")
-	password1, password1Error := term.ReadPassword((int)(syscall.Stdin))
-	if password1Error != nil {
-		return true, password1Error
-	}
-
-	fmt.Println()
-	fmt.Print("Retype new identity's password: ")
-	password2, password2Error := term.ReadPassword((int)(syscall.Stdin))
-	if password2Error != nil {
-		return true, password1Error
+	password, passwordError := readNewPassword("new identity's password")
+	if passwordError != nil {
+		return true, passwordError
 	}
-	fmt.Println()
 
-	if !bytes.Equal(password1, password2) {
-		return true, errors.New("passwords do not match")
+	vaultPassword, vaultPasswordError := readNewPassword("new identity's vault password")
+	if vaultPasswordError != nil {
+		return true, vaultPasswordError
 	}
 
 	credential := gophkeeper.Credential{
-		Username: username,
-		Password: (string)(password1),
+		Username:      username,
+		Password:      password,
+		VaultPassword: vaultPassword,
 	}
 	if err := r.gophkeeper.Register(ctx, credential); err != nil {
 		return true, err
 	}
+	if keyFile == nil {
+		return true, nil
+	}
+
+	token, tokenError := r.gophkeeper.Authenticate(ctx, credential)
+	if tokenError != nil {
+		return true, tokenError
+	}
+	identity, identityError := r.gophkeeper.Identity(ctx, token)
+	if identityError != nil {
+		return true, identityError
+	}
+	if err := changeVaultKey(ctx, identity, vaultPassword, vaultPassword, keyFile); err != nil {
+		return true, fmt.Errorf("identity is registered without the key file: %w", err)
+	}
 	return true, nil
 }
 
@@ -70,5 +88,30 @@ func (r *registerCommand) Help() string {
 
 // Description implements command.
 func (*registerCommand) Description() string {
-	return "Register a new identity to gophkeeper."
+	return "Register a new identity to gophkeeper, the vault is opened with the key file if it is given."
+}
+
+// readNewPassword reads a new password typed twice.
+func readNewPassword(name string) (string, error) {
+	fmt.Printf("Type %s: ", name)
+	password1, password1Error := term.ReadPassword((int)(syscall.Stdin))
+	if password1Error != nil {
+		return "", password1Error
+	}
+	fmt.Println()
+
+	fmt.Printf("Retype %s: ", name)
+	password2, password2Error := term.ReadPassword((int)(syscall.Stdin))
+	if password2Error != nil {
+		return "", password2Error
+	}
+	fmt.Println()
+
+	if !bytes.Equal(password1, password2) {
+		return "", errors.New("passwords do not match")
+	}
+	if len(password1) == 0 {
+		return "", errors.New("password must not be empty")
+	}
+	return (string)(password1), nil
 }
@@ -0,0 +1,73 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/kerelape/gophkeeper/internal/stack"
+	"github.com/kerelape/gophkeeper/pkg/gophkeeper"
+)
+
+type replaceFileCommand struct {
+	gophkeeper gophkeeper.Gophkeeper
+}
+
+var _ command = (*replaceFileCommand)(nil)
+
+// Description implements command.
+func (r *replaceFileCommand) Description() string {
+	return "Replace a stored file keeping its RID."
+}
+
+// Help implements command.
+func (r *replaceFileCommand) Help() string {
+	return "[--no-compress] <RID: int> <path: string>"
+}
+
+// Execute implements command.
+func (r *replaceFileCommand) Execute(ctx context.Context, args stack.Stack[string]) (bool, error) {
+	ctx, args = withNoCompress(ctx, args)
+	if len(args) != 2 {
+		return false, errors.New("expected 2 arguments")
+	}
+
+	rid, ridError := strconv.Atoi(args.Pop())
+	if ridError != nil {
+		return false, ridError
+	}
+	path := args.Pop()
+
+	gophkeeperIdentity, gophkeeperIdentityError := authenticate(ctx, r.gophkeeper)
+	if gophkeeperIdentityError != nil {
+		return true, gophkeeperIdentityError
+	}
+
+	vaultPassword, vaultPasswordError := vaultPassword(ctx)
+	if vaultPasswordError != nil {
+		return true, vaultPasswordError
+	}
+
+	description, descriptionError := description(ctx)
+	if descriptionError != nil {
+		return true, descriptionError
+	}
+
+	var (
+		identity = identity{
+			origin: gophkeeperIdentity,
+		}
+		resource = fileResource{
+			description: description,
+			path:        path,
+		}
+	)
+	if err := identity.ReplaceFile(ctx, (gophkeeper.ResourceID)(rid), resource, vaultPassword); err != nil {
+		return true, err
+	}
+
+	fmt.Printf("Successfully replaced file (RID: %d).\n", rid)
+
+	return true, nil
+}
@@ -0,0 +1,61 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/kerelape/gophkeeper/internal/stack"
+	"github.com/kerelape/gophkeeper/pkg/gophkeeper"
+)
+
+type revertCommand struct {
+	gophkeeper gophkeeper.Gophkeeper
+}
+
+var _ command = (*revertCommand)(nil)
+
+// Description implements command.
+func (r *revertCommand) Description() string {
+	return "Revert a resource to one of its previous versions."
+}
+
+// Help implements command.
+func (r *revertCommand) Help() string {
+	return "<RID: int> <version: int>"
+}
+
+// Execute implements command.
+func (r *revertCommand) Execute(ctx context.Context, args stack.Stack[string]) (bool, error) {
+	if len(args) != 2 {
+		return false, errors.New("expected 2 arguments")
+	}
+
+	rid, ridError := strconv.Atoi(args.Pop())
+	if ridError != nil {
+		return false, ridError
+	}
+	version, versionError := strconv.Atoi(args.Pop())
+	if versionError != nil {
+		return false, versionError
+	}
+
+	identity, identityError := authenticate(ctx, r.gophkeeper)
+	if identityError != nil {
+		return true, identityError
+	}
+
+	vaultPassword, vaultPasswordError := vaultPassword(ctx)
+	if vaultPasswordError != nil {
+		return true, vaultPasswordError
+	}
+
+	if err := identity.RestoreVersion(ctx, (gophkeeper.ResourceID)(rid), version, vaultPassword); err != nil {
+		return true, err
+	}
+
+	fmt.Printf("Successfully reverted resource (RID: %d) to version %d.\n", rid, version)
+
+	return true, nil
+}
@@ -0,0 +1,56 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"github.com/kerelape/gophkeeper/internal/stack"
+	"github.com/kerelape/gophkeeper/pkg/gophkeeper"
+)
+
+type searchCommand struct {
+	gophkeeper gophkeeper.Gophkeeper
+}
+
+var _ command = (*searchCommand)(nil)
+
+// Description implements command.
+func (s *searchCommand) Description() string {
+	return "Search resources by the words of their descriptions and tags."
+}
+
+// Help implements command.
+func (s *searchCommand) Help() string {
+	return "<query: string>..."
+}
+
+// Execute implements command.
+func (s *searchCommand) Execute(ctx context.Context, args stack.Stack[string]) (bool, error) {
+	words := make([]string, 0, len(args))
+	for len(args) > 0 {
+		words = append(words, args.Pop())
+	}
+	query := strings.Join(words, " ")
+	if len(terms(query)) == 0 {
+		return false, errors.New("query must not be empty")
+	}
+
+	gophkeeperIdentity, identityError := authenticate(ctx, s.gophkeeper)
+	if identityError != nil {
+		return true, identityError
+	}
+	identity := identity{
+		origin: gophkeeperIdentity,
+	}
+	resources, resourcesError := identity.Search(ctx, query)
+	if resourcesError != nil {
+		return true, resourcesError
+	}
+	folders, foldersError := identity.Folders(ctx)
+	if foldersError != nil {
+		return true, foldersError
+	}
+	printResources(resources, folderPaths(folders))
+	return true, nil
+}
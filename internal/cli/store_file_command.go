@@ -22,11 +22,12 @@ func (s *storeFileCommand) Description() string {
 
 // Help implements command.
 func (s *storeFileCommand) Help() string {
-	return "<path: string>"
+	return "[--no-compress] <path: string>"
 }
 
 // Execute implements command.
 func (s *storeFileCommand) Execute(ctx context.Context, args stack.Stack[string]) (bool, error) {
+	ctx, args = withNoCompress(ctx, args)
 	if len(args) != 1 {
 		return false, errors.New("expected 1 argument")
 	}
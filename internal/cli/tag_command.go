@@ -0,0 +1,59 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/kerelape/gophkeeper/internal/stack"
+	"github.com/kerelape/gophkeeper/pkg/gophkeeper"
+)
+
+type tagCommand struct {
+	gophkeeper gophkeeper.Gophkeeper
+}
+
+var _ command = (*tagCommand)(nil)
+
+// Description implements command.
+func (t *tagCommand) Description() string {
+	return "Tag resource."
+}
+
+// Help implements command.
+func (t *tagCommand) Help() string {
+	return "<RID: int> <tag: string>"
+}
+
+// Execute implements command.
+func (t *tagCommand) Execute(ctx context.Context, args stack.Stack[string]) (bool, error) {
+	if len(args) != 2 {
+		return false, errors.New("expected 2 arguments")
+	}
+
+	rid, ridError := strconv.Atoi(args.Pop())
+	if ridError != nil {
+		return false, ridError
+	}
+	tag := args.Pop()
+	if tag == "" {
+		return false, errors.New("tag must not be empty")
+	}
+
+	gophkeeperIdentity, identityError := authenticate(ctx, t.gophkeeper)
+	if identityError != nil {
+		return true, identityError
+	}
+	identity := identity{
+		origin: gophkeeperIdentity,
+	}
+
+	if err := identity.Tag(ctx, (gophkeeper.ResourceID)(rid), tag); err != nil {
+		return true, err
+	}
+
+	fmt.Printf("Successfully tagged resource (RID: %d) with %q.\n", rid, tag)
+
+	return true, nil
+}
@@ -0,0 +1,57 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/kerelape/gophkeeper/internal/stack"
+	"github.com/kerelape/gophkeeper/pkg/gophkeeper"
+)
+
+type trashCommand struct {
+	gophkeeper gophkeeper.Gophkeeper
+}
+
+var _ command = (*trashCommand)(nil)
+
+// Description implements command.
+func (t *trashCommand) Description() string {
+	return "List out all resources in the trash."
+}
+
+// Help implements command.
+func (t *trashCommand) Help() string {
+	return ""
+}
+
+// Execute implements command.
+func (t *trashCommand) Execute(ctx context.Context, args stack.Stack[string]) (bool, error) {
+	if len(args) > 0 {
+		return false, errors.New("expected 0 arguments")
+	}
+	gophkeeperIdentity, identityError := authenticate(ctx, t.gophkeeper)
+	if identityError != nil {
+		return true, identityError
+	}
+	identity := identity{
+		origin: gophkeeperIdentity,
+	}
+	resources, resourcesError := identity.Trash(ctx)
+	if resourcesError != nil {
+		return true, resourcesError
+	}
+	fmt.Printf("%d resources found in the trash\n", len(resources))
+	for _, r := range resources {
+		fmt.Printf(
+			"(RID: %d)\n\tType: %s\n\tDescription: %s\n\tDeleted: %s\n",
+			r.RID,
+			r.Type.String(),
+			strings.ReplaceAll(r.Description, "\n", " "),
+			r.DeletedAt.Local().Format(time.DateTime),
+		)
+	}
+	return true, nil
+}
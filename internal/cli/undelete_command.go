@@ -0,0 +1,52 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/kerelape/gophkeeper/internal/stack"
+	"github.com/kerelape/gophkeeper/pkg/gophkeeper"
+)
+
+type undeleteCommand struct {
+	gophkeeper gophkeeper.Gophkeeper
+}
+
+var _ command = (*undeleteCommand)(nil)
+
+// Description implements command.
+func (u *undeleteCommand) Description() string {
+	return "Restore resource from the trash."
+}
+
+// Help implements command.
+func (u *undeleteCommand) Help() string {
+	return "<RID: int>"
+}
+
+// Execute implements command.
+func (u *undeleteCommand) Execute(ctx context.Context, args stack.Stack[string]) (bool, error) {
+	if len(args) != 1 {
+		return false, errors.New("expected 1 arguments")
+	}
+
+	rid, ridError := strconv.Atoi(args.Pop())
+	if ridError != nil {
+		return false, ridError
+	}
+
+	identity, identityError := authenticate(ctx, u.gophkeeper)
+	if identityError != nil {
+		return true, identityError
+	}
+
+	if err := identity.Undelete(ctx, (gophkeeper.ResourceID)(rid)); err != nil {
+		return true, err
+	}
+
+	fmt.Printf("Successfully restored resource (RID: %d) from the trash.\n", rid)
+
+	return true, nil
+}
@@ -0,0 +1,59 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/kerelape/gophkeeper/internal/stack"
+	"github.com/kerelape/gophkeeper/pkg/gophkeeper"
+)
+
+type untagCommand struct {
+	gophkeeper gophkeeper.Gophkeeper
+}
+
+var _ command = (*untagCommand)(nil)
+
+// Description implements command.
+func (u *untagCommand) Description() string {
+	return "Remove tag from resource."
+}
+
+// Help implements command.
+func (u *untagCommand) Help() string {
+	return "<RID: int> <tag: string>"
+}
+
+// Execute implements command.
+func (u *untagCommand) Execute(ctx context.Context, args stack.Stack[string]) (bool, error) {
+	if len(args) != 2 {
+		return false, errors.New("expected 2 arguments")
+	}
+
+	rid, ridError := strconv.Atoi(args.Pop())
+	if ridError != nil {
+		return false, ridError
+	}
+	tag := args.Pop()
+	if tag == "" {
+		return false, errors.New("tag must not be empty")
+	}
+
+	gophkeeperIdentity, identityError := authenticate(ctx, u.gophkeeper)
+	if identityError != nil {
+		return true, identityError
+	}
+	identity := identity{
+		origin: gophkeeperIdentity,
+	}
+
+	if err := identity.Untag(ctx, (gophkeeper.ResourceID)(rid), tag); err != nil {
+		return true, err
+	}
+
+	fmt.Printf("Successfully removed tag %q from resource (RID: %d).\n", tag, rid)
+
+	return true, nil
+}
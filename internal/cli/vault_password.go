@@ -7,9 +7,66 @@ import (
 	"github.com/charmbracelet/bubbles/textinput"
 	tea "github.com/charmbracelet/bubbletea"
 	"github.com/charmbracelet/lipgloss"
+	"github.com/kerelape/gophkeeper/pkg/gophkeeper"
 )
 
+type vaultPasswordKey struct{}
+
+// vaultCredential is what the vault is opened with.
+type vaultCredential struct {
+	password string
+
+	// keyFile is the path to the key file, if there is one.
+	keyFile string
+	// keyFileRequired tells that the vault
+	// can not be opened without a key file.
+	keyFileRequired bool
+
+	// unlocked is the identity unlocked with the password, if there is one.
+	unlocked gophkeeper.Identity
+}
+
+// errKeyFileRequired is returned when the vault password is asked for
+// but the vault needs a key file and there is none.
+var errKeyFileRequired = errors.New("the vault requires a key file, use --key-file")
+
+// withVaultPassword returns the context the vault password
+// is asked for only once, it is reused after that.
+//
+// The vault is opened with the key file as well if there is one.
+func withVaultPassword(ctx context.Context, keyFile string) context.Context {
+	return context.WithValue(ctx, vaultPasswordKey{}, &vaultCredential{keyFile: keyFile})
+}
+
+// lockVault revokes the vault session the vault
+// of the context is unlocked with, if there is one.
+func lockVault(ctx context.Context) error {
+	cached := vaultCredentialOf(ctx)
+	locker, ok := cached.unlocked.(gophkeeper.Locker)
+	cached.unlocked = nil
+	if !ok {
+		return nil
+	}
+	return locker.Lock(ctx)
+}
+
+// vaultCredentialOf returns the vault credential of the context.
+func vaultCredentialOf(ctx context.Context) *vaultCredential {
+	cached, ok := ctx.Value(vaultPasswordKey{}).(*vaultCredential)
+	if !ok {
+		return new(vaultCredential)
+	}
+	return cached
+}
+
 func vaultPassword(ctx context.Context) (string, error) {
+	cached := vaultCredentialOf(ctx)
+	if cached.keyFileRequired && cached.keyFile == "" {
+		return "", errKeyFileRequired
+	}
+	if cached.password != "" {
+		return cached.password, nil
+	}
 	m, err := tea.NewProgram(
 		newVaultPasswordModel(),
 		tea.WithAltScreen(),
@@ -25,6 +82,7 @@ func vaultPassword(ctx context.Context) (string, error) {
 	if model.cancelled {
 		return "", errors.New("vault password typing cancelled by user")
 	}
+	cached.password = model.password.Value()
 	return model.password.Value(), nil
 }
 
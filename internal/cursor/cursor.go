@@ -0,0 +1,85 @@
+// Package cursor provides opaque cursors for keyset
+// pagination of gophkeeper resources.
+package cursor
+
+import (
+	"encoding/base64"
+	"encoding/json"
+
+	"github.com/kerelape/gophkeeper/pkg/gophkeeper"
+)
+
+// Position is a position of a resource in a listing.
+//
+// Key is the value the listing is ordered by, the ResourceID
+// breaks ties between resources with equal keys.
+type Position struct {
+	Order      gophkeeper.ListOrder  `json:"o"`
+	Descending bool                  `json:"d"`
+	Key        int64                 `json:"k"`
+	ID         gophkeeper.ResourceID `json:"id"`
+}
+
+// Of returns position of the resource in a listing
+// ordered by the options.
+func Of(resource gophkeeper.Resource, options gophkeeper.ListOptions) Position {
+	return Position{
+		Order:      options.Order,
+		Descending: options.Descending,
+		Key:        Key(resource, options.Order),
+		ID:         resource.ID,
+	}
+}
+
+// Key returns the value the resource is ordered by.
+func Key(resource gophkeeper.Resource, order gophkeeper.ListOrder) int64 {
+	switch order {
+	case gophkeeper.ListOrderCreated:
+		return resource.CreatedAt.UnixMicro()
+	case gophkeeper.ListOrderUpdated:
+		return resource.UpdatedAt.UnixMicro()
+	case gophkeeper.ListOrderSize:
+		return resource.Size
+	default:
+		return (int64)(resource.ID)
+	}
+}
+
+// Before reports whether the position a goes before b.
+func Before(a, b Position) bool {
+	if a.Key == b.Key {
+		if a.Descending {
+			return a.ID > b.ID
+		}
+		return a.ID < b.ID
+	}
+	if a.Descending {
+		return a.Key > b.Key
+	}
+	return a.Key < b.Key
+}
+
+// Encode encodes the position into an opaque cursor.
+func Encode(position Position) string {
+	content, err := json.Marshal(position)
+	if err != nil {
+		panic(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(content)
+}
+
+// Decode decodes the cursor issued for a listing ordered by the options.
+func Decode(cursor string, options gophkeeper.ListOptions) (Position, error) {
+	content, contentError := base64.RawURLEncoding.DecodeString(cursor)
+	if contentError != nil {
+		return Position{}, gophkeeper.ErrInvalidCursor
+	}
+	var position Position
+	if err := json.Unmarshal(content, &position); err != nil {
+		return Position{}, gophkeeper.ErrInvalidCursor
+	}
+	if position.Order != options.Order || position.Descending != options.Descending {
+		return Position{}, gophkeeper.ErrInvalidCursor
+	}
+	return position, nil
+}
@@ -0,0 +1,301 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+	"io"
+
+	"github.com/kerelape/gophkeeper/internal/chunked"
+	"github.com/kerelape/gophkeeper/pkg/gophkeeper"
+)
+
+// The sealed content starts with the header:
+//
+//	atRestMarker | id length (1 byte) | master key ID | wrapped data key | nonce prefix
+//
+// The wrapped data key is the nonce followed by the data key sealed
+// with the master key. The content follows the header sealed with the
+// data key in chunks, see package chunked.
+const (
+	atRestDataKeyLen = 32
+	atRestNonceLen   = 12
+	atRestTagLen     = 16
+	atRestWrappedLen = atRestNonceLen + atRestDataKeyLen + atRestTagLen
+	atRestPrefixLen  = atRestNonceLen - chunked.NonceSuffixLen
+)
+
+// atRestMarker starts the content sealed by AtRest,
+// the content stored before is stored as is.
+var atRestMarker = ([]byte)("\x00gophkeeper-at-rest:1\x00")
+
+// AtRest encrypts the content the server stores with a data key
+// of its own, the data key is wrapped by the primary master key
+// of the Keys and stored along with the content.
+//
+// The master key is rotated by re-wrapping the data keys with the
+// new primary key, the content itself is not re-encrypted, see Rewrap.
+// The content stored before it is encrypted is opened as is, unless
+// RequireSealed is set.
+//
+// The zero AtRest stores the content as is.
+type AtRest struct {
+	Keys KeyProvider
+
+	// RequireSealed makes the content that is not sealed fail to open
+	// with gophkeeper.ErrIntegrity, so the content can't be planted
+	// in the storage bypassing the encryption. Set it once Rewrap has
+	// sealed all the content stored before.
+	RequireSealed bool
+}
+
+// errNotSealed is the error of the content that is not sealed
+// while it is required to be.
+var errNotSealed = errors.New("the content is not encrypted at rest")
+
+// atRestHeader is the header of the sealed content.
+type atRestHeader struct {
+	keyID   string
+	wrapped []byte
+	prefix  []byte
+}
+
+// Seal returns a writer that writes the content sealed to the origin,
+// the content is not complete until the writer is closed.
+func (a AtRest) Seal(ctx context.Context, origin io.Writer) (io.WriteCloser, error) {
+	if a.Keys == nil {
+		return nopWriteCloser{origin}, nil
+	}
+	keyID, masterKey, keyError := a.Keys.PrimaryKey(ctx)
+	if keyError != nil {
+		return nil, keyError
+	}
+	dataKey := make([]byte, atRestDataKeyLen)
+	if _, err := rand.Read(dataKey); err != nil {
+		return nil, err
+	}
+	wrapped, wrapError := wrapDataKey(masterKey, keyID, dataKey)
+	if wrapError != nil {
+		return nil, wrapError
+	}
+	aead, aeadError := atRestAEAD(dataKey)
+	if aeadError != nil {
+		return nil, aeadError
+	}
+	prefix, prefixError := chunked.NewNoncePrefix(aead)
+	if prefixError != nil {
+		return nil, prefixError
+	}
+	header := atRestHeader{keyID: keyID, wrapped: wrapped, prefix: prefix}
+	if _, err := origin.Write(header.bytes()); err != nil {
+		return nil, err
+	}
+	return chunked.NewSealingWriter(aead, prefix, origin), nil
+}
+
+// Open returns a reader of the content sealed by Seal, the content that
+// is not sealed is read as is unless RequireSealed is set. It fails with
+// gophkeeper.ErrIntegrity if the content is not intact.
+func (a AtRest) Open(ctx context.Context, origin io.Reader) (io.Reader, error) {
+	buffered := bufio.NewReader(origin)
+	header, sealed, headerError := readAtRestHeader(buffered)
+	if headerError != nil {
+		return nil, headerError
+	}
+	if !sealed {
+		if a.RequireSealed {
+			return nil, errors.Join(gophkeeper.ErrIntegrity, errNotSealed)
+		}
+		return buffered, nil
+	}
+	if a.Keys == nil {
+		return nil, ErrUnknownMasterKey
+	}
+	masterKey, keyError := a.Keys.Key(ctx, header.keyID)
+	if keyError != nil {
+		return nil, keyError
+	}
+	dataKey, unwrapError := unwrapDataKey(masterKey, header.keyID, header.wrapped)
+	if unwrapError != nil {
+		return nil, unwrapError
+	}
+	aead, aeadError := atRestAEAD(dataKey)
+	if aeadError != nil {
+		return nil, aeadError
+	}
+	return chunked.NewOpeningReader(aead, header.prefix, buffered), nil
+}
+
+// SealBytes returns the content sealed as it is by Seal.
+func (a AtRest) SealBytes(ctx context.Context, content []byte) ([]byte, error) {
+	if a.Keys == nil {
+		return content, nil
+	}
+	var sealed bytes.Buffer
+	writer, writerError := a.Seal(ctx, &sealed)
+	if writerError != nil {
+		return nil, writerError
+	}
+	if _, err := writer.Write(content); err != nil {
+		return nil, err
+	}
+	if err := writer.Close(); err != nil {
+		return nil, err
+	}
+	return sealed.Bytes(), nil
+}
+
+// OpenBytes returns the content opened as it is by Open.
+func (a AtRest) OpenBytes(ctx context.Context, content []byte) ([]byte, error) {
+	if !bytes.HasPrefix(content, atRestMarker) && !a.RequireSealed {
+		return content, nil
+	}
+	reader, readerError := a.Open(ctx, bytes.NewReader(content))
+	if readerError != nil {
+		return nil, readerError
+	}
+	return io.ReadAll(reader)
+}
+
+// Rewrap writes the content read from the origin to the target with its
+// data key wrapped by the primary master key, the content that is not
+// sealed is sealed. It returns false and writes nothing if the content
+// is sealed with the primary key already.
+func (a AtRest) Rewrap(ctx context.Context, origin io.Reader, target io.Writer) (bool, error) {
+	if a.Keys == nil {
+		return false, nil
+	}
+	buffered := bufio.NewReader(origin)
+	header, sealed, headerError := readAtRestHeader(buffered)
+	if headerError != nil {
+		return false, headerError
+	}
+	if !sealed {
+		writer, writerError := a.Seal(ctx, target)
+		if writerError != nil {
+			return false, writerError
+		}
+		if _, err := io.Copy(writer, buffered); err != nil {
+			return false, err
+		}
+		return true, writer.Close()
+	}
+
+	keyID, primaryKey, primaryKeyError := a.Keys.PrimaryKey(ctx)
+	if primaryKeyError != nil {
+		return false, primaryKeyError
+	}
+	if header.keyID == keyID {
+		return false, nil
+	}
+	masterKey, keyError := a.Keys.Key(ctx, header.keyID)
+	if keyError != nil {
+		return false, keyError
+	}
+	dataKey, unwrapError := unwrapDataKey(masterKey, header.keyID, header.wrapped)
+	if unwrapError != nil {
+		return false, unwrapError
+	}
+	wrapped, wrapError := wrapDataKey(primaryKey, keyID, dataKey)
+	if wrapError != nil {
+		return false, wrapError
+	}
+	header.keyID, header.wrapped = keyID, wrapped
+	if _, err := target.Write(header.bytes()); err != nil {
+		return false, err
+	}
+	if _, err := io.Copy(target, buffered); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// bytes returns the header as it is stored.
+func (h atRestHeader) bytes() []byte {
+	header := append([]byte{}, atRestMarker...)
+	header = append(header, (byte)(len(h.keyID)))
+	header = append(header, h.keyID...)
+	header = append(header, h.wrapped...)
+	return append(header, h.prefix...)
+}
+
+// readAtRestHeader reads the header of the sealed content,
+// it returns false and reads nothing if the content is not sealed.
+func readAtRestHeader(origin *bufio.Reader) (atRestHeader, bool, error) {
+	marker, peekError := origin.Peek(len(atRestMarker))
+	if peekError != nil && !errors.Is(peekError, io.EOF) {
+		return atRestHeader{}, false, peekError
+	}
+	if !bytes.Equal(marker, atRestMarker) {
+		return atRestHeader{}, false, nil
+	}
+	if _, err := origin.Discard(len(atRestMarker)); err != nil {
+		return atRestHeader{}, false, err
+	}
+
+	idLen, idLenError := origin.ReadByte()
+	if idLenError != nil {
+		return atRestHeader{}, false, errors.Join(gophkeeper.ErrIntegrity, idLenError)
+	}
+	rest := make([]byte, (int)(idLen)+atRestWrappedLen+atRestPrefixLen)
+	if _, err := io.ReadFull(origin, rest); err != nil {
+		return atRestHeader{}, false, errors.Join(gophkeeper.ErrIntegrity, err)
+	}
+	header := atRestHeader{
+		keyID:   (string)(rest[:idLen]),
+		wrapped: rest[idLen : (int)(idLen)+atRestWrappedLen],
+		prefix:  rest[(int)(idLen)+atRestWrappedLen:],
+	}
+	return header, true, nil
+}
+
+// wrapDataKey seals the data key with the master key,
+// the ID of the master key is authenticated along with it.
+func wrapDataKey(masterKey []byte, keyID string, dataKey []byte) ([]byte, error) {
+	aead, aeadError := atRestAEAD(masterKey)
+	if aeadError != nil {
+		return nil, aeadError
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, dataKey, ([]byte)(keyID)), nil
+}
+
+// unwrapDataKey opens the data key sealed by wrapDataKey.
+func unwrapDataKey(masterKey []byte, keyID string, wrapped []byte) ([]byte, error) {
+	aead, aeadError := atRestAEAD(masterKey)
+	if aeadError != nil {
+		return nil, aeadError
+	}
+	nonce, sealed := wrapped[:aead.NonceSize()], wrapped[aead.NonceSize():]
+	dataKey, openError := aead.Open(nil, nonce, sealed, ([]byte)(keyID))
+	if openError != nil {
+		return nil, errors.Join(gophkeeper.ErrIntegrity, openError)
+	}
+	return dataKey, nil
+}
+
+// atRestAEAD returns AES-256-GCM keyed with the key.
+func atRestAEAD(key []byte) (cipher.AEAD, error) {
+	block, blockError := aes.NewCipher(key)
+	if blockError != nil {
+		return nil, blockError
+	}
+	return cipher.NewGCM(block)
+}
+
+// nopWriteCloser is a writer with a Close that does nothing.
+type nopWriteCloser struct {
+	io.Writer
+}
+
+// Close implements io.Closer.
+func (nopWriteCloser) Close() error {
+	return nil
+}
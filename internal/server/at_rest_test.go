@@ -0,0 +1,106 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/kerelape/gophkeeper/internal/chunked"
+	"github.com/kerelape/gophkeeper/pkg/gophkeeper"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAtRest(t *testing.T) {
+	var (
+		keyFile = path.Join(t.TempDir(), "keys")
+		atRest  = AtRest{Keys: NewFileKeyProvider(keyFile)}
+	)
+	assert.Nil(t, os.WriteFile(keyFile, ([]byte)(testMasterKey("old", 'o')), 0o600), "did not expect an error")
+
+	for _, size := range []int{0, 1, chunked.Size - 1, chunked.Size, 3*chunked.Size + 7} {
+		content := bytes.Repeat(([]byte)("a"), size)
+		sealed, sealError := atRest.SealBytes(context.Background(), content)
+		assert.Nil(t, sealError, "did not expect an error")
+		assert.NotContains(t, (string)(sealed), strings.Repeat("a", 16), "expected the content to be encrypted")
+		opened, openError := atRest.OpenBytes(context.Background(), sealed)
+		assert.Nil(t, openError, "did not expect an error")
+		assert.Equal(t, content, opened, "unexpected content of size %d", size)
+	}
+
+	sealed, sealError := atRest.SealBytes(context.Background(), ([]byte)("content"))
+	assert.Nil(t, sealError, "did not expect an error")
+
+	t.Run("Not sealed", func(t *testing.T) {
+		opened, openError := atRest.OpenBytes(context.Background(), ([]byte)("stored as is"))
+		assert.Nil(t, openError, "did not expect an error")
+		assert.Equal(t, "stored as is", (string)(opened), "expected the content to be read as is")
+
+		reader, readerError := atRest.Open(context.Background(), strings.NewReader("stored as is"))
+		assert.Nil(t, readerError, "did not expect an error")
+		opened, readError := io.ReadAll(reader)
+		assert.Nil(t, readError, "did not expect an error")
+		assert.Equal(t, "stored as is", (string)(opened), "expected the content to be read as is")
+
+		zero, zeroError := AtRest{}.SealBytes(context.Background(), ([]byte)("content"))
+		assert.Nil(t, zeroError, "did not expect an error")
+		assert.Equal(t, "content", (string)(zero), "expected the zero AtRest to store the content as is")
+		_, unknownError := AtRest{}.OpenBytes(context.Background(), sealed)
+		assert.ErrorIs(t, unknownError, ErrUnknownMasterKey, "unexpected error")
+
+		required := AtRest{Keys: atRest.Keys, RequireSealed: true}
+		_, requiredError := required.OpenBytes(context.Background(), ([]byte)("stored as is"))
+		assert.ErrorIs(t, requiredError, gophkeeper.ErrIntegrity, "expected the content not sealed to be refused")
+		_, requiredError = required.Open(context.Background(), strings.NewReader("stored as is"))
+		assert.ErrorIs(t, requiredError, gophkeeper.ErrIntegrity, "expected the content not sealed to be refused")
+		opened, openError = required.OpenBytes(context.Background(), sealed)
+		assert.Nil(t, openError, "did not expect an error")
+		assert.Equal(t, "content", (string)(opened), "expected the sealed content to be read")
+	})
+
+	t.Run("Tampered", func(t *testing.T) {
+		tampered := bytes.Clone(sealed)
+		tampered[len(tampered)-1] ^= 1
+		_, tamperedError := atRest.OpenBytes(context.Background(), tampered)
+		assert.ErrorIs(t, tamperedError, gophkeeper.ErrIntegrity, "unexpected error")
+
+		_, truncatedError := atRest.OpenBytes(context.Background(), sealed[:len(atRestMarker)+2])
+		assert.ErrorIs(t, truncatedError, gophkeeper.ErrIntegrity, "unexpected error")
+	})
+
+	t.Run("Rewrap", func(t *testing.T) {
+		var target bytes.Buffer
+		rewrapped, rewrapError := atRest.Rewrap(context.Background(), bytes.NewReader(sealed), &target)
+		assert.Nil(t, rewrapError, "did not expect an error")
+		assert.False(t, rewrapped, "expected the content sealed with the primary key to be kept")
+		assert.Zero(t, target.Len(), "expected nothing to be written")
+
+		rotated := testMasterKey("new", 'n') + "\n" + testMasterKey("old", 'o')
+		assert.Nil(t, os.WriteFile(keyFile, ([]byte)(rotated), 0o600), "did not expect an error")
+		atRest := AtRest{Keys: NewFileKeyProvider(keyFile)}
+
+		rewrapped, rewrapError = atRest.Rewrap(context.Background(), bytes.NewReader(sealed), &target)
+		assert.Nil(t, rewrapError, "did not expect an error")
+		assert.True(t, rewrapped, "expected the data key to be re-wrapped")
+		assert.Equal(t, len(sealed)+len("new")-len("old"), target.Len(), "expected the content to be kept")
+
+		assert.Nil(t, os.WriteFile(keyFile, ([]byte)(testMasterKey("new", 'n')), 0o600), "did not expect an error")
+		atRest = AtRest{Keys: NewFileKeyProvider(keyFile)}
+		opened, openError := atRest.OpenBytes(context.Background(), target.Bytes())
+		assert.Nil(t, openError, "expected the old key to be no longer needed")
+		assert.Equal(t, "content", (string)(opened), "unexpected content")
+		_, oldError := atRest.OpenBytes(context.Background(), sealed)
+		assert.ErrorIs(t, oldError, ErrUnknownMasterKey, "unexpected error")
+
+		target.Reset()
+		rewrapped, rewrapError = atRest.Rewrap(context.Background(), strings.NewReader("stored as is"), &target)
+		assert.Nil(t, rewrapError, "did not expect an error")
+		assert.True(t, rewrapped, "expected the content stored as is to be sealed")
+		opened, openError = atRest.OpenBytes(context.Background(), target.Bytes())
+		assert.Nil(t, openError, "did not expect an error")
+		assert.Equal(t, "stored as is", (string)(opened), "unexpected content")
+	})
+}
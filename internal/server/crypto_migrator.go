@@ -0,0 +1,128 @@
+package server
+
+import (
+	"context"
+	"errors"
+
+	"github.com/kerelape/gophkeeper/pkg/gophkeeper"
+)
+
+type (
+	// Envelope is the stored meta of a state of a resource.
+	Envelope struct {
+		Owner    string
+		Resource gophkeeper.ResourceID
+		Version  int // 0 is the current state of the resource.
+		Meta     string
+	}
+
+	// EnvelopeRewrite replaces the meta of the envelope.
+	EnvelopeRewrite struct {
+		Envelope Envelope
+		Meta     string
+	}
+
+	// EnvelopeStore is a storage of the envelopes of every identity.
+	EnvelopeStore interface {
+		// Envelopes returns up to limit envelopes that follow the after
+		// ordered by resource and version, the zero Envelope precedes
+		// all of them.
+		Envelopes(ctx context.Context, after Envelope, limit int) ([]Envelope, error)
+
+		// RewriteEnvelopes applies the rewrites all at once. An envelope
+		// changed since it was read is not rewritten. It returns the number
+		// of the envelopes that are rewritten.
+		RewriteEnvelopes(ctx context.Context, rewrites []EnvelopeRewrite) (int, error)
+	}
+
+	// CryptoMigrationFailure is an envelope that failed to be upgraded.
+	CryptoMigrationFailure struct {
+		Envelope Envelope
+		Err      error
+	}
+
+	// CryptoMigrationReport is the outcome of a crypto migration.
+	CryptoMigrationReport struct {
+		Scanned  int
+		Upgraded int
+		Failures []CryptoMigrationFailure
+	}
+)
+
+// ErrInvalidBatchSize is returned when the batch size is not positive.
+var ErrInvalidBatchSize = errors.New("batch size must be positive")
+
+// CryptoMigrator upgrades the envelopes of every identity's
+// resources to the newest envelope version.
+type CryptoMigrator struct {
+	Store EnvelopeStore
+
+	// Upgrade returns the meta in the newest envelope version,
+	// the meta already in it must be returned as is.
+	Upgrade func(meta string) (string, error)
+
+	BatchSize int
+
+	// Batch, if set, is called after every batch is rewritten
+	// with the last envelope of the batch and its failures.
+	// The migration stops if it returns an error.
+	Batch func(last Envelope, failures []CryptoMigrationFailure) error
+}
+
+// Migrate upgrades the envelopes that follow the after batch by batch.
+//
+// The envelopes already upgraded are not rewritten, thus an interrupted
+// migration is resumed by migrating again, either from the beginning or
+// from the last envelope of the last batch. The envelopes that fail to be
+// upgraded are reported and do not stop the migration.
+func (m CryptoMigrator) Migrate(ctx context.Context, after Envelope) (CryptoMigrationReport, error) {
+	if m.BatchSize <= 0 {
+		return CryptoMigrationReport{}, ErrInvalidBatchSize
+	}
+	report := CryptoMigrationReport{
+		Failures: make([]CryptoMigrationFailure, 0),
+	}
+	for {
+		envelopes, envelopesError := m.Store.Envelopes(ctx, after, m.BatchSize)
+		if envelopesError != nil {
+			return report, envelopesError
+		}
+		if len(envelopes) == 0 {
+			return report, nil
+		}
+
+		var (
+			rewrites = make([]EnvelopeRewrite, 0, len(envelopes))
+			failures = make([]CryptoMigrationFailure, 0)
+		)
+		for _, envelope := range envelopes {
+			upgraded, upgradeError := m.Upgrade(envelope.Meta)
+			if upgradeError != nil {
+				failures = append(failures, CryptoMigrationFailure{Envelope: envelope, Err: upgradeError})
+				continue
+			}
+			if upgraded != envelope.Meta {
+				rewrites = append(rewrites, EnvelopeRewrite{Envelope: envelope, Meta: upgraded})
+			}
+		}
+		if len(rewrites) > 0 {
+			rewritten, rewriteError := m.Store.RewriteEnvelopes(ctx, rewrites)
+			if rewriteError != nil {
+				return report, rewriteError
+			}
+			report.Upgraded += rewritten
+		}
+		report.Scanned += len(envelopes)
+		report.Failures = append(report.Failures, failures...)
+
+		after = envelopes[len(envelopes)-1]
+		if m.Batch != nil {
+			if err := m.Batch(after, failures); err != nil {
+				return report, err
+			}
+		}
+		if len(envelopes) < m.BatchSize {
+			return report, nil
+		}
+	}
+}
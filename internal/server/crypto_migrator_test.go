@@ -0,0 +1,120 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/kerelape/gophkeeper/pkg/gophkeeper"
+	"github.com/stretchr/testify/assert"
+)
+
+type envelopeStore struct {
+	envelopes []Envelope
+	batches   int
+}
+
+func (s *envelopeStore) Envelopes(_ context.Context, after Envelope, limit int) ([]Envelope, error) {
+	envelopes := make([]Envelope, 0, limit)
+	for _, envelope := range s.envelopes {
+		if envelope.Resource < after.Resource ||
+			envelope.Resource == after.Resource && envelope.Version <= after.Version {
+			continue
+		}
+		if len(envelopes) == limit {
+			break
+		}
+		envelopes = append(envelopes, envelope)
+	}
+	return envelopes, nil
+}
+
+func (s *envelopeStore) RewriteEnvelopes(_ context.Context, rewrites []EnvelopeRewrite) (int, error) {
+	s.batches++
+	rewritten := 0
+	for _, rewrite := range rewrites {
+		for n, envelope := range s.envelopes {
+			if envelope.Resource == rewrite.Envelope.Resource &&
+				envelope.Version == rewrite.Envelope.Version &&
+				envelope.Meta == rewrite.Envelope.Meta {
+				s.envelopes[n].Meta = rewrite.Meta
+				rewritten++
+			}
+		}
+	}
+	return rewritten, nil
+}
+
+func TestCryptoMigrator(t *testing.T) {
+	var (
+		errMalformed = errors.New("malformed")
+		upgrade      = func(meta string) (string, error) {
+			switch {
+			case strings.HasPrefix(meta, "v2:"):
+				return meta, nil
+			case strings.HasPrefix(meta, "v1:"):
+				return "v2:" + strings.TrimPrefix(meta, "v1:"), nil
+			default:
+				return "", errMalformed
+			}
+		}
+		store = &envelopeStore{
+			envelopes: []Envelope{
+				{Owner: "a", Resource: 1, Version: 0, Meta: "v1:a"},
+				{Owner: "a", Resource: 1, Version: 1, Meta: "v1:b"},
+				{Owner: "a", Resource: 2, Version: 0, Meta: "v2:c"},
+				{Owner: "b", Resource: 3, Version: 0, Meta: "plain"},
+				{Owner: "b", Resource: 4, Version: 0, Meta: "v1:d"},
+			},
+		}
+		lasts     = make([]gophkeeper.ResourceID, 0)
+		migration = CryptoMigrator{
+			Store:     store,
+			Upgrade:   upgrade,
+			BatchSize: 2,
+			Batch: func(last Envelope, _ []CryptoMigrationFailure) error {
+				lasts = append(lasts, last.Resource)
+				return nil
+			},
+		}
+	)
+
+	report, migrateError := migration.Migrate(context.Background(), Envelope{})
+	assert.Nil(t, migrateError, "did not expect an error")
+	assert.Equal(t, 5, report.Scanned, "unexpected number of scanned envelopes")
+	assert.Equal(t, 3, report.Upgraded, "unexpected number of upgraded envelopes")
+	if assert.Len(t, report.Failures, 1, "expected a failure") {
+		assert.Equal(t, (gophkeeper.ResourceID)(3), report.Failures[0].Envelope.Resource, "unexpected failure")
+		assert.ErrorIs(t, report.Failures[0].Err, errMalformed, "unexpected error")
+	}
+	assert.Equal(t, []gophkeeper.ResourceID{1, 3, 4}, lasts, "unexpected batches")
+	for _, envelope := range store.envelopes {
+		if envelope.Resource != 3 {
+			assert.True(t, strings.HasPrefix(envelope.Meta, "v2:"), "expected the envelope to be upgraded")
+		}
+	}
+
+	// Migrating again rewrites nothing.
+	batches := store.batches
+	report, migrateError = migration.Migrate(context.Background(), Envelope{})
+	assert.Nil(t, migrateError, "did not expect an error")
+	assert.Equal(t, 0, report.Upgraded, "expected nothing to be upgraded")
+	assert.Equal(t, batches, store.batches, "expected nothing to be rewritten")
+
+	// Resuming from an envelope skips the envelopes before it.
+	store.envelopes[4].Meta = "v1:e"
+	report, migrateError = migration.Migrate(context.Background(), Envelope{Resource: 3})
+	assert.Nil(t, migrateError, "did not expect an error")
+	assert.Equal(t, 1, report.Scanned, "unexpected number of scanned envelopes")
+	assert.Equal(t, 1, report.Upgraded, "unexpected number of upgraded envelopes")
+
+	stopError := errors.New("stop")
+	migration.Batch = func(Envelope, []CryptoMigrationFailure) error { return stopError }
+	_, migrateError = migration.Migrate(context.Background(), Envelope{})
+	assert.ErrorIs(t, migrateError, stopError, "expected the migration to stop")
+
+	migration.BatchSize = 0
+	_, migrateError = migration.Migrate(context.Background(), Envelope{})
+	assert.ErrorIs(t, migrateError, ErrInvalidBatchSize, "unexpected error")
+}
@@ -0,0 +1,48 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// EnvKeyProvider is a KeyProvider that reads the master keys from the
+// environment variable, "<id>:<base64 key>" entries separated by commas,
+// the first key is the primary one.
+//
+// The environment of a running server does not change, the master key is
+// rotated by restarting the servers one by one with the new key in front
+// of the old ones.
+type EnvKeyProvider struct {
+	Variable string
+}
+
+var _ KeyProvider = EnvKeyProvider{}
+
+// PrimaryKey implements KeyProvider.
+func (p EnvKeyProvider) PrimaryKey(_ context.Context) (string, []byte, error) {
+	keys, keysError := p.read()
+	if keysError != nil {
+		return "", nil, keysError
+	}
+	return keys.primaryKey()
+}
+
+// Key implements KeyProvider.
+func (p EnvKeyProvider) Key(_ context.Context, id string) ([]byte, error) {
+	keys, keysError := p.read()
+	if keysError != nil {
+		return nil, keysError
+	}
+	return keys.key(id)
+}
+
+// read returns the keys of the variable.
+func (p EnvKeyProvider) read() (masterKeys, error) {
+	value, ok := os.LookupEnv(p.Variable)
+	if !ok {
+		return masterKeys{}, fmt.Errorf("%s is not set", p.Variable)
+	}
+	return parseMasterKeys(strings.Split(value, ","))
+}
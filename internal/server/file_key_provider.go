@@ -0,0 +1,79 @@
+package server
+
+import (
+	"context"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// FileKeyProvider is a KeyProvider that reads the master keys from
+// a file, a "<id>:<base64 key>" line for every key, the first key is
+// the primary one. Empty lines and lines starting with # are skipped.
+//
+// The file is read again once it is modified, so the master key is
+// rotated while the server is running by putting the new key on top
+// and keeping the old ones until the content is re-wrapped.
+type FileKeyProvider struct {
+	path string
+
+	mutex   sync.Mutex
+	modTime time.Time
+	size    int64
+	keys    masterKeys
+}
+
+var _ KeyProvider = (*FileKeyProvider)(nil)
+
+// NewFileKeyProvider returns a new FileKeyProvider
+// of the master keys in the file at the path.
+func NewFileKeyProvider(path string) *FileKeyProvider {
+	return &FileKeyProvider{
+		path: path,
+	}
+}
+
+// PrimaryKey implements KeyProvider.
+func (p *FileKeyProvider) PrimaryKey(_ context.Context) (string, []byte, error) {
+	keys, keysError := p.read()
+	if keysError != nil {
+		return "", nil, keysError
+	}
+	return keys.primaryKey()
+}
+
+// Key implements KeyProvider.
+func (p *FileKeyProvider) Key(_ context.Context, id string) ([]byte, error) {
+	keys, keysError := p.read()
+	if keysError != nil {
+		return nil, keysError
+	}
+	return keys.key(id)
+}
+
+// read returns the keys of the file,
+// the file is parsed again only if it is modified.
+func (p *FileKeyProvider) read() (masterKeys, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	info, statError := os.Stat(p.path)
+	if statError != nil {
+		return masterKeys{}, statError
+	}
+	if p.keys.primary != "" && info.ModTime().Equal(p.modTime) && info.Size() == p.size {
+		return p.keys, nil
+	}
+
+	content, readError := os.ReadFile(p.path)
+	if readError != nil {
+		return masterKeys{}, readError
+	}
+	keys, parseError := parseMasterKeys(strings.Split((string)(content), "\n"))
+	if parseError != nil {
+		return masterKeys{}, parseError
+	}
+	p.keys, p.modTime, p.size = keys, info.ModTime(), info.Size()
+	return keys, nil
+}
@@ -0,0 +1,135 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/kerelape/gophkeeper/internal/blobstore"
+)
+
+// FsckProblemKind is the kind of an inconsistency of the storage.
+type FsckProblemKind string
+
+const (
+	// FsckOrphanFile is a blob file no row references.
+	FsckOrphanFile FsckProblemKind = "orphan-file"
+
+	// FsckMissingFile is a row that references a blob file
+	// that does not exist.
+	FsckMissingFile FsckProblemKind = "missing-file"
+
+	// FsckOrphanRow is a row of a piece, a blob or a version
+	// no resource references.
+	FsckOrphanRow FsckProblemKind = "orphan-row"
+
+	// FsckDanglingRow is a resource that references
+	// a piece or a blob that does not exist.
+	FsckDanglingRow FsckProblemKind = "dangling-row"
+
+	// FsckTypeMismatch is a resource of an unknown type or a resource
+	// that references the content of a type other than its own.
+	FsckTypeMismatch FsckProblemKind = "type-mismatch"
+)
+
+// DefaultFsckGrace is the grace of a check that is not given one.
+const DefaultFsckGrace = time.Hour
+
+type (
+	// FsckProblem is an inconsistency found by a check.
+	FsckProblem struct {
+		Kind FsckProblemKind
+
+		// Table and ID identify the row of the problem, Table is
+		// empty if the problem is of a file with no row.
+		Table string
+		ID    int64
+
+		// Location is the blob file of the problem, if there is one.
+		Location string
+
+		Detail string
+
+		// Quarantined is set if the problem is repaired by moving
+		// the row or the file to the quarantine.
+		Quarantined bool
+	}
+
+	// FsckOptions are the options of a check.
+	FsckOptions struct {
+		// Repair moves the orphans and the rows that cannot
+		// be restored to the quarantine.
+		Repair bool
+
+		// Grace is how old a blob file must be to be reported as
+		// an orphan, a file being stored is not referenced yet.
+		Grace time.Duration
+	}
+
+	// FsckReport is the outcome of a check.
+	FsckReport struct {
+		Resources int
+		Pieces    int
+		Blobs     int
+		Files     int
+		Problems  []FsckProblem
+	}
+
+	// Checker checks the storage for inconsistencies.
+	Checker interface {
+		// Fsck cross-checks the resources, their content and
+		// the blob files, and repairs them if asked to.
+		Fsck(ctx context.Context, options FsckOptions) (FsckReport, error)
+	}
+)
+
+// String returns a human readable representation of the problem.
+func (p FsckProblem) String() string {
+	var b strings.Builder
+	b.WriteString((string)(p.Kind))
+	if p.Table != "" {
+		fmt.Fprintf(&b, " %s %d", p.Table, p.ID)
+	}
+	if p.Location != "" {
+		fmt.Fprintf(&b, " %s", p.Location)
+	}
+	if p.Detail != "" {
+		fmt.Fprintf(&b, ": %s", p.Detail)
+	}
+	if p.Quarantined {
+		b.WriteString(" (quarantined)")
+	}
+	return b.String()
+}
+
+// QuarantineDir is the directory next to a blob
+// the blob is moved to once it is quarantined.
+const QuarantineDir = "quarantine"
+
+// Quarantine is where the blobs found to be inconsistent
+// are moved to instead of being deleted.
+type Quarantine struct {
+	Store blobstore.BlobStore
+}
+
+// Move moves the blob to the quarantine keeping its name, a blob
+// with the same name quarantined before is kept as well.
+// It returns blobstore.ErrNotFound if there is no such blob.
+func (q Quarantine) Move(ctx context.Context, key string) error {
+	content, openError := blobstore.Open(ctx, q.Store, key)
+	if openError != nil {
+		return openError
+	}
+	defer content.Close()
+
+	target := path.Join(path.Dir(key), QuarantineDir, path.Base(key))
+	if _, err := q.Store.Stat(ctx, target); err == nil {
+		target = fmt.Sprintf("%s.%d", target, time.Now().UnixNano())
+	}
+	if _, err := q.Store.Put(ctx, target, content); err != nil {
+		return err
+	}
+	return q.Store.Delete(ctx, key)
+}
@@ -0,0 +1,56 @@
+package server
+
+import (
+	"context"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/kerelape/gophkeeper/internal/blobstore"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFsckProblem(t *testing.T) {
+	problem := FsckProblem{
+		Kind:        FsckMissingFile,
+		Table:       "blobs",
+		ID:          4,
+		Location:    "/blobs/f",
+		Detail:      "gone",
+		Quarantined: true,
+	}
+	assert.Equal(t, "missing-file blobs 4 /blobs/f: gone (quarantined)", problem.String(), "unexpected representation")
+	problem = FsckProblem{Kind: FsckOrphanFile, Location: "/blobs/f"}
+	assert.Equal(t, "orphan-file /blobs/f", problem.String(), "unexpected representation")
+}
+
+func TestQuarantine(t *testing.T) {
+	var (
+		dir        = t.TempDir()
+		store      = &blobstore.FS{Dir: dir}
+		quarantine = Quarantine{Store: store}
+		location   = path.Join(dir, "f")
+	)
+
+	for _, content := range []string{"first", "second"} {
+		assert.Nil(t, os.WriteFile(location, ([]byte)(content), 0o600), "did not expect an error")
+		assert.Nil(t, quarantine.Move(context.Background(), location), "expected the file to be quarantined")
+	}
+	assert.ErrorIs(
+		t,
+		quarantine.Move(context.Background(), location),
+		blobstore.ErrNotFound,
+		"expected a missing file not to be quarantined",
+	)
+
+	files, listError := store.List(context.Background())
+	assert.Nil(t, listError, "did not expect an error")
+	assert.Empty(t, files, "expected the files to be moved")
+	quarantined, readError := os.ReadDir(path.Join(dir, QuarantineDir))
+	assert.Nil(t, readError, "did not expect an error")
+	if assert.Len(t, quarantined, 2, "expected both of the files to be kept") {
+		content, contentError := os.ReadFile(path.Join(dir, QuarantineDir, "f"))
+		assert.Nil(t, contentError, "did not expect an error")
+		assert.Equal(t, "first", (string)(content), "expected the first file to keep its name")
+	}
+}
@@ -0,0 +1,96 @@
+package server
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// KeyProvider provides the master keys the server encrypts
+// the stored content with, see AtRest.
+//
+// Every master key has an ID that is stored along with the content
+// encrypted with it, so the content encrypted with a key that is no
+// longer the primary one is still opened while the key is provided.
+type KeyProvider interface {
+	// PrimaryKey returns the ID of the key new content
+	// is encrypted with and the key itself.
+	PrimaryKey(ctx context.Context) (string, []byte, error)
+
+	// Key returns the key with the ID,
+	// it fails with ErrUnknownMasterKey if there is none.
+	Key(ctx context.Context, id string) ([]byte, error)
+}
+
+const (
+	masterKeyLen      = 32
+	masterKeyIDMaxLen = 255
+)
+
+var (
+	// ErrUnknownMasterKey is returned when the content
+	// is encrypted with a master key that is not provided.
+	ErrUnknownMasterKey = errors.New("unknown master key")
+
+	// ErrMalformedMasterKeys is returned when the master keys can not be parsed.
+	ErrMalformedMasterKeys = errors.New("malformed master keys")
+)
+
+// masterKeys are the master keys by their IDs.
+type masterKeys struct {
+	primary string
+	keys    map[string][]byte
+}
+
+// parseMasterKeys parses the "<id>:<base64 key>" entries, the first
+// entry is the primary key. Empty entries and entries starting with
+// # are skipped.
+func parseMasterKeys(entries []string) (masterKeys, error) {
+	parsed := masterKeys{
+		keys: make(map[string][]byte, len(entries)),
+	}
+	for _, entry := range entries {
+		entry = strings.TrimSpace(entry)
+		if entry == "" || strings.HasPrefix(entry, "#") {
+			continue
+		}
+		id, encoded, ok := strings.Cut(entry, ":")
+		if !ok || id == "" || len(id) > masterKeyIDMaxLen {
+			return masterKeys{}, errors.Join(ErrMalformedMasterKeys, errors.New("expected <id>:<base64 key>"))
+		}
+		if _, ok := parsed.keys[id]; ok {
+			return masterKeys{}, errors.Join(ErrMalformedMasterKeys, fmt.Errorf("duplicate key %s", id))
+		}
+		key, keyError := base64.StdEncoding.DecodeString(encoded)
+		if keyError != nil {
+			return masterKeys{}, errors.Join(ErrMalformedMasterKeys, keyError)
+		}
+		if len(key) != masterKeyLen {
+			return masterKeys{}, errors.Join(ErrMalformedMasterKeys, fmt.Errorf("key %s must be %d bytes", id, masterKeyLen))
+		}
+		if parsed.primary == "" {
+			parsed.primary = id
+		}
+		parsed.keys[id] = key
+	}
+	if parsed.primary == "" {
+		return masterKeys{}, errors.Join(ErrMalformedMasterKeys, errors.New("no keys"))
+	}
+	return parsed, nil
+}
+
+// primaryKey returns the primary key and its ID.
+func (k masterKeys) primaryKey() (string, []byte, error) {
+	return k.primary, k.keys[k.primary], nil
+}
+
+// key returns the key with the ID.
+func (k masterKeys) key(id string) ([]byte, error) {
+	key, ok := k.keys[id]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownMasterKey, id)
+	}
+	return key, nil
+}
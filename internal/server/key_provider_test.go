@@ -0,0 +1,75 @@
+package server
+
+import (
+	"context"
+	"encoding/base64"
+	"os"
+	"path"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// testMasterKey returns a master key entry of the ID.
+func testMasterKey(id string, fill byte) string {
+	return id + ":" + base64.StdEncoding.EncodeToString(([]byte)(strings.Repeat((string)(fill), masterKeyLen)))
+}
+
+func TestParseMasterKeys(t *testing.T) {
+	keys, parseError := parseMasterKeys([]string{"# comment", "", testMasterKey("new", 'n'), testMasterKey("old", 'o')})
+	assert.Nil(t, parseError, "did not expect an error")
+	id, key, keyError := keys.primaryKey()
+	assert.Nil(t, keyError, "did not expect an error")
+	assert.Equal(t, "new", id, "expected the first key to be the primary one")
+	assert.Equal(t, strings.Repeat("n", masterKeyLen), (string)(key), "unexpected key")
+	_, unknownError := keys.key("other")
+	assert.ErrorIs(t, unknownError, ErrUnknownMasterKey, "unexpected error")
+
+	for _, entries := range [][]string{
+		{},
+		{"key"},
+		{":" + base64.StdEncoding.EncodeToString(make([]byte, masterKeyLen))},
+		{"key:" + base64.StdEncoding.EncodeToString(make([]byte, 16))},
+		{"key:not base64"},
+		{testMasterKey("key", 'a'), testMasterKey("key", 'b')},
+	} {
+		_, err := parseMasterKeys(entries)
+		assert.ErrorIs(t, err, ErrMalformedMasterKeys, "expected %v to be rejected", entries)
+	}
+}
+
+func TestFileKeyProvider(t *testing.T) {
+	keyFile := path.Join(t.TempDir(), "keys")
+	assert.Nil(t, os.WriteFile(keyFile, ([]byte)(testMasterKey("old", 'o')+"\n"), 0o600), "did not expect an error")
+
+	provider := NewFileKeyProvider(keyFile)
+	id, _, keyError := provider.PrimaryKey(context.Background())
+	assert.Nil(t, keyError, "did not expect an error")
+	assert.Equal(t, "old", id, "unexpected primary key")
+
+	rotated := testMasterKey("new", 'n') + "\n" + testMasterKey("old", 'o') + "\n"
+	assert.Nil(t, os.WriteFile(keyFile, ([]byte)(rotated), 0o600), "did not expect an error")
+	assert.Nil(t, os.Chtimes(keyFile, time.Now(), time.Now().Add(time.Second)), "did not expect an error")
+	id, _, keyError = provider.PrimaryKey(context.Background())
+	assert.Nil(t, keyError, "did not expect an error")
+	assert.Equal(t, "new", id, "expected the file to be read again")
+	_, oldKeyError := provider.Key(context.Background(), "old")
+	assert.Nil(t, oldKeyError, "expected the old key to be kept")
+}
+
+func TestEnvKeyProvider(t *testing.T) {
+	t.Setenv("TEST_MASTER_KEYS", testMasterKey("new", 'n')+","+testMasterKey("old", 'o'))
+
+	provider := EnvKeyProvider{Variable: "TEST_MASTER_KEYS"}
+	id, _, keyError := provider.PrimaryKey(context.Background())
+	assert.Nil(t, keyError, "did not expect an error")
+	assert.Equal(t, "new", id, "unexpected primary key")
+	key, oldKeyError := provider.Key(context.Background(), "old")
+	assert.Nil(t, oldKeyError, "did not expect an error")
+	assert.Equal(t, strings.Repeat("o", masterKeyLen), (string)(key), "unexpected key")
+
+	_, _, unsetError := EnvKeyProvider{Variable: "TEST_MASTER_KEYS_UNSET"}.PrimaryKey(context.Background())
+	assert.NotNil(t, unsetError, "expected an error")
+}
@@ -0,0 +1,304 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/kerelape/gophkeeper/internal/blobstore"
+)
+
+// blobChunkSize is the size of the chunks the blobs are stored in.
+const blobChunkSize = 1024 * 1024
+
+// stagedBlobsLifespan is how long the chunks of a blob
+// that was never completely stored are kept.
+const stagedBlobsLifespan = 24 * time.Hour
+
+// BlobStore is a blobstore.BlobStore that keeps the blobs in the database
+// as chunks of bytea rows, so the servers sharing the database share
+// the blobs as well. The blobs are streamed chunk by chunk, neither
+// storing nor reading a blob holds more than a chunk in memory.
+//
+// A blob is staged without a key while its chunks are written and gets
+// the key once it is complete, so a reader sees either the blob stored
+// before or the new one. A replaced blob is deleted after that.
+type BlobStore struct {
+	database *database
+}
+
+var _ blobstore.BlobStore = (*BlobStore)(nil)
+
+// NewKey implements blobstore.BlobStore.
+func (s *BlobStore) NewKey() string {
+	return uuid.New().String()
+}
+
+// Put implements blobstore.BlobStore.
+func (s *BlobStore) Put(ctx context.Context, key string, content io.Reader) (int64, error) {
+	connection, connectionError := s.database.Get(ctx)
+	if connectionError != nil {
+		return 0, connectionError
+	}
+
+	var object int64
+	insertObjectResult := connection.QueryRow(
+		ctx,
+		`INSERT INTO blob_objects(chunk_size) VALUES($1) RETURNING id`,
+		blobChunkSize,
+	)
+	if err := insertObjectResult.Scan(&object); err != nil {
+		return 0, err
+	}
+
+	size, writeError := s.writeChunks(ctx, connection, object, content)
+	if writeError != nil {
+		s.discard(connection, object)
+		return 0, writeError
+	}
+
+	if _, err := connection.Exec(
+		ctx,
+		`UPDATE blob_objects SET key = $2, size = $3, modified_at = CURRENT_TIMESTAMP WHERE id = $1`,
+		object, key, size,
+	); err != nil {
+		s.discard(connection, object)
+		return 0, err
+	}
+	if _, err := connection.Exec(
+		ctx,
+		`DELETE FROM blob_objects WHERE key = $1 AND id < $2`,
+		key, object,
+	); err != nil {
+		// The new blob is stored already, the replaced one
+		// is hidden by it and deleted along with it.
+		log.Printf("failed to delete the replaced blob %s: %s\n", key, err.Error())
+	}
+	return size, nil
+}
+
+// Get implements blobstore.BlobStore.
+func (s *BlobStore) Get(ctx context.Context, key string, offset, length int64) (io.ReadCloser, error) {
+	connection, connectionError := s.database.Get(ctx)
+	if connectionError != nil {
+		return nil, connectionError
+	}
+
+	reader := &blobReader{
+		ctx:        ctx,
+		connection: connection,
+		remaining:  length,
+	}
+	selectObjectResult := connection.QueryRow(
+		ctx,
+		`SELECT id, size, chunk_size FROM blob_objects WHERE key = $1 ORDER BY id DESC LIMIT 1`,
+		key,
+	)
+	if err := selectObjectResult.Scan(&reader.object, &reader.size, &reader.chunkSize); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, errors.Join(err, blobstore.ErrNotFound)
+		}
+		return nil, err
+	}
+	if offset >= reader.size {
+		reader.remaining = 0
+		return reader, nil
+	}
+	reader.chunk = offset / reader.chunkSize
+	reader.skip = offset % reader.chunkSize
+	return reader, nil
+}
+
+// Delete implements blobstore.BlobStore.
+func (s *BlobStore) Delete(ctx context.Context, key string) error {
+	connection, connectionError := s.database.Get(ctx)
+	if connectionError != nil {
+		return connectionError
+	}
+
+	_, deleteError := connection.Exec(
+		ctx,
+		`DELETE FROM blob_objects WHERE key = $1`,
+		key,
+	)
+	return deleteError
+}
+
+// Stat implements blobstore.BlobStore.
+func (s *BlobStore) Stat(ctx context.Context, key string) (blobstore.Info, error) {
+	connection, connectionError := s.database.Get(ctx)
+	if connectionError != nil {
+		return blobstore.Info{}, connectionError
+	}
+
+	info := blobstore.Info{Key: key}
+	selectObjectResult := connection.QueryRow(
+		ctx,
+		`SELECT size, modified_at FROM blob_objects WHERE key = $1 ORDER BY id DESC LIMIT 1`,
+		key,
+	)
+	if err := selectObjectResult.Scan(&info.Size, &info.ModTime); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return blobstore.Info{}, errors.Join(err, blobstore.ErrNotFound)
+		}
+		return blobstore.Info{}, err
+	}
+	return info, nil
+}
+
+// List implements blobstore.BlobStore.
+func (s *BlobStore) List(ctx context.Context) ([]blobstore.Info, error) {
+	connection, connectionError := s.database.Get(ctx)
+	if connectionError != nil {
+		return nil, connectionError
+	}
+
+	selectObjectsResult, selectObjectsError := connection.Query(
+		ctx,
+		`SELECT DISTINCT ON (key) key, size, modified_at FROM blob_objects
+		WHERE key IS NOT NULL
+		ORDER BY key, id DESC`,
+	)
+	if selectObjectsError != nil {
+		return nil, selectObjectsError
+	}
+	defer selectObjectsResult.Close()
+
+	blobs := make([]blobstore.Info, 0)
+	for selectObjectsResult.Next() {
+		var info blobstore.Info
+		if err := selectObjectsResult.Scan(&info.Key, &info.Size, &info.ModTime); err != nil {
+			return nil, err
+		}
+		if strings.Contains(info.Key, "/") {
+			continue
+		}
+		blobs = append(blobs, info)
+	}
+	return blobs, selectObjectsResult.Err()
+}
+
+// writeChunks writes the content as the chunks of the object
+// and returns its size.
+func (s *BlobStore) writeChunks(ctx context.Context, connection Connection, object int64, content io.Reader) (int64, error) {
+	var (
+		buffer = make([]byte, blobChunkSize)
+		size   int64
+	)
+	for n := 0; ; n++ {
+		read, readError := io.ReadFull(content, buffer)
+		if read > 0 {
+			if _, err := connection.Exec(
+				ctx,
+				`INSERT INTO blob_chunks(object, n, content) VALUES($1, $2, $3)`,
+				object, n, buffer[:read],
+			); err != nil {
+				return 0, err
+			}
+			size += (int64)(read)
+		}
+		switch {
+		case errors.Is(readError, io.EOF), errors.Is(readError, io.ErrUnexpectedEOF):
+			return size, nil
+		case readError != nil:
+			return 0, readError
+		}
+	}
+}
+
+// discard deletes the staged object. It is deleted even if the request
+// is canceled, otherwise it is left until purgeStagedBlobs.
+func (s *BlobStore) discard(connection Connection, object int64) {
+	if _, err := connection.Exec(
+		context.Background(),
+		`DELETE FROM blob_objects WHERE id = $1`,
+		object,
+	); err != nil {
+		log.Printf("failed to discard the staged blob: %s\n", err.Error())
+	}
+}
+
+// purgeStagedBlobs deletes the blobs staged before the time
+// that were never completely stored, e.g. as the server stopped.
+func purgeStagedBlobs(ctx context.Context, connection Connection, before time.Time) error {
+	_, deleteError := connection.Exec(
+		ctx,
+		`DELETE FROM blob_objects WHERE key IS NULL AND modified_at < $1`,
+		before,
+	)
+	return deleteError
+}
+
+// blobReader reads the blob chunk by chunk.
+type blobReader struct {
+	ctx        context.Context
+	connection Connection
+
+	object    int64
+	size      int64
+	chunkSize int64
+
+	// chunk is the number of the next chunk, skip is how many
+	// bytes of it are skipped and remaining is how many bytes are left
+	// to read, the rest of the blob if it is negative.
+	chunk     int64
+	skip      int64
+	remaining int64
+
+	buffer []byte
+}
+
+// Read implements io.Reader.
+func (r *blobReader) Read(p []byte) (int, error) {
+	if r.remaining == 0 {
+		return 0, io.EOF
+	}
+	if len(r.buffer) == 0 {
+		if r.chunk*r.chunkSize >= r.size {
+			return 0, io.EOF
+		}
+		var content []byte
+		selectChunkResult := r.connection.QueryRow(
+			r.ctx,
+			`SELECT content FROM blob_chunks WHERE object = $1 AND n = $2`,
+			r.object, r.chunk,
+		)
+		if err := selectChunkResult.Scan(&content); err != nil {
+			if errors.Is(err, pgx.ErrNoRows) {
+				// The blob was deleted while being read.
+				return 0, errors.Join(err, blobstore.ErrNotFound)
+			}
+			return 0, err
+		}
+		if r.skip > (int64)(len(content)) {
+			r.skip = (int64)(len(content))
+		}
+		r.buffer = content[r.skip:]
+		r.chunk++
+		r.skip = 0
+		if len(r.buffer) == 0 {
+			return 0, io.EOF
+		}
+	}
+	if r.remaining > 0 && (int64)(len(p)) > r.remaining {
+		p = p[:r.remaining]
+	}
+	n := copy(p, r.buffer)
+	r.buffer = r.buffer[n:]
+	if r.remaining > 0 {
+		r.remaining -= (int64)(n)
+	}
+	return n, nil
+}
+
+// Close implements io.Closer.
+func (r *blobReader) Close() error {
+	r.buffer = nil
+	r.remaining = 0
+	return nil
+}
@@ -0,0 +1,153 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"sync"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/kerelape/gophkeeper/pkg/gophkeeper"
+)
+
+// Connection is a connection to the database,
+// either a pgx connection or a pool of them.
+type Connection interface {
+	Exec(ctx context.Context, sql string, arguments ...any) (pgconn.CommandTag, error)
+	Query(ctx context.Context, sql string, arguments ...any) (pgx.Rows, error)
+	QueryRow(ctx context.Context, sql string, arguments ...any) pgx.Row
+	Begin(ctx context.Context) (pgx.Tx, error)
+}
+
+var (
+	_ Connection = (*pgx.Conn)(nil)
+	_ Connection = (*pgxpool.Pool)(nil)
+)
+
+// database is the pool of the connections to the database,
+// it is set once the database is initialized.
+type database struct {
+	pool *pgxpool.Pool
+
+	ready   chan struct{}
+	failed  chan struct{}
+	failure sync.Once
+}
+
+// newDatabase returns a new database that is not initialized yet.
+func newDatabase() *database {
+	return &database{
+		ready:  make(chan struct{}),
+		failed: make(chan struct{}),
+	}
+}
+
+// Get returns the connection to the database. It waits until the database
+// is initialized, unless an attempt to reach it has failed already, then
+// it fails with gophkeeper.ErrUnavailable at once.
+func (d *database) Get(ctx context.Context) (Connection, error) {
+	select {
+	case <-d.ready:
+		return availability{d.pool}, nil
+	default:
+	}
+	select {
+	case <-d.ready:
+		return availability{d.pool}, nil
+	case <-d.failed:
+		select {
+		case <-d.ready:
+			return availability{d.pool}, nil
+		default:
+			return nil, errors.Join(errors.New("database is not initialized"), gophkeeper.ErrUnavailable)
+		}
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// set sets the pool of the initialized database.
+func (d *database) set(pool *pgxpool.Pool) {
+	d.pool = pool
+	close(d.ready)
+}
+
+// fail tells that an attempt to reach the database has failed,
+// from then on Get fails with gophkeeper.ErrUnavailable at once
+// instead of waiting until the first set.
+func (d *database) fail() {
+	d.failure.Do(func() {
+		close(d.failed)
+	})
+}
+
+// availability is a Connection that joins the errors
+// of reaching the database with gophkeeper.ErrUnavailable.
+type availability struct {
+	Connection
+}
+
+// Exec implements Connection.
+func (a availability) Exec(ctx context.Context, sql string, arguments ...any) (pgconn.CommandTag, error) {
+	tag, execError := a.Connection.Exec(ctx, sql, arguments...)
+	return tag, unavailable(execError)
+}
+
+// Query implements Connection.
+func (a availability) Query(ctx context.Context, sql string, arguments ...any) (pgx.Rows, error) {
+	rows, queryError := a.Connection.Query(ctx, sql, arguments...)
+	return rows, unavailable(queryError)
+}
+
+// QueryRow implements Connection.
+func (a availability) QueryRow(ctx context.Context, sql string, arguments ...any) pgx.Row {
+	return availableRow{a.Connection.QueryRow(ctx, sql, arguments...)}
+}
+
+// Begin implements Connection.
+func (a availability) Begin(ctx context.Context) (pgx.Tx, error) {
+	transaction, beginError := a.Connection.Begin(ctx)
+	return transaction, unavailable(beginError)
+}
+
+// availableRow is a pgx.Row that joins the errors
+// of reaching the database with gophkeeper.ErrUnavailable.
+type availableRow struct {
+	pgx.Row
+}
+
+// Scan implements pgx.Row.
+func (r availableRow) Scan(dest ...any) error {
+	return unavailable(r.Row.Scan(dest...))
+}
+
+// unavailable joins the error with gophkeeper.ErrUnavailable
+// if it tells the database can't be reached.
+func unavailable(err error) error {
+	if err == nil || errors.Is(err, gophkeeper.ErrUnavailable) || !isUnavailable(err) {
+		return err
+	}
+	return errors.Join(err, gophkeeper.ErrUnavailable)
+}
+
+// isUnavailable tells whether the error
+// tells the database can't be reached.
+func isUnavailable(err error) bool {
+	if errors.Is(err, gophkeeper.ErrUnavailable) {
+		return true
+	}
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	var pgError *pgconn.PgError
+	if errors.As(err, &pgError) {
+		// Connection exceptions and the server shutting down or starting up.
+		return strings.HasPrefix(pgError.Code, "08") || strings.HasPrefix(pgError.Code, "57P")
+	}
+	var netError net.Error
+	return errors.As(err, &netError) || errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, net.ErrClosed)
+}
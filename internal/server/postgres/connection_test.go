@@ -0,0 +1,225 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgproto3"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/kerelape/gophkeeper/internal/server"
+	"github.com/kerelape/gophkeeper/pkg/gophkeeper"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDatabase(t *testing.T) {
+	t.Run("Waits until set", func(t *testing.T) {
+		d := newDatabase()
+		go func() {
+			time.Sleep(10 * time.Millisecond)
+			d.set(nil)
+		}()
+		_, getError := d.Get(context.Background())
+		assert.Nil(t, getError, "expected to get the connection once the database is set")
+	})
+	t.Run("Fails once unavailable", func(t *testing.T) {
+		d := newDatabase()
+		d.fail()
+		d.fail()
+		_, getError := d.Get(context.Background())
+		assert.ErrorIs(t, getError, gophkeeper.ErrUnavailable, "unexpected error while the database is unavailable")
+
+		d.set(nil)
+		_, getError = d.Get(context.Background())
+		assert.Nil(t, getError, "expected to get the connection once the database is set")
+	})
+	t.Run("Fails with context cancellation", func(t *testing.T) {
+		d := newDatabase()
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		_, getError := d.Get(ctx)
+		assert.ErrorIs(t, getError, context.Canceled, "unexpected error on canceled context")
+	})
+}
+
+func TestUnavailable(t *testing.T) {
+	var (
+		refused = &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")}
+		cases   = []struct {
+			name        string
+			err         error
+			unavailable bool
+		}{
+			{name: "Dial", err: refused, unavailable: true},
+			{name: "Wrapped dial", err: errors.Join(errors.New("failed to connect"), refused), unavailable: true},
+			{name: "Connection lost", err: io.ErrUnexpectedEOF, unavailable: true},
+			{name: "Starting up", err: &pgconn.PgError{Code: "57P03"}, unavailable: true},
+			{name: "Connection exception", err: &pgconn.PgError{Code: "08006"}, unavailable: true},
+			{name: "Unique violation", err: &pgconn.PgError{Code: "23505"}, unavailable: false},
+			{name: "No rows", err: pgx.ErrNoRows, unavailable: false},
+			{name: "Deadline", err: context.DeadlineExceeded, unavailable: false},
+			{name: "Canceled", err: context.Canceled, unavailable: false},
+		}
+	)
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			err := unavailable(c.err)
+			assert.ErrorIs(t, err, c.err, "expected the error to be kept")
+			assert.Equal(t, c.unavailable, errors.Is(err, gophkeeper.ErrUnavailable))
+		})
+	}
+	assert.Nil(t, unavailable(nil), "expected no error")
+}
+
+func TestGophkeeperReconnect(t *testing.T) {
+	var (
+		database    = &fakeDatabase{}
+		g           = New(database, server.NewJWTSource(([]byte)("secret"), time.Hour))
+		ctx, cancel = context.WithCancel(context.Background())
+		done        = make(chan error, 1)
+	)
+	defer cancel()
+	defer database.stop()
+	go func() {
+		done <- g.Run(ctx)
+	}()
+
+	unavailable := func() bool {
+		_, getError := g.database.Get(ctx)
+		return errors.Is(getError, gophkeeper.ErrUnavailable)
+	}
+	assert.Eventually(t, unavailable, time.Second, 10*time.Millisecond, "expected the database to be unavailable until it is started")
+
+	database.start(t)
+	var connection Connection
+	initialized := func() bool {
+		c, getError := g.database.Get(ctx)
+		connection = c
+		return getError == nil
+	}
+	if !assert.Eventually(t, initialized, 5*time.Second, 10*time.Millisecond, "expected the database to be initialized once it is started") {
+		return
+	}
+	_, execError := connection.Exec(ctx, `SELECT 1`)
+	assert.Nil(t, execError, "expected to reach the database")
+
+	database.stop()
+	_, execError = connection.Exec(ctx, `SELECT 1`)
+	assert.ErrorIs(t, execError, gophkeeper.ErrUnavailable, "unexpected error while the database is restarting")
+
+	database.start(t)
+	reconnected := func() bool {
+		_, execError := connection.Exec(ctx, `SELECT 1`)
+		return execError == nil
+	}
+	assert.Eventually(t, reconnected, 5*time.Second, 10*time.Millisecond, "expected to reach the database once it is restarted")
+
+	cancel()
+	assert.ErrorIs(t, <-done, context.Canceled, "unexpected error of the stopped gophkeeper")
+}
+
+// fakeDatabase is a DatabaseSource of a fake database the pool dials
+// on the loopback, it answers every query with no rows and can be
+// stopped and started again.
+type fakeDatabase struct {
+	mutex       sync.Mutex
+	listener    net.Listener
+	connections []net.Conn
+}
+
+// PoolConfig implements DatabaseSource.
+func (d *fakeDatabase) PoolConfig() (*pgxpool.Config, error) {
+	config, configError := pgxpool.ParseConfig("postgres://gophkeeper@127.0.0.1/gophkeeper?sslmode=disable")
+	if configError != nil {
+		return nil, configError
+	}
+	config.ConnConfig.DialFunc = d.dial
+	config.ConnConfig.DefaultQueryExecMode = pgx.QueryExecModeSimpleProtocol
+	return config, nil
+}
+
+// dial connects to the database, the connection is refused while it is stopped.
+func (d *fakeDatabase) dial(ctx context.Context, _, _ string) (net.Conn, error) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	if d.listener == nil {
+		return nil, &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")}
+	}
+	var dialer net.Dialer
+	return dialer.DialContext(ctx, "tcp", d.listener.Addr().String())
+}
+
+// start starts the database.
+func (d *fakeDatabase) start(t *testing.T) {
+	listener, listenError := net.Listen("tcp", "127.0.0.1:0")
+	if !assert.Nil(t, listenError, "expected to start the database") {
+		return
+	}
+	d.mutex.Lock()
+	d.listener = listener
+	d.mutex.Unlock()
+	go func() {
+		for {
+			connection, acceptError := listener.Accept()
+			if acceptError != nil {
+				return
+			}
+			d.mutex.Lock()
+			d.connections = append(d.connections, connection)
+			d.mutex.Unlock()
+			go serveFakeDatabase(connection)
+		}
+	}()
+}
+
+// stop stops the database dropping its connections.
+func (d *fakeDatabase) stop() {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	if d.listener != nil {
+		d.listener.Close()
+		d.listener = nil
+	}
+	for _, connection := range d.connections {
+		connection.Close()
+	}
+	d.connections = nil
+}
+
+// serveFakeDatabase answers the queries of the connection.
+func serveFakeDatabase(connection net.Conn) {
+	defer connection.Close()
+	backend := pgproto3.NewBackend(connection, connection)
+	if _, err := backend.ReceiveStartupMessage(); err != nil {
+		return
+	}
+	backend.Send(&pgproto3.AuthenticationOk{})
+	backend.Send(&pgproto3.ParameterStatus{Name: "client_encoding", Value: "UTF8"})
+	backend.Send(&pgproto3.ParameterStatus{Name: "standard_conforming_strings", Value: "on"})
+	backend.Send(&pgproto3.ReadyForQuery{TxStatus: 'I'})
+	if err := backend.Flush(); err != nil {
+		return
+	}
+	for {
+		message, receiveError := backend.Receive()
+		if receiveError != nil {
+			return
+		}
+		switch message.(type) {
+		case *pgproto3.Query:
+			backend.Send(&pgproto3.CommandComplete{CommandTag: ([]byte)("OK")})
+			backend.Send(&pgproto3.ReadyForQuery{TxStatus: 'I'})
+			if err := backend.Flush(); err != nil {
+				return
+			}
+		case *pgproto3.Terminate:
+			return
+		}
+	}
+}
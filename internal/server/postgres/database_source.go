@@ -1,20 +1,18 @@
 package postgres
 
 import (
-	"context"
-
-	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
 )
 
-// DatabaseSource is a source of pgx connections.
+// DatabaseSource is a source of pgx connection pools.
 type DatabaseSource interface {
-	Connect(context.Context) (*pgx.Conn, error)
+	PoolConfig() (*pgxpool.Config, error)
 }
 
 // DSNSource is a pgx source from DSN string.
 type DSNSource string
 
-// Connect implements DatabaseSource.
-func (dsn DSNSource) Connect(ctx context.Context) (*pgx.Conn, error) {
-	return pgx.Connect(ctx, (string)(dsn))
+// PoolConfig implements DatabaseSource.
+func (dsn DSNSource) PoolConfig() (*pgxpool.Config, error) {
+	return pgxpool.ParseConfig((string)(dsn))
 }
@@ -0,0 +1,516 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"path"
+	"time"
+
+	"github.com/kerelape/gophkeeper/internal/blobstore"
+	"github.com/kerelape/gophkeeper/internal/server"
+	"github.com/kerelape/gophkeeper/pkg/gophkeeper"
+)
+
+var _ server.Checker = (*Gophkeeper)(nil)
+
+// fsckResources selects every resource along with whether
+// the piece and the blob it references exist and the blob file.
+const fsckResources = `SELECT r.id, r.type, r.resource,
+	EXISTS (SELECT 1 FROM pieces p WHERE p.id = r.resource),
+	b.id IS NOT NULL, b.location
+FROM resources r LEFT JOIN blobs b ON b.id = r.resource
+ORDER BY r.id`
+
+// fsckOrphanPieces selects the pieces no resource references.
+const fsckOrphanPieces = `SELECT p.id, NULL::TEXT FROM pieces p
+WHERE NOT EXISTS (SELECT 1 FROM resources r WHERE r.type = $1 AND r.resource = p.id)
+ORDER BY p.id`
+
+// fsckOrphanBlobs selects the blobs no resource references.
+const fsckOrphanBlobs = `SELECT b.id, b.location FROM blobs b
+WHERE NOT EXISTS (SELECT 1 FROM resources r WHERE r.type = $1 AND r.resource = b.id)
+ORDER BY b.id`
+
+// fsckOrphanVersions selects the versions of the resources that do not exist.
+const fsckOrphanVersions = `SELECT v.id, v.location FROM resource_versions v
+WHERE NOT EXISTS (SELECT 1 FROM resources r WHERE r.id = v.resource)
+ORDER BY v.id`
+
+// fsckVersionFiles selects the blob files of the versions.
+const fsckVersionFiles = `SELECT id, location FROM resource_versions
+WHERE location IS NOT NULL ORDER BY id`
+
+// fsckStagedFiles selects the blob files staged by vault password changes.
+const fsckStagedFiles = `SELECT resource, location FROM reencrypted_resources
+WHERE location IS NOT NULL ORDER BY resource, version`
+
+// quarantineTarget is the rows of the table that match the condition.
+type quarantineTarget struct {
+	table     string
+	condition string
+	args      []any
+}
+
+// fsckRow is a row selected by a check, location is nil
+// if the row references no blob file.
+type fsckRow struct {
+	id       int64
+	location *string
+}
+
+// Fsck implements server.Checker.
+//
+// The files are listed before the rows are read, so a file stored in the
+// meantime is either referenced already or younger than the grace. A problem
+// repaired concurrently is reported but is not quarantined.
+func (r *Gophkeeper) Fsck(ctx context.Context, options server.FsckOptions) (server.FsckReport, error) {
+	connection, connectionError := r.database.Get(ctx)
+	if connectionError != nil {
+		return server.FsckReport{}, connectionError
+	}
+
+	files, filesError := r.blobStore.List(ctx)
+	if filesError != nil {
+		return server.FsckReport{}, filesError
+	}
+
+	var (
+		report     = server.FsckReport{Files: len(files)}
+		quarantine = server.Quarantine{Store: r.blobStore}
+		referenced = make(map[string]struct{})
+	)
+	reference := func(location *string) {
+		if location != nil {
+			referenced[path.Clean(*location)] = struct{}{}
+		}
+	}
+	missing := func(location *string) (bool, error) {
+		if location == nil {
+			return false, nil
+		}
+		_, statError := r.blobStore.Stat(ctx, *location)
+		if errors.Is(statError, blobstore.ErrNotFound) {
+			return true, nil
+		}
+		return false, statError
+	}
+	report.Problems = make([]server.FsckProblem, 0)
+	add := func(problem server.FsckProblem, repair func() (bool, error)) error {
+		if options.Repair && repair != nil {
+			quarantined, repairError := repair()
+			if repairError != nil {
+				return repairError
+			}
+			problem.Quarantined = quarantined
+		}
+		report.Problems = append(report.Problems, problem)
+		return nil
+	}
+
+	type resource struct {
+		id       int64
+		kind     int
+		content  int64
+		hasPiece bool
+		hasBlob  bool
+		blobFile *string
+	}
+	resourcesResult, resourcesError := connection.Query(ctx, fsckResources)
+	if resourcesError != nil {
+		return report, resourcesError
+	}
+	resources := make([]resource, 0)
+	for resourcesResult.Next() {
+		var current resource
+		if err := resourcesResult.Scan(
+			&current.id,
+			&current.kind,
+			&current.content,
+			&current.hasPiece,
+			&current.hasBlob,
+			&current.blobFile,
+		); err != nil {
+			resourcesResult.Close()
+			return report, err
+		}
+		resources = append(resources, current)
+	}
+	resourcesResult.Close()
+	if err := resourcesResult.Err(); err != nil {
+		return report, err
+	}
+	report.Resources = len(resources)
+
+	for _, current := range resources {
+		var (
+			problem = server.FsckProblem{Table: "resources", ID: current.id}
+			exists  bool
+		)
+		switch (gophkeeper.ResourceType)(current.kind) {
+		case gophkeeper.ResourceTypePiece:
+			exists = current.hasPiece
+			if !exists && current.hasBlob {
+				problem.Kind = server.FsckTypeMismatch
+				problem.Detail = fmt.Sprintf("piece resource references blob %d", current.content)
+			}
+			current.blobFile = nil
+		case gophkeeper.ResourceTypeBlob:
+			exists = current.hasBlob
+			if !exists && current.hasPiece {
+				problem.Kind = server.FsckTypeMismatch
+				problem.Detail = fmt.Sprintf("blob resource references piece %d", current.content)
+			}
+			reference(current.blobFile)
+		default:
+			problem.Kind = server.FsckTypeMismatch
+			problem.Detail = fmt.Sprintf("unknown resource type %d", current.kind)
+		}
+		if problem.Kind == "" && !exists {
+			problem.Kind = server.FsckDanglingRow
+			problem.Detail = fmt.Sprintf(
+				"%s %d does not exist",
+				(gophkeeper.ResourceType)(current.kind).String(), current.content,
+			)
+		}
+		if problem.Kind == "" {
+			isMissing, missingError := missing(current.blobFile)
+			if missingError != nil {
+				return report, missingError
+			}
+			if !isMissing {
+				continue
+			}
+			problem.Kind = server.FsckMissingFile
+			problem.Location = *current.blobFile
+			problem.Detail = "blob file of the resource does not exist"
+		}
+		rid := (gophkeeper.ResourceID)(current.id)
+		repair := func() (bool, error) {
+			return r.quarantineResource(ctx, quarantine, rid, current.kind, current.content, (string)(problem.Kind))
+		}
+		if err := add(problem, repair); err != nil {
+			return report, err
+		}
+	}
+
+	orphans := []struct {
+		table string
+		query string
+		args  []any
+	}{
+		{table: "pieces", query: fsckOrphanPieces, args: []any{(int)(gophkeeper.ResourceTypePiece)}},
+		{table: "blobs", query: fsckOrphanBlobs, args: []any{(int)(gophkeeper.ResourceTypeBlob)}},
+		{table: "resource_versions", query: fsckOrphanVersions},
+	}
+	for _, orphan := range orphans {
+		rows, rowsError := queryFsckRows(ctx, connection, orphan.query, orphan.args...)
+		if rowsError != nil {
+			return report, rowsError
+		}
+		for _, row := range rows {
+			var (
+				table   = orphan.table
+				id      = row.id
+				problem = server.FsckProblem{
+					Kind:   server.FsckOrphanRow,
+					Table:  table,
+					ID:     id,
+					Detail: "no resource references the row",
+				}
+			)
+			if row.location != nil {
+				problem.Location = *row.location
+			}
+			repair := func() (bool, error) {
+				return r.quarantineRows(ctx, quarantine, table, `id = $2`, (string)(problem.Kind), id)
+			}
+			if err := add(problem, repair); err != nil {
+				return report, err
+			}
+		}
+	}
+
+	if err := connection.QueryRow(ctx, `SELECT COUNT(*) FROM pieces`).Scan(&report.Pieces); err != nil {
+		return report, err
+	}
+	if err := connection.QueryRow(ctx, `SELECT COUNT(*) FROM blobs`).Scan(&report.Blobs); err != nil {
+		return report, err
+	}
+
+	versions, versionsError := queryFsckRows(ctx, connection, fsckVersionFiles)
+	if versionsError != nil {
+		return report, versionsError
+	}
+	for _, version := range versions {
+		reference(version.location)
+		isMissing, missingError := missing(version.location)
+		if missingError != nil {
+			return report, missingError
+		}
+		if !isMissing {
+			continue
+		}
+		var (
+			id      = version.id
+			problem = server.FsckProblem{
+				Kind:     server.FsckMissingFile,
+				Table:    "resource_versions",
+				ID:       id,
+				Location: *version.location,
+				Detail:   "blob file of the version does not exist",
+			}
+		)
+		repair := func() (bool, error) {
+			return r.quarantineRows(ctx, quarantine, "resource_versions", `id = $2`, (string)(problem.Kind), id)
+		}
+		if err := add(problem, repair); err != nil {
+			return report, err
+		}
+	}
+
+	staged, stagedError := queryFsckRows(ctx, connection, fsckStagedFiles)
+	if stagedError != nil {
+		return report, stagedError
+	}
+	for _, stage := range staged {
+		reference(stage.location)
+		isMissing, missingError := missing(stage.location)
+		if missingError != nil {
+			return report, missingError
+		}
+		if !isMissing {
+			continue
+		}
+		// The vault password change that staged the file
+		// is either completed or abandoned by its owner.
+		problem := server.FsckProblem{
+			Kind:     server.FsckMissingFile,
+			Table:    "reencrypted_resources",
+			ID:       stage.id,
+			Location: *stage.location,
+			Detail:   "blob file staged by a vault password change does not exist",
+		}
+		if err := add(problem, nil); err != nil {
+			return report, err
+		}
+	}
+
+	// The rows quarantined above are no longer referenced,
+	// their files are moved to the quarantine along with them.
+	before := time.Now().Add(-options.Grace)
+	for _, file := range files {
+		if _, ok := referenced[path.Clean(file.Key)]; ok || !file.ModTime.Before(before) {
+			continue
+		}
+		var (
+			location = file.Key
+			problem  = server.FsckProblem{
+				Kind:     server.FsckOrphanFile,
+				Location: location,
+				Detail:   "no row references the file",
+			}
+		)
+		repair := func() (bool, error) {
+			var stillReferenced bool
+			if err := connection.QueryRow(ctx, selectBlobFileReferenced, location).Scan(&stillReferenced); err != nil {
+				return false, err
+			}
+			if stillReferenced {
+				return false, nil
+			}
+			return quarantineFile(ctx, quarantine, location)
+		}
+		if err := add(problem, repair); err != nil {
+			return report, err
+		}
+	}
+
+	return report, nil
+}
+
+// quarantineResource moves the resource along with its tags and versions
+// to the quarantine, and the piece or the blob of its type unless another
+// resource references it. It returns false if the resource is gone already.
+func (r *Gophkeeper) quarantineResource(
+	ctx context.Context,
+	quarantine server.Quarantine,
+	rid gophkeeper.ResourceID,
+	kind int,
+	content int64,
+	reason string,
+) (bool, error) {
+	connection, connectionError := r.database.Get(ctx)
+	if connectionError != nil {
+		return false, connectionError
+	}
+
+	transaction, transactionError := connection.Begin(ctx)
+	if transactionError != nil {
+		return false, transactionError
+	}
+
+	var (
+		moved   int64
+		dropped = make([]string, 0)
+		targets = []quarantineTarget{
+			{table: "resources", condition: `id = $2`, args: []any{(int64)(rid)}},
+			{table: "resource_tags", condition: `resource = $2`, args: []any{(int64)(rid)}},
+			{table: "resource_versions", condition: `resource = $2`, args: []any{(int64)(rid)}},
+		}
+	)
+	if table := contentTable(kind); table != "" {
+		targets = append(targets, quarantineTarget{
+			table:     table,
+			condition: `id = $2 AND NOT EXISTS (SELECT 1 FROM resources WHERE type = $3 AND resource = $2)`,
+			args:      []any{content, kind},
+		})
+	}
+	for index, target := range targets {
+		n, locations, quarantineError := quarantineRows(ctx, transaction, target.table, target.condition, reason, target.args...)
+		if quarantineError != nil {
+			if err := transaction.Rollback(ctx); err != nil {
+				return false, err
+			}
+			return false, quarantineError
+		}
+		if index == 0 {
+			moved = n
+			if moved == 0 {
+				break
+			}
+		}
+		dropped = append(dropped, locations...)
+	}
+	if moved == 0 {
+		if err := transaction.Rollback(ctx); err != nil {
+			return false, err
+		}
+		return false, nil
+	}
+
+	if err := transaction.Commit(ctx); err != nil {
+		if err := transaction.Rollback(ctx); err != nil {
+			return false, err
+		}
+		return false, err
+	}
+
+	quarantineFiles(ctx, quarantine, dropped)
+	return true, nil
+}
+
+// contentTable returns the table of the content of the resource
+// type, empty if the type is unknown.
+func contentTable(kind int) string {
+	switch (gophkeeper.ResourceType)(kind) {
+	case gophkeeper.ResourceTypePiece:
+		return "pieces"
+	case gophkeeper.ResourceTypeBlob:
+		return "blobs"
+	default:
+		return ""
+	}
+}
+
+// quarantineRows moves the rows of the table that match the condition
+// to the quarantine table along with the blob files they reference.
+// It returns false if there are no such rows.
+func (r *Gophkeeper) quarantineRows(
+	ctx context.Context,
+	quarantine server.Quarantine,
+	table, condition, reason string,
+	args ...any,
+) (bool, error) {
+	connection, connectionError := r.database.Get(ctx)
+	if connectionError != nil {
+		return false, connectionError
+	}
+	moved, dropped, quarantineError := quarantineRows(ctx, connection, table, condition, reason, args...)
+	if quarantineError != nil {
+		return false, quarantineError
+	}
+	quarantineFiles(ctx, quarantine, dropped)
+	return moved > 0, nil
+}
+
+// quarantineRows moves the rows of the table that match the condition
+// to the quarantine table. The condition takes its arguments from $2 on,
+// $1 is the reason. It returns the number of the rows moved and the
+// locations of the blob files they reference, the files must be moved to
+// the quarantine once the rows are.
+func quarantineRows(
+	ctx context.Context,
+	q querier,
+	table, condition, reason string,
+	args ...any,
+) (int64, []string, error) {
+	query := fmt.Sprintf(
+		`WITH moved AS (DELETE FROM %s WHERE %s RETURNING *)
+		INSERT INTO quarantine(source, data, reason)
+		SELECT '%s', to_jsonb(moved), $1 FROM moved RETURNING data->>'location'`,
+		table, condition, table,
+	)
+	result, resultError := q.Query(ctx, query, append([]any{reason}, args...)...)
+	if resultError != nil {
+		return 0, nil, resultError
+	}
+	defer result.Close()
+
+	var (
+		moved     int64
+		locations = make([]string, 0)
+	)
+	for result.Next() {
+		var location *string
+		if err := result.Scan(&location); err != nil {
+			return 0, nil, err
+		}
+		moved++
+		if location != nil {
+			locations = append(locations, *location)
+		}
+	}
+	return moved, locations, result.Err()
+}
+
+// queryFsckRows queries the id and the location of the rows.
+func queryFsckRows(ctx context.Context, q querier, query string, args ...any) ([]fsckRow, error) {
+	result, resultError := q.Query(ctx, query, args...)
+	if resultError != nil {
+		return nil, resultError
+	}
+	defer result.Close()
+
+	rows := make([]fsckRow, 0)
+	for result.Next() {
+		var row fsckRow
+		if err := result.Scan(&row.id, &row.location); err != nil {
+			return nil, err
+		}
+		rows = append(rows, row)
+	}
+	return rows, result.Err()
+}
+
+// quarantineFile moves the file to the quarantine.
+// It returns false if the file is gone already.
+func quarantineFile(ctx context.Context, quarantine server.Quarantine, location string) (bool, error) {
+	if err := quarantine.Move(ctx, location); err != nil {
+		if errors.Is(err, blobstore.ErrNotFound) {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+// quarantineFiles moves the files of the quarantined rows to the quarantine.
+func quarantineFiles(ctx context.Context, quarantine server.Quarantine, locations []string) {
+	for _, location := range locations {
+		if _, err := quarantineFile(ctx, quarantine, location); err != nil {
+			log.Printf("failed to quarantine file: %s\n", err.Error())
+		}
+	}
+}
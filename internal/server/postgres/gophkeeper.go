@@ -5,12 +5,12 @@ import (
 	_ "embed"
 	"encoding/base64"
 	"errors"
-	"io/fs"
-	"os"
+	"log"
+	"time"
 
-	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgconn"
-	"github.com/kerelape/gophkeeper/internal/deferred"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/kerelape/gophkeeper/internal/blobstore"
 	"github.com/kerelape/gophkeeper/internal/server"
 	"github.com/kerelape/gophkeeper/pkg/gophkeeper"
 	"github.com/pior/runnable"
@@ -20,15 +20,31 @@ import (
 //go:embed init.sql
 var initQuery string
 
+const (
+	// minReconnectBackoff is the delay before the first attempt
+	// to reach the database again, it doubles with every attempt.
+	minReconnectBackoff = 100 * time.Millisecond
+
+	// maxReconnectBackoff is the longest delay between
+	// the attempts to reach the database.
+	maxReconnectBackoff = 30 * time.Second
+)
+
 type (
 	// Gophkeeper is a postgresql identity repository.
 	Gophkeeper struct {
-		passwordEncoding *base64.Encoding
-		source           DatabaseSource
-		blobsDir         string
-		tokenSource      server.UsernameBasedTokenSource
+		passwordEncoding  *base64.Encoding
+		source            DatabaseSource
+		blobStore         blobstore.BlobStore
+		versionsLimit     int
+		tokenSource       server.UsernameBasedTokenSource
+		atRest            server.AtRest
+		compression       bool
+		deduplication     bool
+		poolSize          int32
+		healthCheckPeriod time.Duration
 
-		connection deferred.Deferred[*pgx.Conn]
+		database *database
 	}
 	option func(g *Gophkeeper)
 )
@@ -39,22 +55,33 @@ func New(source DatabaseSource, tokenSource server.UsernameBasedTokenSource, opt
 		passwordEncoding: base64.RawStdEncoding,
 		source:           source,
 		tokenSource:      tokenSource,
-		blobsDir:         "./blobs",
+		blobStore:        &blobstore.FS{Dir: "./blobs"},
+		versionsLimit:    10,
+		database:         newDatabase(),
 	}
 	for _, o := range options {
 		o(g)
 	}
+	if g.deduplication {
+		g.blobStore = &blobstore.Dedup{
+			Store:      g.blobStore,
+			References: &References{database: g.database},
+			AtRest:     g.atRest,
+		}
+	}
 	return g
 }
 
 var (
 	_ gophkeeper.Gophkeeper = (*Gophkeeper)(nil)
 	_ runnable.Runnable     = (*Gophkeeper)(nil)
+	_ server.Trash          = (*Gophkeeper)(nil)
+	_ server.EnvelopeStore  = (*Gophkeeper)(nil)
 )
 
 // Register implements Repository.
 func (r *Gophkeeper) Register(ctx context.Context, credential gophkeeper.Credential) error {
-	connection, connectionError := r.connection.Get(ctx)
+	connection, connectionError := r.database.Get(ctx)
 	if connectionError != nil {
 		return connectionError
 	}
@@ -67,11 +94,22 @@ func (r *Gophkeeper) Register(ctx context.Context, credential gophkeeper.Credent
 		return passwordError
 	}
 
+	vaultPassword := credential.VaultPassword
+	if vaultPassword == "" {
+		vaultPassword = credential.Password
+	}
+	vaultCheck, vaultCheckError := server.NewVaultCheck(vaultPassword)
+	if vaultCheckError != nil {
+		return vaultCheckError
+	}
+
 	_, insertError := connection.Exec(
 		ctx,
-		`INSERT INTO identities(username, password) VALUES($1, $2)`,
+		`INSERT INTO identities(username, password, vault_check, vault_salt) VALUES($1, $2, $3, $4)`,
 		credential.Username,
 		r.passwordEncoding.EncodeToString(password),
+		vaultCheck,
+		credential.VaultSalt,
 	)
 	if insertError != nil {
 		if err := new(pgconn.PgError); errors.As(insertError, &err) && err.Code == "23505" {
@@ -85,7 +123,7 @@ func (r *Gophkeeper) Register(ctx context.Context, credential gophkeeper.Credent
 
 // Authenticate implements Repository.
 func (r *Gophkeeper) Authenticate(ctx context.Context, credential gophkeeper.Credential) (gophkeeper.Token, error) {
-	connection, connectionError := r.connection.Get(ctx)
+	connection, connectionError := r.database.Get(ctx)
 	if connectionError != nil {
 		return gophkeeper.InvalidToken, connectionError
 	}
@@ -109,7 +147,7 @@ func (r *Gophkeeper) Authenticate(ctx context.Context, credential gophkeeper.Cre
 
 // Identity implements Repository.
 func (r *Gophkeeper) Identity(ctx context.Context, token gophkeeper.Token) (gophkeeper.Identity, error) {
-	connection, connectionError := r.connection.Get(ctx)
+	connection, connectionError := r.database.Get(ctx)
 	if connectionError != nil {
 		return nil, connectionError
 	}
@@ -123,39 +161,313 @@ func (r *Gophkeeper) Identity(ctx context.Context, token gophkeeper.Token) (goph
 		Connection:       connection,
 		PasswordEncoding: r.passwordEncoding,
 		Username:         username,
-		BlobsDir:         r.blobsDir,
+		Blobs:            r.blobStore,
+		VersionsLimit:    r.versionsLimit,
+		AtRest:           r.atRest,
+		BlobAtRest:       r.blobAtRest(),
+		Compression:      r.compression,
+		Deduplication:    r.deduplication,
 	}
 	return identity, nil
 }
 
+// PurgeTrash implements server.Trash.
+func (r *Gophkeeper) PurgeTrash(ctx context.Context, before time.Time) error {
+	connection, connectionError := r.database.Get(ctx)
+	if connectionError != nil {
+		return connectionError
+	}
+
+	selectResourcesResult, selectResourcesError := connection.Query(
+		ctx,
+		`SELECT id FROM resources WHERE deleted_at < $1`,
+		before,
+	)
+	if selectResourcesError != nil {
+		return selectResourcesError
+	}
+	expired := make([]gophkeeper.ResourceID, 0)
+	for selectResourcesResult.Next() {
+		var rid gophkeeper.ResourceID
+		if err := selectResourcesResult.Scan(&rid); err != nil {
+			selectResourcesResult.Close()
+			return err
+		}
+		expired = append(expired, rid)
+	}
+	selectResourcesResult.Close()
+	if err := selectResourcesResult.Err(); err != nil {
+		return err
+	}
+
+	for _, rid := range expired {
+		transaction, transactionError := connection.Begin(ctx)
+		if transactionError != nil {
+			return transactionError
+		}
+		dropped, purgeError := purgeResource(ctx, transaction, rid)
+		if purgeError != nil {
+			if err := transaction.Rollback(ctx); err != nil {
+				return err
+			}
+			return purgeError
+		}
+		if err := transaction.Commit(ctx); err != nil {
+			return err
+		}
+		removeBlobs(r.blobStore, dropped)
+	}
+
+	if dedup, ok := r.blobStore.(*blobstore.Dedup); ok {
+		return dedup.Collect(ctx)
+	}
+	return nil
+}
+
+// blobAtRest returns what the identities encrypt the blob files at rest
+// with, nothing if the deduplication encrypts the chunks instead.
+func (r *Gophkeeper) blobAtRest() server.AtRest {
+	if r.deduplication {
+		return server.AtRest{}
+	}
+	return r.atRest
+}
+
+// Envelopes implements server.EnvelopeStore.
+func (r *Gophkeeper) Envelopes(ctx context.Context, after server.Envelope, limit int) ([]server.Envelope, error) {
+	connection, connectionError := r.database.Get(ctx)
+	if connectionError != nil {
+		return nil, connectionError
+	}
+
+	selectEnvelopesResult, selectEnvelopesError := connection.Query(
+		ctx,
+		`SELECT owner, resource, version, meta FROM (
+			SELECT owner, id AS resource, 0 AS version, meta FROM resources
+			UNION ALL
+			SELECT r.owner, v.resource, v.version, v.meta FROM resource_versions v
+			JOIN resources r ON r.id = v.resource
+		) e WHERE (resource, version) > ($1, $2)
+		ORDER BY resource, version LIMIT $3`,
+		(int64)(after.Resource), after.Version, limit,
+	)
+	if selectEnvelopesError != nil {
+		return nil, selectEnvelopesError
+	}
+	defer selectEnvelopesResult.Close()
+
+	envelopes := make([]server.Envelope, 0, limit)
+	for selectEnvelopesResult.Next() {
+		var envelope server.Envelope
+		if err := selectEnvelopesResult.Scan(
+			&envelope.Owner,
+			&envelope.Resource,
+			&envelope.Version,
+			&envelope.Meta,
+		); err != nil {
+			return nil, err
+		}
+		envelopes = append(envelopes, envelope)
+	}
+	return envelopes, selectEnvelopesResult.Err()
+}
+
+// RewriteEnvelopes implements server.EnvelopeStore.
+func (r *Gophkeeper) RewriteEnvelopes(ctx context.Context, rewrites []server.EnvelopeRewrite) (int, error) {
+	connection, connectionError := r.database.Get(ctx)
+	if connectionError != nil {
+		return 0, connectionError
+	}
+
+	transaction, transactionError := connection.Begin(ctx)
+	if transactionError != nil {
+		return 0, transactionError
+	}
+	rewritten := 0
+	for _, rewrite := range rewrites {
+		var (
+			tag          pgconn.CommandTag
+			rewriteError error
+		)
+		if rewrite.Envelope.Version == 0 {
+			tag, rewriteError = transaction.Exec(
+				ctx,
+				`UPDATE resources SET meta = $3 WHERE id = $1 AND meta = $2`,
+				(int64)(rewrite.Envelope.Resource), rewrite.Envelope.Meta, rewrite.Meta,
+			)
+		} else {
+			tag, rewriteError = transaction.Exec(
+				ctx,
+				`UPDATE resource_versions SET meta = $4 WHERE resource = $1 AND version = $2 AND meta = $3`,
+				(int64)(rewrite.Envelope.Resource), rewrite.Envelope.Version, rewrite.Envelope.Meta, rewrite.Meta,
+			)
+		}
+		if rewriteError != nil {
+			if err := transaction.Rollback(ctx); err != nil {
+				return 0, err
+			}
+			return 0, rewriteError
+		}
+		rewritten += (int)(tag.RowsAffected())
+	}
+	if err := transaction.Commit(ctx); err != nil {
+		if err := transaction.Rollback(ctx); err != nil {
+			return 0, err
+		}
+		return 0, err
+	}
+	return rewritten, nil
+}
+
 // Run implements Runnable.
+//
+// While the database can't be reached at first the initialization is
+// retried with the exponential backoff and the calls fail with
+// gophkeeper.ErrUnavailable. Once it is initialized the pool redials
+// the database by itself, so it recovers after the database restarts.
 func (r *Gophkeeper) Run(ctx context.Context) error {
-	mkdirError := os.MkdirAll(r.blobsDir, fs.ModePerm)
-	if mkdirError != nil {
-		return mkdirError
+	config, configError := r.source.PoolConfig()
+	if configError != nil {
+		return configError
 	}
-
-	connection, connectError := r.source.Connect(ctx)
-	if connectError != nil {
-		return connectError
+	if r.poolSize > 0 {
+		config.MaxConns = r.poolSize
+	}
+	if r.healthCheckPeriod > 0 {
+		config.HealthCheckPeriod = r.healthCheckPeriod
 	}
-	defer connection.Close(context.Background())
+	pool, poolError := pgxpool.NewWithConfig(ctx, config)
+	if poolError != nil {
+		return poolError
+	}
+	defer pool.Close()
 
-	_, initializeError := connection.Exec(ctx, initQuery)
-	if initializeError != nil {
-		return initializeError
+	if err := r.initialize(ctx, pool); err != nil {
+		return err
 	}
 
-	r.connection.Set(connection)
+	r.database.set(pool)
 
 	<-ctx.Done()
 	return ctx.Err()
 }
 
+// initialize initializes the database, the attempts that fail to reach
+// the database are repeated with the exponential backoff.
+func (r *Gophkeeper) initialize(ctx context.Context, pool *pgxpool.Pool) error {
+	backoff := minReconnectBackoff
+	for {
+		initializeError := initializeDatabase(ctx, pool)
+		if initializeError == nil || !isUnavailable(initializeError) {
+			return initializeError
+		}
+		r.database.fail()
+		log.Printf("database is unavailable, retrying in %s: %s\n", backoff, initializeError.Error())
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, maxReconnectBackoff)
+	}
+}
+
+// initializeDatabase creates the tables of the database.
+func initializeDatabase(ctx context.Context, connection Connection) error {
+	if _, err := connection.Exec(ctx, initQuery); err != nil {
+		return err
+	}
+	return purgeStagedBlobs(ctx, connection, time.Now().Add(-stagedBlobsLifespan))
+}
+
 // WithBlobsDir sets blobs dir to the gophkeeper.
 func WithBlobsDir(dir string) option {
+	return WithBlobStore(&blobstore.FS{Dir: dir})
+}
+
+// WithBlobStore sets the store of the blob files to the gophkeeper.
+func WithBlobStore(store blobstore.BlobStore) option {
+	if store == nil {
+		panic("store must be not nil")
+	}
+	return func(g *Gophkeeper) {
+		g.blobStore = store
+	}
+}
+
+// WithPoolSize sets the maximum number of
+// the connections to the database.
+func WithPoolSize(size int32) option {
+	if size <= 0 {
+		panic("size must be positive")
+	}
+	return func(g *Gophkeeper) {
+		g.poolSize = size
+	}
+}
+
+// WithHealthCheckPeriod sets how often the idle
+// connections to the database are checked.
+func WithHealthCheckPeriod(period time.Duration) option {
+	if period <= 0 {
+		panic("period must be positive")
+	}
+	return func(g *Gophkeeper) {
+		g.healthCheckPeriod = period
+	}
+}
+
+// WithDatabaseBlobStore makes the gophkeeper keep the blob files
+// in the database, so the servers sharing it are stateless.
+func WithDatabaseBlobStore() option {
+	return func(g *Gophkeeper) {
+		g.blobStore = &BlobStore{database: g.database}
+	}
+}
+
+// WithDeduplication makes the gophkeeper keep every unique chunk
+// of the blob files once, the chunks nothing references are
+// deleted along with the trash. The blob files stored before
+// are moved to the chunks by MigrateBlobs.
+func WithDeduplication(deduplication bool) option {
+	return func(g *Gophkeeper) {
+		g.deduplication = deduplication
+	}
+}
+
+// WithVersionsLimit sets how many previous versions
+// of a resource the gophkeeper keeps.
+func WithVersionsLimit(limit int) option {
+	if limit < 0 {
+		panic("limit must be not negative")
+	}
+	return func(g *Gophkeeper) {
+		g.versionsLimit = limit
+	}
+}
+
+// WithKeyProvider sets the master keys the gophkeeper encrypts
+// the pieces and the blob files at rest with, see server.AtRest.
+// The content is stored as is if the keys are nil.
+func WithKeyProvider(keys server.KeyProvider) option {
+	return func(g *Gophkeeper) {
+		g.atRest.Keys = keys
+	}
+}
+
+// WithRequireSealed sets whether the content stored
+// not encrypted at rest is refused, see server.AtRest.
+func WithRequireSealed(require bool) option {
+	return func(g *Gophkeeper) {
+		g.atRest.RequireSealed = require
+	}
+}
+
+// WithCompression sets whether the blobs are compressed
+// before they are encrypted, see gophkeeper.Settings.
+func WithCompression(compression bool) option {
 	return func(g *Gophkeeper) {
-		g.blobsDir = dir
+		g.compression = compression
 	}
 }
 
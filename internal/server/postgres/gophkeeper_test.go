@@ -0,0 +1,141 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/kerelape/gophkeeper/internal/server"
+	"github.com/kerelape/gophkeeper/pkg/gophkeeper"
+	"github.com/stretchr/testify/assert"
+)
+
+// testDSNVariable is the variable of the DSN of the database
+// the tests run against, they are skipped if it is not set.
+const testDSNVariable = "GOPHKEEPER_TEST_DATABASE_DSN"
+
+// newGophkeeper runs a new postgres gophkeeper
+// against the test database for the test.
+func newGophkeeper(t *testing.T, options ...option) *Gophkeeper {
+	t.Helper()
+	dsn := os.Getenv(testDSNVariable)
+	if dsn == "" {
+		t.Skipf("%s is not set", testDSNVariable)
+	}
+	g := New(
+		DSNSource(dsn),
+		server.NewJWTSource(([]byte)("secret"), time.Hour),
+		append([]option{WithBlobsDir(path.Join(t.TempDir(), "blobs"))}, options...)...,
+	)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- g.Run(ctx)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		if err := <-done; !errors.Is(err, context.Canceled) {
+			t.Errorf("unexpected error on run: %v", err)
+		}
+	})
+	return g
+}
+
+func TestGophkeeperConcurrency(t *testing.T) {
+	const (
+		workers = 16
+		pieces  = 10
+	)
+	var (
+		g          = newGophkeeper(t, WithPoolSize(4))
+		credential = gophkeeper.Credential{
+			Username: fmt.Sprintf("concurrency-%d", time.Now().UnixNano()),
+			Password: "qwerty",
+		}
+	)
+	if err := g.Register(context.Background(), credential); err != nil {
+		t.Fatalf("failed to register: %s", err.Error())
+	}
+	token, authenticateError := g.Authenticate(context.Background(), credential)
+	if authenticateError != nil {
+		t.Fatalf("failed to authenticate: %s", authenticateError.Error())
+	}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, workers*pieces*2)
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			identity, identityError := g.Identity(context.Background(), token)
+			if identityError != nil {
+				errs <- identityError
+				return
+			}
+			for p := 0; p < pieces; p++ {
+				piece := gophkeeper.Piece{
+					Meta:    fmt.Sprintf("%d-%d", w, p),
+					Content: ([]byte)("content"),
+				}
+				if _, err := identity.StorePiece(context.Background(), piece, credential.Password); err != nil {
+					errs <- err
+				}
+				if _, err := identity.List(context.Background(), gophkeeper.ListOptions{}); err != nil {
+					errs <- err
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Errorf("unexpected error: %s", err.Error())
+	}
+
+	identity, identityError := g.Identity(context.Background(), token)
+	if identityError != nil {
+		t.Fatalf("failed to get the identity: %s", identityError.Error())
+	}
+	resources := 0
+	options := gophkeeper.ListOptions{}
+	for {
+		page, listError := identity.List(context.Background(), options)
+		if !assert.Nil(t, listError, "expected to list the resources") {
+			return
+		}
+		resources += len(page.Resources)
+		if page.Next == "" {
+			break
+		}
+		options.Cursor = page.Next
+	}
+	assert.Equal(t, workers*pieces, resources, "expected every piece to be stored")
+}
+
+func TestGophkeeperUnavailable(t *testing.T) {
+	g := New(
+		DSNSource("postgres://gophkeeper@127.0.0.1:1/gophkeeper?connect_timeout=1"),
+		server.NewJWTSource(([]byte)("secret"), time.Hour),
+		WithBlobsDir(path.Join(t.TempDir(), "blobs")),
+	)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- g.Run(ctx)
+	}()
+	defer func() {
+		cancel()
+		assert.ErrorIs(t, <-done, context.Canceled, "expected to retry until canceled")
+	}()
+
+	registerCtx, registerCancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer registerCancel()
+	registerError := g.Register(registerCtx, gophkeeper.Credential{Username: "test", Password: "qwerty"})
+	assert.ErrorIs(t, registerError, gophkeeper.ErrUnavailable, "unexpected error while the database is unavailable")
+}
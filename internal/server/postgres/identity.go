@@ -1,36 +1,54 @@
 package postgres
 
 import (
-	"bufio"
+	"bytes"
 	"context"
+	"crypto/sha256"
 	"encoding/base64"
 	"errors"
+	"fmt"
 	"log"
-	"os"
-	"path"
+	"strings"
+	"time"
 
-	"github.com/google/uuid"
 	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/kerelape/gophkeeper/internal/blobstore"
+	"github.com/kerelape/gophkeeper/internal/cursor"
+	"github.com/kerelape/gophkeeper/internal/server"
 	"github.com/kerelape/gophkeeper/pkg/gophkeeper"
 	"golang.org/x/crypto/bcrypt"
 )
 
 // Identity is a postgres identity.
 type Identity struct {
-	Connection       *pgx.Conn
+	Connection       Connection
 	PasswordEncoding *base64.Encoding
-	BlobsDir         string
+	Blobs            blobstore.BlobStore
+	VersionsLimit    int
+	AtRest           server.AtRest
+	BlobAtRest       server.AtRest
+	Compression      bool
+	Deduplication    bool
 
 	Username string
 }
 
-var _ gophkeeper.Identity = (*Identity)(nil)
+var (
+	_ gophkeeper.Identity  = (*Identity)(nil)
+	_ server.VaultUnlocker = (*Identity)(nil)
+)
 
 // StorePiece implements Identity.
 func (i *Identity) StorePiece(ctx context.Context, piece gophkeeper.Piece, password string) (gophkeeper.ResourceID, error) {
-	if err := i.comparePassword(ctx, password); err != nil {
-		return -1, errors.Join(err, gophkeeper.ErrBadCredential)
+	vaultCheck, passwordError := i.verifyVaultPassword(ctx, password)
+	if passwordError != nil {
+		return -1, errors.Join(passwordError, gophkeeper.ErrBadCredential)
+	}
+
+	content, sealError := i.AtRest.SealBytes(ctx, piece.Content)
+	if sealError != nil {
+		return -1, sealError
 	}
 
 	transaction, transactionError := i.Connection.Begin(ctx)
@@ -38,7 +56,19 @@ func (i *Identity) StorePiece(ctx context.Context, piece gophkeeper.Piece, passw
 		return -1, transactionError
 	}
 
-	insertPieceResult := transaction.QueryRow(ctx, `INSERT INTO pieces(content) VALUES($1) RETURNING id`, piece.Content)
+	if err := i.lockVaultPassword(ctx, transaction, vaultCheck); err != nil {
+		if err := transaction.Rollback(ctx); err != nil {
+			return -1, err
+		}
+		return -1, err
+	}
+
+	hash := sha256.Sum256(piece.Content)
+	insertPieceResult := transaction.QueryRow(
+		ctx,
+		`INSERT INTO pieces(content, size, hash) VALUES($1, $2, $3) RETURNING id`,
+		content, len(piece.Content), hash[:],
+	)
 	var id int
 	if err := insertPieceResult.Scan(&id); err != nil {
 		if err := transaction.Rollback(ctx); err != nil {
@@ -48,8 +78,8 @@ func (i *Identity) StorePiece(ctx context.Context, piece gophkeeper.Piece, passw
 	}
 	insertResourceResult := transaction.QueryRow(
 		ctx,
-		`INSERT INTO resources(meta, resource, type, owner) VALUES($1, $2, $3, $4) RETURNING id`,
-		piece.Meta, id, (int)(gophkeeper.ResourceTypePiece), i.Username,
+		`INSERT INTO resources(meta, resource, type, owner, tokens) VALUES($1, $2, $3, $4, $5) RETURNING id`,
+		piece.Meta, id, (int)(gophkeeper.ResourceTypePiece), i.Username, piece.Index,
 	)
 	var rid int64
 	if err := insertResourceResult.Scan(&rid); err != nil {
@@ -70,7 +100,7 @@ func (i *Identity) StorePiece(ctx context.Context, piece gophkeeper.Piece, passw
 
 // RestorePiece implements Identity.
 func (i *Identity) RestorePiece(ctx context.Context, rid gophkeeper.ResourceID, password string) (gophkeeper.Piece, error) {
-	if err := i.comparePassword(ctx, password); err != nil {
+	if err := i.compareVaultPassword(ctx, password); err != nil {
 		return gophkeeper.Piece{}, errors.Join(err, gophkeeper.ErrBadCredential)
 	}
 
@@ -80,7 +110,8 @@ func (i *Identity) RestorePiece(ctx context.Context, rid gophkeeper.ResourceID,
 	)
 	queryResourceResult := i.Connection.QueryRow(
 		ctx,
-		`SELECT meta, resource FROM resources WHERE id = $1 AND owner = $2 AND type = $3`,
+		`UPDATE resources SET accessed_at = CURRENT_TIMESTAMP
+		WHERE id = $1 AND owner = $2 AND type = $3 AND deleted_at IS NULL RETURNING meta, resource`,
 		(int64)(rid), i.Username, (int)(gophkeeper.ResourceTypePiece),
 	)
 	var id int
@@ -98,6 +129,10 @@ func (i *Identity) RestorePiece(ctx context.Context, rid gophkeeper.ResourceID,
 	if err := queryPieceResult.Scan(&content); err != nil {
 		return gophkeeper.Piece{}, err
 	}
+	content, openError := i.AtRest.OpenBytes(ctx, content)
+	if openError != nil {
+		return gophkeeper.Piece{}, openError
+	}
 
 	piece := gophkeeper.Piece{
 		Meta:    meta,
@@ -109,29 +144,15 @@ func (i *Identity) RestorePiece(ctx context.Context, rid gophkeeper.ResourceID,
 // StoreBlob implements Identity.
 func (i *Identity) StoreBlob(ctx context.Context, blob gophkeeper.Blob, password string) (gophkeeper.ResourceID, error) {
 	defer blob.Content.Close()
-	if err := i.comparePassword(ctx, password); err != nil {
-		return -1, errors.Join(err, gophkeeper.ErrBadCredential)
+	vaultCheck, passwordError := i.verifyVaultPassword(ctx, password)
+	if passwordError != nil {
+		return -1, errors.Join(passwordError, gophkeeper.ErrBadCredential)
 	}
 
-	location := path.Join(i.BlobsDir, uuid.New().String())
-	file, createError := os.Create(location)
-	if createError != nil {
-		return -1, createError
-	}
-
-	if _, err := bufio.NewWriter(file).ReadFrom(blob.Content); err != nil {
-		log.Printf("failed to write file: %s\n", err.Error())
-		if err := file.Close(); err != nil {
-			log.Printf("failed to close file: %s\n", err.Error())
-		}
-		if err := os.Remove(location); err != nil {
-			log.Printf("failed to remove file: %s\n", err.Error())
-		}
-		return -1, err
-	}
-	if err := file.Close(); err != nil {
-		log.Printf("failed to close file: %s\n", err.Error())
-		return -1, err
+	location, size, hash, writeError := i.writeBlob(ctx, blob.Content)
+	if writeError != nil {
+		log.Printf("failed to write file: %s\n", writeError.Error())
+		return -1, writeError
 	}
 
 	transaction, transactionError := i.Connection.Begin(ctx)
@@ -139,6 +160,14 @@ func (i *Identity) StoreBlob(ctx context.Context, blob gophkeeper.Blob, password
 		return -1, transactionError
 	}
 
+	if err := i.lockVaultPassword(ctx, transaction, vaultCheck); err != nil {
+		removeBlobs(i.Blobs, []string{location})
+		if err := transaction.Rollback(ctx); err != nil {
+			return -1, err
+		}
+		return -1, err
+	}
+
 	var (
 		blobID int
 		rid    int64
@@ -146,8 +175,8 @@ func (i *Identity) StoreBlob(ctx context.Context, blob gophkeeper.Blob, password
 
 	insertBlobResult := transaction.QueryRow(
 		ctx,
-		`INSERT INTO blobs(location) VALUES($1) RETURNING id`,
-		location,
+		`INSERT INTO blobs(location, size, hash) VALUES($1, $2, $3) RETURNING id`,
+		location, size, hash,
 	)
 	if err := insertBlobResult.Scan(&blobID); err != nil {
 		if err := transaction.Rollback(ctx); err != nil {
@@ -158,8 +187,8 @@ func (i *Identity) StoreBlob(ctx context.Context, blob gophkeeper.Blob, password
 
 	insertResourceResult := transaction.QueryRow(
 		ctx,
-		`INSERT INTO resources(meta, owner, type, resource) VALUES($1, $2, $3, $4) RETURNING id`,
-		blob.Meta, i.Username, gophkeeper.ResourceTypeBlob, blobID,
+		`INSERT INTO resources(meta, owner, type, resource, tokens) VALUES($1, $2, $3, $4, $5) RETURNING id`,
+		blob.Meta, i.Username, gophkeeper.ResourceTypeBlob, blobID, blob.Index,
 	)
 	if err := insertResourceResult.Scan(&rid); err != nil {
 		if err := transaction.Rollback(ctx); err != nil {
@@ -180,13 +209,14 @@ func (i *Identity) StoreBlob(ctx context.Context, blob gophkeeper.Blob, password
 
 // RestoreBlob implements Identity.
 func (i *Identity) RestoreBlob(ctx context.Context, rid gophkeeper.ResourceID, password string) (gophkeeper.Blob, error) {
-	if err := i.comparePassword(ctx, password); err != nil {
+	if err := i.compareVaultPassword(ctx, password); err != nil {
 		return gophkeeper.Blob{}, errors.Join(err, gophkeeper.ErrBadCredential)
 	}
 
 	selectResourceResult := i.Connection.QueryRow(
 		ctx,
-		`SELECT meta, resource FROM resources WHERE id = $1 AND owner = $2`,
+		`UPDATE resources SET accessed_at = CURRENT_TIMESTAMP
+		WHERE id = $1 AND owner = $2 AND deleted_at IS NULL RETURNING meta, resource`,
 		(int64)(rid), i.Username,
 	)
 	var (
@@ -207,78 +237,323 @@ func (i *Identity) RestoreBlob(ctx context.Context, rid gophkeeper.ResourceID, p
 		return gophkeeper.Blob{}, err
 	}
 
-	file, fileError := os.Open(location)
-	if fileError != nil {
-		return gophkeeper.Blob{}, fileError
+	content, contentError := i.openBlob(ctx, location)
+	if contentError != nil {
+		return gophkeeper.Blob{}, contentError
 	}
 
 	blob := gophkeeper.Blob{
 		Meta:    meta,
-		Content: file,
+		Content: content,
 	}
 	return blob, nil
 }
 
-// Delete implements Identity.
-func (i *Identity) Delete(ctx context.Context, rid gophkeeper.ResourceID) error {
+// UpdatePiece implements Identity.
+func (i *Identity) UpdatePiece(ctx context.Context, rid gophkeeper.ResourceID, piece gophkeeper.Piece, password string) error {
+	vaultCheck, passwordError := i.verifyVaultPassword(ctx, password)
+	if passwordError != nil {
+		return errors.Join(passwordError, gophkeeper.ErrBadCredential)
+	}
+
+	content, sealError := i.AtRest.SealBytes(ctx, piece.Content)
+	if sealError != nil {
+		return sealError
+	}
+
 	transaction, transactionError := i.Connection.Begin(ctx)
 	if transactionError != nil {
 		return transactionError
 	}
 
-	deleteResourceResult := transaction.QueryRow(
+	if err := i.lockVaultPassword(ctx, transaction, vaultCheck); err != nil {
+		if err := transaction.Rollback(ctx); err != nil {
+			return err
+		}
+		return err
+	}
+
+	selectResourceResult := transaction.QueryRow(
+		ctx,
+		`SELECT resource FROM resources WHERE id = $1 AND owner = $2 AND type = $3 AND deleted_at IS NULL FOR UPDATE`,
+		(int64)(rid), i.Username, (int)(gophkeeper.ResourceTypePiece),
+	)
+	var id int
+	if err := selectResourceResult.Scan(&id); err != nil {
+		if err := transaction.Rollback(ctx); err != nil {
+			return err
+		}
+		if errors.Is(err, pgx.ErrNoRows) {
+			return gophkeeper.ErrResourceNotFound
+		}
+		return err
+	}
+
+	dropped, pushError := i.pushVersion(ctx, transaction, rid)
+	if pushError != nil {
+		if err := transaction.Rollback(ctx); err != nil {
+			return err
+		}
+		return pushError
+	}
+
+	if _, err := transaction.Exec(
+		ctx,
+		`UPDATE resources SET meta = $1, tokens = $2, updated_at = CURRENT_TIMESTAMP WHERE id = $3`,
+		piece.Meta, piece.Index, (int64)(rid),
+	); err != nil {
+		if err := transaction.Rollback(ctx); err != nil {
+			return err
+		}
+		return err
+	}
+
+	hash := sha256.Sum256(piece.Content)
+	if _, err := transaction.Exec(
+		ctx,
+		`UPDATE pieces SET content = $1, size = $2, hash = $3 WHERE id = $4`,
+		content, len(piece.Content), hash[:], id,
+	); err != nil {
+		if err := transaction.Rollback(ctx); err != nil {
+			return err
+		}
+		return err
+	}
+
+	if err := transaction.Commit(ctx); err != nil {
+		if err := transaction.Rollback(ctx); err != nil {
+			return err
+		}
+		return err
+	}
+
+	removeBlobs(i.Blobs, dropped)
+	return nil
+}
+
+// UpdateBlob implements Identity.
+func (i *Identity) UpdateBlob(ctx context.Context, rid gophkeeper.ResourceID, blob gophkeeper.Blob, password string) error {
+	defer blob.Content.Close()
+	vaultCheck, passwordError := i.verifyVaultPassword(ctx, password)
+	if passwordError != nil {
+		return errors.Join(passwordError, gophkeeper.ErrBadCredential)
+	}
+
+	location, size, hash, writeError := i.writeBlob(ctx, blob.Content)
+	if writeError != nil {
+		log.Printf("failed to write file: %s\n", writeError.Error())
+		return writeError
+	}
+
+	transaction, transactionError := i.Connection.Begin(ctx)
+	if transactionError != nil {
+		removeBlobs(i.Blobs, []string{location})
+		return transactionError
+	}
+
+	if err := i.lockVaultPassword(ctx, transaction, vaultCheck); err != nil {
+		removeBlobs(i.Blobs, []string{location})
+		if err := transaction.Rollback(ctx); err != nil {
+			return err
+		}
+		return err
+	}
+
+	selectResourceResult := transaction.QueryRow(
+		ctx,
+		`SELECT resource FROM resources WHERE id = $1 AND owner = $2 AND type = $3 AND deleted_at IS NULL FOR UPDATE`,
+		(int64)(rid), i.Username, (int)(gophkeeper.ResourceTypeBlob),
+	)
+	var blobID int
+	if err := selectResourceResult.Scan(&blobID); err != nil {
+		removeBlobs(i.Blobs, []string{location})
+		if err := transaction.Rollback(ctx); err != nil {
+			return err
+		}
+		if errors.Is(err, pgx.ErrNoRows) {
+			return gophkeeper.ErrResourceNotFound
+		}
+		return err
+	}
+
+	dropped, pushError := i.pushVersion(ctx, transaction, rid)
+	if pushError != nil {
+		removeBlobs(i.Blobs, []string{location})
+		if err := transaction.Rollback(ctx); err != nil {
+			return err
+		}
+		return pushError
+	}
+
+	if _, err := transaction.Exec(
+		ctx,
+		`UPDATE resources SET meta = $1, tokens = $2, updated_at = CURRENT_TIMESTAMP WHERE id = $3`,
+		blob.Meta, blob.Index, (int64)(rid),
+	); err != nil {
+		removeBlobs(i.Blobs, []string{location})
+		if err := transaction.Rollback(ctx); err != nil {
+			return err
+		}
+		return err
+	}
+
+	if _, err := transaction.Exec(
+		ctx,
+		`UPDATE blobs SET location = $1, size = $2, hash = $3 WHERE id = $4`,
+		location, size, hash, blobID,
+	); err != nil {
+		removeBlobs(i.Blobs, []string{location})
+		if err := transaction.Rollback(ctx); err != nil {
+			return err
+		}
+		return err
+	}
+
+	if err := transaction.Commit(ctx); err != nil {
+		removeBlobs(i.Blobs, []string{location})
+		if err := transaction.Rollback(ctx); err != nil {
+			return err
+		}
+		return err
+	}
+
+	removeBlobs(i.Blobs, dropped)
+	return nil
+}
+
+// ListVersions implements Identity.
+func (i *Identity) ListVersions(ctx context.Context, rid gophkeeper.ResourceID) ([]gophkeeper.ResourceVersion, error) {
+	var resourceType int
+	selectResourceResult := i.Connection.QueryRow(
+		ctx,
+		`SELECT type FROM resources WHERE id = $1 AND owner = $2 AND deleted_at IS NULL`,
+		(int64)(rid), i.Username,
+	)
+	if err := selectResourceResult.Scan(&resourceType); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, gophkeeper.ErrResourceNotFound
+		}
+		return nil, err
+	}
+
+	selectVersionsResult, selectVersionsError := i.Connection.Query(
+		ctx,
+		`SELECT version, meta, created_at FROM resource_versions WHERE resource = $1 ORDER BY version DESC`,
+		(int64)(rid),
+	)
+	if selectVersionsError != nil {
+		return nil, selectVersionsError
+	}
+	defer selectVersionsResult.Close()
+
+	versions := make([]gophkeeper.ResourceVersion, 0)
+	for selectVersionsResult.Next() {
+		var version gophkeeper.ResourceVersion
+		if err := selectVersionsResult.Scan(&version.Version, &version.Meta, &version.CreatedAt); err != nil {
+			return nil, err
+		}
+		versions = append(versions, version)
+	}
+	if err := selectVersionsResult.Err(); err != nil {
+		return nil, err
+	}
+	return versions, nil
+}
+
+// RestoreVersion implements Identity.
+func (i *Identity) RestoreVersion(ctx context.Context, rid gophkeeper.ResourceID, version int, password string) error {
+	if err := i.compareVaultPassword(ctx, password); err != nil {
+		return errors.Join(err, gophkeeper.ErrBadCredential)
+	}
+
+	transaction, transactionError := i.Connection.Begin(ctx)
+	if transactionError != nil {
+		return transactionError
+	}
+
+	selectResourceResult := transaction.QueryRow(
 		ctx,
-		`DELETE FROM resources WHERE id = $1 AND owner = $2 RETURNING type, resource`,
+		`SELECT type, resource FROM resources WHERE id = $1 AND owner = $2 AND deleted_at IS NULL FOR UPDATE`,
 		(int64)(rid), i.Username,
 	)
 	var (
 		resourceType int
 		resourceID   int
 	)
-	if err := deleteResourceResult.Scan(&resourceType, &resourceID); err != nil {
+	if err := selectResourceResult.Scan(&resourceType, &resourceID); err != nil {
+		if err := transaction.Rollback(ctx); err != nil {
+			return err
+		}
+		if errors.Is(err, pgx.ErrNoRows) {
+			return gophkeeper.ErrResourceNotFound
+		}
+		return err
+	}
+
+	deleteVersionResult := transaction.QueryRow(
+		ctx,
+		`DELETE FROM resource_versions WHERE resource = $1 AND version = $2 RETURNING meta, tokens, content, location, size, hash`,
+		(int64)(rid), version,
+	)
+	var (
+		meta     string
+		tokens   []string
+		content  []byte
+		location *string
+		size     *int64
+		hash     []byte
+	)
+	if err := deleteVersionResult.Scan(&meta, &tokens, &content, &location, &size, &hash); err != nil {
+		if err := transaction.Rollback(ctx); err != nil {
+			return err
+		}
 		if errors.Is(err, pgx.ErrNoRows) {
-			if err := transaction.Rollback(ctx); err != nil {
-				return err
-			}
 			return gophkeeper.ErrResourceNotFound
 		}
 		return err
 	}
 
+	dropped, pushError := i.pushVersion(ctx, transaction, rid)
+	if pushError != nil {
+		if err := transaction.Rollback(ctx); err != nil {
+			return err
+		}
+		return pushError
+	}
+
+	if _, err := transaction.Exec(
+		ctx,
+		`UPDATE resources SET meta = $1, tokens = $2, updated_at = CURRENT_TIMESTAMP WHERE id = $3`,
+		meta, tokens, (int64)(rid),
+	); err != nil {
+		if err := transaction.Rollback(ctx); err != nil {
+			return err
+		}
+		return err
+	}
+
+	var restoreError error
 	switch (gophkeeper.ResourceType)(resourceType) {
 	case gophkeeper.ResourceTypePiece:
-		_, err := transaction.Exec(
+		_, restoreError = transaction.Exec(
 			ctx,
-			`DELETE FROM pieces WHERE id = $1`,
-			resourceID,
+			`UPDATE pieces SET content = $1, size = $2, hash = $3 WHERE id = $4`,
+			content, size, hash, resourceID,
 		)
-		if err != nil {
-			if err := transaction.Rollback(ctx); err != nil {
-				return err
-			}
-			return err
-		}
 	case gophkeeper.ResourceTypeBlob:
-		deleteResult := transaction.QueryRow(
+		_, restoreError = transaction.Exec(
 			ctx,
-			`DELETE FROM blobs WHERE id = $1 RETURNING location`,
-			resourceID,
+			`UPDATE blobs SET location = $1, size = $2, hash = $3 WHERE id = $4`,
+			location, size, hash, resourceID,
 		)
-		var location string
-		if err := deleteResult.Scan(&location); err != nil {
-			if err := transaction.Rollback(ctx); err != nil {
-				return err
-			}
-			return err
-		}
-		if err := os.Remove(location); err != nil {
-			if err := transaction.Rollback(ctx); err != nil {
-				return err
-			}
+	default:
+		restoreError = errors.New("unknown resource type")
+	}
+	if restoreError != nil {
+		if err := transaction.Rollback(ctx); err != nil {
 			return err
 		}
-	default:
-		return errors.New("unknown resource type")
+		return restoreError
 	}
 
 	if err := transaction.Commit(ctx); err != nil {
@@ -287,55 +562,834 @@ func (i *Identity) Delete(ctx context.Context, rid gophkeeper.ResourceID) error
 		}
 		return err
 	}
+
+	removeBlobs(i.Blobs, dropped)
 	return nil
 }
 
-// List implements Identity.
-func (i *Identity) List(ctx context.Context) ([]gophkeeper.Resource, error) {
+// Delete implements Identity.
+func (i *Identity) Delete(ctx context.Context, rid gophkeeper.ResourceID) error {
+	result, updateError := i.Connection.Exec(
+		ctx,
+		`UPDATE resources SET deleted_at = CURRENT_TIMESTAMP WHERE id = $1 AND owner = $2 AND deleted_at IS NULL`,
+		(int64)(rid), i.Username,
+	)
+	if updateError != nil {
+		return updateError
+	}
+	if result.RowsAffected() == 0 {
+		return gophkeeper.ErrResourceNotFound
+	}
+	return nil
+}
+
+// ListTrash implements Identity.
+func (i *Identity) ListTrash(ctx context.Context) ([]gophkeeper.TrashedResource, error) {
 	selectResourcesResult, selectResourcesResultError := i.Connection.Query(
 		ctx,
-		`SELECT id, type, meta FROM resources WHERE owner = $1`,
-		i.Username,
+		selectResources+` WHERE r.owner = $1 AND r.deleted_at IS NOT NULL`,
+		i.Username, (int)(gophkeeper.ResourceTypePiece), (int)(gophkeeper.ResourceTypeBlob),
 	)
 	if selectResourcesResultError != nil {
 		return nil, selectResourcesResultError
 	}
 	defer selectResourcesResult.Close()
-	var resources []gophkeeper.Resource
+	resources := make([]gophkeeper.TrashedResource, 0)
 	for selectResourcesResult.Next() {
-		if err := selectResourcesResult.Err(); err != nil {
-			return nil, err
-		}
-		var resource gophkeeper.Resource
-		if err := selectResourcesResult.Scan(&resource.ID, &resource.Type, &resource.Meta); err != nil {
-			return nil, err
+		resource, scanError := scanResource(selectResourcesResult)
+		if scanError != nil {
+			return nil, scanError
 		}
 		resources = append(resources, resource)
 	}
+	if err := selectResourcesResult.Err(); err != nil {
+		return nil, err
+	}
 	return resources, nil
 }
 
-func (i *Identity) comparePassword(ctx context.Context, password string) error {
-	row := i.Connection.QueryRow(
+// Undelete implements Identity.
+func (i *Identity) Undelete(ctx context.Context, rid gophkeeper.ResourceID) error {
+	result, updateError := i.Connection.Exec(
 		ctx,
-		`SELECT password FROM identities WHERE username = $1`,
-		i.Username,
+		`UPDATE resources SET deleted_at = NULL WHERE id = $1 AND owner = $2 AND deleted_at IS NOT NULL`,
+		(int64)(rid), i.Username,
 	)
-	var encodedPassword string
-	if err := row.Scan(&encodedPassword); err != nil {
-		var pgerr pgconn.PgError
-		if errors.As(err, (any)(&pgerr)) {
-			return gophkeeper.ErrBadCredential
+	if updateError != nil {
+		return updateError
+	}
+	if result.RowsAffected() == 0 {
+		return gophkeeper.ErrResourceNotFound
+	}
+	return nil
+}
+
+// Purge implements Identity.
+func (i *Identity) Purge(ctx context.Context, rid gophkeeper.ResourceID) error {
+	transaction, transactionError := i.Connection.Begin(ctx)
+	if transactionError != nil {
+		return transactionError
+	}
+
+	selectResourceResult := transaction.QueryRow(
+		ctx,
+		`SELECT id FROM resources WHERE id = $1 AND owner = $2 AND deleted_at IS NOT NULL FOR UPDATE`,
+		(int64)(rid), i.Username,
+	)
+	var id int64
+	if err := selectResourceResult.Scan(&id); err != nil {
+		if err := transaction.Rollback(ctx); err != nil {
+			return err
+		}
+		if errors.Is(err, pgx.ErrNoRows) {
+			return gophkeeper.ErrResourceNotFound
 		}
 		return err
 	}
 
-	decodedPassword, decodePasswordError := i.PasswordEncoding.DecodeString(encodedPassword)
-	if decodePasswordError != nil {
-		return decodePasswordError
-	}
-	if err := bcrypt.CompareHashAndPassword(decodedPassword, ([]byte)(password)); err != nil {
+	dropped, purgeError := purgeResource(ctx, transaction, rid)
+	if purgeError != nil {
+		if err := transaction.Rollback(ctx); err != nil {
+			return err
+		}
+		return purgeError
+	}
+
+	if err := transaction.Commit(ctx); err != nil {
+		if err := transaction.Rollback(ctx); err != nil {
+			return err
+		}
+		return err
+	}
+
+	removeBlobs(i.Blobs, dropped)
+	return nil
+}
+
+// CreateFolder implements Identity.
+func (i *Identity) CreateFolder(ctx context.Context, name string, parent gophkeeper.FolderID) (gophkeeper.FolderID, error) {
+	insertFolderResult := i.Connection.QueryRow(
+		ctx,
+		`INSERT INTO folders(owner, parent, name) SELECT $1, $2, $3
+		WHERE $2 = 0 OR EXISTS (SELECT 1 FROM folders WHERE id = $2 AND owner = $1) RETURNING id`,
+		i.Username, (int64)(parent), name,
+	)
+	var id int64
+	if err := insertFolderResult.Scan(&id); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return -1, gophkeeper.ErrFolderNotFound
+		}
+		return -1, err
+	}
+	return (gophkeeper.FolderID)(id), nil
+}
+
+// ListFolders implements Identity.
+func (i *Identity) ListFolders(ctx context.Context) ([]gophkeeper.Folder, error) {
+	selectFoldersResult, selectFoldersError := i.Connection.Query(
+		ctx,
+		`SELECT id, parent, name FROM folders WHERE owner = $1 ORDER BY id`,
+		i.Username,
+	)
+	if selectFoldersError != nil {
+		return nil, selectFoldersError
+	}
+	defer selectFoldersResult.Close()
+
+	folders := make([]gophkeeper.Folder, 0)
+	for selectFoldersResult.Next() {
+		var folder gophkeeper.Folder
+		if err := selectFoldersResult.Scan(&folder.ID, &folder.Parent, &folder.Name); err != nil {
+			return nil, err
+		}
+		folders = append(folders, folder)
+	}
+	if err := selectFoldersResult.Err(); err != nil {
+		return nil, err
+	}
+	return folders, nil
+}
+
+// MoveFolder implements Identity.
+func (i *Identity) MoveFolder(ctx context.Context, folder gophkeeper.FolderID, parent gophkeeper.FolderID) error {
+	transaction, transactionError := i.Connection.Begin(ctx)
+	if transactionError != nil {
+		return transactionError
+	}
+
+	// Lock all folders of the identity so that concurrent
+	// moves can not make a cycle together.
+	if _, err := transaction.Exec(ctx, `SELECT id FROM folders WHERE owner = $1 FOR UPDATE`, i.Username); err != nil {
+		if err := transaction.Rollback(ctx); err != nil {
+			return err
+		}
+		return err
+	}
+
+	checkResult := transaction.QueryRow(
+		ctx,
+		`SELECT
+			EXISTS (SELECT 1 FROM folders WHERE id = $2 AND owner = $1),
+			$3 = 0 OR EXISTS (SELECT 1 FROM folders WHERE id = $3 AND owner = $1),
+			EXISTS (
+				WITH RECURSIVE ancestors(id, parent) AS (
+					SELECT id, parent FROM folders WHERE id = $3 AND owner = $1
+					UNION
+					SELECT f.id, f.parent FROM folders f JOIN ancestors a ON f.id = a.parent
+				) SELECT 1 FROM ancestors WHERE id = $2
+			)`,
+		i.Username, (int64)(folder), (int64)(parent),
+	)
+	var (
+		folderExists bool
+		parentExists bool
+		cycle        bool
+	)
+	if err := checkResult.Scan(&folderExists, &parentExists, &cycle); err != nil {
+		if err := transaction.Rollback(ctx); err != nil {
+			return err
+		}
+		return err
+	}
+	var checkError error
+	switch {
+	case !folderExists || !parentExists:
+		checkError = gophkeeper.ErrFolderNotFound
+	case cycle:
+		checkError = gophkeeper.ErrFolderCycle
+	}
+	if checkError != nil {
+		if err := transaction.Rollback(ctx); err != nil {
+			return err
+		}
+		return checkError
+	}
+
+	if _, err := transaction.Exec(ctx, `UPDATE folders SET parent = $1 WHERE id = $2`, (int64)(parent), (int64)(folder)); err != nil {
+		if err := transaction.Rollback(ctx); err != nil {
+			return err
+		}
+		return err
+	}
+
+	if err := transaction.Commit(ctx); err != nil {
+		if err := transaction.Rollback(ctx); err != nil {
+			return err
+		}
+		return err
+	}
+	return nil
+}
+
+// MoveResource implements Identity.
+func (i *Identity) MoveResource(ctx context.Context, rid gophkeeper.ResourceID, folder gophkeeper.FolderID) error {
+	if err := i.checkResource(ctx, rid); err != nil {
+		return err
+	}
+
+	if folder != gophkeeper.RootFolder {
+		var exists bool
+		selectFolderResult := i.Connection.QueryRow(
+			ctx,
+			`SELECT EXISTS (SELECT 1 FROM folders WHERE id = $1 AND owner = $2)`,
+			(int64)(folder), i.Username,
+		)
+		if err := selectFolderResult.Scan(&exists); err != nil {
+			return err
+		}
+		if !exists {
+			return gophkeeper.ErrFolderNotFound
+		}
+	}
+
+	result, updateError := i.Connection.Exec(
+		ctx,
+		`UPDATE resources SET folder = $1 WHERE id = $2 AND owner = $3 AND deleted_at IS NULL`,
+		(int64)(folder), (int64)(rid), i.Username,
+	)
+	if updateError != nil {
+		return updateError
+	}
+	if result.RowsAffected() == 0 {
+		return gophkeeper.ErrResourceNotFound
+	}
+	return nil
+}
+
+// Tag implements Identity.
+func (i *Identity) Tag(ctx context.Context, rid gophkeeper.ResourceID, tag string, index ...string) error {
+	if err := i.checkResource(ctx, rid); err != nil {
+		return err
+	}
+	_, insertError := i.Connection.Exec(
+		ctx,
+		`INSERT INTO resource_tags(resource, tag, tokens) VALUES($1, $2, $3)
+		ON CONFLICT (resource, tag) DO UPDATE SET tokens = EXCLUDED.tokens`,
+		(int64)(rid), tag, index,
+	)
+	return insertError
+}
+
+// Untag implements Identity.
+func (i *Identity) Untag(ctx context.Context, rid gophkeeper.ResourceID, tag string) error {
+	if err := i.checkResource(ctx, rid); err != nil {
+		return err
+	}
+	_, deleteError := i.Connection.Exec(
+		ctx,
+		`DELETE FROM resource_tags WHERE resource = $1 AND tag = $2`,
+		(int64)(rid), tag,
+	)
+	return deleteError
+}
+
+// List implements Identity.
+func (i *Identity) List(ctx context.Context, options gophkeeper.ListOptions) (gophkeeper.ListPage, error) {
+	var (
+		query strings.Builder
+		args  = []any{i.Username, (int)(gophkeeper.ResourceTypePiece), (int)(gophkeeper.ResourceTypeBlob)}
+		arg   = func(value any) string {
+			args = append(args, value)
+			return fmt.Sprintf("$%d", len(args))
+		}
+	)
+	query.WriteString(selectResources)
+	query.WriteString(` WHERE r.owner = $1 AND r.deleted_at IS NULL`)
+	if options.ID != nil {
+		fmt.Fprintf(&query, ` AND r.id = %s`, arg((int64)(*options.ID)))
+	}
+	if options.Folder != nil {
+		fmt.Fprintf(&query, ` AND r.folder = %s`, arg((int64)(*options.Folder)))
+	}
+	if options.Tag != "" {
+		fmt.Fprintf(
+			&query,
+			` AND EXISTS (SELECT 1 FROM resource_tags t WHERE t.resource = r.id AND t.tag = %s)`,
+			arg(options.Tag),
+		)
+	}
+	if options.Type != nil {
+		fmt.Fprintf(&query, ` AND r.type = %s`, arg((int)(*options.Type)))
+	}
+	if options.MetaPrefix != "" {
+		fmt.Fprintf(&query, ` AND starts_with(r.meta, %s)`, arg(options.MetaPrefix))
+	}
+
+	key, direction, comparison := listOrderKey(options.Order), "ASC", ">"
+	if options.Descending {
+		direction, comparison = "DESC", "<"
+	}
+	if options.Cursor != "" {
+		position, positionError := cursor.Decode(options.Cursor, options)
+		if positionError != nil {
+			return gophkeeper.ListPage{}, positionError
+		}
+		var value any = position.Key
+		if options.Order == gophkeeper.ListOrderCreated || options.Order == gophkeeper.ListOrderUpdated {
+			value = time.UnixMicro(position.Key)
+		}
+		fmt.Fprintf(
+			&query,
+			` AND (%s, r.id) %s (%s, %s)`,
+			key, comparison, arg(value), arg((int64)(position.ID)),
+		)
+	}
+	fmt.Fprintf(&query, ` ORDER BY %s %s, r.id %s`, key, direction, direction)
+	if options.Limit > 0 {
+		// Select one extra resource to find out whether there is a next page.
+		fmt.Fprintf(&query, ` LIMIT %s`, arg(options.Limit+1))
+	}
+
+	selectResourcesResult, selectResourcesResultError := i.Connection.Query(ctx, query.String(), args...)
+	if selectResourcesResultError != nil {
+		return gophkeeper.ListPage{}, selectResourcesResultError
+	}
+	defer selectResourcesResult.Close()
+	resources := make([]gophkeeper.Resource, 0)
+	for selectResourcesResult.Next() {
+		resource, scanError := scanResource(selectResourcesResult)
+		if scanError != nil {
+			return gophkeeper.ListPage{}, scanError
+		}
+		resources = append(resources, resource.Resource)
+	}
+	if err := selectResourcesResult.Err(); err != nil {
+		return gophkeeper.ListPage{}, err
+	}
+
+	var page gophkeeper.ListPage
+	if options.Limit > 0 && len(resources) > options.Limit {
+		resources = resources[:options.Limit]
+		page.Next = cursor.Encode(cursor.Of(resources[len(resources)-1], options))
+	}
+	page.Resources = resources
+	return page, nil
+}
+
+// Search implements Identity.
+func (i *Identity) Search(ctx context.Context, tokens []string) ([]gophkeeper.Resource, error) {
+	if tokens == nil {
+		tokens = make([]string, 0)
+	}
+	selectResourcesResult, selectResourcesError := i.Connection.Query(
+		ctx,
+		selectResources+` WHERE r.owner = $1 AND r.deleted_at IS NULL
+		AND $4::TEXT[] <@ (COALESCE(r.tokens, '{}') || ARRAY(
+			SELECT unnest(t.tokens) FROM resource_tags t WHERE t.resource = r.id
+		))
+		ORDER BY r.id`,
+		i.Username, (int)(gophkeeper.ResourceTypePiece), (int)(gophkeeper.ResourceTypeBlob), tokens,
+	)
+	if selectResourcesError != nil {
+		return nil, selectResourcesError
+	}
+	defer selectResourcesResult.Close()
+
+	resources := make([]gophkeeper.Resource, 0)
+	for selectResourcesResult.Next() {
+		resource, scanError := scanResource(selectResourcesResult)
+		if scanError != nil {
+			return nil, scanError
+		}
+		resources = append(resources, resource.Resource)
+	}
+	return resources, selectResourcesResult.Err()
+}
+
+// listOrderKey returns the column resources are ordered by.
+func listOrderKey(order gophkeeper.ListOrder) string {
+	switch order {
+	case gophkeeper.ListOrderCreated:
+		return "r.created_at"
+	case gophkeeper.ListOrderUpdated:
+		return "r.updated_at"
+	case gophkeeper.ListOrderSize:
+		return "COALESCE(p.size, b.size, 0)"
+	default:
+		return "r.id"
+	}
+}
+
+// ChangePassword implements Identity.
+//
+// The vault password of an identity registered before vault passwords
+// were introduced is the login password, so it is kept as the old one.
+func (i *Identity) ChangePassword(ctx context.Context, oldPassword, newPassword string) error {
+	if err := i.comparePassword(ctx, oldPassword); err != nil {
+		return errors.Join(err, gophkeeper.ErrBadCredential)
+	}
+
+	password, passwordError := bcrypt.GenerateFromPassword(([]byte)(newPassword), bcrypt.DefaultCost)
+	if passwordError != nil {
+		return passwordError
+	}
+	vaultCheck, vaultCheckError := server.NewVaultCheck(oldPassword)
+	if vaultCheckError != nil {
+		return vaultCheckError
+	}
+
+	_, updateError := i.Connection.Exec(
+		ctx,
+		`UPDATE identities SET password = $1, vault_check = COALESCE(vault_check, $2) WHERE username = $3`,
+		i.PasswordEncoding.EncodeToString(password), vaultCheck, i.Username,
+	)
+	return updateError
+}
+
+// ChangeVaultPassword implements Identity.
+//
+// The stored content is left as is, a pending re-encryption is discarded.
+func (i *Identity) ChangeVaultPassword(ctx context.Context, oldPassword, newPassword string) error {
+	if err := i.compareVaultPassword(ctx, oldPassword); err != nil {
+		return errors.Join(err, gophkeeper.ErrBadCredential)
+	}
+
+	vaultCheck, vaultCheckError := server.NewVaultCheck(newPassword)
+	if vaultCheckError != nil {
+		return vaultCheckError
+	}
+
+	transaction, transactionError := i.Connection.Begin(ctx)
+	if transactionError != nil {
+		return transactionError
+	}
+
+	if _, err := transaction.Exec(
+		ctx,
+		`UPDATE identities SET vault_check = $1 WHERE username = $2`,
+		vaultCheck, i.Username,
+	); err != nil {
+		if err := transaction.Rollback(ctx); err != nil {
+			return err
+		}
+		return err
+	}
+
+	dropped, discardError := i.discardReencryption(ctx, transaction)
+	if discardError != nil {
+		if err := transaction.Rollback(ctx); err != nil {
+			return err
+		}
+		return discardError
+	}
+
+	if err := transaction.Commit(ctx); err != nil {
+		if err := transaction.Rollback(ctx); err != nil {
+			return err
+		}
+		return err
+	}
+
+	removeBlobs(i.Blobs, dropped)
+	return nil
+}
+
+// Settings implements Identity.
+func (i *Identity) Settings(ctx context.Context) (gophkeeper.Settings, error) {
+	row := i.Connection.QueryRow(
+		ctx,
+		`SELECT COALESCE(key_file, FALSE), vault_salt FROM identities WHERE username = $1`,
+		i.Username,
+	)
+	settings := gophkeeper.Settings{
+		Compression:   i.Compression,
+		Deduplication: i.Deduplication,
+	}
+	if err := row.Scan(&settings.KeyFile, &settings.VaultSalt); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return gophkeeper.Settings{}, gophkeeper.ErrBadCredential
+		}
+		return gophkeeper.Settings{}, err
+	}
+	return settings, nil
+}
+
+// UpdateSettings implements Identity.
+func (i *Identity) UpdateSettings(ctx context.Context, settings gophkeeper.Settings) error {
+	_, updateError := i.Connection.Exec(
+		ctx,
+		`UPDATE identities SET key_file = $1 WHERE username = $2`,
+		settings.KeyFile, i.Username,
+	)
+	return updateError
+}
+
+// checkResource returns ErrResourceNotFound unless the resource
+// is owned by the identity and is not in the trash.
+func (i *Identity) checkResource(ctx context.Context, rid gophkeeper.ResourceID) error {
+	var exists bool
+	selectResourceResult := i.Connection.QueryRow(
+		ctx,
+		`SELECT EXISTS (SELECT 1 FROM resources WHERE id = $1 AND owner = $2 AND deleted_at IS NULL)`,
+		(int64)(rid), i.Username,
+	)
+	if err := selectResourceResult.Scan(&exists); err != nil {
+		return err
+	}
+	if !exists {
+		return gophkeeper.ErrResourceNotFound
+	}
+	return nil
+}
+
+// selectResources selects the columns read by scanResource.
+// It expects the piece and the blob resource types
+// as $2 and $3 parameters.
+const selectResources = `SELECT r.id, r.type, r.meta, r.folder,
+	ARRAY(SELECT t.tag FROM resource_tags t WHERE t.resource = r.id ORDER BY t.tag),
+	r.created_at, r.updated_at, r.accessed_at,
+	COALESCE(p.size, b.size, 0), COALESCE(p.hash, b.hash), r.deleted_at
+	FROM resources r
+	LEFT JOIN pieces p ON r.type = $2 AND p.id = r.resource
+	LEFT JOIN blobs b ON r.type = $3 AND b.id = r.resource`
+
+// scanResource scans a row selected with selectResources.
+// DeletedAt is left zero for resources that are not in the trash.
+func scanResource(row pgx.Row) (gophkeeper.TrashedResource, error) {
+	var (
+		resource   gophkeeper.TrashedResource
+		accessedAt *time.Time
+		deletedAt  *time.Time
+	)
+	if err := row.Scan(
+		&resource.ID, &resource.Type, &resource.Meta, &resource.Folder, &resource.Tags,
+		&resource.CreatedAt, &resource.UpdatedAt, &accessedAt, &resource.Size, &resource.Hash, &deletedAt,
+	); err != nil {
+		return gophkeeper.TrashedResource{}, err
+	}
+	if accessedAt != nil {
+		resource.AccessedAt = *accessedAt
+	}
+	if deletedAt != nil {
+		resource.DeletedAt = *deletedAt
+	}
+	return resource, nil
+}
+
+// comparePassword compares the password with the login password of the identity.
+func (i *Identity) comparePassword(ctx context.Context, password string) error {
+	row := i.Connection.QueryRow(
+		ctx,
+		`SELECT password FROM identities WHERE username = $1`,
+		i.Username,
+	)
+	var encodedPassword string
+	if err := row.Scan(&encodedPassword); err != nil {
+		var pgerr pgconn.PgError
+		if errors.As(err, (any)(&pgerr)) {
+			return gophkeeper.ErrBadCredential
+		}
+		return err
+	}
+
+	decodedPassword, decodePasswordError := i.PasswordEncoding.DecodeString(encodedPassword)
+	if decodePasswordError != nil {
+		return decodePasswordError
+	}
+	if err := bcrypt.CompareHashAndPassword(decodedPassword, ([]byte)(password)); err != nil {
 		return errors.Join(gophkeeper.ErrBadCredential, err)
 	}
 	return nil
 }
+
+func (i *Identity) compareVaultPassword(ctx context.Context, password string) error {
+	_, err := i.verifyVaultPassword(ctx, password)
+	return err
+}
+
+// UnlockVault implements server.VaultUnlocker.
+func (i *Identity) UnlockVault(ctx context.Context, password string) ([]byte, error) {
+	_, key, err := i.unlockVault(ctx, password)
+	return key, err
+}
+
+// verifyVaultPassword compares the password with the vault password
+// of the identity and returns its vault check.
+func (i *Identity) verifyVaultPassword(ctx context.Context, password string) ([]byte, error) {
+	vaultCheck, _, err := i.unlockVault(ctx, password)
+	return vaultCheck, err
+}
+
+// unlockVault compares the password with the vault password of the
+// identity and returns its vault check and the key that verifies it.
+// The vault key of the context, if there is one, is compared instead
+// of the password, see server.WithVaultKey.
+//
+// An identity registered before vault passwords were introduced has no
+// vault check, its vault password is the login password and the check
+// is set up on the first use of the vault.
+func (i *Identity) unlockVault(ctx context.Context, password string) ([]byte, []byte, error) {
+	row := i.Connection.QueryRow(
+		ctx,
+		`SELECT vault_check FROM identities WHERE username = $1`,
+		i.Username,
+	)
+	var vaultCheck []byte
+	if err := row.Scan(&vaultCheck); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, nil, gophkeeper.ErrBadCredential
+		}
+		return nil, nil, err
+	}
+	if key := server.VaultKey(ctx); key != nil {
+		if err := server.VerifyVaultCheckKey(vaultCheck, key); err != nil {
+			return nil, nil, err
+		}
+		return vaultCheck, key, nil
+	}
+
+	if vaultCheck == nil {
+		if err := i.comparePassword(ctx, password); err != nil {
+			return nil, nil, err
+		}
+		newVaultCheck, newVaultCheckError := server.NewVaultCheck(password)
+		if newVaultCheckError != nil {
+			return nil, nil, newVaultCheckError
+		}
+		// The check could have been set up concurrently.
+		updateResult := i.Connection.QueryRow(
+			ctx,
+			`UPDATE identities SET vault_check = COALESCE(vault_check, $1) WHERE username = $2 RETURNING vault_check`,
+			newVaultCheck, i.Username,
+		)
+		if err := updateResult.Scan(&vaultCheck); err != nil {
+			return nil, nil, err
+		}
+	}
+	key, keyError := server.VaultCheckKey(vaultCheck, password)
+	if keyError != nil {
+		return nil, nil, keyError
+	}
+	if err := server.VerifyVaultCheckKey(vaultCheck, key); err != nil {
+		return nil, nil, err
+	}
+	return vaultCheck, key, nil
+}
+
+// lockVaultPassword locks the vault password of the identity until the end
+// of the transaction and checks that its vault check is still the one,
+// so content encrypted with a replaced password is never written.
+func (i *Identity) lockVaultPassword(ctx context.Context, transaction pgx.Tx, vaultCheck []byte) error {
+	row := transaction.QueryRow(
+		ctx,
+		`SELECT vault_check FROM identities WHERE username = $1 FOR SHARE`,
+		i.Username,
+	)
+	var currentVaultCheck []byte
+	if err := row.Scan(&currentVaultCheck); err != nil {
+		return err
+	}
+	if !bytes.Equal(currentVaultCheck, vaultCheck) {
+		return gophkeeper.ErrBadCredential
+	}
+	return nil
+}
+
+// pushVersion saves the current state of the resource into its history
+// and drops the revisions beyond the limit. It returns locations of
+// the blob files that are no longer referenced, they must be removed
+// after the transaction is committed.
+func (i *Identity) pushVersion(ctx context.Context, transaction pgx.Tx, rid gophkeeper.ResourceID) ([]string, error) {
+	updateRevisionResult := transaction.QueryRow(
+		ctx,
+		`UPDATE resources SET revision = COALESCE(revision, 0) + 1 WHERE id = $1 RETURNING revision, type, resource`,
+		(int64)(rid),
+	)
+	var (
+		revision     int
+		resourceType int
+		resourceID   int
+	)
+	if err := updateRevisionResult.Scan(&revision, &resourceType, &resourceID); err != nil {
+		return nil, err
+	}
+
+	var insertError error
+	switch (gophkeeper.ResourceType)(resourceType) {
+	case gophkeeper.ResourceTypePiece:
+		_, insertError = transaction.Exec(
+			ctx,
+			`INSERT INTO resource_versions(resource, version, meta, tokens, content, size, hash)
+			SELECT r.id, $2, r.meta, r.tokens, p.content, p.size, p.hash FROM resources r, pieces p WHERE r.id = $1 AND p.id = $3`,
+			(int64)(rid), revision, resourceID,
+		)
+	case gophkeeper.ResourceTypeBlob:
+		_, insertError = transaction.Exec(
+			ctx,
+			`INSERT INTO resource_versions(resource, version, meta, tokens, location, size, hash)
+			SELECT r.id, $2, r.meta, r.tokens, b.location, b.size, b.hash FROM resources r, blobs b WHERE r.id = $1 AND b.id = $3`,
+			(int64)(rid), revision, resourceID,
+		)
+	default:
+		insertError = errors.New("unknown resource type")
+	}
+	if insertError != nil {
+		return nil, insertError
+	}
+
+	pruneResult, pruneError := transaction.Query(
+		ctx,
+		`DELETE FROM resource_versions WHERE resource = $1 AND id NOT IN (
+			SELECT id FROM resource_versions WHERE resource = $1 ORDER BY version DESC LIMIT $2
+		) RETURNING location`,
+		(int64)(rid), i.VersionsLimit,
+	)
+	if pruneError != nil {
+		return nil, pruneError
+	}
+	defer pruneResult.Close()
+
+	dropped := make([]string, 0)
+	for pruneResult.Next() {
+		var location *string
+		if err := pruneResult.Scan(&location); err != nil {
+			return nil, err
+		}
+		if location != nil {
+			dropped = append(dropped, *location)
+		}
+	}
+	return dropped, pruneResult.Err()
+}
+
+// purgeResource permanently deletes the resource with its history.
+// It returns locations of the blob files that are no longer referenced,
+// they must be removed after the transaction is committed.
+func purgeResource(ctx context.Context, transaction pgx.Tx, rid gophkeeper.ResourceID) ([]string, error) {
+	deleteResourceResult := transaction.QueryRow(
+		ctx,
+		`DELETE FROM resources WHERE id = $1 RETURNING type, resource`,
+		(int64)(rid),
+	)
+	var (
+		resourceType int
+		resourceID   int
+	)
+	if err := deleteResourceResult.Scan(&resourceType, &resourceID); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, gophkeeper.ErrResourceNotFound
+		}
+		return nil, err
+	}
+
+	if _, err := transaction.Exec(ctx, `DELETE FROM resource_tags WHERE resource = $1`, (int64)(rid)); err != nil {
+		return nil, err
+	}
+
+	deleteVersionsResult, deleteVersionsError := transaction.Query(
+		ctx,
+		`DELETE FROM resource_versions WHERE resource = $1 RETURNING location`,
+		(int64)(rid),
+	)
+	if deleteVersionsError != nil {
+		return nil, deleteVersionsError
+	}
+	dropped := make([]string, 0)
+	for deleteVersionsResult.Next() {
+		var location *string
+		if err := deleteVersionsResult.Scan(&location); err != nil {
+			deleteVersionsResult.Close()
+			return nil, err
+		}
+		if location != nil {
+			dropped = append(dropped, *location)
+		}
+	}
+	deleteVersionsResult.Close()
+	if err := deleteVersionsResult.Err(); err != nil {
+		return nil, err
+	}
+
+	switch (gophkeeper.ResourceType)(resourceType) {
+	case gophkeeper.ResourceTypePiece:
+		if _, err := transaction.Exec(ctx, `DELETE FROM pieces WHERE id = $1`, resourceID); err != nil {
+			return nil, err
+		}
+	case gophkeeper.ResourceTypeBlob:
+		deleteResult := transaction.QueryRow(
+			ctx,
+			`DELETE FROM blobs WHERE id = $1 RETURNING location`,
+			resourceID,
+		)
+		var location string
+		if err := deleteResult.Scan(&location); err != nil {
+			return nil, err
+		}
+		dropped = append(dropped, location)
+	default:
+		return nil, errors.New("unknown resource type")
+	}
+
+	return dropped, nil
+}
+
+// removeBlobs deletes the blobs that are no longer referenced.
+// The blobs are deleted even if the request is canceled,
+// as the rows that referenced them are gone already.
+func removeBlobs(store blobstore.BlobStore, locations []string) {
+	for _, location := range locations {
+		if err := store.Delete(context.Background(), location); err != nil {
+			log.Printf("failed to remove file: %s\n", err.Error())
+		}
+	}
+}
@@ -25,3 +25,106 @@ CREATE TABLE IF NOT EXISTS blobs(
     salt BYTEA,
     iv BYTEA
 );
+
+ALTER TABLE resources ADD COLUMN IF NOT EXISTS revision INTEGER DEFAULT 0;
+
+CREATE TABLE IF NOT EXISTS resource_versions(
+    id SERIAL PRIMARY KEY UNIQUE,
+    resource INTEGER,
+    version INTEGER,
+    meta TEXT,
+    content BYTEA,
+    location TEXT,
+    created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP
+);
+
+ALTER TABLE resources ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMPTZ;
+
+ALTER TABLE resources ADD COLUMN IF NOT EXISTS created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP;
+ALTER TABLE resources ADD COLUMN IF NOT EXISTS updated_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP;
+ALTER TABLE resources ADD COLUMN IF NOT EXISTS accessed_at TIMESTAMPTZ;
+
+ALTER TABLE pieces ADD COLUMN IF NOT EXISTS size BIGINT;
+ALTER TABLE pieces ADD COLUMN IF NOT EXISTS hash BYTEA;
+UPDATE pieces SET size = octet_length(content), hash = sha256(content) WHERE size IS NULL AND content IS NOT NULL;
+
+ALTER TABLE blobs ADD COLUMN IF NOT EXISTS size BIGINT;
+ALTER TABLE blobs ADD COLUMN IF NOT EXISTS hash BYTEA;
+
+ALTER TABLE resource_versions ADD COLUMN IF NOT EXISTS size BIGINT;
+ALTER TABLE resource_versions ADD COLUMN IF NOT EXISTS hash BYTEA;
+
+CREATE TABLE IF NOT EXISTS folders(
+    id SERIAL PRIMARY KEY UNIQUE,
+    owner TEXT,
+    parent INTEGER,
+    name TEXT
+);
+
+ALTER TABLE resources ADD COLUMN IF NOT EXISTS folder INTEGER DEFAULT 0;
+
+CREATE TABLE IF NOT EXISTS resource_tags(
+    resource INTEGER,
+    tag TEXT,
+    PRIMARY KEY (resource, tag)
+);
+
+CREATE TABLE IF NOT EXISTS password_changes(
+    username TEXT PRIMARY KEY UNIQUE,
+    password TEXT
+);
+
+CREATE TABLE IF NOT EXISTS reencrypted_resources(
+    username TEXT,
+    resource INTEGER,
+    version INTEGER,
+    revision INTEGER,
+    meta TEXT,
+    content BYTEA,
+    location TEXT,
+    size BIGINT,
+    hash BYTEA,
+    PRIMARY KEY (resource, version)
+);
+
+ALTER TABLE identities ADD COLUMN IF NOT EXISTS vault_check BYTEA;
+ALTER TABLE password_changes ADD COLUMN IF NOT EXISTS vault_check BYTEA;
+
+ALTER TABLE reencrypted_resources ADD COLUMN IF NOT EXISTS content_kept BOOLEAN DEFAULT FALSE;
+
+ALTER TABLE resources ADD COLUMN IF NOT EXISTS tokens TEXT[];
+ALTER TABLE resource_versions ADD COLUMN IF NOT EXISTS tokens TEXT[];
+ALTER TABLE resource_tags ADD COLUMN IF NOT EXISTS tokens TEXT[];
+ALTER TABLE reencrypted_resources ADD COLUMN IF NOT EXISTS tokens TEXT[];
+
+ALTER TABLE identities ADD COLUMN IF NOT EXISTS key_file BOOLEAN DEFAULT FALSE;
+ALTER TABLE identities ADD COLUMN IF NOT EXISTS vault_salt BYTEA;
+
+CREATE TABLE IF NOT EXISTS quarantine(
+    id SERIAL PRIMARY KEY UNIQUE,
+    source TEXT,
+    data JSONB,
+    reason TEXT,
+    quarantined_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS blob_objects(
+    id BIGSERIAL PRIMARY KEY UNIQUE,
+    key TEXT,
+    size BIGINT DEFAULT 0,
+    chunk_size INTEGER,
+    modified_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX IF NOT EXISTS blob_objects_key ON blob_objects(key);
+
+CREATE TABLE IF NOT EXISTS blob_chunks(
+    object BIGINT REFERENCES blob_objects(id) ON DELETE CASCADE,
+    n INTEGER,
+    content BYTEA,
+    PRIMARY KEY (object, n)
+);
+
+CREATE TABLE IF NOT EXISTS blob_references(
+    hash TEXT PRIMARY KEY UNIQUE,
+    refs BIGINT DEFAULT 0
+);
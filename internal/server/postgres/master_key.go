@@ -0,0 +1,248 @@
+package postgres
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"os"
+
+	"github.com/kerelape/gophkeeper/internal/blobstore"
+)
+
+// atRestColumn is a column of the content encrypted at rest. The rows
+// are keyed by a resource and a version, 0 stands for the version of
+// the rows that have none.
+type atRestColumn struct {
+	// selectBatch selects the key and the content of up to $3 rows
+	// that follow the ($1, $2) key ordered by the key.
+	selectBatch string
+
+	// rewrite replaces the content of the ($1, $2) row
+	// with $4 unless it is changed from $3.
+	rewrite string
+}
+
+var atRestColumns = []atRestColumn{
+	{
+		selectBatch: `SELECT id, 0, content FROM pieces
+		WHERE content IS NOT NULL AND (id, 0) > ($1::INTEGER, $2::INTEGER) ORDER BY id LIMIT $3`,
+		rewrite: `UPDATE pieces SET content = $4 WHERE (id, 0) = ($1::INTEGER, $2::INTEGER) AND content = $3`,
+	},
+	{
+		selectBatch: `SELECT resource, version, content FROM resource_versions
+		WHERE content IS NOT NULL AND (resource, version) > ($1, $2) ORDER BY resource, version LIMIT $3`,
+		rewrite: `UPDATE resource_versions SET content = $4 WHERE resource = $1 AND version = $2 AND content = $3`,
+	},
+	{
+		selectBatch: `SELECT resource, version, content FROM reencrypted_resources
+		WHERE content IS NOT NULL AND (resource, version) > ($1, $2) ORDER BY resource, version LIMIT $3`,
+		rewrite: `UPDATE reencrypted_resources SET content = $4 WHERE resource = $1 AND version = $2 AND content = $3`,
+	},
+}
+
+// selectBlobFiles selects up to $2 locations of the blob files
+// that follow the $1 location ordered by the location.
+const selectBlobFiles = `SELECT location FROM (
+	SELECT location FROM blobs
+	UNION SELECT location FROM resource_versions
+	UNION SELECT location FROM reencrypted_resources
+) l WHERE location IS NOT NULL AND location > $1 ORDER BY location LIMIT $2`
+
+// selectBlobFileReferenced selects whether the $1 location is referenced.
+const selectBlobFileReferenced = `SELECT
+	EXISTS (SELECT 1 FROM blobs WHERE location = $1)
+	OR EXISTS (SELECT 1 FROM resource_versions WHERE location = $1)
+	OR EXISTS (SELECT 1 FROM reencrypted_resources WHERE location = $1)`
+
+// errNoMasterKeys is returned when the master key is rotated
+// by the gophkeeper that is not given a key provider.
+var errNoMasterKeys = errors.New("master keys are not provided")
+
+// RotateMasterKey re-wraps the data keys of the pieces and the blob files
+// with the primary master key, batchSize of them at a time. The content
+// stored before it was encrypted at rest is encrypted. It returns the number
+// of the pieces and the blob files re-wrapped.
+//
+// The gophkeeper keeps serving while the key is rotated, the content written
+// in the meantime is encrypted with the primary key and the content changed
+// since it was read is not rewritten. An interrupted rotation is resumed by
+// rotating again, the master keys that are no longer primary can be removed
+// once the rotation is complete.
+func (r *Gophkeeper) RotateMasterKey(ctx context.Context, batchSize int) (int, error) {
+	if batchSize <= 0 {
+		return 0, errors.New("batch size must be positive")
+	}
+	if r.atRest.Keys == nil {
+		return 0, errNoMasterKeys
+	}
+	connection, connectionError := r.database.Get(ctx)
+	if connectionError != nil {
+		return 0, connectionError
+	}
+
+	rotated := 0
+	for _, column := range atRestColumns {
+		n, err := r.rotateColumn(ctx, column, batchSize)
+		rotated += n
+		if err != nil {
+			return rotated, err
+		}
+	}
+
+	after := ""
+	for {
+		locations, locationsError := queryLocations(ctx, connection, selectBlobFiles, after, batchSize)
+		if locationsError != nil {
+			return rotated, locationsError
+		}
+		for _, location := range locations {
+			rewrapped, rewrapError := r.rewrapBlobFile(ctx, location)
+			if rewrapError != nil {
+				return rotated, rewrapError
+			}
+			if !rewrapped {
+				continue
+			}
+			rotated++
+
+			// The file may be purged while it is re-wrapped.
+			var referenced bool
+			if err := connection.QueryRow(ctx, selectBlobFileReferenced, location).Scan(&referenced); err != nil {
+				return rotated, err
+			}
+			if !referenced {
+				// The chunks of the file are released once it is purged.
+				removeBlobs(r.rawBlobStore(), []string{location})
+			}
+		}
+		if len(locations) < batchSize {
+			return rotated, nil
+		}
+		after = locations[len(locations)-1]
+	}
+}
+
+// rotateColumn re-wraps the content of the column batch by batch.
+func (r *Gophkeeper) rotateColumn(ctx context.Context, column atRestColumn, batchSize int) (int, error) {
+	connection, connectionError := r.database.Get(ctx)
+	if connectionError != nil {
+		return 0, connectionError
+	}
+
+	var (
+		rotated     = 0
+		lastRID     = (int64)(0)
+		lastVersion = 0
+	)
+	for {
+		type row struct {
+			resource int64
+			version  int
+			content  []byte
+		}
+		selectBatchResult, selectBatchError := connection.Query(ctx, column.selectBatch, lastRID, lastVersion, batchSize)
+		if selectBatchError != nil {
+			return rotated, selectBatchError
+		}
+		rows := make([]row, 0, batchSize)
+		for selectBatchResult.Next() {
+			var current row
+			if err := selectBatchResult.Scan(&current.resource, &current.version, &current.content); err != nil {
+				selectBatchResult.Close()
+				return rotated, err
+			}
+			rows = append(rows, current)
+		}
+		selectBatchResult.Close()
+		if err := selectBatchResult.Err(); err != nil {
+			return rotated, err
+		}
+
+		for _, current := range rows {
+			var rewrapped bytes.Buffer
+			ok, rewrapError := r.atRest.Rewrap(ctx, bytes.NewReader(current.content), &rewrapped)
+			if rewrapError != nil {
+				return rotated, rewrapError
+			}
+			if !ok {
+				continue
+			}
+			tag, rewriteError := connection.Exec(
+				ctx,
+				column.rewrite,
+				current.resource, current.version, current.content, rewrapped.Bytes(),
+			)
+			if rewriteError != nil {
+				return rotated, rewriteError
+			}
+			rotated += (int)(tag.RowsAffected())
+		}
+		if len(rows) < batchSize {
+			return rotated, nil
+		}
+		lastRID, lastVersion = rows[len(rows)-1].resource, rows[len(rows)-1].version
+	}
+}
+
+// rewrapBlobFile re-wraps the blob file with the primary master key,
+// the manifest and the chunks of the file if it is deduplicated. It
+// returns false if the file is re-wrapped already or is removed.
+func (r *Gophkeeper) rewrapBlobFile(ctx context.Context, location string) (bool, error) {
+	dedup, deduplicated := r.blobStore.(*blobstore.Dedup)
+	if !deduplicated {
+		return r.rewrapFile(ctx, r.blobStore, location)
+	}
+	keys, keysError := dedup.Keys(ctx, location)
+	if keysError != nil {
+		if errors.Is(keysError, blobstore.ErrNotFound) {
+			return false, nil
+		}
+		return false, keysError
+	}
+	rewrapped := false
+	for _, key := range keys {
+		ok, rewrapError := r.rewrapFile(ctx, dedup.Store, key)
+		if rewrapError != nil {
+			return rewrapped, rewrapError
+		}
+		rewrapped = rewrapped || ok
+	}
+	return rewrapped, nil
+}
+
+// rewrapFile replaces the file of the store with the one re-wrapped
+// with the primary master key. It returns false if the file is
+// re-wrapped already or is removed.
+func (r *Gophkeeper) rewrapFile(ctx context.Context, store blobstore.BlobStore, key string) (bool, error) {
+	file, fileError := blobstore.Open(ctx, store, key)
+	if fileError != nil {
+		if errors.Is(fileError, blobstore.ErrNotFound) {
+			return false, nil
+		}
+		return false, fileError
+	}
+	defer file.Close()
+
+	temporary, temporaryError := os.CreateTemp("", "rewrap-*")
+	if temporaryError != nil {
+		return false, temporaryError
+	}
+	defer os.Remove(temporary.Name())
+	defer temporary.Close()
+
+	rewrapped, rewrapError := r.atRest.Rewrap(ctx, file, temporary)
+	if rewrapError != nil || !rewrapped {
+		return false, rewrapError
+	}
+	if _, err := temporary.Seek(0, io.SeekStart); err != nil {
+		return false, err
+	}
+
+	// Blob files and chunks are never written once they
+	// are stored, so the file is replaced at once.
+	if _, err := store.Put(ctx, key, temporary); err != nil {
+		return false, err
+	}
+	return true, nil
+}
@@ -0,0 +1,73 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+
+	"github.com/kerelape/gophkeeper/internal/blobstore"
+)
+
+// MigrateBlobs moves the blob files stored before the deduplication was
+// enabled to the chunks, or back from the chunks once it is disabled,
+// batchSize of them at a time. It returns the number of the files moved.
+//
+// The gophkeeper must not serve while the files are moved, an interrupted
+// migration is resumed by migrating again.
+func (r *Gophkeeper) MigrateBlobs(ctx context.Context, batchSize int) (int, error) {
+	if batchSize <= 0 {
+		return 0, errors.New("batch size must be positive")
+	}
+	connection, connectionError := r.database.Get(ctx)
+	if connectionError != nil {
+		return 0, connectionError
+	}
+
+	dedup, deduplicated := r.blobStore.(*blobstore.Dedup)
+	if !deduplicated {
+		dedup = &blobstore.Dedup{
+			Store:      r.blobStore,
+			References: &References{database: r.database},
+			AtRest:     r.atRest,
+		}
+	}
+	migrate := dedup.Join
+	if deduplicated {
+		migrate = dedup.Split
+	}
+
+	var (
+		migrated = 0
+		after    = ""
+	)
+	for {
+		locations, locationsError := queryLocations(ctx, connection, selectBlobFiles, after, batchSize)
+		if locationsError != nil {
+			return migrated, locationsError
+		}
+		for _, location := range locations {
+			ok, migrateError := migrate(ctx, location)
+			if errors.Is(migrateError, blobstore.ErrNotFound) {
+				continue
+			}
+			if migrateError != nil {
+				return migrated, migrateError
+			}
+			if ok {
+				migrated++
+			}
+		}
+		if len(locations) < batchSize {
+			return migrated, dedup.Collect(ctx)
+		}
+		after = locations[len(locations)-1]
+	}
+}
+
+// rawBlobStore returns the store the blob files are kept in
+// as they are, the manifests of them if they are deduplicated.
+func (r *Gophkeeper) rawBlobStore() blobstore.BlobStore {
+	if dedup, ok := r.blobStore.(*blobstore.Dedup); ok {
+		return dedup.Store
+	}
+	return r.blobStore
+}
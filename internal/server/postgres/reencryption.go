@@ -0,0 +1,710 @@
+package postgres
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"errors"
+	"io"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/kerelape/gophkeeper/internal/blobstore"
+	"github.com/kerelape/gophkeeper/internal/server"
+	"github.com/kerelape/gophkeeper/pkg/gophkeeper"
+)
+
+// querier is either a connection or a transaction.
+type querier interface {
+	Exec(ctx context.Context, sql string, arguments ...any) (pgconn.CommandTag, error)
+	Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error)
+	QueryRow(ctx context.Context, sql string, args ...any) pgx.Row
+}
+
+// reencryptionUnit is a stored state of a resource waiting
+// to be re-encrypted, version 0 is the current state.
+type reencryptionUnit struct {
+	rid          gophkeeper.ResourceID
+	version      int
+	resourceType gophkeeper.ResourceType
+	staged       *string
+}
+
+var _ gophkeeper.Reencrypter = (*Identity)(nil)
+
+// Reencrypt implements gophkeeper.Reencrypter.
+//
+// The re-encrypted content is staged in reencrypted_resources first,
+// every staged resource is committed on its own, so an interrupted call
+// resumes from where it stopped. The staged content replaces the stored
+// one together with the vault password in a single transaction.
+func (i *Identity) Reencrypt(ctx context.Context, oldPassword, newPassword string, reencryption gophkeeper.Reencryption) error {
+	vaultCheck, passwordError := i.verifyVaultPassword(ctx, oldPassword)
+	if passwordError != nil {
+		return errors.Join(passwordError, gophkeeper.ErrBadCredential)
+	}
+	if err := i.beginReencryption(ctx, newPassword); err != nil {
+		return err
+	}
+
+	_, replaced, stageError := i.stageReencryption(ctx, i.Connection, reencryption)
+	removeBlobs(i.Blobs, replaced)
+	if stageError != nil {
+		return stageError
+	}
+
+	transaction, transactionError := i.Connection.Begin(ctx)
+	if transactionError != nil {
+		return transactionError
+	}
+
+	staged, dropped, applyError := i.applyReencryption(ctx, transaction, vaultCheck, reencryption)
+	if applyError != nil {
+		removeBlobs(i.Blobs, staged)
+		if err := transaction.Rollback(ctx); err != nil {
+			return err
+		}
+		return applyError
+	}
+
+	if err := transaction.Commit(ctx); err != nil {
+		removeBlobs(i.Blobs, staged)
+		if err := transaction.Rollback(ctx); err != nil {
+			return err
+		}
+		return err
+	}
+
+	removeBlobs(i.Blobs, dropped)
+	return nil
+}
+
+// Metas implements gophkeeper.Reencrypter.
+func (i *Identity) Metas(ctx context.Context, password string) ([]string, error) {
+	if err := i.compareVaultPassword(ctx, password); err != nil {
+		return nil, errors.Join(err, gophkeeper.ErrBadCredential)
+	}
+
+	selectMetasResult, selectMetasError := i.Connection.Query(
+		ctx,
+		`SELECT meta FROM resources WHERE owner = $1
+		UNION ALL
+		SELECT v.meta FROM resource_versions v
+		JOIN resources r ON r.id = v.resource
+		WHERE r.owner = $1`,
+		i.Username,
+	)
+	if selectMetasError != nil {
+		return nil, selectMetasError
+	}
+	defer selectMetasResult.Close()
+
+	metas := make([]string, 0)
+	for selectMetasResult.Next() {
+		var meta string
+		if err := selectMetasResult.Scan(&meta); err != nil {
+			return nil, err
+		}
+		metas = append(metas, meta)
+	}
+	return metas, selectMetasResult.Err()
+}
+
+// beginReencryption records the new vault password of a pending
+// re-encryption. A pending re-encryption to another password is discarded.
+func (i *Identity) beginReencryption(ctx context.Context, newPassword string) error {
+	selectPendingResult := i.Connection.QueryRow(
+		ctx,
+		`SELECT vault_check FROM password_changes WHERE username = $1`,
+		i.Username,
+	)
+	var pending []byte
+	switch err := selectPendingResult.Scan(&pending); {
+	case err == nil:
+		// Changes pending since before vault passwords have no vault check.
+		if pending != nil && server.VerifyVaultCheck(pending, newPassword) == nil {
+			return nil
+		}
+	case !errors.Is(err, pgx.ErrNoRows):
+		return err
+	}
+
+	vaultCheck, vaultCheckError := server.NewVaultCheck(newPassword)
+	if vaultCheckError != nil {
+		return vaultCheckError
+	}
+
+	transaction, transactionError := i.Connection.Begin(ctx)
+	if transactionError != nil {
+		return transactionError
+	}
+
+	dropped, discardError := i.discardReencryption(ctx, transaction)
+	if discardError != nil {
+		if err := transaction.Rollback(ctx); err != nil {
+			return err
+		}
+		return discardError
+	}
+
+	if _, err := transaction.Exec(
+		ctx,
+		`INSERT INTO password_changes(username, vault_check) VALUES($1, $2)`,
+		i.Username, vaultCheck,
+	); err != nil {
+		if err := transaction.Rollback(ctx); err != nil {
+			return err
+		}
+		return err
+	}
+
+	if err := transaction.Commit(ctx); err != nil {
+		if err := transaction.Rollback(ctx); err != nil {
+			return err
+		}
+		return err
+	}
+
+	removeBlobs(i.Blobs, dropped)
+	return nil
+}
+
+// discardReencryption deletes the pending re-encryption. It returns
+// locations of the staged blob files, they must be removed after
+// the transaction is committed.
+func (i *Identity) discardReencryption(ctx context.Context, transaction pgx.Tx) ([]string, error) {
+	if _, err := transaction.Exec(ctx, `DELETE FROM password_changes WHERE username = $1`, i.Username); err != nil {
+		return nil, err
+	}
+
+	deleteStagedResult, deleteStagedError := transaction.Query(
+		ctx,
+		`DELETE FROM reencrypted_resources WHERE username = $1 RETURNING location`,
+		i.Username,
+	)
+	if deleteStagedError != nil {
+		return nil, deleteStagedError
+	}
+	defer deleteStagedResult.Close()
+
+	dropped := make([]string, 0)
+	for deleteStagedResult.Next() {
+		var location *string
+		if err := deleteStagedResult.Scan(&location); err != nil {
+			return nil, err
+		}
+		if location != nil {
+			dropped = append(dropped, *location)
+		}
+	}
+	return dropped, deleteStagedResult.Err()
+}
+
+// stageReencryption re-encrypts every stored state of the identity's
+// resources that is not staged yet or was changed after it had been staged.
+// It returns locations of the blob files it has staged and locations of
+// the previously staged blob files it has replaced, the latter must be
+// removed once the staging is committed.
+func (i *Identity) stageReencryption(ctx context.Context, q querier, reencryption gophkeeper.Reencryption) ([]string, []string, error) {
+	units, unitsError := i.reencryptionUnits(ctx, q)
+	if unitsError != nil {
+		return nil, nil, unitsError
+	}
+	var (
+		staged   = make([]string, 0)
+		replaced = make([]string, 0)
+	)
+	for n, unit := range units {
+		location, stageError := i.stageUnit(ctx, q, unit, reencryption)
+		if stageError != nil {
+			return staged, replaced, stageError
+		}
+		if location != nil {
+			staged = append(staged, *location)
+		}
+		if unit.staged != nil {
+			replaced = append(replaced, *unit.staged)
+		}
+		gophkeeper.ReportProgress(ctx, n+1, len(units))
+	}
+	return staged, replaced, nil
+}
+
+// reencryptionUnits returns the stored states of the identity's resources
+// that are not staged yet or were changed after they had been staged.
+func (i *Identity) reencryptionUnits(ctx context.Context, q querier) ([]reencryptionUnit, error) {
+	selectUnitsResult, selectUnitsError := q.Query(
+		ctx,
+		`SELECT r.id, 0, r.type, s.location FROM resources r
+		LEFT JOIN reencrypted_resources s ON s.resource = r.id AND s.version = 0
+		WHERE r.owner = $1 AND (s.resource IS NULL OR s.revision IS DISTINCT FROM r.revision)
+		UNION ALL
+		SELECT v.resource, v.version, r.type, NULL FROM resource_versions v
+		JOIN resources r ON r.id = v.resource
+		LEFT JOIN reencrypted_resources s ON s.resource = v.resource AND s.version = v.version
+		WHERE r.owner = $1 AND s.resource IS NULL
+		ORDER BY 1, 2`,
+		i.Username,
+	)
+	if selectUnitsError != nil {
+		return nil, selectUnitsError
+	}
+	defer selectUnitsResult.Close()
+
+	units := make([]reencryptionUnit, 0)
+	for selectUnitsResult.Next() {
+		var unit reencryptionUnit
+		if err := selectUnitsResult.Scan(&unit.rid, &unit.version, &unit.resourceType, &unit.staged); err != nil {
+			return nil, err
+		}
+		units = append(units, unit)
+	}
+	return units, selectUnitsResult.Err()
+}
+
+// stageUnit re-encrypts the stored state and stages it.
+// It returns location of the staged blob file if there is one.
+func (i *Identity) stageUnit(ctx context.Context, q querier, unit reencryptionUnit, reencryption gophkeeper.Reencryption) (*string, error) {
+	var selectStateResult pgx.Row
+	if unit.version == 0 {
+		selectStateResult = q.QueryRow(
+			ctx,
+			`SELECT r.revision, r.meta, r.tokens, p.content, b.location FROM resources r
+			LEFT JOIN pieces p ON r.type = $2 AND p.id = r.resource
+			LEFT JOIN blobs b ON r.type = $3 AND b.id = r.resource
+			WHERE r.id = $1`,
+			(int64)(unit.rid), (int)(gophkeeper.ResourceTypePiece), (int)(gophkeeper.ResourceTypeBlob),
+		)
+	} else {
+		selectStateResult = q.QueryRow(
+			ctx,
+			`SELECT NULL::INTEGER, meta, tokens, content, location FROM resource_versions WHERE resource = $1 AND version = $2`,
+			(int64)(unit.rid), unit.version,
+		)
+	}
+	var (
+		revision *int
+		meta     string
+		tokens   []string
+		content  []byte
+		location *string
+	)
+	if err := selectStateResult.Scan(&revision, &meta, &tokens, &content, &location); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			// The resource has been purged in the meantime.
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	if reencryption.Meta != nil {
+		reencryptedMeta, metaError := reencryption.Meta(meta)
+		if metaError != nil {
+			return nil, metaError
+		}
+		reencryptedTokens, tokensError := reencryptTokens(reencryptedMeta, tokens, reencryption)
+		if tokensError != nil {
+			return nil, tokensError
+		}
+		_, insertError := q.Exec(
+			ctx,
+			`INSERT INTO reencrypted_resources(username, resource, version, revision, meta, tokens, content_kept)
+			VALUES($1, $2, $3, $4, $5, $6, TRUE)
+			ON CONFLICT (resource, version) DO UPDATE SET
+			revision = EXCLUDED.revision, meta = EXCLUDED.meta, tokens = EXCLUDED.tokens, content = NULL,
+			location = NULL, size = NULL, hash = NULL, content_kept = TRUE`,
+			i.Username, (int64)(unit.rid), unit.version, revision, reencryptedMeta, reencryptedTokens,
+		)
+		return nil, insertError
+	}
+
+	var (
+		size int64
+		hash []byte
+	)
+	switch unit.resourceType {
+	case gophkeeper.ResourceTypePiece:
+		opened, openError := i.AtRest.OpenBytes(ctx, content)
+		if openError != nil {
+			return nil, openError
+		}
+		piece, pieceError := reencryption.Piece(gophkeeper.Piece{Meta: meta, Content: opened})
+		if pieceError != nil {
+			return nil, pieceError
+		}
+		sealed, sealError := i.AtRest.SealBytes(ctx, piece.Content)
+		if sealError != nil {
+			return nil, sealError
+		}
+		pieceHash := sha256.Sum256(piece.Content)
+		meta, content, size, hash = piece.Meta, sealed, (int64)(len(piece.Content)), pieceHash[:]
+	case gophkeeper.ResourceTypeBlob:
+		if location == nil {
+			return nil, errors.New("blob has no location")
+		}
+		file, fileError := i.openBlob(ctx, *location)
+		if fileError != nil {
+			return nil, fileError
+		}
+		blob, blobError := reencryption.Blob(gophkeeper.Blob{Meta: meta, Content: file})
+		if blobError != nil {
+			file.Close()
+			return nil, blobError
+		}
+		reencryptedLocation, reencryptedSize, reencryptedHash, writeError := i.writeBlob(ctx, blob.Content)
+		blob.Content.Close()
+		if writeError != nil {
+			return nil, writeError
+		}
+		meta, location, size, hash = blob.Meta, &reencryptedLocation, reencryptedSize, reencryptedHash
+	default:
+		return nil, errors.New("unknown resource type")
+	}
+
+	tokens, tokensError := reencryptTokens(meta, tokens, reencryption)
+	if tokensError != nil {
+		if unit.resourceType == gophkeeper.ResourceTypeBlob {
+			removeBlobs(i.Blobs, []string{*location})
+		}
+		return nil, tokensError
+	}
+	if _, err := q.Exec(
+		ctx,
+		`INSERT INTO reencrypted_resources(username, resource, version, revision, meta, tokens, content, location, size, hash)
+		VALUES($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+		ON CONFLICT (resource, version) DO UPDATE SET
+		revision = EXCLUDED.revision, meta = EXCLUDED.meta, tokens = EXCLUDED.tokens, content = EXCLUDED.content,
+		location = EXCLUDED.location, size = EXCLUDED.size, hash = EXCLUDED.hash, content_kept = FALSE`,
+		i.Username, (int64)(unit.rid), unit.version, revision, meta, tokens, content, location, size, hash,
+	); err != nil {
+		if unit.resourceType == gophkeeper.ResourceTypeBlob {
+			removeBlobs(i.Blobs, []string{*location})
+		}
+		return nil, err
+	}
+	if unit.resourceType == gophkeeper.ResourceTypeBlob {
+		return location, nil
+	}
+	return nil, nil
+}
+
+// applyReencryption replaces the stored content with the staged one
+// and the vault password with the pending one. It returns locations of the blob
+// files staged by the transaction, they must be removed if it fails, and
+// locations of the blob files that are no longer referenced, they must be
+// removed after the transaction is committed.
+func (i *Identity) applyReencryption(ctx context.Context, transaction pgx.Tx, vaultCheck []byte, reencryption gophkeeper.Reencryption) ([]string, []string, error) {
+	// Locking the vault password blocks new content from being written,
+	// locking the resources blocks the stored one from being changed.
+	lockIdentityResult := transaction.QueryRow(
+		ctx,
+		`SELECT vault_check FROM identities WHERE username = $1 FOR UPDATE`,
+		i.Username,
+	)
+	var currentVaultCheck []byte
+	if err := lockIdentityResult.Scan(&currentVaultCheck); err != nil {
+		return nil, nil, err
+	}
+	if !bytes.Equal(currentVaultCheck, vaultCheck) {
+		return nil, nil, gophkeeper.ErrBadCredential
+	}
+	if _, err := transaction.Exec(ctx, `SELECT 1 FROM resources WHERE owner = $1 FOR UPDATE`, i.Username); err != nil {
+		return nil, nil, err
+	}
+
+	// Catch up with the changes made while the content was being staged.
+	staged, dropped, stageError := i.stageReencryption(ctx, transaction, reencryption)
+	if stageError != nil {
+		return staged, nil, stageError
+	}
+
+	if _, err := transaction.Exec(
+		ctx,
+		`UPDATE resources r SET meta = s.meta, tokens = s.tokens FROM reencrypted_resources s
+		WHERE s.username = $1 AND s.version = 0 AND s.resource = r.id`,
+		i.Username,
+	); err != nil {
+		return staged, nil, err
+	}
+	if _, err := transaction.Exec(
+		ctx,
+		`UPDATE pieces p SET content = s.content, size = s.size, hash = s.hash
+		FROM reencrypted_resources s, resources r
+		WHERE s.username = $1 AND s.version = 0 AND NOT s.content_kept
+		AND r.id = s.resource AND r.type = $2 AND p.id = r.resource`,
+		i.Username, (int)(gophkeeper.ResourceTypePiece),
+	); err != nil {
+		return staged, nil, err
+	}
+
+	replacedBlobs, blobsError := queryLocations(
+		ctx,
+		transaction,
+		`UPDATE blobs b SET location = s.location, size = s.size, hash = s.hash
+		FROM reencrypted_resources s, resources r, blobs o
+		WHERE s.username = $1 AND s.version = 0 AND NOT s.content_kept
+		AND r.id = s.resource AND r.type = $2 AND b.id = r.resource AND o.id = b.id
+		RETURNING o.location`,
+		i.Username, (int)(gophkeeper.ResourceTypeBlob),
+	)
+	if blobsError != nil {
+		return staged, nil, blobsError
+	}
+	dropped = append(dropped, replacedBlobs...)
+
+	replacedVersions, versionsError := queryLocations(
+		ctx,
+		transaction,
+		`UPDATE resource_versions v SET meta = s.meta, tokens = s.tokens, content = s.content,
+		location = s.location, size = s.size, hash = s.hash
+		FROM reencrypted_resources s, resource_versions o
+		WHERE s.username = $1 AND s.version <> 0 AND NOT s.content_kept
+		AND s.resource = v.resource AND s.version = v.version AND o.id = v.id
+		RETURNING o.location`,
+		i.Username,
+	)
+	if versionsError != nil {
+		return staged, nil, versionsError
+	}
+	dropped = append(dropped, replacedVersions...)
+	if _, err := transaction.Exec(
+		ctx,
+		`UPDATE resource_versions v SET meta = s.meta, tokens = s.tokens FROM reencrypted_resources s
+		WHERE s.username = $1 AND s.version <> 0 AND s.content_kept
+		AND s.resource = v.resource AND s.version = v.version`,
+		i.Username,
+	); err != nil {
+		return staged, nil, err
+	}
+
+	if err := i.reencryptTags(ctx, transaction, reencryption); err != nil {
+		return staged, nil, err
+	}
+	if err := i.reencryptFolders(ctx, transaction, reencryption); err != nil {
+		return staged, nil, err
+	}
+
+	// Resources purged while being staged leave their staged blob files behind.
+	unapplied, unappliedError := queryLocations(
+		ctx,
+		transaction,
+		`DELETE FROM reencrypted_resources s WHERE s.username = $1 AND s.location IS NOT NULL
+		AND NOT EXISTS (SELECT 1 FROM blobs b WHERE b.location = s.location)
+		AND NOT EXISTS (SELECT 1 FROM resource_versions v WHERE v.location = s.location)
+		RETURNING s.location`,
+		i.Username,
+	)
+	if unappliedError != nil {
+		return staged, nil, unappliedError
+	}
+	dropped = append(dropped, unapplied...)
+
+	if _, err := transaction.Exec(
+		ctx,
+		`UPDATE identities SET vault_check = c.vault_check FROM password_changes c
+		WHERE c.username = $1 AND identities.username = $1`,
+		i.Username,
+	); err != nil {
+		return staged, nil, err
+	}
+	if _, err := transaction.Exec(ctx, `DELETE FROM reencrypted_resources WHERE username = $1`, i.Username); err != nil {
+		return staged, nil, err
+	}
+	if _, err := transaction.Exec(ctx, `DELETE FROM password_changes WHERE username = $1`, i.Username); err != nil {
+		return staged, nil, err
+	}
+
+	return staged, dropped, nil
+}
+
+// reencryptTokens returns the tokens of the re-encrypted meta,
+// the tokens are kept as is unless reencryption.Index replaces them.
+func reencryptTokens(meta string, tokens []string, reencryption gophkeeper.Reencryption) ([]string, error) {
+	if reencryption.Index == nil {
+		return tokens, nil
+	}
+	return reencryption.Index(meta)
+}
+
+// reencryptTags rewrites every tag of the identity's resources with
+// reencryption.Tag and replaces its tokens with reencryption.TagIndex.
+func (i *Identity) reencryptTags(ctx context.Context, transaction pgx.Tx, reencryption gophkeeper.Reencryption) error {
+	if reencryption.TagIndex == nil && reencryption.Tag == nil {
+		return nil
+	}
+	selectTagsResult, selectTagsError := transaction.Query(
+		ctx,
+		`SELECT DISTINCT t.tag FROM resource_tags t
+		JOIN resources r ON r.id = t.resource
+		WHERE r.owner = $1`,
+		i.Username,
+	)
+	if selectTagsError != nil {
+		return selectTagsError
+	}
+	tags := make([]string, 0)
+	for selectTagsResult.Next() {
+		var tag string
+		if err := selectTagsResult.Scan(&tag); err != nil {
+			selectTagsResult.Close()
+			return err
+		}
+		tags = append(tags, tag)
+	}
+	selectTagsResult.Close()
+	if err := selectTagsResult.Err(); err != nil {
+		return err
+	}
+
+	for _, tag := range tags {
+		if reencryption.TagIndex != nil {
+			tokens, tokensError := reencryption.TagIndex(tag)
+			if tokensError != nil {
+				return tokensError
+			}
+			if _, err := transaction.Exec(
+				ctx,
+				`UPDATE resource_tags t SET tokens = $1 FROM resources r
+				WHERE r.id = t.resource AND r.owner = $2 AND t.tag = $3`,
+				tokens, i.Username, tag,
+			); err != nil {
+				return err
+			}
+		}
+		if reencryption.Tag != nil {
+			rewritten, tagError := reencryption.Tag(tag)
+			if tagError != nil {
+				return tagError
+			}
+			if _, err := transaction.Exec(
+				ctx,
+				`UPDATE resource_tags t SET tag = $1 FROM resources r
+				WHERE r.id = t.resource AND r.owner = $2 AND t.tag = $3`,
+				rewritten, i.Username, tag,
+			); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// reencryptFolders rewrites the names of the
+// identity's folders with reencryption.Folder.
+func (i *Identity) reencryptFolders(ctx context.Context, transaction pgx.Tx, reencryption gophkeeper.Reencryption) error {
+	if reencryption.Folder == nil {
+		return nil
+	}
+	selectFoldersResult, selectFoldersError := transaction.Query(
+		ctx,
+		`SELECT id, name FROM folders WHERE owner = $1 FOR UPDATE`,
+		i.Username,
+	)
+	if selectFoldersError != nil {
+		return selectFoldersError
+	}
+	names := make(map[int64]string)
+	for selectFoldersResult.Next() {
+		var (
+			id   int64
+			name string
+		)
+		if err := selectFoldersResult.Scan(&id, &name); err != nil {
+			selectFoldersResult.Close()
+			return err
+		}
+		names[id] = name
+	}
+	selectFoldersResult.Close()
+	if err := selectFoldersResult.Err(); err != nil {
+		return err
+	}
+
+	for id, name := range names {
+		rewritten, folderError := reencryption.Folder(name)
+		if folderError != nil {
+			return folderError
+		}
+		if _, err := transaction.Exec(ctx, `UPDATE folders SET name = $1 WHERE id = $2`, rewritten, id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// queryLocations runs the query and returns the not null
+// locations it returns.
+func queryLocations(ctx context.Context, q querier, query string, args ...any) ([]string, error) {
+	result, resultError := q.Query(ctx, query, args...)
+	if resultError != nil {
+		return nil, resultError
+	}
+	defer result.Close()
+
+	locations := make([]string, 0)
+	for result.Next() {
+		var location *string
+		if err := result.Scan(&location); err != nil {
+			return nil, err
+		}
+		if location != nil {
+			locations = append(locations, *location)
+		}
+	}
+	return locations, result.Err()
+}
+
+// writeBlob stores the content as a new blob encrypted at rest with
+// BlobAtRest and returns its location, size and SHA-256 hash of the content.
+//
+// The content is sealed as it is stored, so it is never held whole.
+func (i *Identity) writeBlob(ctx context.Context, content io.Reader) (string, int64, []byte, error) {
+	var (
+		location       = i.Blobs.NewKey()
+		hash           = sha256.New()
+		size           int64
+		reader, writer = io.Pipe()
+		sealed         = make(chan error, 1)
+	)
+	go func() {
+		sealer, sealError := i.BlobAtRest.Seal(ctx, writer)
+		if sealError == nil {
+			size, sealError = io.Copy(sealer, io.TeeReader(content, hash))
+		}
+		if sealError == nil {
+			sealError = sealer.Close()
+		}
+		writer.CloseWithError(sealError)
+		sealed <- sealError
+	}()
+
+	_, putError := i.Blobs.Put(ctx, location, reader)
+	reader.Close()
+	sealError := <-sealed
+	if putError != nil {
+		// The content that failed to be read or sealed fails the put too.
+		if sealError != nil && !errors.Is(sealError, io.ErrClosedPipe) {
+			return "", 0, nil, sealError
+		}
+		return "", 0, nil, putError
+	}
+	return location, size, hash.Sum(nil), nil
+}
+
+// openBlob opens the blob written by writeBlob.
+func (i *Identity) openBlob(ctx context.Context, location string) (io.ReadCloser, error) {
+	file, fileError := blobstore.Open(ctx, i.Blobs, location)
+	if fileError != nil {
+		return nil, fileError
+	}
+	content, contentError := i.BlobAtRest.Open(ctx, file)
+	if contentError != nil {
+		file.Close()
+		return nil, contentError
+	}
+	blob := struct {
+		io.Reader
+		io.Closer
+	}{content, file}
+	return blob, nil
+}
@@ -0,0 +1,109 @@
+package postgres
+
+import (
+	"context"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/kerelape/gophkeeper/internal/blobstore"
+)
+
+// References are the references to the chunks
+// of the deduplicated blobs counted in the database.
+type References struct {
+	database *database
+}
+
+var _ blobstore.References = (*References)(nil)
+
+// Acquire implements blobstore.References.
+func (r *References) Acquire(ctx context.Context, hash string) error {
+	connection, connectionError := r.database.Get(ctx)
+	if connectionError != nil {
+		return connectionError
+	}
+
+	_, acquireError := connection.Exec(
+		ctx,
+		`INSERT INTO blob_references(hash, refs) VALUES($1, 1)
+		ON CONFLICT (hash) DO UPDATE SET refs = blob_references.refs + 1`,
+		hash,
+	)
+	return acquireError
+}
+
+// Release implements blobstore.References.
+func (r *References) Release(ctx context.Context, hashes []string) error {
+	connection, connectionError := r.database.Get(ctx)
+	if connectionError != nil {
+		return connectionError
+	}
+
+	counts := make(map[string]int, len(hashes))
+	for _, hash := range hashes {
+		counts[hash]++
+	}
+	for hash, count := range counts {
+		if _, err := connection.Exec(
+			ctx,
+			`UPDATE blob_references SET refs = refs - $2 WHERE hash = $1`,
+			hash, count,
+		); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Collect implements blobstore.References.
+//
+// The chunks are locked until the transaction forgetting them is
+// committed, so acquiring one of them waits until it is forgotten.
+func (r *References) Collect(ctx context.Context, collect func(hash string) error) error {
+	connection, connectionError := r.database.Get(ctx)
+	if connectionError != nil {
+		return connectionError
+	}
+
+	transaction, transactionError := connection.Begin(ctx)
+	if transactionError != nil {
+		return transactionError
+	}
+	hashes, hashesError := forgetUnreferenced(ctx, transaction)
+	if hashesError != nil {
+		if err := transaction.Rollback(ctx); err != nil {
+			return err
+		}
+		return hashesError
+	}
+	for _, hash := range hashes {
+		if err := collect(hash); err != nil {
+			if err := transaction.Rollback(ctx); err != nil {
+				return err
+			}
+			return err
+		}
+	}
+	return transaction.Commit(ctx)
+}
+
+// forgetUnreferenced forgets the chunks nothing references and returns them.
+func forgetUnreferenced(ctx context.Context, transaction pgx.Tx) ([]string, error) {
+	deleteReferencesResult, deleteReferencesError := transaction.Query(
+		ctx,
+		`DELETE FROM blob_references WHERE refs <= 0 RETURNING hash`,
+	)
+	if deleteReferencesError != nil {
+		return nil, deleteReferencesError
+	}
+	defer deleteReferencesResult.Close()
+
+	hashes := make([]string, 0)
+	for deleteReferencesResult.Next() {
+		var hash string
+		if err := deleteReferencesResult.Scan(&hash); err != nil {
+			return nil, err
+		}
+		hashes = append(hashes, hash)
+	}
+	return hashes, deleteReferencesResult.Err()
+}
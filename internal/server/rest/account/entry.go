@@ -0,0 +1,201 @@
+// Package account provides REST endpoints to manage
+// the account of the authenticated user.
+package account
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/kerelape/gophkeeper/internal/server/rest/authentication"
+	"github.com/kerelape/gophkeeper/pkg/gophkeeper"
+)
+
+// Entry is account entry.
+type Entry struct {
+	Gophkeeper gophkeeper.Gophkeeper
+}
+
+// Route routes account entry.
+func (e *Entry) Route() http.Handler {
+	router := chi.NewRouter()
+	router.Use(authentication.Middleware(e.Gophkeeper))
+	router.Post("/password", e.password)
+	router.Post("/vault-password", e.vaultPassword)
+	router.Get("/settings", e.settings)
+	router.Put("/settings", e.updateSettings)
+	return router
+}
+
+// passwordChange is the body of a password change request.
+//
+// Metas of a vault password change re-encrypted by the client map
+// every stored meta to its re-encrypted one, Indexes map every
+// re-encrypted meta to its index and TagIndexes map every tag to its index.
+// Tags and Folders map every tag and folder name to the rewritten one.
+type passwordChange struct {
+	OldPassword *string             `json:"old_password"`
+	NewPassword *string             `json:"new_password"`
+	Metas       map[string]string   `json:"metas"`
+	Indexes     map[string][]string `json:"indexes"`
+	TagIndexes  map[string][]string `json:"tag_indexes"`
+	Tags        map[string]string   `json:"tags"`
+	Folders     map[string]string   `json:"folders"`
+}
+
+// decodePasswordChange decodes the password change request.
+func decodePasswordChange(in *http.Request) (passwordChange, bool) {
+	var request passwordChange
+	if err := json.NewDecoder(in.Body).Decode(&request); err != nil {
+		return passwordChange{}, false
+	}
+	if request.OldPassword == nil || request.NewPassword == nil || *request.NewPassword == "" {
+		return passwordChange{}, false
+	}
+	return request, true
+}
+
+// password changes the login password of the identity.
+func (e *Entry) password(out http.ResponseWriter, in *http.Request) {
+	identity := authentication.Identity(in)
+
+	request, ok := decodePasswordChange(in)
+	if !ok {
+		status := http.StatusBadRequest
+		http.Error(out, http.StatusText(status), status)
+		return
+	}
+
+	if err := identity.ChangePassword(in.Context(), *request.OldPassword, *request.NewPassword); err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, gophkeeper.ErrUnavailable) {
+			status = http.StatusServiceUnavailable
+		}
+		if errors.Is(err, gophkeeper.ErrBadCredential) {
+			status = http.StatusUnauthorized
+		}
+		http.Error(out, http.StatusText(status), status)
+		return
+	}
+
+	out.WriteHeader(http.StatusOK)
+}
+
+// progressEvent is a line of the vault password change response.
+type progressEvent struct {
+	Done     int    `json:"done"`
+	Total    int    `json:"total"`
+	Complete bool   `json:"complete,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+// lookup returns a function that looks up the value of the key,
+// a key missing from the values has changed since the client read it.
+func lookup[T any](values map[string]T) func(string) (T, error) {
+	return func(key string) (T, error) {
+		value, ok := values[key]
+		if !ok {
+			var zero T
+			return zero, gophkeeper.ErrStaleReencryption
+		}
+		return value, nil
+	}
+}
+
+// vaultPassword changes the vault password of the identity.
+//
+// If the request has metas, the identity re-encrypts with them instead of
+// re-encrypting on its own, a meta missing from them means it has changed
+// since the client read it and the change is answered with a conflict.
+//
+// Re-encryption progress is streamed as JSON lines once it starts,
+// an error after that is reported by the last line instead of the status.
+func (e *Entry) vaultPassword(out http.ResponseWriter, in *http.Request) {
+	identity := authentication.Identity(in)
+
+	request, ok := decodePasswordChange(in)
+	if !ok {
+		status := http.StatusBadRequest
+		http.Error(out, http.StatusText(status), status)
+		return
+	}
+	oldPassword, newPassword := *request.OldPassword, *request.NewPassword
+
+	change := identity.ChangeVaultPassword
+	if request.Metas != nil {
+		reencrypter, ok := identity.(gophkeeper.Reencrypter)
+		if !ok {
+			status := http.StatusNotImplemented
+			http.Error(out, http.StatusText(status), status)
+			return
+		}
+		reencryption := gophkeeper.Reencryption{
+			Meta: lookup(request.Metas),
+		}
+		if request.Indexes != nil {
+			reencryption.Index = lookup(request.Indexes)
+		}
+		if request.TagIndexes != nil {
+			reencryption.TagIndex = lookup(request.TagIndexes)
+		}
+		if request.Tags != nil {
+			reencryption.Tag = lookup(request.Tags)
+		}
+		if request.Folders != nil {
+			reencryption.Folder = lookup(request.Folders)
+		}
+		change = func(ctx context.Context, oldPassword, newPassword string) error {
+			return reencrypter.Reencrypt(ctx, oldPassword, newPassword, reencryption)
+		}
+	}
+
+	var (
+		encoder = json.NewEncoder(out)
+		last    progressEvent
+		started bool
+	)
+	write := func(event progressEvent) {
+		if !started {
+			out.Header().Set("Content-Type", "application/x-ndjson")
+			out.WriteHeader(http.StatusOK)
+			started = true
+		}
+		if err := encoder.Encode(&event); err != nil {
+			log.Printf("failed to write response: %s\n", err.Error())
+			return
+		}
+		if flusher, ok := out.(http.Flusher); ok {
+			flusher.Flush()
+		}
+	}
+	ctx := gophkeeper.WithProgress(in.Context(), func(done, total int) {
+		last = progressEvent{Done: done, Total: total}
+		write(last)
+	})
+
+	if err := change(ctx, oldPassword, newPassword); err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, gophkeeper.ErrUnavailable) {
+			status = http.StatusServiceUnavailable
+		}
+		if errors.Is(err, gophkeeper.ErrBadCredential) {
+			status = http.StatusUnauthorized
+		}
+		if errors.Is(err, gophkeeper.ErrStaleReencryption) {
+			status = http.StatusConflict
+		}
+		if started {
+			last.Error = http.StatusText(status)
+			write(last)
+			return
+		}
+		http.Error(out, http.StatusText(status), status)
+		return
+	}
+
+	last.Complete = true
+	write(last)
+}
@@ -0,0 +1,75 @@
+package account
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/kerelape/gophkeeper/internal/server/rest/authentication"
+	"github.com/kerelape/gophkeeper/pkg/gophkeeper"
+)
+
+// settings is the body of the account settings requests.
+//
+// Compression, Deduplication and VaultSalt are set by the server,
+// they are ignored when the settings are updated.
+type settings struct {
+	KeyFile       bool   `json:"key_file"`
+	Compression   bool   `json:"compression"`
+	Deduplication bool   `json:"deduplication"`
+	VaultSalt     []byte `json:"vault_salt,omitempty"`
+}
+
+// settings responds with the account settings of the identity.
+func (e *Entry) settings(out http.ResponseWriter, in *http.Request) {
+	identity := authentication.Identity(in)
+
+	current, settingsError := identity.Settings(in.Context())
+	if settingsError != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(settingsError, gophkeeper.ErrUnavailable) {
+			status = http.StatusServiceUnavailable
+		}
+		http.Error(out, http.StatusText(status), status)
+		return
+	}
+
+	response := settings{
+		KeyFile:       current.KeyFile,
+		Compression:   current.Compression,
+		Deduplication: current.Deduplication,
+		VaultSalt:     current.VaultSalt,
+	}
+	out.Header().Set("Content-Type", "application/json")
+	out.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(out).Encode(&response); err != nil {
+		log.Printf("failed to write response: %s\n", err.Error())
+	}
+}
+
+// updateSettings replaces the account settings of the identity.
+func (e *Entry) updateSettings(out http.ResponseWriter, in *http.Request) {
+	identity := authentication.Identity(in)
+
+	var request settings
+	if err := json.NewDecoder(in.Body).Decode(&request); err != nil {
+		status := http.StatusBadRequest
+		http.Error(out, http.StatusText(status), status)
+		return
+	}
+
+	updated := gophkeeper.Settings{
+		KeyFile: request.KeyFile,
+	}
+	if err := identity.UpdateSettings(in.Context(), updated); err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, gophkeeper.ErrUnavailable) {
+			status = http.StatusServiceUnavailable
+		}
+		http.Error(out, http.StatusText(status), status)
+		return
+	}
+
+	out.WriteHeader(http.StatusOK)
+}
@@ -0,0 +1,119 @@
+// Package admin provides REST endpoints to maintain the server,
+// they are authorized with the admin token instead of an identity.
+package admin
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/kerelape/gophkeeper/internal/server"
+	"github.com/kerelape/gophkeeper/pkg/gophkeeper"
+)
+
+// Entry is admin entry.
+type Entry struct {
+	Checker server.Checker
+	Token   string
+}
+
+// Route routes admin entry.
+func (e *Entry) Route() http.Handler {
+	router := chi.NewRouter()
+	router.Use(e.authorize)
+	router.Get("/fsck", e.fsck)
+	router.Post("/fsck", e.fsck)
+	return router
+}
+
+// authorize refuses the requests not authorized with the admin token.
+func (e *Entry) authorize(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(out http.ResponseWriter, in *http.Request) {
+		token := in.Header.Get("Authorization")
+		if e.Token == "" || subtle.ConstantTimeCompare(([]byte)(token), ([]byte)(e.Token)) != 1 {
+			status := http.StatusUnauthorized
+			http.Error(out, http.StatusText(status), status)
+			return
+		}
+		next.ServeHTTP(out, in)
+	})
+}
+
+type (
+	// fsckProblem is a problem of the fsck response.
+	fsckProblem struct {
+		Kind        string `json:"kind"`
+		Table       string `json:"table,omitempty"`
+		ID          int64  `json:"id,omitempty"`
+		Location    string `json:"location,omitempty"`
+		Detail      string `json:"detail,omitempty"`
+		Quarantined bool   `json:"quarantined"`
+	}
+
+	// fsckReport is the body of the fsck response.
+	fsckReport struct {
+		Resources int           `json:"resources"`
+		Pieces    int           `json:"pieces"`
+		Blobs     int           `json:"blobs"`
+		Files     int           `json:"files"`
+		Problems  []fsckProblem `json:"problems"`
+	}
+)
+
+// fsck checks the storage, POST repairs the problems found as well.
+//
+// The grace query parameter is how old a blob file must be
+// to be reported as an orphan, server.DefaultFsckGrace if omitted.
+func (e *Entry) fsck(out http.ResponseWriter, in *http.Request) {
+	options := server.FsckOptions{
+		Repair: in.Method == http.MethodPost,
+		Grace:  server.DefaultFsckGrace,
+	}
+	if grace := in.URL.Query().Get("grace"); grace != "" {
+		parsed, parseError := time.ParseDuration(grace)
+		if parseError != nil || parsed < 0 {
+			status := http.StatusBadRequest
+			http.Error(out, http.StatusText(status), status)
+			return
+		}
+		options.Grace = parsed
+	}
+
+	report, fsckError := e.Checker.Fsck(in.Context(), options)
+	if fsckError != nil {
+		log.Printf("failed to check the vault: %s\n", fsckError.Error())
+		status := http.StatusInternalServerError
+		if errors.Is(fsckError, gophkeeper.ErrUnavailable) {
+			status = http.StatusServiceUnavailable
+		}
+		http.Error(out, http.StatusText(status), status)
+		return
+	}
+
+	response := fsckReport{
+		Resources: report.Resources,
+		Pieces:    report.Pieces,
+		Blobs:     report.Blobs,
+		Files:     report.Files,
+		Problems:  make([]fsckProblem, 0, len(report.Problems)),
+	}
+	for _, problem := range report.Problems {
+		response.Problems = append(response.Problems, fsckProblem{
+			Kind:        (string)(problem.Kind),
+			Table:       problem.Table,
+			ID:          problem.ID,
+			Location:    problem.Location,
+			Detail:      problem.Detail,
+			Quarantined: problem.Quarantined,
+		})
+	}
+	out.Header().Set("Content-Type", "application/json")
+	out.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(out).Encode(&response); err != nil {
+		log.Printf("failed to write response: %s\n", err.Error())
+	}
+}
@@ -27,6 +27,9 @@ func Middleware(g gophkeeper.Gophkeeper) func(next http.Handler) http.Handler {
 			identity, identityError := g.Identity(in.Context(), (gophkeeper.Token)(token))
 			if identityError != nil {
 				status := http.StatusInternalServerError
+				if errors.Is(identityError, gophkeeper.ErrUnavailable) {
+					status = http.StatusServiceUnavailable
+				}
 				if errors.Is(identityError, gophkeeper.ErrBadCredential) {
 					status = http.StatusUnauthorized
 				}
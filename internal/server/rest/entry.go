@@ -2,8 +2,12 @@ package rest
 
 import (
 	"net/http"
+	"time"
 
 	"github.com/go-chi/chi/v5"
+	"github.com/kerelape/gophkeeper/internal/server"
+	"github.com/kerelape/gophkeeper/internal/server/rest/account"
+	"github.com/kerelape/gophkeeper/internal/server/rest/admin"
 	"github.com/kerelape/gophkeeper/internal/server/rest/login"
 	"github.com/kerelape/gophkeeper/internal/server/rest/register"
 	"github.com/kerelape/gophkeeper/internal/server/rest/vault"
@@ -11,8 +15,20 @@ import (
 )
 
 // Entry is the REST api entry.
+//
+// If RequireEncryption is set, pieces and blobs not encrypted
+// by the client are refused, see encrypted.Gophkeeper.
+//
+// The vault sessions expire once they are not used for VaultSessionIdle.
+//
+// The admin endpoints check the storage with the Checker, they are
+// mounted only if both the Checker and the AdminToken are set.
 type Entry struct {
-	Gophkeeper gophkeeper.Gophkeeper
+	Gophkeeper        gophkeeper.Gophkeeper
+	RequireEncryption bool
+	VaultSessionIdle  time.Duration
+	Checker           server.Checker
+	AdminToken        string
 }
 
 // Route routes Entry into an http.Handler.
@@ -25,6 +41,11 @@ func (e *Entry) Route() http.Handler {
 			Gophkeeper: e.Gophkeeper,
 		}
 		vault = vault.Entry{
+			Gophkeeper:        e.Gophkeeper,
+			RequireEncryption: e.RequireEncryption,
+			SessionIdle:       e.VaultSessionIdle,
+		}
+		account = account.Entry{
 			Gophkeeper: e.Gophkeeper,
 		}
 	)
@@ -32,5 +53,13 @@ func (e *Entry) Route() http.Handler {
 	router.Mount("/register", register.Route())
 	router.Mount("/login", login.Route())
 	router.Mount("/vault", vault.Route())
+	router.Mount("/account", account.Route())
+	if e.Checker != nil && e.AdminToken != "" {
+		admin := admin.Entry{
+			Checker: e.Checker,
+			Token:   e.AdminToken,
+		}
+		router.Mount("/admin", admin.Route())
+	}
 	return router
 }
@@ -1,6 +1,8 @@
 package rest_test
 
 import (
+	"context"
+	"crypto/sha256"
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
@@ -11,7 +13,9 @@ import (
 	"testing"
 	"time"
 
+	"github.com/kerelape/gophkeeper/internal/server"
 	"github.com/kerelape/gophkeeper/internal/server/rest"
+	"github.com/kerelape/gophkeeper/pkg/gophkeeper"
 	"github.com/kerelape/gophkeeper/pkg/gophkeeper/virtual"
 	"github.com/stretchr/testify/assert"
 )
@@ -85,6 +89,32 @@ func TestEntry(t *testing.T) {
 			response := recorder.Result()
 			assert.Equal(t, http.StatusBadRequest, response.StatusCode, "unexpected status code")
 		})
+		t.Run("With vault password", func(t *testing.T) {
+			var (
+				recorder = httptest.NewRecorder()
+				request  = httptest.NewRequest(
+					http.MethodPost,
+					"/register",
+					strings.NewReader(`{"username": "vault", "password": "qwerty", "vault_password": "ytrewq"}`),
+				)
+			)
+			handler.ServeHTTP(recorder, request)
+			response := recorder.Result()
+			assert.Equal(t, http.StatusCreated, response.StatusCode, "unexpected status code")
+		})
+		t.Run("Empty vault password", func(t *testing.T) {
+			var (
+				recorder = httptest.NewRecorder()
+				request  = httptest.NewRequest(
+					http.MethodPost,
+					"/register",
+					strings.NewReader(`{"username": "empty", "password": "qwerty", "vault_password": ""}`),
+				)
+			)
+			handler.ServeHTTP(recorder, request)
+			response := recorder.Result()
+			assert.Equal(t, http.StatusBadRequest, response.StatusCode, "unexpected status code")
+		})
 	})
 	t.Run("login", func(t *testing.T) {
 		t.Run("Existing identity", func(t *testing.T) {
@@ -217,6 +247,134 @@ func TestEntry(t *testing.T) {
 				assert.Equal(t, "testmeta", responseBody.Meta, "meta is not correct")
 				assert.Equal(t, "Hello, World!", (string)(content), "content is not correct")
 			})
+			t.Run("Update", func(t *testing.T) {
+				var (
+					recorder = httptest.NewRecorder()
+					request  = httptest.NewRequest(
+						http.MethodPost,
+						fmt.Sprintf("/vault/piece/%d", rid),
+						strings.NewReader(
+							fmt.Sprintf(
+								`{"meta": "updatedmeta", "content": "%s"}`,
+								base64.RawStdEncoding.EncodeToString(([]byte)("Goodbye, World!")),
+							),
+						),
+					)
+				)
+				request.Header.Set("Authorization", token)
+				request.Header.Set("X-Password", "qwerty")
+				handler.ServeHTTP(recorder, request)
+				response := recorder.Result()
+				assert.Equal(t, http.StatusOK, response.StatusCode, "unexpected status code")
+				t.Run("Unexisting RID", func(t *testing.T) {
+					var (
+						recorder = httptest.NewRecorder()
+						request  = httptest.NewRequest(
+							http.MethodPost,
+							"/vault/piece/100",
+							strings.NewReader(`{"meta": "", "content": ""}`),
+						)
+					)
+					request.Header.Set("Authorization", token)
+					request.Header.Set("X-Password", "qwerty")
+					handler.ServeHTTP(recorder, request)
+					response := recorder.Result()
+					assert.Equal(t, http.StatusNotFound, response.StatusCode, "unexpected status code")
+				})
+				t.Run("Non-JSON body", func(t *testing.T) {
+					var (
+						recorder = httptest.NewRecorder()
+						request  = httptest.NewRequest(
+							http.MethodPost,
+							fmt.Sprintf("/vault/piece/%d", rid),
+							strings.NewReader("_"),
+						)
+					)
+					request.Header.Set("Authorization", token)
+					request.Header.Set("X-Password", "qwerty")
+					handler.ServeHTTP(recorder, request)
+					response := recorder.Result()
+					assert.Equal(t, http.StatusBadRequest, response.StatusCode, "unexpected status code")
+				})
+			})
+			t.Run("Versions", func(t *testing.T) {
+				var (
+					recorder = httptest.NewRecorder()
+					request  = httptest.NewRequest(
+						http.MethodGet,
+						fmt.Sprintf("/vault/%d/versions", rid),
+						nil,
+					)
+				)
+				request.Header.Set("Authorization", token)
+				handler.ServeHTTP(recorder, request)
+				response := recorder.Result()
+				assert.Equal(t, http.StatusOK, response.StatusCode, "unexpected status code")
+
+				var responseBody []struct {
+					Version int    `json:"version"`
+					Meta    string `json:"meta"`
+				}
+				decodeError := json.NewDecoder(response.Body).Decode(&responseBody)
+				assert.Nil(t, decodeError, "did not expect an error")
+				assert.Equal(t, 1, len(responseBody), "unexpected versions count")
+				assert.Equal(t, "testmeta", responseBody[0].Meta, "unexpected version meta")
+
+				t.Run("Revert", func(t *testing.T) {
+					var (
+						recorder = httptest.NewRecorder()
+						request  = httptest.NewRequest(
+							http.MethodPost,
+							fmt.Sprintf("/vault/%d/versions/%d", rid, responseBody[0].Version),
+							nil,
+						)
+					)
+					request.Header.Set("Authorization", token)
+					request.Header.Set("X-Password", "qwerty")
+					handler.ServeHTTP(recorder, request)
+					response := recorder.Result()
+					assert.Equal(t, http.StatusOK, response.StatusCode, "unexpected status code")
+				})
+				t.Run("Revert without password", func(t *testing.T) {
+					var (
+						recorder = httptest.NewRecorder()
+						request  = httptest.NewRequest(
+							http.MethodPost,
+							fmt.Sprintf("/vault/%d/versions/1", rid),
+							nil,
+						)
+					)
+					request.Header.Set("Authorization", token)
+					handler.ServeHTTP(recorder, request)
+					response := recorder.Result()
+					assert.Equal(t, http.StatusBadRequest, response.StatusCode, "unexpected status code")
+				})
+				t.Run("Revert unexisting version", func(t *testing.T) {
+					var (
+						recorder = httptest.NewRecorder()
+						request  = httptest.NewRequest(
+							http.MethodPost,
+							fmt.Sprintf("/vault/%d/versions/100", rid),
+							nil,
+						)
+					)
+					request.Header.Set("Authorization", token)
+					request.Header.Set("X-Password", "qwerty")
+					handler.ServeHTTP(recorder, request)
+					response := recorder.Result()
+					assert.Equal(t, http.StatusNotFound, response.StatusCode, "unexpected status code")
+				})
+				t.Run("Invalid RID", func(t *testing.T) {
+					var (
+						recorder = httptest.NewRecorder()
+						request  = httptest.NewRequest(http.MethodGet, "/vault/_/versions", nil)
+					)
+					request.Header.Set("Authorization", token)
+					handler.ServeHTTP(recorder, request)
+					response := recorder.Result()
+					assert.Equal(t, http.StatusBadRequest, response.StatusCode, "unexpected status code")
+				})
+			})
 		})
 
 		t.Run("blob", func(t *testing.T) {
@@ -329,6 +487,33 @@ func TestEntry(t *testing.T) {
 				assert.Nil(t, contentError, "unexpected error")
 				assert.Equal(t, "Hello, World!", (string)(content), "content is not correct")
 			})
+			t.Run("Update", func(t *testing.T) {
+				var (
+					recorder = httptest.NewRecorder()
+					request  = httptest.NewRequest(
+						http.MethodPost,
+						fmt.Sprintf("/vault/blob/%d", rid),
+						strings.NewReader("Goodbye, World!"),
+					)
+				)
+				request.Header.Set("Authorization", token)
+				request.Header.Set("X-Password", "qwerty")
+				request.Header.Set("X-Meta", "updatedmeta")
+				handler.ServeHTTP(recorder, request)
+				response := recorder.Result()
+				assert.Equal(t, http.StatusOK, response.StatusCode, "unexpected status code")
+				t.Run("Invalid RID", func(t *testing.T) {
+					var (
+						recorder = httptest.NewRecorder()
+						request  = httptest.NewRequest(http.MethodPost, "/vault/blob/_", strings.NewReader(""))
+					)
+					request.Header.Set("Authorization", token)
+					request.Header.Set("X-Password", "qwerty")
+					handler.ServeHTTP(recorder, request)
+					response := recorder.Result()
+					assert.Equal(t, http.StatusBadRequest, response.StatusCode, "unexpected status code")
+				})
+			})
 		})
 
 		t.Run("List", func(t *testing.T) {
@@ -340,6 +525,67 @@ func TestEntry(t *testing.T) {
 			handler.ServeHTTP(recorder, request)
 			response := recorder.Result()
 			assert.Equal(t, http.StatusOK, response.StatusCode, "unexpected status code")
+
+			var responseBody []struct {
+				Size      int64     `json:"size"`
+				Hash      []byte    `json:"hash"`
+				CreatedAt time.Time `json:"created_at"`
+			}
+			decodeError := json.NewDecoder(response.Body).Decode(&responseBody)
+			assert.Nil(t, decodeError, "did not expect an error")
+			assert.NotEmpty(t, responseBody, "expected resources to be listed")
+			for _, resource := range responseBody {
+				assert.Positive(t, resource.Size, "expected size to be set")
+				assert.Len(t, resource.Hash, sha256.Size, "expected hash to be set")
+				assert.False(t, resource.CreatedAt.IsZero(), "expected creation time to be set")
+			}
+			t.Run("Paginated", func(t *testing.T) {
+				var (
+					recorder = httptest.NewRecorder()
+					request  = httptest.NewRequest(http.MethodGet, "/vault?limit=1&order=3&desc=true", nil)
+				)
+				request.Header.Set("Authorization", token)
+				handler.ServeHTTP(recorder, request)
+				response := recorder.Result()
+				assert.Equal(t, http.StatusOK, response.StatusCode, "unexpected status code")
+				var page []json.RawMessage
+				decodeError := json.NewDecoder(response.Body).Decode(&page)
+				assert.Nil(t, decodeError, "did not expect an error")
+				assert.Len(t, page, 1, "expected a single resource on the page")
+				next := response.Header.Get("X-Next-Cursor")
+				assert.NotEmpty(t, next, "expected a next cursor")
+
+				recorder = httptest.NewRecorder()
+				request = httptest.NewRequest(
+					http.MethodGet,
+					"/vault?limit=1&order=3&desc=true&cursor="+next,
+					nil,
+				)
+				request.Header.Set("Authorization", token)
+				handler.ServeHTTP(recorder, request)
+				response = recorder.Result()
+				assert.Equal(t, http.StatusOK, response.StatusCode, "unexpected status code")
+			})
+			t.Run("Invalid cursor", func(t *testing.T) {
+				var (
+					recorder = httptest.NewRecorder()
+					request  = httptest.NewRequest(http.MethodGet, "/vault?cursor=invalid", nil)
+				)
+				request.Header.Set("Authorization", token)
+				handler.ServeHTTP(recorder, request)
+				response := recorder.Result()
+				assert.Equal(t, http.StatusBadRequest, response.StatusCode, "unexpected status code")
+			})
+			t.Run("Invalid order", func(t *testing.T) {
+				var (
+					recorder = httptest.NewRecorder()
+					request  = httptest.NewRequest(http.MethodGet, "/vault?order=42", nil)
+				)
+				request.Header.Set("Authorization", token)
+				handler.ServeHTTP(recorder, request)
+				response := recorder.Result()
+				assert.Equal(t, http.StatusBadRequest, response.StatusCode, "unexpected status code")
+			})
 			t.Run("Without token", func(t *testing.T) {
 				var (
 					recorder = httptest.NewRecorder()
@@ -380,5 +626,443 @@ func TestEntry(t *testing.T) {
 				assert.Equal(t, http.StatusBadRequest, response.StatusCode, "unexpected status code")
 			})
 		})
+
+		t.Run("Trash", func(t *testing.T) {
+			var (
+				recorder = httptest.NewRecorder()
+				request  = httptest.NewRequest(http.MethodGet, "/vault/trash", nil)
+			)
+			request.Header.Set("Authorization", token)
+			handler.ServeHTTP(recorder, request)
+			response := recorder.Result()
+			assert.Equal(t, http.StatusOK, response.StatusCode, "unexpected status code")
+
+			var responseBody []struct {
+				RID  int    `json:"rid"`
+				Meta string `json:"meta"`
+			}
+			decodeError := json.NewDecoder(response.Body).Decode(&responseBody)
+			assert.Nil(t, decodeError, "did not expect an error")
+			assert.Equal(t, 1, len(responseBody), "unexpected trash size")
+			assert.Equal(t, 0, responseBody[0].RID, "unexpected resource in the trash")
+
+			t.Run("Without token", func(t *testing.T) {
+				var (
+					recorder = httptest.NewRecorder()
+					request  = httptest.NewRequest(http.MethodGet, "/vault/trash", nil)
+				)
+				handler.ServeHTTP(recorder, request)
+				response := recorder.Result()
+				assert.Equal(t, http.StatusUnauthorized, response.StatusCode, "unexpected status code")
+			})
+			t.Run("Undelete", func(t *testing.T) {
+				var (
+					recorder = httptest.NewRecorder()
+					request  = httptest.NewRequest(http.MethodPost, "/vault/trash/0", nil)
+				)
+				request.Header.Set("Authorization", token)
+				handler.ServeHTTP(recorder, request)
+				response := recorder.Result()
+				assert.Equal(t, http.StatusOK, response.StatusCode, "unexpected status code")
+			})
+			t.Run("Purge live resource", func(t *testing.T) {
+				var (
+					recorder = httptest.NewRecorder()
+					request  = httptest.NewRequest(http.MethodDelete, "/vault/trash/0", nil)
+				)
+				request.Header.Set("Authorization", token)
+				handler.ServeHTTP(recorder, request)
+				response := recorder.Result()
+				assert.Equal(t, http.StatusNotFound, response.StatusCode, "unexpected status code")
+			})
+			t.Run("Purge", func(t *testing.T) {
+				var (
+					deleteRecorder = httptest.NewRecorder()
+					deleteRequest  = httptest.NewRequest(http.MethodDelete, "/vault/0", nil)
+				)
+				deleteRequest.Header.Set("Authorization", token)
+				handler.ServeHTTP(deleteRecorder, deleteRequest)
+				assert.Equal(t, http.StatusOK, deleteRecorder.Result().StatusCode, "unexpected status code")
+
+				var (
+					recorder = httptest.NewRecorder()
+					request  = httptest.NewRequest(http.MethodDelete, "/vault/trash/0", nil)
+				)
+				request.Header.Set("Authorization", token)
+				handler.ServeHTTP(recorder, request)
+				response := recorder.Result()
+				assert.Equal(t, http.StatusOK, response.StatusCode, "unexpected status code")
+			})
+			t.Run("Invalid RID", func(t *testing.T) {
+				var (
+					recorder = httptest.NewRecorder()
+					request  = httptest.NewRequest(http.MethodPost, "/vault/trash/_", nil)
+				)
+				request.Header.Set("Authorization", token)
+				handler.ServeHTTP(recorder, request)
+				response := recorder.Result()
+				assert.Equal(t, http.StatusBadRequest, response.StatusCode, "unexpected status code")
+			})
+		})
+
+		t.Run("Folders", func(t *testing.T) {
+			serve := func(method, target, body string) *http.Response {
+				var (
+					recorder = httptest.NewRecorder()
+					request  = httptest.NewRequest(method, target, strings.NewReader(body))
+				)
+				request.Header.Set("Authorization", token)
+				request.Header.Set("X-Password", "qwerty")
+				handler.ServeHTTP(recorder, request)
+				return recorder.Result()
+			}
+
+			response := serve(http.MethodPut, "/vault/folders", `{"name": "work", "parent": 0}`)
+			assert.Equal(t, http.StatusCreated, response.StatusCode, "unexpected status code")
+			var folder struct {
+				ID int `json:"id"`
+			}
+			assert.Nil(t, json.NewDecoder(response.Body).Decode(&folder), "did not expect an error")
+
+			response = serve(http.MethodPut, "/vault/folders", `{"name": "nested", "parent": 100}`)
+			assert.Equal(t, http.StatusNotFound, response.StatusCode, "unexpected status code")
+
+			response = serve(http.MethodPut, "/vault/folders", `{"parent": 0}`)
+			assert.Equal(t, http.StatusBadRequest, response.StatusCode, "unexpected status code")
+
+			response = serve(http.MethodGet, "/vault/folders", "")
+			assert.Equal(t, http.StatusOK, response.StatusCode, "unexpected status code")
+			var folders []struct {
+				ID   int    `json:"id"`
+				Name string `json:"name"`
+			}
+			assert.Nil(t, json.NewDecoder(response.Body).Decode(&folders), "did not expect an error")
+			assert.Equal(t, 1, len(folders), "unexpected folders count")
+			assert.Equal(t, "work", folders[0].Name, "unexpected folder name")
+
+			response = serve(http.MethodPost, fmt.Sprintf("/vault/folders/%d", folder.ID), fmt.Sprintf(`{"parent": %d}`, folder.ID))
+			assert.Equal(t, http.StatusConflict, response.StatusCode, "unexpected status code")
+
+			response = serve(http.MethodPost, "/vault/folders/100", `{"parent": 0}`)
+			assert.Equal(t, http.StatusNotFound, response.StatusCode, "unexpected status code")
+
+			response = serve(
+				http.MethodPut,
+				"/vault/piece",
+				fmt.Sprintf(`{"meta": "testmeta", "content": "%s"}`, base64.RawStdEncoding.EncodeToString(([]byte)("content"))),
+			)
+			assert.Equal(t, http.StatusCreated, response.StatusCode, "unexpected status code")
+			var piece struct {
+				RID int `json:"rid"`
+			}
+			assert.Nil(t, json.NewDecoder(response.Body).Decode(&piece), "did not expect an error")
+
+			response = serve(http.MethodPost, fmt.Sprintf("/vault/%d/folder", piece.RID), fmt.Sprintf(`{"folder": %d}`, folder.ID))
+			assert.Equal(t, http.StatusOK, response.StatusCode, "unexpected status code")
+
+			response = serve(http.MethodPost, fmt.Sprintf("/vault/%d/folder", piece.RID), `{"folder": 100}`)
+			assert.Equal(t, http.StatusUnprocessableEntity, response.StatusCode, "unexpected status code")
+
+			response = serve(http.MethodPost, "/vault/100/folder", `{"folder": 0}`)
+			assert.Equal(t, http.StatusNotFound, response.StatusCode, "unexpected status code")
+
+			response = serve(http.MethodPut, fmt.Sprintf("/vault/%d/tags?tag=important", piece.RID), "")
+			assert.Equal(t, http.StatusOK, response.StatusCode, "unexpected status code")
+
+			response = serve(http.MethodPut, fmt.Sprintf("/vault/%d/tags", piece.RID), "")
+			assert.Equal(t, http.StatusBadRequest, response.StatusCode, "unexpected status code")
+
+			response = serve(http.MethodPut, "/vault/100/tags?tag=important", "")
+			assert.Equal(t, http.StatusNotFound, response.StatusCode, "unexpected status code")
+
+			response = serve(http.MethodGet, fmt.Sprintf("/vault?folder=%d&tag=important", folder.ID), "")
+			assert.Equal(t, http.StatusOK, response.StatusCode, "unexpected status code")
+			var resources []struct {
+				RID    int      `json:"rid"`
+				Folder int      `json:"folder"`
+				Tags   []string `json:"tags"`
+			}
+			assert.Nil(t, json.NewDecoder(response.Body).Decode(&resources), "did not expect an error")
+			assert.Equal(t, 1, len(resources), "unexpected resources count")
+			assert.Equal(t, piece.RID, resources[0].RID, "unexpected resource")
+			assert.Equal(t, folder.ID, resources[0].Folder, "unexpected resource folder")
+			assert.Equal(t, []string{"important"}, resources[0].Tags, "unexpected resource tags")
+
+			response = serve(http.MethodDelete, fmt.Sprintf("/vault/%d/tags?tag=important", piece.RID), "")
+			assert.Equal(t, http.StatusOK, response.StatusCode, "unexpected status code")
+
+			response = serve(http.MethodGet, "/vault?tag=important", "")
+			assert.Equal(t, http.StatusOK, response.StatusCode, "unexpected status code")
+			assert.Nil(t, json.NewDecoder(response.Body).Decode(&resources), "did not expect an error")
+			assert.Empty(t, resources, "expected no resources with the removed tag")
+
+			response = serve(http.MethodGet, "/vault?folder=_", "")
+			assert.Equal(t, http.StatusBadRequest, response.StatusCode, "unexpected status code")
+		})
+	})
+	t.Run("unlock", func(t *testing.T) {
+		serve := func(method, target, password, session, body string) *http.Response {
+			var (
+				recorder = httptest.NewRecorder()
+				request  = httptest.NewRequest(method, target, strings.NewReader(body))
+			)
+			request.Header.Set("Authorization", token)
+			if password != "" {
+				request.Header.Set("X-Password", password)
+			}
+			if session != "" {
+				request.Header.Set("X-Vault-Session", session)
+			}
+			handler.ServeHTTP(recorder, request)
+			return recorder.Result()
+		}
+		const piece = `{"meta": "meta", "content": ""}`
+
+		response := serve(http.MethodPost, "/vault/unlock", "", "", "")
+		assert.Equal(t, http.StatusBadRequest, response.StatusCode, "unexpected status code")
+		response = serve(http.MethodPost, "/vault/unlock", "wrong", "", "")
+		assert.Equal(t, http.StatusUnauthorized, response.StatusCode, "unexpected status code")
+
+		response = serve(http.MethodPost, "/vault/unlock", "qwerty", "", "")
+		assert.Equal(t, http.StatusCreated, response.StatusCode, "unexpected status code")
+		session := response.Header.Get("X-Vault-Session")
+		assert.NotEmpty(t, session, "expected a vault session")
+
+		response = serve(http.MethodPut, "/vault/piece", "", session, piece)
+		assert.Equal(t, http.StatusCreated, response.StatusCode, "expected the session to stand for the password")
+		response = serve(http.MethodPut, "/vault/piece", "", "unknown", piece)
+		assert.Equal(t, http.StatusUnauthorized, response.StatusCode, "unexpected status code")
+
+		response = serve(http.MethodDelete, "/vault/unlock", "", session, "")
+		assert.Equal(t, http.StatusOK, response.StatusCode, "unexpected status code")
+		response = serve(http.MethodPut, "/vault/piece", "", session, piece)
+		assert.Equal(t, http.StatusUnauthorized, response.StatusCode, "expected the session to be revoked")
+		response = serve(http.MethodDelete, "/vault/unlock", "", session, "")
+		assert.Equal(t, http.StatusNotFound, response.StatusCode, "unexpected status code")
+	})
+	t.Run("account", func(t *testing.T) {
+		serve := func(token, target, body string) *http.Response {
+			var (
+				recorder = httptest.NewRecorder()
+				request  = httptest.NewRequest(http.MethodPost, target, strings.NewReader(body))
+			)
+			request.Header.Set("Authorization", token)
+			handler.ServeHTTP(recorder, request)
+			return recorder.Result()
+		}
+
+		t.Run("password", func(t *testing.T) {
+			response := serve("", "/account/password", `{"old_password": "qwerty", "new_password": "asdfgh"}`)
+			assert.Equal(t, http.StatusUnauthorized, response.StatusCode, "unexpected status code")
+
+			response = serve(token, "/account/password", "qwerty")
+			assert.Equal(t, http.StatusBadRequest, response.StatusCode, "unexpected status code")
+
+			response = serve(token, "/account/password", `{"old_password": "wrong", "new_password": "asdfgh"}`)
+			assert.Equal(t, http.StatusUnauthorized, response.StatusCode, "unexpected status code")
+
+			response = serve(token, "/account/password", `{"old_password": "qwerty", "new_password": "asdfgh"}`)
+			assert.Equal(t, http.StatusOK, response.StatusCode, "unexpected status code")
+		})
+
+		t.Run("settings", func(t *testing.T) {
+			settings := func(method, body string) *http.Response {
+				var (
+					recorder = httptest.NewRecorder()
+					request  = httptest.NewRequest(method, "/account/settings", strings.NewReader(body))
+				)
+				request.Header.Set("Authorization", token)
+				handler.ServeHTTP(recorder, request)
+				return recorder.Result()
+			}
+
+			response := settings(http.MethodPut, "key_file")
+			assert.Equal(t, http.StatusBadRequest, response.StatusCode, "unexpected status code")
+
+			response = settings(http.MethodPut, `{"key_file": true}`)
+			assert.Equal(t, http.StatusOK, response.StatusCode, "unexpected status code")
+
+			response = settings(http.MethodGet, "")
+			assert.Equal(t, http.StatusOK, response.StatusCode, "unexpected status code")
+			var body struct {
+				KeyFile bool `json:"key_file"`
+			}
+			assert.Nil(t, json.NewDecoder(response.Body).Decode(&body), "did not expect an error")
+			assert.True(t, body.KeyFile, "expected the settings to be updated")
+
+			response = settings(http.MethodPut, `{"key_file": false}`)
+			assert.Equal(t, http.StatusOK, response.StatusCode, "unexpected status code")
+		})
+
+		t.Run("vault-password", func(t *testing.T) {
+			response := serve("", "/account/vault-password", `{"old_password": "qwerty", "new_password": "asdfgh"}`)
+			assert.Equal(t, http.StatusUnauthorized, response.StatusCode, "unexpected status code")
+
+			response = serve(token, "/account/vault-password", "qwerty")
+			assert.Equal(t, http.StatusBadRequest, response.StatusCode, "unexpected status code")
+
+			response = serve(token, "/account/vault-password", `{"old_password": "qwerty"}`)
+			assert.Equal(t, http.StatusBadRequest, response.StatusCode, "unexpected status code")
+
+			response = serve(token, "/account/vault-password", `{"old_password": "wrong", "new_password": "asdfgh"}`)
+			assert.Equal(t, http.StatusUnauthorized, response.StatusCode, "unexpected status code")
+
+			response = serve(token, "/account/vault-password", `{"old_password": "qwerty", "new_password": "asdfgh"}`)
+			assert.Equal(t, http.StatusOK, response.StatusCode, "unexpected status code")
+			var (
+				decoder = json.NewDecoder(response.Body)
+				event   struct {
+					Done     int    `json:"done"`
+					Total    int    `json:"total"`
+					Complete bool   `json:"complete"`
+					Error    string `json:"error"`
+				}
+			)
+			for decoder.More() {
+				assert.Nil(t, decoder.Decode(&event), "did not expect an error")
+			}
+			assert.True(t, event.Complete, "expected the last line to report completion")
+			assert.Empty(t, event.Error, "did not expect an error")
+			assert.Equal(t, event.Total, event.Done, "expected all resources to be re-encrypted")
+		})
+	})
+}
+
+type checkerFunc func(ctx context.Context, options server.FsckOptions) (server.FsckReport, error)
+
+func (f checkerFunc) Fsck(ctx context.Context, options server.FsckOptions) (server.FsckReport, error) {
+	return f(ctx, options)
+}
+
+func TestAdmin(t *testing.T) {
+	var (
+		checks = make([]server.FsckOptions, 0)
+		r      = rest.Entry{
+			Gophkeeper: virtual.New(time.Hour, t.TempDir()),
+			Checker: checkerFunc(func(_ context.Context, options server.FsckOptions) (server.FsckReport, error) {
+				checks = append(checks, options)
+				report := server.FsckReport{
+					Resources: 2,
+					Files:     1,
+					Problems: []server.FsckProblem{
+						{
+							Kind:        server.FsckOrphanFile,
+							Location:    "/blobs/f",
+							Quarantined: options.Repair,
+						},
+					},
+				}
+				return report, nil
+			}),
+			AdminToken: "admin",
+		}
+		handler = r.Route()
+	)
+	fsck := func(method, target, token string) *http.Response {
+		var (
+			recorder = httptest.NewRecorder()
+			request  = httptest.NewRequest(method, target, nil)
+		)
+		if token != "" {
+			request.Header.Set("Authorization", token)
+		}
+		handler.ServeHTTP(recorder, request)
+		return recorder.Result()
+	}
+	decode := func(response *http.Response) map[string]any {
+		var report map[string]any
+		assert.Nil(t, json.NewDecoder(response.Body).Decode(&report), "expected a JSON report")
+		return report
+	}
+
+	t.Run("Without token", func(t *testing.T) {
+		response := fsck(http.MethodGet, "/admin/fsck", "")
+		assert.Equal(t, http.StatusUnauthorized, response.StatusCode, "unexpected status code")
+	})
+	t.Run("Wrong token", func(t *testing.T) {
+		response := fsck(http.MethodGet, "/admin/fsck", "user")
+		assert.Equal(t, http.StatusUnauthorized, response.StatusCode, "unexpected status code")
+	})
+	t.Run("Invalid grace", func(t *testing.T) {
+		response := fsck(http.MethodGet, "/admin/fsck?grace=soon", "admin")
+		assert.Equal(t, http.StatusBadRequest, response.StatusCode, "unexpected status code")
 	})
+	t.Run("Check", func(t *testing.T) {
+		response := fsck(http.MethodGet, "/admin/fsck", "admin")
+		assert.Equal(t, http.StatusOK, response.StatusCode, "unexpected status code")
+		report := decode(response)
+		assert.Equal(t, 2.0, report["resources"], "unexpected number of resources")
+		problems, ok := report["problems"].([]any)
+		if assert.True(t, ok, "expected problems") && assert.Len(t, problems, 1, "expected a problem") {
+			problem := problems[0].(map[string]any)
+			assert.Equal(t, "orphan-file", problem["kind"], "unexpected kind")
+			assert.Equal(t, "/blobs/f", problem["location"], "unexpected location")
+			assert.Equal(t, false, problem["quarantined"], "did not expect a check to repair")
+		}
+		assert.Equal(t, server.FsckOptions{Grace: server.DefaultFsckGrace}, checks[len(checks)-1], "unexpected options")
+	})
+	t.Run("Repair", func(t *testing.T) {
+		response := fsck(http.MethodPost, "/admin/fsck?grace=10m", "admin")
+		assert.Equal(t, http.StatusOK, response.StatusCode, "unexpected status code")
+		problem := decode(response)["problems"].([]any)[0].(map[string]any)
+		assert.Equal(t, true, problem["quarantined"], "expected the problem to be repaired")
+		assert.Equal(t, server.FsckOptions{Repair: true, Grace: 10 * time.Minute}, checks[len(checks)-1], "unexpected options")
+	})
+	t.Run("Without admin token", func(t *testing.T) {
+		r := rest.Entry{
+			Gophkeeper: virtual.New(time.Hour, t.TempDir()),
+			Checker:    r.Checker,
+		}
+		recorder := httptest.NewRecorder()
+		r.Route().ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/admin/fsck", nil))
+		assert.Equal(t, http.StatusNotFound, recorder.Result().StatusCode, "expected the admin endpoints to be disabled")
+	})
+}
+
+func TestUnavailable(t *testing.T) {
+	var (
+		r = rest.Entry{
+			Gophkeeper: unavailableGophkeeper{},
+		}
+		handler = r.Route()
+	)
+	serve := func(method, target, body, token string) *http.Response {
+		var (
+			recorder = httptest.NewRecorder()
+			request  = httptest.NewRequest(method, target, strings.NewReader(body))
+		)
+		if token != "" {
+			request.Header.Set("Authorization", token)
+		}
+		handler.ServeHTTP(recorder, request)
+		return recorder.Result()
+	}
+
+	t.Run("Register", func(t *testing.T) {
+		response := serve(http.MethodPost, "/register", `{"username": "test", "password": "qwerty"}`, "")
+		assert.Equal(t, http.StatusServiceUnavailable, response.StatusCode, "unexpected status code")
+	})
+	t.Run("Login", func(t *testing.T) {
+		response := serve(http.MethodPost, "/login", `{"username": "test", "password": "qwerty"}`, "")
+		assert.Equal(t, http.StatusServiceUnavailable, response.StatusCode, "unexpected status code")
+	})
+	t.Run("Vault", func(t *testing.T) {
+		response := serve(http.MethodGet, "/vault", "", "token")
+		assert.Equal(t, http.StatusServiceUnavailable, response.StatusCode, "unexpected status code")
+	})
+}
+
+// unavailableGophkeeper is a gophkeeper whose storage can't be reached.
+type unavailableGophkeeper struct{}
+
+func (unavailableGophkeeper) Register(context.Context, gophkeeper.Credential) error {
+	return gophkeeper.ErrUnavailable
+}
+
+func (unavailableGophkeeper) Authenticate(context.Context, gophkeeper.Credential) (gophkeeper.Token, error) {
+	return gophkeeper.InvalidToken, gophkeeper.ErrUnavailable
+}
+
+func (unavailableGophkeeper) Identity(context.Context, gophkeeper.Token) (gophkeeper.Identity, error) {
+	return nil, gophkeeper.ErrUnavailable
 }
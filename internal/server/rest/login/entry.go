@@ -53,6 +53,9 @@ func (e *Entry) login(out http.ResponseWriter, in *http.Request) {
 	token, authenticateError := e.Gophkeeper.Authenticate(in.Context(), credential)
 	if authenticateError != nil {
 		status := http.StatusInternalServerError
+		if errors.Is(authenticateError, gophkeeper.ErrUnavailable) {
+			status = http.StatusServiceUnavailable
+		}
 		if errors.Is(authenticateError, gophkeeper.ErrBadCredential) {
 			status = http.StatusUnauthorized
 		}
@@ -25,8 +25,10 @@ func (e *Entry) Route() http.Handler {
 
 func (e *Entry) register(out http.ResponseWriter, in *http.Request) {
 	var requestBody struct {
-		Username *string `json:"username"`
-		Password *string `json:"password"`
+		Username      *string `json:"username"`
+		Password      *string `json:"password"`
+		VaultPassword *string `json:"vault_password"`
+		VaultSalt     []byte  `json:"vault_salt"`
 	}
 	if err := json.NewDecoder(in.Body).Decode(&requestBody); err != nil {
 		status := http.StatusBadRequest
@@ -51,8 +53,23 @@ func (e *Entry) register(out http.ResponseWriter, in *http.Request) {
 		return
 	}
 
+	// The vault password is optional for the clients
+	// that do not know about it, the password is used then.
+	if vaultPassword := requestBody.VaultPassword; vaultPassword != nil {
+		if *vaultPassword == "" {
+			status := http.StatusBadRequest
+			http.Error(out, http.StatusText(status), status)
+			return
+		}
+		credential.VaultPassword = *vaultPassword
+	}
+	credential.VaultSalt = requestBody.VaultSalt
+
 	if err := e.Gophkeeper.Register(in.Context(), credential); err != nil {
 		status := http.StatusInternalServerError
+		if errors.Is(err, gophkeeper.ErrUnavailable) {
+			status = http.StatusServiceUnavailable
+		}
 		if errors.Is(err, gophkeeper.ErrBadCredential) {
 			status = http.StatusBadRequest
 		}
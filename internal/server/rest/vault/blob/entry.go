@@ -14,17 +14,25 @@ import (
 	"github.com/kerelape/gophkeeper/internal/server/rest/authentication"
 	"github.com/kerelape/gophkeeper/internal/server/rest/vault/credential"
 	"github.com/kerelape/gophkeeper/pkg/gophkeeper"
+	"github.com/kerelape/gophkeeper/pkg/gophkeeper/encrypted"
 )
 
 // Entry is blob entry.
-type Entry struct{}
+//
+// If RequireEncryption is set, blobs with the meta
+// not wrapped into an envelope are refused.
+type Entry struct {
+	RequireEncryption bool
+	Sessions          *credential.Sessions
+}
 
 // Route routes blob entry.
 func (e *Entry) Route() http.Handler {
 	router := chi.NewRouter()
-	router.Use(credential.Middleware)
+	router.Use(e.Sessions.Middleware)
 	router.Put("/", e.encrypt)
 	router.Get("/{rid}", e.decrypt)
+	router.Post("/{rid}", e.update)
 	return router
 }
 
@@ -35,10 +43,19 @@ func (e *Entry) encrypt(out http.ResponseWriter, in *http.Request) {
 	blob := gophkeeper.Blob{
 		Meta:    in.Header.Get("X-Meta"),
 		Content: in.Body,
+		Index:   in.Header.Values("X-Index"),
+	}
+	if e.RequireEncryption && !encrypted.IsEnvelope(blob.Meta) {
+		status := http.StatusUnprocessableEntity
+		http.Error(out, http.StatusText(status), status)
+		return
 	}
 	rid, storeError := identity.StoreBlob(in.Context(), blob, password)
 	if storeError != nil {
 		status := http.StatusInternalServerError
+		if errors.Is(storeError, gophkeeper.ErrUnavailable) {
+			status = http.StatusServiceUnavailable
+		}
 		if errors.Is(storeError, gophkeeper.ErrBadCredential) {
 			status = http.StatusUnauthorized
 		}
@@ -70,6 +87,9 @@ func (e *Entry) decrypt(out http.ResponseWriter, in *http.Request) {
 	blob, restoreError := identity.RestoreBlob(in.Context(), (gophkeeper.ResourceID)(rid), password)
 	if restoreError != nil {
 		status := http.StatusInternalServerError
+		if errors.Is(restoreError, gophkeeper.ErrUnavailable) {
+			status = http.StatusServiceUnavailable
+		}
 		if errors.Is(restoreError, gophkeeper.ErrBadCredential) {
 			status = http.StatusUnauthorized
 		}
@@ -94,3 +114,42 @@ func (e *Entry) decrypt(out http.ResponseWriter, in *http.Request) {
 		log.Printf("failed to flush content: %s", err.Error())
 	}
 }
+
+func (e *Entry) update(out http.ResponseWriter, in *http.Request) {
+	identity := authentication.Identity(in)
+	password := credential.Password(in)
+
+	rid, ridError := strconv.Atoi(chi.URLParam(in, "rid"))
+	if ridError != nil {
+		status := http.StatusBadRequest
+		http.Error(out, http.StatusText(status), status)
+		return
+	}
+
+	blob := gophkeeper.Blob{
+		Meta:    in.Header.Get("X-Meta"),
+		Content: in.Body,
+		Index:   in.Header.Values("X-Index"),
+	}
+	if e.RequireEncryption && !encrypted.IsEnvelope(blob.Meta) {
+		status := http.StatusUnprocessableEntity
+		http.Error(out, http.StatusText(status), status)
+		return
+	}
+	if err := identity.UpdateBlob(in.Context(), (gophkeeper.ResourceID)(rid), blob, password); err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, gophkeeper.ErrUnavailable) {
+			status = http.StatusServiceUnavailable
+		}
+		if errors.Is(err, gophkeeper.ErrBadCredential) {
+			status = http.StatusUnauthorized
+		}
+		if errors.Is(err, gophkeeper.ErrResourceNotFound) {
+			status = http.StatusNotFound
+		}
+		http.Error(out, http.StatusText(status), status)
+		return
+	}
+
+	out.WriteHeader(http.StatusOK)
+}
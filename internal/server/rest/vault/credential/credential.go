@@ -5,6 +5,8 @@ package credential
 import (
 	"context"
 	"net/http"
+
+	"github.com/kerelape/gophkeeper/internal/server"
 )
 
 type contextKey string
@@ -12,10 +14,25 @@ type contextKey string
 const contextKeyPassword = contextKey("password")
 
 // Middleware is vault credential middleware.
-func Middleware(next http.Handler) http.Handler {
+//
+// The vault password is taken from the X-Password header or,
+// if the X-Vault-Session header is set, from the session.
+func (s *Sessions) Middleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(out http.ResponseWriter, in *http.Request) {
+		ctx := in.Context()
 		password := in.Header.Get("X-Password")
-		if password == "" {
+		if handle := in.Header.Get("X-Vault-Session"); handle != "" {
+			session, ok := s.use(handle, in.Header.Get("Authorization"))
+			if !ok {
+				status := http.StatusUnauthorized
+				http.Error(out, http.StatusText(status), status)
+				return
+			}
+			password = session.password
+			if session.key != nil {
+				ctx = server.WithVaultKey(ctx, session.key)
+			}
+		} else if password == "" {
 			status := http.StatusBadRequest
 			http.Error(out, http.StatusText(status), status)
 			return
@@ -24,7 +41,7 @@ func Middleware(next http.Handler) http.Handler {
 			out,
 			in.WithContext(
 				context.WithValue(
-					in.Context(),
+					ctx,
 					contextKeyPassword,
 					password,
 				),
@@ -0,0 +1,109 @@
+package credential
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"sync"
+	"time"
+)
+
+// sessionHandleLen is the length of the random part of a session handle.
+const sessionHandleLen = 32
+
+// Sessions are the vault sessions, the vault is unlocked with the
+// password once and the session handle is passed instead of it.
+//
+// The sessions are kept in memory only and expire once they are
+// not used for the idle duration.
+type Sessions struct {
+	idle time.Duration
+
+	mutex    sync.Mutex
+	sessions map[string]session
+}
+
+// session is a vault session bound to the authorization token.
+//
+// The key is the vault key of the password, the password
+// is kept only for the identities that have no vault key.
+type session struct {
+	token    string
+	password string
+	key      []byte
+	usedAt   time.Time
+}
+
+// NewSessions creates new Sessions that expire
+// after the idle duration and returns them.
+func NewSessions(idle time.Duration) *Sessions {
+	return &Sessions{
+		idle:     idle,
+		sessions: make(map[string]session),
+	}
+}
+
+// Open opens a session bound to the token and returns its handle.
+func (s *Sessions) Open(token, password string, key []byte) (string, error) {
+	handle := make([]byte, sessionHandleLen)
+	if _, err := rand.Read(handle); err != nil {
+		return "", err
+	}
+	if key != nil {
+		password = ""
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	now := time.Now()
+	for h, session := range s.sessions {
+		if s.expired(session, now) {
+			delete(s.sessions, h)
+		}
+	}
+	encodedHandle := base64.RawURLEncoding.EncodeToString(handle)
+	s.sessions[encodedHandle] = session{
+		token:    token,
+		password: password,
+		key:      key,
+		usedAt:   now,
+	}
+	return encodedHandle, nil
+}
+
+// Revoke revokes the session bound to the token,
+// it reports whether there was such a session.
+func (s *Sessions) Revoke(handle, token string) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	session, ok := s.sessions[handle]
+	if !ok || session.token != token {
+		return false
+	}
+	delete(s.sessions, handle)
+	return true
+}
+
+// use returns the session bound to the token and prolongs it.
+func (s *Sessions) use(handle, token string) (session, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	session, ok := s.sessions[handle]
+	if !ok || session.token != token {
+		return session, false
+	}
+	now := time.Now()
+	if s.expired(session, now) {
+		delete(s.sessions, handle)
+		return session, false
+	}
+	session.usedAt = now
+	s.sessions[handle] = session
+	return session, true
+}
+
+func (s *Sessions) expired(session session, now time.Time) bool {
+	return now.Sub(session.usedAt) > s.idle
+}
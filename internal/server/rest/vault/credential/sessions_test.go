@@ -0,0 +1,46 @@
+package credential
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSessions(t *testing.T) {
+	sessions := NewSessions(time.Hour)
+
+	handle, openError := sessions.Open("token", "qwerty", nil)
+	assert.Nil(t, openError, "did not expect an error")
+
+	session, ok := sessions.use(handle, "token")
+	assert.True(t, ok, "expected the session to be found")
+	assert.Equal(t, "qwerty", session.password, "unexpected password")
+
+	_, ok = sessions.use(handle, "other")
+	assert.False(t, ok, "expected the session to be bound to the token")
+
+	keyed, keyedError := sessions.Open("token", "qwerty", []byte("key"))
+	assert.Nil(t, keyedError, "did not expect an error")
+	session, ok = sessions.use(keyed, "token")
+	assert.True(t, ok, "expected the session to be found")
+	assert.Empty(t, session.password, "expected the password not to be kept along with the key")
+	assert.Equal(t, []byte("key"), session.key, "unexpected key")
+
+	assert.False(t, sessions.Revoke(handle, "other"), "expected the session to be bound to the token")
+	assert.True(t, sessions.Revoke(handle, "token"), "expected the session to be revoked")
+	_, ok = sessions.use(handle, "token")
+	assert.False(t, ok, "expected the session to be revoked")
+}
+
+func TestSessionsIdle(t *testing.T) {
+	sessions := NewSessions(time.Millisecond)
+
+	handle, openError := sessions.Open("token", "qwerty", nil)
+	assert.Nil(t, openError, "did not expect an error")
+
+	time.Sleep(10 * time.Millisecond)
+	_, ok := sessions.use(handle, "token")
+	assert.False(t, ok, "expected the session to expire")
+	assert.Empty(t, sessions.sessions, "expected the expired session to be dropped")
+}
@@ -3,57 +3,154 @@
 package vault
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"log"
 	"net/http"
+	"net/url"
 	"strconv"
+	"time"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/kerelape/gophkeeper/internal/server/rest/authentication"
 	"github.com/kerelape/gophkeeper/internal/server/rest/vault/blob"
+	"github.com/kerelape/gophkeeper/internal/server/rest/vault/credential"
+	"github.com/kerelape/gophkeeper/internal/server/rest/vault/folder"
 	"github.com/kerelape/gophkeeper/internal/server/rest/vault/piece"
+	"github.com/kerelape/gophkeeper/internal/server/rest/vault/trash"
+	"github.com/kerelape/gophkeeper/internal/server/rest/vault/unlock"
 	"github.com/kerelape/gophkeeper/pkg/gophkeeper"
 )
 
+// defaultSessionIdle is how long an unused vault session
+// lasts if the entry does not set it.
+const defaultSessionIdle = 5 * time.Minute
+
 // Entry is vault entry.
+//
+// If RequireEncryption is set, pieces and blobs
+// not encrypted by the client are refused.
+//
+// The vault sessions expire once they are not used for SessionIdle.
 type Entry struct {
-	Gophkeeper gophkeeper.Gophkeeper
+	Gophkeeper        gophkeeper.Gophkeeper
+	RequireEncryption bool
+	SessionIdle       time.Duration
 }
 
 // Route routes vault entry.
 func (e *Entry) Route() http.Handler {
+	idle := e.SessionIdle
+	if idle == 0 {
+		idle = defaultSessionIdle
+	}
 	var (
-		piece = piece.Entry{}
-		blob  = blob.Entry{}
+		sessions = credential.NewSessions(idle)
+		piece    = piece.Entry{
+			RequireEncryption: e.RequireEncryption,
+			Sessions:          sessions,
+		}
+		blob = blob.Entry{
+			RequireEncryption: e.RequireEncryption,
+			Sessions:          sessions,
+		}
+		unlock = unlock.Entry{
+			Sessions: sessions,
+		}
+		trash  = trash.Entry{}
+		folder = folder.Entry{}
 	)
 	router := chi.NewRouter()
 	router.Use(authentication.Middleware(e.Gophkeeper))
 	router.Mount("/piece", piece.Route())
 	router.Mount("/blob", blob.Route())
+	router.Mount("/trash", trash.Route())
+	router.Mount("/folders", folder.Route())
+	router.Mount("/unlock", unlock.Route())
 	router.Get("/", e.get)
+	router.With(sessions.Middleware).Get("/metas", e.metas)
+	router.Get("/search", e.search)
 	router.Delete("/{rid}", e.delete)
+	router.Get("/{rid}/versions", e.versions)
+	router.With(sessions.Middleware).Post("/{rid}/versions/{version}", e.revert)
+	router.Post("/{rid}/folder", e.move)
+	router.Put("/{rid}/tags", e.tag)
+	router.Delete("/{rid}/tags", e.untag)
 	return router
 }
 
 func (e *Entry) get(out http.ResponseWriter, in *http.Request) {
 	identity := authentication.Identity(in)
 
-	resources, resourcesError := identity.List(in.Context())
-	if resourcesError != nil {
+	options, optionsError := listOptions(in.URL.Query())
+	if optionsError != nil {
+		status := http.StatusBadRequest
+		http.Error(out, http.StatusText(status), status)
+		return
+	}
+
+	page, pageError := identity.List(in.Context(), options)
+	if pageError != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(pageError, gophkeeper.ErrUnavailable) {
+			status = http.StatusServiceUnavailable
+		}
+		if errors.Is(pageError, gophkeeper.ErrInvalidCursor) {
+			status = http.StatusBadRequest
+		}
+		http.Error(out, http.StatusText(status), status)
+		return
+	}
+
+	if page.Next != "" {
+		out.Header().Set("X-Next-Cursor", page.Next)
+	}
+	writeResources(out, page.Resources)
+}
+
+// search responds with the resources indexed by all of the tokens.
+func (e *Entry) search(out http.ResponseWriter, in *http.Request) {
+	identity := authentication.Identity(in)
+
+	tokens := in.URL.Query()["token"]
+	if len(tokens) == 0 {
+		status := http.StatusBadRequest
+		http.Error(out, http.StatusText(status), status)
+		return
+	}
+
+	resources, searchError := identity.Search(in.Context(), tokens)
+	if searchError != nil {
 		status := http.StatusInternalServerError
+		if errors.Is(searchError, gophkeeper.ErrUnavailable) {
+			status = http.StatusServiceUnavailable
+		}
 		http.Error(out, http.StatusText(status), status)
 		return
 	}
 
+	writeResources(out, resources)
+}
+
+// writeResources responds with the resources.
+func writeResources(out http.ResponseWriter, resources []gophkeeper.Resource) {
 	response := make([](map[string]any), 0, len(resources))
 	for _, resource := range resources {
 		response = append(
 			response,
 			map[string]any{
-				"rid":  (int64)(resource.ID),
-				"meta": resource.Meta,
-				"type": (int)(resource.Type),
+				"rid":         (int64)(resource.ID),
+				"meta":        resource.Meta,
+				"type":        (int)(resource.Type),
+				"folder":      (int64)(resource.Folder),
+				"tags":        resource.Tags,
+				"created_at":  resource.CreatedAt.Format(time.RFC3339Nano),
+				"updated_at":  resource.UpdatedAt.Format(time.RFC3339Nano),
+				"accessed_at": resource.AccessedAt.Format(time.RFC3339Nano),
+				"size":        resource.Size,
+				"hash":        resource.Hash,
 			},
 		)
 	}
@@ -64,11 +161,76 @@ func (e *Entry) get(out http.ResponseWriter, in *http.Request) {
 	}
 }
 
+// listOptions parses list options from the query parameters.
+func listOptions(query url.Values) (gophkeeper.ListOptions, error) {
+	options := gophkeeper.ListOptions{
+		Tag:        query.Get("tag"),
+		MetaPrefix: query.Get("prefix"),
+		Cursor:     query.Get("cursor"),
+	}
+	if query.Has("rid") {
+		rid, ridError := strconv.Atoi(query.Get("rid"))
+		if ridError != nil {
+			return gophkeeper.ListOptions{}, ridError
+		}
+		options.ID = new(gophkeeper.ResourceID)
+		*options.ID = (gophkeeper.ResourceID)(rid)
+	}
+	if query.Has("folder") {
+		folder, folderError := strconv.Atoi(query.Get("folder"))
+		if folderError != nil {
+			return gophkeeper.ListOptions{}, folderError
+		}
+		options.Folder = new(gophkeeper.FolderID)
+		*options.Folder = (gophkeeper.FolderID)(folder)
+	}
+	if query.Has("type") {
+		resourceType, resourceTypeError := strconv.Atoi(query.Get("type"))
+		if resourceTypeError != nil {
+			return gophkeeper.ListOptions{}, resourceTypeError
+		}
+		options.Type = new(gophkeeper.ResourceType)
+		*options.Type = (gophkeeper.ResourceType)(resourceType)
+	}
+	if query.Has("order") {
+		order, orderError := strconv.Atoi(query.Get("order"))
+		if orderError != nil {
+			return gophkeeper.ListOptions{}, orderError
+		}
+		switch options.Order = (gophkeeper.ListOrder)(order); options.Order {
+		case gophkeeper.ListOrderID, gophkeeper.ListOrderCreated, gophkeeper.ListOrderUpdated, gophkeeper.ListOrderSize:
+		default:
+			return gophkeeper.ListOptions{}, fmt.Errorf("unsupported order: %d", order)
+		}
+	}
+	if query.Has("desc") {
+		descending, descendingError := strconv.ParseBool(query.Get("desc"))
+		if descendingError != nil {
+			return gophkeeper.ListOptions{}, descendingError
+		}
+		options.Descending = descending
+	}
+	if query.Has("limit") {
+		limit, limitError := strconv.Atoi(query.Get("limit"))
+		if limitError != nil {
+			return gophkeeper.ListOptions{}, limitError
+		}
+		if limit < 0 {
+			return gophkeeper.ListOptions{}, fmt.Errorf("negative limit: %d", limit)
+		}
+		options.Limit = limit
+	}
+	return options, nil
+}
+
 func (e *Entry) delete(out http.ResponseWriter, in *http.Request) {
 	token := in.Header.Get("Authorization")
 	identity, identityError := e.Gophkeeper.Identity(in.Context(), (gophkeeper.Token)(token))
 	if identityError != nil {
 		status := http.StatusInternalServerError
+		if errors.Is(identityError, gophkeeper.ErrUnavailable) {
+			status = http.StatusServiceUnavailable
+		}
 		if errors.Is(identityError, gophkeeper.ErrBadCredential) {
 			status = http.StatusUnauthorized
 		}
@@ -85,6 +247,203 @@ func (e *Entry) delete(out http.ResponseWriter, in *http.Request) {
 
 	if err := identity.Delete(in.Context(), (gophkeeper.ResourceID)(rid)); err != nil {
 		status := http.StatusInternalServerError
+		if errors.Is(err, gophkeeper.ErrUnavailable) {
+			status = http.StatusServiceUnavailable
+		}
+		if errors.Is(err, gophkeeper.ErrResourceNotFound) {
+			status = http.StatusNotFound
+		}
+		http.Error(out, http.StatusText(status), status)
+		return
+	}
+
+	out.WriteHeader(http.StatusOK)
+}
+
+func (e *Entry) versions(out http.ResponseWriter, in *http.Request) {
+	identity := authentication.Identity(in)
+
+	rid, ridError := strconv.Atoi(chi.URLParam(in, "rid"))
+	if ridError != nil {
+		status := http.StatusBadRequest
+		http.Error(out, http.StatusText(status), status)
+		return
+	}
+
+	versions, versionsError := identity.ListVersions(in.Context(), (gophkeeper.ResourceID)(rid))
+	if versionsError != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(versionsError, gophkeeper.ErrUnavailable) {
+			status = http.StatusServiceUnavailable
+		}
+		if errors.Is(versionsError, gophkeeper.ErrResourceNotFound) {
+			status = http.StatusNotFound
+		}
+		http.Error(out, http.StatusText(status), status)
+		return
+	}
+
+	response := make([](map[string]any), 0, len(versions))
+	for _, version := range versions {
+		response = append(
+			response,
+			map[string]any{
+				"version":    version.Version,
+				"meta":       version.Meta,
+				"created_at": version.CreatedAt.Format(time.RFC3339Nano),
+			},
+		)
+	}
+
+	out.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(out).Encode(&response); err != nil {
+		log.Printf("failed to write response: %s\n", err.Error())
+	}
+}
+
+func (e *Entry) revert(out http.ResponseWriter, in *http.Request) {
+	identity := authentication.Identity(in)
+	password := credential.Password(in)
+
+	rid, ridError := strconv.Atoi(chi.URLParam(in, "rid"))
+	if ridError != nil {
+		status := http.StatusBadRequest
+		http.Error(out, http.StatusText(status), status)
+		return
+	}
+	version, versionError := strconv.Atoi(chi.URLParam(in, "version"))
+	if versionError != nil {
+		status := http.StatusBadRequest
+		http.Error(out, http.StatusText(status), status)
+		return
+	}
+
+	if err := identity.RestoreVersion(in.Context(), (gophkeeper.ResourceID)(rid), version, password); err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, gophkeeper.ErrUnavailable) {
+			status = http.StatusServiceUnavailable
+		}
+		if errors.Is(err, gophkeeper.ErrBadCredential) {
+			status = http.StatusUnauthorized
+		}
+		if errors.Is(err, gophkeeper.ErrResourceNotFound) {
+			status = http.StatusNotFound
+		}
+		http.Error(out, http.StatusText(status), status)
+		return
+	}
+
+	out.WriteHeader(http.StatusOK)
+}
+
+// metas responds with the meta of every stored state
+// of the resources, so that the client can re-encrypt it.
+func (e *Entry) metas(out http.ResponseWriter, in *http.Request) {
+	identity := authentication.Identity(in)
+	password := credential.Password(in)
+
+	reencrypter, ok := identity.(gophkeeper.Reencrypter)
+	if !ok {
+		status := http.StatusNotImplemented
+		http.Error(out, http.StatusText(status), status)
+		return
+	}
+
+	metas, metasError := reencrypter.Metas(in.Context(), password)
+	if metasError != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(metasError, gophkeeper.ErrUnavailable) {
+			status = http.StatusServiceUnavailable
+		}
+		if errors.Is(metasError, gophkeeper.ErrBadCredential) {
+			status = http.StatusUnauthorized
+		}
+		http.Error(out, http.StatusText(status), status)
+		return
+	}
+
+	out.Header().Set("Content-Type", "application/json")
+	out.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(out).Encode(metas); err != nil {
+		log.Printf("failed to write response: %s\n", err.Error())
+	}
+}
+
+func (e *Entry) move(out http.ResponseWriter, in *http.Request) {
+	identity := authentication.Identity(in)
+
+	rid, ridError := strconv.Atoi(chi.URLParam(in, "rid"))
+	if ridError != nil {
+		status := http.StatusBadRequest
+		http.Error(out, http.StatusText(status), status)
+		return
+	}
+
+	var request struct {
+		Folder int64 `json:"folder"`
+	}
+	if err := json.NewDecoder(in.Body).Decode(&request); err != nil {
+		status := http.StatusBadRequest
+		http.Error(out, http.StatusText(status), status)
+		return
+	}
+
+	if err := identity.MoveResource(
+		in.Context(),
+		(gophkeeper.ResourceID)(rid),
+		(gophkeeper.FolderID)(request.Folder),
+	); err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, gophkeeper.ErrUnavailable) {
+			status = http.StatusServiceUnavailable
+		}
+		if errors.Is(err, gophkeeper.ErrResourceNotFound) {
+			status = http.StatusNotFound
+		}
+		if errors.Is(err, gophkeeper.ErrFolderNotFound) {
+			status = http.StatusUnprocessableEntity
+		}
+		http.Error(out, http.StatusText(status), status)
+		return
+	}
+
+	out.WriteHeader(http.StatusOK)
+}
+
+func (e *Entry) tag(out http.ResponseWriter, in *http.Request) {
+	identity := authentication.Identity(in)
+	e.tags(out, in, func(ctx context.Context, rid gophkeeper.ResourceID, tag string) error {
+		return identity.Tag(ctx, rid, tag, in.URL.Query()["token"]...)
+	})
+}
+
+func (e *Entry) untag(out http.ResponseWriter, in *http.Request) {
+	e.tags(out, in, authentication.Identity(in).Untag)
+}
+
+func (e *Entry) tags(
+	out http.ResponseWriter,
+	in *http.Request,
+	apply func(context.Context, gophkeeper.ResourceID, string) error,
+) {
+	rid, ridError := strconv.Atoi(chi.URLParam(in, "rid"))
+	if ridError != nil {
+		status := http.StatusBadRequest
+		http.Error(out, http.StatusText(status), status)
+		return
+	}
+	tag := in.URL.Query().Get("tag")
+	if tag == "" {
+		status := http.StatusBadRequest
+		http.Error(out, http.StatusText(status), status)
+		return
+	}
+
+	if err := apply(in.Context(), (gophkeeper.ResourceID)(rid), tag); err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, gophkeeper.ErrUnavailable) {
+			status = http.StatusServiceUnavailable
+		}
 		if errors.Is(err, gophkeeper.ErrResourceNotFound) {
 			status = http.StatusNotFound
 		}
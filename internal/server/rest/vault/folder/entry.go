@@ -0,0 +1,140 @@
+// Package folder provides REST endpoints to create,
+// list and move folders.
+package folder
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/kerelape/gophkeeper/internal/server/rest/authentication"
+	"github.com/kerelape/gophkeeper/pkg/gophkeeper"
+)
+
+// Entry is folder entry.
+type Entry struct{}
+
+// Route routes folder entry.
+func (e *Entry) Route() http.Handler {
+	router := chi.NewRouter()
+	router.Get("/", e.list)
+	router.Put("/", e.create)
+	router.Post("/{folder}", e.move)
+	return router
+}
+
+func (e *Entry) list(out http.ResponseWriter, in *http.Request) {
+	identity := authentication.Identity(in)
+
+	folders, foldersError := identity.ListFolders(in.Context())
+	if foldersError != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(foldersError, gophkeeper.ErrUnavailable) {
+			status = http.StatusServiceUnavailable
+		}
+		http.Error(out, http.StatusText(status), status)
+		return
+	}
+
+	response := make([](map[string]any), 0, len(folders))
+	for _, folder := range folders {
+		response = append(
+			response,
+			map[string]any{
+				"id":     (int64)(folder.ID),
+				"parent": (int64)(folder.Parent),
+				"name":   folder.Name,
+			},
+		)
+	}
+
+	out.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(out).Encode(&response); err != nil {
+		log.Printf("failed to write response: %s\n", err.Error())
+	}
+}
+
+func (e *Entry) create(out http.ResponseWriter, in *http.Request) {
+	identity := authentication.Identity(in)
+
+	var request struct {
+		Name   string `json:"name"`
+		Parent int64  `json:"parent"`
+	}
+	if err := json.NewDecoder(in.Body).Decode(&request); err != nil {
+		status := http.StatusBadRequest
+		http.Error(out, http.StatusText(status), status)
+		return
+	}
+	if request.Name == "" {
+		status := http.StatusBadRequest
+		http.Error(out, http.StatusText(status), status)
+		return
+	}
+
+	id, createError := identity.CreateFolder(in.Context(), request.Name, (gophkeeper.FolderID)(request.Parent))
+	if createError != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(createError, gophkeeper.ErrUnavailable) {
+			status = http.StatusServiceUnavailable
+		}
+		if errors.Is(createError, gophkeeper.ErrFolderNotFound) {
+			status = http.StatusNotFound
+		}
+		http.Error(out, http.StatusText(status), status)
+		return
+	}
+
+	out.WriteHeader(http.StatusCreated)
+	var response struct {
+		ID int64 `json:"id"`
+	}
+	response.ID = (int64)(id)
+	if err := json.NewEncoder(out).Encode(&response); err != nil {
+		log.Printf("failed to write response: %s\n", err.Error())
+	}
+}
+
+func (e *Entry) move(out http.ResponseWriter, in *http.Request) {
+	identity := authentication.Identity(in)
+
+	folder, folderError := strconv.Atoi(chi.URLParam(in, "folder"))
+	if folderError != nil {
+		status := http.StatusBadRequest
+		http.Error(out, http.StatusText(status), status)
+		return
+	}
+
+	var request struct {
+		Parent int64 `json:"parent"`
+	}
+	if err := json.NewDecoder(in.Body).Decode(&request); err != nil {
+		status := http.StatusBadRequest
+		http.Error(out, http.StatusText(status), status)
+		return
+	}
+
+	if err := identity.MoveFolder(
+		in.Context(),
+		(gophkeeper.FolderID)(folder),
+		(gophkeeper.FolderID)(request.Parent),
+	); err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, gophkeeper.ErrUnavailable) {
+			status = http.StatusServiceUnavailable
+		}
+		if errors.Is(err, gophkeeper.ErrFolderNotFound) {
+			status = http.StatusNotFound
+		}
+		if errors.Is(err, gophkeeper.ErrFolderCycle) {
+			status = http.StatusConflict
+		}
+		http.Error(out, http.StatusText(status), status)
+		return
+	}
+
+	out.WriteHeader(http.StatusOK)
+}
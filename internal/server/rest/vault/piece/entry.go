@@ -15,17 +15,25 @@ import (
 	"github.com/kerelape/gophkeeper/internal/server/rest/authentication"
 	"github.com/kerelape/gophkeeper/internal/server/rest/vault/credential"
 	"github.com/kerelape/gophkeeper/pkg/gophkeeper"
+	"github.com/kerelape/gophkeeper/pkg/gophkeeper/encrypted"
 )
 
 // Entry is piece entry.
-type Entry struct{}
+//
+// If RequireEncryption is set, pieces with the meta
+// not wrapped into an envelope are refused.
+type Entry struct {
+	RequireEncryption bool
+	Sessions          *credential.Sessions
+}
 
 // Route routes piece entry.
 func (e *Entry) Route() http.Handler {
 	router := chi.NewRouter()
-	router.Use(credential.Middleware)
+	router.Use(e.Sessions.Middleware)
 	router.Put("/", e.encrypt)
 	router.Get("/{rid}", e.decrypt)
+	router.Post("/{rid}", e.update)
 	return router
 }
 
@@ -34,29 +42,39 @@ func (e *Entry) encrypt(out http.ResponseWriter, in *http.Request) {
 	password := credential.Password(in)
 
 	var request struct {
-		Meta    string `json:"meta"`
-		Content string `json:"content"`
+		Meta    string   `json:"meta"`
+		Content string   `json:"content"`
+		Index   []string `json:"index"`
 	}
 	if err := json.NewDecoder(in.Body).Decode(&request); err != nil {
 		status := http.StatusBadRequest
 		http.Error(out, http.StatusText(status), status)
 		return
 	}
-	content := make([]byte, len(request.Content))
-	if _, err := base64.RawStdEncoding.Decode(content, ([]byte)(request.Content)); err != nil {
+	content, contentError := base64.RawStdEncoding.DecodeString(request.Content)
+	if contentError != nil {
 		status := http.StatusBadRequest
 		http.Error(out, http.StatusText(status), status)
 		return
 	}
+	if e.RequireEncryption && !encrypted.IsEnvelope(request.Meta) {
+		status := http.StatusUnprocessableEntity
+		http.Error(out, http.StatusText(status), status)
+		return
+	}
 
 	piece := gophkeeper.Piece{
 		Meta:    request.Meta,
 		Content: content,
+		Index:   request.Index,
 	}
 
 	rid, storeError := identity.StorePiece(in.Context(), piece, password)
 	if storeError != nil {
 		status := http.StatusInternalServerError
+		if errors.Is(storeError, gophkeeper.ErrUnavailable) {
+			status = http.StatusServiceUnavailable
+		}
 		if errors.Is(storeError, gophkeeper.ErrBadCredential) {
 			status = http.StatusUnauthorized
 		}
@@ -88,6 +106,9 @@ func (e *Entry) decrypt(out http.ResponseWriter, in *http.Request) {
 	piece, restoreError := identity.RestorePiece(in.Context(), (gophkeeper.ResourceID)(rid), password)
 	if restoreError != nil {
 		status := http.StatusInternalServerError
+		if errors.Is(restoreError, gophkeeper.ErrUnavailable) {
+			status = http.StatusServiceUnavailable
+		}
 		if errors.Is(restoreError, gophkeeper.ErrBadCredential) {
 			status = http.StatusUnauthorized
 		}
@@ -103,15 +124,71 @@ func (e *Entry) decrypt(out http.ResponseWriter, in *http.Request) {
 		Content string `json:"content"`
 	}
 	response.Meta = piece.Meta
-	response.Content = base64.RawStdEncoding.EncodeToString(
-		bytes.ReplaceAll(
-			piece.Content,
-			[]byte{'\x00'},
-			[]byte{},
-		),
-	)
+	content := piece.Content
+	if !encrypted.IsEnvelope(piece.Meta) {
+		// Pieces used to be stored padded with zeros,
+		// ciphertext is not and must be kept as is.
+		content = bytes.ReplaceAll(content, []byte{'\x00'}, []byte{})
+	}
+	response.Content = base64.RawStdEncoding.EncodeToString(content)
 	out.WriteHeader(http.StatusOK)
 	if err := json.NewEncoder(out).Encode(response); err != nil {
 		log.Printf("Failed to write response: %s", err.Error())
 	}
 }
+
+func (e *Entry) update(out http.ResponseWriter, in *http.Request) {
+	identity := authentication.Identity(in)
+	password := credential.Password(in)
+
+	rid, ridError := strconv.Atoi(chi.URLParam(in, "rid"))
+	if ridError != nil {
+		status := http.StatusBadRequest
+		http.Error(out, http.StatusText(status), status)
+		return
+	}
+
+	var request struct {
+		Meta    string   `json:"meta"`
+		Content string   `json:"content"`
+		Index   []string `json:"index"`
+	}
+	if err := json.NewDecoder(in.Body).Decode(&request); err != nil {
+		status := http.StatusBadRequest
+		http.Error(out, http.StatusText(status), status)
+		return
+	}
+	content, contentError := base64.RawStdEncoding.DecodeString(request.Content)
+	if contentError != nil {
+		status := http.StatusBadRequest
+		http.Error(out, http.StatusText(status), status)
+		return
+	}
+	if e.RequireEncryption && !encrypted.IsEnvelope(request.Meta) {
+		status := http.StatusUnprocessableEntity
+		http.Error(out, http.StatusText(status), status)
+		return
+	}
+
+	piece := gophkeeper.Piece{
+		Meta:    request.Meta,
+		Content: content,
+		Index:   request.Index,
+	}
+	if err := identity.UpdatePiece(in.Context(), (gophkeeper.ResourceID)(rid), piece, password); err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, gophkeeper.ErrUnavailable) {
+			status = http.StatusServiceUnavailable
+		}
+		if errors.Is(err, gophkeeper.ErrBadCredential) {
+			status = http.StatusUnauthorized
+		}
+		if errors.Is(err, gophkeeper.ErrResourceNotFound) {
+			status = http.StatusNotFound
+		}
+		http.Error(out, http.StatusText(status), status)
+		return
+	}
+
+	out.WriteHeader(http.StatusOK)
+}
@@ -0,0 +1,117 @@
+// Package trash provides REST endpoints to inspect
+// the trash, restore resources from it and purge them.
+package trash
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/kerelape/gophkeeper/internal/server/rest/authentication"
+	"github.com/kerelape/gophkeeper/pkg/gophkeeper"
+)
+
+// Entry is trash entry.
+type Entry struct{}
+
+// Route routes trash entry.
+func (e *Entry) Route() http.Handler {
+	router := chi.NewRouter()
+	router.Get("/", e.list)
+	router.Post("/{rid}", e.undelete)
+	router.Delete("/{rid}", e.purge)
+	return router
+}
+
+func (e *Entry) list(out http.ResponseWriter, in *http.Request) {
+	identity := authentication.Identity(in)
+
+	resources, resourcesError := identity.ListTrash(in.Context())
+	if resourcesError != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(resourcesError, gophkeeper.ErrUnavailable) {
+			status = http.StatusServiceUnavailable
+		}
+		http.Error(out, http.StatusText(status), status)
+		return
+	}
+
+	response := make([](map[string]any), 0, len(resources))
+	for _, resource := range resources {
+		response = append(
+			response,
+			map[string]any{
+				"rid":         (int64)(resource.ID),
+				"meta":        resource.Meta,
+				"type":        (int)(resource.Type),
+				"folder":      (int64)(resource.Folder),
+				"tags":        resource.Tags,
+				"created_at":  resource.CreatedAt.Format(time.RFC3339Nano),
+				"updated_at":  resource.UpdatedAt.Format(time.RFC3339Nano),
+				"accessed_at": resource.AccessedAt.Format(time.RFC3339Nano),
+				"size":        resource.Size,
+				"hash":        resource.Hash,
+				"deleted_at":  resource.DeletedAt.Format(time.RFC3339Nano),
+			},
+		)
+	}
+
+	out.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(out).Encode(&response); err != nil {
+		log.Printf("failed to write response: %s\n", err.Error())
+	}
+}
+
+func (e *Entry) undelete(out http.ResponseWriter, in *http.Request) {
+	identity := authentication.Identity(in)
+
+	rid, ridError := strconv.Atoi(chi.URLParam(in, "rid"))
+	if ridError != nil {
+		status := http.StatusBadRequest
+		http.Error(out, http.StatusText(status), status)
+		return
+	}
+
+	if err := identity.Undelete(in.Context(), (gophkeeper.ResourceID)(rid)); err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, gophkeeper.ErrUnavailable) {
+			status = http.StatusServiceUnavailable
+		}
+		if errors.Is(err, gophkeeper.ErrResourceNotFound) {
+			status = http.StatusNotFound
+		}
+		http.Error(out, http.StatusText(status), status)
+		return
+	}
+
+	out.WriteHeader(http.StatusOK)
+}
+
+func (e *Entry) purge(out http.ResponseWriter, in *http.Request) {
+	identity := authentication.Identity(in)
+
+	rid, ridError := strconv.Atoi(chi.URLParam(in, "rid"))
+	if ridError != nil {
+		status := http.StatusBadRequest
+		http.Error(out, http.StatusText(status), status)
+		return
+	}
+
+	if err := identity.Purge(in.Context(), (gophkeeper.ResourceID)(rid)); err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, gophkeeper.ErrUnavailable) {
+			status = http.StatusServiceUnavailable
+		}
+		if errors.Is(err, gophkeeper.ErrResourceNotFound) {
+			status = http.StatusNotFound
+		}
+		http.Error(out, http.StatusText(status), status)
+		return
+	}
+
+	out.WriteHeader(http.StatusOK)
+}
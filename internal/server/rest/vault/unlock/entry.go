@@ -0,0 +1,89 @@
+// Package unlock provides REST endpoints to open and revoke
+// vault sessions, so that the vault password is verified once
+// instead of on every request.
+package unlock
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/kerelape/gophkeeper/internal/server"
+	"github.com/kerelape/gophkeeper/internal/server/rest/authentication"
+	"github.com/kerelape/gophkeeper/internal/server/rest/vault/credential"
+	"github.com/kerelape/gophkeeper/pkg/gophkeeper"
+)
+
+// Entry is unlock entry.
+type Entry struct {
+	Sessions *credential.Sessions
+}
+
+// Route routes unlock entry.
+func (e *Entry) Route() http.Handler {
+	router := chi.NewRouter()
+	router.Post("/", e.unlock)
+	router.Delete("/", e.lock)
+	return router
+}
+
+// unlock verifies the vault password and responds
+// with the handle of a new vault session.
+func (e *Entry) unlock(out http.ResponseWriter, in *http.Request) {
+	identity := authentication.Identity(in)
+
+	password := in.Header.Get("X-Password")
+	if password == "" {
+		status := http.StatusBadRequest
+		http.Error(out, http.StatusText(status), status)
+		return
+	}
+
+	unlocker, ok := identity.(server.VaultUnlocker)
+	if !ok {
+		status := http.StatusNotImplemented
+		http.Error(out, http.StatusText(status), status)
+		return
+	}
+	key, unlockError := unlocker.UnlockVault(in.Context(), password)
+	if unlockError != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(unlockError, gophkeeper.ErrUnavailable) {
+			status = http.StatusServiceUnavailable
+		}
+		if errors.Is(unlockError, gophkeeper.ErrBadCredential) {
+			status = http.StatusUnauthorized
+		}
+		http.Error(out, http.StatusText(status), status)
+		return
+	}
+
+	handle, openError := e.Sessions.Open(in.Header.Get("Authorization"), password, key)
+	if openError != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(openError, gophkeeper.ErrUnavailable) {
+			status = http.StatusServiceUnavailable
+		}
+		http.Error(out, http.StatusText(status), status)
+		return
+	}
+
+	out.Header().Set("X-Vault-Session", handle)
+	out.WriteHeader(http.StatusCreated)
+}
+
+// lock revokes the vault session.
+func (e *Entry) lock(out http.ResponseWriter, in *http.Request) {
+	handle := in.Header.Get("X-Vault-Session")
+	if handle == "" {
+		status := http.StatusBadRequest
+		http.Error(out, http.StatusText(status), status)
+		return
+	}
+	if !e.Sessions.Revoke(handle, in.Header.Get("Authorization")) {
+		status := http.StatusNotFound
+		http.Error(out, http.StatusText(status), status)
+		return
+	}
+	out.WriteHeader(http.StatusOK)
+}
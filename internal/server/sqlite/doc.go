@@ -0,0 +1,3 @@
+// Package sqlite provides gophkeeper implementation
+// with embedded sqlite database storage.
+package sqlite
@@ -0,0 +1,431 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	_ "embed"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/kerelape/gophkeeper/internal/blobstore"
+	"github.com/kerelape/gophkeeper/internal/deferred"
+	"github.com/kerelape/gophkeeper/internal/server"
+	"github.com/kerelape/gophkeeper/pkg/gophkeeper"
+	"github.com/pior/runnable"
+	"golang.org/x/crypto/bcrypt"
+	sqlite3 "modernc.org/sqlite"
+	sqlite3lib "modernc.org/sqlite/lib"
+)
+
+//go:embed init.sql
+var initQuery string
+
+// scheme is the scheme of the sqlite DSNs.
+const scheme = "sqlite://"
+
+// pragmas are the connection parameters of the database.
+//
+// Transactions take the write lock once they begin, so two of them never
+// wait for each other to upgrade, and the writers wait for the lock instead
+// of failing at once.
+const pragmas = "?_pragma=busy_timeout(10000)&_pragma=journal_mode(WAL)&_pragma=synchronous(NORMAL)&_txlock=immediate"
+
+type (
+	// Gophkeeper is a sqlite identity repository.
+	Gophkeeper struct {
+		passwordEncoding *base64.Encoding
+		path             string
+		blobStore        blobstore.BlobStore
+		versionsLimit    int
+		tokenSource      server.UsernameBasedTokenSource
+		compression      bool
+		deduplication    bool
+
+		database deferred.Deferred[*sql.DB]
+	}
+	option func(g *Gophkeeper)
+)
+
+// Path returns the path of the database file of the DSN,
+// false if the DSN is not a sqlite one, e.g. sqlite:///var/lib/gophkeeper.db.
+func Path(dsn string) (string, bool) {
+	if !strings.HasPrefix(dsn, scheme) {
+		return "", false
+	}
+	path := strings.TrimPrefix(dsn, scheme)
+	return path, path != ""
+}
+
+// New creates a new sqlite Gophkeeper stored
+// in the database file at the path and returns it.
+func New(path string, tokenSource server.UsernameBasedTokenSource, options ...option) *Gophkeeper {
+	g := &Gophkeeper{
+		passwordEncoding: base64.RawStdEncoding,
+		path:             path,
+		tokenSource:      tokenSource,
+		blobStore:        &blobstore.FS{Dir: "./blobs"},
+		versionsLimit:    10,
+	}
+	for _, o := range options {
+		o(g)
+	}
+	if g.deduplication {
+		g.blobStore = &blobstore.Dedup{
+			Store:      g.blobStore,
+			References: &References{database: &g.database},
+		}
+	}
+	return g
+}
+
+var (
+	_ gophkeeper.Gophkeeper = (*Gophkeeper)(nil)
+	_ runnable.Runnable     = (*Gophkeeper)(nil)
+	_ server.Trash          = (*Gophkeeper)(nil)
+	_ server.EnvelopeStore  = (*Gophkeeper)(nil)
+)
+
+// Register implements Repository.
+func (r *Gophkeeper) Register(ctx context.Context, credential gophkeeper.Credential) error {
+	database, databaseError := r.database.Get(ctx)
+	if databaseError != nil {
+		return databaseError
+	}
+
+	password, passwordError := bcrypt.GenerateFromPassword(
+		([]byte)(credential.Password),
+		bcrypt.DefaultCost,
+	)
+	if passwordError != nil {
+		return passwordError
+	}
+
+	vaultPassword := credential.VaultPassword
+	if vaultPassword == "" {
+		vaultPassword = credential.Password
+	}
+	vaultCheck, vaultCheckError := server.NewVaultCheck(vaultPassword)
+	if vaultCheckError != nil {
+		return vaultCheckError
+	}
+
+	_, insertError := database.ExecContext(
+		ctx,
+		`INSERT INTO identities(username, password, vault_check, vault_salt) VALUES(?, ?, ?, ?)`,
+		credential.Username,
+		r.passwordEncoding.EncodeToString(password),
+		vaultCheck,
+		credential.VaultSalt,
+	)
+	if insertError != nil {
+		if err := new(sqlite3.Error); errors.As(insertError, &err) && err.Code() == sqlite3lib.SQLITE_CONSTRAINT_PRIMARYKEY {
+			return gophkeeper.ErrIdentityDuplicate
+		}
+		return insertError
+	}
+
+	return nil
+}
+
+// Authenticate implements Repository.
+func (r *Gophkeeper) Authenticate(ctx context.Context, credential gophkeeper.Credential) (gophkeeper.Token, error) {
+	database, databaseError := r.database.Get(ctx)
+	if databaseError != nil {
+		return gophkeeper.InvalidToken, databaseError
+	}
+
+	identity := Identity{
+		Database:         database,
+		PasswordEncoding: r.passwordEncoding,
+		Username:         credential.Username,
+	}
+	if err := identity.comparePassword(ctx, credential.Password); err != nil {
+		return gophkeeper.InvalidToken, err
+	}
+
+	t, tError := r.tokenSource.Create(ctx, credential.Username)
+	if tError != nil {
+		return gophkeeper.InvalidToken, tError
+	}
+
+	return (gophkeeper.Token)(t), nil
+}
+
+// Identity implements Repository.
+func (r *Gophkeeper) Identity(ctx context.Context, token gophkeeper.Token) (gophkeeper.Identity, error) {
+	database, databaseError := r.database.Get(ctx)
+	if databaseError != nil {
+		return nil, databaseError
+	}
+
+	username, usernameError := r.tokenSource.Unwrap(ctx, token)
+	if usernameError != nil {
+		return nil, errors.Join(usernameError, gophkeeper.ErrBadCredential)
+	}
+
+	identity := &Identity{
+		Database:         database,
+		PasswordEncoding: r.passwordEncoding,
+		Username:         username,
+		Blobs:            r.blobStore,
+		VersionsLimit:    r.versionsLimit,
+		Compression:      r.compression,
+		Deduplication:    r.deduplication,
+	}
+	return identity, nil
+}
+
+// PurgeTrash implements server.Trash.
+func (r *Gophkeeper) PurgeTrash(ctx context.Context, before time.Time) error {
+	database, databaseError := r.database.Get(ctx)
+	if databaseError != nil {
+		return databaseError
+	}
+
+	selectResourcesResult, selectResourcesError := database.QueryContext(
+		ctx,
+		`SELECT id FROM resources WHERE deleted_at < ?`,
+		before.UnixMicro(),
+	)
+	if selectResourcesError != nil {
+		return selectResourcesError
+	}
+	expired := make([]gophkeeper.ResourceID, 0)
+	for selectResourcesResult.Next() {
+		var rid gophkeeper.ResourceID
+		if err := selectResourcesResult.Scan(&rid); err != nil {
+			selectResourcesResult.Close()
+			return err
+		}
+		expired = append(expired, rid)
+	}
+	selectResourcesResult.Close()
+	if err := selectResourcesResult.Err(); err != nil {
+		return err
+	}
+
+	for _, rid := range expired {
+		transaction, transactionError := database.BeginTx(ctx, nil)
+		if transactionError != nil {
+			return transactionError
+		}
+		dropped, purgeError := purgeResource(ctx, transaction, rid)
+		if purgeError != nil {
+			if err := transaction.Rollback(); err != nil {
+				return err
+			}
+			return purgeError
+		}
+		if err := transaction.Commit(); err != nil {
+			return err
+		}
+		removeBlobs(r.blobStore, dropped)
+	}
+
+	if dedup, ok := r.blobStore.(*blobstore.Dedup); ok {
+		return dedup.Collect(ctx)
+	}
+	return nil
+}
+
+// Envelopes implements server.EnvelopeStore.
+func (r *Gophkeeper) Envelopes(ctx context.Context, after server.Envelope, limit int) ([]server.Envelope, error) {
+	database, databaseError := r.database.Get(ctx)
+	if databaseError != nil {
+		return nil, databaseError
+	}
+
+	selectEnvelopesResult, selectEnvelopesError := database.QueryContext(
+		ctx,
+		`SELECT owner, resource, version, meta FROM (
+			SELECT owner, id AS resource, 0 AS version, meta FROM resources
+			UNION ALL
+			SELECT r.owner, v.resource, v.version, v.meta FROM resource_versions v
+			JOIN resources r ON r.id = v.resource
+		) WHERE (resource, version) > (?, ?)
+		ORDER BY resource, version LIMIT ?`,
+		(int64)(after.Resource), after.Version, limit,
+	)
+	if selectEnvelopesError != nil {
+		return nil, selectEnvelopesError
+	}
+	defer selectEnvelopesResult.Close()
+
+	envelopes := make([]server.Envelope, 0, limit)
+	for selectEnvelopesResult.Next() {
+		var envelope server.Envelope
+		if err := selectEnvelopesResult.Scan(
+			&envelope.Owner,
+			&envelope.Resource,
+			&envelope.Version,
+			&envelope.Meta,
+		); err != nil {
+			return nil, err
+		}
+		envelopes = append(envelopes, envelope)
+	}
+	return envelopes, selectEnvelopesResult.Err()
+}
+
+// RewriteEnvelopes implements server.EnvelopeStore.
+func (r *Gophkeeper) RewriteEnvelopes(ctx context.Context, rewrites []server.EnvelopeRewrite) (int, error) {
+	database, databaseError := r.database.Get(ctx)
+	if databaseError != nil {
+		return 0, databaseError
+	}
+
+	transaction, transactionError := database.BeginTx(ctx, nil)
+	if transactionError != nil {
+		return 0, transactionError
+	}
+	rewritten := 0
+	for _, rewrite := range rewrites {
+		var (
+			result       sql.Result
+			rewriteError error
+		)
+		if rewrite.Envelope.Version == 0 {
+			result, rewriteError = transaction.ExecContext(
+				ctx,
+				`UPDATE resources SET meta = ? WHERE id = ? AND meta = ?`,
+				rewrite.Meta, (int64)(rewrite.Envelope.Resource), rewrite.Envelope.Meta,
+			)
+		} else {
+			result, rewriteError = transaction.ExecContext(
+				ctx,
+				`UPDATE resource_versions SET meta = ? WHERE resource = ? AND version = ? AND meta = ?`,
+				rewrite.Meta, (int64)(rewrite.Envelope.Resource), rewrite.Envelope.Version, rewrite.Envelope.Meta,
+			)
+		}
+		if rewriteError != nil {
+			if err := transaction.Rollback(); err != nil {
+				return 0, err
+			}
+			return 0, rewriteError
+		}
+		affected, affectedError := result.RowsAffected()
+		if affectedError != nil {
+			if err := transaction.Rollback(); err != nil {
+				return 0, err
+			}
+			return 0, affectedError
+		}
+		rewritten += (int)(affected)
+	}
+	if err := transaction.Commit(); err != nil {
+		return 0, err
+	}
+	return rewritten, nil
+}
+
+// Run implements Runnable.
+func (r *Gophkeeper) Run(ctx context.Context) error {
+	database, openError := sql.Open("sqlite", r.path+pragmas)
+	if openError != nil {
+		return openError
+	}
+	defer database.Close()
+
+	_, initializeError := database.ExecContext(ctx, initQuery)
+	if initializeError != nil {
+		return initializeError
+	}
+	if err := addColumns(ctx, database); err != nil {
+		return err
+	}
+
+	r.database.Set(database)
+
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+// addedColumns are the columns added to the tables after they
+// were first created, the databases created before lack them.
+var addedColumns = []struct {
+	table, column, definition string
+}{
+	{table: "identities", column: "vault_salt", definition: "BLOB"},
+}
+
+// addColumns adds the added columns the tables lack.
+func addColumns(ctx context.Context, database *sql.DB) error {
+	for _, c := range addedColumns {
+		var count int
+		row := database.QueryRowContext(
+			ctx,
+			`SELECT COUNT(*) FROM pragma_table_info(?) WHERE name = ?`,
+			c.table, c.column,
+		)
+		if err := row.Scan(&count); err != nil {
+			return err
+		}
+		if count > 0 {
+			continue
+		}
+		if _, err := database.ExecContext(
+			ctx,
+			fmt.Sprintf(`ALTER TABLE %s ADD COLUMN %s %s`, c.table, c.column, c.definition),
+		); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WithBlobsDir sets blobs dir to the gophkeeper.
+func WithBlobsDir(dir string) option {
+	return WithBlobStore(&blobstore.FS{Dir: dir})
+}
+
+// WithBlobStore sets the store of the blob files to the gophkeeper.
+func WithBlobStore(store blobstore.BlobStore) option {
+	if store == nil {
+		panic("store must be not nil")
+	}
+	return func(g *Gophkeeper) {
+		g.blobStore = store
+	}
+}
+
+// WithDeduplication makes the gophkeeper keep every unique chunk
+// of the blob files once, the chunks nothing references are
+// deleted along with the trash. The blob files stored before
+// are moved to the chunks by MigrateBlobs.
+func WithDeduplication(deduplication bool) option {
+	return func(g *Gophkeeper) {
+		g.deduplication = deduplication
+	}
+}
+
+// WithVersionsLimit sets how many previous versions
+// of a resource the gophkeeper keeps.
+func WithVersionsLimit(limit int) option {
+	if limit < 0 {
+		panic("limit must be not negative")
+	}
+	return func(g *Gophkeeper) {
+		g.versionsLimit = limit
+	}
+}
+
+// WithCompression sets whether the blobs are compressed
+// before they are encrypted, see gophkeeper.Settings.
+func WithCompression(compression bool) option {
+	return func(g *Gophkeeper) {
+		g.compression = compression
+	}
+}
+
+// WithPasswordEnoding sets password encoding to the gophkeeper.
+func WithPasswordEncoding(encoding *base64.Encoding) option {
+	if encoding == nil {
+		panic("encoding must be not nil")
+	}
+	return func(g *Gophkeeper) {
+		g.passwordEncoding = encoding
+	}
+}
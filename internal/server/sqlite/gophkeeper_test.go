@@ -0,0 +1,288 @@
+package sqlite_test
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"io"
+	"os"
+	"path"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kerelape/gophkeeper/internal/blobstore"
+	"github.com/kerelape/gophkeeper/internal/server"
+	"github.com/kerelape/gophkeeper/internal/server/sqlite"
+	"github.com/kerelape/gophkeeper/pkg/gophkeeper"
+	"github.com/stretchr/testify/assert"
+)
+
+// newGophkeeper runs a new sqlite gophkeeper
+// in a temporary directory for the test.
+func newGophkeeper(t *testing.T) *sqlite.Gophkeeper {
+	t.Helper()
+	dir := t.TempDir()
+	g := sqlite.New(
+		path.Join(dir, "gophkeeper.db"),
+		server.NewJWTSource(([]byte)("secret"), time.Hour),
+		sqlite.WithBlobsDir(path.Join(dir, "blobs")),
+		sqlite.WithVersionsLimit(2),
+	)
+	runGophkeeper(t, g)
+	return g
+}
+
+// runGophkeeper runs the gophkeeper until the test ends.
+func runGophkeeper(t *testing.T, g *sqlite.Gophkeeper) {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- g.Run(ctx)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		if err := <-done; !errors.Is(err, context.Canceled) {
+			t.Errorf("unexpected error on run: %v", err)
+		}
+	})
+}
+
+// newIdentity registers the credential with the gophkeeper
+// and returns its identity.
+func newIdentity(t *testing.T, g *sqlite.Gophkeeper, credential gophkeeper.Credential) gophkeeper.Identity {
+	t.Helper()
+	if err := g.Register(context.Background(), credential); err != nil {
+		t.Fatalf("failed to register: %s", err.Error())
+	}
+	token, authenticateError := g.Authenticate(context.Background(), credential)
+	if authenticateError != nil {
+		t.Fatalf("failed to authenticate: %s", authenticateError.Error())
+	}
+	identity, identityError := g.Identity(context.Background(), token)
+	if identityError != nil {
+		t.Fatalf("failed to get the identity: %s", identityError.Error())
+	}
+	return identity
+}
+
+func TestPath(t *testing.T) {
+	path, ok := sqlite.Path("sqlite:///var/lib/gophkeeper.db")
+	assert.True(t, ok, "expected a sqlite DSN")
+	assert.Equal(t, "/var/lib/gophkeeper.db", path)
+
+	_, ok = sqlite.Path("postgres://localhost/gophkeeper")
+	assert.False(t, ok, "expected a postgres DSN not to be a sqlite one")
+
+	_, ok = sqlite.Path("sqlite://")
+	assert.False(t, ok, "expected a DSN with no path to be rejected")
+}
+
+func TestGophkeeper(t *testing.T) {
+	var (
+		g          = newGophkeeper(t)
+		credential = gophkeeper.Credential{
+			Username: "test",
+			Password: "qwerty",
+		}
+	)
+	identity := newIdentity(t, g, credential)
+	_, storeError := identity.StorePiece(context.Background(), gophkeeper.Piece{}, credential.Password)
+	assert.Nil(t, storeError, "expected the password to unlock the vault")
+
+	reregisterError := g.Register(context.Background(), credential)
+	assert.ErrorIs(t, reregisterError, gophkeeper.ErrIdentityDuplicate, "unexpected error on reregister")
+
+	_, wrongPasswordError := g.Authenticate(
+		context.Background(),
+		gophkeeper.Credential{Username: credential.Username, Password: "wrong"},
+	)
+	assert.ErrorIs(t, wrongPasswordError, gophkeeper.ErrBadCredential, "unexpected error on wrong password")
+
+	_, unknownError := g.Authenticate(
+		context.Background(),
+		gophkeeper.Credential{Username: "unknown", Password: "qwerty"},
+	)
+	assert.ErrorIs(t, unknownError, gophkeeper.ErrBadCredential, "unexpected error on unknown username")
+
+	_, tokenError := g.Identity(context.Background(), "invalid")
+	assert.ErrorIs(t, tokenError, gophkeeper.ErrBadCredential, "unexpected error on invalid token")
+}
+
+func TestVaultSalt(t *testing.T) {
+	// The database is created before the vault salts.
+	dir := t.TempDir()
+	database, openError := sql.Open("sqlite", path.Join(dir, "gophkeeper.db"))
+	if openError != nil {
+		t.Fatalf("failed to open the database: %s", openError.Error())
+	}
+	_, createError := database.Exec(
+		`CREATE TABLE identities(
+			username TEXT PRIMARY KEY,
+			password TEXT NOT NULL,
+			vault_check BLOB,
+			key_file INTEGER NOT NULL DEFAULT 0
+		)`,
+	)
+	assert.Nil(t, createError, "expected to create the identities")
+	assert.Nil(t, database.Close(), "expected to close the database")
+
+	g := sqlite.New(
+		path.Join(dir, "gophkeeper.db"),
+		server.NewJWTSource(([]byte)("secret"), time.Hour),
+		sqlite.WithBlobsDir(path.Join(dir, "blobs")),
+	)
+	runGophkeeper(t, g)
+	identity := newIdentity(
+		t, g,
+		gophkeeper.Credential{Username: "test", Password: "qwerty", VaultSalt: ([]byte)("salt")},
+	)
+	settings, settingsError := identity.Settings(context.Background())
+	assert.Nil(t, settingsError, "expected to get the settings")
+	assert.Equal(t, ([]byte)("salt"), settings.VaultSalt, "expected the vault salt to be kept")
+
+	updateError := identity.UpdateSettings(context.Background(), gophkeeper.Settings{KeyFile: true})
+	assert.Nil(t, updateError, "expected to update the settings")
+	settings, settingsError = identity.Settings(context.Background())
+	assert.Nil(t, settingsError, "expected to get the settings")
+	assert.Equal(t, ([]byte)("salt"), settings.VaultSalt, "expected the vault salt not to be updated")
+}
+
+func TestPurgeTrash(t *testing.T) {
+	var (
+		g          = newGophkeeper(t)
+		credential = gophkeeper.Credential{
+			Username: "test",
+			Password: "qwerty",
+		}
+	)
+	identity := newIdentity(t, g, credential)
+	rid, storeError := identity.StorePiece(context.Background(), gophkeeper.Piece{Meta: "meta"}, credential.Password)
+	assert.Nil(t, storeError, "expected to successfully store a piece")
+	assert.Nil(t, identity.Delete(context.Background(), rid), "expected to successfully delete the piece")
+
+	assert.Nil(t, g.PurgeTrash(context.Background(), time.Now().Add(-time.Hour)))
+	trash, trashError := identity.ListTrash(context.Background())
+	assert.Nil(t, trashError, "expected to successfully list the trash")
+	assert.Len(t, trash, 1, "expected a recently deleted piece to be kept")
+
+	assert.Nil(t, g.PurgeTrash(context.Background(), time.Now().Add(time.Hour)))
+	trash, trashError = identity.ListTrash(context.Background())
+	assert.Nil(t, trashError, "expected to successfully list the trash")
+	assert.Empty(t, trash, "expected an expired piece to be purged")
+}
+
+func TestDeduplication(t *testing.T) {
+	var (
+		dir = t.TempDir()
+		g   = sqlite.New(
+			path.Join(dir, "gophkeeper.db"),
+			server.NewJWTSource(([]byte)("secret"), time.Hour),
+			sqlite.WithBlobsDir(path.Join(dir, "blobs")),
+			sqlite.WithDeduplication(true),
+		)
+		credential = gophkeeper.Credential{
+			Username: "test",
+			Password: "qwerty",
+		}
+	)
+	runGophkeeper(t, g)
+	identity := newIdentity(t, g, credential)
+
+	rids := make([]gophkeeper.ResourceID, 0, 2)
+	for i := 0; i < 2; i++ {
+		rid, storeError := identity.StoreBlob(
+			context.Background(),
+			gophkeeper.Blob{
+				Meta:    "meta",
+				Content: io.NopCloser(strings.NewReader("content")),
+			},
+			credential.Password,
+		)
+		assert.Nil(t, storeError, "expected to successfully store a blob")
+		assert.Equal(t, "content", restoreBlob(t, identity, rid, credential.Password))
+		rids = append(rids, rid)
+	}
+	chunks, chunksError := os.ReadDir(path.Join(dir, "blobs", blobstore.ChunksDir))
+	assert.Nil(t, chunksError, "expected the chunks to be stored")
+	assert.Len(t, chunks, 1, "expected the same content to be stored once")
+
+	assert.Nil(t, identity.Delete(context.Background(), rids[0]), "expected to successfully delete the blob")
+	assert.Nil(t, g.PurgeTrash(context.Background(), time.Now().Add(time.Hour)))
+	assert.Equal(t, "content", restoreBlob(t, identity, rids[1], credential.Password))
+
+	assert.Nil(t, identity.Delete(context.Background(), rids[1]), "expected to successfully delete the blob")
+	assert.Nil(t, g.PurgeTrash(context.Background(), time.Now().Add(time.Hour)))
+	chunks, chunksError = os.ReadDir(path.Join(dir, "blobs", blobstore.ChunksDir))
+	assert.Nil(t, chunksError, "expected to read the chunks")
+	assert.Empty(t, chunks, "expected the chunks nothing references to be deleted")
+}
+
+func TestMigrateBlobs(t *testing.T) {
+	var (
+		dir        = t.TempDir()
+		credential = gophkeeper.Credential{
+			Username: "test",
+			Password: "qwerty",
+		}
+		gophkeeperOf = func(deduplication bool) *sqlite.Gophkeeper {
+			g := sqlite.New(
+				path.Join(dir, "gophkeeper.db"),
+				server.NewJWTSource(([]byte)("secret"), time.Hour),
+				sqlite.WithBlobsDir(path.Join(dir, "blobs")),
+				sqlite.WithDeduplication(deduplication),
+			)
+			runGophkeeper(t, g)
+			return g
+		}
+		identityOf = func(g *sqlite.Gophkeeper) gophkeeper.Identity {
+			token, authenticateError := g.Authenticate(context.Background(), credential)
+			assert.Nil(t, authenticateError, "expected to successfully authenticate")
+			identity, identityError := g.Identity(context.Background(), token)
+			assert.Nil(t, identityError, "expected to successfully get the identity")
+			return identity
+		}
+	)
+
+	stored := gophkeeperOf(false)
+	identity := newIdentity(t, stored, credential)
+	rids := make([]gophkeeper.ResourceID, 0, 2)
+	for i := 0; i < 2; i++ {
+		rid, storeError := identity.StoreBlob(
+			context.Background(),
+			gophkeeper.Blob{
+				Meta:    "meta",
+				Content: io.NopCloser(strings.NewReader("content")),
+			},
+			credential.Password,
+		)
+		assert.Nil(t, storeError, "expected to successfully store a blob")
+		rids = append(rids, rid)
+	}
+
+	deduplicated := gophkeeperOf(true)
+	migrated, migrateError := deduplicated.MigrateBlobs(context.Background(), 1)
+	assert.Nil(t, migrateError, "expected to move the blobs to the chunks")
+	assert.Equal(t, 2, migrated)
+	chunks, chunksError := os.ReadDir(path.Join(dir, "blobs", blobstore.ChunksDir))
+	assert.Nil(t, chunksError, "expected the chunks to be stored")
+	assert.Len(t, chunks, 1, "expected the same content to be stored once")
+	migrated, migrateError = deduplicated.MigrateBlobs(context.Background(), 1)
+	assert.Nil(t, migrateError, "did not expect an error")
+	assert.Equal(t, 0, migrated, "expected the blobs to be moved once")
+	for _, rid := range rids {
+		assert.Equal(t, "content", restoreBlob(t, identityOf(deduplicated), rid, credential.Password))
+	}
+
+	joined := gophkeeperOf(false)
+	migrated, migrateError = joined.MigrateBlobs(context.Background(), 10)
+	assert.Nil(t, migrateError, "expected to move the blobs back from the chunks")
+	assert.Equal(t, 2, migrated)
+	chunks, chunksError = os.ReadDir(path.Join(dir, "blobs", blobstore.ChunksDir))
+	assert.Nil(t, chunksError, "expected to read the chunks")
+	assert.Empty(t, chunks, "expected the chunks to be deleted")
+	for _, rid := range rids {
+		assert.Equal(t, "content", restoreBlob(t, identityOf(joined), rid, credential.Password))
+	}
+}
@@ -0,0 +1,1343 @@
+package sqlite
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/kerelape/gophkeeper/internal/blobstore"
+	"github.com/kerelape/gophkeeper/internal/cursor"
+	"github.com/kerelape/gophkeeper/internal/server"
+	"github.com/kerelape/gophkeeper/pkg/gophkeeper"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Identity is a sqlite identity.
+//
+// The times are stored as unix microseconds and the
+// tokens as JSON arrays.
+type Identity struct {
+	Database         *sql.DB
+	PasswordEncoding *base64.Encoding
+	Blobs            blobstore.BlobStore
+	VersionsLimit    int
+	Compression      bool
+	Deduplication    bool
+
+	Username string
+}
+
+var (
+	_ gophkeeper.Identity  = (*Identity)(nil)
+	_ server.VaultUnlocker = (*Identity)(nil)
+)
+
+// StorePiece implements Identity.
+func (i *Identity) StorePiece(ctx context.Context, piece gophkeeper.Piece, password string) (gophkeeper.ResourceID, error) {
+	vaultCheck, passwordError := i.verifyVaultPassword(ctx, password)
+	if passwordError != nil {
+		return -1, errors.Join(passwordError, gophkeeper.ErrBadCredential)
+	}
+
+	transaction, transactionError := i.Database.BeginTx(ctx, nil)
+	if transactionError != nil {
+		return -1, transactionError
+	}
+
+	if err := i.lockVaultPassword(ctx, transaction, vaultCheck); err != nil {
+		if err := transaction.Rollback(); err != nil {
+			return -1, err
+		}
+		return -1, err
+	}
+
+	hash := sha256.Sum256(piece.Content)
+	insertPieceResult := transaction.QueryRowContext(
+		ctx,
+		`INSERT INTO pieces(content, size, hash) VALUES(?, ?, ?) RETURNING id`,
+		piece.Content, len(piece.Content), hash[:],
+	)
+	var id int64
+	if err := insertPieceResult.Scan(&id); err != nil {
+		if err := transaction.Rollback(); err != nil {
+			return -1, err
+		}
+		return -1, err
+	}
+	rid, insertError := i.insertResource(ctx, transaction, gophkeeper.ResourceTypePiece, id, piece.Meta, piece.Index)
+	if insertError != nil {
+		if err := transaction.Rollback(); err != nil {
+			return -1, err
+		}
+		return -1, insertError
+	}
+	if err := transaction.Commit(); err != nil {
+		return -1, err
+	}
+
+	return rid, nil
+}
+
+// RestorePiece implements Identity.
+func (i *Identity) RestorePiece(ctx context.Context, rid gophkeeper.ResourceID, password string) (gophkeeper.Piece, error) {
+	if err := i.compareVaultPassword(ctx, password); err != nil {
+		return gophkeeper.Piece{}, errors.Join(err, gophkeeper.ErrBadCredential)
+	}
+
+	meta, id, accessError := i.accessResource(ctx, rid, gophkeeper.ResourceTypePiece)
+	if accessError != nil {
+		return gophkeeper.Piece{}, accessError
+	}
+	var content []byte
+	selectPieceResult := i.Database.QueryRowContext(
+		ctx,
+		`SELECT content FROM pieces WHERE id = ?`,
+		id,
+	)
+	if err := selectPieceResult.Scan(&content); err != nil {
+		return gophkeeper.Piece{}, err
+	}
+
+	piece := gophkeeper.Piece{
+		Meta:    meta,
+		Content: content,
+	}
+	return piece, nil
+}
+
+// StoreBlob implements Identity.
+func (i *Identity) StoreBlob(ctx context.Context, blob gophkeeper.Blob, password string) (gophkeeper.ResourceID, error) {
+	defer blob.Content.Close()
+	vaultCheck, passwordError := i.verifyVaultPassword(ctx, password)
+	if passwordError != nil {
+		return -1, errors.Join(passwordError, gophkeeper.ErrBadCredential)
+	}
+
+	location, size, hash, writeError := i.writeBlob(ctx, blob.Content)
+	if writeError != nil {
+		log.Printf("failed to write file: %s\n", writeError.Error())
+		return -1, writeError
+	}
+
+	transaction, transactionError := i.Database.BeginTx(ctx, nil)
+	if transactionError != nil {
+		removeBlobs(i.Blobs, []string{location})
+		return -1, transactionError
+	}
+
+	if err := i.lockVaultPassword(ctx, transaction, vaultCheck); err != nil {
+		removeBlobs(i.Blobs, []string{location})
+		if err := transaction.Rollback(); err != nil {
+			return -1, err
+		}
+		return -1, err
+	}
+
+	insertBlobResult := transaction.QueryRowContext(
+		ctx,
+		`INSERT INTO blobs(location, size, hash) VALUES(?, ?, ?) RETURNING id`,
+		location, size, hash,
+	)
+	var blobID int64
+	if err := insertBlobResult.Scan(&blobID); err != nil {
+		removeBlobs(i.Blobs, []string{location})
+		if err := transaction.Rollback(); err != nil {
+			return -1, err
+		}
+		return -1, err
+	}
+	rid, insertError := i.insertResource(ctx, transaction, gophkeeper.ResourceTypeBlob, blobID, blob.Meta, blob.Index)
+	if insertError != nil {
+		removeBlobs(i.Blobs, []string{location})
+		if err := transaction.Rollback(); err != nil {
+			return -1, err
+		}
+		return -1, insertError
+	}
+
+	if err := transaction.Commit(); err != nil {
+		removeBlobs(i.Blobs, []string{location})
+		return -1, err
+	}
+
+	return rid, nil
+}
+
+// RestoreBlob implements Identity.
+func (i *Identity) RestoreBlob(ctx context.Context, rid gophkeeper.ResourceID, password string) (gophkeeper.Blob, error) {
+	if err := i.compareVaultPassword(ctx, password); err != nil {
+		return gophkeeper.Blob{}, errors.Join(err, gophkeeper.ErrBadCredential)
+	}
+
+	meta, blobID, accessError := i.accessResource(ctx, rid, gophkeeper.ResourceTypeBlob)
+	if accessError != nil {
+		return gophkeeper.Blob{}, accessError
+	}
+	var location string
+	selectBlobResult := i.Database.QueryRowContext(
+		ctx,
+		`SELECT location FROM blobs WHERE id = ?`,
+		blobID,
+	)
+	if err := selectBlobResult.Scan(&location); err != nil {
+		return gophkeeper.Blob{}, err
+	}
+
+	content, contentError := blobstore.Open(ctx, i.Blobs, location)
+	if contentError != nil {
+		return gophkeeper.Blob{}, contentError
+	}
+
+	blob := gophkeeper.Blob{
+		Meta:    meta,
+		Content: content,
+	}
+	return blob, nil
+}
+
+// UpdatePiece implements Identity.
+func (i *Identity) UpdatePiece(ctx context.Context, rid gophkeeper.ResourceID, piece gophkeeper.Piece, password string) error {
+	vaultCheck, passwordError := i.verifyVaultPassword(ctx, password)
+	if passwordError != nil {
+		return errors.Join(passwordError, gophkeeper.ErrBadCredential)
+	}
+
+	transaction, transactionError := i.Database.BeginTx(ctx, nil)
+	if transactionError != nil {
+		return transactionError
+	}
+
+	if err := i.lockVaultPassword(ctx, transaction, vaultCheck); err != nil {
+		if err := transaction.Rollback(); err != nil {
+			return err
+		}
+		return err
+	}
+
+	id, dropped, pushError := i.updateResource(ctx, transaction, rid, gophkeeper.ResourceTypePiece, piece.Meta, piece.Index)
+	if pushError != nil {
+		if err := transaction.Rollback(); err != nil {
+			return err
+		}
+		return pushError
+	}
+
+	hash := sha256.Sum256(piece.Content)
+	if _, err := transaction.ExecContext(
+		ctx,
+		`UPDATE pieces SET content = ?, size = ?, hash = ? WHERE id = ?`,
+		piece.Content, len(piece.Content), hash[:], id,
+	); err != nil {
+		if err := transaction.Rollback(); err != nil {
+			return err
+		}
+		return err
+	}
+
+	if err := transaction.Commit(); err != nil {
+		return err
+	}
+
+	removeBlobs(i.Blobs, dropped)
+	return nil
+}
+
+// UpdateBlob implements Identity.
+func (i *Identity) UpdateBlob(ctx context.Context, rid gophkeeper.ResourceID, blob gophkeeper.Blob, password string) error {
+	defer blob.Content.Close()
+	vaultCheck, passwordError := i.verifyVaultPassword(ctx, password)
+	if passwordError != nil {
+		return errors.Join(passwordError, gophkeeper.ErrBadCredential)
+	}
+
+	location, size, hash, writeError := i.writeBlob(ctx, blob.Content)
+	if writeError != nil {
+		log.Printf("failed to write file: %s\n", writeError.Error())
+		return writeError
+	}
+
+	transaction, transactionError := i.Database.BeginTx(ctx, nil)
+	if transactionError != nil {
+		removeBlobs(i.Blobs, []string{location})
+		return transactionError
+	}
+
+	if err := i.lockVaultPassword(ctx, transaction, vaultCheck); err != nil {
+		removeBlobs(i.Blobs, []string{location})
+		if err := transaction.Rollback(); err != nil {
+			return err
+		}
+		return err
+	}
+
+	blobID, dropped, pushError := i.updateResource(ctx, transaction, rid, gophkeeper.ResourceTypeBlob, blob.Meta, blob.Index)
+	if pushError != nil {
+		removeBlobs(i.Blobs, []string{location})
+		if err := transaction.Rollback(); err != nil {
+			return err
+		}
+		return pushError
+	}
+
+	if _, err := transaction.ExecContext(
+		ctx,
+		`UPDATE blobs SET location = ?, size = ?, hash = ? WHERE id = ?`,
+		location, size, hash, blobID,
+	); err != nil {
+		removeBlobs(i.Blobs, []string{location})
+		if err := transaction.Rollback(); err != nil {
+			return err
+		}
+		return err
+	}
+
+	if err := transaction.Commit(); err != nil {
+		removeBlobs(i.Blobs, []string{location})
+		return err
+	}
+
+	removeBlobs(i.Blobs, dropped)
+	return nil
+}
+
+// ListVersions implements Identity.
+func (i *Identity) ListVersions(ctx context.Context, rid gophkeeper.ResourceID) ([]gophkeeper.ResourceVersion, error) {
+	if err := i.checkResource(ctx, rid); err != nil {
+		return nil, err
+	}
+
+	selectVersionsResult, selectVersionsError := i.Database.QueryContext(
+		ctx,
+		`SELECT version, meta, created_at FROM resource_versions WHERE resource = ? ORDER BY version DESC`,
+		(int64)(rid),
+	)
+	if selectVersionsError != nil {
+		return nil, selectVersionsError
+	}
+	defer selectVersionsResult.Close()
+
+	versions := make([]gophkeeper.ResourceVersion, 0)
+	for selectVersionsResult.Next() {
+		var (
+			version   gophkeeper.ResourceVersion
+			createdAt int64
+		)
+		if err := selectVersionsResult.Scan(&version.Version, &version.Meta, &createdAt); err != nil {
+			return nil, err
+		}
+		version.CreatedAt = time.UnixMicro(createdAt)
+		versions = append(versions, version)
+	}
+	if err := selectVersionsResult.Err(); err != nil {
+		return nil, err
+	}
+	return versions, nil
+}
+
+// RestoreVersion implements Identity.
+func (i *Identity) RestoreVersion(ctx context.Context, rid gophkeeper.ResourceID, version int, password string) error {
+	if err := i.compareVaultPassword(ctx, password); err != nil {
+		return errors.Join(err, gophkeeper.ErrBadCredential)
+	}
+
+	transaction, transactionError := i.Database.BeginTx(ctx, nil)
+	if transactionError != nil {
+		return transactionError
+	}
+
+	selectResourceResult := transaction.QueryRowContext(
+		ctx,
+		`SELECT type FROM resources WHERE id = ? AND owner = ? AND deleted_at IS NULL`,
+		(int64)(rid), i.Username,
+	)
+	var resourceType int
+	if err := selectResourceResult.Scan(&resourceType); err != nil {
+		if err := transaction.Rollback(); err != nil {
+			return err
+		}
+		if errors.Is(err, sql.ErrNoRows) {
+			return gophkeeper.ErrResourceNotFound
+		}
+		return err
+	}
+
+	deleteVersionResult := transaction.QueryRowContext(
+		ctx,
+		`DELETE FROM resource_versions WHERE resource = ? AND version = ? RETURNING meta, tokens, content, location, size, hash`,
+		(int64)(rid), version,
+	)
+	var (
+		meta     string
+		tokens   *string
+		content  []byte
+		location *string
+		size     *int64
+		hash     []byte
+	)
+	if err := deleteVersionResult.Scan(&meta, &tokens, &content, &location, &size, &hash); err != nil {
+		if err := transaction.Rollback(); err != nil {
+			return err
+		}
+		if errors.Is(err, sql.ErrNoRows) {
+			return gophkeeper.ErrResourceNotFound
+		}
+		return err
+	}
+	index, indexError := decodeTokens(tokens)
+	if indexError != nil {
+		if err := transaction.Rollback(); err != nil {
+			return err
+		}
+		return indexError
+	}
+
+	resourceID, dropped, pushError := i.updateResource(ctx, transaction, rid, (gophkeeper.ResourceType)(resourceType), meta, index)
+	if pushError != nil {
+		if err := transaction.Rollback(); err != nil {
+			return err
+		}
+		return pushError
+	}
+
+	var restoreError error
+	switch (gophkeeper.ResourceType)(resourceType) {
+	case gophkeeper.ResourceTypePiece:
+		_, restoreError = transaction.ExecContext(
+			ctx,
+			`UPDATE pieces SET content = ?, size = ?, hash = ? WHERE id = ?`,
+			content, size, hash, resourceID,
+		)
+	case gophkeeper.ResourceTypeBlob:
+		_, restoreError = transaction.ExecContext(
+			ctx,
+			`UPDATE blobs SET location = ?, size = ?, hash = ? WHERE id = ?`,
+			location, size, hash, resourceID,
+		)
+	default:
+		restoreError = errors.New("unknown resource type")
+	}
+	if restoreError != nil {
+		if err := transaction.Rollback(); err != nil {
+			return err
+		}
+		return restoreError
+	}
+
+	if err := transaction.Commit(); err != nil {
+		return err
+	}
+
+	removeBlobs(i.Blobs, dropped)
+	return nil
+}
+
+// Delete implements Identity.
+func (i *Identity) Delete(ctx context.Context, rid gophkeeper.ResourceID) error {
+	result, updateError := i.Database.ExecContext(
+		ctx,
+		`UPDATE resources SET deleted_at = ? WHERE id = ? AND owner = ? AND deleted_at IS NULL`,
+		time.Now().UnixMicro(), (int64)(rid), i.Username,
+	)
+	return affectedResource(result, updateError)
+}
+
+// ListTrash implements Identity.
+func (i *Identity) ListTrash(ctx context.Context) ([]gophkeeper.TrashedResource, error) {
+	selectResourcesResult, selectResourcesError := i.Database.QueryContext(
+		ctx,
+		selectResources+` WHERE r.owner = ?1 AND r.deleted_at IS NOT NULL ORDER BY r.id`,
+		i.Username, (int)(gophkeeper.ResourceTypePiece), (int)(gophkeeper.ResourceTypeBlob),
+	)
+	if selectResourcesError != nil {
+		return nil, selectResourcesError
+	}
+	defer selectResourcesResult.Close()
+	resources := make([]gophkeeper.TrashedResource, 0)
+	for selectResourcesResult.Next() {
+		resource, scanError := scanResource(selectResourcesResult)
+		if scanError != nil {
+			return nil, scanError
+		}
+		resources = append(resources, resource)
+	}
+	if err := selectResourcesResult.Err(); err != nil {
+		return nil, err
+	}
+	return resources, nil
+}
+
+// Undelete implements Identity.
+func (i *Identity) Undelete(ctx context.Context, rid gophkeeper.ResourceID) error {
+	result, updateError := i.Database.ExecContext(
+		ctx,
+		`UPDATE resources SET deleted_at = NULL WHERE id = ? AND owner = ? AND deleted_at IS NOT NULL`,
+		(int64)(rid), i.Username,
+	)
+	return affectedResource(result, updateError)
+}
+
+// Purge implements Identity.
+func (i *Identity) Purge(ctx context.Context, rid gophkeeper.ResourceID) error {
+	transaction, transactionError := i.Database.BeginTx(ctx, nil)
+	if transactionError != nil {
+		return transactionError
+	}
+
+	selectResourceResult := transaction.QueryRowContext(
+		ctx,
+		`SELECT id FROM resources WHERE id = ? AND owner = ? AND deleted_at IS NOT NULL`,
+		(int64)(rid), i.Username,
+	)
+	var id int64
+	if err := selectResourceResult.Scan(&id); err != nil {
+		if err := transaction.Rollback(); err != nil {
+			return err
+		}
+		if errors.Is(err, sql.ErrNoRows) {
+			return gophkeeper.ErrResourceNotFound
+		}
+		return err
+	}
+
+	dropped, purgeError := purgeResource(ctx, transaction, rid)
+	if purgeError != nil {
+		if err := transaction.Rollback(); err != nil {
+			return err
+		}
+		return purgeError
+	}
+
+	if err := transaction.Commit(); err != nil {
+		return err
+	}
+
+	removeBlobs(i.Blobs, dropped)
+	return nil
+}
+
+// CreateFolder implements Identity.
+func (i *Identity) CreateFolder(ctx context.Context, name string, parent gophkeeper.FolderID) (gophkeeper.FolderID, error) {
+	insertFolderResult := i.Database.QueryRowContext(
+		ctx,
+		`INSERT INTO folders(owner, parent, name) SELECT ?1, ?2, ?3
+		WHERE ?2 = 0 OR EXISTS (SELECT 1 FROM folders WHERE id = ?2 AND owner = ?1) RETURNING id`,
+		i.Username, (int64)(parent), name,
+	)
+	var id int64
+	if err := insertFolderResult.Scan(&id); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return -1, gophkeeper.ErrFolderNotFound
+		}
+		return -1, err
+	}
+	return (gophkeeper.FolderID)(id), nil
+}
+
+// ListFolders implements Identity.
+func (i *Identity) ListFolders(ctx context.Context) ([]gophkeeper.Folder, error) {
+	selectFoldersResult, selectFoldersError := i.Database.QueryContext(
+		ctx,
+		`SELECT id, parent, name FROM folders WHERE owner = ? ORDER BY id`,
+		i.Username,
+	)
+	if selectFoldersError != nil {
+		return nil, selectFoldersError
+	}
+	defer selectFoldersResult.Close()
+
+	folders := make([]gophkeeper.Folder, 0)
+	for selectFoldersResult.Next() {
+		var folder gophkeeper.Folder
+		if err := selectFoldersResult.Scan(&folder.ID, &folder.Parent, &folder.Name); err != nil {
+			return nil, err
+		}
+		folders = append(folders, folder)
+	}
+	if err := selectFoldersResult.Err(); err != nil {
+		return nil, err
+	}
+	return folders, nil
+}
+
+// MoveFolder implements Identity.
+//
+// The transaction holds the write lock of the database,
+// so concurrent moves can not make a cycle together.
+func (i *Identity) MoveFolder(ctx context.Context, folder gophkeeper.FolderID, parent gophkeeper.FolderID) error {
+	transaction, transactionError := i.Database.BeginTx(ctx, nil)
+	if transactionError != nil {
+		return transactionError
+	}
+
+	checkResult := transaction.QueryRowContext(
+		ctx,
+		`SELECT
+			EXISTS (SELECT 1 FROM folders WHERE id = ?2 AND owner = ?1),
+			?3 = 0 OR EXISTS (SELECT 1 FROM folders WHERE id = ?3 AND owner = ?1),
+			EXISTS (
+				WITH RECURSIVE ancestors(id, parent) AS (
+					SELECT id, parent FROM folders WHERE id = ?3 AND owner = ?1
+					UNION
+					SELECT f.id, f.parent FROM folders f JOIN ancestors a ON f.id = a.parent
+				) SELECT 1 FROM ancestors WHERE id = ?2
+			)`,
+		i.Username, (int64)(folder), (int64)(parent),
+	)
+	var (
+		folderExists bool
+		parentExists bool
+		cycle        bool
+	)
+	if err := checkResult.Scan(&folderExists, &parentExists, &cycle); err != nil {
+		if err := transaction.Rollback(); err != nil {
+			return err
+		}
+		return err
+	}
+	var checkError error
+	switch {
+	case !folderExists || !parentExists:
+		checkError = gophkeeper.ErrFolderNotFound
+	case cycle:
+		checkError = gophkeeper.ErrFolderCycle
+	}
+	if checkError != nil {
+		if err := transaction.Rollback(); err != nil {
+			return err
+		}
+		return checkError
+	}
+
+	if _, err := transaction.ExecContext(ctx, `UPDATE folders SET parent = ? WHERE id = ?`, (int64)(parent), (int64)(folder)); err != nil {
+		if err := transaction.Rollback(); err != nil {
+			return err
+		}
+		return err
+	}
+
+	return transaction.Commit()
+}
+
+// MoveResource implements Identity.
+func (i *Identity) MoveResource(ctx context.Context, rid gophkeeper.ResourceID, folder gophkeeper.FolderID) error {
+	if err := i.checkResource(ctx, rid); err != nil {
+		return err
+	}
+
+	if folder != gophkeeper.RootFolder {
+		var exists bool
+		selectFolderResult := i.Database.QueryRowContext(
+			ctx,
+			`SELECT EXISTS (SELECT 1 FROM folders WHERE id = ? AND owner = ?)`,
+			(int64)(folder), i.Username,
+		)
+		if err := selectFolderResult.Scan(&exists); err != nil {
+			return err
+		}
+		if !exists {
+			return gophkeeper.ErrFolderNotFound
+		}
+	}
+
+	result, updateError := i.Database.ExecContext(
+		ctx,
+		`UPDATE resources SET folder = ? WHERE id = ? AND owner = ? AND deleted_at IS NULL`,
+		(int64)(folder), (int64)(rid), i.Username,
+	)
+	return affectedResource(result, updateError)
+}
+
+// Tag implements Identity.
+func (i *Identity) Tag(ctx context.Context, rid gophkeeper.ResourceID, tag string, index ...string) error {
+	if err := i.checkResource(ctx, rid); err != nil {
+		return err
+	}
+	tokens, tokensError := encodeTokens(index)
+	if tokensError != nil {
+		return tokensError
+	}
+	_, insertError := i.Database.ExecContext(
+		ctx,
+		`INSERT INTO resource_tags(resource, tag, tokens) VALUES(?, ?, ?)
+		ON CONFLICT (resource, tag) DO UPDATE SET tokens = excluded.tokens`,
+		(int64)(rid), tag, tokens,
+	)
+	return insertError
+}
+
+// Untag implements Identity.
+func (i *Identity) Untag(ctx context.Context, rid gophkeeper.ResourceID, tag string) error {
+	if err := i.checkResource(ctx, rid); err != nil {
+		return err
+	}
+	_, deleteError := i.Database.ExecContext(
+		ctx,
+		`DELETE FROM resource_tags WHERE resource = ? AND tag = ?`,
+		(int64)(rid), tag,
+	)
+	return deleteError
+}
+
+// List implements Identity.
+func (i *Identity) List(ctx context.Context, options gophkeeper.ListOptions) (gophkeeper.ListPage, error) {
+	var (
+		query strings.Builder
+		args  = []any{i.Username, (int)(gophkeeper.ResourceTypePiece), (int)(gophkeeper.ResourceTypeBlob)}
+		arg   = func(value any) string {
+			args = append(args, value)
+			return fmt.Sprintf("?%d", len(args))
+		}
+	)
+	query.WriteString(selectResources)
+	query.WriteString(` WHERE r.owner = ?1 AND r.deleted_at IS NULL`)
+	if options.ID != nil {
+		fmt.Fprintf(&query, ` AND r.id = %s`, arg((int64)(*options.ID)))
+	}
+	if options.Folder != nil {
+		fmt.Fprintf(&query, ` AND r.folder = %s`, arg((int64)(*options.Folder)))
+	}
+	if options.Tag != "" {
+		fmt.Fprintf(
+			&query,
+			` AND EXISTS (SELECT 1 FROM resource_tags t WHERE t.resource = r.id AND t.tag = %s)`,
+			arg(options.Tag),
+		)
+	}
+	if options.Type != nil {
+		fmt.Fprintf(&query, ` AND r.type = %s`, arg((int)(*options.Type)))
+	}
+	if options.MetaPrefix != "" {
+		prefix := arg(options.MetaPrefix)
+		fmt.Fprintf(&query, ` AND substr(r.meta, 1, length(%s)) = %s`, prefix, prefix)
+	}
+
+	key, direction, comparison := listOrderKey(options.Order), "ASC", ">"
+	if options.Descending {
+		direction, comparison = "DESC", "<"
+	}
+	if options.Cursor != "" {
+		position, positionError := cursor.Decode(options.Cursor, options)
+		if positionError != nil {
+			return gophkeeper.ListPage{}, positionError
+		}
+		fmt.Fprintf(
+			&query,
+			` AND (%s, r.id) %s (%s, %s)`,
+			key, comparison, arg(position.Key), arg((int64)(position.ID)),
+		)
+	}
+	fmt.Fprintf(&query, ` ORDER BY %s %s, r.id %s`, key, direction, direction)
+	if options.Limit > 0 {
+		// Select one extra resource to find out whether there is a next page.
+		fmt.Fprintf(&query, ` LIMIT %s`, arg(options.Limit+1))
+	}
+
+	selectResourcesResult, selectResourcesError := i.Database.QueryContext(ctx, query.String(), args...)
+	if selectResourcesError != nil {
+		return gophkeeper.ListPage{}, selectResourcesError
+	}
+	defer selectResourcesResult.Close()
+	resources := make([]gophkeeper.Resource, 0)
+	for selectResourcesResult.Next() {
+		resource, scanError := scanResource(selectResourcesResult)
+		if scanError != nil {
+			return gophkeeper.ListPage{}, scanError
+		}
+		resources = append(resources, resource.Resource)
+	}
+	if err := selectResourcesResult.Err(); err != nil {
+		return gophkeeper.ListPage{}, err
+	}
+
+	var page gophkeeper.ListPage
+	if options.Limit > 0 && len(resources) > options.Limit {
+		resources = resources[:options.Limit]
+		page.Next = cursor.Encode(cursor.Of(resources[len(resources)-1], options))
+	}
+	page.Resources = resources
+	return page, nil
+}
+
+// Search implements Identity.
+func (i *Identity) Search(ctx context.Context, tokens []string) ([]gophkeeper.Resource, error) {
+	query, queryError := encodeTokens(tokens)
+	if queryError != nil {
+		return nil, queryError
+	}
+	if query == nil {
+		empty := "[]"
+		query = &empty
+	}
+	selectResourcesResult, selectResourcesError := i.Database.QueryContext(
+		ctx,
+		selectResources+` WHERE r.owner = ?1 AND r.deleted_at IS NULL
+		AND NOT EXISTS (
+			SELECT 1 FROM json_each(?4) q WHERE q.value NOT IN (
+				SELECT value FROM json_each(COALESCE(r.tokens, '[]'))
+				UNION ALL
+				SELECT j.value FROM resource_tags t, json_each(COALESCE(t.tokens, '[]')) j WHERE t.resource = r.id
+			)
+		)
+		ORDER BY r.id`,
+		i.Username, (int)(gophkeeper.ResourceTypePiece), (int)(gophkeeper.ResourceTypeBlob), *query,
+	)
+	if selectResourcesError != nil {
+		return nil, selectResourcesError
+	}
+	defer selectResourcesResult.Close()
+
+	resources := make([]gophkeeper.Resource, 0)
+	for selectResourcesResult.Next() {
+		resource, scanError := scanResource(selectResourcesResult)
+		if scanError != nil {
+			return nil, scanError
+		}
+		resources = append(resources, resource.Resource)
+	}
+	return resources, selectResourcesResult.Err()
+}
+
+// listOrderKey returns the column resources are ordered by.
+func listOrderKey(order gophkeeper.ListOrder) string {
+	switch order {
+	case gophkeeper.ListOrderCreated:
+		return "r.created_at"
+	case gophkeeper.ListOrderUpdated:
+		return "r.updated_at"
+	case gophkeeper.ListOrderSize:
+		return "COALESCE(p.size, b.size, 0)"
+	default:
+		return "r.id"
+	}
+}
+
+// ChangePassword implements Identity.
+func (i *Identity) ChangePassword(ctx context.Context, oldPassword, newPassword string) error {
+	if err := i.comparePassword(ctx, oldPassword); err != nil {
+		return errors.Join(err, gophkeeper.ErrBadCredential)
+	}
+
+	password, passwordError := bcrypt.GenerateFromPassword(([]byte)(newPassword), bcrypt.DefaultCost)
+	if passwordError != nil {
+		return passwordError
+	}
+
+	_, updateError := i.Database.ExecContext(
+		ctx,
+		`UPDATE identities SET password = ? WHERE username = ?`,
+		i.PasswordEncoding.EncodeToString(password), i.Username,
+	)
+	return updateError
+}
+
+// ChangeVaultPassword implements Identity.
+//
+// The stored content is left as is.
+func (i *Identity) ChangeVaultPassword(ctx context.Context, oldPassword, newPassword string) error {
+	if err := i.compareVaultPassword(ctx, oldPassword); err != nil {
+		return errors.Join(err, gophkeeper.ErrBadCredential)
+	}
+
+	vaultCheck, vaultCheckError := server.NewVaultCheck(newPassword)
+	if vaultCheckError != nil {
+		return vaultCheckError
+	}
+
+	_, updateError := i.Database.ExecContext(
+		ctx,
+		`UPDATE identities SET vault_check = ? WHERE username = ?`,
+		vaultCheck, i.Username,
+	)
+	return updateError
+}
+
+// Settings implements Identity.
+func (i *Identity) Settings(ctx context.Context) (gophkeeper.Settings, error) {
+	row := i.Database.QueryRowContext(
+		ctx,
+		`SELECT key_file, vault_salt FROM identities WHERE username = ?`,
+		i.Username,
+	)
+	settings := gophkeeper.Settings{
+		Compression:   i.Compression,
+		Deduplication: i.Deduplication,
+	}
+	if err := row.Scan(&settings.KeyFile, &settings.VaultSalt); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return gophkeeper.Settings{}, gophkeeper.ErrBadCredential
+		}
+		return gophkeeper.Settings{}, err
+	}
+	return settings, nil
+}
+
+// UpdateSettings implements Identity.
+func (i *Identity) UpdateSettings(ctx context.Context, settings gophkeeper.Settings) error {
+	_, updateError := i.Database.ExecContext(
+		ctx,
+		`UPDATE identities SET key_file = ? WHERE username = ?`,
+		settings.KeyFile, i.Username,
+	)
+	return updateError
+}
+
+// insertResource inserts the resource of the content
+// owned by the identity and returns its ResourceID.
+func (i *Identity) insertResource(
+	ctx context.Context,
+	transaction *sql.Tx,
+	resourceType gophkeeper.ResourceType,
+	content int64,
+	meta string,
+	index []string,
+) (gophkeeper.ResourceID, error) {
+	tokens, tokensError := encodeTokens(index)
+	if tokensError != nil {
+		return -1, tokensError
+	}
+	now := time.Now().UnixMicro()
+	insertResourceResult := transaction.QueryRowContext(
+		ctx,
+		`INSERT INTO resources(meta, resource, type, owner, tokens, created_at, updated_at)
+		VALUES(?, ?, ?, ?, ?, ?, ?) RETURNING id`,
+		meta, content, (int)(resourceType), i.Username, tokens, now, now,
+	)
+	var rid int64
+	if err := insertResourceResult.Scan(&rid); err != nil {
+		return -1, err
+	}
+	return (gophkeeper.ResourceID)(rid), nil
+}
+
+// accessResource marks the resource of the type as restored
+// and returns its meta and the id of its content.
+func (i *Identity) accessResource(
+	ctx context.Context,
+	rid gophkeeper.ResourceID,
+	resourceType gophkeeper.ResourceType,
+) (string, int64, error) {
+	updateResourceResult := i.Database.QueryRowContext(
+		ctx,
+		`UPDATE resources SET accessed_at = ?
+		WHERE id = ? AND owner = ? AND type = ? AND deleted_at IS NULL RETURNING meta, resource`,
+		time.Now().UnixMicro(), (int64)(rid), i.Username, (int)(resourceType),
+	)
+	var (
+		meta string
+		id   int64
+	)
+	if err := updateResourceResult.Scan(&meta, &id); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", -1, gophkeeper.ErrResourceNotFound
+		}
+		return "", -1, err
+	}
+	return meta, id, nil
+}
+
+// updateResource saves the current state of the resource of the type into
+// its history and replaces its meta and index. It returns the id of its
+// content, that is to be replaced by the caller, and locations of the blob
+// files that are no longer referenced, they must be removed after the
+// transaction is committed.
+func (i *Identity) updateResource(
+	ctx context.Context,
+	transaction *sql.Tx,
+	rid gophkeeper.ResourceID,
+	resourceType gophkeeper.ResourceType,
+	meta string,
+	index []string,
+) (int64, []string, error) {
+	selectResourceResult := transaction.QueryRowContext(
+		ctx,
+		`SELECT resource FROM resources WHERE id = ? AND owner = ? AND type = ? AND deleted_at IS NULL`,
+		(int64)(rid), i.Username, (int)(resourceType),
+	)
+	var id int64
+	if err := selectResourceResult.Scan(&id); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return -1, nil, gophkeeper.ErrResourceNotFound
+		}
+		return -1, nil, err
+	}
+
+	dropped, pushError := i.pushVersion(ctx, transaction, rid)
+	if pushError != nil {
+		return -1, nil, pushError
+	}
+
+	tokens, tokensError := encodeTokens(index)
+	if tokensError != nil {
+		return -1, nil, tokensError
+	}
+	if _, err := transaction.ExecContext(
+		ctx,
+		`UPDATE resources SET meta = ?, tokens = ?, updated_at = ? WHERE id = ?`,
+		meta, tokens, time.Now().UnixMicro(), (int64)(rid),
+	); err != nil {
+		return -1, nil, err
+	}
+	return id, dropped, nil
+}
+
+// pushVersion saves the current state of the resource into its history
+// and drops the revisions beyond the limit. It returns locations of
+// the blob files that are no longer referenced, they must be removed
+// after the transaction is committed.
+func (i *Identity) pushVersion(ctx context.Context, transaction *sql.Tx, rid gophkeeper.ResourceID) ([]string, error) {
+	updateRevisionResult := transaction.QueryRowContext(
+		ctx,
+		`UPDATE resources SET revision = revision + 1 WHERE id = ? RETURNING revision, type, resource`,
+		(int64)(rid),
+	)
+	var (
+		revision     int
+		resourceType int
+		resourceID   int64
+	)
+	if err := updateRevisionResult.Scan(&revision, &resourceType, &resourceID); err != nil {
+		return nil, err
+	}
+
+	var insertError error
+	switch (gophkeeper.ResourceType)(resourceType) {
+	case gophkeeper.ResourceTypePiece:
+		_, insertError = transaction.ExecContext(
+			ctx,
+			`INSERT INTO resource_versions(resource, version, meta, tokens, content, size, hash, created_at)
+			SELECT r.id, ?2, r.meta, r.tokens, p.content, p.size, p.hash, ?4 FROM resources r, pieces p WHERE r.id = ?1 AND p.id = ?3`,
+			(int64)(rid), revision, resourceID, time.Now().UnixMicro(),
+		)
+	case gophkeeper.ResourceTypeBlob:
+		_, insertError = transaction.ExecContext(
+			ctx,
+			`INSERT INTO resource_versions(resource, version, meta, tokens, location, size, hash, created_at)
+			SELECT r.id, ?2, r.meta, r.tokens, b.location, b.size, b.hash, ?4 FROM resources r, blobs b WHERE r.id = ?1 AND b.id = ?3`,
+			(int64)(rid), revision, resourceID, time.Now().UnixMicro(),
+		)
+	default:
+		insertError = errors.New("unknown resource type")
+	}
+	if insertError != nil {
+		return nil, insertError
+	}
+
+	return queryLocations(
+		ctx,
+		transaction,
+		`DELETE FROM resource_versions WHERE resource = ?1 AND id NOT IN (
+			SELECT id FROM resource_versions WHERE resource = ?1 ORDER BY version DESC LIMIT ?2
+		) RETURNING location`,
+		(int64)(rid), i.VersionsLimit,
+	)
+}
+
+// checkResource returns ErrResourceNotFound unless the resource
+// is owned by the identity and is not in the trash.
+func (i *Identity) checkResource(ctx context.Context, rid gophkeeper.ResourceID) error {
+	var exists bool
+	selectResourceResult := i.Database.QueryRowContext(
+		ctx,
+		`SELECT EXISTS (SELECT 1 FROM resources WHERE id = ? AND owner = ? AND deleted_at IS NULL)`,
+		(int64)(rid), i.Username,
+	)
+	if err := selectResourceResult.Scan(&exists); err != nil {
+		return err
+	}
+	if !exists {
+		return gophkeeper.ErrResourceNotFound
+	}
+	return nil
+}
+
+// selectResources selects the columns read by scanResource.
+// It expects the piece and the blob resource types
+// as ?2 and ?3 parameters.
+const selectResources = `SELECT r.id, r.type, r.meta, r.folder,
+	(SELECT json_group_array(tag) FROM (SELECT t.tag FROM resource_tags t WHERE t.resource = r.id ORDER BY t.tag)),
+	r.created_at, r.updated_at, r.accessed_at,
+	COALESCE(p.size, b.size, 0), COALESCE(p.hash, b.hash), r.deleted_at
+	FROM resources r
+	LEFT JOIN pieces p ON r.type = ?2 AND p.id = r.resource
+	LEFT JOIN blobs b ON r.type = ?3 AND b.id = r.resource`
+
+// scanResource scans a row selected with selectResources.
+// DeletedAt is left zero for resources that are not in the trash.
+func scanResource(row *sql.Rows) (gophkeeper.TrashedResource, error) {
+	var (
+		resource   gophkeeper.TrashedResource
+		tags       string
+		createdAt  int64
+		updatedAt  int64
+		accessedAt *int64
+		deletedAt  *int64
+	)
+	if err := row.Scan(
+		&resource.ID, &resource.Type, &resource.Meta, &resource.Folder, &tags,
+		&createdAt, &updatedAt, &accessedAt, &resource.Size, &resource.Hash, &deletedAt,
+	); err != nil {
+		return gophkeeper.TrashedResource{}, err
+	}
+	if err := json.Unmarshal(([]byte)(tags), &resource.Tags); err != nil {
+		return gophkeeper.TrashedResource{}, err
+	}
+	resource.CreatedAt = time.UnixMicro(createdAt)
+	resource.UpdatedAt = time.UnixMicro(updatedAt)
+	if accessedAt != nil {
+		resource.AccessedAt = time.UnixMicro(*accessedAt)
+	}
+	if deletedAt != nil {
+		resource.DeletedAt = time.UnixMicro(*deletedAt)
+	}
+	return resource, nil
+}
+
+// comparePassword compares the password with the login password of the identity.
+func (i *Identity) comparePassword(ctx context.Context, password string) error {
+	row := i.Database.QueryRowContext(
+		ctx,
+		`SELECT password FROM identities WHERE username = ?`,
+		i.Username,
+	)
+	var encodedPassword string
+	if err := row.Scan(&encodedPassword); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return gophkeeper.ErrBadCredential
+		}
+		return err
+	}
+
+	decodedPassword, decodePasswordError := i.PasswordEncoding.DecodeString(encodedPassword)
+	if decodePasswordError != nil {
+		return decodePasswordError
+	}
+	if err := bcrypt.CompareHashAndPassword(decodedPassword, ([]byte)(password)); err != nil {
+		return errors.Join(gophkeeper.ErrBadCredential, err)
+	}
+	return nil
+}
+
+func (i *Identity) compareVaultPassword(ctx context.Context, password string) error {
+	_, err := i.verifyVaultPassword(ctx, password)
+	return err
+}
+
+// UnlockVault implements server.VaultUnlocker.
+func (i *Identity) UnlockVault(ctx context.Context, password string) ([]byte, error) {
+	_, key, err := i.unlockVault(ctx, password)
+	return key, err
+}
+
+// verifyVaultPassword compares the password with the vault password
+// of the identity and returns its vault check.
+func (i *Identity) verifyVaultPassword(ctx context.Context, password string) ([]byte, error) {
+	vaultCheck, _, err := i.unlockVault(ctx, password)
+	return vaultCheck, err
+}
+
+// unlockVault compares the password with the vault password of the
+// identity and returns its vault check and the key that verifies it.
+// The vault key of the context, if there is one, is compared instead
+// of the password, see server.WithVaultKey.
+func (i *Identity) unlockVault(ctx context.Context, password string) ([]byte, []byte, error) {
+	row := i.Database.QueryRowContext(
+		ctx,
+		`SELECT vault_check FROM identities WHERE username = ?`,
+		i.Username,
+	)
+	var vaultCheck []byte
+	if err := row.Scan(&vaultCheck); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil, gophkeeper.ErrBadCredential
+		}
+		return nil, nil, err
+	}
+	if key := server.VaultKey(ctx); key != nil {
+		if err := server.VerifyVaultCheckKey(vaultCheck, key); err != nil {
+			return nil, nil, err
+		}
+		return vaultCheck, key, nil
+	}
+
+	key, keyError := server.VaultCheckKey(vaultCheck, password)
+	if keyError != nil {
+		return nil, nil, keyError
+	}
+	if err := server.VerifyVaultCheckKey(vaultCheck, key); err != nil {
+		return nil, nil, err
+	}
+	return vaultCheck, key, nil
+}
+
+// lockVaultPassword checks that the vault check of the identity is still
+// the one within the transaction, it holds the write lock of the database,
+// so content encrypted with a replaced password is never written.
+func (i *Identity) lockVaultPassword(ctx context.Context, transaction *sql.Tx, vaultCheck []byte) error {
+	row := transaction.QueryRowContext(
+		ctx,
+		`SELECT vault_check FROM identities WHERE username = ?`,
+		i.Username,
+	)
+	var currentVaultCheck []byte
+	if err := row.Scan(&currentVaultCheck); err != nil {
+		return err
+	}
+	if !bytes.Equal(currentVaultCheck, vaultCheck) {
+		return gophkeeper.ErrBadCredential
+	}
+	return nil
+}
+
+// writeBlob stores the content as a new blob
+// and returns its location, size and SHA-256 hash.
+func (i *Identity) writeBlob(ctx context.Context, content io.Reader) (string, int64, []byte, error) {
+	var (
+		location = i.Blobs.NewKey()
+		hash     = sha256.New()
+	)
+	size, putError := i.Blobs.Put(ctx, location, io.TeeReader(content, hash))
+	if putError != nil {
+		return "", 0, nil, putError
+	}
+	return location, size, hash.Sum(nil), nil
+}
+
+// purgeResource permanently deletes the resource with its history.
+// It returns locations of the blob files that are no longer referenced,
+// they must be removed after the transaction is committed.
+func purgeResource(ctx context.Context, transaction *sql.Tx, rid gophkeeper.ResourceID) ([]string, error) {
+	deleteResourceResult := transaction.QueryRowContext(
+		ctx,
+		`DELETE FROM resources WHERE id = ? RETURNING type, resource`,
+		(int64)(rid),
+	)
+	var (
+		resourceType int
+		resourceID   int64
+	)
+	if err := deleteResourceResult.Scan(&resourceType, &resourceID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, gophkeeper.ErrResourceNotFound
+		}
+		return nil, err
+	}
+
+	if _, err := transaction.ExecContext(ctx, `DELETE FROM resource_tags WHERE resource = ?`, (int64)(rid)); err != nil {
+		return nil, err
+	}
+
+	dropped, versionsError := queryLocations(
+		ctx,
+		transaction,
+		`DELETE FROM resource_versions WHERE resource = ? RETURNING location`,
+		(int64)(rid),
+	)
+	if versionsError != nil {
+		return nil, versionsError
+	}
+
+	switch (gophkeeper.ResourceType)(resourceType) {
+	case gophkeeper.ResourceTypePiece:
+		if _, err := transaction.ExecContext(ctx, `DELETE FROM pieces WHERE id = ?`, resourceID); err != nil {
+			return nil, err
+		}
+	case gophkeeper.ResourceTypeBlob:
+		blobs, blobsError := queryLocations(
+			ctx,
+			transaction,
+			`DELETE FROM blobs WHERE id = ? RETURNING location`,
+			resourceID,
+		)
+		if blobsError != nil {
+			return nil, blobsError
+		}
+		dropped = append(dropped, blobs...)
+	default:
+		return nil, errors.New("unknown resource type")
+	}
+
+	return dropped, nil
+}
+
+// queryLocations runs the query and returns the not null
+// locations it returns.
+func queryLocations(ctx context.Context, transaction *sql.Tx, query string, args ...any) ([]string, error) {
+	result, resultError := transaction.QueryContext(ctx, query, args...)
+	if resultError != nil {
+		return nil, resultError
+	}
+	defer result.Close()
+
+	locations := make([]string, 0)
+	for result.Next() {
+		var location *string
+		if err := result.Scan(&location); err != nil {
+			return nil, err
+		}
+		if location != nil {
+			locations = append(locations, *location)
+		}
+	}
+	return locations, result.Err()
+}
+
+// affectedResource returns ErrResourceNotFound
+// if the update affected no resource.
+func affectedResource(result sql.Result, updateError error) error {
+	if updateError != nil {
+		return updateError
+	}
+	affected, affectedError := result.RowsAffected()
+	if affectedError != nil {
+		return affectedError
+	}
+	if affected == 0 {
+		return gophkeeper.ErrResourceNotFound
+	}
+	return nil
+}
+
+// encodeTokens encodes the tokens as a JSON array, nil if there are none.
+func encodeTokens(tokens []string) (*string, error) {
+	if tokens == nil {
+		return nil, nil
+	}
+	encoded, encodeError := json.Marshal(tokens)
+	if encodeError != nil {
+		return nil, encodeError
+	}
+	result := (string)(encoded)
+	return &result, nil
+}
+
+// decodeTokens decodes the tokens encoded by encodeTokens.
+func decodeTokens(encoded *string) ([]string, error) {
+	if encoded == nil {
+		return nil, nil
+	}
+	var tokens []string
+	if err := json.Unmarshal(([]byte)(*encoded), &tokens); err != nil {
+		return nil, err
+	}
+	return tokens, nil
+}
+
+// removeBlobs deletes the blobs that are no longer referenced.
+// The blobs are deleted even if the request is canceled,
+// as the rows that referenced them are gone already.
+func removeBlobs(store blobstore.BlobStore, locations []string) {
+	for _, location := range locations {
+		if err := store.Delete(context.Background(), location); err != nil {
+			log.Printf("failed to remove file: %s\n", err.Error())
+		}
+	}
+}
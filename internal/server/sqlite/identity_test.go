@@ -0,0 +1,348 @@
+package sqlite_test
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/kerelape/gophkeeper/pkg/gophkeeper"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIdentity(t *testing.T) {
+	var (
+		g          = newGophkeeper(t)
+		credential = gophkeeper.Credential{
+			Username: "test",
+			Password: "qwerty",
+		}
+		alianCredential = gophkeeper.Credential{
+			Username: "abc",
+			Password: "nonword",
+		}
+		identity = newIdentity(t, g, credential)
+		alian    = newIdentity(t, g, alianCredential)
+	)
+
+	rid, storeError := identity.StorePiece(
+		context.Background(),
+		gophkeeper.Piece{
+			Meta:    "testmeta",
+			Content: ([]byte)("testcontent"),
+		},
+		credential.Password,
+	)
+	assert.Nil(t, storeError, "expected to successfully store a piece")
+	piece, restoreError := identity.RestorePiece(context.Background(), rid, credential.Password)
+	assert.Nil(t, restoreError, "expected to successfully restore the piece back")
+	assert.Equal(t, "testmeta", piece.Meta, "meta is not restored correctly")
+	assert.Equal(t, "testcontent", (string)(piece.Content), "content is not restored correctly")
+
+	_, wrongPasswordError := identity.RestorePiece(context.Background(), rid, "wrong")
+	assert.ErrorIs(t, wrongPasswordError, gophkeeper.ErrBadCredential, "unexpected error on wrong password")
+
+	_, alianRestoreError := alian.RestorePiece(context.Background(), rid, alianCredential.Password)
+	assert.ErrorIs(
+		t,
+		alianRestoreError,
+		gophkeeper.ErrResourceNotFound,
+		"unexpected error on restoring other identity's piece",
+	)
+
+	blobRID, storeBlobError := identity.StoreBlob(
+		context.Background(),
+		gophkeeper.Blob{
+			Meta:    "blobmeta",
+			Content: io.NopCloser(bytes.NewReader(([]byte)("blobcontent"))),
+		},
+		credential.Password,
+	)
+	assert.Nil(t, storeBlobError, "expected to successfully store a blob")
+	assert.Equal(t, "blobcontent", restoreBlob(t, identity, blobRID, credential.Password))
+
+	t.Run("Update", func(t *testing.T) {
+		for _, content := range []string{"first", "second", "third"} {
+			updateError := identity.UpdateBlob(
+				context.Background(),
+				blobRID,
+				gophkeeper.Blob{
+					Meta:    content + "meta",
+					Content: io.NopCloser(strings.NewReader(content)),
+				},
+				credential.Password,
+			)
+			assert.Nil(t, updateError, "expected to successfully update the blob")
+		}
+		assert.Equal(t, "third", restoreBlob(t, identity, blobRID, credential.Password))
+
+		versions, versionsError := identity.ListVersions(context.Background(), blobRID)
+		assert.Nil(t, versionsError, "expected to successfully list the versions")
+		assert.Len(t, versions, 2, "expected the versions beyond the limit to be dropped")
+		assert.Equal(t, "secondmeta", versions[0].Meta)
+		assert.Equal(t, "firstmeta", versions[1].Meta)
+
+		restoreVersionError := identity.RestoreVersion(
+			context.Background(),
+			blobRID,
+			versions[1].Version,
+			credential.Password,
+		)
+		assert.Nil(t, restoreVersionError, "expected to successfully restore the version")
+		assert.Equal(t, "first", restoreBlob(t, identity, blobRID, credential.Password))
+
+		alianUpdateError := alian.UpdatePiece(
+			context.Background(),
+			rid,
+			gophkeeper.Piece{Meta: "alian"},
+			alianCredential.Password,
+		)
+		assert.ErrorIs(t, alianUpdateError, gophkeeper.ErrResourceNotFound, "unexpected error on alian update")
+	})
+
+	t.Run("Trash", func(t *testing.T) {
+		assert.Nil(t, identity.Delete(context.Background(), blobRID), "expected to successfully delete the blob")
+		_, restoreError := identity.RestoreBlob(context.Background(), blobRID, credential.Password)
+		assert.ErrorIs(t, restoreError, gophkeeper.ErrResourceNotFound, "expected a deleted blob not to be restored")
+
+		trash, trashError := identity.ListTrash(context.Background())
+		assert.Nil(t, trashError, "expected to successfully list the trash")
+		if assert.Len(t, trash, 1, "expected the blob to be in the trash") {
+			assert.Equal(t, blobRID, trash[0].ID)
+			assert.False(t, trash[0].DeletedAt.IsZero(), "expected the deletion time to be set")
+		}
+
+		assert.Nil(t, identity.Undelete(context.Background(), blobRID), "expected to successfully undelete the blob")
+		assert.Equal(t, "first", restoreBlob(t, identity, blobRID, credential.Password))
+
+		purgeLiveError := identity.Purge(context.Background(), blobRID)
+		assert.ErrorIs(t, purgeLiveError, gophkeeper.ErrResourceNotFound, "expected a blob out of the trash not to be purged")
+
+		assert.Nil(t, identity.Delete(context.Background(), blobRID), "expected to successfully delete the blob")
+		assert.Nil(t, identity.Purge(context.Background(), blobRID), "expected to successfully purge the blob")
+		assert.ErrorIs(
+			t,
+			identity.Undelete(context.Background(), blobRID),
+			gophkeeper.ErrResourceNotFound,
+			"expected a purged blob not to be undeleted",
+		)
+	})
+}
+
+func TestIdentityFolders(t *testing.T) {
+	var (
+		g          = newGophkeeper(t)
+		credential = gophkeeper.Credential{
+			Username: "test",
+			Password: "qwerty",
+		}
+		identity = newIdentity(t, g, credential)
+	)
+
+	parent, parentError := identity.CreateFolder(context.Background(), "parent", gophkeeper.RootFolder)
+	assert.Nil(t, parentError, "expected to successfully create a folder")
+	child, childError := identity.CreateFolder(context.Background(), "child", parent)
+	assert.Nil(t, childError, "expected to successfully create a folder")
+
+	_, missingParentError := identity.CreateFolder(context.Background(), "orphan", child+100)
+	assert.ErrorIs(t, missingParentError, gophkeeper.ErrFolderNotFound, "unexpected error on missing parent")
+
+	cycleError := identity.MoveFolder(context.Background(), parent, child)
+	assert.ErrorIs(t, cycleError, gophkeeper.ErrFolderCycle, "expected a folder not to be moved into its child")
+
+	folders, foldersError := identity.ListFolders(context.Background())
+	assert.Nil(t, foldersError, "expected to successfully list the folders")
+	assert.Len(t, folders, 2)
+
+	rid, storeError := identity.StorePiece(context.Background(), gophkeeper.Piece{Meta: "meta"}, credential.Password)
+	assert.Nil(t, storeError, "expected to successfully store a piece")
+	assert.Nil(t, identity.MoveResource(context.Background(), rid, child), "expected to successfully move the piece")
+
+	page, listError := identity.List(context.Background(), gophkeeper.ListOptions{Folder: &child})
+	assert.Nil(t, listError, "expected to successfully list the folder")
+	if assert.Len(t, page.Resources, 1, "expected the piece to be in the folder") {
+		assert.Equal(t, rid, page.Resources[0].ID)
+		assert.Equal(t, child, page.Resources[0].Folder)
+	}
+}
+
+func TestIdentityList(t *testing.T) {
+	var (
+		g          = newGophkeeper(t)
+		credential = gophkeeper.Credential{
+			Username: "test",
+			Password: "qwerty",
+		}
+		identity = newIdentity(t, g, credential)
+	)
+
+	stored := make([]gophkeeper.ResourceID, 0)
+	for _, content := range []string{"a", "bbb", "cc", "dddd", "eeeee"} {
+		rid, storeError := identity.StorePiece(
+			context.Background(),
+			gophkeeper.Piece{Meta: "meta-" + content, Content: ([]byte)(content), Index: []string{content}},
+			credential.Password,
+		)
+		assert.Nil(t, storeError, "expected to successfully store a piece")
+		stored = append(stored, rid)
+	}
+
+	t.Run("Pages", func(t *testing.T) {
+		listed := make([]gophkeeper.ResourceID, 0)
+		options := gophkeeper.ListOptions{
+			Order:      gophkeeper.ListOrderSize,
+			Descending: true,
+			Limit:      2,
+		}
+		for {
+			page, listError := identity.List(context.Background(), options)
+			if !assert.Nil(t, listError, "expected to successfully list the resources") {
+				return
+			}
+			for _, resource := range page.Resources {
+				listed = append(listed, resource.ID)
+			}
+			if page.Next == "" {
+				break
+			}
+			options.Cursor = page.Next
+		}
+		assert.Equal(
+			t,
+			[]gophkeeper.ResourceID{stored[4], stored[3], stored[1], stored[2], stored[0]},
+			listed,
+			"expected the resources to be listed by size",
+		)
+	})
+
+	t.Run("Search", func(t *testing.T) {
+		assert.Nil(t, identity.Tag(context.Background(), stored[2], "work", "tagged"), "expected to tag the piece")
+
+		found, searchError := identity.Search(context.Background(), []string{"cc", "tagged"})
+		assert.Nil(t, searchError, "expected to successfully search")
+		if assert.Len(t, found, 1, "expected to find the tagged piece") {
+			assert.Equal(t, stored[2], found[0].ID)
+			assert.Equal(t, []string{"work"}, found[0].Tags)
+		}
+
+		nothing, searchError := identity.Search(context.Background(), []string{"a", "tagged"})
+		assert.Nil(t, searchError, "expected to successfully search")
+		assert.Empty(t, nothing, "expected every token to be required")
+
+		page, listError := identity.List(context.Background(), gophkeeper.ListOptions{MetaPrefix: "meta-d"})
+		assert.Nil(t, listError, "expected to successfully list the resources")
+		if assert.Len(t, page.Resources, 1, "expected the resources to be filtered by meta") {
+			assert.Equal(t, stored[3], page.Resources[0].ID)
+		}
+
+		page, listError = identity.List(context.Background(), gophkeeper.ListOptions{ID: &stored[1]})
+		assert.Nil(t, listError, "expected to successfully list the resources")
+		if assert.Len(t, page.Resources, 1, "expected the resources to be filtered by id") {
+			assert.Equal(t, stored[1], page.Resources[0].ID)
+		}
+	})
+}
+
+func TestIdentityReencrypt(t *testing.T) {
+	var (
+		g          = newGophkeeper(t)
+		credential = gophkeeper.Credential{
+			Username: "test",
+			Password: "qwerty",
+		}
+		identity = newIdentity(t, g, credential)
+	)
+	reencrypter, ok := identity.(gophkeeper.Reencrypter)
+	if !ok {
+		t.Fatal("expected the identity to be a reencrypter")
+	}
+
+	rid, storeError := identity.StorePiece(
+		context.Background(),
+		gophkeeper.Piece{Meta: "meta", Content: ([]byte)("content")},
+		credential.Password,
+	)
+	assert.Nil(t, storeError, "expected to successfully store a piece")
+	blobRID, storeBlobError := identity.StoreBlob(
+		context.Background(),
+		gophkeeper.Blob{Meta: "meta", Content: io.NopCloser(strings.NewReader("blob"))},
+		credential.Password,
+	)
+	assert.Nil(t, storeBlobError, "expected to successfully store a blob")
+	assert.Nil(t, identity.Tag(context.Background(), rid, "tag", "token"), "expected to successfully tag the piece")
+	fid, folderError := identity.CreateFolder(context.Background(), "folder", gophkeeper.RootFolder)
+	assert.Nil(t, folderError, "expected to successfully create a folder")
+
+	reencryption := gophkeeper.Reencryption{
+		Piece: func(p gophkeeper.Piece) (gophkeeper.Piece, error) {
+			return gophkeeper.Piece{Meta: p.Meta + "!", Content: append(p.Content, '!')}, nil
+		},
+		Blob: func(b gophkeeper.Blob) (gophkeeper.Blob, error) {
+			content, err := io.ReadAll(b.Content)
+			if err != nil {
+				return gophkeeper.Blob{}, err
+			}
+			return gophkeeper.Blob{Meta: b.Meta + "!", Content: io.NopCloser(bytes.NewReader(append(content, '!')))}, nil
+		},
+		TagIndex: func(tag string) ([]string, error) {
+			return []string{tag + " token"}, nil
+		},
+		Tag: func(tag string) (string, error) {
+			return tag + "!", nil
+		},
+		Folder: func(name string) (string, error) {
+			return name + "!", nil
+		},
+	}
+
+	failing := reencryption
+	failing.Blob = func(gophkeeper.Blob) (gophkeeper.Blob, error) {
+		return gophkeeper.Blob{}, io.ErrUnexpectedEOF
+	}
+	failError := reencrypter.Reencrypt(context.Background(), credential.Password, "new", failing)
+	assert.ErrorIs(t, failError, io.ErrUnexpectedEOF, "unexpected error on failed re-encryption")
+	piece, restoreError := identity.RestorePiece(context.Background(), rid, credential.Password)
+	assert.Nil(t, restoreError, "expected the password to be kept after a failed re-encryption")
+	assert.Equal(t, "content", (string)(piece.Content), "expected the content to be kept after a failed re-encryption")
+
+	reencryptError := reencrypter.Reencrypt(context.Background(), credential.Password, "new", reencryption)
+	assert.Nil(t, reencryptError, "expected to successfully re-encrypt")
+
+	_, oldPasswordError := identity.RestorePiece(context.Background(), rid, credential.Password)
+	assert.ErrorIs(t, oldPasswordError, gophkeeper.ErrBadCredential, "expected the old password to be rejected")
+	piece, restoreError = identity.RestorePiece(context.Background(), rid, "new")
+	assert.Nil(t, restoreError, "expected to restore the piece with the new password")
+	assert.Equal(t, "meta!", piece.Meta)
+	assert.Equal(t, "content!", (string)(piece.Content))
+	assert.Equal(t, "blob!", restoreBlob(t, identity, blobRID, "new"))
+
+	metas, metasError := reencrypter.Metas(context.Background(), "new")
+	assert.Nil(t, metasError, "expected to successfully get the metas")
+	assert.ElementsMatch(t, []string{"meta!", "meta!"}, metas)
+
+	page, listError := identity.List(context.Background(), gophkeeper.ListOptions{Tag: "tag!"})
+	assert.Nil(t, listError, "expected to successfully list")
+	if assert.Equal(t, 1, len(page.Resources), "expected the tag to be rewritten") {
+		assert.Equal(t, []string{"tag!"}, page.Resources[0].Tags)
+	}
+	found, searchError := identity.Search(context.Background(), []string{"tag token"})
+	assert.Nil(t, searchError, "expected to successfully search")
+	assert.Equal(t, 1, len(found), "expected the tag to be reindexed")
+	folders, foldersError := identity.ListFolders(context.Background())
+	assert.Nil(t, foldersError, "expected to successfully list the folders")
+	assert.Equal(t, []gophkeeper.Folder{{ID: fid, Name: "folder!"}}, folders, "expected the folder to be renamed")
+}
+
+// restoreBlob restores the blob and returns its content.
+func restoreBlob(t *testing.T, identity gophkeeper.Identity, rid gophkeeper.ResourceID, password string) string {
+	t.Helper()
+	blob, restoreError := identity.RestoreBlob(context.Background(), rid, password)
+	if !assert.Nil(t, restoreError, "expected to successfully restore the blob") {
+		return ""
+	}
+	defer blob.Content.Close()
+	content, readError := io.ReadAll(blob.Content)
+	assert.Nil(t, readError, "expected to successfully read the blob")
+	return (string)(content)
+}
@@ -0,0 +1,71 @@
+CREATE TABLE IF NOT EXISTS identities(
+    username TEXT PRIMARY KEY,
+    password TEXT NOT NULL,
+    vault_check BLOB,
+    key_file INTEGER NOT NULL DEFAULT 0,
+    vault_salt BLOB
+);
+
+CREATE TABLE IF NOT EXISTS resources(
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    resource INTEGER NOT NULL,
+    type INTEGER NOT NULL,
+    owner TEXT NOT NULL,
+    meta TEXT NOT NULL,
+    tokens TEXT,
+    folder INTEGER NOT NULL DEFAULT 0,
+    revision INTEGER NOT NULL DEFAULT 0,
+    created_at INTEGER NOT NULL,
+    updated_at INTEGER NOT NULL,
+    accessed_at INTEGER,
+    deleted_at INTEGER
+);
+
+CREATE INDEX IF NOT EXISTS resources_owner ON resources(owner);
+
+CREATE TABLE IF NOT EXISTS pieces(
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    content BLOB,
+    size INTEGER,
+    hash BLOB
+);
+
+CREATE TABLE IF NOT EXISTS blobs(
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    location TEXT,
+    size INTEGER,
+    hash BLOB
+);
+
+CREATE TABLE IF NOT EXISTS resource_versions(
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    resource INTEGER NOT NULL,
+    version INTEGER NOT NULL,
+    meta TEXT NOT NULL,
+    tokens TEXT,
+    content BLOB,
+    location TEXT,
+    size INTEGER,
+    hash BLOB,
+    created_at INTEGER NOT NULL,
+    UNIQUE (resource, version)
+);
+
+CREATE TABLE IF NOT EXISTS folders(
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    owner TEXT NOT NULL,
+    parent INTEGER NOT NULL,
+    name TEXT NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS resource_tags(
+    resource INTEGER NOT NULL,
+    tag TEXT NOT NULL,
+    tokens TEXT,
+    PRIMARY KEY (resource, tag)
+);
+
+CREATE TABLE IF NOT EXISTS blob_references(
+    hash TEXT PRIMARY KEY,
+    refs INTEGER NOT NULL DEFAULT 0
+);
@@ -0,0 +1,91 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+
+	"github.com/kerelape/gophkeeper/internal/blobstore"
+)
+
+// selectBlobFiles selects up to ? locations of the blob files
+// that follow the ? location ordered by the location.
+const selectBlobFiles = `SELECT location FROM (
+	SELECT location FROM blobs
+	UNION SELECT location FROM resource_versions
+) WHERE location IS NOT NULL AND location > ? ORDER BY location LIMIT ?`
+
+// MigrateBlobs moves the blob files stored before the deduplication was
+// enabled to the chunks, or back from the chunks once it is disabled,
+// batchSize of them at a time. It returns the number of the files moved.
+//
+// The gophkeeper must not serve while the files are moved, an interrupted
+// migration is resumed by migrating again.
+func (r *Gophkeeper) MigrateBlobs(ctx context.Context, batchSize int) (int, error) {
+	if batchSize <= 0 {
+		return 0, errors.New("batch size must be positive")
+	}
+	database, databaseError := r.database.Get(ctx)
+	if databaseError != nil {
+		return 0, databaseError
+	}
+
+	dedup, deduplicated := r.blobStore.(*blobstore.Dedup)
+	if !deduplicated {
+		dedup = &blobstore.Dedup{
+			Store:      r.blobStore,
+			References: &References{database: &r.database},
+		}
+	}
+	migrate := dedup.Join
+	if deduplicated {
+		migrate = dedup.Split
+	}
+
+	var (
+		migrated = 0
+		after    = ""
+	)
+	for {
+		locations, locationsError := queryBlobFiles(ctx, database, after, batchSize)
+		if locationsError != nil {
+			return migrated, locationsError
+		}
+		for _, location := range locations {
+			ok, migrateError := migrate(ctx, location)
+			if errors.Is(migrateError, blobstore.ErrNotFound) {
+				continue
+			}
+			if migrateError != nil {
+				return migrated, migrateError
+			}
+			if ok {
+				migrated++
+			}
+		}
+		if len(locations) < batchSize {
+			return migrated, dedup.Collect(ctx)
+		}
+		after = locations[len(locations)-1]
+	}
+}
+
+// queryBlobFiles returns up to limit locations
+// of the blob files that follow the location.
+func queryBlobFiles(ctx context.Context, database *sql.DB, after string, limit int) ([]string, error) {
+	result, resultError := database.QueryContext(ctx, selectBlobFiles, after, limit)
+	if resultError != nil {
+		return nil, resultError
+	}
+	defer result.Close()
+
+	locations := make([]string, 0, limit)
+	for result.Next() {
+		var location string
+		if err := result.Scan(&location); err != nil {
+			return nil, err
+		}
+		locations = append(locations, location)
+	}
+	return locations, result.Err()
+}
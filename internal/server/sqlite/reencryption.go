@@ -0,0 +1,424 @@
+package sqlite
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"errors"
+
+	"github.com/kerelape/gophkeeper/internal/blobstore"
+	"github.com/kerelape/gophkeeper/internal/server"
+	"github.com/kerelape/gophkeeper/pkg/gophkeeper"
+)
+
+// reencryptionUnit is a stored state of a resource,
+// version 0 is the current state.
+type reencryptionUnit struct {
+	rid          gophkeeper.ResourceID
+	version      int
+	resourceType gophkeeper.ResourceType
+	content      int64
+}
+
+var _ gophkeeper.Reencrypter = (*Identity)(nil)
+
+// Reencrypt implements gophkeeper.Reencrypter.
+//
+// The content is rewritten within a single transaction, it holds
+// the write lock of the database, so nothing changes the content
+// while it is being rewritten and a failed call changes nothing.
+func (i *Identity) Reencrypt(ctx context.Context, oldPassword, newPassword string, reencryption gophkeeper.Reencryption) error {
+	vaultCheck, passwordError := i.verifyVaultPassword(ctx, oldPassword)
+	if passwordError != nil {
+		return errors.Join(passwordError, gophkeeper.ErrBadCredential)
+	}
+	newVaultCheck, vaultCheckError := server.NewVaultCheck(newPassword)
+	if vaultCheckError != nil {
+		return vaultCheckError
+	}
+
+	transaction, transactionError := i.Database.BeginTx(ctx, nil)
+	if transactionError != nil {
+		return transactionError
+	}
+
+	if err := i.lockVaultPassword(ctx, transaction, vaultCheck); err != nil {
+		if err := transaction.Rollback(); err != nil {
+			return err
+		}
+		return err
+	}
+
+	written, dropped, reencryptError := i.reencrypt(ctx, transaction, reencryption)
+	if reencryptError != nil {
+		removeBlobs(i.Blobs, written)
+		if err := transaction.Rollback(); err != nil {
+			return err
+		}
+		return reencryptError
+	}
+
+	if _, err := transaction.ExecContext(
+		ctx,
+		`UPDATE identities SET vault_check = ? WHERE username = ?`,
+		newVaultCheck, i.Username,
+	); err != nil {
+		removeBlobs(i.Blobs, written)
+		if err := transaction.Rollback(); err != nil {
+			return err
+		}
+		return err
+	}
+
+	if err := transaction.Commit(); err != nil {
+		removeBlobs(i.Blobs, written)
+		return err
+	}
+
+	removeBlobs(i.Blobs, dropped)
+	return nil
+}
+
+// Metas implements gophkeeper.Reencrypter.
+func (i *Identity) Metas(ctx context.Context, password string) ([]string, error) {
+	if err := i.compareVaultPassword(ctx, password); err != nil {
+		return nil, errors.Join(err, gophkeeper.ErrBadCredential)
+	}
+
+	selectMetasResult, selectMetasError := i.Database.QueryContext(
+		ctx,
+		`SELECT meta FROM resources WHERE owner = ?1
+		UNION ALL
+		SELECT v.meta FROM resource_versions v
+		JOIN resources r ON r.id = v.resource
+		WHERE r.owner = ?1`,
+		i.Username,
+	)
+	if selectMetasError != nil {
+		return nil, selectMetasError
+	}
+	defer selectMetasResult.Close()
+
+	metas := make([]string, 0)
+	for selectMetasResult.Next() {
+		var meta string
+		if err := selectMetasResult.Scan(&meta); err != nil {
+			return nil, err
+		}
+		metas = append(metas, meta)
+	}
+	return metas, selectMetasResult.Err()
+}
+
+// reencrypt rewrites every stored state of the identity's resources
+// and the tokens of their tags. It returns locations of the blob files it
+// has written, they must be removed if the transaction fails, and locations
+// of the blob files that are no longer referenced, they must be removed
+// after the transaction is committed.
+func (i *Identity) reencrypt(ctx context.Context, transaction *sql.Tx, reencryption gophkeeper.Reencryption) ([]string, []string, error) {
+	units, unitsError := i.reencryptionUnits(ctx, transaction)
+	if unitsError != nil {
+		return nil, nil, unitsError
+	}
+	var (
+		written = make([]string, 0)
+		dropped = make([]string, 0)
+	)
+	for n, unit := range units {
+		if err := ctx.Err(); err != nil {
+			return written, nil, err
+		}
+		location, replaced, reencryptError := i.reencryptUnit(ctx, transaction, unit, reencryption)
+		if location != "" {
+			written = append(written, location)
+		}
+		if reencryptError != nil {
+			return written, nil, reencryptError
+		}
+		if replaced != "" {
+			dropped = append(dropped, replaced)
+		}
+		gophkeeper.ReportProgress(ctx, n+1, len(units))
+	}
+
+	if err := i.reencryptTags(ctx, transaction, reencryption); err != nil {
+		return written, nil, err
+	}
+	if err := i.reencryptFolders(ctx, transaction, reencryption); err != nil {
+		return written, nil, err
+	}
+	return written, dropped, nil
+}
+
+// reencryptionUnits returns the stored states of the identity's resources.
+func (i *Identity) reencryptionUnits(ctx context.Context, transaction *sql.Tx) ([]reencryptionUnit, error) {
+	selectUnitsResult, selectUnitsError := transaction.QueryContext(
+		ctx,
+		`SELECT id, 0, type, resource FROM resources WHERE owner = ?1
+		UNION ALL
+		SELECT v.resource, v.version, r.type, v.id FROM resource_versions v
+		JOIN resources r ON r.id = v.resource
+		WHERE r.owner = ?1
+		ORDER BY 1, 2`,
+		i.Username,
+	)
+	if selectUnitsError != nil {
+		return nil, selectUnitsError
+	}
+	defer selectUnitsResult.Close()
+
+	units := make([]reencryptionUnit, 0)
+	for selectUnitsResult.Next() {
+		var unit reencryptionUnit
+		if err := selectUnitsResult.Scan(&unit.rid, &unit.version, &unit.resourceType, &unit.content); err != nil {
+			return nil, err
+		}
+		units = append(units, unit)
+	}
+	return units, selectUnitsResult.Err()
+}
+
+// reencryptUnit rewrites the stored state. It returns location of the
+// blob file it has written and location of the blob file it has replaced,
+// if there are ones.
+func (i *Identity) reencryptUnit(
+	ctx context.Context,
+	transaction *sql.Tx,
+	unit reencryptionUnit,
+	reencryption gophkeeper.Reencryption,
+) (string, string, error) {
+	var selectStateResult *sql.Row
+	if unit.version == 0 {
+		selectStateResult = transaction.QueryRowContext(
+			ctx,
+			`SELECT r.meta, r.tokens, p.content, b.location FROM resources r
+			LEFT JOIN pieces p ON r.type = ?2 AND p.id = r.resource
+			LEFT JOIN blobs b ON r.type = ?3 AND b.id = r.resource
+			WHERE r.id = ?1`,
+			(int64)(unit.rid), (int)(gophkeeper.ResourceTypePiece), (int)(gophkeeper.ResourceTypeBlob),
+		)
+	} else {
+		selectStateResult = transaction.QueryRowContext(
+			ctx,
+			`SELECT meta, tokens, content, location FROM resource_versions WHERE id = ?`,
+			unit.content,
+		)
+	}
+	var (
+		meta     string
+		tokens   *string
+		content  []byte
+		location *string
+	)
+	if err := selectStateResult.Scan(&meta, &tokens, &content, &location); err != nil {
+		return "", "", err
+	}
+	index, indexError := decodeTokens(tokens)
+	if indexError != nil {
+		return "", "", indexError
+	}
+
+	if reencryption.Meta != nil {
+		reencryptedMeta, metaError := reencryption.Meta(meta)
+		if metaError != nil {
+			return "", "", metaError
+		}
+		return "", "", i.rewriteState(ctx, transaction, unit, reencryptedMeta, index, reencryption)
+	}
+
+	switch unit.resourceType {
+	case gophkeeper.ResourceTypePiece:
+		piece, pieceError := reencryption.Piece(gophkeeper.Piece{Meta: meta, Content: content})
+		if pieceError != nil {
+			return "", "", pieceError
+		}
+		if err := i.rewriteState(ctx, transaction, unit, piece.Meta, index, reencryption); err != nil {
+			return "", "", err
+		}
+		hash := sha256.Sum256(piece.Content)
+		query := `UPDATE pieces SET content = ?, size = ?, hash = ? WHERE id = ?`
+		if unit.version != 0 {
+			query = `UPDATE resource_versions SET content = ?, size = ?, hash = ? WHERE id = ?`
+		}
+		_, updateError := transaction.ExecContext(ctx, query, piece.Content, len(piece.Content), hash[:], unit.content)
+		return "", "", updateError
+	case gophkeeper.ResourceTypeBlob:
+		if location == nil {
+			return "", "", errors.New("blob has no location")
+		}
+		file, fileError := blobstore.Open(ctx, i.Blobs, *location)
+		if fileError != nil {
+			return "", "", fileError
+		}
+		blob, blobError := reencryption.Blob(gophkeeper.Blob{Meta: meta, Content: file})
+		if blobError != nil {
+			file.Close()
+			return "", "", blobError
+		}
+		reencryptedLocation, size, hash, writeError := i.writeBlob(ctx, blob.Content)
+		blob.Content.Close()
+		if writeError != nil {
+			return "", "", writeError
+		}
+		if err := i.rewriteState(ctx, transaction, unit, blob.Meta, index, reencryption); err != nil {
+			return reencryptedLocation, "", err
+		}
+		query := `UPDATE blobs SET location = ?, size = ?, hash = ? WHERE id = ?`
+		if unit.version != 0 {
+			query = `UPDATE resource_versions SET location = ?, size = ?, hash = ? WHERE id = ?`
+		}
+		if _, err := transaction.ExecContext(ctx, query, reencryptedLocation, size, hash, unit.content); err != nil {
+			return reencryptedLocation, "", err
+		}
+		return reencryptedLocation, *location, nil
+	default:
+		return "", "", errors.New("unknown resource type")
+	}
+}
+
+// rewriteState replaces the meta of the stored state and its tokens,
+// the tokens are kept as is unless reencryption.Index replaces them.
+func (i *Identity) rewriteState(
+	ctx context.Context,
+	transaction *sql.Tx,
+	unit reencryptionUnit,
+	meta string,
+	index []string,
+	reencryption gophkeeper.Reencryption,
+) error {
+	if reencryption.Index != nil {
+		reencryptedIndex, indexError := reencryption.Index(meta)
+		if indexError != nil {
+			return indexError
+		}
+		index = reencryptedIndex
+	}
+	tokens, tokensError := encodeTokens(index)
+	if tokensError != nil {
+		return tokensError
+	}
+	if unit.version == 0 {
+		_, updateError := transaction.ExecContext(
+			ctx,
+			`UPDATE resources SET meta = ?, tokens = ? WHERE id = ?`,
+			meta, tokens, (int64)(unit.rid),
+		)
+		return updateError
+	}
+	_, updateError := transaction.ExecContext(
+		ctx,
+		`UPDATE resource_versions SET meta = ?, tokens = ? WHERE id = ?`,
+		meta, tokens, unit.content,
+	)
+	return updateError
+}
+
+// reencryptTags rewrites every tag of the identity's resources with
+// reencryption.Tag and replaces its tokens with reencryption.TagIndex.
+func (i *Identity) reencryptTags(ctx context.Context, transaction *sql.Tx, reencryption gophkeeper.Reencryption) error {
+	if reencryption.TagIndex == nil && reencryption.Tag == nil {
+		return nil
+	}
+	selectTagsResult, selectTagsError := transaction.QueryContext(
+		ctx,
+		`SELECT DISTINCT t.tag FROM resource_tags t
+		JOIN resources r ON r.id = t.resource
+		WHERE r.owner = ?`,
+		i.Username,
+	)
+	if selectTagsError != nil {
+		return selectTagsError
+	}
+	tags := make([]string, 0)
+	for selectTagsResult.Next() {
+		var tag string
+		if err := selectTagsResult.Scan(&tag); err != nil {
+			selectTagsResult.Close()
+			return err
+		}
+		tags = append(tags, tag)
+	}
+	selectTagsResult.Close()
+	if err := selectTagsResult.Err(); err != nil {
+		return err
+	}
+
+	for _, tag := range tags {
+		if reencryption.TagIndex != nil {
+			index, indexError := reencryption.TagIndex(tag)
+			if indexError != nil {
+				return indexError
+			}
+			tokens, tokensError := encodeTokens(index)
+			if tokensError != nil {
+				return tokensError
+			}
+			if _, err := transaction.ExecContext(
+				ctx,
+				`UPDATE resource_tags SET tokens = ?1 WHERE tag = ?3
+				AND resource IN (SELECT id FROM resources WHERE owner = ?2)`,
+				tokens, i.Username, tag,
+			); err != nil {
+				return err
+			}
+		}
+		if reencryption.Tag != nil {
+			rewritten, tagError := reencryption.Tag(tag)
+			if tagError != nil {
+				return tagError
+			}
+			if _, err := transaction.ExecContext(
+				ctx,
+				`UPDATE resource_tags SET tag = ?1 WHERE tag = ?3
+				AND resource IN (SELECT id FROM resources WHERE owner = ?2)`,
+				rewritten, i.Username, tag,
+			); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// reencryptFolders rewrites the names of the
+// identity's folders with reencryption.Folder.
+func (i *Identity) reencryptFolders(ctx context.Context, transaction *sql.Tx, reencryption gophkeeper.Reencryption) error {
+	if reencryption.Folder == nil {
+		return nil
+	}
+	selectFoldersResult, selectFoldersError := transaction.QueryContext(
+		ctx,
+		`SELECT id, name FROM folders WHERE owner = ?`,
+		i.Username,
+	)
+	if selectFoldersError != nil {
+		return selectFoldersError
+	}
+	names := make(map[int64]string)
+	for selectFoldersResult.Next() {
+		var (
+			id   int64
+			name string
+		)
+		if err := selectFoldersResult.Scan(&id, &name); err != nil {
+			selectFoldersResult.Close()
+			return err
+		}
+		names[id] = name
+	}
+	selectFoldersResult.Close()
+	if err := selectFoldersResult.Err(); err != nil {
+		return err
+	}
+
+	for id, name := range names {
+		rewritten, folderError := reencryption.Folder(name)
+		if folderError != nil {
+			return folderError
+		}
+		if _, err := transaction.ExecContext(ctx, `UPDATE folders SET name = ? WHERE id = ?`, rewritten, id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,110 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/kerelape/gophkeeper/internal/blobstore"
+	"github.com/kerelape/gophkeeper/internal/deferred"
+)
+
+// References are the references to the chunks
+// of the deduplicated blobs counted in the database.
+type References struct {
+	database *deferred.Deferred[*sql.DB]
+}
+
+var _ blobstore.References = (*References)(nil)
+
+// Acquire implements blobstore.References.
+func (r *References) Acquire(ctx context.Context, hash string) error {
+	database, databaseError := r.database.Get(ctx)
+	if databaseError != nil {
+		return databaseError
+	}
+
+	_, acquireError := database.ExecContext(
+		ctx,
+		`INSERT INTO blob_references(hash, refs) VALUES(?, 1)
+		ON CONFLICT (hash) DO UPDATE SET refs = refs + 1`,
+		hash,
+	)
+	return acquireError
+}
+
+// Release implements blobstore.References.
+func (r *References) Release(ctx context.Context, hashes []string) error {
+	database, databaseError := r.database.Get(ctx)
+	if databaseError != nil {
+		return databaseError
+	}
+
+	counts := make(map[string]int, len(hashes))
+	for _, hash := range hashes {
+		counts[hash]++
+	}
+	for hash, count := range counts {
+		if _, err := database.ExecContext(
+			ctx,
+			`UPDATE blob_references SET refs = refs - ? WHERE hash = ?`,
+			count, hash,
+		); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Collect implements blobstore.References.
+//
+// The transaction holds the write lock of the database until the chunks
+// are forgotten, so acquiring one of them waits until it is forgotten.
+func (r *References) Collect(ctx context.Context, collect func(hash string) error) error {
+	database, databaseError := r.database.Get(ctx)
+	if databaseError != nil {
+		return databaseError
+	}
+
+	transaction, transactionError := database.BeginTx(ctx, nil)
+	if transactionError != nil {
+		return transactionError
+	}
+	hashes, hashesError := forgetUnreferenced(ctx, transaction)
+	if hashesError != nil {
+		if err := transaction.Rollback(); err != nil {
+			return err
+		}
+		return hashesError
+	}
+	for _, hash := range hashes {
+		if err := collect(hash); err != nil {
+			if err := transaction.Rollback(); err != nil {
+				return err
+			}
+			return err
+		}
+	}
+	return transaction.Commit()
+}
+
+// forgetUnreferenced forgets the chunks nothing references and returns them.
+func forgetUnreferenced(ctx context.Context, transaction *sql.Tx) ([]string, error) {
+	deleteReferencesResult, deleteReferencesError := transaction.QueryContext(
+		ctx,
+		`DELETE FROM blob_references WHERE refs <= 0 RETURNING hash`,
+	)
+	if deleteReferencesError != nil {
+		return nil, deleteReferencesError
+	}
+	defer deleteReferencesResult.Close()
+
+	hashes := make([]string, 0)
+	for deleteReferencesResult.Next() {
+		var hash string
+		if err := deleteReferencesResult.Scan(&hash); err != nil {
+			return nil, err
+		}
+		hashes = append(hashes, hash)
+	}
+	return hashes, deleteReferencesResult.Err()
+}
@@ -0,0 +1,42 @@
+package server
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/pior/runnable"
+)
+
+// Trash is a storage of deleted resources.
+type Trash interface {
+	// PurgeTrash permanently deletes all resources
+	// moved to the trash before the given time.
+	PurgeTrash(ctx context.Context, before time.Time) error
+}
+
+// TrashPurger periodically purges resources that
+// stay in the trash longer than Retention.
+type TrashPurger struct {
+	Trash     Trash
+	Retention time.Duration
+	Interval  time.Duration
+}
+
+var _ runnable.Runnable = (*TrashPurger)(nil)
+
+// Run implements runnable.Runnable.
+func (p *TrashPurger) Run(ctx context.Context) error {
+	ticker := time.NewTicker(p.Interval)
+	defer ticker.Stop()
+	for {
+		if err := p.Trash.PurgeTrash(ctx, time.Now().Add(-p.Retention)); err != nil && ctx.Err() == nil {
+			log.Printf("failed to purge trash: %s\n", err.Error())
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,41 @@
+package server
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type trashFunc func(ctx context.Context, before time.Time) error
+
+func (f trashFunc) PurgeTrash(ctx context.Context, before time.Time) error {
+	return f(ctx, before)
+}
+
+func TestTrashPurger(t *testing.T) {
+	var (
+		purges = make(chan time.Time, 1)
+		purger = TrashPurger{
+			Trash: trashFunc(func(_ context.Context, before time.Time) error {
+				purges <- before
+				return nil
+			}),
+			Retention: time.Hour,
+			Interval:  time.Hour,
+		}
+	)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- purger.Run(ctx)
+	}()
+
+	before := <-purges
+	assert.WithinDuration(t, time.Now().Add(-time.Hour), before, time.Minute, "unexpected purge threshold")
+
+	cancel()
+	assert.ErrorIs(t, <-done, context.Canceled, "unexpected error")
+}
@@ -0,0 +1,139 @@
+package server
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+
+	"github.com/kerelape/gophkeeper/pkg/gophkeeper"
+	"github.com/kerelape/gophkeeper/pkg/gophkeeper/kdf"
+)
+
+const (
+	vaultCheckSaltLen = 16
+	vaultCheckKeyLen  = 32
+)
+
+// vaultCheckPlaintext is the known plaintext sealed by a vault check.
+var vaultCheckPlaintext = ([]byte)("gophkeeper vault")
+
+// vaultCheckKDFMarker starts the checks that record their KDF,
+// the checks made before start with the salt and use kdf.Legacy.
+var vaultCheckKDFMarker = ([]byte)("gophkeeper-kdf:")
+
+// ErrMalformedVaultCheck is returned when a vault check can not be parsed.
+var ErrMalformedVaultCheck = errors.New("malformed vault check")
+
+// NewVaultCheck returns a check value of the vault password,
+// it is a known plaintext encrypted with a key derived
+// from the password by kdf.Default.
+func NewVaultCheck(password string) ([]byte, error) {
+	params, paramsError := json.Marshal(kdf.Default)
+	if paramsError != nil {
+		return nil, paramsError
+	}
+	salt := make([]byte, vaultCheckSaltLen)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	key, keyError := kdf.Default.Key(password, salt, vaultCheckKeyLen)
+	if keyError != nil {
+		return nil, keyError
+	}
+	aead, aeadError := vaultCheckAEAD(key)
+	if aeadError != nil {
+		return nil, aeadError
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	check := append([]byte{}, vaultCheckKDFMarker...)
+	check = binary.BigEndian.AppendUint16(check, (uint16)(len(params)))
+	check = append(check, params...)
+	check = append(check, salt...)
+	check = append(check, nonce...)
+	return aead.Seal(check, nonce, vaultCheckPlaintext, nil), nil
+}
+
+// VerifyVaultCheck returns gophkeeper.ErrBadCredential
+// unless the check is made by NewVaultCheck with the password.
+func VerifyVaultCheck(check []byte, password string) error {
+	key, keyError := VaultCheckKey(check, password)
+	if keyError != nil {
+		return keyError
+	}
+	return VerifyVaultCheckKey(check, key)
+}
+
+// VaultCheckKey derives the key of the password the check is verified with.
+//
+// Deriving the key is what makes verifying the check expensive,
+// the key verifies the check cheaply with VerifyVaultCheckKey.
+func VaultCheckKey(check []byte, password string) ([]byte, error) {
+	keyDerivation, rest, parseError := parseVaultCheckKDF(check)
+	if parseError != nil {
+		return nil, parseError
+	}
+	if len(rest) < vaultCheckSaltLen {
+		return nil, ErrMalformedVaultCheck
+	}
+	return keyDerivation.Key(password, rest[:vaultCheckSaltLen], vaultCheckKeyLen)
+}
+
+// VerifyVaultCheckKey returns gophkeeper.ErrBadCredential unless the key
+// is derived by VaultCheckKey from the password the check is made with.
+func VerifyVaultCheckKey(check []byte, key []byte) error {
+	_, rest, parseError := parseVaultCheckKDF(check)
+	if parseError != nil {
+		return parseError
+	}
+	if len(rest) < vaultCheckSaltLen {
+		return ErrMalformedVaultCheck
+	}
+	aead, aeadError := vaultCheckAEAD(key)
+	if aeadError != nil {
+		return aeadError
+	}
+	sealed := rest[vaultCheckSaltLen:]
+	if len(sealed) < aead.NonceSize() {
+		return ErrMalformedVaultCheck
+	}
+	if _, err := aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], nil); err != nil {
+		return errors.Join(gophkeeper.ErrBadCredential, err)
+	}
+	return nil
+}
+
+// parseVaultCheckKDF returns the KDF of the check and the rest of it.
+func parseVaultCheckKDF(check []byte) (kdf.KDF, []byte, error) {
+	if !bytes.HasPrefix(check, vaultCheckKDFMarker) {
+		return kdf.Legacy, check, nil
+	}
+	check = check[len(vaultCheckKDFMarker):]
+	if len(check) < 2 {
+		return kdf.KDF{}, nil, ErrMalformedVaultCheck
+	}
+	size := (int)(binary.BigEndian.Uint16(check))
+	check = check[2:]
+	if len(check) < size {
+		return kdf.KDF{}, nil, ErrMalformedVaultCheck
+	}
+	var keyDerivation kdf.KDF
+	if err := json.Unmarshal(check[:size], &keyDerivation); err != nil {
+		return kdf.KDF{}, nil, errors.Join(ErrMalformedVaultCheck, err)
+	}
+	return keyDerivation, check[size:], nil
+}
+
+func vaultCheckAEAD(key []byte) (cipher.AEAD, error) {
+	block, blockError := aes.NewCipher(key)
+	if blockError != nil {
+		return nil, blockError
+	}
+	return cipher.NewGCM(block)
+}
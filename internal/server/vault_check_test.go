@@ -0,0 +1,50 @@
+package server
+
+import (
+	"testing"
+
+	"github.com/kerelape/gophkeeper/pkg/gophkeeper"
+	"github.com/kerelape/gophkeeper/pkg/gophkeeper/kdf"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestVaultCheck(t *testing.T) {
+	check, checkError := NewVaultCheck("qwerty")
+	assert.Nil(t, checkError, "did not expect an error")
+
+	assert.Nil(t, VerifyVaultCheck(check, "qwerty"), "expected the password to be accepted")
+	assert.ErrorIs(t, VerifyVaultCheck(check, "ytrewq"), gophkeeper.ErrBadCredential, "unexpected error")
+	assert.ErrorIs(t, VerifyVaultCheck(check[:4], "qwerty"), ErrMalformedVaultCheck, "unexpected error")
+
+	other, otherError := NewVaultCheck("qwerty")
+	assert.Nil(t, otherError, "did not expect an error")
+	assert.NotEqual(t, check, other, "expected checks to be salted")
+
+	// The checks made before the KDF was recorded use kdf.Legacy.
+	salt := ([]byte)("0123456789abcdef")
+	key, keyError := kdf.Legacy.Key("qwerty", salt, vaultCheckKeyLen)
+	assert.Nil(t, keyError, "did not expect an error")
+	aead, aeadError := vaultCheckAEAD(key)
+	assert.Nil(t, aeadError, "did not expect an error")
+	nonce := make([]byte, aead.NonceSize())
+	legacy := aead.Seal(append(salt, nonce...), nonce, vaultCheckPlaintext, nil)
+	assert.Nil(t, VerifyVaultCheck(legacy, "qwerty"), "expected the password to be accepted")
+	assert.ErrorIs(t, VerifyVaultCheck(legacy, "ytrewq"), gophkeeper.ErrBadCredential, "unexpected error")
+}
+
+func TestVaultCheckKey(t *testing.T) {
+	check, checkError := NewVaultCheck("qwerty")
+	assert.Nil(t, checkError, "did not expect an error")
+
+	key, keyError := VaultCheckKey(check, "qwerty")
+	assert.Nil(t, keyError, "did not expect an error")
+	assert.Nil(t, VerifyVaultCheckKey(check, key), "expected the key to be accepted")
+
+	wrong, wrongError := VaultCheckKey(check, "ytrewq")
+	assert.Nil(t, wrongError, "did not expect an error")
+	assert.ErrorIs(t, VerifyVaultCheckKey(check, wrong), gophkeeper.ErrBadCredential, "unexpected error")
+
+	other, otherError := NewVaultCheck("qwerty")
+	assert.Nil(t, otherError, "did not expect an error")
+	assert.ErrorIs(t, VerifyVaultCheckKey(other, key), gophkeeper.ErrBadCredential, "expected the key to be bound to the check")
+}
@@ -0,0 +1,29 @@
+package server
+
+import "context"
+
+// VaultUnlocker is an identity that derives a vault key from its vault
+// password, the key verifies the password cheaply afterwards in the
+// contexts made by WithVaultKey.
+type VaultUnlocker interface {
+	// UnlockVault returns the vault key of the password, it fails
+	// with gophkeeper.ErrBadCredential if the password is wrong.
+	//
+	// An identity that verifies the password cheaply
+	// may return no key.
+	UnlockVault(ctx context.Context, password string) ([]byte, error)
+}
+
+type vaultKeyContextKey struct{}
+
+// WithVaultKey returns the context in which the vault password
+// is verified with the vault key instead of being derived again.
+func WithVaultKey(ctx context.Context, key []byte) context.Context {
+	return context.WithValue(ctx, vaultKeyContextKey{}, key)
+}
+
+// VaultKey returns the vault key of the context, nil if there is none.
+func VaultKey(ctx context.Context) []byte {
+	key, _ := ctx.Value(vaultKeyContextKey{}).([]byte)
+	return key
+}
@@ -0,0 +1,18 @@
+package gophkeeper
+
+import "context"
+
+type withoutCompressionKey struct{}
+
+// WithoutCompression returns the context the blobs are stored
+// with as they are, for the content that is compressed already.
+func WithoutCompression(ctx context.Context) context.Context {
+	return context.WithValue(ctx, withoutCompressionKey{}, true)
+}
+
+// CompressionDisabled reports whether the blobs
+// are stored with the context as they are.
+func CompressionDisabled(ctx context.Context) bool {
+	disabled, _ := ctx.Value(withoutCompressionKey{}).(bool)
+	return disabled
+}
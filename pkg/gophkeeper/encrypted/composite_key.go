@@ -0,0 +1,22 @@
+package encrypted
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+)
+
+// CompositeKey returns the composite key of the password and the content
+// of the key file, so the keys derived from it need both of them.
+//
+// As in KeePass, the composite key is the hash
+// of the hashes of the password and the key file.
+func CompositeKey(password string, keyFile []byte) string {
+	var (
+		passwordHash = sha256.Sum256(([]byte)(password))
+		keyFileHash  = sha256.Sum256(keyFile)
+		composite    = sha256.New()
+	)
+	composite.Write(passwordHash[:])
+	composite.Write(keyFileHash[:])
+	return base64.RawStdEncoding.EncodeToString(composite.Sum(nil))
+}
@@ -0,0 +1,91 @@
+package encrypted
+
+import (
+	"compress/gzip"
+	"errors"
+	"io"
+	"sync"
+
+	"github.com/kerelape/gophkeeper/pkg/gophkeeper"
+)
+
+// compressionGzip is the compression of the blobs
+// compressed with gzip before they are encrypted.
+const compressionGzip = "gzip"
+
+// ErrUnknownCompression is returned when the content is
+// compressed with a compression the identity does not know.
+var ErrUnknownCompression = errors.New("unknown compression")
+
+// compressingReader reads the origin compressed with gzip. The origin
+// is compressed once the reader is read, closing the reader stops it.
+type compressingReader struct {
+	origin io.Reader
+
+	once   sync.Once
+	reader *io.PipeReader
+}
+
+func newCompressingReader(origin io.Reader) *compressingReader {
+	return &compressingReader{
+		origin: origin,
+	}
+}
+
+// Read implements io.Reader.
+func (r *compressingReader) Read(p []byte) (int, error) {
+	r.once.Do(r.start)
+	return r.reader.Read(p)
+}
+
+// Close implements io.Closer.
+func (r *compressingReader) Close() error {
+	r.once.Do(func() {})
+	if r.reader == nil {
+		return nil
+	}
+	return r.reader.Close()
+}
+
+// start starts compressing the origin.
+func (r *compressingReader) start() {
+	reader, writer := io.Pipe()
+	go func() {
+		compressor := gzip.NewWriter(writer)
+		_, err := io.Copy(compressor, r.origin)
+		if err == nil {
+			err = compressor.Close()
+		}
+		writer.CloseWithError(err)
+	}()
+	r.reader = reader
+}
+
+// newDecompressingReader returns a reader
+// of the origin decompressed with the compression.
+func newDecompressingReader(compression string, origin io.Reader) (io.Reader, error) {
+	switch compression {
+	case "":
+		return origin, nil
+	case compressionGzip:
+		reader, readerError := gzip.NewReader(origin)
+		if readerError != nil {
+			return nil, errors.Join(gophkeeper.ErrIntegrity, readerError)
+		}
+		return reader, nil
+	default:
+		return nil, ErrUnknownCompression
+	}
+}
+
+// closers closes every closer.
+type closers []io.Closer
+
+// Close implements io.Closer.
+func (c closers) Close() error {
+	errs := make([]error, 0, len(c))
+	for _, closer := range c {
+		errs = append(errs, closer.Close())
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,58 @@
+package encrypted
+
+import (
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/sha256"
+	"io"
+
+	"github.com/kerelape/gophkeeper/internal/chunked"
+)
+
+var (
+	// convergenceSalt separates the key the data keys of the
+	// deduplicated blobs are derived with from the index key
+	// it is derived from.
+	convergenceSalt = ([]byte)("gophkeeper convergence")
+
+	// convergentNonceSalt separates the nonce prefix of
+	// a deduplicated blob from the data key it is derived from.
+	convergentNonceSalt = ([]byte)("gophkeeper convergent nonce")
+)
+
+// newConvergenceKey derives the convergence key from the index key.
+func newConvergenceKey(indexKey []byte) []byte {
+	mac := hmac.New(sha256.New, indexKey)
+	mac.Write(convergenceSalt)
+	return mac.Sum(nil)
+}
+
+// convergentKey returns the data key of the content, the identical
+// content of the vault is given the same key and the content of other
+// vaults is not. The content is read to its end and then sought back.
+func convergentKey(convergenceKey []byte, content io.ReadSeeker) ([]byte, error) {
+	start, startError := content.Seek(0, io.SeekCurrent)
+	if startError != nil {
+		return nil, startError
+	}
+	mac := hmac.New(sha256.New, convergenceKey)
+	if _, err := io.Copy(mac, content); err != nil {
+		return nil, err
+	}
+	if _, err := content.Seek(start, io.SeekStart); err != nil {
+		return nil, err
+	}
+	return mac.Sum(nil), nil
+}
+
+// convergentNoncePrefix returns the nonce prefix of the content encrypted
+// with the data key returned by convergentKey. The key encrypts nothing
+// but the content it is derived from, so the prefix is derived from it too
+// and the identical content is encrypted the same way.
+func convergentNoncePrefix(key []byte) func(aead cipher.AEAD) ([]byte, error) {
+	return func(aead cipher.AEAD) ([]byte, error) {
+		mac := hmac.New(sha256.New, key)
+		mac.Write(convergentNonceSalt)
+		return mac.Sum(nil)[:aead.NonceSize()-chunked.NonceSuffixLen], nil
+	}
+}
@@ -0,0 +1,25 @@
+package encrypted
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+)
+
+// GCMCipher is an AES-GCM AEADCipher.
+type GCMCipher struct{}
+
+var _ AEADCipher = (*GCMCipher)(nil)
+
+// Name implements AEADCipher.
+func (c GCMCipher) Name() string {
+	return "aes-256-gcm"
+}
+
+// AEAD implements AEADCipher.
+func (c GCMCipher) AEAD(key []byte) (cipher.AEAD, error) {
+	block, blockError := aes.NewCipher(key)
+	if blockError != nil {
+		return nil, blockError
+	}
+	return cipher.NewGCM(block)
+}
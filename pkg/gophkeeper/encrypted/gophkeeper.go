@@ -0,0 +1,93 @@
+package encrypted
+
+import (
+	"context"
+	"errors"
+
+	"github.com/kerelape/gophkeeper/pkg/gophkeeper"
+	"github.com/kerelape/gophkeeper/pkg/gophkeeper/kdf"
+)
+
+// Gophkeeper is an ecrypted Gophkeeper.
+//
+// If there is a Verifier, the origin receives the verifier
+// of the vault password instead of it, see Identity.
+//
+// If there is a KeyFile, the vault is opened with the composite
+// key of the vault password and the key file, see CompositeKey.
+//
+// Every vault registered by the Gophkeeper gets a random vault salt,
+// the origin keeps it in the account settings of the identity.
+type Gophkeeper struct {
+	Origin   gophkeeper.Gophkeeper
+	Cipher   Cipher
+	AEAD     AEADCipher
+	KDF      kdf.KDF
+	Verifier func(password string, vaultSalt []byte) (string, error)
+	KeyFile  []byte
+}
+
+var _ gophkeeper.Gophkeeper = (*Gophkeeper)(nil)
+
+// ErrNoVaultPassword is returned when an identity is registered
+// without the vault password, the login password the origin
+// receives must not open the vault.
+var ErrNoVaultPassword = errors.New("vault password is not set")
+
+// Authenticate implements gophkeeper.Gophkeeper.
+func (g Gophkeeper) Authenticate(ctx context.Context, credential gophkeeper.Credential) (gophkeeper.Token, error) {
+	return g.Origin.Authenticate(ctx, credential)
+}
+
+// Identity implements gophkeeper.Gophkeeper.
+//
+// The vault salt of the identity is read
+// from the account settings of the origin.
+func (g Gophkeeper) Identity(ctx context.Context, token gophkeeper.Token) (gophkeeper.Identity, error) {
+	origin, originError := g.Origin.Identity(ctx, token)
+	if originError != nil {
+		return nil, originError
+	}
+	settings, settingsError := origin.Settings(ctx)
+	if settingsError != nil {
+		return nil, settingsError
+	}
+	identity := Identity{
+		Origin:    origin,
+		Cipher:    g.Cipher,
+		AEAD:      g.AEAD,
+		KDF:       g.KDF,
+		Verifier:  g.Verifier,
+		KeyFile:   g.KeyFile,
+		VaultSalt: settings.VaultSalt,
+	}
+	return identity, nil
+}
+
+// Register implements gophkeeper.Gophkeeper.
+//
+// The vault password must be set, it fails with ErrNoVaultPassword
+// otherwise. The origin receives its verifier if there is a Verifier.
+// The account settings of an identity registered with a key file
+// are to be updated once it is authenticated.
+func (g Gophkeeper) Register(ctx context.Context, credential gophkeeper.Credential) error {
+	if credential.VaultPassword == "" {
+		return ErrNoVaultPassword
+	}
+	vaultSalt, vaultSaltError := newVaultSalt()
+	if vaultSaltError != nil {
+		return vaultSaltError
+	}
+	credential.VaultSalt = vaultSalt
+	if g.KeyFile != nil {
+		credential.VaultPassword = CompositeKey(credential.VaultPassword, g.KeyFile)
+	}
+	if g.Verifier != nil {
+		verifier, verifierError := g.Verifier(credential.VaultPassword, credential.VaultSalt)
+		if verifierError != nil {
+			return verifierError
+		}
+		credential.VaultPassword = verifier
+	}
+	return g.Origin.Register(ctx, credential)
+}
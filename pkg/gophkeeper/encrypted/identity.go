@@ -0,0 +1,1192 @@
+package encrypted
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/json"
+	"errors"
+	"io"
+	"slices"
+	"strings"
+
+	"github.com/kerelape/gophkeeper/internal/chunked"
+	composedreadcloser "github.com/kerelape/gophkeeper/internal/composed_read_closer"
+	"github.com/kerelape/gophkeeper/pkg/gophkeeper"
+	encryption "github.com/kerelape/gophkeeper/pkg/gophkeeper/encrypted/internal"
+	"github.com/kerelape/gophkeeper/pkg/gophkeeper/kdf"
+)
+
+const (
+	// envelopeVersion is the newest version of the envelope.
+	//
+	// Envelopes of version 1 have no version, no header and no cipher
+	// and key wrapping scheme, those are implied by the fields present.
+	// Envelopes of version 2 have the meta in clear text.
+	envelopeVersion = 3
+
+	// streamCipherName is the cipher of the content encrypted
+	// with the Cipher of the identity.
+	streamCipherName = "aes-256-cfb"
+
+	// wrapNone is the key wrapping scheme of the data keys
+	// that are the keys derived from the password.
+	wrapNone = "none"
+
+	// wrapAESGCM is the key wrapping scheme of the data keys
+	// sealed with AES-GCM under the key derived from the password.
+	wrapAESGCM = "aes-256-gcm"
+)
+
+// meta is the envelope the meta of a resource is wrapped into.
+//
+// The header of the envelope records the cipher the content is encrypted
+// with, the KDF that derives the key-encryption key from the password and
+// the salt, and the scheme the data key is wrapped with under that key.
+// For an AEAD cipher the IV is the nonce prefix of the content chunks.
+//
+// The meta is sealed with the meta key derived from the password by the
+// meta KDF, so the origin only knows the type of the resource. The meta
+// of the envelopes upgraded without the password stays in clear text
+// until the resource is written again.
+//
+// The terms the resource is indexed by are sealed along with the meta,
+// so the index can be rebuilt when the vault password changes.
+//
+// The compression of the blobs compressed before they are encrypted
+// is recorded in the header, they are decompressed once decrypted.
+//
+// Envelopes of version 1 have no header. Their content is encrypted with
+// the AEAD if there is one and with the Cipher of the identity otherwise,
+// their key is derived by kdf.Legacy if there is no KDF, and their data
+// key is the derived key itself if there is no wrapped key.
+type meta struct {
+	Version int      `json:"v,omitempty"`
+	Cipher  string   `json:"cipher,omitempty"`
+	KDF     *kdf.KDF `json:"kdf,omitempty"`
+	Wrap    string   `json:"wrap,omitempty"`
+	IV      []byte   `json:"iv"`
+	Salt    []byte   `json:"salt"`
+	Key     []byte   `json:"key,omitempty"`
+	AEAD    string   `json:"aead,omitempty"`
+	MetaKDF *kdf.KDF `json:"meta_kdf,omitempty"`
+	Sealed  []byte   `json:"sealed,omitempty"`
+	Terms   []byte   `json:"terms,omitempty"`
+	Content string   `json:"content,omitempty"`
+
+	Compression string `json:"compression,omitempty"`
+}
+
+// parseMeta parses the envelope of any version
+// and returns it in the newest one.
+func parseMeta(wrappedMeta string) (meta, error) {
+	var m meta
+	if err := json.Unmarshal(([]byte)(wrappedMeta), &m); err != nil {
+		return meta{}, errors.Join(ErrMalformedEnvelope, err)
+	}
+	return m.upgrade()
+}
+
+// upgrade returns the envelope in the newest version.
+func (m meta) upgrade() (meta, error) {
+	switch m.Version {
+	case 0, 1:
+		if len(m.Salt) == 0 {
+			return meta{}, ErrMalformedEnvelope
+		}
+		m.Cipher, m.AEAD = m.AEAD, ""
+		if m.Cipher == "" {
+			m.Cipher = streamCipherName
+		}
+		if m.KDF == nil {
+			legacy := kdf.Legacy
+			m.KDF = &legacy
+		}
+		m.Wrap = wrapAESGCM
+		if m.Key == nil {
+			m.Wrap = wrapNone
+		}
+	case 2, envelopeVersion:
+		if m.Cipher == "" || m.KDF == nil || m.Wrap == "" || len(m.Salt) == 0 {
+			return meta{}, ErrMalformedEnvelope
+		}
+		if (m.Sealed != nil || m.Terms != nil) && (m.Version == 2 || m.MetaKDF == nil) {
+			return meta{}, ErrMalformedEnvelope
+		}
+	default:
+		return meta{}, ErrUnknownEnvelopeVersion
+	}
+	m.Version = envelopeVersion
+	return m, nil
+}
+
+// IsEnvelope reports whether the meta is wrapped into an envelope.
+func IsEnvelope(wrappedMeta string) bool {
+	_, err := parseMeta(wrappedMeta)
+	return err == nil
+}
+
+// UpgradeEnvelope returns the wrapped meta of a resource in the newest
+// envelope version, the wrapped meta already in it is returned as is.
+//
+// Only the header of the envelope is rewritten, so no password is needed,
+// the keys and the content are kept and are moved to the KDF and the
+// cipher of the identity the next time the resource is written.
+func UpgradeEnvelope(wrappedMeta string) (string, error) {
+	m, parseError := parseMeta(wrappedMeta)
+	if parseError != nil {
+		return "", parseError
+	}
+	var header struct {
+		Version int `json:"v"`
+	}
+	if err := json.Unmarshal(([]byte)(wrappedMeta), &header); err != nil {
+		return "", err
+	}
+	if header.Version == envelopeVersion {
+		return wrappedMeta, nil
+	}
+	upgradedMeta, marshalError := json.Marshal(m)
+	if marshalError != nil {
+		return "", marshalError
+	}
+	return (string)(upgradedMeta), nil
+}
+
+// seal seals the meta and the terms with the meta key derived by the KDF.
+func (m meta) seal(content string, terms []string, keys *metaKeys, keyDerivation kdf.KDF) (meta, error) {
+	key, keyError := keys.key(keyDerivation)
+	if keyError != nil {
+		return meta{}, keyError
+	}
+	sealed, sealError := encryption.Seal(([]byte)(content), key)
+	if sealError != nil {
+		return meta{}, sealError
+	}
+	m.MetaKDF, m.Sealed, m.Content, m.Terms = &keyDerivation, sealed, "", nil
+	if len(terms) > 0 {
+		encodedTerms, encodeError := json.Marshal(terms)
+		if encodeError != nil {
+			return meta{}, encodeError
+		}
+		sealedTerms, sealTermsError := encryption.Seal(encodedTerms, key)
+		if sealTermsError != nil {
+			return meta{}, sealTermsError
+		}
+		m.Terms = sealedTerms
+	}
+	return m, nil
+}
+
+// open returns the meta, opening it with the meta keys if it is sealed.
+func (m meta) open(keys *metaKeys) (string, error) {
+	if m.Sealed == nil {
+		return m.Content, nil
+	}
+	if keys == nil {
+		return "", ErrLocked
+	}
+	key, keyError := keys.key(*m.MetaKDF)
+	if keyError != nil {
+		return "", keyError
+	}
+	content, openError := encryption.Open(m.Sealed, key)
+	if openError != nil {
+		return "", errors.Join(gophkeeper.ErrBadCredential, openError)
+	}
+	return (string)(content), nil
+}
+
+// terms returns the terms the resource is indexed by.
+func (m meta) terms(keys *metaKeys) ([]string, error) {
+	if m.Terms == nil {
+		return nil, nil
+	}
+	if keys == nil {
+		return nil, ErrLocked
+	}
+	key, keyError := keys.key(*m.MetaKDF)
+	if keyError != nil {
+		return nil, keyError
+	}
+	encodedTerms, openError := encryption.Open(m.Terms, key)
+	if openError != nil {
+		return nil, errors.Join(gophkeeper.ErrBadCredential, openError)
+	}
+	var terms []string
+	if err := json.Unmarshal(encodedTerms, &terms); err != nil {
+		return nil, errors.Join(ErrMalformedEnvelope, err)
+	}
+	return terms, nil
+}
+
+// dataKey returns the data key of the resource.
+func (m meta) dataKey(keys *metaKeys) ([]byte, error) {
+	kek, kekError := keys.kek(*m.KDF, m.Salt)
+	if kekError != nil {
+		return nil, kekError
+	}
+	switch m.Wrap {
+	case wrapNone:
+		return kek, nil
+	case wrapAESGCM:
+		key, unwrapError := encryption.Unwrap(m.Key, kek)
+		if unwrapError != nil {
+			return nil, errors.Join(gophkeeper.ErrBadCredential, unwrapError)
+		}
+		return key, nil
+	default:
+		return nil, ErrUnknownKeyWrap
+	}
+}
+
+// wrap wraps the data key with the key-encryption key
+// derived from the password of the keys by the KDF.
+func (m meta) wrap(key []byte, keys *metaKeys, keyDerivation kdf.KDF) (meta, error) {
+	salt, saltError := keys.salt()
+	if saltError != nil {
+		return meta{}, saltError
+	}
+	kek, kekError := keys.kek(keyDerivation, salt)
+	if kekError != nil {
+		return meta{}, kekError
+	}
+	wrapped, wrapError := encryption.Wrap(key, kek)
+	if wrapError != nil {
+		return meta{}, wrapError
+	}
+	m.Salt, m.KDF, m.Wrap, m.Key = salt, &keyDerivation, wrapAESGCM, wrapped
+	return m, nil
+}
+
+// rewrap wraps the data key with the new password,
+// the content encrypted with it is kept as is.
+func (m meta) rewrap(oldKeys, newKeys *metaKeys, keyDerivation kdf.KDF) (meta, error) {
+	key, keyError := m.dataKey(oldKeys)
+	if keyError != nil {
+		return meta{}, keyError
+	}
+	return m.wrap(key, newKeys, keyDerivation)
+}
+
+// Cipher is a factory of encrypter and pairing decrypters.
+type Cipher interface {
+	// Encrypter returns encrypting Stream.
+	Encrypter(block cipher.Block, iv []byte) cipher.Stream
+
+	// Decrypter returns decrypting Stream.
+	Decrypter(block cipher.Block, iv []byte) cipher.Stream
+}
+
+// AEADCipher is a factory of authenticated ciphers.
+type AEADCipher interface {
+	// Name returns the name the content is marked with
+	// to be decrypted with the cipher.
+	Name() string
+
+	// AEAD returns the authenticated cipher of the key.
+	AEAD(key []byte) (cipher.AEAD, error)
+}
+
+// aeadCiphers are the known AEAD ciphers by their names.
+var aeadCiphers = map[string]AEADCipher{
+	GCMCipher{}.Name():               GCMCipher{},
+	XChaCha20Poly1305Cipher{}.Name(): XChaCha20Poly1305Cipher{},
+}
+
+// Identity is an encrpypted gophkeeper Identity.
+//
+// The content is encrypted with the AEAD, AES-GCM if it is nil,
+// so that tampering with it fails with gophkeeper.ErrIntegrity.
+// The Cipher decrypts the content stored before that.
+//
+// The keys are derived from the password by the KDF, kdf.Default if it
+// is zero. The KDF is stored along with every resource, so the resources
+// stored with another one are still decrypted and are moved to the KDF
+// the next time they are written.
+//
+// The origin receives the password as is unless there is a Verifier,
+// then it receives the verifier of the password instead, so that the
+// password never leaves the identity.
+//
+// The meta is encrypted too, so the resources are listed only by the
+// identity unlocked with the password, see Unlock.
+//
+// If there is a KeyFile, the keys and the verifier are derived from the
+// composite key of the password and the key file, see CompositeKey.
+//
+// The keys of the meta and the index and the verifier are salted with
+// the VaultSalt, the one of the account settings of the origin.
+type Identity struct {
+	Origin    gophkeeper.Identity
+	Cipher    Cipher
+	AEAD      AEADCipher
+	KDF       kdf.KDF
+	Verifier  func(password string, vaultSalt []byte) (string, error)
+	KeyFile   []byte
+	VaultSalt []byte
+
+	unlocked *metaKeys
+}
+
+var (
+	_ gophkeeper.Identity = (*Identity)(nil)
+	_ gophkeeper.Unlocker = (*Identity)(nil)
+	_ gophkeeper.Locker   = (*Identity)(nil)
+)
+
+var (
+	// ErrReencryptionUnsupported is returned when the vault password is changed
+	// but the origin can not re-encrypt the stored content.
+	ErrReencryptionUnsupported = errors.New("origin does not support re-encryption")
+
+	// ErrUnknownAEAD is returned when the content is encrypted
+	// with an AEAD cipher the identity does not know.
+	ErrUnknownAEAD = errors.New("unknown AEAD cipher")
+
+	// ErrUnknownKeyWrap is returned when the data key is wrapped
+	// with a scheme the identity does not know.
+	ErrUnknownKeyWrap = errors.New("unknown key wrapping scheme")
+
+	// ErrUnknownEnvelopeVersion is returned when the meta is
+	// wrapped into an envelope newer than the identity knows.
+	ErrUnknownEnvelopeVersion = errors.New("unknown envelope version")
+
+	// ErrMalformedEnvelope is returned when the meta
+	// is not wrapped into a valid envelope.
+	ErrMalformedEnvelope = errors.New("malformed envelope")
+
+	// ErrLocked is returned when the resources with encrypted
+	// meta are listed, searched or tagged by an identity
+	// that is not unlocked.
+	ErrLocked = errors.New("identity is locked")
+)
+
+// Unlock implements gophkeeper.Unlocker.
+//
+// The identity it returns decrypts the meta of the listed resources
+// with the password, the keys, the verifier and the key-encryption keys
+// of the password are derived once and are reused afterwards.
+//
+// If the origin is a gophkeeper.Unlocker, a vault session is opened
+// with the verifier and the origin passes it instead of the verifier,
+// a wrong password fails with gophkeeper.ErrBadCredential then. It is not
+// detected until the meta is decrypted otherwise, then listing fails
+// with gophkeeper.ErrBadCredential.
+func (i Identity) Unlock(ctx context.Context, password string) (gophkeeper.Identity, error) {
+	i.unlocked = newMetaKeys(i.secret(password), i.VaultSalt)
+	if _, err := i.unlocked.key(i.keyDerivation()); err != nil {
+		return nil, err
+	}
+	if origin, ok := i.Origin.(gophkeeper.Unlocker); ok {
+		verifier, verifierError := i.verifier(i.unlocked.password)
+		if verifierError != nil {
+			return nil, verifierError
+		}
+		session, sessionError := origin.Unlock(ctx, verifier)
+		if sessionError != nil {
+			return nil, sessionError
+		}
+		i.Origin = session
+	}
+	return i, nil
+}
+
+// Lock implements gophkeeper.Locker.
+//
+// The vault session of the origin is revoked,
+// the identity must not be used afterwards.
+func (i Identity) Lock(ctx context.Context) error {
+	origin, ok := i.Origin.(gophkeeper.Locker)
+	if !ok || i.unlocked == nil {
+		return nil
+	}
+	return origin.Lock(ctx)
+}
+
+// Locked reports whether the identity is not unlocked.
+func (i Identity) Locked() bool {
+	return i.unlocked == nil
+}
+
+// WithKeyFile returns the identity that derives the keys from
+// the composite key of the password and the key file, it has
+// to be unlocked again.
+func (i Identity) WithKeyFile(keyFile []byte) gophkeeper.Identity {
+	i.KeyFile, i.unlocked = keyFile, nil
+	return i
+}
+
+// StorePiece implements gophkeeper.Identity.
+func (i Identity) StorePiece(ctx context.Context, piece gophkeeper.Piece, password string) (gophkeeper.ResourceID, error) {
+	password = i.secret(password)
+	verifier, verifierError := i.verifier(password)
+	if verifierError != nil {
+		return -1, verifierError
+	}
+	encryptedPiece, encryptError := i.encryptPiece(piece, password)
+	if encryptError != nil {
+		return -1, encryptError
+	}
+	return i.Origin.StorePiece(ctx, encryptedPiece, verifier)
+}
+
+// RestorePiece implements gophkeeper.Identity.
+func (i Identity) RestorePiece(ctx context.Context, rid gophkeeper.ResourceID, password string) (gophkeeper.Piece, error) {
+	password = i.secret(password)
+	verifier, verifierError := i.verifier(password)
+	if verifierError != nil {
+		return gophkeeper.Piece{}, verifierError
+	}
+	piece, pieceError := i.Origin.RestorePiece(ctx, rid, verifier)
+	if pieceError != nil {
+		return gophkeeper.Piece{}, pieceError
+	}
+	return i.decryptPiece(piece, password)
+}
+
+// StoreBlob implements gophkeeper.Identity.
+func (i Identity) StoreBlob(ctx context.Context, blob gophkeeper.Blob, password string) (gophkeeper.ResourceID, error) {
+	password = i.secret(password)
+	verifier, verifierError := i.verifier(password)
+	if verifierError != nil {
+		return -1, verifierError
+	}
+	settings, settingsError := i.blobSettings(ctx)
+	if settingsError != nil {
+		return -1, settingsError
+	}
+	encryptedBlob, encryptError := i.encryptBlob(blob, password, settings)
+	if encryptError != nil {
+		return -1, encryptError
+	}
+	return i.Origin.StoreBlob(ctx, encryptedBlob, verifier)
+}
+
+// RestoreBlob implements gophkeeper.Identity.
+func (i Identity) RestoreBlob(ctx context.Context, rid gophkeeper.ResourceID, password string) (gophkeeper.Blob, error) {
+	password = i.secret(password)
+	verifier, verifierError := i.verifier(password)
+	if verifierError != nil {
+		return gophkeeper.Blob{}, verifierError
+	}
+	blob, blobError := i.Origin.RestoreBlob(ctx, rid, verifier)
+	if blobError != nil {
+		return gophkeeper.Blob{}, blobError
+	}
+	decryptedBlob, decryptError := i.decryptBlob(blob, password)
+	if decryptError != nil {
+		blob.Content.Close()
+		return gophkeeper.Blob{}, decryptError
+	}
+	return decryptedBlob, nil
+}
+
+// UpdatePiece implements gophkeeper.Identity.
+func (i Identity) UpdatePiece(ctx context.Context, rid gophkeeper.ResourceID, piece gophkeeper.Piece, password string) error {
+	password = i.secret(password)
+	verifier, verifierError := i.verifier(password)
+	if verifierError != nil {
+		return verifierError
+	}
+	encryptedPiece, encryptError := i.encryptPiece(piece, password)
+	if encryptError != nil {
+		return encryptError
+	}
+	return i.Origin.UpdatePiece(ctx, rid, encryptedPiece, verifier)
+}
+
+// UpdateBlob implements gophkeeper.Identity.
+func (i Identity) UpdateBlob(ctx context.Context, rid gophkeeper.ResourceID, blob gophkeeper.Blob, password string) error {
+	password = i.secret(password)
+	verifier, verifierError := i.verifier(password)
+	if verifierError != nil {
+		return verifierError
+	}
+	settings, settingsError := i.blobSettings(ctx)
+	if settingsError != nil {
+		return settingsError
+	}
+	encryptedBlob, encryptError := i.encryptBlob(blob, password, settings)
+	if encryptError != nil {
+		return encryptError
+	}
+	return i.Origin.UpdateBlob(ctx, rid, encryptedBlob, verifier)
+}
+
+// List implements gophkeeper.Identity.
+//
+// The origin stores wrapped meta, so the meta prefix is matched
+// after unwrapping and pages may hold fewer resources than the limit.
+// The encrypted meta and the sealed tags are opened only if the
+// identity is unlocked, it fails with ErrLocked otherwise.
+func (i Identity) List(ctx context.Context, options gophkeeper.ListOptions) (gophkeeper.ListPage, error) {
+	prefix := options.MetaPrefix
+	options.MetaPrefix = ""
+	if options.Tag != "" {
+		if i.unlocked == nil {
+			return gophkeeper.ListPage{}, ErrLocked
+		}
+		sealed, sealError := i.unlocked.sealName(options.Tag)
+		if sealError != nil {
+			return gophkeeper.ListPage{}, sealError
+		}
+		options.Tag = sealed
+	}
+	page, pageError := i.Origin.List(ctx, options)
+	if pageError != nil {
+		return gophkeeper.ListPage{}, pageError
+	}
+	resources, openError := i.openResources(page.Resources)
+	if openError != nil {
+		return gophkeeper.ListPage{}, openError
+	}
+	page.Resources = slices.DeleteFunc(resources, func(resource gophkeeper.Resource) bool {
+		return !strings.HasPrefix(resource.Meta, prefix)
+	})
+	return page, nil
+}
+
+// Search implements gophkeeper.Identity.
+//
+// The terms are turned into the tokens of the index, so the origin
+// never learns them. Searching requires the identity to be unlocked,
+// it fails with ErrLocked otherwise.
+func (i Identity) Search(ctx context.Context, terms []string) ([]gophkeeper.Resource, error) {
+	if i.unlocked == nil {
+		return nil, ErrLocked
+	}
+	tokens, tokensError := i.unlocked.index(terms)
+	if tokensError != nil {
+		return nil, tokensError
+	}
+	resources, searchError := i.Origin.Search(ctx, tokens)
+	if searchError != nil {
+		return nil, searchError
+	}
+	return i.openResources(resources)
+}
+
+// ListVersions implements gophkeeper.Identity.
+func (i Identity) ListVersions(ctx context.Context, rid gophkeeper.ResourceID) ([]gophkeeper.ResourceVersion, error) {
+	versions, versionsError := i.Origin.ListVersions(ctx, rid)
+	for n := range versions {
+		version := &versions[n]
+		m, parseError := parseMeta(version.Meta)
+		if parseError != nil {
+			return nil, parseError
+		}
+		content, openError := m.open(i.unlocked)
+		if openError != nil {
+			return nil, openError
+		}
+		version.Meta = content
+	}
+	return versions, versionsError
+}
+
+// RestoreVersion implements gophkeeper.Identity.
+func (i Identity) RestoreVersion(ctx context.Context, rid gophkeeper.ResourceID, version int, password string) error {
+	password = i.secret(password)
+	verifier, verifierError := i.verifier(password)
+	if verifierError != nil {
+		return verifierError
+	}
+	return i.Origin.RestoreVersion(ctx, rid, version, verifier)
+}
+
+// Delete implements gophkeeper.Identity.
+func (i Identity) Delete(ctx context.Context, rid gophkeeper.ResourceID) error {
+	return i.Origin.Delete(ctx, rid)
+}
+
+// ListTrash implements gophkeeper.Identity.
+func (i Identity) ListTrash(ctx context.Context) ([]gophkeeper.TrashedResource, error) {
+	resources, resourcesError := i.Origin.ListTrash(ctx)
+	for n := range resources {
+		resource := &resources[n]
+		m, parseError := parseMeta(resource.Meta)
+		if parseError != nil {
+			return nil, parseError
+		}
+		content, openError := m.open(i.unlocked)
+		if openError != nil {
+			return nil, openError
+		}
+		tags, tagsError := i.openTags(resource.Tags)
+		if tagsError != nil {
+			return nil, tagsError
+		}
+		resource.Meta, resource.Tags = content, tags
+	}
+	return resources, resourcesError
+}
+
+// Undelete implements gophkeeper.Identity.
+func (i Identity) Undelete(ctx context.Context, rid gophkeeper.ResourceID) error {
+	return i.Origin.Undelete(ctx, rid)
+}
+
+// Purge implements gophkeeper.Identity.
+func (i Identity) Purge(ctx context.Context, rid gophkeeper.ResourceID) error {
+	return i.Origin.Purge(ctx, rid)
+}
+
+// CreateFolder implements gophkeeper.Identity.
+//
+// The origin receives the name sealed with the meta keys, so it never
+// learns it. Creating a folder requires the identity to be unlocked,
+// it fails with ErrLocked otherwise.
+func (i Identity) CreateFolder(ctx context.Context, name string, parent gophkeeper.FolderID) (gophkeeper.FolderID, error) {
+	if i.unlocked == nil {
+		return -1, ErrLocked
+	}
+	sealed, sealError := i.unlocked.sealName(name)
+	if sealError != nil {
+		return -1, sealError
+	}
+	return i.Origin.CreateFolder(ctx, sealed, parent)
+}
+
+// ListFolders implements gophkeeper.Identity.
+//
+// The sealed names are opened only if the identity
+// is unlocked, it fails with ErrLocked otherwise.
+func (i Identity) ListFolders(ctx context.Context) ([]gophkeeper.Folder, error) {
+	folders, foldersError := i.Origin.ListFolders(ctx)
+	if foldersError != nil {
+		return nil, foldersError
+	}
+	for n := range folders {
+		name, openError := i.unlocked.openName(folders[n].Name)
+		if openError != nil {
+			return nil, openError
+		}
+		folders[n].Name = name
+	}
+	return folders, nil
+}
+
+// MoveFolder implements gophkeeper.Identity.
+func (i Identity) MoveFolder(ctx context.Context, folder gophkeeper.FolderID, parent gophkeeper.FolderID) error {
+	return i.Origin.MoveFolder(ctx, folder, parent)
+}
+
+// MoveResource implements gophkeeper.Identity.
+func (i Identity) MoveResource(ctx context.Context, rid gophkeeper.ResourceID, folder gophkeeper.FolderID) error {
+	return i.Origin.MoveResource(ctx, rid, folder)
+}
+
+// Tag implements gophkeeper.Identity.
+//
+// The origin receives the tag sealed with the meta keys and the token
+// of the tag, the resource is searched by the token only and the given
+// index is ignored. The same tag is always sealed the same way, so the
+// origin tells the resources tagged with it without learning it.
+// Tagging requires the identity to be unlocked, it fails with
+// ErrLocked otherwise.
+func (i Identity) Tag(ctx context.Context, rid gophkeeper.ResourceID, tag string, _ ...string) error {
+	if i.unlocked == nil {
+		return ErrLocked
+	}
+	sealed, sealError := i.unlocked.sealName(tag)
+	if sealError != nil {
+		return sealError
+	}
+	index, indexError := i.unlocked.index([]string{tag})
+	if indexError != nil {
+		return indexError
+	}
+	return i.Origin.Tag(ctx, rid, sealed, index...)
+}
+
+// Untag implements gophkeeper.Identity.
+//
+// Untagging requires the identity to be unlocked,
+// it fails with ErrLocked otherwise.
+func (i Identity) Untag(ctx context.Context, rid gophkeeper.ResourceID, tag string) error {
+	if i.unlocked == nil {
+		return ErrLocked
+	}
+	sealed, sealError := i.unlocked.sealName(tag)
+	if sealError != nil {
+		return sealError
+	}
+	return i.Origin.Untag(ctx, rid, sealed)
+}
+
+// ChangePassword implements gophkeeper.Identity.
+func (i Identity) ChangePassword(ctx context.Context, oldPassword, newPassword string) error {
+	return i.Origin.ChangePassword(ctx, oldPassword, newPassword)
+}
+
+// ChangeVaultPassword implements gophkeeper.Identity.
+//
+// The data key of every piece and blob is rewrapped with the new password
+// and the meta is sealed with it, thus the origin must be
+// a gophkeeper.Reencrypter. The index is rebuilt with the index key
+// of the new password and the tags and the folder names are sealed
+// with it. The content is kept as is.
+func (i Identity) ChangeVaultPassword(ctx context.Context, oldPassword, newPassword string) error {
+	return i.ChangeVaultKey(ctx, oldPassword, newPassword, i.KeyFile)
+}
+
+// ChangeVaultKey changes the vault password and the key file, the vault
+// is re-encrypted with the composite key of the new ones as it is by
+// ChangeVaultPassword. A nil key file turns the key file off.
+//
+// Whether the vault needs a key file is recorded in the account
+// settings of the origin once the vault is re-encrypted.
+func (i Identity) ChangeVaultKey(ctx context.Context, oldPassword, newPassword string, keyFile []byte) error {
+	origin, ok := i.Origin.(gophkeeper.Reencrypter)
+	if !ok {
+		return ErrReencryptionUnsupported
+	}
+	oldPassword = i.secret(oldPassword)
+	newPassword = Identity{KeyFile: keyFile}.secret(newPassword)
+	var (
+		oldKeys = i.metaKeysOf(oldPassword)
+		newKeys = newMetaKeys(newPassword, i.VaultSalt)
+	)
+	reencryption := gophkeeper.Reencryption{
+		Meta: func(wrappedMeta string) (string, error) {
+			m, parseError := parseMeta(wrappedMeta)
+			if parseError != nil {
+				return "", parseError
+			}
+			content, openError := m.open(oldKeys)
+			if openError != nil {
+				return "", openError
+			}
+			terms, termsError := m.terms(oldKeys)
+			if termsError != nil {
+				return "", termsError
+			}
+			rewrapped, rewrapError := m.rewrap(oldKeys, newKeys, i.keyDerivation())
+			if rewrapError != nil {
+				return "", rewrapError
+			}
+			rewrapped, sealError := rewrapped.seal(content, terms, newKeys, i.keyDerivation())
+			if sealError != nil {
+				return "", sealError
+			}
+			rewrappedMeta, marshalError := json.Marshal(rewrapped)
+			if marshalError != nil {
+				return "", marshalError
+			}
+			return (string)(rewrappedMeta), nil
+		},
+		Index: func(wrappedMeta string) ([]string, error) {
+			m, parseError := parseMeta(wrappedMeta)
+			if parseError != nil {
+				return nil, parseError
+			}
+			terms, termsError := m.terms(newKeys)
+			if termsError != nil {
+				return nil, termsError
+			}
+			return newKeys.index(terms)
+		},
+		TagIndex: func(sealedTag string) ([]string, error) {
+			tag, openError := oldKeys.openName(sealedTag)
+			if openError != nil {
+				return nil, openError
+			}
+			return newKeys.index([]string{tag})
+		},
+		Tag:    resealName(oldKeys, newKeys),
+		Folder: resealName(oldKeys, newKeys),
+	}
+	oldVerifier, oldVerifierError := i.verifier(oldPassword)
+	if oldVerifierError != nil {
+		return oldVerifierError
+	}
+	newVerifier, newVerifierError := i.verifier(newPassword)
+	if newVerifierError != nil {
+		return newVerifierError
+	}
+	if err := origin.Reencrypt(ctx, oldVerifier, newVerifier, reencryption); err != nil {
+		return err
+	}
+
+	settings, settingsError := i.Origin.Settings(ctx)
+	if settingsError != nil {
+		return settingsError
+	}
+	if settings.KeyFile == (keyFile != nil) {
+		return nil
+	}
+	settings.KeyFile = keyFile != nil
+	return i.Origin.UpdateSettings(ctx, settings)
+}
+
+// resealName returns a function that opens a tag or a folder name
+// with the old keys and seals it with the new ones, the names stored
+// before they were sealed are sealed too.
+func resealName(oldKeys, newKeys *metaKeys) func(string) (string, error) {
+	return func(sealedName string) (string, error) {
+		name, openError := oldKeys.openName(sealedName)
+		if openError != nil {
+			return "", openError
+		}
+		return newKeys.sealName(name)
+	}
+}
+
+// Settings implements gophkeeper.Identity.
+func (i Identity) Settings(ctx context.Context) (gophkeeper.Settings, error) {
+	return i.Origin.Settings(ctx)
+}
+
+// UpdateSettings implements gophkeeper.Identity.
+func (i Identity) UpdateSettings(ctx context.Context, settings gophkeeper.Settings) error {
+	return i.Origin.UpdateSettings(ctx, settings)
+}
+
+// decryptPiece decrypts the piece stored by encryptPiece.
+func (i Identity) decryptPiece(piece gophkeeper.Piece, password string) (gophkeeper.Piece, error) {
+	m, parseError := parseMeta(piece.Meta)
+	if parseError != nil {
+		return gophkeeper.Piece{}, parseError
+	}
+	keys := i.metaKeysOf(password)
+	content, openError := m.open(keys)
+	if openError != nil {
+		return gophkeeper.Piece{}, openError
+	}
+	terms, termsError := m.terms(keys)
+	if termsError != nil {
+		return gophkeeper.Piece{}, termsError
+	}
+
+	reader, readerError := i.decrypter(m, keys, bytes.NewReader(piece.Content))
+	if readerError != nil {
+		return gophkeeper.Piece{}, readerError
+	}
+	decrypted, decryptedError := io.ReadAll(reader)
+	if decryptedError != nil {
+		return gophkeeper.Piece{}, decryptedError
+	}
+
+	decryptedPiece := gophkeeper.Piece{
+		Meta:    content,
+		Content: decrypted,
+		Index:   terms,
+	}
+	return decryptedPiece, nil
+}
+
+// decryptBlob wraps the content of the blob stored
+// by encryptBlob into a decrypting stream.
+//
+// The first chunk of the content is opened right away, so a blob
+// tampered with at the beginning is reported before it is read.
+func (i Identity) decryptBlob(blob gophkeeper.Blob, password string) (gophkeeper.Blob, error) {
+	m, parseError := parseMeta(blob.Meta)
+	if parseError != nil {
+		return gophkeeper.Blob{}, parseError
+	}
+	keys := i.metaKeysOf(password)
+	content, openError := m.open(keys)
+	if openError != nil {
+		return gophkeeper.Blob{}, openError
+	}
+	terms, termsError := m.terms(keys)
+	if termsError != nil {
+		return gophkeeper.Blob{}, termsError
+	}
+
+	reader, readerError := i.decrypter(m, keys, blob.Content)
+	if readerError != nil {
+		return gophkeeper.Blob{}, readerError
+	}
+	if opening, ok := reader.(*chunked.OpeningReader); ok {
+		if err := opening.Prefetch(); err != nil {
+			return gophkeeper.Blob{}, err
+		}
+	}
+	reader, readerError = newDecompressingReader(m.Compression, reader)
+	if readerError != nil {
+		return gophkeeper.Blob{}, readerError
+	}
+
+	decryptedBlob := gophkeeper.Blob{
+		Meta:  content,
+		Index: terms,
+		Content: &composedreadcloser.ComposedReadCloser{
+			Reader: reader,
+			Closer: blob.Content,
+		},
+	}
+	return decryptedBlob, nil
+}
+
+// encryptPiece encrypts the piece with a fresh data key.
+func (i Identity) encryptPiece(piece gophkeeper.Piece, password string) (gophkeeper.Piece, error) {
+	m, reader, readerError := i.encrypter(password, bytes.NewReader(piece.Content))
+	if readerError != nil {
+		return gophkeeper.Piece{}, readerError
+	}
+	content, contentError := io.ReadAll(reader)
+	if contentError != nil {
+		return gophkeeper.Piece{}, contentError
+	}
+
+	keys := i.metaKeysOf(password)
+	m, sealError := m.seal(piece.Meta, piece.Index, keys, i.keyDerivation())
+	if sealError != nil {
+		return gophkeeper.Piece{}, sealError
+	}
+	index, indexError := keys.index(piece.Index)
+	if indexError != nil {
+		return gophkeeper.Piece{}, indexError
+	}
+	wrappedMeta, wrappedMetaError := json.Marshal(m)
+	if wrappedMetaError != nil {
+		return gophkeeper.Piece{}, wrappedMetaError
+	}
+
+	encryptedPiece := gophkeeper.Piece{
+		Meta:    (string)(wrappedMeta),
+		Content: content,
+		Index:   index,
+	}
+	return encryptedPiece, nil
+}
+
+// encryptBlob wraps the blob content into an encrypting stream,
+// the content is compressed ahead of the encryption if the settings
+// tell so.
+//
+// The data key is a fresh one, unless the settings tell the blobs are
+// deduplicated and the content can be read twice, then it is derived
+// from the content, so the identical blobs of the vault are encrypted
+// the same way.
+func (i Identity) encryptBlob(blob gophkeeper.Blob, password string, settings gophkeeper.Settings) (gophkeeper.Blob, error) {
+	var (
+		key      []byte
+		prefixOf = chunked.NewNoncePrefix
+	)
+	if seeker, ok := blob.Content.(io.ReadSeeker); ok && settings.Deduplication {
+		convergenceKey, convergenceError := i.metaKeysOf(password).convergence()
+		if convergenceError != nil {
+			return gophkeeper.Blob{}, convergenceError
+		}
+		derived, keyError := convergentKey(convergenceKey, seeker)
+		if keyError != nil {
+			return gophkeeper.Blob{}, keyError
+		}
+		key, prefixOf = derived, convergentNoncePrefix(derived)
+	} else {
+		fresh, keyError := encryption.NewKey()
+		if keyError != nil {
+			return gophkeeper.Blob{}, keyError
+		}
+		key = fresh
+	}
+
+	var (
+		content io.Reader = blob.Content
+		closer  io.Closer = blob.Content
+	)
+	if settings.Compression {
+		compressed := newCompressingReader(blob.Content)
+		content, closer = compressed, closers{compressed, blob.Content}
+	}
+	m, reader, readerError := i.encrypterOf(password, key, prefixOf, content)
+	if readerError != nil {
+		return gophkeeper.Blob{}, readerError
+	}
+	if settings.Compression {
+		m.Compression = compressionGzip
+	}
+
+	keys := i.metaKeysOf(password)
+	m, sealError := m.seal(blob.Meta, blob.Index, keys, i.keyDerivation())
+	if sealError != nil {
+		return gophkeeper.Blob{}, sealError
+	}
+	index, indexError := keys.index(blob.Index)
+	if indexError != nil {
+		return gophkeeper.Blob{}, indexError
+	}
+	wrappedMeta, wrappedMetaError := json.Marshal(m)
+	if wrappedMetaError != nil {
+		return gophkeeper.Blob{}, wrappedMetaError
+	}
+
+	encryptedBlob := gophkeeper.Blob{
+		Meta:  (string)(wrappedMeta),
+		Index: index,
+		Content: &composedreadcloser.ComposedReadCloser{
+			Reader: reader,
+			Closer: closer,
+		},
+	}
+	return encryptedBlob, nil
+}
+
+// encrypter returns the meta of a fresh data key and
+// a reader of the origin encrypted with it.
+func (i Identity) encrypter(password string, origin io.Reader) (meta, io.Reader, error) {
+	key, keyError := encryption.NewKey()
+	if keyError != nil {
+		return meta{}, nil, keyError
+	}
+	return i.encrypterOf(password, key, chunked.NewNoncePrefix, origin)
+}
+
+// encrypterOf returns the meta of the data key and a reader of the origin
+// encrypted with it, the nonce prefix of the content is made by prefixOf.
+func (i Identity) encrypterOf(
+	password string,
+	key []byte,
+	prefixOf func(aead cipher.AEAD) ([]byte, error),
+	origin io.Reader,
+) (meta, io.Reader, error) {
+	m, wrapError := meta{Version: envelopeVersion}.wrap(key, i.metaKeysOf(password), i.keyDerivation())
+	if wrapError != nil {
+		return meta{}, nil, wrapError
+	}
+
+	aeadCipher := i.AEAD
+	if aeadCipher == nil {
+		aeadCipher = GCMCipher{}
+	}
+	aead, aeadError := aeadCipher.AEAD(key)
+	if aeadError != nil {
+		return meta{}, nil, aeadError
+	}
+	prefix, prefixError := prefixOf(aead)
+	if prefixError != nil {
+		return meta{}, nil, prefixError
+	}
+
+	m.IV, m.Cipher = prefix, aeadCipher.Name()
+	return m, chunked.NewSealingReader(aead, prefix, origin), nil
+}
+
+// blobSettings returns the settings of the origin the blobs are
+// stored with, they are not compressed if the context stores them
+// as they are.
+func (i Identity) blobSettings(ctx context.Context) (gophkeeper.Settings, error) {
+	settings, settingsError := i.Origin.Settings(ctx)
+	if settingsError != nil {
+		return gophkeeper.Settings{}, settingsError
+	}
+	if gophkeeper.CompressionDisabled(ctx) {
+		settings.Compression = false
+	}
+	return settings, nil
+}
+
+// verifier returns what the origin receives instead of the password,
+// the one of the unlocked identity is derived once.
+func (i Identity) verifier(password string) (string, error) {
+	if i.Verifier == nil {
+		return password, nil
+	}
+	return i.metaKeysOf(password).verifierOf(i.Verifier)
+}
+
+// openResources returns the resources with their meta opened.
+func (i Identity) openResources(resources []gophkeeper.Resource) ([]gophkeeper.Resource, error) {
+	opened := make([]gophkeeper.Resource, 0, len(resources))
+	for _, resource := range resources {
+		m, parseError := parseMeta(resource.Meta)
+		if parseError != nil {
+			return nil, parseError
+		}
+		content, openError := m.open(i.unlocked)
+		if openError != nil {
+			return nil, openError
+		}
+		tags, tagsError := i.openTags(resource.Tags)
+		if tagsError != nil {
+			return nil, tagsError
+		}
+		resource.Meta, resource.Tags = content, tags
+		opened = append(opened, resource)
+	}
+	return opened, nil
+}
+
+// openTags returns the tags with the sealed ones opened.
+func (i Identity) openTags(tags []string) ([]string, error) {
+	if tags == nil {
+		return nil, nil
+	}
+	opened := make([]string, 0, len(tags))
+	for _, tag := range tags {
+		name, openError := i.unlocked.openName(tag)
+		if openError != nil {
+			return nil, openError
+		}
+		opened = append(opened, name)
+	}
+	return opened, nil
+}
+
+// secret returns the secret the keys are derived from,
+// the composite key of the password if there is a key file.
+func (i Identity) secret(password string) string {
+	if i.KeyFile == nil {
+		return password
+	}
+	return CompositeKey(password, i.KeyFile)
+}
+
+// metaKeysOf returns the meta keys of the password,
+// those of the unlocked identity if it is the same password.
+func (i Identity) metaKeysOf(password string) *metaKeys {
+	if i.unlocked != nil && i.unlocked.password == password {
+		return i.unlocked
+	}
+	return newMetaKeys(password, i.VaultSalt)
+}
+
+// keyDerivation returns the KDF new keys are derived with.
+func (i Identity) keyDerivation() kdf.KDF {
+	if i.KDF == (kdf.KDF{}) {
+		return kdf.Default
+	}
+	return i.KDF
+}
+
+// decrypter returns a reader of the origin decrypted
+// with the data key of the meta.
+func (i Identity) decrypter(m meta, keys *metaKeys, origin io.Reader) (io.Reader, error) {
+	key, keyError := m.dataKey(keys)
+	if keyError != nil {
+		return nil, keyError
+	}
+
+	if m.Cipher == streamCipherName {
+		block, blockError := aes.NewCipher(key)
+		if blockError != nil {
+			return nil, blockError
+		}
+		reader := cipher.StreamReader{
+			S: i.Cipher.Decrypter(block, m.IV),
+			R: origin,
+		}
+		return reader, nil
+	}
+
+	aeadCipher, ok := aeadCiphers[m.Cipher]
+	if i.AEAD != nil && i.AEAD.Name() == m.Cipher {
+		aeadCipher, ok = i.AEAD, true
+	}
+	if !ok {
+		return nil, ErrUnknownAEAD
+	}
+	aead, aeadError := aeadCipher.AEAD(key)
+	if aeadError != nil {
+		return nil, aeadError
+	}
+	if len(m.IV) != aead.NonceSize()-chunked.NonceSuffixLen {
+		return nil, errors.Join(gophkeeper.ErrIntegrity, errors.New("invalid nonce prefix"))
+	}
+	return chunked.NewOpeningReader(aead, m.IV, origin), nil
+}
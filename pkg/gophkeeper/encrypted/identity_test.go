@@ -0,0 +1,1556 @@
+package encrypted_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/sha256"
+	"encoding/json"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kerelape/gophkeeper/pkg/gophkeeper"
+	"github.com/kerelape/gophkeeper/pkg/gophkeeper/encrypted"
+	"github.com/kerelape/gophkeeper/pkg/gophkeeper/kdf"
+	"github.com/kerelape/gophkeeper/pkg/gophkeeper/virtual"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/pbkdf2"
+)
+
+// testKDF is a cheap KDF so that the tests do not take long.
+var testKDF = kdf.KDF{Algorithm: kdf.Argon2id, Time: 1, Memory: 64, Threads: 1}
+
+func TestEncrypted(t *testing.T) {
+	t.Run("Piece", func(t *testing.T) {
+		var (
+			g = encrypted.Gophkeeper{
+				Origin: virtual.New(time.Hour, t.TempDir()),
+				Cipher: encrypted.CFBCipher{},
+				KDF:    testKDF,
+			}
+			credential = gophkeeper.Credential{
+				Username:      "test",
+				Password:      "qwerty",
+				VaultPassword: "qwerty",
+			}
+		)
+
+		registerError := g.Register(context.Background(), credential)
+		assert.Nil(t, registerError, "expected to successfully register")
+
+		token, authenticateError := g.Authenticate(context.Background(), credential)
+		assert.Nil(t, authenticateError, "expected to successfully authenticate")
+
+		identity, identityError := g.Identity(context.Background(), token)
+		assert.Nil(t, identityError, "expected to successfully get the identity")
+
+		rid, storePieceError := identity.StorePiece(
+			context.Background(),
+			gophkeeper.Piece{
+				Meta:    "testmeta",
+				Content: ([]byte)("testcontent"),
+			},
+			credential.Password,
+		)
+		assert.Nil(t, storePieceError, "expected to successfully store a piece")
+
+		piece, restoreError := identity.RestorePiece(
+			context.Background(),
+			rid, credential.Password,
+		)
+		assert.Nil(t, restoreError, "expected to successfully restore the piece")
+
+		assert.Equal(t, "testmeta", piece.Meta, "meta is not restored correctly")
+		assert.Equal(t, "testcontent", (string)(piece.Content), "content is not restored correctly")
+
+		origin := (identity.(encrypted.Identity)).Origin
+		wrongRID, wrongRIDError := origin.StorePiece(
+			context.Background(),
+			gophkeeper.Piece{
+				Meta:    "nonjson",
+				Content: []byte{},
+			},
+			credential.Password,
+		)
+		if wrongRIDError != nil {
+			t.Fail()
+		}
+		_, restoreWrongError := identity.RestorePiece(context.Background(), wrongRID, credential.Password)
+		assert.NotNil(t, restoreWrongError)
+	})
+
+	t.Run("Blob", func(t *testing.T) {
+		var (
+			g = encrypted.Gophkeeper{
+				Origin: virtual.New(time.Hour, t.TempDir()),
+				Cipher: encrypted.CFBCipher{},
+				KDF:    testKDF,
+			}
+			credential = gophkeeper.Credential{
+				Username:      "test",
+				Password:      "qwerty",
+				VaultPassword: "qwerty",
+			}
+		)
+
+		registerError := g.Register(context.Background(), credential)
+		assert.Nil(t, registerError, "expected to successfully register")
+
+		token, authenticateError := g.Authenticate(context.Background(), credential)
+		assert.Nil(t, authenticateError, "expected to successfully authenticate")
+
+		identity, identityError := g.Identity(context.Background(), token)
+		assert.Nil(t, identityError, "expected to successfully get the identity")
+
+		rid, storeBlobError := identity.StoreBlob(
+			context.Background(),
+			gophkeeper.Blob{
+				Meta:    "testmeta",
+				Content: io.NopCloser(bytes.NewReader(([]byte)("testcontent"))),
+			},
+			credential.Password,
+		)
+		assert.Nil(t, storeBlobError, "expected to successfully store a blob")
+
+		blob, restoreError := identity.RestoreBlob(
+			context.Background(),
+			rid, credential.Password,
+		)
+		assert.Nil(t, restoreError, "expected to successfully restore the blob")
+
+		content, contentError := io.ReadAll(blob.Content)
+		assert.Nil(t, contentError, "expected to successfully read the content")
+
+		blob.Content.Close()
+
+		assert.Equal(t, "testmeta", blob.Meta, "meta is not restored correctly")
+		assert.Equal(t, "testcontent", (string)(content), "content is not restored correctly")
+
+		origin := (identity.(encrypted.Identity)).Origin
+		wrongRID, wrongRIDError := origin.StoreBlob(
+			context.Background(),
+			gophkeeper.Blob{
+				Meta:    "nonjson",
+				Content: io.NopCloser(bytes.NewReader([]byte{})),
+			},
+			credential.Password,
+		)
+		assert.Nil(t, wrongRIDError)
+		_, restoreWrongError := identity.RestoreBlob(context.Background(), wrongRID, credential.Password)
+		assert.NotNil(t, restoreWrongError)
+	})
+
+	t.Run("Compression", func(t *testing.T) {
+		var (
+			g = encrypted.Gophkeeper{
+				Origin: virtual.New(time.Hour, t.TempDir(), virtual.WithCompression(true)),
+				Cipher: encrypted.CFBCipher{},
+				KDF:    testKDF,
+			}
+			credential = gophkeeper.Credential{
+				Username:      "test",
+				Password:      "qwerty",
+				VaultPassword: "qwerty",
+			}
+			content = strings.Repeat("2024-01-01 00:00:00 INFO request served\n", 4096)
+		)
+
+		registerError := g.Register(context.Background(), credential)
+		assert.Nil(t, registerError, "expected to successfully register")
+		token, authenticateError := g.Authenticate(context.Background(), credential)
+		assert.Nil(t, authenticateError, "expected to successfully authenticate")
+		identity, identityError := g.Identity(context.Background(), token)
+		assert.Nil(t, identityError, "expected to successfully get the identity")
+		origin := identity.(encrypted.Identity).Origin
+
+		stored := func(ctx context.Context) (gophkeeper.ResourceID, int) {
+			rid, storeError := identity.StoreBlob(
+				ctx,
+				gophkeeper.Blob{Meta: "log", Content: io.NopCloser(strings.NewReader(content))},
+				credential.Password,
+			)
+			assert.Nil(t, storeError, "expected to successfully store a blob")
+			blob, restoreError := origin.RestoreBlob(context.Background(), rid, credential.Password)
+			assert.Nil(t, restoreError, "expected to successfully restore the stored blob")
+			ciphertext, readError := io.ReadAll(blob.Content)
+			assert.Nil(t, readError, "expected to successfully read the stored blob")
+			blob.Content.Close()
+			return rid, len(ciphertext)
+		}
+
+		compressedRID, compressedSize := stored(context.Background())
+		assert.Less(t, compressedSize, len(content)/10, "expected the blob to be compressed")
+		_, uncompressedSize := stored(gophkeeper.WithoutCompression(context.Background()))
+		assert.GreaterOrEqual(t, uncompressedSize, len(content), "expected the blob to be stored as is")
+
+		blob, restoreError := identity.RestoreBlob(context.Background(), compressedRID, credential.Password)
+		assert.Nil(t, restoreError, "expected to successfully restore the blob")
+		restored, readError := io.ReadAll(blob.Content)
+		assert.Nil(t, readError, "expected to successfully read the blob")
+		assert.Nil(t, blob.Content.Close(), "did not expect an error")
+		assert.Equal(t, content, (string)(restored), "expected the blob to be decompressed")
+		assert.Equal(t, "log", blob.Meta, "meta is not restored correctly")
+
+		settings, settingsError := identity.Settings(context.Background())
+		assert.Nil(t, settingsError, "did not expect an error")
+		assert.True(t, settings.Compression, "expected the compression to be set by the origin")
+		settings.Compression = false
+		assert.Nil(t, identity.UpdateSettings(context.Background(), settings), "did not expect an error")
+		settings, settingsError = identity.Settings(context.Background())
+		assert.Nil(t, settingsError, "did not expect an error")
+		assert.True(t, settings.Compression, "expected the compression not to be changed by the identity")
+	})
+
+	t.Run("Deduplication", func(t *testing.T) {
+		var (
+			content     = strings.Repeat("deduplicated ", 1<<16)
+			credentials = []gophkeeper.Credential{
+				{Username: "first", Password: "qwerty", VaultPassword: "qwerty"},
+				{Username: "second", Password: "qwerty", VaultPassword: "qwerty"},
+			}
+		)
+		stored := func(deduplication bool, credential gophkeeper.Credential, seekable bool) []string {
+			g := encrypted.Gophkeeper{
+				Origin: virtual.New(time.Hour, t.TempDir(), virtual.WithDeduplication(deduplication)),
+				Cipher: encrypted.CFBCipher{},
+				KDF:    testKDF,
+			}
+			assert.Nil(t, g.Register(context.Background(), credential), "expected to successfully register")
+			token, authenticateError := g.Authenticate(context.Background(), credential)
+			assert.Nil(t, authenticateError, "expected to successfully authenticate")
+			identity, identityError := g.Identity(context.Background(), token)
+			assert.Nil(t, identityError, "expected to successfully get the identity")
+			origin := identity.(encrypted.Identity).Origin
+
+			var ciphertexts []string
+			for n := 0; n < 2; n++ {
+				var blobContent io.ReadCloser = seekableContent{strings.NewReader(content)}
+				if !seekable {
+					blobContent = io.NopCloser(strings.NewReader(content))
+				}
+				rid, storeError := identity.StoreBlob(
+					context.Background(),
+					gophkeeper.Blob{Meta: "file", Content: blobContent},
+					credential.Password,
+				)
+				assert.Nil(t, storeError, "expected to successfully store a blob")
+
+				encryptedBlob, restoreError := origin.RestoreBlob(context.Background(), rid, credential.Password)
+				assert.Nil(t, restoreError, "expected to successfully restore the stored blob")
+				ciphertext, readError := io.ReadAll(encryptedBlob.Content)
+				assert.Nil(t, readError, "expected to successfully read the stored blob")
+				encryptedBlob.Content.Close()
+				ciphertexts = append(ciphertexts, (string)(ciphertext))
+
+				blob, restoreError := identity.RestoreBlob(context.Background(), rid, credential.Password)
+				assert.Nil(t, restoreError, "expected to successfully restore the blob")
+				restored, readError := io.ReadAll(blob.Content)
+				assert.Nil(t, readError, "expected to successfully read the blob")
+				assert.Nil(t, blob.Content.Close(), "did not expect an error")
+				assert.Equal(t, content, (string)(restored), "blob is not restored correctly")
+			}
+			return ciphertexts
+		}
+
+		first := stored(true, credentials[0], true)
+		assert.Equal(t, first[0], first[1], "expected the identical blobs to be encrypted the same way")
+		second := stored(true, credentials[1], true)
+		assert.NotEqual(t, first[0], second[0], "expected the blobs of another vault to be encrypted another way")
+		unseekable := stored(true, credentials[0], false)
+		assert.NotEqual(t, unseekable[0], unseekable[1], "expected the content read once to be encrypted with a fresh key")
+		disabled := stored(false, credentials[0], true)
+		assert.NotEqual(t, disabled[0], disabled[1], "expected the blobs to be encrypted with a fresh key")
+	})
+
+	t.Run("Update", func(t *testing.T) {
+		var (
+			g = encrypted.Gophkeeper{
+				Origin: virtual.New(time.Hour, t.TempDir()),
+				Cipher: encrypted.CFBCipher{},
+				KDF:    testKDF,
+			}
+			credential = gophkeeper.Credential{
+				Username:      "test",
+				Password:      "qwerty",
+				VaultPassword: "qwerty",
+			}
+		)
+
+		registerError := g.Register(context.Background(), credential)
+		assert.Nil(t, registerError, "expected to successfully register")
+
+		token, authenticateError := g.Authenticate(context.Background(), credential)
+		assert.Nil(t, authenticateError, "expected to successfully authenticate")
+
+		identity, identityError := g.Identity(context.Background(), token)
+		assert.Nil(t, identityError, "expected to successfully get the identity")
+
+		rid, storePieceError := identity.StorePiece(
+			context.Background(),
+			gophkeeper.Piece{
+				Meta:    "testmeta",
+				Content: ([]byte)("testcontent"),
+			},
+			credential.Password,
+		)
+		assert.Nil(t, storePieceError, "expected to successfully store a piece")
+
+		origin := (identity.(encrypted.Identity)).Origin
+		before, beforeError := origin.RestorePiece(context.Background(), rid, credential.Password)
+		assert.Nil(t, beforeError, "expected to successfully restore the raw piece")
+
+		updatePieceError := identity.UpdatePiece(
+			context.Background(),
+			rid,
+			gophkeeper.Piece{
+				Meta:    "updatedmeta",
+				Content: ([]byte)("updatedcontent"),
+			},
+			credential.Password,
+		)
+		assert.Nil(t, updatePieceError, "expected to successfully update the piece")
+
+		after, afterError := origin.RestorePiece(context.Background(), rid, credential.Password)
+		assert.Nil(t, afterError, "expected to successfully restore the raw piece")
+		assert.NotEqual(t, before.Meta, after.Meta, "expected a fresh IV and salt")
+
+		piece, restoreError := identity.RestorePiece(context.Background(), rid, credential.Password)
+		assert.Nil(t, restoreError, "expected to successfully restore the piece")
+		assert.Equal(t, "updatedmeta", piece.Meta, "meta is not updated")
+		assert.Equal(t, "updatedcontent", (string)(piece.Content), "content is not updated")
+
+		unlocked, unlockError := identity.(encrypted.Identity).Unlock(context.Background(), credential.Password)
+		assert.Nil(t, unlockError, "expected to successfully unlock the identity")
+		versions, versionsError := unlocked.ListVersions(context.Background(), rid)
+		assert.Nil(t, versionsError, "expected to successfully list versions")
+		assert.Equal(t, 1, len(versions), "expected the previous version to be kept")
+		assert.Equal(t, "testmeta", versions[0].Meta, "version meta is not decrypted")
+
+		revertError := identity.RestoreVersion(context.Background(), rid, versions[0].Version, credential.Password)
+		assert.Nil(t, revertError, "expected to successfully restore the version")
+
+		reverted, revertedError := identity.RestorePiece(context.Background(), rid, credential.Password)
+		assert.Nil(t, revertedError, "expected to successfully restore the piece")
+		assert.Equal(t, "testcontent", (string)(reverted.Content), "content is not reverted")
+
+		blobRID, storeBlobError := identity.StoreBlob(
+			context.Background(),
+			gophkeeper.Blob{
+				Meta:    "testmeta",
+				Content: io.NopCloser(strings.NewReader("testcontent")),
+			},
+			credential.Password,
+		)
+		assert.Nil(t, storeBlobError, "expected to successfully store a blob")
+
+		updateBlobError := identity.UpdateBlob(
+			context.Background(),
+			blobRID,
+			gophkeeper.Blob{
+				Meta:    "updatedmeta",
+				Content: io.NopCloser(strings.NewReader("updatedcontent")),
+			},
+			credential.Password,
+		)
+		assert.Nil(t, updateBlobError, "expected to successfully update the blob")
+
+		blob, restoreBlobError := identity.RestoreBlob(context.Background(), blobRID, credential.Password)
+		assert.Nil(t, restoreBlobError, "expected to successfully restore the blob")
+		content, contentError := io.ReadAll(blob.Content)
+		assert.Nil(t, contentError, "expected to successfully read the content")
+		blob.Content.Close()
+		assert.Equal(t, "updatedmeta", blob.Meta, "meta is not updated")
+		assert.Equal(t, "updatedcontent", (string)(content), "content is not updated")
+	})
+
+	t.Run("Delete", func(t *testing.T) {
+		var (
+			g = encrypted.Gophkeeper{
+				Origin: virtual.New(time.Hour, t.TempDir()),
+				Cipher: encrypted.CFBCipher{},
+				KDF:    testKDF,
+			}
+			credential = gophkeeper.Credential{
+				Username:      "test",
+				Password:      "qwerty",
+				VaultPassword: "qwerty",
+			}
+		)
+
+		registerError := g.Register(context.Background(), credential)
+		assert.Nil(t, registerError, "expected to successfully register")
+
+		token, authenticateError := g.Authenticate(context.Background(), credential)
+		assert.Nil(t, authenticateError, "expected to successfully authenticate")
+
+		identity, identityError := g.Identity(context.Background(), token)
+		assert.Nil(t, identityError, "expected to successfully get the identity")
+
+		rid, storePieceError := identity.StorePiece(
+			context.Background(),
+			gophkeeper.Piece{
+				Meta:    "testmeta",
+				Content: ([]byte)("testcontent"),
+			},
+			credential.Password,
+		)
+		assert.Nil(t, storePieceError, "expected to successfully store a piece")
+
+		err := identity.Delete(context.Background(), rid)
+		assert.Nil(t, err, "expected to successfully delete")
+
+		_, lockedError := identity.ListTrash(context.Background())
+		assert.ErrorIs(t, lockedError, encrypted.ErrLocked, "expected the identity to be locked")
+
+		unlocked, unlockError := identity.(encrypted.Identity).Unlock(context.Background(), credential.Password)
+		assert.Nil(t, unlockError, "expected to successfully unlock the identity")
+		trash, trashError := unlocked.ListTrash(context.Background())
+		assert.Nil(t, trashError, "expected to successfully list the trash")
+		assert.Equal(t, 1, len(trash), "expected the deleted resource to be in the trash")
+		assert.Equal(t, "testmeta", trash[0].Meta, "meta is not restored correctly")
+
+		undeleteError := identity.Undelete(context.Background(), rid)
+		assert.Nil(t, undeleteError, "expected to successfully undelete")
+
+		piece, restoreError := identity.RestorePiece(context.Background(), rid, credential.Password)
+		assert.Nil(t, restoreError, "expected to successfully restore the piece")
+		assert.Equal(t, "testcontent", (string)(piece.Content), "content is not restored correctly")
+
+		assert.Nil(t, identity.Delete(context.Background(), rid), "expected to successfully delete")
+		purgeError := identity.Purge(context.Background(), rid)
+		assert.Nil(t, purgeError, "expected to successfully purge")
+	})
+
+	t.Run("List", func(t *testing.T) {
+		var (
+			g = encrypted.Gophkeeper{
+				Origin: virtual.New(time.Hour, t.TempDir()),
+				Cipher: encrypted.CFBCipher{},
+				KDF:    testKDF,
+			}
+			credential = gophkeeper.Credential{
+				Username:      "test",
+				Password:      "qwerty",
+				VaultPassword: "qwerty",
+			}
+		)
+
+		registerError := g.Register(context.Background(), credential)
+		assert.Nil(t, registerError, "expected to successfully register")
+
+		token, authenticateError := g.Authenticate(context.Background(), credential)
+		assert.Nil(t, authenticateError, "expected to successfully authenticate")
+
+		identity, identityError := g.Identity(context.Background(), token)
+		assert.Nil(t, identityError, "expected to successfully get the identity")
+
+		_, storePieceError := identity.StorePiece(
+			context.Background(),
+			gophkeeper.Piece{
+				Meta:    "testmeta",
+				Content: ([]byte)("testcontent"),
+			},
+			credential.Password,
+		)
+		assert.Nil(t, storePieceError, "expected to successfully store a piece")
+
+		origin := identity.(encrypted.Identity).Origin
+		stored, storedError := origin.List(context.Background(), gophkeeper.ListOptions{})
+		assert.Nil(t, storedError, "expected to successfully list the stored resources")
+		assert.NotContains(t, stored.Resources[0].Meta, "testmeta", "expected the meta to be encrypted")
+
+		_, lockedError := identity.List(context.Background(), gophkeeper.ListOptions{})
+		assert.ErrorIs(t, lockedError, encrypted.ErrLocked, "expected the identity to be locked")
+		assert.True(t, identity.(encrypted.Identity).Locked(), "expected the identity to be locked")
+
+		wrong, unlockError := identity.(encrypted.Identity).Unlock(context.Background(), "wrong")
+		assert.Nil(t, unlockError, "expected to successfully unlock the identity")
+		_, wrongPasswordError := wrong.List(context.Background(), gophkeeper.ListOptions{})
+		assert.ErrorIs(t, wrongPasswordError, gophkeeper.ErrBadCredential, "expected the password to be rejected")
+
+		identity, unlockError = identity.(encrypted.Identity).Unlock(context.Background(), credential.Password)
+		assert.Nil(t, unlockError, "expected to successfully unlock the identity")
+		assert.False(t, identity.(encrypted.Identity).Locked(), "expected the identity to be unlocked")
+
+		page, listError := identity.List(context.Background(), gophkeeper.ListOptions{})
+		assert.Nil(t, listError, "expected to successfully list resources")
+		assert.Equal(t, 1, len(page.Resources))
+		assert.Equal(t, "testmeta", page.Resources[0].Meta)
+		assert.Equal(t, gophkeeper.ResourceTypePiece, page.Resources[0].Type)
+
+		page, listError = identity.List(context.Background(), gophkeeper.ListOptions{MetaPrefix: "test"})
+		assert.Nil(t, listError, "expected to successfully list resources")
+		assert.Equal(t, 1, len(page.Resources), "expected prefix to match unwrapped meta")
+		page, listError = identity.List(context.Background(), gophkeeper.ListOptions{MetaPrefix: "{"})
+		assert.Nil(t, listError, "expected to successfully list resources")
+		assert.Empty(t, page.Resources, "expected prefix not to match wrapped meta")
+	})
+
+	t.Run("Search", func(t *testing.T) {
+		var (
+			g = encrypted.Gophkeeper{
+				Origin: virtual.New(time.Hour, t.TempDir()),
+				Cipher: encrypted.CFBCipher{},
+				KDF:    testKDF,
+			}
+			credential = gophkeeper.Credential{
+				Username:      "test",
+				Password:      "qwerty",
+				VaultPassword: "qwerty",
+			}
+			newPassword = "ytrewq"
+		)
+
+		registerError := g.Register(context.Background(), credential)
+		assert.Nil(t, registerError, "expected to successfully register")
+
+		token, authenticateError := g.Authenticate(context.Background(), credential)
+		assert.Nil(t, authenticateError, "expected to successfully authenticate")
+
+		identity, identityError := g.Identity(context.Background(), token)
+		assert.Nil(t, identityError, "expected to successfully get the identity")
+
+		rid, storePieceError := identity.StorePiece(
+			context.Background(),
+			gophkeeper.Piece{
+				Meta:    "bank account",
+				Content: ([]byte)("testcontent"),
+				Index:   []string{"bank", "account"},
+			},
+			credential.Password,
+		)
+		assert.Nil(t, storePieceError, "expected to successfully store a piece")
+
+		_, lockedError := identity.Search(context.Background(), []string{"bank"})
+		assert.ErrorIs(t, lockedError, encrypted.ErrLocked, "expected the identity to be locked")
+		lockedTagError := identity.Tag(context.Background(), rid, "finance")
+		assert.ErrorIs(t, lockedTagError, encrypted.ErrLocked, "expected the identity to be locked")
+
+		unlocked, unlockError := identity.(encrypted.Identity).Unlock(context.Background(), credential.Password)
+		assert.Nil(t, unlockError, "expected to successfully unlock the identity")
+
+		resources, searchError := unlocked.Search(context.Background(), []string{"Bank"})
+		assert.Nil(t, searchError, "expected to successfully search")
+		assert.Equal(t, 1, len(resources), "expected the piece to be found")
+		assert.Equal(t, "bank account", resources[0].Meta, "expected the meta to be decrypted")
+		resources, searchError = unlocked.Search(context.Background(), []string{"bank", "savings"})
+		assert.Nil(t, searchError, "expected to successfully search")
+		assert.Empty(t, resources, "expected all the terms to match")
+
+		origin := identity.(encrypted.Identity).Origin
+		stored, storedError := origin.Search(context.Background(), []string{"bank"})
+		assert.Nil(t, storedError, "expected to successfully search the origin")
+		assert.Empty(t, stored, "expected the terms not to be stored as is")
+		page, listError := origin.List(context.Background(), gophkeeper.ListOptions{})
+		assert.Nil(t, listError, "expected to successfully list the stored resources")
+		assert.NotContains(t, page.Resources[0].Meta, "account", "expected the terms to be sealed")
+
+		tagError := unlocked.Tag(context.Background(), rid, "finance")
+		assert.Nil(t, tagError, "expected to successfully tag the piece")
+		resources, searchError = unlocked.Search(context.Background(), []string{"finance", "account"})
+		assert.Nil(t, searchError, "expected to successfully search")
+		assert.Equal(t, 1, len(resources), "expected the piece to be found by its tag")
+		stored, storedError = origin.Search(context.Background(), []string{"finance"})
+		assert.Nil(t, storedError, "expected to successfully search the origin")
+		assert.Empty(t, stored, "expected the tag not to be indexed as is")
+		page, listError = origin.List(context.Background(), gophkeeper.ListOptions{Tag: "finance"})
+		assert.Nil(t, listError, "expected to successfully list the stored resources")
+		assert.Empty(t, page.Resources, "expected the tag not to be stored as is")
+		page, listError = origin.List(context.Background(), gophkeeper.ListOptions{})
+		assert.Nil(t, listError, "expected to successfully list the stored resources")
+		assert.NotContains(t, page.Resources[0].Tags, "finance", "expected the tag to be sealed")
+
+		piece, restoreError := identity.RestorePiece(context.Background(), rid, credential.Password)
+		assert.Nil(t, restoreError, "expected to successfully restore the piece")
+		assert.Equal(t, []string{"bank", "account"}, piece.Index, "expected the terms to be restored")
+
+		changeError := identity.ChangeVaultPassword(context.Background(), credential.Password, newPassword)
+		assert.Nil(t, changeError, "expected to successfully change the vault password")
+		unlocked, unlockError = identity.(encrypted.Identity).Unlock(context.Background(), newPassword)
+		assert.Nil(t, unlockError, "expected to successfully unlock the identity")
+		resources, searchError = unlocked.Search(context.Background(), []string{"account", "finance"})
+		assert.Nil(t, searchError, "expected to successfully search")
+		assert.Equal(t, 1, len(resources), "expected the index to be rebuilt")
+	})
+
+	t.Run("Tags and folders", func(t *testing.T) {
+		var (
+			g = encrypted.Gophkeeper{
+				Origin: virtual.New(time.Hour, t.TempDir()),
+				Cipher: encrypted.CFBCipher{},
+				KDF:    testKDF,
+			}
+			credential = gophkeeper.Credential{
+				Username:      "test",
+				Password:      "qwerty",
+				VaultPassword: "qwerty",
+			}
+			newPassword = "ytrewq"
+		)
+
+		registerError := g.Register(context.Background(), credential)
+		assert.Nil(t, registerError, "expected to successfully register")
+
+		token, authenticateError := g.Authenticate(context.Background(), credential)
+		assert.Nil(t, authenticateError, "expected to successfully authenticate")
+
+		identity, identityError := g.Identity(context.Background(), token)
+		assert.Nil(t, identityError, "expected to successfully get the identity")
+
+		rid, storePieceError := identity.StorePiece(
+			context.Background(),
+			gophkeeper.Piece{Meta: "piece", Content: ([]byte)("testcontent")},
+			credential.VaultPassword,
+		)
+		assert.Nil(t, storePieceError, "expected to successfully store a piece")
+
+		_, lockedFolderError := identity.CreateFolder(context.Background(), "Taxes", gophkeeper.RootFolder)
+		assert.ErrorIs(t, lockedFolderError, encrypted.ErrLocked, "expected the identity to be locked")
+		lockedUntagError := identity.Untag(context.Background(), rid, "Finance")
+		assert.ErrorIs(t, lockedUntagError, encrypted.ErrLocked, "expected the identity to be locked")
+
+		unlocked, unlockError := identity.(encrypted.Identity).Unlock(context.Background(), credential.VaultPassword)
+		assert.Nil(t, unlockError, "expected to successfully unlock the identity")
+		fid, createFolderError := unlocked.CreateFolder(context.Background(), "Taxes", gophkeeper.RootFolder)
+		assert.Nil(t, createFolderError, "expected to successfully create a folder")
+		assert.Nil(t, unlocked.Tag(context.Background(), rid, "Finance"), "expected to successfully tag the piece")
+		assert.Nil(t, unlocked.Tag(context.Background(), rid, "Work"), "expected to successfully tag the piece")
+
+		origin := identity.(encrypted.Identity).Origin
+		stored, storedError := origin.List(context.Background(), gophkeeper.ListOptions{})
+		assert.Nil(t, storedError, "expected to successfully list the stored resources")
+		assert.Equal(t, 2, len(stored.Resources[0].Tags), "expected the tags to be stored")
+		for _, tag := range stored.Resources[0].Tags {
+			assert.NotContains(t, []string{"Finance", "Work"}, tag, "expected the tags to be sealed")
+		}
+		storedFolders, storedFoldersError := origin.ListFolders(context.Background())
+		assert.Nil(t, storedFoldersError, "expected to successfully list the stored folders")
+		assert.NotEqual(t, "Taxes", storedFolders[0].Name, "expected the folder name to be sealed")
+		_, lockedFoldersError := identity.ListFolders(context.Background())
+		assert.ErrorIs(t, lockedFoldersError, encrypted.ErrLocked, "expected the identity to be locked")
+
+		page, listError := unlocked.List(context.Background(), gophkeeper.ListOptions{Tag: "Finance"})
+		assert.Nil(t, listError, "expected to successfully list")
+		assert.Equal(t, 1, len(page.Resources), "expected the piece to be listed by its tag")
+		assert.ElementsMatch(t, []string{"Finance", "Work"}, page.Resources[0].Tags, "expected the tags to be opened")
+		folders, foldersError := unlocked.ListFolders(context.Background())
+		assert.Nil(t, foldersError, "expected to successfully list the folders")
+		assert.Equal(t, []gophkeeper.Folder{{ID: fid, Name: "Taxes"}}, folders, "expected the folder name to be opened")
+
+		assert.Nil(t, unlocked.Untag(context.Background(), rid, "Work"), "expected to successfully untag the piece")
+		page, listError = unlocked.List(context.Background(), gophkeeper.ListOptions{Tag: "Work"})
+		assert.Nil(t, listError, "expected to successfully list")
+		assert.Empty(t, page.Resources, "expected the tag to be removed")
+
+		changeError := identity.ChangeVaultPassword(context.Background(), credential.VaultPassword, newPassword)
+		assert.Nil(t, changeError, "expected to successfully change the vault password")
+		_, staleError := unlocked.ListFolders(context.Background())
+		assert.ErrorIs(t, staleError, gophkeeper.ErrBadCredential, "expected the folder name to be sealed with the new password")
+		unlocked, unlockError = identity.(encrypted.Identity).Unlock(context.Background(), newPassword)
+		assert.Nil(t, unlockError, "expected to successfully unlock the identity")
+		page, listError = unlocked.List(context.Background(), gophkeeper.ListOptions{Tag: "Finance"})
+		assert.Nil(t, listError, "expected to successfully list")
+		assert.Equal(t, 1, len(page.Resources), "expected the tag to be sealed with the new password")
+		assert.Equal(t, []string{"Finance"}, page.Resources[0].Tags, "expected the tags to be opened")
+		found, searchError := unlocked.Search(context.Background(), []string{"finance"})
+		assert.Nil(t, searchError, "expected to successfully search")
+		assert.Equal(t, 1, len(found), "expected the piece to be found by its tag")
+		folders, foldersError = unlocked.ListFolders(context.Background())
+		assert.Nil(t, foldersError, "expected to successfully list the folders")
+		assert.Equal(t, "Taxes", folders[0].Name, "expected the folder name to be opened")
+	})
+
+	t.Run("Change vault password", func(t *testing.T) {
+		var (
+			g = encrypted.Gophkeeper{
+				Origin: virtual.New(time.Hour, t.TempDir()),
+				Cipher: encrypted.CFBCipher{},
+				KDF:    testKDF,
+			}
+			credential = gophkeeper.Credential{
+				Username:      "test",
+				Password:      "login",
+				VaultPassword: "qwerty",
+			}
+			newPassword = "ytrewq"
+		)
+
+		registerError := g.Register(context.Background(), credential)
+		assert.Nil(t, registerError, "expected to successfully register")
+
+		token, authenticateError := g.Authenticate(context.Background(), credential)
+		assert.Nil(t, authenticateError, "expected to successfully authenticate")
+
+		identity, identityError := g.Identity(context.Background(), token)
+		assert.Nil(t, identityError, "expected to successfully get the identity")
+
+		pieceRID, storePieceError := identity.StorePiece(
+			context.Background(),
+			gophkeeper.Piece{Meta: "piece", Content: ([]byte)("first")},
+			credential.VaultPassword,
+		)
+		assert.Nil(t, storePieceError, "expected to successfully store a piece")
+		updatePieceError := identity.UpdatePiece(
+			context.Background(),
+			pieceRID,
+			gophkeeper.Piece{Meta: "piece", Content: ([]byte)("second")},
+			credential.VaultPassword,
+		)
+		assert.Nil(t, updatePieceError, "expected to successfully update the piece")
+		blobRID, storeBlobError := identity.StoreBlob(
+			context.Background(),
+			gophkeeper.Blob{Meta: "blob", Content: io.NopCloser(strings.NewReader("blobcontent"))},
+			credential.VaultPassword,
+		)
+		assert.Nil(t, storeBlobError, "expected to successfully store a blob")
+		assert.Nil(t, identity.Delete(context.Background(), blobRID), "expected to successfully delete the blob")
+
+		unlocked, unlockError := identity.(encrypted.Identity).Unlock(context.Background(), credential.VaultPassword)
+		assert.Nil(t, unlockError, "expected to successfully unlock the identity")
+		trashBefore, listBeforeError := unlocked.ListTrash(context.Background())
+		assert.Nil(t, listBeforeError, "did not expect an error")
+
+		badPasswordError := identity.ChangeVaultPassword(context.Background(), "wrong", newPassword)
+		assert.ErrorIs(t, badPasswordError, gophkeeper.ErrBadCredential, "unexpected error")
+
+		var progress [][2]int
+		ctx := gophkeeper.WithProgress(context.Background(), func(done, total int) {
+			progress = append(progress, [2]int{done, total})
+		})
+		changeError := identity.ChangeVaultPassword(ctx, credential.VaultPassword, newPassword)
+		assert.Nil(t, changeError, "expected to successfully change the vault password")
+		assert.Equal(t, [][2]int{{1, 3}, {2, 3}, {3, 3}}, progress, "unexpected progress")
+
+		_, staleError := unlocked.ListTrash(context.Background())
+		assert.ErrorIs(t, staleError, gophkeeper.ErrBadCredential, "expected the meta to be sealed with the new password")
+		unlocked, unlockError = identity.(encrypted.Identity).Unlock(context.Background(), newPassword)
+		assert.Nil(t, unlockError, "expected to successfully unlock the identity")
+		trashAfter, listAfterError := unlocked.ListTrash(context.Background())
+		assert.Nil(t, listAfterError, "did not expect an error")
+		assert.Equal(t, "blob", trashAfter[0].Meta, "meta is not re-encrypted")
+		assert.NotEmpty(t, trashAfter[0].Hash, "expected the blob to have a hash")
+		assert.Equal(t, trashBefore[0].Hash, trashAfter[0].Hash, "expected the blob content to be kept")
+
+		_, oldPasswordError := identity.RestorePiece(context.Background(), pieceRID, credential.VaultPassword)
+		assert.ErrorIs(t, oldPasswordError, gophkeeper.ErrBadCredential, "expected old password to be rejected")
+
+		piece, restorePieceError := identity.RestorePiece(context.Background(), pieceRID, newPassword)
+		assert.Nil(t, restorePieceError, "expected to successfully restore the piece")
+		assert.Equal(t, "second", (string)(piece.Content), "piece is not re-encrypted")
+
+		versions, versionsError := unlocked.ListVersions(context.Background(), pieceRID)
+		assert.Nil(t, versionsError, "did not expect an error")
+		assert.Equal(t, "piece", versions[0].Meta, "version meta is not re-encrypted")
+		revertError := identity.RestoreVersion(context.Background(), pieceRID, versions[0].Version, newPassword)
+		assert.Nil(t, revertError, "expected to successfully revert the piece")
+		piece, restorePieceError = identity.RestorePiece(context.Background(), pieceRID, newPassword)
+		assert.Nil(t, restorePieceError, "expected to successfully restore the piece")
+		assert.Equal(t, "first", (string)(piece.Content), "version is not re-encrypted")
+
+		assert.Nil(t, identity.Undelete(context.Background(), blobRID), "expected to successfully undelete the blob")
+		blob, restoreBlobError := identity.RestoreBlob(context.Background(), blobRID, newPassword)
+		assert.Nil(t, restoreBlobError, "expected to successfully restore the blob")
+		content, contentError := io.ReadAll(blob.Content)
+		assert.Nil(t, contentError, "did not expect an error")
+		assert.Nil(t, blob.Content.Close(), "failed to close blob content")
+		assert.Equal(t, "blobcontent", (string)(content), "trashed blob is not re-encrypted")
+
+		loginError := identity.ChangePassword(context.Background(), credential.Password, "newlogin")
+		assert.Nil(t, loginError, "expected to successfully change the login password")
+		_, restoreAfterLoginError := identity.RestorePiece(context.Background(), pieceRID, newPassword)
+		assert.Nil(t, restoreAfterLoginError, "expected the vault password to be kept")
+
+		unsupported := encrypted.Identity{
+			Origin: struct{ gophkeeper.Identity }{(identity.(encrypted.Identity)).Origin},
+			Cipher: encrypted.CFBCipher{},
+			KDF:    testKDF,
+		}
+		unsupportedError := unsupported.ChangeVaultPassword(context.Background(), newPassword, credential.VaultPassword)
+		assert.ErrorIs(t, unsupportedError, encrypted.ErrReencryptionUnsupported, "unexpected error")
+	})
+
+	t.Run("Key file", func(t *testing.T) {
+		var (
+			g = encrypted.Gophkeeper{
+				Origin: virtual.New(time.Hour, t.TempDir()),
+				Cipher: encrypted.CFBCipher{},
+				KDF:    testKDF,
+			}
+			credential = gophkeeper.Credential{
+				Username:      "test",
+				Password:      "login",
+				VaultPassword: "qwerty",
+			}
+			keyFile = ([]byte)("key file")
+		)
+		assert.NotEqual(
+			t,
+			encrypted.CompositeKey(credential.VaultPassword, keyFile),
+			encrypted.CompositeKey(credential.VaultPassword, ([]byte)("other key file")),
+			"expected the composite key to depend on the key file",
+		)
+
+		registerError := g.Register(context.Background(), credential)
+		assert.Nil(t, registerError, "expected to successfully register")
+		token, authenticateError := g.Authenticate(context.Background(), credential)
+		assert.Nil(t, authenticateError, "expected to successfully authenticate")
+		identity, identityError := g.Identity(context.Background(), token)
+		assert.Nil(t, identityError, "expected to successfully get the identity")
+
+		rid, storeError := identity.StorePiece(
+			context.Background(),
+			gophkeeper.Piece{Meta: "piece", Content: ([]byte)("content")},
+			credential.VaultPassword,
+		)
+		assert.Nil(t, storeError, "expected to successfully store a piece")
+
+		changeError := identity.(encrypted.Identity).ChangeVaultKey(
+			context.Background(),
+			credential.VaultPassword,
+			credential.VaultPassword,
+			keyFile,
+		)
+		assert.Nil(t, changeError, "expected to successfully turn the key file on")
+		settings, settingsError := identity.Settings(context.Background())
+		assert.Nil(t, settingsError, "expected to successfully get the settings")
+		assert.True(t, settings.KeyFile, "expected the key file to be recorded in the settings")
+
+		_, missingError := identity.RestorePiece(context.Background(), rid, credential.VaultPassword)
+		assert.ErrorIs(t, missingError, gophkeeper.ErrBadCredential, "expected the key file to be required")
+		wrong := identity.(encrypted.Identity).WithKeyFile(([]byte)("other key file"))
+		_, wrongError := wrong.RestorePiece(context.Background(), rid, credential.VaultPassword)
+		assert.ErrorIs(t, wrongError, gophkeeper.ErrBadCredential, "expected a wrong key file to be rejected")
+
+		keyed := identity.(encrypted.Identity).WithKeyFile(keyFile)
+		piece, restoreError := keyed.RestorePiece(context.Background(), rid, credential.VaultPassword)
+		assert.Nil(t, restoreError, "expected to successfully restore the piece with the key file")
+		assert.Equal(t, "content", (string)(piece.Content), "piece is not re-encrypted")
+		unlocked, unlockError := keyed.(encrypted.Identity).Unlock(context.Background(), credential.VaultPassword)
+		assert.Nil(t, unlockError, "expected to successfully unlock the identity")
+		page, listError := unlocked.List(context.Background(), gophkeeper.ListOptions{})
+		assert.Nil(t, listError, "expected to successfully list with the key file")
+		assert.Equal(t, "piece", page.Resources[0].Meta, "meta is not re-encrypted")
+
+		changeError = keyed.(encrypted.Identity).ChangeVaultKey(
+			context.Background(),
+			credential.VaultPassword,
+			credential.VaultPassword,
+			nil,
+		)
+		assert.Nil(t, changeError, "expected to successfully turn the key file off")
+		settings, settingsError = identity.Settings(context.Background())
+		assert.Nil(t, settingsError, "expected to successfully get the settings")
+		assert.False(t, settings.KeyFile, "expected the key file to be turned off in the settings")
+		piece, restoreError = identity.RestorePiece(context.Background(), rid, credential.VaultPassword)
+		assert.Nil(t, restoreError, "expected to successfully restore the piece without the key file")
+		assert.Equal(t, "content", (string)(piece.Content), "piece is not re-encrypted")
+	})
+
+	t.Run("Legacy format", func(t *testing.T) {
+		var (
+			g = encrypted.Gophkeeper{
+				Origin: virtual.New(time.Hour, t.TempDir()),
+				Cipher: encrypted.CFBCipher{},
+				KDF:    testKDF,
+			}
+			credential = gophkeeper.Credential{
+				Username:      "test",
+				Password:      "qwerty",
+				VaultPassword: "qwerty",
+			}
+		)
+		registerError := g.Register(context.Background(), credential)
+		assert.Nil(t, registerError, "expected to successfully register")
+		token, authenticateError := g.Authenticate(context.Background(), credential)
+		assert.Nil(t, authenticateError, "expected to successfully authenticate")
+		identity, identityError := g.Identity(context.Background(), token)
+		assert.Nil(t, identityError, "expected to successfully get the identity")
+
+		// The content used to be encrypted with the key derived from the password.
+		var (
+			salt = ([]byte)("saltsalt")
+			iv   = ([]byte)("0123456789abcdef")
+		)
+		block, blockError := aes.NewCipher(pbkdf2.Key(([]byte)(credential.Password), salt, 4096, 32, sha256.New))
+		assert.Nil(t, blockError, "did not expect an error")
+		content := make([]byte, len("legacy"))
+		cipher.NewCFBEncrypter(block, iv).XORKeyStream(content, ([]byte)("legacy"))
+		m, metaError := json.Marshal(map[string]any{"iv": iv, "salt": salt, "content": "meta"})
+		assert.Nil(t, metaError, "did not expect an error")
+		rid, storeError := identity.(encrypted.Identity).Origin.StorePiece(
+			context.Background(),
+			gophkeeper.Piece{Meta: (string)(m), Content: content},
+			credential.Password,
+		)
+		assert.Nil(t, storeError, "expected to successfully store a piece")
+
+		piece, restoreError := identity.RestorePiece(context.Background(), rid, credential.Password)
+		assert.Nil(t, restoreError, "expected to successfully restore the piece")
+		assert.Equal(t, gophkeeper.Piece{Meta: "meta", Content: ([]byte)("legacy")}, piece, "incorrect piece")
+
+		changeError := identity.ChangeVaultPassword(context.Background(), credential.Password, "ytrewq")
+		assert.Nil(t, changeError, "expected to successfully change the vault password")
+		piece, restoreError = identity.RestorePiece(context.Background(), rid, "ytrewq")
+		assert.Nil(t, restoreError, "expected to successfully restore the piece")
+		assert.Equal(t, gophkeeper.Piece{Meta: "meta", Content: ([]byte)("legacy")}, piece, "incorrect piece")
+	})
+
+	t.Run("Envelope", func(t *testing.T) {
+		var (
+			g = encrypted.Gophkeeper{
+				Origin: virtual.New(time.Hour, t.TempDir()),
+				Cipher: encrypted.CFBCipher{},
+				KDF:    testKDF,
+			}
+			credential = gophkeeper.Credential{
+				Username:      "test",
+				Password:      "qwerty",
+				VaultPassword: "qwerty",
+			}
+		)
+		registerError := g.Register(context.Background(), credential)
+		assert.Nil(t, registerError, "expected to successfully register")
+		token, authenticateError := g.Authenticate(context.Background(), credential)
+		assert.Nil(t, authenticateError, "expected to successfully authenticate")
+		identity, identityError := g.Identity(context.Background(), token)
+		assert.Nil(t, identityError, "expected to successfully get the identity")
+		origin := identity.(encrypted.Identity).Origin
+
+		header := func(wrappedMeta string) map[string]any {
+			var h map[string]any
+			assert.Nil(t, json.Unmarshal(([]byte)(wrappedMeta), &h), "expected the meta to be JSON")
+			delete(h, "iv")
+			delete(h, "salt")
+			delete(h, "key")
+			delete(h, "sealed")
+			delete(h, "content")
+			return h
+		}
+
+		rid, storeError := identity.StorePiece(
+			context.Background(),
+			gophkeeper.Piece{Meta: "meta", Content: ([]byte)("content")},
+			credential.Password,
+		)
+		assert.Nil(t, storeError, "expected to successfully store a piece")
+		stored, storedError := origin.RestorePiece(context.Background(), rid, credential.Password)
+		assert.Nil(t, storedError, "expected to successfully restore the stored piece")
+		assert.Equal(
+			t,
+			map[string]any{
+				"v":        3.0,
+				"cipher":   "aes-256-gcm",
+				"kdf":      map[string]any{"alg": "argon2id", "t": 1.0, "m": 64.0, "p": 1.0},
+				"wrap":     "aes-256-gcm",
+				"meta_kdf": map[string]any{"alg": "argon2id", "t": 1.0, "m": 64.0, "p": 1.0},
+			},
+			header(stored.Meta),
+			"unexpected header",
+		)
+		upgraded, upgradeError := encrypted.UpgradeEnvelope(stored.Meta)
+		assert.Nil(t, upgradeError, "did not expect an error")
+		assert.Equal(t, stored.Meta, upgraded, "expected the newest envelope to be kept as is")
+
+		// Version 1 envelope of the content encrypted with the key derived from the password.
+		var (
+			salt = ([]byte)("saltsalt")
+			iv   = ([]byte)("0123456789abcdef")
+		)
+		block, blockError := aes.NewCipher(pbkdf2.Key(([]byte)(credential.Password), salt, 4096, 32, sha256.New))
+		assert.Nil(t, blockError, "did not expect an error")
+		content := make([]byte, len("legacy"))
+		cipher.NewCFBEncrypter(block, iv).XORKeyStream(content, ([]byte)("legacy"))
+		legacyMeta, metaError := json.Marshal(map[string]any{"iv": iv, "salt": salt, "content": "meta"})
+		assert.Nil(t, metaError, "did not expect an error")
+
+		upgraded, upgradeError = encrypted.UpgradeEnvelope((string)(legacyMeta))
+		assert.Nil(t, upgradeError, "did not expect an error")
+		assert.Equal(
+			t,
+			map[string]any{
+				"v":      3.0,
+				"cipher": "aes-256-cfb",
+				"kdf":    map[string]any{"alg": "pbkdf2-sha256", "t": 4096.0},
+				"wrap":   "none",
+			},
+			header(upgraded),
+			"unexpected header",
+		)
+		legacyRID, legacyStoreError := origin.StorePiece(
+			context.Background(),
+			gophkeeper.Piece{Meta: upgraded, Content: content},
+			credential.Password,
+		)
+		assert.Nil(t, legacyStoreError, "expected to successfully store a piece")
+		piece, restoreError := identity.RestorePiece(context.Background(), legacyRID, credential.Password)
+		assert.Nil(t, restoreError, "expected to successfully restore the upgraded piece")
+		assert.Equal(t, gophkeeper.Piece{Meta: "meta", Content: ([]byte)("legacy")}, piece, "incorrect piece")
+
+		for wrappedMeta, expected := range map[string]error{
+			"meta":                                   encrypted.ErrMalformedEnvelope,
+			`{"content":"meta"}`:                     encrypted.ErrMalformedEnvelope,
+			`{"v":2,"salt":"c2FsdA==","content":""}`: encrypted.ErrMalformedEnvelope,
+			`{"v":3,"cipher":"aes-256-gcm","kdf":{"alg":"argon2id","t":1,"m":64,"p":1},"wrap":"none","salt":"c2FsdA==","sealed":"c2VhbGVk"}`: encrypted.ErrMalformedEnvelope,
+			`{"v":4,"salt":"c2FsdA==","content":""}`: encrypted.ErrUnknownEnvelopeVersion,
+		} {
+			_, err := encrypted.UpgradeEnvelope(wrappedMeta)
+			assert.ErrorIs(t, err, expected, "unexpected error")
+
+			rid, storeError := origin.StorePiece(
+				context.Background(),
+				gophkeeper.Piece{Meta: wrappedMeta},
+				credential.Password,
+			)
+			assert.Nil(t, storeError, "expected to successfully store a piece")
+			_, restoreError := identity.RestorePiece(context.Background(), rid, credential.Password)
+			assert.ErrorIs(t, restoreError, expected, "unexpected error")
+		}
+	})
+
+	t.Run("Verifier", func(t *testing.T) {
+		var (
+			g = encrypted.Gophkeeper{
+				Origin:   virtual.New(time.Hour, t.TempDir()),
+				Cipher:   encrypted.CFBCipher{},
+				KDF:      testKDF,
+				Verifier: func(password string, _ []byte) (string, error) { return "verifier of " + password, nil },
+			}
+			credential = gophkeeper.Credential{
+				Username:      "test",
+				Password:      "qwerty",
+				VaultPassword: "qwerty",
+			}
+		)
+		registerError := g.Register(context.Background(), credential)
+		assert.Nil(t, registerError, "expected to successfully register")
+		token, authenticateError := g.Authenticate(context.Background(), credential)
+		assert.Nil(t, authenticateError, "expected to successfully authenticate")
+		identity, identityError := g.Identity(context.Background(), token)
+		assert.Nil(t, identityError, "expected to successfully get the identity")
+		origin := identity.(encrypted.Identity).Origin
+
+		rid, storeError := identity.StorePiece(
+			context.Background(),
+			gophkeeper.Piece{Meta: "meta", Content: ([]byte)("content")},
+			credential.Password,
+		)
+		assert.Nil(t, storeError, "expected to successfully store a piece")
+
+		_, originError := origin.RestorePiece(context.Background(), rid, credential.Password)
+		assert.ErrorIs(t, originError, gophkeeper.ErrBadCredential, "expected the origin to not know the password")
+		_, verifierError := origin.RestorePiece(context.Background(), rid, "verifier of "+credential.Password)
+		assert.Nil(t, verifierError, "expected the origin to know the verifier")
+
+		changeError := identity.ChangeVaultPassword(context.Background(), credential.Password, "ytrewq")
+		assert.Nil(t, changeError, "expected to successfully change the vault password")
+		piece, restoreError := identity.RestorePiece(context.Background(), rid, "ytrewq")
+		assert.Nil(t, restoreError, "expected to successfully restore the piece")
+		assert.Equal(t, gophkeeper.Piece{Meta: "meta", Content: ([]byte)("content")}, piece, "incorrect piece")
+		_, verifierError = origin.RestorePiece(context.Background(), rid, "verifier of ytrewq")
+		assert.Nil(t, verifierError, "expected the origin to know the new verifier")
+
+		verifier, err := encrypted.Verifier(credential.Password, ([]byte)("salt"))
+		assert.Nil(t, err, "did not expect an error")
+		again, err := encrypted.Verifier(credential.Password, ([]byte)("salt"))
+		assert.Nil(t, err, "did not expect an error")
+		assert.Equal(t, verifier, again, "expected the verifier to be stable")
+		assert.NotContains(t, verifier, credential.Password, "expected the verifier to hide the password")
+		other, err := encrypted.Verifier(credential.Password, ([]byte)("other salt"))
+		assert.Nil(t, err, "did not expect an error")
+		assert.NotEqual(t, verifier, other, "expected the verifier to be salted with the vault salt")
+	})
+
+	t.Run("Vault session", func(t *testing.T) {
+		var (
+			derived int
+			g       = encrypted.Gophkeeper{
+				Origin: virtual.New(time.Hour, t.TempDir()),
+				Cipher: encrypted.CFBCipher{},
+				KDF:    testKDF,
+				Verifier: func(password string, _ []byte) (string, error) {
+					derived++
+					return "verifier of " + password, nil
+				},
+			}
+			credential = gophkeeper.Credential{
+				Username:      "test",
+				Password:      "qwerty",
+				VaultPassword: "qwerty",
+			}
+		)
+		registerError := g.Register(context.Background(), credential)
+		assert.Nil(t, registerError, "expected to successfully register")
+		token, authenticateError := g.Authenticate(context.Background(), credential)
+		assert.Nil(t, authenticateError, "expected to successfully authenticate")
+		identity, identityError := g.Identity(context.Background(), token)
+		assert.Nil(t, identityError, "expected to successfully get the identity")
+		origin := &sessionOrigin{Identity: identity.(encrypted.Identity).Origin}
+		sessions := identity.(encrypted.Identity)
+		sessions.Origin = origin
+
+		unlocked, unlockError := sessions.Unlock(context.Background(), credential.VaultPassword)
+		assert.Nil(t, unlockError, "expected to successfully unlock the identity")
+		assert.Equal(t, []string{"verifier of qwerty"}, origin.unlocked, "expected a vault session to be opened with the verifier")
+
+		derived = 0
+		rid, storeError := unlocked.StorePiece(
+			context.Background(),
+			gophkeeper.Piece{Meta: "meta", Content: ([]byte)("content")},
+			credential.VaultPassword,
+		)
+		assert.Nil(t, storeError, "expected to successfully store a piece")
+		for n := 0; n < 2; n++ {
+			piece, restoreError := unlocked.RestorePiece(context.Background(), rid, credential.VaultPassword)
+			assert.Nil(t, restoreError, "expected to successfully restore the piece")
+			assert.Equal(t, "content", (string)(piece.Content), "incorrect piece content")
+		}
+		assert.Zero(t, derived, "expected the verifier to be derived once")
+		assert.Equal(t, 3, origin.sessionCalls, "expected the vault session to be used")
+
+		assert.Nil(t, unlocked.(gophkeeper.Locker).Lock(context.Background()), "expected to successfully lock the identity")
+		assert.Equal(t, 1, origin.locked, "expected the vault session to be revoked")
+	})
+
+	t.Run("No vault password", func(t *testing.T) {
+		var (
+			origin = virtual.New(time.Hour, t.TempDir())
+			g      = encrypted.Gophkeeper{
+				Origin:   origin,
+				Cipher:   encrypted.CFBCipher{},
+				KDF:      testKDF,
+				Verifier: encrypted.Verifier,
+			}
+			credential = gophkeeper.Credential{Username: "test", Password: "qwerty"}
+		)
+		registerError := g.Register(context.Background(), credential)
+		assert.ErrorIs(t, registerError, encrypted.ErrNoVaultPassword, "expected the vault password to be required")
+		_, authenticateError := origin.Authenticate(context.Background(), credential)
+		assert.ErrorIs(t, authenticateError, gophkeeper.ErrBadCredential, "expected the identity not to be registered")
+	})
+
+	t.Run("Vault salt", func(t *testing.T) {
+		var (
+			origin = virtual.New(time.Hour, t.TempDir())
+			g      = encrypted.Gophkeeper{
+				Origin: origin,
+				Cipher: encrypted.CFBCipher{},
+				KDF:    testKDF,
+			}
+			identities = make([]encrypted.Identity, 0, 3)
+		)
+		for _, username := range []string{"first", "second"} {
+			credential := gophkeeper.Credential{Username: username, Password: "qwerty", VaultPassword: "qwerty"}
+			registerError := g.Register(context.Background(), credential)
+			assert.Nil(t, registerError, "expected to successfully register")
+			token, authenticateError := g.Authenticate(context.Background(), credential)
+			assert.Nil(t, authenticateError, "expected to successfully authenticate")
+			identity, identityError := g.Identity(context.Background(), token)
+			assert.Nil(t, identityError, "expected to successfully get the identity")
+			identities = append(identities, identity.(encrypted.Identity))
+		}
+		assert.NotEmpty(t, identities[0].VaultSalt, "expected the vault to be salted")
+		assert.NotEqual(t, identities[0].VaultSalt, identities[1].VaultSalt, "expected the vault salts to differ")
+
+		// A vault registered before the vault salts is still opened.
+		legacy := gophkeeper.Credential{Username: "legacy", Password: "qwerty"}
+		registerError := origin.Register(context.Background(), legacy)
+		assert.Nil(t, registerError, "expected to successfully register")
+		token, authenticateError := g.Authenticate(context.Background(), legacy)
+		assert.Nil(t, authenticateError, "expected to successfully authenticate")
+		identity, identityError := g.Identity(context.Background(), token)
+		assert.Nil(t, identityError, "expected to successfully get the identity")
+		assert.Empty(t, identity.(encrypted.Identity).VaultSalt, "expected the vault not to be salted")
+		identities = append(identities, identity.(encrypted.Identity))
+
+		for _, identity := range identities {
+			_, storeError := identity.StorePiece(
+				context.Background(),
+				gophkeeper.Piece{Meta: "meta", Content: ([]byte)("content"), Index: []string{"term"}},
+				"qwerty",
+			)
+			assert.Nil(t, storeError, "expected to successfully store a piece")
+			unlocked, unlockError := identity.Unlock(context.Background(), "qwerty")
+			assert.Nil(t, unlockError, "expected to successfully unlock the identity")
+			resources, searchError := unlocked.Search(context.Background(), []string{"term"})
+			assert.Nil(t, searchError, "expected to successfully search")
+			assert.Equal(t, 1, len(resources), "expected the piece to be found")
+		}
+
+		// The meta keys of the same password differ between the vaults.
+		foreign := identities[1]
+		foreign.VaultSalt = identities[0].VaultSalt
+		unlocked, unlockError := foreign.Unlock(context.Background(), "qwerty")
+		assert.Nil(t, unlockError, "expected to successfully unlock the identity")
+		_, listError := unlocked.List(context.Background(), gophkeeper.ListOptions{})
+		assert.ErrorIs(t, listError, gophkeeper.ErrBadCredential, "expected the meta keys to be salted with the vault salt")
+
+		// The tokens of the same term differ between the vaults.
+		resources, searchError := unlocked.Search(context.Background(), []string{"term"})
+		assert.Nil(t, searchError, "expected to successfully search")
+		assert.Empty(t, resources, "expected the tokens to be salted with the vault salt")
+	})
+
+	t.Run("Migrate", func(t *testing.T) {
+		var (
+			origin = virtual.New(time.Hour, t.TempDir())
+			g      = encrypted.Gophkeeper{
+				Origin:   origin,
+				Cipher:   encrypted.CFBCipher{},
+				KDF:      testKDF,
+				Verifier: encrypted.Verifier,
+			}
+			// The vault is registered and filled by a client
+			// from before the client-side encryption.
+			credential = gophkeeper.Credential{Username: "legacy", Password: "qwerty"}
+		)
+		registerError := origin.Register(context.Background(), credential)
+		assert.Nil(t, registerError, "expected to successfully register")
+		token, authenticateError := g.Authenticate(context.Background(), credential)
+		assert.Nil(t, authenticateError, "expected to successfully authenticate")
+		legacy, legacyError := origin.Identity(context.Background(), token)
+		assert.Nil(t, legacyError, "expected to successfully get the identity")
+		pieceRID, storeError := legacy.StorePiece(
+			context.Background(),
+			gophkeeper.Piece{Meta: "plain piece", Content: ([]byte)("piece content")},
+			credential.Password,
+		)
+		assert.Nil(t, storeError, "expected to successfully store a piece")
+		blobRID, storeError := legacy.StoreBlob(
+			context.Background(),
+			gophkeeper.Blob{Meta: "plain blob", Content: io.NopCloser(strings.NewReader("blob content"))},
+			credential.Password,
+		)
+		assert.Nil(t, storeError, "expected to successfully store a blob")
+		assert.Nil(t, legacy.Tag(context.Background(), pieceRID, "plain tag"), "expected to successfully tag the piece")
+		_, folderError := legacy.CreateFolder(context.Background(), "plain folder", gophkeeper.RootFolder)
+		assert.Nil(t, folderError, "expected to successfully create a folder")
+
+		identity, identityError := g.Identity(context.Background(), token)
+		assert.Nil(t, identityError, "expected to successfully get the identity")
+		_, restoreError := identity.RestorePiece(context.Background(), pieceRID, credential.Password)
+		assert.ErrorIs(t, restoreError, gophkeeper.ErrBadCredential, "expected the vault to be checked with the password")
+
+		index := func(meta string) []string { return strings.Fields(meta) }
+		migrated, migrateError := identity.(encrypted.Identity).Migrate(context.Background(), credential.Password, index)
+		assert.Nil(t, migrateError, "expected to successfully migrate")
+		assert.Equal(t, 2, migrated, "expected both resources to be encrypted")
+
+		_, restoreError = legacy.RestorePiece(context.Background(), pieceRID, credential.Password)
+		assert.ErrorIs(t, restoreError, gophkeeper.ErrBadCredential, "expected the vault to be moved to the verifier")
+		stored, storedError := legacy.List(context.Background(), gophkeeper.ListOptions{})
+		assert.Nil(t, storedError, "expected to successfully list")
+		for _, resource := range stored.Resources {
+			assert.True(t, encrypted.IsEnvelope(resource.Meta), "expected the meta to be encrypted")
+			assert.NotContains(t, resource.Meta, "plain", "expected the meta to be encrypted")
+			assert.NotContains(t, resource.Tags, "plain tag", "expected the tags to be sealed")
+		}
+		storedFolders, storedFoldersError := legacy.ListFolders(context.Background())
+		assert.Nil(t, storedFoldersError, "expected to successfully list the folders")
+		assert.NotEqual(t, "plain folder", storedFolders[0].Name, "expected the folder name to be sealed")
+
+		piece, pieceError := identity.RestorePiece(context.Background(), pieceRID, credential.Password)
+		assert.Nil(t, pieceError, "expected to successfully restore the piece")
+		assert.Equal(t, "plain piece", piece.Meta, "unexpected meta")
+		assert.Equal(t, "piece content", (string)(piece.Content), "unexpected content")
+		blob, blobError := identity.RestoreBlob(context.Background(), blobRID, credential.Password)
+		assert.Nil(t, blobError, "expected to successfully restore the blob")
+		content, contentError := io.ReadAll(blob.Content)
+		assert.Nil(t, contentError, "expected to successfully read the blob")
+		assert.Nil(t, blob.Content.Close(), "failed to close blob content")
+		assert.Equal(t, "blob content", (string)(content), "unexpected content")
+
+		unlocked, unlockError := identity.(encrypted.Identity).Unlock(context.Background(), credential.Password)
+		assert.Nil(t, unlockError, "expected to successfully unlock the identity")
+		found, searchError := unlocked.Search(context.Background(), []string{"blob"})
+		assert.Nil(t, searchError, "expected to successfully search")
+		assert.Equal(t, 1, len(found), "expected the blob to be indexed")
+		found, searchError = unlocked.Search(context.Background(), []string{"plain tag"})
+		assert.Nil(t, searchError, "expected to successfully search")
+		assert.Equal(t, 1, len(found), "expected the piece to be found by its tag")
+		assert.Equal(t, []string{"plain tag"}, found[0].Tags, "expected the tag to be opened")
+		folders, foldersError := unlocked.ListFolders(context.Background())
+		assert.Nil(t, foldersError, "expected to successfully list the folders")
+		assert.Equal(t, "plain folder", folders[0].Name, "expected the folder name to be opened")
+
+		migrated, migrateError = identity.(encrypted.Identity).Migrate(context.Background(), credential.Password, index)
+		assert.Nil(t, migrateError, "expected to successfully migrate again")
+		assert.Zero(t, migrated, "expected nothing left to encrypt")
+	})
+
+	t.Run("KDF", func(t *testing.T) {
+		var (
+			origin = virtual.New(time.Hour, t.TempDir())
+			g      = encrypted.Gophkeeper{
+				Origin: origin,
+				Cipher: encrypted.CFBCipher{},
+			}
+			credential = gophkeeper.Credential{
+				Username:      "test",
+				Password:      "qwerty",
+				VaultPassword: "qwerty",
+			}
+		)
+		registerError := g.Register(context.Background(), credential)
+		assert.Nil(t, registerError, "expected to successfully register")
+		token, authenticateError := g.Authenticate(context.Background(), credential)
+		assert.Nil(t, authenticateError, "expected to successfully authenticate")
+		identity, identityError := g.Identity(context.Background(), token)
+		assert.Nil(t, identityError, "expected to successfully get the identity")
+		originIdentity := identity.(encrypted.Identity).Origin
+
+		storedKDF := func(rid gophkeeper.ResourceID) kdf.KDF {
+			piece, err := originIdentity.RestorePiece(context.Background(), rid, credential.Password)
+			assert.Nil(t, err, "expected to successfully restore the stored piece")
+			var m struct {
+				KDF *kdf.KDF `json:"kdf"`
+			}
+			assert.Nil(t, json.Unmarshal(([]byte)(piece.Meta), &m), "expected the meta to be JSON")
+			if m.KDF == nil {
+				return kdf.Legacy
+			}
+			return *m.KDF
+		}
+
+		rid, storeError := identity.StorePiece(
+			context.Background(),
+			gophkeeper.Piece{Meta: "meta", Content: ([]byte)("content")},
+			credential.Password,
+		)
+		assert.Nil(t, storeError, "expected to successfully store a piece")
+		assert.Equal(t, kdf.Default, storedKDF(rid), "expected the default KDF to be recorded")
+
+		// A resource stored with another KDF is still decrypted
+		// and is moved to the configured one when it is written.
+		legacy := identity.(encrypted.Identity)
+		legacy.KDF = kdf.Legacy
+		legacyRID, legacyStoreError := legacy.StorePiece(
+			context.Background(),
+			gophkeeper.Piece{Meta: "legacy", Content: ([]byte)("content")},
+			credential.Password,
+		)
+		assert.Nil(t, legacyStoreError, "expected to successfully store a piece")
+		assert.Equal(t, kdf.Legacy, storedKDF(legacyRID), "expected the legacy KDF to be recorded")
+
+		cheap := identity.(encrypted.Identity)
+		cheap.KDF = testKDF
+		piece, restoreError := cheap.RestorePiece(context.Background(), legacyRID, credential.Password)
+		assert.Nil(t, restoreError, "expected to successfully restore the piece")
+		assert.Equal(t, gophkeeper.Piece{Meta: "legacy", Content: ([]byte)("content")}, piece, "incorrect piece")
+
+		updateError := cheap.UpdatePiece(context.Background(), legacyRID, piece, credential.Password)
+		assert.Nil(t, updateError, "expected to successfully update the piece")
+		assert.Equal(t, testKDF, storedKDF(legacyRID), "expected the KDF to be upgraded")
+		piece, restoreError = cheap.RestorePiece(context.Background(), legacyRID, credential.Password)
+		assert.Nil(t, restoreError, "expected to successfully restore the piece")
+		assert.Equal(t, gophkeeper.Piece{Meta: "legacy", Content: ([]byte)("content")}, piece, "incorrect piece")
+	})
+
+	t.Run("Integrity", func(t *testing.T) {
+		for _, aead := range []encrypted.AEADCipher{encrypted.GCMCipher{}, encrypted.XChaCha20Poly1305Cipher{}} {
+			t.Run(aead.Name(), func(t *testing.T) {
+				var (
+					g = encrypted.Gophkeeper{
+						Origin: virtual.New(time.Hour, t.TempDir()),
+						Cipher: encrypted.CFBCipher{},
+						KDF:    testKDF,
+						AEAD:   aead,
+					}
+					credential = gophkeeper.Credential{
+						Username:      "test",
+						Password:      "qwerty",
+						VaultPassword: "qwerty",
+					}
+					chunk = 64 * 1024
+				)
+				registerError := g.Register(context.Background(), credential)
+				assert.Nil(t, registerError, "expected to successfully register")
+				token, authenticateError := g.Authenticate(context.Background(), credential)
+				assert.Nil(t, authenticateError, "expected to successfully authenticate")
+				identity, identityError := g.Identity(context.Background(), token)
+				assert.Nil(t, identityError, "expected to successfully get the identity")
+				origin := identity.(encrypted.Identity).Origin
+
+				for _, size := range []int{0, 1, chunk, chunk + 1, 3*chunk + 100} {
+					content := bytes.Repeat([]byte{'x'}, size)
+					rid, storeError := identity.StoreBlob(
+						context.Background(),
+						gophkeeper.Blob{Meta: "blob", Content: io.NopCloser(bytes.NewReader(content))},
+						credential.Password,
+					)
+					assert.Nil(t, storeError, "expected to successfully store a blob")
+					blob, restoreError := identity.RestoreBlob(context.Background(), rid, credential.Password)
+					assert.Nil(t, restoreError, "expected to successfully restore the blob")
+					restored, readError := io.ReadAll(blob.Content)
+					assert.Nil(t, readError, "did not expect an error")
+					assert.Nil(t, blob.Content.Close(), "failed to close blob content")
+					assert.Equal(t, content, restored, "incorrect content of %d bytes", size)
+				}
+
+				pieceRID, storePieceError := identity.StorePiece(
+					context.Background(),
+					gophkeeper.Piece{Meta: "piece", Content: ([]byte)("content")},
+					credential.Password,
+				)
+				assert.Nil(t, storePieceError, "expected to successfully store a piece")
+				stored, storedError := origin.RestorePiece(context.Background(), pieceRID, credential.Password)
+				assert.Nil(t, storedError, "did not expect an error")
+				stored.Content[0] ^= 1
+				assert.Nil(t, origin.UpdatePiece(context.Background(), pieceRID, stored, credential.Password))
+				_, tamperedPieceError := identity.RestorePiece(context.Background(), pieceRID, credential.Password)
+				assert.ErrorIs(t, tamperedPieceError, gophkeeper.ErrIntegrity, "unexpected error")
+
+				blobRID, storeBlobError := identity.StoreBlob(
+					context.Background(),
+					gophkeeper.Blob{Meta: "blob", Content: io.NopCloser(bytes.NewReader(bytes.Repeat([]byte{'y'}, 3*chunk)))},
+					credential.Password,
+				)
+				assert.Nil(t, storeBlobError, "expected to successfully store a blob")
+				storedBlob, storedBlobError := origin.RestoreBlob(context.Background(), blobRID, credential.Password)
+				assert.Nil(t, storedBlobError, "did not expect an error")
+				sealed, sealedError := io.ReadAll(storedBlob.Content)
+				assert.Nil(t, sealedError, "did not expect an error")
+				assert.Nil(t, storedBlob.Content.Close(), "failed to close blob content")
+				sealedChunk := len(sealed) / 3
+
+				tamper := func(content []byte) error {
+					updateError := origin.UpdateBlob(
+						context.Background(),
+						blobRID,
+						gophkeeper.Blob{Meta: storedBlob.Meta, Content: io.NopCloser(bytes.NewReader(content))},
+						credential.Password,
+					)
+					assert.Nil(t, updateError, "did not expect an error")
+					blob, restoreError := identity.RestoreBlob(context.Background(), blobRID, credential.Password)
+					if restoreError != nil {
+						return restoreError
+					}
+					defer blob.Content.Close()
+					_, readError := io.ReadAll(blob.Content)
+					return readError
+				}
+
+				flipped := append([]byte{}, sealed...)
+				flipped[len(flipped)-sealedChunk] ^= 1
+				assert.ErrorIs(t, tamper(flipped), gophkeeper.ErrIntegrity, "expected a bit flip to be detected")
+
+				flippedFirst := append([]byte{}, sealed...)
+				flippedFirst[0] ^= 1
+				assert.ErrorIs(t, tamper(flippedFirst), gophkeeper.ErrIntegrity, "expected a bit flip to be detected")
+				_, restoreFlippedError := identity.RestoreBlob(context.Background(), blobRID, credential.Password)
+				assert.ErrorIs(t, restoreFlippedError, gophkeeper.ErrIntegrity, "expected the first chunk to be opened by RestoreBlob")
+
+				truncated := append([]byte{}, sealed[:2*sealedChunk]...)
+				assert.ErrorIs(t, tamper(truncated), gophkeeper.ErrIntegrity, "expected truncation to be detected")
+
+				reordered := append(append([]byte{}, sealed[sealedChunk:2*sealedChunk]...), sealed[:sealedChunk]...)
+				reordered = append(reordered, sealed[2*sealedChunk:]...)
+				assert.ErrorIs(t, tamper(reordered), gophkeeper.ErrIntegrity, "expected reordering to be detected")
+
+				appended := append(append([]byte{}, sealed...), 'z')
+				assert.ErrorIs(t, tamper(appended), gophkeeper.ErrIntegrity, "expected trailing data to be detected")
+
+				assert.Nil(t, tamper(sealed), "expected the intact content to be restored")
+			})
+		}
+	})
+
+	t.Run("Incorrect input", func(t *testing.T) {
+		var (
+			g = encrypted.Gophkeeper{
+				Origin: virtual.New(time.Hour, t.TempDir()),
+				Cipher: encrypted.CFBCipher{},
+				KDF:    testKDF,
+			}
+			credential = gophkeeper.Credential{
+				Username:      "test",
+				Password:      "qwerty",
+				VaultPassword: "qwerty",
+			}
+		)
+
+		registerError := g.Register(context.Background(), credential)
+		assert.Nil(t, registerError, "expected to successfully register")
+
+		token, authenticateError := g.Authenticate(context.Background(), credential)
+		assert.Nil(t, authenticateError, "expected to successfully authenticate")
+
+		identity, identityError := g.Identity(context.Background(), token)
+		assert.Nil(t, identityError, "expected to successfully get the identity")
+
+		_, restoreIncorrectPieceError := identity.RestorePiece(context.Background(), -1, credential.Password)
+		assert.NotNil(t, restoreIncorrectPieceError)
+
+		_, restoreIncorrectBlobError := identity.RestoreBlob(context.Background(), -1, credential.Password)
+		assert.NotNil(t, restoreIncorrectBlobError)
+
+		_, invalidPasswordError := identity.StorePiece(context.Background(), gophkeeper.Piece{}, "")
+		assert.NotNil(t, invalidPasswordError)
+
+		_, invalidPasswordError = identity.StoreBlob(context.Background(), gophkeeper.Blob{Content: io.NopCloser(strings.NewReader(""))}, "")
+		assert.NotNil(t, invalidPasswordError)
+
+		_, err := (identity.(encrypted.Identity)).Origin.StorePiece(
+			context.Background(),
+			gophkeeper.Piece{
+				Meta:    "not a json",
+				Content: ([]byte)("test"),
+			},
+			credential.Password,
+		)
+		assert.Nil(t, err)
+		_, invalidContentError := identity.List(context.Background(), gophkeeper.ListOptions{})
+		assert.NotNil(t, invalidContentError)
+	})
+}
+
+// sessionOrigin is an origin that opens vault sessions.
+type sessionOrigin struct {
+	gophkeeper.Identity
+
+	unlocked     []string
+	sessionCalls int
+	locked       int
+}
+
+// Unlock implements gophkeeper.Unlocker.
+func (o *sessionOrigin) Unlock(_ context.Context, password string) (gophkeeper.Identity, error) {
+	o.unlocked = append(o.unlocked, password)
+	return vaultSession{Identity: o.Identity, origin: o}, nil
+}
+
+// vaultSession is a vault session of sessionOrigin.
+type vaultSession struct {
+	gophkeeper.Identity
+
+	origin *sessionOrigin
+}
+
+// StorePiece implements gophkeeper.Identity.
+func (s vaultSession) StorePiece(ctx context.Context, piece gophkeeper.Piece, password string) (gophkeeper.ResourceID, error) {
+	s.origin.sessionCalls++
+	return s.Identity.StorePiece(ctx, piece, password)
+}
+
+// RestorePiece implements gophkeeper.Identity.
+func (s vaultSession) RestorePiece(ctx context.Context, rid gophkeeper.ResourceID, password string) (gophkeeper.Piece, error) {
+	s.origin.sessionCalls++
+	return s.Identity.RestorePiece(ctx, rid, password)
+}
+
+// Lock implements gophkeeper.Locker.
+func (s vaultSession) Lock(context.Context) error {
+	s.origin.locked++
+	return nil
+}
+
+// seekableContent is blob content that can be read twice.
+type seekableContent struct {
+	*strings.Reader
+}
+
+func (seekableContent) Close() error {
+	return nil
+}
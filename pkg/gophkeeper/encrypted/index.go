@@ -0,0 +1,46 @@
+package encrypted
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"strings"
+
+	encryption "github.com/kerelape/gophkeeper/pkg/gophkeeper/encrypted/internal"
+	"github.com/kerelape/gophkeeper/pkg/gophkeeper/kdf"
+)
+
+var (
+	// indexKDF derives the index keys, tokens derived with other
+	// parameters do not match the ones stored by the origin,
+	// so they must never change.
+	indexKDF = kdf.KDF{Algorithm: kdf.Argon2id, Time: 3, Memory: 64 * 1024, Threads: 4}
+
+	// indexSalt separates the index keys from the other
+	// keys derived from the same password and vault salt.
+	indexSalt = ([]byte)("gophkeeper index key")
+)
+
+// indexKey derives the index key of the password salted with
+// the vault salt, so the tokens of the same term differ
+// between the vaults with the same password.
+func indexKey(password string, vaultSalt []byte) ([]byte, error) {
+	return indexKDF.Key(password, saltOf(indexSalt, vaultSalt), encryption.KeyLen)
+}
+
+// blind returns the tokens of the terms, the origin can only tell
+// whether two tokens are of the same term, it can not tell the term.
+//
+// Terms are matched case-insensitively.
+func blind(key []byte, terms []string) []string {
+	if len(terms) == 0 {
+		return nil
+	}
+	tokens := make([]string, 0, len(terms))
+	for _, term := range terms {
+		mac := hmac.New(sha256.New, key)
+		mac.Write(([]byte)(strings.ToLower(term)))
+		tokens = append(tokens, base64.RawURLEncoding.EncodeToString(mac.Sum(nil)))
+	}
+	return tokens
+}
@@ -0,0 +1,95 @@
+// Package encryption provides encryption utils.
+package encryption
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+)
+
+const (
+	// KeyLen is length of the keys.
+	KeyLen = 32
+
+	saltLen = 16
+)
+
+// ErrKeyUnwrap is returned when a wrapped data key can not be unwrapped,
+// usually because the password is not the one it was wrapped with.
+var ErrKeyUnwrap = errors.New("failed to unwrap data key")
+
+// NewKey returns a fresh random data key.
+func NewKey() ([]byte, error) {
+	key := make([]byte, KeyLen)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// NewSalt returns a fresh random salt.
+func NewSalt() ([]byte, error) {
+	salt := make([]byte, saltLen)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	return salt, nil
+}
+
+// ErrOpen is returned when sealed data can not be opened,
+// usually because the key is not the one it was sealed with.
+var ErrOpen = errors.New("failed to open sealed data")
+
+// Seal encrypts and authenticates the plaintext with the key.
+func Seal(plaintext []byte, key []byte) ([]byte, error) {
+	aead, aeadError := sealer(key)
+	if aeadError != nil {
+		return nil, aeadError
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, plaintext, nil), nil
+}
+
+// Open decrypts the data sealed by Seal.
+func Open(sealed []byte, key []byte) ([]byte, error) {
+	aead, aeadError := sealer(key)
+	if aeadError != nil {
+		return nil, aeadError
+	}
+	if len(sealed) < aead.NonceSize() {
+		return nil, ErrOpen
+	}
+
+	plaintext, openError := aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], nil)
+	if openError != nil {
+		return nil, errors.Join(ErrOpen, openError)
+	}
+	return plaintext, nil
+}
+
+// Wrap wraps the data key with the key-encryption key.
+func Wrap(key []byte, kek []byte) ([]byte, error) {
+	return Seal(key, kek)
+}
+
+// Unwrap unwraps the data key wrapped by Wrap.
+func Unwrap(wrapped []byte, kek []byte) ([]byte, error) {
+	key, openError := Open(wrapped, kek)
+	if openError != nil {
+		return nil, errors.Join(ErrKeyUnwrap, openError)
+	}
+	return key, nil
+}
+
+func sealer(key []byte) (cipher.AEAD, error) {
+	block, blockError := aes.NewCipher(key)
+	if blockError != nil {
+		return nil, blockError
+	}
+	return cipher.NewGCM(block)
+}
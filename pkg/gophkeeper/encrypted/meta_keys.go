@@ -0,0 +1,200 @@
+package encrypted
+
+import (
+	"sync"
+
+	encryption "github.com/kerelape/gophkeeper/pkg/gophkeeper/encrypted/internal"
+	"github.com/kerelape/gophkeeper/pkg/gophkeeper/kdf"
+)
+
+// metaKeySalt separates the meta keys from the other
+// keys derived from the same password and vault salt.
+var metaKeySalt = ([]byte)("gophkeeper meta key")
+
+// metaKeys are the keys the meta is sealed with,
+// derived from the password by the KDFs they are asked for,
+// the key the meta is indexed with and the keys the tags
+// and the folder names are sealed with.
+//
+// A meta key is the same for every resource of the vault,
+// so the meta of all of them is opened with a single derivation.
+// It is salted with the vault salt, so it differs between
+// the vaults with the same password.
+//
+// The verifier of the password and the key-encryption keys are
+// derived once as well. The data keys wrapped by the same meta keys
+// are wrapped with the same salt, so wrapping them takes
+// a single derivation too.
+type metaKeys struct {
+	password  string
+	vaultSalt []byte
+
+	mutex    sync.Mutex
+	keys     map[kdf.KDF][]byte
+	indexKey []byte
+	nameKeys *nameKeys
+	verifier *string
+	keks     map[kekID][]byte
+	wrapSalt []byte
+}
+
+// kekID identifies a key-encryption key.
+type kekID struct {
+	keyDerivation kdf.KDF
+	salt          string
+}
+
+func newMetaKeys(password string, vaultSalt []byte) *metaKeys {
+	return &metaKeys{
+		password:  password,
+		vaultSalt: vaultSalt,
+		keys:      make(map[kdf.KDF][]byte),
+		keks:      make(map[kekID][]byte),
+	}
+}
+
+// key returns the meta key derived by the KDF.
+func (k *metaKeys) key(keyDerivation kdf.KDF) ([]byte, error) {
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+
+	if key, ok := k.keys[keyDerivation]; ok {
+		return key, nil
+	}
+	key, keyError := keyDerivation.Key(k.password, saltOf(metaKeySalt, k.vaultSalt), encryption.KeyLen)
+	if keyError != nil {
+		return nil, keyError
+	}
+	k.keys[keyDerivation] = key
+	return key, nil
+}
+
+// index returns the tokens of the terms.
+func (k *metaKeys) index(terms []string) ([]string, error) {
+	if len(terms) == 0 {
+		return nil, nil
+	}
+
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+
+	key, keyError := k.loadIndexKey()
+	if keyError != nil {
+		return nil, keyError
+	}
+	return blind(key, terms), nil
+}
+
+// sealName returns the sealed tag or folder name.
+func (k *metaKeys) sealName(name string) (string, error) {
+	keys, keysError := k.names()
+	if keysError != nil {
+		return "", keysError
+	}
+	return keys.seal(name), nil
+}
+
+// openName returns the tag or the folder name, opening it if it is sealed.
+func (k *metaKeys) openName(name string) (string, error) {
+	if !isSealedName(name) {
+		return name, nil
+	}
+	if k == nil {
+		return "", ErrLocked
+	}
+	keys, keysError := k.names()
+	if keysError != nil {
+		return "", keysError
+	}
+	return keys.open(name)
+}
+
+// convergence returns the key the data keys
+// of the deduplicated blobs are derived with.
+func (k *metaKeys) convergence() ([]byte, error) {
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+
+	key, keyError := k.loadIndexKey()
+	if keyError != nil {
+		return nil, keyError
+	}
+	return newConvergenceKey(key), nil
+}
+
+// names returns the keys the names are sealed with.
+func (k *metaKeys) names() (nameKeys, error) {
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+
+	if k.nameKeys == nil {
+		key, keyError := k.loadIndexKey()
+		if keyError != nil {
+			return nameKeys{}, keyError
+		}
+		keys := newNameKeys(key)
+		k.nameKeys = &keys
+	}
+	return *k.nameKeys, nil
+}
+
+// loadIndexKey returns the index key deriving it once,
+// the mutex must be held.
+func (k *metaKeys) loadIndexKey() ([]byte, error) {
+	if k.indexKey == nil {
+		key, keyError := indexKey(k.password, k.vaultSalt)
+		if keyError != nil {
+			return nil, keyError
+		}
+		k.indexKey = key
+	}
+	return k.indexKey, nil
+}
+
+// verifierOf returns the verifier of the password derived by the verifier.
+func (k *metaKeys) verifierOf(verifier func(password string, vaultSalt []byte) (string, error)) (string, error) {
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+
+	if k.verifier == nil {
+		derived, derivationError := verifier(k.password, k.vaultSalt)
+		if derivationError != nil {
+			return "", derivationError
+		}
+		k.verifier = &derived
+	}
+	return *k.verifier, nil
+}
+
+// kek returns the key-encryption key derived from
+// the password and the salt by the KDF.
+func (k *metaKeys) kek(keyDerivation kdf.KDF, salt []byte) ([]byte, error) {
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+
+	id := kekID{keyDerivation: keyDerivation, salt: (string)(salt)}
+	if kek, ok := k.keks[id]; ok {
+		return kek, nil
+	}
+	kek, kekError := keyDerivation.Key(k.password, salt, encryption.KeyLen)
+	if kekError != nil {
+		return nil, kekError
+	}
+	k.keks[id] = kek
+	return kek, nil
+}
+
+// salt returns the salt the data keys are wrapped with.
+func (k *metaKeys) salt() ([]byte, error) {
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+
+	if k.wrapSalt == nil {
+		salt, saltError := encryption.NewSalt()
+		if saltError != nil {
+			return nil, saltError
+		}
+		k.wrapSalt = salt
+	}
+	return k.wrapSalt, nil
+}
@@ -0,0 +1,183 @@
+package encrypted
+
+import (
+	"context"
+	"errors"
+	"slices"
+
+	"github.com/kerelape/gophkeeper/pkg/gophkeeper"
+)
+
+// Migrate encrypts the resources stored in clear text before the vault
+// was encrypted by the client and returns how many of them it encrypted.
+// The index of a resource is built from its meta by index, the resources
+// are not indexed if it is nil.
+//
+// The vault of an account registered before the verifiers is checked
+// with the password itself, so it is moved to the verifier first.
+// Every resource is updated in place and keeps its ID, folder and tags,
+// its previous versions and the trashed resources are left as they are.
+// The tags and the folder names stored in clear text are sealed last,
+// that requires the origin to be a gophkeeper.Reencrypter.
+// An interrupted migration is resumed by calling it again.
+func (i Identity) Migrate(ctx context.Context, password string, index func(meta string) []string) (int, error) {
+	secret := i.secret(password)
+	verifier, verifierError := i.verifier(secret)
+	if verifierError != nil {
+		return 0, verifierError
+	}
+	if verifier != secret {
+		// The vault moved to the verifier already refuses the password.
+		err := i.Origin.ChangeVaultPassword(ctx, secret, verifier)
+		if err != nil && !errors.Is(err, gophkeeper.ErrBadCredential) {
+			return 0, err
+		}
+	}
+
+	plain, plainError := i.plainResources(ctx)
+	if plainError != nil {
+		return 0, plainError
+	}
+	for n, resource := range plain {
+		gophkeeper.ReportProgress(ctx, n, len(plain))
+		if err := i.migrate(ctx, resource, password, verifier, index); err != nil {
+			return n, err
+		}
+	}
+	if err := i.sealNames(ctx, password, verifier); err != nil {
+		return len(plain), err
+	}
+	gophkeeper.ReportProgress(ctx, len(plain), len(plain))
+	return len(plain), nil
+}
+
+// sealNames seals the tags and the folder names stored in clear text,
+// the vault is re-encrypted with the same password keeping the meta
+// and the content as they are.
+func (i Identity) sealNames(ctx context.Context, password, verifier string) error {
+	plain, plainError := i.hasPlainNames(ctx)
+	if plainError != nil || !plain {
+		return plainError
+	}
+	origin, ok := i.Origin.(gophkeeper.Reencrypter)
+	if !ok {
+		return ErrReencryptionUnsupported
+	}
+	keys := i.metaKeysOf(i.secret(password))
+	reencryption := gophkeeper.Reencryption{
+		Meta: func(meta string) (string, error) {
+			return meta, nil
+		},
+		TagIndex: func(sealedTag string) ([]string, error) {
+			tag, openError := keys.openName(sealedTag)
+			if openError != nil {
+				return nil, openError
+			}
+			return keys.index([]string{tag})
+		},
+		Tag:    resealName(keys, keys),
+		Folder: resealName(keys, keys),
+	}
+	return origin.Reencrypt(ctx, verifier, verifier, reencryption)
+}
+
+// hasPlainNames reports whether the origin has a tag
+// or a folder name stored in clear text.
+func (i Identity) hasPlainNames(ctx context.Context) (bool, error) {
+	folders, foldersError := i.Origin.ListFolders(ctx)
+	if foldersError != nil {
+		return false, foldersError
+	}
+	for _, folder := range folders {
+		if !isSealedName(folder.Name) {
+			return true, nil
+		}
+	}
+	var options gophkeeper.ListOptions
+	for {
+		page, pageError := i.Origin.List(ctx, options)
+		if pageError != nil {
+			return false, pageError
+		}
+		for _, resource := range page.Resources {
+			if slices.ContainsFunc(resource.Tags, isPlainName) {
+				return true, nil
+			}
+		}
+		if page.Next == "" {
+			break
+		}
+		options.Cursor = page.Next
+	}
+	trash, trashError := i.Origin.ListTrash(ctx)
+	if trashError != nil {
+		return false, trashError
+	}
+	for _, resource := range trash {
+		if slices.ContainsFunc(resource.Tags, isPlainName) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// isPlainName reports whether the name is stored in clear text.
+func isPlainName(name string) bool {
+	return !isSealedName(name)
+}
+
+// plainResources returns the resources of the origin
+// with the meta not wrapped into an envelope.
+func (i Identity) plainResources(ctx context.Context) ([]gophkeeper.Resource, error) {
+	var (
+		plain   = make([]gophkeeper.Resource, 0)
+		options gophkeeper.ListOptions
+	)
+	for {
+		page, pageError := i.Origin.List(ctx, options)
+		if pageError != nil {
+			return nil, pageError
+		}
+		for _, resource := range page.Resources {
+			if !IsEnvelope(resource.Meta) {
+				plain = append(plain, resource)
+			}
+		}
+		if page.Next == "" {
+			return plain, nil
+		}
+		options.Cursor = page.Next
+	}
+}
+
+// migrate restores the resource stored in clear text
+// from the origin and updates it with the encrypted one.
+func (i Identity) migrate(
+	ctx context.Context,
+	resource gophkeeper.Resource,
+	password, verifier string,
+	index func(meta string) []string,
+) error {
+	var terms []string
+	if index != nil {
+		terms = index(resource.Meta)
+	}
+	switch resource.Type {
+	case gophkeeper.ResourceTypePiece:
+		piece, pieceError := i.Origin.RestorePiece(ctx, resource.ID, verifier)
+		if pieceError != nil {
+			return pieceError
+		}
+		piece.Index = terms
+		return i.UpdatePiece(ctx, resource.ID, piece, password)
+	case gophkeeper.ResourceTypeBlob:
+		blob, blobError := i.Origin.RestoreBlob(ctx, resource.ID, verifier)
+		if blobError != nil {
+			return blobError
+		}
+		blob.Index = terms
+		return i.UpdateBlob(ctx, resource.ID, blob, password)
+	default:
+		return errors.New("unknown resource type")
+	}
+}
@@ -0,0 +1,92 @@
+package encrypted
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"strings"
+
+	"github.com/kerelape/gophkeeper/pkg/gophkeeper"
+)
+
+// sealedNamePrefix marks the sealed tags and folder names,
+// the ones stored before they were sealed are kept as is.
+const sealedNamePrefix = "sealed:"
+
+var (
+	// nameMACSalt separates the key the sealed names are
+	// authenticated with from the index key they are derived from.
+	nameMACSalt = ([]byte)("gophkeeper name mac")
+
+	// nameCipherSalt separates the key the sealed names are
+	// encrypted with from the index key they are derived from.
+	nameCipherSalt = ([]byte)("gophkeeper name cipher")
+)
+
+// nameKeys are the keys the tags and the folder names are sealed with.
+type nameKeys struct {
+	mac    []byte
+	cipher []byte
+}
+
+// newNameKeys derives the name keys from the index key.
+func newNameKeys(indexKey []byte) nameKeys {
+	derive := func(salt []byte) []byte {
+		mac := hmac.New(sha256.New, indexKey)
+		mac.Write(salt)
+		return mac.Sum(nil)
+	}
+	return nameKeys{mac: derive(nameMACSalt), cipher: derive(nameCipherSalt)}
+}
+
+// seal seals the name deterministically, the same name is sealed
+// the same way, so the origin matches the sealed tags without
+// learning them.
+//
+// The IV is the MAC of the name, the name is encrypted with AES-CTR
+// under it, so the sealed name is authenticated by the IV itself.
+func (k nameKeys) seal(name string) string {
+	iv := k.iv(([]byte)(name))
+	sealed := make([]byte, len(iv)+len(name))
+	copy(sealed, iv)
+	k.stream(iv).XORKeyStream(sealed[len(iv):], ([]byte)(name))
+	return sealedNamePrefix + base64.RawURLEncoding.EncodeToString(sealed)
+}
+
+// open opens the sealed name.
+func (k nameKeys) open(sealedName string) (string, error) {
+	sealed, decodeError := base64.RawURLEncoding.DecodeString(strings.TrimPrefix(sealedName, sealedNamePrefix))
+	if decodeError != nil || len(sealed) < aes.BlockSize {
+		return "", ErrMalformedEnvelope
+	}
+	iv, name := sealed[:aes.BlockSize], make([]byte, len(sealed)-aes.BlockSize)
+	k.stream(iv).XORKeyStream(name, sealed[aes.BlockSize:])
+	if !hmac.Equal(iv, k.iv(name)) {
+		return "", gophkeeper.ErrBadCredential
+	}
+	return (string)(name), nil
+}
+
+// iv returns the IV of the name.
+func (k nameKeys) iv(name []byte) []byte {
+	mac := hmac.New(sha256.New, k.mac)
+	mac.Write(name)
+	return mac.Sum(nil)[:aes.BlockSize]
+}
+
+// stream returns the AES-CTR stream of the IV.
+func (k nameKeys) stream(iv []byte) cipher.Stream {
+	block, blockError := aes.NewCipher(k.cipher)
+	if blockError != nil {
+		// The key is always of a valid length.
+		panic(blockError)
+	}
+	return cipher.NewCTR(block, iv)
+}
+
+// isSealedName reports whether the name is sealed.
+func isSealedName(name string) bool {
+	return strings.HasPrefix(name, sealedNamePrefix)
+}
@@ -0,0 +1,22 @@
+package encrypted
+
+import "crypto/rand"
+
+// vaultSaltLen is the length of the vault salts.
+const vaultSaltLen = 16
+
+// newVaultSalt returns a new random vault salt.
+func newVaultSalt() ([]byte, error) {
+	salt := make([]byte, vaultSaltLen)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	return salt, nil
+}
+
+// saltOf returns the salt of the keys of the purpose derived for
+// the vault with the vault salt. The keys of the vaults registered
+// before the vault salts are derived with the purpose alone.
+func saltOf(purpose, vaultSalt []byte) []byte {
+	return append(purpose[:len(purpose):len(purpose)], vaultSalt...)
+}
@@ -0,0 +1,37 @@
+package encrypted
+
+import (
+	"encoding/base64"
+
+	"github.com/kerelape/gophkeeper/pkg/gophkeeper/kdf"
+)
+
+var (
+	// verifierKDF derives the verifiers, verifiers derived with
+	// other parameters do not match the ones stored by the origin,
+	// so they must never change.
+	verifierKDF = kdf.KDF{Algorithm: kdf.Argon2id, Time: 3, Memory: 64 * 1024, Threads: 4}
+
+	// verifierSalt separates the verifiers from the other
+	// keys derived from the same password and vault salt.
+	verifierSalt = ([]byte)("gophkeeper vault verifier")
+)
+
+const verifierLen = 32
+
+// Verifier derives the verifier of the password that stands for it
+// at the origin. The origin can not reveal the password and the keys
+// derived from it by the verifier, guessing the password from it takes
+// as long as guessing it from the content.
+//
+// The verifier is salted with the vault salt, so the verifiers of the
+// same password differ between the vaults and every guess is paid for
+// each vault on its own. The verifiers of the vaults without a salt
+// are only salted with verifierSalt.
+func Verifier(password string, vaultSalt []byte) (string, error) {
+	key, keyError := verifierKDF.Key(password, saltOf(verifierSalt, vaultSalt), verifierLen)
+	if keyError != nil {
+		return "", keyError
+	}
+	return base64.RawStdEncoding.EncodeToString(key), nil
+}
@@ -0,0 +1,22 @@
+package encrypted
+
+import (
+	"crypto/cipher"
+
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+// XChaCha20Poly1305Cipher is an XChaCha20-Poly1305 AEADCipher.
+type XChaCha20Poly1305Cipher struct{}
+
+var _ AEADCipher = (*XChaCha20Poly1305Cipher)(nil)
+
+// Name implements AEADCipher.
+func (c XChaCha20Poly1305Cipher) Name() string {
+	return "xchacha20-poly1305"
+}
+
+// AEAD implements AEADCipher.
+func (c XChaCha20Poly1305Cipher) AEAD(key []byte) (cipher.AEAD, error) {
+	return chacha20poly1305.NewX(key)
+}
@@ -0,0 +1,29 @@
+package gophkeeper
+
+import "errors"
+
+type (
+	// FolderID is id of a folder.
+	FolderID int64
+
+	// Folder is a folder information.
+	Folder struct {
+		ID     FolderID
+		Parent FolderID
+		Name   string
+	}
+)
+
+// RootFolder is the top level folder of every vault,
+// it always exists and can not be moved.
+const RootFolder FolderID = 0
+
+var (
+	// ErrFolderNotFound is returned when there is no
+	// folder with the FolderID (or it's owned by another identity).
+	ErrFolderNotFound = errors.New("folder not found")
+
+	// ErrFolderCycle is returned when a folder is moved
+	// into itself or into one of its subfolders.
+	ErrFolderCycle = errors.New("folder can not be moved into itself")
+)
@@ -14,6 +14,10 @@ var (
 
 	// ErrIdentityDuplicate indecates that there is already such an identity.
 	ErrIdentityDuplicate = errors.New("identity already exists")
+
+	// ErrUnavailable indicates that the storage of the gophkeeper
+	// can't be reached for now, the request may be retried later.
+	ErrUnavailable = errors.New("storage unavailable")
 )
 
 type (
@@ -24,6 +28,14 @@ type (
 	Credential struct {
 		Username string
 		Password string
+
+		// VaultPassword is the password of the vault set up
+		// on registration, the Password is used if it is empty.
+		VaultPassword string
+
+		// VaultSalt is the salt the keys of the vault are derived
+		// with, it is set up on registration along with the vault password.
+		VaultSalt []byte
 	}
 )
 
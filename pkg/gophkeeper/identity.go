@@ -9,21 +9,28 @@ import (
 type (
 	// Piece is a piece of encrypted information.
 	Piece struct {
-		Content []byte // Content of the piece.
-		Meta    string // Meta info of the piece.
+		Content []byte   // Content of the piece.
+		Meta    string   // Meta info of the piece.
+		Index   []string // Tokens the piece is searched by.
 	}
 
 	// Blob is an encrypted blob.
 	Blob struct {
 		Content io.ReadCloser // Content of the blob.
 		Meta    string        // Meta info of the blob.
+		Index   []string      // Tokens the blob is searched by.
 	}
 )
 
 // ErrResourceNotFound is returned when there is no
-// resource with the ResourceID (or it's owned by another identity).
+// resource with the ResourceID (or it's owned by another identity),
+// or the resource has no such version.
 var ErrResourceNotFound = errors.New("resource not found")
 
+// ErrIntegrity is returned when the stored content
+// is found to be tampered with or corrupted.
+var ErrIntegrity = errors.New("content integrity check failed")
+
 // Identity is a gophkeeper's identity.
 type Identity interface {
 	// StorePiece stores a piece and returns its ResourceID.
@@ -38,9 +45,71 @@ type Identity interface {
 	// RestoreBlob restores a blob by ResourceID.
 	RestoreBlob(ctx context.Context, rid ResourceID, password string) (Blob, error)
 
-	// Delete deletes the resource by ResourceID.
+	// UpdatePiece replaces the piece stored under the ResourceID
+	// keeping the ResourceID unchanged.
+	UpdatePiece(ctx context.Context, rid ResourceID, piece Piece, password string) error
+
+	// UpdateBlob replaces the blob stored under the ResourceID
+	// keeping the ResourceID unchanged.
+	UpdateBlob(ctx context.Context, rid ResourceID, blob Blob, password string) error
+
+	// ListVersions returns previous revisions of the resource
+	// starting from the latest one.
+	ListVersions(ctx context.Context, rid ResourceID) ([]ResourceVersion, error)
+
+	// RestoreVersion makes a previous revision of the resource current,
+	// the replaced state is kept as a new revision.
+	RestoreVersion(ctx context.Context, rid ResourceID, version int, password string) error
+
+	// Delete moves the resource to the trash by ResourceID.
 	Delete(context.Context, ResourceID) error
 
-	// List returns list of all stored resources.
-	List(context.Context) ([]Resource, error)
+	// ListTrash returns list of all resources in the trash.
+	ListTrash(context.Context) ([]TrashedResource, error)
+
+	// Undelete moves the resource back from the trash by ResourceID.
+	Undelete(context.Context, ResourceID) error
+
+	// Purge permanently deletes the resource in the trash by ResourceID.
+	Purge(context.Context, ResourceID) error
+
+	// CreateFolder creates a folder in the parent folder
+	// and returns its FolderID.
+	CreateFolder(ctx context.Context, name string, parent FolderID) (FolderID, error)
+
+	// ListFolders returns list of all folders.
+	ListFolders(context.Context) ([]Folder, error)
+
+	// MoveFolder moves the folder into the parent folder.
+	MoveFolder(ctx context.Context, folder FolderID, parent FolderID) error
+
+	// MoveResource moves the resource into the folder.
+	MoveResource(ctx context.Context, rid ResourceID, folder FolderID) error
+
+	// Tag tags the resource with the tag, the resource
+	// is searched by the index tokens of the tag as well.
+	Tag(ctx context.Context, rid ResourceID, tag string, index ...string) error
+
+	// Untag removes the tag from the resource.
+	Untag(ctx context.Context, rid ResourceID, tag string) error
+
+	// List returns a page of stored resources matching the options.
+	List(context.Context, ListOptions) (ListPage, error)
+
+	// Search returns the stored resources indexed by all of the tokens,
+	// either by their own index or by the index of their tags.
+	Search(ctx context.Context, tokens []string) ([]Resource, error)
+
+	// ChangePassword changes the login password of the identity.
+	ChangePassword(ctx context.Context, oldPassword, newPassword string) error
+
+	// ChangeVaultPassword changes the vault password of the identity,
+	// the stored content is re-encrypted if needed.
+	ChangeVaultPassword(ctx context.Context, oldPassword, newPassword string) error
+
+	// Settings returns the account settings of the identity.
+	Settings(context.Context) (Settings, error)
+
+	// UpdateSettings replaces the account settings of the identity.
+	UpdateSettings(context.Context, Settings) error
 }
@@ -0,0 +1,64 @@
+// Package kdf provides password based key derivation functions.
+package kdf
+
+import (
+	"crypto/sha256"
+	"errors"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/pbkdf2"
+)
+
+const (
+	// Argon2id is the Argon2id algorithm.
+	Argon2id = "argon2id"
+
+	// PBKDF2SHA256 is the PBKDF2 algorithm with HMAC-SHA256.
+	PBKDF2SHA256 = "pbkdf2-sha256"
+)
+
+// KDF is a key derivation function with its parameters,
+// it is meant to be stored along with the keys it derives.
+type KDF struct {
+	Algorithm string `json:"alg"`
+	Time      uint32 `json:"t"`           // Number of passes or iterations.
+	Memory    uint32 `json:"m,omitempty"` // Memory in KiB, Argon2id only.
+	Threads   uint8  `json:"p,omitempty"` // Degree of parallelism, Argon2id only.
+}
+
+var (
+	// Default is Argon2id with the parameters recommended
+	// by RFC 9106 for memory constrained environments.
+	Default = KDF{Algorithm: Argon2id, Time: 3, Memory: 64 * 1024, Threads: 4}
+
+	// Legacy is the KDF the keys were derived with
+	// before the KDF was stored along with them.
+	Legacy = KDF{Algorithm: PBKDF2SHA256, Time: 4096}
+)
+
+var (
+	// ErrUnknownAlgorithm is returned when the algorithm is not known.
+	ErrUnknownAlgorithm = errors.New("unknown key derivation algorithm")
+
+	// ErrInvalidParameters is returned when the parameters
+	// are not valid for the algorithm.
+	ErrInvalidParameters = errors.New("invalid key derivation parameters")
+)
+
+// Key derives a key of the length from the password and the salt.
+func (k KDF) Key(password string, salt []byte, length uint32) ([]byte, error) {
+	switch k.Algorithm {
+	case Argon2id:
+		if k.Time < 1 || k.Threads < 1 || k.Memory < 8*(uint32)(k.Threads) {
+			return nil, ErrInvalidParameters
+		}
+		return argon2.IDKey(([]byte)(password), salt, k.Time, k.Memory, k.Threads, length), nil
+	case PBKDF2SHA256:
+		if k.Time < 1 {
+			return nil, ErrInvalidParameters
+		}
+		return pbkdf2.Key(([]byte)(password), salt, (int)(k.Time), (int)(length), sha256.New), nil
+	default:
+		return nil, ErrUnknownAlgorithm
+	}
+}
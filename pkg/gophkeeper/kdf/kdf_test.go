@@ -0,0 +1,36 @@
+package kdf
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestKDF(t *testing.T) {
+	var (
+		salt = ([]byte)("saltsaltsaltsalt")
+		fast = KDF{Algorithm: Argon2id, Time: 1, Memory: 64, Threads: 1}
+	)
+
+	key, keyError := fast.Key("qwerty", salt, 32)
+	assert.Nil(t, keyError, "did not expect an error")
+	assert.Equal(t, 32, len(key), "unexpected key length")
+
+	again, againError := fast.Key("qwerty", salt, 32)
+	assert.Nil(t, againError, "did not expect an error")
+	assert.Equal(t, key, again, "expected the key to be deterministic")
+
+	other, otherError := KDF{Algorithm: Argon2id, Time: 2, Memory: 64, Threads: 1}.Key("qwerty", salt, 32)
+	assert.Nil(t, otherError, "did not expect an error")
+	assert.NotEqual(t, key, other, "expected the parameters to change the key")
+
+	legacy, legacyError := Legacy.Key("qwerty", salt, 32)
+	assert.Nil(t, legacyError, "did not expect an error")
+	assert.NotEqual(t, key, legacy, "expected the algorithm to change the key")
+
+	_, unknownError := KDF{Algorithm: "md5"}.Key("qwerty", salt, 32)
+	assert.ErrorIs(t, unknownError, ErrUnknownAlgorithm, "unexpected error")
+
+	_, invalidError := KDF{Algorithm: Argon2id}.Key("qwerty", salt, 32)
+	assert.ErrorIs(t, invalidError, ErrInvalidParameters, "unexpected error")
+}
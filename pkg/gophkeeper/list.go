@@ -0,0 +1,52 @@
+package gophkeeper
+
+import "errors"
+
+// ListOrder is an order resources are listed in.
+type ListOrder int
+
+const (
+	// ListOrderID orders resources by ResourceID.
+	ListOrderID ListOrder = iota
+
+	// ListOrderCreated orders resources by creation time.
+	ListOrderCreated
+
+	// ListOrderUpdated orders resources by last update time.
+	ListOrderUpdated
+
+	// ListOrderSize orders resources by content size.
+	ListOrderSize
+)
+
+type (
+	// ListOptions filters, orders and paginates
+	// resources returned by Identity.List.
+	ListOptions struct {
+		ID         *ResourceID   // ResourceID of the resource, any if nil.
+		Folder     *FolderID     // Folder the resources are directly in, any if nil.
+		Tag        string        // Tag the resources are tagged with, any if empty.
+		Type       *ResourceType // Type of the resources, any if nil.
+		MetaPrefix string        // Prefix of the resources meta, any if empty.
+
+		Order      ListOrder
+		Descending bool
+
+		Limit  int    // Maximum number of resources in the page, unlimited if zero.
+		Cursor string // ListPage.Next of the previous page, first page if empty.
+	}
+
+	// ListPage is a page of resources.
+	//
+	// A page may hold fewer resources than the limit even
+	// if there are more of them, the listing is over only
+	// when Next is empty.
+	ListPage struct {
+		Resources []Resource
+		Next      string
+	}
+)
+
+// ErrInvalidCursor is returned when the cursor is malformed
+// or was issued for a listing in another order.
+var ErrInvalidCursor = errors.New("invalid cursor")
@@ -0,0 +1,73 @@
+package gophkeeper
+
+import (
+	"context"
+	"errors"
+)
+
+// ErrStaleReencryption is returned when the content changes while
+// it is being re-encrypted aside, the re-encryption can be retried.
+var ErrStaleReencryption = errors.New("content changed while being re-encrypted")
+
+type (
+	// Reencryption rewrites stored pieces and blobs
+	// from one vault password to another.
+	Reencryption struct {
+		Piece func(Piece) (Piece, error)
+		Blob  func(Blob) (Blob, error)
+
+		// Meta, if set, rewrites only the meta of pieces and blobs,
+		// their content is kept as is and Piece and Blob are not used.
+		Meta func(meta string) (string, error)
+
+		// Index, if set, returns the index of the rewritten meta,
+		// it replaces the index of the state the meta is of.
+		Index func(meta string) ([]string, error)
+
+		// TagIndex, if set, returns the index of the tag,
+		// it replaces the index of every resource tagged with it.
+		TagIndex func(tag string) ([]string, error)
+
+		// Tag, if set, rewrites the tags of every resource,
+		// TagIndex is given the tag as it was before.
+		Tag func(tag string) (string, error)
+
+		// Folder, if set, rewrites the names of the folders.
+		Folder func(name string) (string, error)
+	}
+
+	// Reencrypter is an identity that can re-encrypt
+	// its content while changing the vault password.
+	Reencrypter interface {
+		// Reencrypt changes the vault password of the identity and rewrites
+		// every piece and blob, including previous versions and trashed
+		// resources, with the reencryption.
+		//
+		// Either all of the content and the password are replaced or none
+		// of them, an interrupted call is resumed by calling it again with
+		// the same passwords.
+		Reencrypt(ctx context.Context, oldPassword, newPassword string, reencryption Reencryption) error
+
+		// Metas returns the meta of every state Reencrypt rewrites.
+		Metas(ctx context.Context, password string) ([]string, error)
+	}
+
+	// Progress is notified about progress of a long running operation.
+	Progress func(done, total int)
+)
+
+type progressKey struct{}
+
+// WithProgress returns a context that reports progress
+// of long running operations to the progress.
+func WithProgress(ctx context.Context, progress Progress) context.Context {
+	return context.WithValue(ctx, progressKey{}, progress)
+}
+
+// ReportProgress reports progress to the Progress
+// of the context if there is one.
+func ReportProgress(ctx context.Context, done, total int) {
+	if progress, ok := ctx.Value(progressKey{}).(Progress); ok {
+		progress(done, total)
+	}
+}
@@ -1,5 +1,7 @@
 package gophkeeper
 
+import "time"
+
 type (
 	// ResourceID is id of a resource.
 	ResourceID int64
@@ -9,5 +11,28 @@ type (
 		ID   ResourceID
 		Type ResourceType
 		Meta string
+
+		Folder FolderID
+		Tags   []string
+
+		CreatedAt  time.Time
+		UpdatedAt  time.Time
+		AccessedAt time.Time // zero if the resource has never been restored.
+
+		Size int64  // size of the stored content in bytes.
+		Hash []byte // SHA-256 of the stored content.
+	}
+
+	// TrashedResource is a resource moved to the trash.
+	TrashedResource struct {
+		Resource
+		DeletedAt time.Time
+	}
+
+	// ResourceVersion is a previous revision of a resource.
+	ResourceVersion struct {
+		Version   int
+		Meta      string
+		CreatedAt time.Time
 	}
 )
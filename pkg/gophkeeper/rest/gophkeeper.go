@@ -25,12 +25,17 @@ var _ gophkeeper.Gophkeeper = (*Gophkeeper)(nil)
 // Register implements Gophkeeper.
 func (g *Gophkeeper) Register(ctx context.Context, credential gophkeeper.Credential) error {
 	endpoint := fmt.Sprintf("%s/register", g.Server)
-	content, marshalError := json.Marshal(
-		map[string]any{
-			"username": credential.Username,
-			"password": credential.Password,
-		},
-	)
+	body := map[string]any{
+		"username": credential.Username,
+		"password": credential.Password,
+	}
+	if credential.VaultPassword != "" {
+		body["vault_password"] = credential.VaultPassword
+	}
+	if credential.VaultSalt != nil {
+		body["vault_salt"] = credential.VaultSalt
+	}
+	content, marshalError := json.Marshal(body)
 	if marshalError != nil {
 		return marshalError
 	}
@@ -7,7 +7,12 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"time"
 
 	"github.com/kerelape/gophkeeper/pkg/gophkeeper"
 )
@@ -15,14 +20,32 @@ import (
 // ErrServerIsDown is returns when server returned an internal server error.
 var ErrServerIsDown = errors.New("server is down")
 
+// ErrContentReencryption is returned when the content is asked
+// to be re-encrypted, only the meta can be re-encrypted remotely.
+var ErrContentReencryption = errors.New("content can not be re-encrypted remotely")
+
+// reencryptionAttempts is how many times a re-encryption
+// is tried when the content changes in the meantime.
+const reencryptionAttempts = 3
+
 // Identity is rest identity.
+//
+// An identity unlocked with the vault password passes
+// its vault session instead of the password, see Unlock.
 type Identity struct {
 	Client http.Client
 	Server string
 	Token  gophkeeper.Token
+
+	session *vaultSession
 }
 
-var _ gophkeeper.Identity = (*Identity)(nil)
+var (
+	_ gophkeeper.Identity    = (*Identity)(nil)
+	_ gophkeeper.Reencrypter = (*Identity)(nil)
+	_ gophkeeper.Unlocker    = (*Identity)(nil)
+	_ gophkeeper.Locker      = (*Identity)(nil)
+)
 
 // StorePiece implements Identity.
 func (i *Identity) StorePiece(ctx context.Context, piece gophkeeper.Piece, password string) (gophkeeper.ResourceID, error) {
@@ -31,6 +54,7 @@ func (i *Identity) StorePiece(ctx context.Context, piece gophkeeper.Piece, passw
 		map[string]any{
 			"meta":    piece.Meta,
 			"content": base64.RawStdEncoding.EncodeToString(piece.Content),
+			"index":   piece.Index,
 		},
 	)
 	if contentError != nil {
@@ -45,7 +69,7 @@ func (i *Identity) StorePiece(ctx context.Context, piece gophkeeper.Piece, passw
 		return -1, requestError
 	}
 	request.Header.Set("Authorization", (string)(i.Token))
-	request.Header.Set("X-Password", password)
+	i.setPassword(request, password)
 
 	response, responseError := i.Client.Do(request)
 	if responseError != nil {
@@ -85,7 +109,7 @@ func (i *Identity) RestorePiece(ctx context.Context, rid gophkeeper.ResourceID,
 		return gophkeeper.Piece{}, requestError
 	}
 	request.Header.Set("Authorization", (string)(i.Token))
-	request.Header.Set("X-Password", password)
+	i.setPassword(request, password)
 
 	response, responseError := i.Client.Do(request)
 	if responseError != nil {
@@ -149,8 +173,11 @@ func (i *Identity) StoreBlob(ctx context.Context, blob gophkeeper.Blob, password
 		return -1, requestError
 	}
 	request.Header.Set("Authorization", (string)(i.Token))
-	request.Header.Set("X-Password", password)
+	i.setPassword(request, password)
 	request.Header.Set("X-Meta", blob.Meta)
+	for _, token := range blob.Index {
+		request.Header.Add("X-Index", token)
+	}
 
 	response, responseError := i.Client.Do(request)
 	if responseError != nil {
@@ -188,7 +215,7 @@ func (i *Identity) RestoreBlob(ctx context.Context, rid gophkeeper.ResourceID, p
 		return gophkeeper.Blob{}, requestError
 	}
 	request.Header.Set("Authorization", (string)(i.Token))
-	request.Header.Set("X-Password", password)
+	i.setPassword(request, password)
 
 	response, responseError := i.Client.Do(request)
 	if responseError != nil {
@@ -213,6 +240,182 @@ func (i *Identity) RestoreBlob(ctx context.Context, rid gophkeeper.ResourceID, p
 	}
 }
 
+// UpdatePiece implements Identity.
+func (i *Identity) UpdatePiece(ctx context.Context, rid gophkeeper.ResourceID, piece gophkeeper.Piece, password string) error {
+	endpoint := fmt.Sprintf("%s/vault/piece/%d", i.Server, rid)
+	content, contentError := json.Marshal(
+		map[string]any{
+			"meta":    piece.Meta,
+			"content": base64.RawStdEncoding.EncodeToString(piece.Content),
+			"index":   piece.Index,
+		},
+	)
+	if contentError != nil {
+		return contentError
+	}
+	request, requestError := http.NewRequestWithContext(
+		ctx,
+		http.MethodPost, endpoint,
+		bytes.NewReader(content),
+	)
+	if requestError != nil {
+		return requestError
+	}
+	request.Header.Set("Authorization", (string)(i.Token))
+	i.setPassword(request, password)
+
+	response, responseError := i.Client.Do(request)
+	if responseError != nil {
+		return responseError
+	}
+	defer response.Body.Close()
+
+	switch response.StatusCode {
+	case http.StatusOK:
+		return nil
+	case http.StatusUnauthorized:
+		return gophkeeper.ErrBadCredential
+	case http.StatusNotFound:
+		return gophkeeper.ErrResourceNotFound
+	default:
+		return errors.Join(
+			fmt.Errorf("unexpected response status: %d", response.StatusCode),
+			ErrIncompatibleAPI,
+		)
+	}
+}
+
+// UpdateBlob implements Identity.
+func (i *Identity) UpdateBlob(ctx context.Context, rid gophkeeper.ResourceID, blob gophkeeper.Blob, password string) error {
+	endpoint := fmt.Sprintf("%s/vault/blob/%d", i.Server, rid)
+	request, requestError := http.NewRequestWithContext(
+		ctx,
+		http.MethodPost, endpoint,
+		blob.Content,
+	)
+	if requestError != nil {
+		return requestError
+	}
+	request.Header.Set("Authorization", (string)(i.Token))
+	i.setPassword(request, password)
+	request.Header.Set("X-Meta", blob.Meta)
+	for _, token := range blob.Index {
+		request.Header.Add("X-Index", token)
+	}
+
+	response, responseError := i.Client.Do(request)
+	if responseError != nil {
+		return responseError
+	}
+	defer response.Body.Close()
+
+	switch response.StatusCode {
+	case http.StatusOK:
+		return nil
+	case http.StatusUnauthorized:
+		return gophkeeper.ErrBadCredential
+	case http.StatusNotFound:
+		return gophkeeper.ErrResourceNotFound
+	default:
+		return errors.Join(
+			fmt.Errorf("unexpected response status: %d", response.StatusCode),
+			ErrIncompatibleAPI,
+		)
+	}
+}
+
+// ListVersions implements Identity.
+func (i *Identity) ListVersions(ctx context.Context, rid gophkeeper.ResourceID) ([]gophkeeper.ResourceVersion, error) {
+	endpoint := fmt.Sprintf("%s/vault/%d/versions", i.Server, rid)
+	request, requestError := http.NewRequestWithContext(
+		ctx,
+		http.MethodGet, endpoint,
+		nil,
+	)
+	if requestError != nil {
+		return nil, requestError
+	}
+	request.Header.Set("Authorization", (string)(i.Token))
+
+	response, responseError := i.Client.Do(request)
+	if responseError != nil {
+		return nil, responseError
+	}
+	defer response.Body.Close()
+
+	switch response.StatusCode {
+	case http.StatusOK:
+		responseContent := make(
+			[]struct {
+				Version   int       `json:"version"`
+				Meta      string    `json:"meta"`
+				CreatedAt time.Time `json:"created_at"`
+			},
+			0,
+		)
+		if err := json.NewDecoder(response.Body).Decode(&responseContent); err != nil {
+			return nil, errors.Join(
+				fmt.Errorf("parse response: %w", err),
+				ErrIncompatibleAPI,
+			)
+		}
+		versions := make([]gophkeeper.ResourceVersion, 0, len(responseContent))
+		for _, responseVersion := range responseContent {
+			versions = append(
+				versions,
+				gophkeeper.ResourceVersion{
+					Version:   responseVersion.Version,
+					Meta:      responseVersion.Meta,
+					CreatedAt: responseVersion.CreatedAt,
+				},
+			)
+		}
+		return versions, nil
+	case http.StatusNotFound:
+		return nil, gophkeeper.ErrResourceNotFound
+	default:
+		return nil, errors.Join(
+			fmt.Errorf("unexpected response code: %d", response.StatusCode),
+			ErrIncompatibleAPI,
+		)
+	}
+}
+
+// RestoreVersion implements Identity.
+func (i *Identity) RestoreVersion(ctx context.Context, rid gophkeeper.ResourceID, version int, password string) error {
+	endpoint := fmt.Sprintf("%s/vault/%d/versions/%d", i.Server, rid, version)
+	request, requestError := http.NewRequestWithContext(
+		ctx,
+		http.MethodPost, endpoint,
+		nil,
+	)
+	if requestError != nil {
+		return requestError
+	}
+	request.Header.Set("Authorization", (string)(i.Token))
+	i.setPassword(request, password)
+
+	response, responseError := i.Client.Do(request)
+	if responseError != nil {
+		return responseError
+	}
+	defer response.Body.Close()
+
+	switch response.StatusCode {
+	case http.StatusOK:
+		return nil
+	case http.StatusUnauthorized:
+		return gophkeeper.ErrBadCredential
+	case http.StatusNotFound:
+		return gophkeeper.ErrResourceNotFound
+	default:
+		return errors.Join(
+			fmt.Errorf("unexpected response code: %d", response.StatusCode),
+			ErrIncompatibleAPI,
+		)
+	}
+}
+
 // Delete implements Identity.
 func (i *Identity) Delete(ctx context.Context, rid gophkeeper.ResourceID) error {
 	endpoint := fmt.Sprintf("%s/vault/%d", i.Server, rid)
@@ -244,9 +447,9 @@ func (i *Identity) Delete(ctx context.Context, rid gophkeeper.ResourceID) error
 	}
 }
 
-// List implements Identity.
-func (i *Identity) List(ctx context.Context) ([]gophkeeper.Resource, error) {
-	endpoint := fmt.Sprintf("%s/vault", i.Server)
+// ListTrash implements Identity.
+func (i *Identity) ListTrash(ctx context.Context) ([]gophkeeper.TrashedResource, error) {
+	endpoint := fmt.Sprintf("%s/vault/trash", i.Server)
 	request, requestError := http.NewRequestWithContext(
 		ctx,
 		http.MethodGet, endpoint,
@@ -267,23 +470,24 @@ func (i *Identity) List(ctx context.Context) ([]gophkeeper.Resource, error) {
 	case http.StatusOK:
 		responseContent := make(
 			[]struct {
-				Meta string                  `json:"meta"`
-				RID  gophkeeper.ResourceID   `json:"rid"`
-				Type gophkeeper.ResourceType `json:"type"`
+				resourceResponse
+				DeletedAt time.Time `json:"deleted_at"`
 			},
 			0,
 		)
 		if err := json.NewDecoder(response.Body).Decode(&responseContent); err != nil {
-			return nil, err
+			return nil, errors.Join(
+				fmt.Errorf("parse response: %w", err),
+				ErrIncompatibleAPI,
+			)
 		}
-		resources := make([]gophkeeper.Resource, 0, len(responseContent))
+		resources := make([]gophkeeper.TrashedResource, 0, len(responseContent))
 		for _, responseResource := range responseContent {
 			resources = append(
 				resources,
-				gophkeeper.Resource{
-					ID:   responseResource.RID,
-					Type: responseResource.Type,
-					Meta: responseResource.Meta,
+				gophkeeper.TrashedResource{
+					Resource:  responseResource.resource(),
+					DeletedAt: responseResource.DeletedAt,
 				},
 			)
 		}
@@ -295,3 +499,717 @@ func (i *Identity) List(ctx context.Context) ([]gophkeeper.Resource, error) {
 		)
 	}
 }
+
+// Undelete implements Identity.
+func (i *Identity) Undelete(ctx context.Context, rid gophkeeper.ResourceID) error {
+	return i.trash(ctx, http.MethodPost, rid)
+}
+
+// Purge implements Identity.
+func (i *Identity) Purge(ctx context.Context, rid gophkeeper.ResourceID) error {
+	return i.trash(ctx, http.MethodDelete, rid)
+}
+
+func (i *Identity) trash(ctx context.Context, method string, rid gophkeeper.ResourceID) error {
+	endpoint := fmt.Sprintf("%s/vault/trash/%d", i.Server, rid)
+	request, requestError := http.NewRequestWithContext(
+		ctx,
+		method, endpoint,
+		nil,
+	)
+	if requestError != nil {
+		return requestError
+	}
+	request.Header.Set("Authorization", (string)(i.Token))
+
+	response, responseError := i.Client.Do(request)
+	if responseError != nil {
+		return responseError
+	}
+	defer response.Body.Close()
+
+	switch response.StatusCode {
+	case http.StatusOK:
+		return nil
+	case http.StatusNotFound:
+		return gophkeeper.ErrResourceNotFound
+	default:
+		return errors.Join(
+			fmt.Errorf("unexpected response code: %d", response.StatusCode),
+			ErrIncompatibleAPI,
+		)
+	}
+}
+
+// List implements Identity.
+func (i *Identity) List(ctx context.Context, options gophkeeper.ListOptions) (gophkeeper.ListPage, error) {
+	query := url.Values{}
+	if options.ID != nil {
+		query.Set("rid", strconv.FormatInt((int64)(*options.ID), 10))
+	}
+	if options.Folder != nil {
+		query.Set("folder", strconv.FormatInt((int64)(*options.Folder), 10))
+	}
+	if options.Tag != "" {
+		query.Set("tag", options.Tag)
+	}
+	if options.Type != nil {
+		query.Set("type", strconv.Itoa((int)(*options.Type)))
+	}
+	if options.MetaPrefix != "" {
+		query.Set("prefix", options.MetaPrefix)
+	}
+	if options.Order != gophkeeper.ListOrderID {
+		query.Set("order", strconv.Itoa((int)(options.Order)))
+	}
+	if options.Descending {
+		query.Set("desc", "true")
+	}
+	if options.Limit > 0 {
+		query.Set("limit", strconv.Itoa(options.Limit))
+	}
+	if options.Cursor != "" {
+		query.Set("cursor", options.Cursor)
+	}
+	endpoint := fmt.Sprintf("%s/vault?%s", i.Server, query.Encode())
+	request, requestError := http.NewRequestWithContext(
+		ctx,
+		http.MethodGet, endpoint,
+		nil,
+	)
+	if requestError != nil {
+		return gophkeeper.ListPage{}, requestError
+	}
+	request.Header.Set("Authorization", (string)(i.Token))
+
+	response, responseError := i.Client.Do(request)
+	if responseError != nil {
+		return gophkeeper.ListPage{}, responseError
+	}
+	defer response.Body.Close()
+
+	switch response.StatusCode {
+	case http.StatusOK:
+		responseContent := make([]resourceResponse, 0)
+		if err := json.NewDecoder(response.Body).Decode(&responseContent); err != nil {
+			return gophkeeper.ListPage{}, err
+		}
+		page := gophkeeper.ListPage{
+			Resources: make([]gophkeeper.Resource, 0, len(responseContent)),
+			Next:      response.Header.Get("X-Next-Cursor"),
+		}
+		for _, responseResource := range responseContent {
+			page.Resources = append(page.Resources, responseResource.resource())
+		}
+		return page, nil
+	case http.StatusBadRequest:
+		if options.Cursor != "" {
+			return gophkeeper.ListPage{}, gophkeeper.ErrInvalidCursor
+		}
+		fallthrough
+	default:
+		return gophkeeper.ListPage{}, errors.Join(
+			fmt.Errorf("unexpected response code: %d", response.StatusCode),
+			ErrIncompatibleAPI,
+		)
+	}
+}
+
+// Search implements Identity.
+func (i *Identity) Search(ctx context.Context, tokens []string) ([]gophkeeper.Resource, error) {
+	endpoint := fmt.Sprintf("%s/vault/search?%s", i.Server, url.Values{"token": tokens}.Encode())
+	request, requestError := http.NewRequestWithContext(
+		ctx,
+		http.MethodGet, endpoint,
+		nil,
+	)
+	if requestError != nil {
+		return nil, requestError
+	}
+	request.Header.Set("Authorization", (string)(i.Token))
+
+	response, responseError := i.Client.Do(request)
+	if responseError != nil {
+		return nil, responseError
+	}
+	defer response.Body.Close()
+
+	switch response.StatusCode {
+	case http.StatusOK:
+		responseContent := make([]resourceResponse, 0)
+		if err := json.NewDecoder(response.Body).Decode(&responseContent); err != nil {
+			return nil, errors.Join(
+				fmt.Errorf("parse response: %w", err),
+				ErrIncompatibleAPI,
+			)
+		}
+		resources := make([]gophkeeper.Resource, 0, len(responseContent))
+		for _, responseResource := range responseContent {
+			resources = append(resources, responseResource.resource())
+		}
+		return resources, nil
+	case http.StatusInternalServerError:
+		return nil, ErrServerIsDown
+	default:
+		return nil, errors.Join(
+			fmt.Errorf("unexpected response code: %d", response.StatusCode),
+			ErrIncompatibleAPI,
+		)
+	}
+}
+
+// CreateFolder implements Identity.
+func (i *Identity) CreateFolder(ctx context.Context, name string, parent gophkeeper.FolderID) (gophkeeper.FolderID, error) {
+	endpoint := fmt.Sprintf("%s/vault/folders", i.Server)
+	content, contentError := json.Marshal(
+		map[string]any{
+			"name":   name,
+			"parent": (int64)(parent),
+		},
+	)
+	if contentError != nil {
+		return -1, contentError
+	}
+	request, requestError := http.NewRequestWithContext(
+		ctx,
+		http.MethodPut, endpoint,
+		bytes.NewReader(content),
+	)
+	if requestError != nil {
+		return -1, requestError
+	}
+	request.Header.Set("Authorization", (string)(i.Token))
+
+	response, responseError := i.Client.Do(request)
+	if responseError != nil {
+		return -1, responseError
+	}
+	defer response.Body.Close()
+
+	switch response.StatusCode {
+	case http.StatusCreated:
+		var content struct {
+			ID gophkeeper.FolderID `json:"id"`
+		}
+		if err := json.NewDecoder(response.Body).Decode(&content); err != nil {
+			return -1, errors.Join(
+				fmt.Errorf("parse response: %w", err),
+				ErrIncompatibleAPI,
+			)
+		}
+		return content.ID, nil
+	case http.StatusNotFound:
+		return -1, gophkeeper.ErrFolderNotFound
+	default:
+		return -1, errors.Join(
+			fmt.Errorf("unexpected response code: %d", response.StatusCode),
+			ErrIncompatibleAPI,
+		)
+	}
+}
+
+// ListFolders implements Identity.
+func (i *Identity) ListFolders(ctx context.Context) ([]gophkeeper.Folder, error) {
+	endpoint := fmt.Sprintf("%s/vault/folders", i.Server)
+	request, requestError := http.NewRequestWithContext(
+		ctx,
+		http.MethodGet, endpoint,
+		nil,
+	)
+	if requestError != nil {
+		return nil, requestError
+	}
+	request.Header.Set("Authorization", (string)(i.Token))
+
+	response, responseError := i.Client.Do(request)
+	if responseError != nil {
+		return nil, responseError
+	}
+	defer response.Body.Close()
+
+	switch response.StatusCode {
+	case http.StatusOK:
+		responseContent := make(
+			[]struct {
+				ID     gophkeeper.FolderID `json:"id"`
+				Parent gophkeeper.FolderID `json:"parent"`
+				Name   string              `json:"name"`
+			},
+			0,
+		)
+		if err := json.NewDecoder(response.Body).Decode(&responseContent); err != nil {
+			return nil, errors.Join(
+				fmt.Errorf("parse response: %w", err),
+				ErrIncompatibleAPI,
+			)
+		}
+		folders := make([]gophkeeper.Folder, 0, len(responseContent))
+		for _, responseFolder := range responseContent {
+			folders = append(
+				folders,
+				gophkeeper.Folder{
+					ID:     responseFolder.ID,
+					Parent: responseFolder.Parent,
+					Name:   responseFolder.Name,
+				},
+			)
+		}
+		return folders, nil
+	default:
+		return nil, errors.Join(
+			fmt.Errorf("unexpected response code: %d", response.StatusCode),
+			ErrIncompatibleAPI,
+		)
+	}
+}
+
+// MoveFolder implements Identity.
+func (i *Identity) MoveFolder(ctx context.Context, folder gophkeeper.FolderID, parent gophkeeper.FolderID) error {
+	endpoint := fmt.Sprintf("%s/vault/folders/%d", i.Server, folder)
+	content, contentError := json.Marshal(
+		map[string]any{
+			"parent": (int64)(parent),
+		},
+	)
+	if contentError != nil {
+		return contentError
+	}
+	request, requestError := http.NewRequestWithContext(
+		ctx,
+		http.MethodPost, endpoint,
+		bytes.NewReader(content),
+	)
+	if requestError != nil {
+		return requestError
+	}
+	request.Header.Set("Authorization", (string)(i.Token))
+
+	response, responseError := i.Client.Do(request)
+	if responseError != nil {
+		return responseError
+	}
+	defer response.Body.Close()
+
+	switch response.StatusCode {
+	case http.StatusOK:
+		return nil
+	case http.StatusNotFound:
+		return gophkeeper.ErrFolderNotFound
+	case http.StatusConflict:
+		return gophkeeper.ErrFolderCycle
+	default:
+		return errors.Join(
+			fmt.Errorf("unexpected response code: %d", response.StatusCode),
+			ErrIncompatibleAPI,
+		)
+	}
+}
+
+// MoveResource implements Identity.
+func (i *Identity) MoveResource(ctx context.Context, rid gophkeeper.ResourceID, folder gophkeeper.FolderID) error {
+	endpoint := fmt.Sprintf("%s/vault/%d/folder", i.Server, rid)
+	content, contentError := json.Marshal(
+		map[string]any{
+			"folder": (int64)(folder),
+		},
+	)
+	if contentError != nil {
+		return contentError
+	}
+	request, requestError := http.NewRequestWithContext(
+		ctx,
+		http.MethodPost, endpoint,
+		bytes.NewReader(content),
+	)
+	if requestError != nil {
+		return requestError
+	}
+	request.Header.Set("Authorization", (string)(i.Token))
+
+	response, responseError := i.Client.Do(request)
+	if responseError != nil {
+		return responseError
+	}
+	defer response.Body.Close()
+
+	switch response.StatusCode {
+	case http.StatusOK:
+		return nil
+	case http.StatusNotFound:
+		return gophkeeper.ErrResourceNotFound
+	case http.StatusUnprocessableEntity:
+		return gophkeeper.ErrFolderNotFound
+	default:
+		return errors.Join(
+			fmt.Errorf("unexpected response code: %d", response.StatusCode),
+			ErrIncompatibleAPI,
+		)
+	}
+}
+
+// Tag implements Identity.
+func (i *Identity) Tag(ctx context.Context, rid gophkeeper.ResourceID, tag string, index ...string) error {
+	return i.tags(ctx, http.MethodPut, rid, url.Values{"tag": {tag}, "token": index})
+}
+
+// Untag implements Identity.
+func (i *Identity) Untag(ctx context.Context, rid gophkeeper.ResourceID, tag string) error {
+	return i.tags(ctx, http.MethodDelete, rid, url.Values{"tag": {tag}})
+}
+
+func (i *Identity) tags(ctx context.Context, method string, rid gophkeeper.ResourceID, query url.Values) error {
+	endpoint := fmt.Sprintf("%s/vault/%d/tags?%s", i.Server, rid, query.Encode())
+	request, requestError := http.NewRequestWithContext(
+		ctx,
+		method, endpoint,
+		nil,
+	)
+	if requestError != nil {
+		return requestError
+	}
+	request.Header.Set("Authorization", (string)(i.Token))
+
+	response, responseError := i.Client.Do(request)
+	if responseError != nil {
+		return responseError
+	}
+	defer response.Body.Close()
+
+	switch response.StatusCode {
+	case http.StatusOK:
+		return nil
+	case http.StatusNotFound:
+		return gophkeeper.ErrResourceNotFound
+	default:
+		return errors.Join(
+			fmt.Errorf("unexpected response code: %d", response.StatusCode),
+			ErrIncompatibleAPI,
+		)
+	}
+}
+
+// ChangePassword implements Identity.
+func (i *Identity) ChangePassword(ctx context.Context, oldPassword, newPassword string) error {
+	endpoint := fmt.Sprintf("%s/account/password", i.Server)
+	content, contentError := json.Marshal(
+		map[string]any{
+			"old_password": oldPassword,
+			"new_password": newPassword,
+		},
+	)
+	if contentError != nil {
+		return contentError
+	}
+	request, requestError := http.NewRequestWithContext(
+		ctx,
+		http.MethodPost, endpoint,
+		bytes.NewReader(content),
+	)
+	if requestError != nil {
+		return requestError
+	}
+	request.Header.Set("Authorization", (string)(i.Token))
+
+	response, responseError := i.Client.Do(request)
+	if responseError != nil {
+		return responseError
+	}
+	defer response.Body.Close()
+
+	switch response.StatusCode {
+	case http.StatusOK:
+		return nil
+	case http.StatusUnauthorized:
+		return gophkeeper.ErrBadCredential
+	case http.StatusConflict:
+		return gophkeeper.ErrStaleReencryption
+	default:
+		return errors.Join(
+			fmt.Errorf("unexpected response code: %d", response.StatusCode),
+			ErrIncompatibleAPI,
+		)
+	}
+}
+
+// ChangeVaultPassword implements Identity.
+//
+// Progress of the re-encryption is reported to
+// the gophkeeper.Progress of the context.
+func (i *Identity) ChangeVaultPassword(ctx context.Context, oldPassword, newPassword string) error {
+	return i.changeVaultPassword(ctx, oldPassword, newPassword, nil)
+}
+
+// Reencrypt implements gophkeeper.Reencrypter.
+//
+// The stored metas are read, re-encrypted and sent back along with
+// the vault password change, which is retried if they change meanwhile.
+// So are the indexes of the re-encrypted metas and of the tags,
+// the rewritten tags and the rewritten folder names.
+func (i *Identity) Reencrypt(ctx context.Context, oldPassword, newPassword string, reencryption gophkeeper.Reencryption) error {
+	if reencryption.Meta == nil {
+		return ErrContentReencryption
+	}
+	for attempt := 1; ; attempt++ {
+		reencrypted, reencryptError := i.reencrypt(ctx, oldPassword, reencryption)
+		if reencryptError != nil {
+			return reencryptError
+		}
+		err := i.changeVaultPassword(ctx, oldPassword, newPassword, reencrypted)
+		if !errors.Is(err, gophkeeper.ErrStaleReencryption) || attempt == reencryptionAttempts {
+			return err
+		}
+	}
+}
+
+// reencrypt returns the re-encrypted metas along with their indexes,
+// the indexes of the tags, the rewritten tags and the rewritten
+// folder names if the reencryption has them.
+func (i *Identity) reencrypt(ctx context.Context, password string, reencryption gophkeeper.Reencryption) (map[string]any, error) {
+	stored, storedError := i.Metas(ctx, password)
+	if storedError != nil {
+		return nil, storedError
+	}
+	var (
+		metas   = make(map[string]string, len(stored))
+		indexes = make(map[string][]string, len(stored))
+	)
+	for _, meta := range stored {
+		reencrypted, reencryptError := reencryption.Meta(meta)
+		if reencryptError != nil {
+			return nil, reencryptError
+		}
+		metas[meta] = reencrypted
+		if reencryption.Index != nil {
+			index, indexError := reencryption.Index(reencrypted)
+			if indexError != nil {
+				return nil, indexError
+			}
+			indexes[reencrypted] = index
+		}
+	}
+	reencrypted := map[string]any{"metas": metas}
+	if reencryption.Index != nil {
+		reencrypted["indexes"] = indexes
+	}
+	if reencryption.TagIndex != nil || reencryption.Tag != nil {
+		tags, tagsError := i.allTags(ctx)
+		if tagsError != nil {
+			return nil, tagsError
+		}
+		if reencryption.TagIndex != nil {
+			tagIndexes, tagIndexesError := reencryptAll(tags, reencryption.TagIndex)
+			if tagIndexesError != nil {
+				return nil, tagIndexesError
+			}
+			reencrypted["tag_indexes"] = tagIndexes
+		}
+		if reencryption.Tag != nil {
+			rewrittenTags, rewriteError := reencryptAll(tags, reencryption.Tag)
+			if rewriteError != nil {
+				return nil, rewriteError
+			}
+			reencrypted["tags"] = rewrittenTags
+		}
+	}
+	if reencryption.Folder != nil {
+		folders, foldersError := i.ListFolders(ctx)
+		if foldersError != nil {
+			return nil, foldersError
+		}
+		names := make([]string, 0, len(folders))
+		for _, folder := range folders {
+			names = append(names, folder.Name)
+		}
+		rewrittenFolders, rewriteError := reencryptAll(names, reencryption.Folder)
+		if rewriteError != nil {
+			return nil, rewriteError
+		}
+		reencrypted["folders"] = rewrittenFolders
+	}
+	return reencrypted, nil
+}
+
+// reencryptAll returns what the reencryption rewrites the keys into.
+func reencryptAll[T any](keys []string, reencryption func(string) (T, error)) (map[string]T, error) {
+	reencrypted := make(map[string]T, len(keys))
+	for _, key := range keys {
+		value, reencryptError := reencryption(key)
+		if reencryptError != nil {
+			return nil, reencryptError
+		}
+		reencrypted[key] = value
+	}
+	return reencrypted, nil
+}
+
+// allTags returns the tags of the stored resources and of the trashed ones.
+func (i *Identity) allTags(ctx context.Context) ([]string, error) {
+	tags := make([]string, 0)
+	options := gophkeeper.ListOptions{}
+	for {
+		page, pageError := i.List(ctx, options)
+		if pageError != nil {
+			return nil, pageError
+		}
+		for _, resource := range page.Resources {
+			tags = append(tags, resource.Tags...)
+		}
+		if page.Next == "" {
+			break
+		}
+		options.Cursor = page.Next
+	}
+	trash, trashError := i.ListTrash(ctx)
+	if trashError != nil {
+		return nil, trashError
+	}
+	for _, resource := range trash {
+		tags = append(tags, resource.Tags...)
+	}
+	slices.Sort(tags)
+	return slices.Compact(tags), nil
+}
+
+// Metas implements gophkeeper.Reencrypter.
+func (i *Identity) Metas(ctx context.Context, password string) ([]string, error) {
+	endpoint := fmt.Sprintf("%s/vault/metas", i.Server)
+	request, requestError := http.NewRequestWithContext(
+		ctx,
+		http.MethodGet, endpoint,
+		nil,
+	)
+	if requestError != nil {
+		return nil, requestError
+	}
+	request.Header.Set("Authorization", (string)(i.Token))
+	i.setPassword(request, password)
+
+	response, responseError := i.Client.Do(request)
+	if responseError != nil {
+		return nil, responseError
+	}
+	defer response.Body.Close()
+
+	switch response.StatusCode {
+	case http.StatusOK:
+		metas := make([]string, 0)
+		if err := json.NewDecoder(response.Body).Decode(&metas); err != nil {
+			return nil, errors.Join(
+				fmt.Errorf("parse response: %w", err),
+				ErrIncompatibleAPI,
+			)
+		}
+		return metas, nil
+	case http.StatusUnauthorized:
+		return nil, gophkeeper.ErrBadCredential
+	case http.StatusInternalServerError:
+		return nil, ErrServerIsDown
+	default:
+		return nil, errors.Join(
+			fmt.Errorf("unexpected response code: %d", response.StatusCode),
+			ErrIncompatibleAPI,
+		)
+	}
+}
+
+// changeVaultPassword changes the vault password, the identity
+// re-encrypts with the re-encrypted metas if there are any.
+func (i *Identity) changeVaultPassword(ctx context.Context, oldPassword, newPassword string, reencrypted map[string]any) error {
+	endpoint := fmt.Sprintf("%s/account/vault-password", i.Server)
+	body := map[string]any{
+		"old_password": oldPassword,
+		"new_password": newPassword,
+	}
+	for key, value := range reencrypted {
+		body[key] = value
+	}
+	content, contentError := json.Marshal(body)
+	if contentError != nil {
+		return contentError
+	}
+	request, requestError := http.NewRequestWithContext(
+		ctx,
+		http.MethodPost, endpoint,
+		bytes.NewReader(content),
+	)
+	if requestError != nil {
+		return requestError
+	}
+	request.Header.Set("Authorization", (string)(i.Token))
+
+	response, responseError := i.Client.Do(request)
+	if responseError != nil {
+		return responseError
+	}
+	defer response.Body.Close()
+
+	switch response.StatusCode {
+	case http.StatusOK:
+		decoder := json.NewDecoder(response.Body)
+		for {
+			var event struct {
+				Done     int    `json:"done"`
+				Total    int    `json:"total"`
+				Complete bool   `json:"complete"`
+				Error    string `json:"error"`
+			}
+			if err := decoder.Decode(&event); err != nil {
+				if errors.Is(err, io.EOF) {
+					err = io.ErrUnexpectedEOF
+				}
+				return errors.Join(
+					fmt.Errorf("parse response: %w", err),
+					ErrIncompatibleAPI,
+				)
+			}
+			switch {
+			case event.Error == http.StatusText(http.StatusConflict):
+				return gophkeeper.ErrStaleReencryption
+			case event.Error != "":
+				return errors.Join(errors.New(event.Error), ErrServerIsDown)
+			case event.Complete:
+				return nil
+			default:
+				gophkeeper.ReportProgress(ctx, event.Done, event.Total)
+			}
+		}
+	case http.StatusUnauthorized:
+		return gophkeeper.ErrBadCredential
+	case http.StatusConflict:
+		return gophkeeper.ErrStaleReencryption
+	default:
+		return errors.Join(
+			fmt.Errorf("unexpected response code: %d", response.StatusCode),
+			ErrIncompatibleAPI,
+		)
+	}
+}
+
+// resourceResponse is a resource as it is listed by the server.
+type resourceResponse struct {
+	Meta       string                  `json:"meta"`
+	RID        gophkeeper.ResourceID   `json:"rid"`
+	Type       gophkeeper.ResourceType `json:"type"`
+	Folder     gophkeeper.FolderID     `json:"folder"`
+	Tags       []string                `json:"tags"`
+	CreatedAt  time.Time               `json:"created_at"`
+	UpdatedAt  time.Time               `json:"updated_at"`
+	AccessedAt time.Time               `json:"accessed_at"`
+	Size       int64                   `json:"size"`
+	Hash       []byte                  `json:"hash"`
+}
+
+func (r resourceResponse) resource() gophkeeper.Resource {
+	return gophkeeper.Resource{
+		ID:         r.RID,
+		Type:       r.Type,
+		Meta:       r.Meta,
+		Folder:     r.Folder,
+		Tags:       r.Tags,
+		CreatedAt:  r.CreatedAt,
+		UpdatedAt:  r.UpdatedAt,
+		AccessedAt: r.AccessedAt,
+		Size:       r.Size,
+		Hash:       r.Hash,
+	}
+}
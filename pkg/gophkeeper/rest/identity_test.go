@@ -3,15 +3,20 @@ package rest_test
 import (
 	"bytes"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"io"
 	"net/http"
 	"net/http/httptest"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 
 	serverrest "github.com/kerelape/gophkeeper/internal/server/rest"
 	"github.com/kerelape/gophkeeper/pkg/gophkeeper"
+	"github.com/kerelape/gophkeeper/pkg/gophkeeper/encrypted"
+	"github.com/kerelape/gophkeeper/pkg/gophkeeper/kdf"
 	"github.com/kerelape/gophkeeper/pkg/gophkeeper/rest"
 	"github.com/kerelape/gophkeeper/pkg/gophkeeper/virtual"
 	"github.com/stretchr/testify/assert"
@@ -95,6 +100,55 @@ func TestIdentity(t *testing.T) {
 				assert.ErrorIs(t, err, gophkeeper.ErrResourceNotFound, "unexpected error")
 			})
 		})
+		t.Run("Update", func(t *testing.T) {
+			err := identity.UpdatePiece(
+				context.Background(),
+				rid,
+				gophkeeper.Piece{
+					Meta:    "updatedmeta",
+					Content: ([]byte)("updatedcontent"),
+				},
+				credential.Password,
+			)
+			assert.Nil(t, err, "did not expect an error")
+			piece, restoreError := identity.RestorePiece(context.Background(), rid, credential.Password)
+			assert.Nil(t, restoreError, "did not expect an error")
+			assert.Equal(t, "updatedmeta", piece.Meta, "updated meta incorrectly")
+			assert.Equal(t, "updatedcontent", (string)(piece.Content), "updated content incorrectly")
+			t.Run("Invalid password", func(t *testing.T) {
+				err := identity.UpdatePiece(context.Background(), rid, gophkeeper.Piece{}, "_")
+				assert.NotNil(t, err, "expected an error")
+				assert.ErrorIs(t, err, gophkeeper.ErrBadCredential, "unexpected error")
+			})
+			t.Run("Invalid RID", func(t *testing.T) {
+				err := identity.UpdatePiece(context.Background(), -1, gophkeeper.Piece{}, credential.Password)
+				assert.NotNil(t, err, "expected an error")
+				assert.ErrorIs(t, err, gophkeeper.ErrResourceNotFound, "unexpected error")
+			})
+		})
+		t.Run("Versions", func(t *testing.T) {
+			versions, err := identity.ListVersions(context.Background(), rid)
+			assert.Nil(t, err, "did not expect an error")
+			assert.Equal(t, 1, len(versions), "incorrect versions count")
+			assert.Equal(t, "testmeta", versions[0].Meta, "incorrect version meta")
+			assert.False(t, versions[0].CreatedAt.IsZero(), "missing version creation time")
+
+			revertError := identity.RestoreVersion(context.Background(), rid, versions[0].Version, credential.Password)
+			assert.Nil(t, revertError, "did not expect an error")
+			piece, restoreError := identity.RestorePiece(context.Background(), rid, credential.Password)
+			assert.Nil(t, restoreError, "did not expect an error")
+			assert.Equal(t, "testcontent", (string)(piece.Content), "reverted content incorrectly")
+			t.Run("Invalid password", func(t *testing.T) {
+				err := identity.RestoreVersion(context.Background(), rid, versions[0].Version, "_")
+				assert.NotNil(t, err, "expected an error")
+				assert.ErrorIs(t, err, gophkeeper.ErrBadCredential, "unexpected error")
+			})
+			t.Run("Invalid RID", func(t *testing.T) {
+				_, err := identity.ListVersions(context.Background(), -1)
+				assert.NotNil(t, err, "expected an error")
+				assert.ErrorIs(t, err, gophkeeper.ErrResourceNotFound, "unexpected error")
+			})
+		})
 	})
 	t.Run("Blob", func(t *testing.T) {
 		var rid gophkeeper.ResourceID
@@ -142,6 +196,34 @@ func TestIdentity(t *testing.T) {
 				assert.ErrorIs(t, err, gophkeeper.ErrResourceNotFound, "unexpected error")
 			})
 		})
+		t.Run("Update", func(t *testing.T) {
+			err := identity.UpdateBlob(
+				context.Background(),
+				rid,
+				gophkeeper.Blob{
+					Meta:    "updatedmeta",
+					Content: io.NopCloser(strings.NewReader("updatedcontent")),
+				},
+				credential.Password,
+			)
+			assert.Nil(t, err, "did not expect an error")
+			blob, restoreError := identity.RestoreBlob(context.Background(), rid, credential.Password)
+			assert.Nil(t, restoreError, "did not expect an error")
+			assert.Equal(t, "updatedmeta", blob.Meta, "updated meta incorrectly")
+			content, contentError := io.ReadAll(blob.Content)
+			assert.Nil(t, contentError, "did not expect an error")
+			assert.Equal(t, "updatedcontent", (string)(content), "updated content incorrectly")
+			t.Run("Invalid password", func(t *testing.T) {
+				err := identity.UpdateBlob(
+					context.Background(),
+					rid,
+					gophkeeper.Blob{Content: io.NopCloser(strings.NewReader(""))},
+					"_",
+				)
+				assert.NotNil(t, err, "expected an error")
+				assert.ErrorIs(t, err, gophkeeper.ErrBadCredential, "unexpected error")
+			})
+		})
 	})
 	t.Run("Delete", func(t *testing.T) {
 		assert.Positive(t, rescount, "expected rescount to grow by this moment")
@@ -155,9 +237,223 @@ func TestIdentity(t *testing.T) {
 		})
 	})
 	t.Run("List", func(t *testing.T) {
-		resources, err := identity.List(context.Background())
+		page, err := identity.List(context.Background(), gophkeeper.ListOptions{})
+		assert.Nil(t, err, "did not expect an error")
+		assert.Equal(t, rescount, len(page.Resources), "incorrect resources count")
+		for _, resource := range page.Resources {
+			assert.False(t, resource.CreatedAt.IsZero(), "expected creation time to be set")
+			assert.False(t, resource.UpdatedAt.IsZero(), "expected update time to be set")
+			assert.NotEmpty(t, resource.Hash, "expected hash to be set")
+		}
+	})
+	t.Run("Resources", func(t *testing.T) {
+		iterator := identity.(*rest.Identity).Resources(context.Background(), gophkeeper.ListOptions{Limit: 1})
+		rids := make([]gophkeeper.ResourceID, 0, rescount)
+		for iterator.Next() {
+			rids = append(rids, iterator.Resource().ID)
+		}
+		assert.Nil(t, iterator.Err(), "did not expect an error")
+		assert.Equal(t, rescount, len(rids), "incorrect resources count")
+		assert.IsIncreasing(t, rids, "expected resources in RID order")
+		t.Run("Invalid cursor", func(t *testing.T) {
+			_, err := identity.List(context.Background(), gophkeeper.ListOptions{Cursor: "invalid"})
+			assert.ErrorIs(t, err, gophkeeper.ErrInvalidCursor, "unexpected error")
+		})
+	})
+	t.Run("Trash", func(t *testing.T) {
+		deleted := (gophkeeper.ResourceID)(rescount)
+		trash, err := identity.ListTrash(context.Background())
 		assert.Nil(t, err, "did not expect an error")
-		assert.Equal(t, rescount, len(resources), "incorrect resources count")
+		assert.Equal(t, 1, len(trash), "incorrect trash size")
+		assert.Equal(t, deleted, trash[0].ID, "unexpected resource in the trash")
+		t.Run("Undelete", func(t *testing.T) {
+			err := identity.Undelete(context.Background(), deleted)
+			assert.Nil(t, err, "did not expect an error")
+			page, listError := identity.List(context.Background(), gophkeeper.ListOptions{})
+			assert.Nil(t, listError, "did not expect an error")
+			assert.Equal(t, rescount+1, len(page.Resources), "incorrect resources count")
+		})
+		t.Run("Purge", func(t *testing.T) {
+			deleteError := identity.Delete(context.Background(), deleted)
+			assert.Nil(t, deleteError, "did not expect an error")
+			err := identity.Purge(context.Background(), deleted)
+			assert.Nil(t, err, "did not expect an error")
+			trash, trashError := identity.ListTrash(context.Background())
+			assert.Nil(t, trashError, "did not expect an error")
+			assert.Equal(t, 0, len(trash), "incorrect trash size")
+		})
+		t.Run("Invalid RID", func(t *testing.T) {
+			undeleteError := identity.Undelete(context.Background(), -1)
+			assert.ErrorIs(t, undeleteError, gophkeeper.ErrResourceNotFound, "unexpected error")
+			purgeError := identity.Purge(context.Background(), -1)
+			assert.ErrorIs(t, purgeError, gophkeeper.ErrResourceNotFound, "unexpected error")
+		})
+	})
+
+	t.Run("Folders", func(t *testing.T) {
+		folder, createError := identity.CreateFolder(context.Background(), "work", gophkeeper.RootFolder)
+		assert.Nil(t, createError, "did not expect an error")
+		nested, nestedError := identity.CreateFolder(context.Background(), "nested", folder)
+		assert.Nil(t, nestedError, "did not expect an error")
+
+		_, unknownParentError := identity.CreateFolder(context.Background(), "orphan", 100)
+		assert.ErrorIs(t, unknownParentError, gophkeeper.ErrFolderNotFound, "unexpected error")
+
+		folders, foldersError := identity.ListFolders(context.Background())
+		assert.Nil(t, foldersError, "did not expect an error")
+		assert.Equal(t, 2, len(folders), "incorrect folders count")
+
+		cycleError := identity.MoveFolder(context.Background(), folder, nested)
+		assert.ErrorIs(t, cycleError, gophkeeper.ErrFolderCycle, "unexpected error")
+		unknownFolderError := identity.MoveFolder(context.Background(), 100, gophkeeper.RootFolder)
+		assert.ErrorIs(t, unknownFolderError, gophkeeper.ErrFolderNotFound, "unexpected error")
+		moveFolderError := identity.MoveFolder(context.Background(), nested, gophkeeper.RootFolder)
+		assert.Nil(t, moveFolderError, "did not expect an error")
+
+		rid, storeError := identity.StorePiece(
+			context.Background(),
+			gophkeeper.Piece{Meta: "meta", Content: ([]byte)("content")},
+			credential.Password,
+		)
+		assert.Nil(t, storeError, "did not expect an error")
+		rescount++
+
+		moveError := identity.MoveResource(context.Background(), rid, folder)
+		assert.Nil(t, moveError, "did not expect an error")
+		moveUnknownFolderError := identity.MoveResource(context.Background(), rid, 100)
+		assert.ErrorIs(t, moveUnknownFolderError, gophkeeper.ErrFolderNotFound, "unexpected error")
+		moveUnknownResourceError := identity.MoveResource(context.Background(), -1, folder)
+		assert.ErrorIs(t, moveUnknownResourceError, gophkeeper.ErrResourceNotFound, "unexpected error")
+
+		tagError := identity.Tag(context.Background(), rid, "two words")
+		assert.Nil(t, tagError, "did not expect an error")
+		tagUnknownError := identity.Tag(context.Background(), -1, "tag")
+		assert.ErrorIs(t, tagUnknownError, gophkeeper.ErrResourceNotFound, "unexpected error")
+
+		page, listError := identity.List(
+			context.Background(),
+			gophkeeper.ListOptions{Folder: &folder, Tag: "two words"},
+		)
+		assert.Nil(t, listError, "did not expect an error")
+		assert.Equal(t, 1, len(page.Resources), "incorrect resources count")
+		assert.Equal(t, folder, page.Resources[0].Folder, "incorrect resource folder")
+		assert.Equal(t, []string{"two words"}, page.Resources[0].Tags, "incorrect resource tags")
+
+		page, listError = identity.List(context.Background(), gophkeeper.ListOptions{ID: &rid})
+		assert.Nil(t, listError, "did not expect an error")
+		assert.Equal(t, 1, len(page.Resources), "incorrect resources count")
+		assert.Equal(t, rid, page.Resources[0].ID, "incorrect resource")
+
+		untagError := identity.Untag(context.Background(), rid, "two words")
+		assert.Nil(t, untagError, "did not expect an error")
+		page, listError = identity.List(context.Background(), gophkeeper.ListOptions{Tag: "two words"})
+		assert.Nil(t, listError, "did not expect an error")
+		assert.Empty(t, page.Resources, "expected no resources with the removed tag")
+	})
+
+	t.Run("Search", func(t *testing.T) {
+		rid, storeError := identity.StorePiece(
+			context.Background(),
+			gophkeeper.Piece{Meta: "meta", Content: ([]byte)("content"), Index: []string{"first", "second"}},
+			credential.Password,
+		)
+		assert.Nil(t, storeError, "did not expect an error")
+		rescount++
+
+		resources, searchError := identity.Search(context.Background(), []string{"first", "second"})
+		assert.Nil(t, searchError, "did not expect an error")
+		assert.Equal(t, 1, len(resources), "incorrect resources count")
+		assert.Equal(t, rid, resources[0].ID, "incorrect resource")
+
+		tagError := identity.Tag(context.Background(), rid, "tag", "third")
+		assert.Nil(t, tagError, "did not expect an error")
+		resources, searchError = identity.Search(context.Background(), []string{"first", "third"})
+		assert.Nil(t, searchError, "did not expect an error")
+		assert.Equal(t, 1, len(resources), "expected the resource to be found by its tag")
+
+		resources, searchError = identity.Search(context.Background(), []string{"fourth"})
+		assert.Nil(t, searchError, "did not expect an error")
+		assert.Empty(t, resources, "expected no resources")
+	})
+
+	t.Run("Unlock", func(t *testing.T) {
+		_, wrongPasswordError := identity.(*rest.Identity).Unlock(context.Background(), "wrong")
+		assert.ErrorIs(t, wrongPasswordError, gophkeeper.ErrBadCredential, "unexpected error")
+
+		unlocked, unlockError := identity.(*rest.Identity).Unlock(context.Background(), credential.Password)
+		assert.Nil(t, unlockError, "did not expect an error")
+
+		rid, storeError := unlocked.StorePiece(
+			context.Background(),
+			gophkeeper.Piece{Meta: "meta", Content: ([]byte)("content")},
+			credential.Password,
+		)
+		assert.Nil(t, storeError, "did not expect an error")
+		rescount++
+		piece, restoreError := unlocked.RestorePiece(context.Background(), rid, credential.Password)
+		assert.Nil(t, restoreError, "did not expect an error")
+		assert.Equal(t, "content", (string)(piece.Content), "incorrect piece content")
+		_, otherPasswordError := unlocked.RestorePiece(context.Background(), rid, "wrong")
+		assert.ErrorIs(t, otherPasswordError, gophkeeper.ErrBadCredential, "expected other passwords to be sent as is")
+
+		assert.Nil(t, unlocked.(*rest.Identity).Lock(context.Background()), "did not expect an error")
+		_, lockedError := unlocked.RestorePiece(context.Background(), rid, credential.Password)
+		assert.Nil(t, lockedError, "expected the password to be sent once locked")
+		assert.ErrorIs(t, unlocked.(*rest.Identity).Lock(context.Background()), rest.ErrNoVaultSession, "unexpected error")
+	})
+
+	t.Run("Settings", func(t *testing.T) {
+		settings, settingsError := identity.Settings(context.Background())
+		assert.Nil(t, settingsError, "did not expect an error")
+		assert.False(t, settings.KeyFile, "expected no key file by default")
+
+		settings.KeyFile = true
+		assert.Nil(t, identity.UpdateSettings(context.Background(), settings), "did not expect an error")
+		settings, settingsError = identity.Settings(context.Background())
+		assert.Nil(t, settingsError, "did not expect an error")
+		assert.True(t, settings.KeyFile, "expected the settings to be updated")
+
+		settings.KeyFile = false
+		assert.Nil(t, identity.UpdateSettings(context.Background(), settings), "did not expect an error")
+	})
+
+	t.Run("Change password", func(t *testing.T) {
+		changePasswordError := identity.ChangePassword(context.Background(), credential.Password, "asdfgh")
+		assert.Nil(t, changePasswordError, "did not expect an error")
+
+		_, storeError := identity.StorePiece(context.Background(), gophkeeper.Piece{}, credential.Password)
+		assert.Nil(t, storeError, "expected the vault password to be kept")
+
+		t.Run("Invalid password", func(t *testing.T) {
+			err := identity.ChangePassword(context.Background(), credential.Password, "asdfgh")
+			assert.ErrorIs(t, err, gophkeeper.ErrBadCredential, "unexpected error")
+		})
+
+		changeBackError := identity.ChangePassword(context.Background(), "asdfgh", credential.Password)
+		assert.Nil(t, changeBackError, "did not expect an error")
+	})
+
+	t.Run("Change vault password", func(t *testing.T) {
+		var progress [][2]int
+		ctx := gophkeeper.WithProgress(context.Background(), func(done, total int) {
+			progress = append(progress, [2]int{done, total})
+		})
+		changePasswordError := identity.ChangeVaultPassword(ctx, credential.Password, "asdfgh")
+		assert.Nil(t, changePasswordError, "did not expect an error")
+		for _, p := range progress {
+			assert.LessOrEqual(t, p[0], p[1], "unexpected progress")
+		}
+
+		_, storeError := identity.StorePiece(context.Background(), gophkeeper.Piece{}, credential.Password)
+		assert.ErrorIs(t, storeError, gophkeeper.ErrBadCredential, "expected old password to be rejected")
+
+		t.Run("Invalid password", func(t *testing.T) {
+			err := identity.ChangeVaultPassword(context.Background(), credential.Password, "asdfgh")
+			assert.ErrorIs(t, err, gophkeeper.ErrBadCredential, "unexpected error")
+		})
+
+		changeBackError := identity.ChangeVaultPassword(context.Background(), "asdfgh", credential.Password)
+		assert.Nil(t, changeBackError, "did not expect an error")
 	})
 
 	t.Run("Invalid configuration", func(t *testing.T) {
@@ -181,10 +477,50 @@ func TestIdentity(t *testing.T) {
 		assert.NotNil(t, storeBlobError)
 		_, restoreBlobError := identity.RestoreBlob(context.Background(), 0, "")
 		assert.NotNil(t, restoreBlobError)
-		_, listError := identity.List(context.Background())
+		_, listError := identity.List(context.Background(), gophkeeper.ListOptions{})
 		assert.NotNil(t, listError)
+		iterator := identity.Resources(context.Background(), gophkeeper.ListOptions{})
+		assert.False(t, iterator.Next())
+		assert.NotNil(t, iterator.Err())
 		deleteError := identity.Delete(context.Background(), 0)
 		assert.NotNil(t, deleteError)
+		updatePieceError := identity.UpdatePiece(context.Background(), 0, gophkeeper.Piece{}, "")
+		assert.NotNil(t, updatePieceError)
+		updateBlobError := identity.UpdateBlob(
+			context.Background(),
+			0,
+			gophkeeper.Blob{Content: io.NopCloser(bytes.NewReader([]byte{}))},
+			"",
+		)
+		assert.NotNil(t, updateBlobError)
+		_, listVersionsError := identity.ListVersions(context.Background(), 0)
+		assert.NotNil(t, listVersionsError)
+		restoreVersionError := identity.RestoreVersion(context.Background(), 0, 0, "")
+		assert.NotNil(t, restoreVersionError)
+		_, listTrashError := identity.ListTrash(context.Background())
+		assert.NotNil(t, listTrashError)
+		undeleteError := identity.Undelete(context.Background(), 0)
+		assert.NotNil(t, undeleteError)
+		purgeError := identity.Purge(context.Background(), 0)
+		assert.NotNil(t, purgeError)
+		_, createFolderError := identity.CreateFolder(context.Background(), "", 0)
+		assert.NotNil(t, createFolderError)
+		_, listFoldersError := identity.ListFolders(context.Background())
+		assert.NotNil(t, listFoldersError)
+		moveFolderError := identity.MoveFolder(context.Background(), 0, 0)
+		assert.NotNil(t, moveFolderError)
+		moveResourceError := identity.MoveResource(context.Background(), 0, 0)
+		assert.NotNil(t, moveResourceError)
+		tagError := identity.Tag(context.Background(), 0, "")
+		assert.NotNil(t, tagError)
+		untagError := identity.Untag(context.Background(), 0, "")
+		assert.NotNil(t, untagError)
+		changePasswordError := identity.ChangePassword(context.Background(), "", "")
+		assert.NotNil(t, changePasswordError)
+		changeVaultPasswordError := identity.ChangeVaultPassword(context.Background(), "", "")
+		assert.NotNil(t, changeVaultPasswordError)
+		_, metasError := identity.Metas(context.Background(), "")
+		assert.NotNil(t, metasError)
 	})
 	t.Run("nil context", func(t *testing.T) {
 		_, storePieceError := identity.StorePiece(nilContext, gophkeeper.Piece{}, credential.Password)
@@ -199,9 +535,185 @@ func TestIdentity(t *testing.T) {
 		assert.NotNil(t, restorePieceError)
 		_, restoreBlobError := identity.RestoreBlob(nilContext, 0, credential.Password)
 		assert.NotNil(t, restoreBlobError)
-		_, listError := identity.List(nilContext)
+		_, listError := identity.List(nilContext, gophkeeper.ListOptions{})
 		assert.NotNil(t, listError)
 		deleteError := identity.Delete(nilContext, 0)
 		assert.NotNil(t, deleteError)
+		updatePieceError := identity.UpdatePiece(nilContext, 0, gophkeeper.Piece{}, credential.Password)
+		assert.NotNil(t, updatePieceError)
+		updateBlobError := identity.UpdateBlob(
+			nilContext,
+			0,
+			gophkeeper.Blob{Content: io.NopCloser(bytes.NewReader([]byte{}))},
+			credential.Password,
+		)
+		assert.NotNil(t, updateBlobError)
+		_, listVersionsError := identity.ListVersions(nilContext, 0)
+		assert.NotNil(t, listVersionsError)
+		restoreVersionError := identity.RestoreVersion(nilContext, 0, 0, credential.Password)
+		assert.NotNil(t, restoreVersionError)
+		_, listTrashError := identity.ListTrash(nilContext)
+		assert.NotNil(t, listTrashError)
+		undeleteError := identity.Undelete(nilContext, 0)
+		assert.NotNil(t, undeleteError)
+		purgeError := identity.Purge(nilContext, 0)
+		assert.NotNil(t, purgeError)
+		_, createFolderError := identity.CreateFolder(nilContext, "", 0)
+		assert.NotNil(t, createFolderError)
+		_, listFoldersError := identity.ListFolders(nilContext)
+		assert.NotNil(t, listFoldersError)
+		moveFolderError := identity.MoveFolder(nilContext, 0, 0)
+		assert.NotNil(t, moveFolderError)
+		moveResourceError := identity.MoveResource(nilContext, 0, 0)
+		assert.NotNil(t, moveResourceError)
+		tagError := identity.Tag(nilContext, 0, "")
+		assert.NotNil(t, tagError)
+		untagError := identity.Untag(nilContext, 0, "")
+		assert.NotNil(t, untagError)
+		changePasswordError := identity.ChangePassword(nilContext, credential.Password, credential.Password)
+		assert.NotNil(t, changePasswordError)
+		changeVaultPasswordError := identity.ChangeVaultPassword(nilContext, credential.Password, credential.Password)
+		assert.NotNil(t, changeVaultPasswordError)
+		_, metasError := identity.(gophkeeper.Reencrypter).Metas(nilContext, credential.Password)
+		assert.NotNil(t, metasError)
+	})
+}
+
+func TestEncryptedIdentity(t *testing.T) {
+	var (
+		entry = serverrest.Entry{
+			Gophkeeper:        virtual.New(time.Hour, t.TempDir()),
+			RequireEncryption: true,
+		}
+		received = make([]string, 0)
+		mutex    sync.Mutex
+		server   = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			body, _ := io.ReadAll(r.Body)
+			mutex.Lock()
+			received = append(received, r.Header.Get("X-Password"), r.Header.Get("X-Meta"), (string)(body))
+			mutex.Unlock()
+			r.Body = io.NopCloser(bytes.NewReader(body))
+			entry.Route().ServeHTTP(w, r)
+		}))
+	)
+	defer server.Close()
+	var (
+		origin = &rest.Gophkeeper{
+			Client: *server.Client(),
+			Server: server.URL,
+		}
+		g = encrypted.Gophkeeper{
+			Origin: origin,
+			Cipher: encrypted.CFBCipher{},
+			KDF:    kdf.KDF{Algorithm: kdf.Argon2id, Time: 1, Memory: 64, Threads: 1},
+			Verifier: func(password string, _ []byte) (string, error) {
+				verifier := sha256.Sum256(([]byte)(password))
+				return hex.EncodeToString(verifier[:]), nil
+			},
+		}
+		credential = gophkeeper.Credential{
+			Username:      "test",
+			Password:      "qwerty",
+			VaultPassword: "vault-secret",
+		}
+	)
+
+	registerError := g.Register(context.Background(), credential)
+	assert.Nil(t, registerError, "did not expect an error")
+	token, authenticateError := g.Authenticate(context.Background(), credential)
+	assert.Nil(t, authenticateError, "did not expect an error")
+	identity, identityError := g.Identity(context.Background(), token)
+	assert.Nil(t, identityError, "did not expect an error")
+	assert.NotEmpty(t, identity.(encrypted.Identity).VaultSalt, "expected the vault salt to be kept by the server")
+
+	content := []byte{0, 1, 0, 2, 0, 0}
+	pieceRID, storePieceError := identity.StorePiece(
+		context.Background(),
+		gophkeeper.Piece{Meta: "piece", Content: content},
+		credential.VaultPassword,
+	)
+	assert.Nil(t, storePieceError, "did not expect an error")
+	blobRID, storeBlobError := identity.StoreBlob(
+		context.Background(),
+		gophkeeper.Blob{Meta: "blob", Content: io.NopCloser(bytes.NewReader(content))},
+		credential.VaultPassword,
+	)
+	assert.Nil(t, storeBlobError, "did not expect an error")
+	updateError := identity.UpdatePiece(
+		context.Background(),
+		pieceRID,
+		gophkeeper.Piece{Meta: "piece", Content: append(content, 0)},
+		credential.VaultPassword,
+	)
+	assert.Nil(t, updateError, "did not expect an error")
+
+	t.Run("Change vault password", func(t *testing.T) {
+		changeError := identity.ChangeVaultPassword(context.Background(), credential.VaultPassword, "new-vault-secret")
+		assert.Nil(t, changeError, "did not expect an error")
+
+		_, oldError := identity.RestorePiece(context.Background(), pieceRID, credential.VaultPassword)
+		assert.ErrorIs(t, oldError, gophkeeper.ErrBadCredential, "expected old password to be rejected")
+
+		piece, restorePieceError := identity.RestorePiece(context.Background(), pieceRID, "new-vault-secret")
+		assert.Nil(t, restorePieceError, "did not expect an error")
+		assert.Equal(t, gophkeeper.Piece{Meta: "piece", Content: append(content, 0)}, piece, "incorrect piece")
+
+		revertError := identity.RestoreVersion(context.Background(), pieceRID, 1, "new-vault-secret")
+		assert.Nil(t, revertError, "did not expect an error")
+		piece, restorePieceError = identity.RestorePiece(context.Background(), pieceRID, "new-vault-secret")
+		assert.Nil(t, restorePieceError, "did not expect an error")
+		assert.Equal(t, gophkeeper.Piece{Meta: "piece", Content: content}, piece, "incorrect piece")
+
+		blob, restoreBlobError := identity.RestoreBlob(context.Background(), blobRID, "new-vault-secret")
+		assert.Nil(t, restoreBlobError, "did not expect an error")
+		blobContent, readError := io.ReadAll(blob.Content)
+		assert.Nil(t, readError, "did not expect an error")
+		assert.Nil(t, blob.Content.Close(), "did not expect an error")
+		assert.Equal(t, content, blobContent, "incorrect blob")
+	})
+
+	t.Run("Stale re-encryption", func(t *testing.T) {
+		reencrypter := identity.(encrypted.Identity).Origin.(gophkeeper.Reencrypter)
+		vaultSalt := identity.(encrypted.Identity).VaultSalt
+		oldVerifier, _ := g.Verifier("new-vault-secret", vaultSalt)
+		newVerifier, _ := g.Verifier("other-vault-secret", vaultSalt)
+		err := reencrypter.Reencrypt(
+			context.Background(),
+			oldVerifier, newVerifier,
+			gophkeeper.Reencryption{Meta: func(meta string) (string, error) {
+				// The resources change while they are being re-encrypted.
+				if _, err := identity.StorePiece(
+					context.Background(),
+					gophkeeper.Piece{Meta: "piece", Content: content},
+					"new-vault-secret",
+				); err != nil {
+					return "", err
+				}
+				return meta, nil
+			}},
+		)
+		assert.ErrorIs(t, err, gophkeeper.ErrStaleReencryption, "unexpected error")
+		_, restoreError := identity.RestorePiece(context.Background(), pieceRID, "new-vault-secret")
+		assert.Nil(t, restoreError, "expected the vault password to be kept")
+	})
+
+	mutex.Lock()
+	for _, r := range received {
+		for _, secret := range []string{credential.VaultPassword, "new-vault-secret", "other-vault-secret"} {
+			assert.NotContains(t, r, secret, "expected the vault password to never be sent")
+		}
+		assert.NotContains(t, r, `"meta":"piece"`, "expected the meta to be wrapped")
+	}
+	mutex.Unlock()
+
+	t.Run("Plaintext", func(t *testing.T) {
+		plaintext, plaintextError := origin.Identity(context.Background(), token)
+		assert.Nil(t, plaintextError, "did not expect an error")
+		_, storeError := plaintext.StorePiece(
+			context.Background(),
+			gophkeeper.Piece{Meta: "piece", Content: content},
+			credential.VaultPassword,
+		)
+		assert.NotNil(t, storeError, "expected plaintext to be refused")
 	})
 }
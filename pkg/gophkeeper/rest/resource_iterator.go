@@ -0,0 +1,61 @@
+package rest
+
+import (
+	"context"
+
+	"github.com/kerelape/gophkeeper/pkg/gophkeeper"
+)
+
+// ResourceIterator iterates over resources
+// fetching pages of them lazily.
+type ResourceIterator struct {
+	ctx      context.Context
+	identity *Identity
+	options  gophkeeper.ListOptions
+
+	page     []gophkeeper.Resource
+	resource gophkeeper.Resource
+	done     bool
+	err      error
+}
+
+// Resources returns an iterator over resources matching the options,
+// the options limit is used as the page size.
+func (i *Identity) Resources(ctx context.Context, options gophkeeper.ListOptions) *ResourceIterator {
+	return &ResourceIterator{
+		ctx:      ctx,
+		identity: i,
+		options:  options,
+	}
+}
+
+// Next advances the iterator to the next resource.
+// It returns false when there are no more resources
+// or fetching a page failed.
+func (r *ResourceIterator) Next() bool {
+	for len(r.page) == 0 {
+		if r.done || r.err != nil {
+			return false
+		}
+		page, pageError := r.identity.List(r.ctx, r.options)
+		if pageError != nil {
+			r.err = pageError
+			return false
+		}
+		r.page = page.Resources
+		r.options.Cursor = page.Next
+		r.done = page.Next == ""
+	}
+	r.resource, r.page = r.page[0], r.page[1:]
+	return true
+}
+
+// Resource returns the current resource.
+func (r *ResourceIterator) Resource() gophkeeper.Resource {
+	return r.resource
+}
+
+// Err returns the error that stopped the iteration.
+func (r *ResourceIterator) Err() error {
+	return r.err
+}
@@ -0,0 +1,105 @@
+package rest
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/kerelape/gophkeeper/pkg/gophkeeper"
+)
+
+// accountSettings are the account settings as sent by the server.
+type accountSettings struct {
+	KeyFile       bool   `json:"key_file"`
+	Compression   bool   `json:"compression"`
+	Deduplication bool   `json:"deduplication"`
+	VaultSalt     []byte `json:"vault_salt,omitempty"`
+}
+
+// Settings implements gophkeeper.Identity.
+func (i *Identity) Settings(ctx context.Context) (gophkeeper.Settings, error) {
+	endpoint := fmt.Sprintf("%s/account/settings", i.Server)
+	request, requestError := http.NewRequestWithContext(
+		ctx,
+		http.MethodGet, endpoint,
+		nil,
+	)
+	if requestError != nil {
+		return gophkeeper.Settings{}, requestError
+	}
+	request.Header.Set("Authorization", (string)(i.Token))
+
+	response, responseError := i.Client.Do(request)
+	if responseError != nil {
+		return gophkeeper.Settings{}, responseError
+	}
+	defer response.Body.Close()
+
+	switch response.StatusCode {
+	case http.StatusOK:
+		var settings accountSettings
+		if err := json.NewDecoder(response.Body).Decode(&settings); err != nil {
+			return gophkeeper.Settings{}, errors.Join(
+				fmt.Errorf("parse response: %w", err),
+				ErrIncompatibleAPI,
+			)
+		}
+		result := gophkeeper.Settings{
+			KeyFile:       settings.KeyFile,
+			Compression:   settings.Compression,
+			Deduplication: settings.Deduplication,
+			VaultSalt:     settings.VaultSalt,
+		}
+		return result, nil
+	case http.StatusUnauthorized:
+		return gophkeeper.Settings{}, gophkeeper.ErrBadCredential
+	case http.StatusInternalServerError:
+		return gophkeeper.Settings{}, ErrServerIsDown
+	default:
+		return gophkeeper.Settings{}, errors.Join(
+			fmt.Errorf("unexpected response code: %d", response.StatusCode),
+			ErrIncompatibleAPI,
+		)
+	}
+}
+
+// UpdateSettings implements gophkeeper.Identity.
+func (i *Identity) UpdateSettings(ctx context.Context, settings gophkeeper.Settings) error {
+	endpoint := fmt.Sprintf("%s/account/settings", i.Server)
+	content, contentError := json.Marshal(accountSettings{KeyFile: settings.KeyFile})
+	if contentError != nil {
+		return contentError
+	}
+	request, requestError := http.NewRequestWithContext(
+		ctx,
+		http.MethodPut, endpoint,
+		bytes.NewReader(content),
+	)
+	if requestError != nil {
+		return requestError
+	}
+	request.Header.Set("Authorization", (string)(i.Token))
+
+	response, responseError := i.Client.Do(request)
+	if responseError != nil {
+		return responseError
+	}
+	defer response.Body.Close()
+
+	switch response.StatusCode {
+	case http.StatusOK:
+		return nil
+	case http.StatusUnauthorized:
+		return gophkeeper.ErrBadCredential
+	case http.StatusInternalServerError:
+		return ErrServerIsDown
+	default:
+		return errors.Join(
+			fmt.Errorf("unexpected response code: %d", response.StatusCode),
+			ErrIncompatibleAPI,
+		)
+	}
+}
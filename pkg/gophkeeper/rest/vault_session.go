@@ -0,0 +1,114 @@
+package rest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/kerelape/gophkeeper/pkg/gophkeeper"
+)
+
+// ErrNoVaultSession is returned when an identity
+// that is not unlocked is asked to be locked.
+var ErrNoVaultSession = errors.New("identity is not unlocked")
+
+// vaultSession is a vault session opened with the password.
+type vaultSession struct {
+	password string
+	handle   string
+}
+
+// Unlock implements gophkeeper.Unlocker.
+//
+// The identity it returns passes the session instead of the password
+// it is unlocked with, so the server verifies the password once.
+// The session expires once it is not used for a while, then the requests
+// fail with gophkeeper.ErrBadCredential until the identity is unlocked again.
+func (i *Identity) Unlock(ctx context.Context, password string) (gophkeeper.Identity, error) {
+	endpoint := fmt.Sprintf("%s/vault/unlock", i.Server)
+	request, requestError := http.NewRequestWithContext(
+		ctx,
+		http.MethodPost, endpoint,
+		nil,
+	)
+	if requestError != nil {
+		return nil, requestError
+	}
+	request.Header.Set("Authorization", (string)(i.Token))
+	request.Header.Set("X-Password", password)
+
+	response, responseError := i.Client.Do(request)
+	if responseError != nil {
+		return nil, responseError
+	}
+	defer response.Body.Close()
+
+	switch response.StatusCode {
+	case http.StatusCreated:
+		unlocked := *i
+		unlocked.session = &vaultSession{
+			password: password,
+			handle:   response.Header.Get("X-Vault-Session"),
+		}
+		return &unlocked, nil
+	case http.StatusUnauthorized:
+		return nil, gophkeeper.ErrBadCredential
+	case http.StatusInternalServerError:
+		return nil, ErrServerIsDown
+	default:
+		return nil, errors.Join(
+			fmt.Errorf("unexpected response code: %d", response.StatusCode),
+			ErrIncompatibleAPI,
+		)
+	}
+}
+
+// Lock implements gophkeeper.Locker.
+//
+// The identity passes the password afterwards.
+func (i *Identity) Lock(ctx context.Context) error {
+	if i.session == nil {
+		return ErrNoVaultSession
+	}
+	endpoint := fmt.Sprintf("%s/vault/unlock", i.Server)
+	request, requestError := http.NewRequestWithContext(
+		ctx,
+		http.MethodDelete, endpoint,
+		nil,
+	)
+	if requestError != nil {
+		return requestError
+	}
+	request.Header.Set("Authorization", (string)(i.Token))
+	request.Header.Set("X-Vault-Session", i.session.handle)
+
+	response, responseError := i.Client.Do(request)
+	if responseError != nil {
+		return responseError
+	}
+	defer response.Body.Close()
+
+	switch response.StatusCode {
+	case http.StatusOK, http.StatusNotFound:
+		i.session = nil
+		return nil
+	case http.StatusInternalServerError:
+		return ErrServerIsDown
+	default:
+		return errors.Join(
+			fmt.Errorf("unexpected response code: %d", response.StatusCode),
+			ErrIncompatibleAPI,
+		)
+	}
+}
+
+// setPassword sets the vault password of the request, the vault
+// session instead if the identity is unlocked with the password.
+func (i *Identity) setPassword(request *http.Request, password string) {
+	if i.session != nil && i.session.password == password {
+		request.Header.Set("X-Vault-Session", i.session.handle)
+		return
+	}
+	request.Header.Set("X-Password", password)
+}
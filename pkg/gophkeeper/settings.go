@@ -0,0 +1,25 @@
+package gophkeeper
+
+// Settings are the account settings of an identity.
+type Settings struct {
+	// KeyFile tells that the vault is opened
+	// with a key file along with the vault password.
+	KeyFile bool
+
+	// Compression tells that blobs are compressed before
+	// they are encrypted, it is set by the server and
+	// is not changed by updating the settings.
+	Compression bool
+
+	// Deduplication tells that the blobs are encrypted with the keys
+	// derived from their content, so the identical blobs of the vault
+	// are stored once. The origin learns which blobs of the vault are
+	// identical. It is set by the server and is not changed by updating
+	// the settings.
+	Deduplication bool
+
+	// VaultSalt is the salt the keys of the vault are derived with,
+	// it is set on registration and is not changed by updating
+	// the settings. It is empty for the vaults registered before it.
+	VaultSalt []byte
+}
@@ -0,0 +1,18 @@
+package gophkeeper
+
+import "context"
+
+type (
+	// Unlocker is an identity that opens a vault session with the vault
+	// password, the identity it returns passes the session instead of
+	// the password, so the password is verified once per session.
+	Unlocker interface {
+		Unlock(ctx context.Context, password string) (Identity, error)
+	}
+
+	// Locker is an identity unlocked with a vault session,
+	// the session is revoked once the identity is locked.
+	Locker interface {
+		Lock(ctx context.Context) error
+	}
+)
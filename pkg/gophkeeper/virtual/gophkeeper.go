@@ -6,44 +6,105 @@ import (
 	"sync"
 	"time"
 
+	"github.com/kerelape/gophkeeper/internal/blobstore"
 	"github.com/kerelape/gophkeeper/internal/server"
 	"github.com/kerelape/gophkeeper/pkg/gophkeeper"
 )
 
-const invalidIdentityID = -1
+const (
+	invalidIdentityID    = -1
+	defaultVersionsLimit = 10
+)
 
 type identity struct {
-	username string
-	password string
+	username      string
+	password      string
+	vaultPassword string
+	settings      gophkeeper.Settings
 }
 
 // Gophkeeper is a virtual Gophkeeper.
 type Gophkeeper struct {
-	identities []identity
+	identities []*identity
 
-	ts       server.UsernameBasedTokenSource
-	blobsDir string
+	ts            server.UsernameBasedTokenSource
+	compression   bool
+	deduplication bool
 
 	storage *storage
 
 	mutex *sync.Mutex
 }
 
+type option func(g *Gophkeeper)
+
+var _ server.Trash = (*Gophkeeper)(nil)
+
 // New returns a new virtual Gophkeeper that store all its
 // data in RAM.
-func New(sessionLifespan time.Duration, blobsDir string) *Gophkeeper {
-	return &Gophkeeper{
-		identities: make([]identity, 0),
+func New(sessionLifespan time.Duration, blobsDir string, options ...option) *Gophkeeper {
+	g := &Gophkeeper{
+		identities: make([]*identity, 0),
 		ts:         server.NewJWTSource(([]byte)("none"), sessionLifespan),
-		blobsDir:   blobsDir,
 		storage: &storage{
 			mutex:     &sync.Mutex{},
+			blobStore: &blobstore.FS{Dir: blobsDir},
 			resources: make([]resource, 0),
 			blobs:     make([]blob, 0),
 			pieces:    make([]piece, 0),
+			versions:  make(map[gophkeeper.ResourceID][]version),
+
+			versionsLimit: defaultVersionsLimit,
 		},
 		mutex: &sync.Mutex{},
 	}
+	for _, o := range options {
+		o(g)
+	}
+	if g.deduplication {
+		g.storage.blobStore = &blobstore.Dedup{
+			Store:      g.storage.blobStore,
+			References: &references{counts: make(map[string]int), mutex: &sync.Mutex{}},
+		}
+	}
+	return g
+}
+
+// WithVersionsLimit sets how many previous versions
+// of a resource the gophkeeper keeps.
+func WithVersionsLimit(limit int) option {
+	if limit < 0 {
+		panic("limit must be not negative")
+	}
+	return func(g *Gophkeeper) {
+		g.storage.versionsLimit = limit
+	}
+}
+
+// WithBlobStore sets the store of the blob files to the gophkeeper.
+func WithBlobStore(store blobstore.BlobStore) option {
+	if store == nil {
+		panic("store must be not nil")
+	}
+	return func(g *Gophkeeper) {
+		g.storage.blobStore = store
+	}
+}
+
+// WithCompression sets whether the blobs are compressed
+// before they are encrypted, see gophkeeper.Settings.
+func WithCompression(compression bool) option {
+	return func(g *Gophkeeper) {
+		g.compression = compression
+	}
+}
+
+// WithDeduplication makes the gophkeeper keep every unique chunk
+// of the blobs once, see gophkeeper.Settings.
+func WithDeduplication(deduplication bool) option {
+	return func(g *Gophkeeper) {
+		g.deduplication = deduplication
+	}
 }
 
 // Register implements gophkeeper.Gophkeeper.
@@ -56,11 +117,17 @@ func (k *Gophkeeper) Register(_ context.Context, credential gophkeeper.Credentia
 		return gophkeeper.ErrIdentityDuplicate
 	}
 
+	vaultPassword := credential.VaultPassword
+	if vaultPassword == "" {
+		vaultPassword = credential.Password
+	}
 	k.identities = append(
 		k.identities,
-		identity{
-			username: credential.Username,
-			password: credential.Password,
+		&identity{
+			username:      credential.Username,
+			password:      credential.Password,
+			vaultPassword: vaultPassword,
+			settings:      gophkeeper.Settings{VaultSalt: credential.VaultSalt},
 		},
 	)
 	return nil
@@ -90,13 +157,31 @@ func (k *Gophkeeper) Identity(ctx context.Context, token gophkeeper.Token) (goph
 	}
 
 	identity := &Identity{
-		identity: k.identities[k.findIdentity(username)],
-		storage:  k.storage,
-		blobsDir: k.blobsDir,
+		identity:      k.identities[k.findIdentity(username)],
+		storage:       k.storage,
+		compression:   k.compression,
+		deduplication: k.deduplication,
 	}
 	return identity, nil
 }
 
+// PurgeTrash implements server.Trash.
+func (k *Gophkeeper) PurgeTrash(ctx context.Context, before time.Time) error {
+	k.storage.mutex.Lock()
+	defer k.storage.mutex.Unlock()
+
+	for rid, resource := range k.storage.resources {
+		if resource.owner == "" || resource.deletedAt.IsZero() || !resource.deletedAt.Before(before) {
+			continue
+		}
+		k.storage.purge((gophkeeper.ResourceID)(rid))
+	}
+	if dedup, ok := k.storage.blobStore.(*blobstore.Dedup); ok {
+		return dedup.Collect(ctx)
+	}
+	return nil
+}
+
 func (k *Gophkeeper) findIdentity(username string) int {
 	for i := range k.identities {
 		identity := k.identities[i]
@@ -22,6 +22,28 @@ func TestGophkeeper(t *testing.T) {
 		registerError := g.Register(context.Background(), credential)
 		assert.Nil(t, registerError, "expected to successfully register")
 	})
+	t.Run("Registration with a vault password", func(t *testing.T) {
+		var (
+			g          = virtual.New(time.Hour, t.TempDir())
+			credential = gophkeeper.Credential{
+				Username:      "test",
+				Password:      "qwerty",
+				VaultPassword: "vault",
+			}
+		)
+		registerError := g.Register(context.Background(), credential)
+		assert.Nil(t, registerError, "expected to successfully register")
+
+		token, authenticateError := g.Authenticate(context.Background(), credential)
+		assert.Nil(t, authenticateError, "expected to successfully authentiate")
+		identity, identityError := g.Identity(context.Background(), token)
+		assert.Nil(t, identityError, "expected to successfully get identity")
+
+		_, loginPasswordError := identity.StorePiece(context.Background(), gophkeeper.Piece{}, credential.Password)
+		assert.ErrorIs(t, loginPasswordError, gophkeeper.ErrBadCredential, "expected the login password to be rejected")
+		_, vaultPasswordError := identity.StorePiece(context.Background(), gophkeeper.Piece{}, credential.VaultPassword)
+		assert.Nil(t, vaultPasswordError, "expected the vault password to be accepted")
+	})
 	t.Run("Subsequent registration with same credential", func(t *testing.T) {
 		var (
 			g          = virtual.New(time.Hour, t.TempDir())
@@ -1,30 +1,39 @@
 package virtual
 
 import (
-	"bufio"
 	"context"
-	"os"
-
+	"crypto/sha256"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/kerelape/gophkeeper/internal/blobstore"
+	"github.com/kerelape/gophkeeper/internal/cursor"
 	"github.com/kerelape/gophkeeper/pkg/gophkeeper"
 )
 
 // Identity is a virtual identity.
 type Identity struct {
-	identity
+	*identity
 
-	blobsDir string
+	compression   bool
+	deduplication bool
 
 	storage *storage
 }
 
-var _ gophkeeper.Identity = (*Identity)(nil)
+var (
+	_ gophkeeper.Identity    = (*Identity)(nil)
+	_ gophkeeper.Reencrypter = (*Identity)(nil)
+)
 
 // StorePiece implements gophkeeper.Identity.
 func (i *Identity) StorePiece(_ context.Context, origin gophkeeper.Piece, password string) (gophkeeper.ResourceID, error) {
 	i.storage.mutex.Lock()
 	defer i.storage.mutex.Unlock()
 
-	if password != i.password {
+	if password != i.vaultPassword {
 		return -1, gophkeeper.ErrBadCredential
 	}
 
@@ -34,13 +43,20 @@ func (i *Identity) StorePiece(_ context.Context, origin gophkeeper.Piece, passwo
 			content: origin.Content,
 		},
 	)
+	hash := sha256.Sum256(origin.Content)
+	now := time.Now()
 	i.storage.resources = append(
 		i.storage.resources,
 		resource{
-			id:    len(i.storage.pieces) - 1,
-			_type: gophkeeper.ResourceTypePiece,
-			meta:  origin.Meta,
-			owner: i.username,
+			id:        len(i.storage.pieces) - 1,
+			_type:     gophkeeper.ResourceTypePiece,
+			meta:      origin.Meta,
+			index:     origin.Index,
+			owner:     i.username,
+			size:      (int64)(len(origin.Content)),
+			hash:      hash[:],
+			createdAt: now,
+			updatedAt: now,
 		},
 	)
 	return (gophkeeper.ResourceID)(len(i.storage.resources) - 1), nil
@@ -51,19 +67,16 @@ func (i *Identity) RestorePiece(_ context.Context, rid gophkeeper.ResourceID, pa
 	i.storage.mutex.Lock()
 	defer i.storage.mutex.Unlock()
 
-	if password != i.password {
+	if password != i.vaultPassword {
 		return gophkeeper.Piece{}, gophkeeper.ErrBadCredential
 	}
 
-	if ((int)(rid) >= len(i.storage.resources)) || rid < 0 {
-		return gophkeeper.Piece{}, gophkeeper.ErrResourceNotFound
-	}
-
-	resource := i.storage.resources[rid]
-	if resource.owner != i.username || resource._type != gophkeeper.ResourceTypePiece {
+	resource, ok := i.storage.lookup(rid, i.username)
+	if !ok || resource._type != gophkeeper.ResourceTypePiece {
 		return gophkeeper.Piece{}, gophkeeper.ErrResourceNotFound
 	}
 
+	resource.accessedAt = time.Now()
 	piece := gophkeeper.Piece{
 		Meta:    resource.meta,
 		Content: i.storage.pieces[resource.id].content,
@@ -72,43 +85,39 @@ func (i *Identity) RestorePiece(_ context.Context, rid gophkeeper.ResourceID, pa
 }
 
 // StoreBlob implements gophkeeper.Identity.
-func (i *Identity) StoreBlob(_ context.Context, origin gophkeeper.Blob, password string) (gophkeeper.ResourceID, error) {
+func (i *Identity) StoreBlob(ctx context.Context, origin gophkeeper.Blob, password string) (gophkeeper.ResourceID, error) {
 	i.storage.mutex.Lock()
 	defer i.storage.mutex.Unlock()
 	defer origin.Content.Close()
 
-	if password != i.password {
+	if password != i.vaultPassword {
 		return -1, gophkeeper.ErrBadCredential
 	}
 
-	dir, dirError := os.MkdirTemp(i.blobsDir, "blobs-*")
-	if dirError != nil {
-		return -1, dirError
-	}
-	file, fileError := os.CreateTemp(dir, "blob-*")
-	if fileError != nil {
-		return -1, fileError
-	}
-	if _, err := bufio.NewWriter(file).ReadFrom(origin.Content); err != nil {
-		return -1, err
-	}
-	if err := file.Close(); err != nil {
-		return -1, err
+	location, size, hash, writeError := i.storage.writeBlob(ctx, origin.Content)
+	if writeError != nil {
+		return -1, writeError
 	}
 
+	now := time.Now()
 	i.storage.blobs = append(
 		i.storage.blobs,
 		blob{
-			location: file.Name(),
+			location: location,
 		},
 	)
 	i.storage.resources = append(
 		i.storage.resources,
 		resource{
-			meta:  origin.Meta,
-			id:    len(i.storage.blobs) - 1,
-			owner: i.username,
-			_type: gophkeeper.ResourceTypeBlob,
+			meta:      origin.Meta,
+			index:     origin.Index,
+			id:        len(i.storage.blobs) - 1,
+			owner:     i.username,
+			_type:     gophkeeper.ResourceTypeBlob,
+			size:      size,
+			hash:      hash,
+			createdAt: now,
+			updatedAt: now,
 		},
 	)
 
@@ -116,28 +125,25 @@ func (i *Identity) StoreBlob(_ context.Context, origin gophkeeper.Blob, password
 }
 
 // RestoreBlob implements gophkeeper.Identity.
-func (i *Identity) RestoreBlob(_ context.Context, rid gophkeeper.ResourceID, password string) (gophkeeper.Blob, error) {
+func (i *Identity) RestoreBlob(ctx context.Context, rid gophkeeper.ResourceID, password string) (gophkeeper.Blob, error) {
 	i.storage.mutex.Lock()
 	defer i.storage.mutex.Unlock()
 
-	if password != i.password {
+	if password != i.vaultPassword {
 		return gophkeeper.Blob{}, gophkeeper.ErrBadCredential
 	}
 
-	if !((int)(rid) < len(i.storage.resources)) || rid < 0 {
+	resource, ok := i.storage.lookup(rid, i.username)
+	if !ok || resource._type != gophkeeper.ResourceTypeBlob {
 		return gophkeeper.Blob{}, gophkeeper.ErrResourceNotFound
 	}
 
-	resource := i.storage.resources[rid]
-	if resource.owner != i.username || resource._type != gophkeeper.ResourceTypeBlob {
-		return gophkeeper.Blob{}, gophkeeper.ErrResourceNotFound
-	}
-
-	file, fileError := os.Open(i.storage.blobs[resource.id].location)
+	file, fileError := blobstore.Open(ctx, i.storage.blobStore, i.storage.blobs[resource.id].location)
 	if fileError != nil {
 		return gophkeeper.Blob{}, fileError
 	}
 
+	resource.accessedAt = time.Now()
 	blob := gophkeeper.Blob{
 		Meta:    resource.meta,
 		Content: file,
@@ -145,43 +151,670 @@ func (i *Identity) RestoreBlob(_ context.Context, rid gophkeeper.ResourceID, pas
 	return blob, nil
 }
 
-// Delete implements gophkeeper.Identity.
-func (i *Identity) Delete(_ context.Context, rid gophkeeper.ResourceID) error {
+// UpdatePiece implements gophkeeper.Identity.
+func (i *Identity) UpdatePiece(_ context.Context, rid gophkeeper.ResourceID, origin gophkeeper.Piece, password string) error {
 	i.storage.mutex.Lock()
 	defer i.storage.mutex.Unlock()
 
-	if !((int)(rid) < len(i.storage.resources)) || rid < 0 {
+	if password != i.vaultPassword {
+		return gophkeeper.ErrBadCredential
+	}
+
+	resource, ok := i.storage.lookup(rid, i.username)
+	if !ok || resource._type != gophkeeper.ResourceTypePiece {
 		return gophkeeper.ErrResourceNotFound
 	}
 
-	if i.storage.resources[rid].owner != i.username {
+	hash := sha256.Sum256(origin.Content)
+	i.storage.pushVersion(rid)
+	i.storage.pieces[resource.id].content = origin.Content
+	resource.meta = origin.Meta
+	resource.index = origin.Index
+	resource.size = (int64)(len(origin.Content))
+	resource.hash = hash[:]
+	resource.updatedAt = time.Now()
+
+	return nil
+}
+
+// UpdateBlob implements gophkeeper.Identity.
+func (i *Identity) UpdateBlob(ctx context.Context, rid gophkeeper.ResourceID, origin gophkeeper.Blob, password string) error {
+	i.storage.mutex.Lock()
+	defer i.storage.mutex.Unlock()
+	defer origin.Content.Close()
+
+	if password != i.vaultPassword {
+		return gophkeeper.ErrBadCredential
+	}
+
+	resource, ok := i.storage.lookup(rid, i.username)
+	if !ok || resource._type != gophkeeper.ResourceTypeBlob {
 		return gophkeeper.ErrResourceNotFound
 	}
 
-	i.storage.resources[rid].owner = ""
+	location, size, hash, writeError := i.storage.writeBlob(ctx, origin.Content)
+	if writeError != nil {
+		return writeError
+	}
+
+	i.storage.pushVersion(rid)
+	i.storage.blobs[resource.id].location = location
+	resource.meta = origin.Meta
+	resource.index = origin.Index
+	resource.size = size
+	resource.hash = hash
+	resource.updatedAt = time.Now()
 
 	return nil
 }
 
-// List implements gophkeeper.Identity.
-func (i *Identity) List(_ context.Context) ([]gophkeeper.Resource, error) {
+// ListVersions implements gophkeeper.Identity.
+func (i *Identity) ListVersions(_ context.Context, rid gophkeeper.ResourceID) ([]gophkeeper.ResourceVersion, error) {
 	i.storage.mutex.Lock()
 	defer i.storage.mutex.Unlock()
 
-	resources := make([]gophkeeper.Resource, 0)
-	for rid, resource := range i.storage.resources {
-		if resource.owner != i.username {
+	if _, ok := i.storage.lookup(rid, i.username); !ok {
+		return nil, gophkeeper.ErrResourceNotFound
+	}
+
+	history := i.storage.versions[rid]
+	versions := make([]gophkeeper.ResourceVersion, 0, len(history))
+	for n := len(history) - 1; n >= 0; n-- {
+		versions = append(
+			versions,
+			gophkeeper.ResourceVersion{
+				Version:   history[n].number,
+				Meta:      history[n].meta,
+				CreatedAt: history[n].createdAt,
+			},
+		)
+	}
+	return versions, nil
+}
+
+// RestoreVersion implements gophkeeper.Identity.
+func (i *Identity) RestoreVersion(_ context.Context, rid gophkeeper.ResourceID, number int, password string) error {
+	i.storage.mutex.Lock()
+	defer i.storage.mutex.Unlock()
+
+	if password != i.vaultPassword {
+		return gophkeeper.ErrBadCredential
+	}
+
+	resource, ok := i.storage.lookup(rid, i.username)
+	if !ok {
+		return gophkeeper.ErrResourceNotFound
+	}
+
+	history := i.storage.versions[rid]
+	index := -1
+	for n := range history {
+		if history[n].number == number {
+			index = n
+			break
+		}
+	}
+	if index == -1 {
+		return gophkeeper.ErrResourceNotFound
+	}
+	target := history[index]
+	i.storage.versions[rid] = append(history[:index:index], history[index+1:]...)
+
+	i.storage.pushVersion(rid)
+	switch resource._type {
+	case gophkeeper.ResourceTypePiece:
+		i.storage.pieces[resource.id].content = target.content
+	case gophkeeper.ResourceTypeBlob:
+		i.storage.blobs[resource.id].location = target.location
+	}
+	resource.meta = target.meta
+	resource.index = target.index
+	resource.size = target.size
+	resource.hash = target.hash
+	resource.updatedAt = time.Now()
+
+	return nil
+}
+
+// Delete implements gophkeeper.Identity.
+func (i *Identity) Delete(_ context.Context, rid gophkeeper.ResourceID) error {
+	i.storage.mutex.Lock()
+	defer i.storage.mutex.Unlock()
+
+	resource, ok := i.storage.lookup(rid, i.username)
+	if !ok {
+		return gophkeeper.ErrResourceNotFound
+	}
+
+	resource.deletedAt = time.Now()
+
+	return nil
+}
+
+// ListTrash implements gophkeeper.Identity.
+func (i *Identity) ListTrash(_ context.Context) ([]gophkeeper.TrashedResource, error) {
+	i.storage.mutex.Lock()
+	defer i.storage.mutex.Unlock()
+
+	resources := make([]gophkeeper.TrashedResource, 0)
+	for rid := range i.storage.resources {
+		resource := &i.storage.resources[rid]
+		if resource.owner != i.username || resource.deletedAt.IsZero() {
 			continue
 		}
 		resources = append(
 			resources,
-			gophkeeper.Resource{
-				ID:   (gophkeeper.ResourceID)(rid),
-				Type: resource._type,
-				Meta: resource.meta,
+			gophkeeper.TrashedResource{
+				Resource:  resource.info((gophkeeper.ResourceID)(rid)),
+				DeletedAt: resource.deletedAt,
 			},
 		)
 	}
 
 	return resources, nil
 }
+
+// Undelete implements gophkeeper.Identity.
+func (i *Identity) Undelete(_ context.Context, rid gophkeeper.ResourceID) error {
+	i.storage.mutex.Lock()
+	defer i.storage.mutex.Unlock()
+
+	resource, ok := i.storage.lookupTrash(rid, i.username)
+	if !ok {
+		return gophkeeper.ErrResourceNotFound
+	}
+
+	resource.deletedAt = time.Time{}
+
+	return nil
+}
+
+// Purge implements gophkeeper.Identity.
+func (i *Identity) Purge(_ context.Context, rid gophkeeper.ResourceID) error {
+	i.storage.mutex.Lock()
+	defer i.storage.mutex.Unlock()
+
+	if _, ok := i.storage.lookupTrash(rid, i.username); !ok {
+		return gophkeeper.ErrResourceNotFound
+	}
+
+	i.storage.purge(rid)
+
+	return nil
+}
+
+// CreateFolder implements gophkeeper.Identity.
+func (i *Identity) CreateFolder(_ context.Context, name string, parent gophkeeper.FolderID) (gophkeeper.FolderID, error) {
+	i.storage.mutex.Lock()
+	defer i.storage.mutex.Unlock()
+
+	if _, ok := i.storage.lookupFolder(parent, i.username); !ok {
+		return -1, gophkeeper.ErrFolderNotFound
+	}
+
+	i.storage.folders = append(
+		i.storage.folders,
+		folder{
+			owner:  i.username,
+			parent: parent,
+			name:   name,
+		},
+	)
+	return (gophkeeper.FolderID)(len(i.storage.folders)), nil
+}
+
+// ListFolders implements gophkeeper.Identity.
+func (i *Identity) ListFolders(_ context.Context) ([]gophkeeper.Folder, error) {
+	i.storage.mutex.Lock()
+	defer i.storage.mutex.Unlock()
+
+	folders := make([]gophkeeper.Folder, 0)
+	for n, folder := range i.storage.folders {
+		if folder.owner != i.username {
+			continue
+		}
+		folders = append(
+			folders,
+			gophkeeper.Folder{
+				ID:     (gophkeeper.FolderID)(n + 1),
+				Parent: folder.parent,
+				Name:   folder.name,
+			},
+		)
+	}
+	return folders, nil
+}
+
+// MoveFolder implements gophkeeper.Identity.
+func (i *Identity) MoveFolder(_ context.Context, id gophkeeper.FolderID, parent gophkeeper.FolderID) error {
+	i.storage.mutex.Lock()
+	defer i.storage.mutex.Unlock()
+
+	folder, ok := i.storage.lookupFolder(id, i.username)
+	if !ok || folder == nil {
+		return gophkeeper.ErrFolderNotFound
+	}
+	if _, ok := i.storage.lookupFolder(parent, i.username); !ok {
+		return gophkeeper.ErrFolderNotFound
+	}
+	for ancestor := parent; ancestor != gophkeeper.RootFolder; ancestor = i.storage.folders[ancestor-1].parent {
+		if ancestor == id {
+			return gophkeeper.ErrFolderCycle
+		}
+	}
+
+	folder.parent = parent
+
+	return nil
+}
+
+// MoveResource implements gophkeeper.Identity.
+func (i *Identity) MoveResource(_ context.Context, rid gophkeeper.ResourceID, folder gophkeeper.FolderID) error {
+	i.storage.mutex.Lock()
+	defer i.storage.mutex.Unlock()
+
+	resource, ok := i.storage.lookup(rid, i.username)
+	if !ok {
+		return gophkeeper.ErrResourceNotFound
+	}
+	if _, ok := i.storage.lookupFolder(folder, i.username); !ok {
+		return gophkeeper.ErrFolderNotFound
+	}
+
+	resource.folder = folder
+
+	return nil
+}
+
+// Tag implements gophkeeper.Identity.
+func (i *Identity) Tag(_ context.Context, rid gophkeeper.ResourceID, tag string, index ...string) error {
+	i.storage.mutex.Lock()
+	defer i.storage.mutex.Unlock()
+
+	resource, ok := i.storage.lookup(rid, i.username)
+	if !ok {
+		return gophkeeper.ErrResourceNotFound
+	}
+
+	if !slices.Contains(resource.tags, tag) {
+		resource.tags = append(resource.tags, tag)
+	}
+	if resource.tagIndex == nil {
+		resource.tagIndex = make(map[string][]string)
+	}
+	resource.tagIndex[tag] = index
+
+	return nil
+}
+
+// Untag implements gophkeeper.Identity.
+func (i *Identity) Untag(_ context.Context, rid gophkeeper.ResourceID, tag string) error {
+	i.storage.mutex.Lock()
+	defer i.storage.mutex.Unlock()
+
+	resource, ok := i.storage.lookup(rid, i.username)
+	if !ok {
+		return gophkeeper.ErrResourceNotFound
+	}
+
+	if n := slices.Index(resource.tags, tag); n != -1 {
+		resource.tags = slices.Delete(resource.tags, n, n+1)
+	}
+	delete(resource.tagIndex, tag)
+
+	return nil
+}
+
+// List implements gophkeeper.Identity.
+func (i *Identity) List(_ context.Context, options gophkeeper.ListOptions) (gophkeeper.ListPage, error) {
+	var after *cursor.Position
+	if options.Cursor != "" {
+		position, positionError := cursor.Decode(options.Cursor, options)
+		if positionError != nil {
+			return gophkeeper.ListPage{}, positionError
+		}
+		after = &position
+	}
+
+	i.storage.mutex.Lock()
+	defer i.storage.mutex.Unlock()
+
+	resources := make([]gophkeeper.Resource, 0)
+	for rid := range i.storage.resources {
+		resource := &i.storage.resources[rid]
+		if resource.owner != i.username || !resource.deletedAt.IsZero() {
+			continue
+		}
+		if options.ID != nil && (gophkeeper.ResourceID)(rid) != *options.ID {
+			continue
+		}
+		if options.Folder != nil && resource.folder != *options.Folder {
+			continue
+		}
+		if options.Tag != "" && !slices.Contains(resource.tags, options.Tag) {
+			continue
+		}
+		if options.Type != nil && resource._type != *options.Type {
+			continue
+		}
+		if !strings.HasPrefix(resource.meta, options.MetaPrefix) {
+			continue
+		}
+		info := resource.info((gophkeeper.ResourceID)(rid))
+		if after != nil && !cursor.Before(*after, cursor.Of(info, options)) {
+			continue
+		}
+		resources = append(resources, info)
+	}
+	sort.Slice(resources, func(a, b int) bool {
+		return cursor.Before(cursor.Of(resources[a], options), cursor.Of(resources[b], options))
+	})
+
+	var page gophkeeper.ListPage
+	if options.Limit > 0 && len(resources) > options.Limit {
+		resources = resources[:options.Limit]
+		page.Next = cursor.Encode(cursor.Of(resources[len(resources)-1], options))
+	}
+	page.Resources = resources
+	return page, nil
+}
+
+// Search implements gophkeeper.Identity.
+func (i *Identity) Search(_ context.Context, tokens []string) ([]gophkeeper.Resource, error) {
+	i.storage.mutex.Lock()
+	defer i.storage.mutex.Unlock()
+
+	resources := make([]gophkeeper.Resource, 0)
+	for rid := range i.storage.resources {
+		resource := &i.storage.resources[rid]
+		if resource.owner != i.username || !resource.deletedAt.IsZero() {
+			continue
+		}
+		if resource.indexed(tokens) {
+			resources = append(resources, resource.info((gophkeeper.ResourceID)(rid)))
+		}
+	}
+	return resources, nil
+}
+
+// ChangePassword implements gophkeeper.Identity.
+func (i *Identity) ChangePassword(_ context.Context, oldPassword, newPassword string) error {
+	i.storage.mutex.Lock()
+	defer i.storage.mutex.Unlock()
+
+	if oldPassword != i.password {
+		return gophkeeper.ErrBadCredential
+	}
+
+	i.password = newPassword
+
+	return nil
+}
+
+// ChangeVaultPassword implements gophkeeper.Identity.
+func (i *Identity) ChangeVaultPassword(_ context.Context, oldPassword, newPassword string) error {
+	i.storage.mutex.Lock()
+	defer i.storage.mutex.Unlock()
+
+	if oldPassword != i.vaultPassword {
+		return gophkeeper.ErrBadCredential
+	}
+
+	i.vaultPassword = newPassword
+
+	return nil
+}
+
+// Settings implements gophkeeper.Identity.
+func (i *Identity) Settings(_ context.Context) (gophkeeper.Settings, error) {
+	i.storage.mutex.Lock()
+	defer i.storage.mutex.Unlock()
+
+	settings := i.settings
+	settings.Compression = i.compression
+	settings.Deduplication = i.deduplication
+	return settings, nil
+}
+
+// UpdateSettings implements gophkeeper.Identity.
+func (i *Identity) UpdateSettings(_ context.Context, settings gophkeeper.Settings) error {
+	i.storage.mutex.Lock()
+	defer i.storage.mutex.Unlock()
+
+	i.settings = gophkeeper.Settings{
+		KeyFile:   settings.KeyFile,
+		VaultSalt: i.settings.VaultSalt,
+	}
+
+	return nil
+}
+
+// UnlockVault checks the vault password, the password of a virtual
+// identity is checked cheaply, so no vault key is returned.
+func (i *Identity) UnlockVault(_ context.Context, password string) ([]byte, error) {
+	i.storage.mutex.Lock()
+	defer i.storage.mutex.Unlock()
+
+	if password != i.vaultPassword {
+		return nil, gophkeeper.ErrBadCredential
+	}
+
+	return nil, nil
+}
+
+// Metas implements gophkeeper.Reencrypter.
+func (i *Identity) Metas(_ context.Context, password string) ([]string, error) {
+	i.storage.mutex.Lock()
+	defer i.storage.mutex.Unlock()
+
+	if password != i.vaultPassword {
+		return nil, gophkeeper.ErrBadCredential
+	}
+
+	metas := make([]string, 0)
+	for rid := range i.storage.resources {
+		resource := &i.storage.resources[rid]
+		if resource.owner != i.username {
+			continue
+		}
+		metas = append(metas, resource.meta)
+		for _, v := range i.storage.versions[(gophkeeper.ResourceID)(rid)] {
+			metas = append(metas, v.meta)
+		}
+	}
+	return metas, nil
+}
+
+// Reencrypt implements gophkeeper.Reencrypter.
+//
+// The content is rewritten aside and swapped in only after
+// all of it is rewritten, so a failed call changes nothing.
+func (i *Identity) Reencrypt(ctx context.Context, oldPassword, newPassword string, reencryption gophkeeper.Reencryption) error {
+	i.storage.mutex.Lock()
+	defer i.storage.mutex.Unlock()
+
+	if oldPassword != i.vaultPassword {
+		return gophkeeper.ErrBadCredential
+	}
+
+	type rewrite struct {
+		rid     gophkeeper.ResourceID
+		version int // index in the history, -1 for the current content.
+		state
+	}
+	total := 0
+	for rid := range i.storage.resources {
+		if i.storage.resources[rid].owner == i.username {
+			total += 1 + len(i.storage.versions[(gophkeeper.ResourceID)(rid)])
+		}
+	}
+	rewrites := make([]rewrite, 0, total)
+	discard := func() {
+		for _, r := range rewrites {
+			if r.location != "" {
+				i.storage.removeBlob(r.location)
+			}
+		}
+	}
+	for rid := range i.storage.resources {
+		resource := &i.storage.resources[rid]
+		if resource.owner != i.username {
+			continue
+		}
+		current := state{meta: resource.meta, index: resource.index}
+		switch resource._type {
+		case gophkeeper.ResourceTypePiece:
+			current.content = i.storage.pieces[resource.id].content
+		case gophkeeper.ResourceTypeBlob:
+			current.location = i.storage.blobs[resource.id].location
+		}
+		sources := []state{current}
+		for _, v := range i.storage.versions[(gophkeeper.ResourceID)(rid)] {
+			sources = append(sources, state{meta: v.meta, index: v.index, content: v.content, location: v.location})
+		}
+		for n, source := range sources {
+			if err := ctx.Err(); err != nil {
+				discard()
+				return err
+			}
+			reencrypted, reencryptError := source.reencrypt(ctx, resource._type, i.storage, reencryption)
+			if reencryptError != nil {
+				discard()
+				return reencryptError
+			}
+			rewrites = append(rewrites, rewrite{(gophkeeper.ResourceID)(rid), n - 1, reencrypted})
+			gophkeeper.ReportProgress(ctx, len(rewrites), total)
+		}
+	}
+
+	tags, tagsError := i.reencryptTags(reencryption)
+	if tagsError != nil {
+		discard()
+		return tagsError
+	}
+	folders, foldersError := i.reencryptFolders(reencryption)
+	if foldersError != nil {
+		discard()
+		return foldersError
+	}
+
+	for _, r := range rewrites {
+		resource := &i.storage.resources[r.rid]
+		if reencryption.Meta != nil {
+			if r.version == -1 {
+				resource.meta = r.meta
+				resource.index = r.index
+			} else {
+				i.storage.versions[r.rid][r.version].meta = r.meta
+				i.storage.versions[r.rid][r.version].index = r.index
+			}
+			continue
+		}
+		if r.version == -1 {
+			resource.meta = r.meta
+			resource.index = r.index
+			resource.size = r.size
+			resource.hash = r.hash
+			switch resource._type {
+			case gophkeeper.ResourceTypePiece:
+				i.storage.pieces[resource.id].content = r.content
+			case gophkeeper.ResourceTypeBlob:
+				i.storage.removeBlob(i.storage.blobs[resource.id].location)
+				i.storage.blobs[resource.id].location = r.location
+			}
+			continue
+		}
+		v := &i.storage.versions[r.rid][r.version]
+		if v.location != "" {
+			i.storage.removeBlob(v.location)
+		}
+		v.meta = r.meta
+		v.index = r.index
+		v.content = r.content
+		v.location = r.location
+		v.size = r.size
+		v.hash = r.hash
+	}
+	for rid := range i.storage.resources {
+		resource := &i.storage.resources[rid]
+		if resource.owner != i.username || len(resource.tags) == 0 {
+			continue
+		}
+		tagIndex := make(map[string][]string, len(resource.tags))
+		for n, tag := range resource.tags {
+			rewritten := tags[tag]
+			resource.tags[n] = rewritten.tag
+			tagIndex[rewritten.tag] = resource.tagIndex[tag]
+			if reencryption.TagIndex != nil {
+				tagIndex[rewritten.tag] = rewritten.index
+			}
+		}
+		resource.tagIndex = tagIndex
+	}
+	for n, name := range folders {
+		i.storage.folders[n].name = name
+	}
+	i.vaultPassword = newPassword
+
+	return nil
+}
+
+// rewrittenTag is a tag rewritten by a reencryption.
+type rewrittenTag struct {
+	tag   string
+	index []string
+}
+
+// reencryptTags returns the tags of the identity's resources
+// rewritten by the reencryption, keyed by the stored ones.
+func (i *Identity) reencryptTags(reencryption gophkeeper.Reencryption) (map[string]rewrittenTag, error) {
+	tags := make(map[string]rewrittenTag)
+	for rid := range i.storage.resources {
+		resource := &i.storage.resources[rid]
+		if resource.owner != i.username {
+			continue
+		}
+		for _, tag := range resource.tags {
+			if _, ok := tags[tag]; ok {
+				continue
+			}
+			rewritten := rewrittenTag{tag: tag}
+			if reencryption.TagIndex != nil {
+				index, indexError := reencryption.TagIndex(tag)
+				if indexError != nil {
+					return nil, indexError
+				}
+				rewritten.index = index
+			}
+			if reencryption.Tag != nil {
+				rewrittenName, tagError := reencryption.Tag(tag)
+				if tagError != nil {
+					return nil, tagError
+				}
+				rewritten.tag = rewrittenName
+			}
+			tags[tag] = rewritten
+		}
+	}
+	return tags, nil
+}
+
+// reencryptFolders returns the names of the identity's folders
+// rewritten by the reencryption, keyed by their indexes.
+func (i *Identity) reencryptFolders(reencryption gophkeeper.Reencryption) (map[int]string, error) {
+	folders := make(map[int]string)
+	if reencryption.Folder == nil {
+		return folders, nil
+	}
+	for n, folder := range i.storage.folders {
+		if folder.owner != i.username {
+			continue
+		}
+		name, nameError := reencryption.Folder(folder.name)
+		if nameError != nil {
+			return nil, nameError
+		}
+		folders[n] = name
+	}
+	return folders, nil
+}
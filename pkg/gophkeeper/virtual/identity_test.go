@@ -3,11 +3,16 @@ package virtual_test
 import (
 	"bytes"
 	"context"
+	"crypto/sha256"
+	"errors"
 	"io"
+	"os"
 	"strings"
 	"testing"
+	"testing/iotest"
 	"time"
 
+	"github.com/kerelape/gophkeeper/internal/blobstore"
 	"github.com/kerelape/gophkeeper/pkg/gophkeeper"
 	"github.com/kerelape/gophkeeper/pkg/gophkeeper/virtual"
 	"github.com/stretchr/testify/assert"
@@ -107,6 +112,96 @@ func TestIdentity(t *testing.T) {
 	assert.NotNil(t, restoreAlianBlob, "expected to get an error on restoring alian blob")
 	assert.ErrorIs(t, restoreAlianBlob, gophkeeper.ErrResourceNotFound, "unexpected error")
 
+	t.Run("Update", func(t *testing.T) {
+		updatePieceError := identity.UpdatePiece(
+			context.Background(),
+			rid,
+			gophkeeper.Piece{
+				Meta:    "updatedmeta",
+				Content: ([]byte)("updatedcontent"),
+			},
+			credential.Password,
+		)
+		assert.Nil(t, updatePieceError, "expected to successfully update the piece")
+		piece, restoreError := identity.RestorePiece(context.Background(), rid, credential.Password)
+		assert.Nil(t, restoreError, "expected to successfully restore the piece")
+		assert.Equal(t, "updatedmeta", piece.Meta, "meta is not updated")
+		assert.Equal(t, "updatedcontent", (string)(piece.Content), "content is not updated")
+
+		updateBlobError := identity.UpdateBlob(
+			context.Background(),
+			blobRID,
+			gophkeeper.Blob{
+				Meta:    "updatedmeta",
+				Content: io.NopCloser(strings.NewReader("updatedcontent")),
+			},
+			credential.Password,
+		)
+		assert.Nil(t, updateBlobError, "expected to successfully update the blob")
+		blob, restoreBlobError := identity.RestoreBlob(context.Background(), blobRID, credential.Password)
+		assert.Nil(t, restoreBlobError, "expected to successfully restore the blob")
+		content, contentError := io.ReadAll(blob.Content)
+		assert.Nil(t, contentError, "expected to successfully read content")
+		assert.Nil(t, blob.Content.Close(), "failed to close blob content")
+		assert.Equal(t, "updatedmeta", blob.Meta, "meta is not updated")
+		assert.Equal(t, "updatedcontent", (string)(content), "content is not updated")
+
+		alianUpdateError := alian.UpdatePiece(context.Background(), rid, gophkeeper.Piece{}, alianCredential.Password)
+		assert.ErrorIs(t, alianUpdateError, gophkeeper.ErrResourceNotFound, "unexpected error")
+
+		wrongTypeError := identity.UpdatePiece(context.Background(), blobRID, gophkeeper.Piece{}, credential.Password)
+		assert.ErrorIs(t, wrongTypeError, gophkeeper.ErrResourceNotFound, "unexpected error")
+
+		badPasswordError := identity.UpdateBlob(
+			context.Background(),
+			blobRID,
+			gophkeeper.Blob{Content: io.NopCloser(strings.NewReader(""))},
+			"",
+		)
+		assert.ErrorIs(t, badPasswordError, gophkeeper.ErrBadCredential, "unexpected error")
+	})
+
+	t.Run("Versions", func(t *testing.T) {
+		versions, versionsError := identity.ListVersions(context.Background(), rid)
+		assert.Nil(t, versionsError, "expected to successfully list versions")
+		assert.Equal(t, 1, len(versions), "expected the previous version to be kept")
+		assert.Equal(t, "testmeta", versions[0].Meta, "unexpected version meta")
+
+		restoreVersionError := identity.RestoreVersion(context.Background(), rid, versions[0].Version, credential.Password)
+		assert.Nil(t, restoreVersionError, "expected to successfully restore the version")
+
+		piece, restoreError := identity.RestorePiece(context.Background(), rid, credential.Password)
+		assert.Nil(t, restoreError, "expected to successfully restore the piece")
+		assert.Equal(t, "testmeta", piece.Meta, "meta is not reverted")
+		assert.Equal(t, "testcontent", (string)(piece.Content), "content is not reverted")
+
+		versions, versionsError = identity.ListVersions(context.Background(), rid)
+		assert.Nil(t, versionsError, "expected to successfully list versions")
+		assert.Equal(t, 1, len(versions), "expected the replaced version to be kept")
+		assert.Equal(t, "updatedmeta", versions[0].Meta, "unexpected version meta")
+
+		blobVersions, blobVersionsError := identity.ListVersions(context.Background(), blobRID)
+		assert.Nil(t, blobVersionsError, "expected to successfully list versions")
+		assert.Equal(t, 1, len(blobVersions), "expected the previous version to be kept")
+		restoreBlobVersionError := identity.RestoreVersion(context.Background(), blobRID, blobVersions[0].Version, credential.Password)
+		assert.Nil(t, restoreBlobVersionError, "expected to successfully restore the version")
+		blob, restoreBlobError := identity.RestoreBlob(context.Background(), blobRID, credential.Password)
+		assert.Nil(t, restoreBlobError, "expected to successfully restore the blob")
+		content, contentError := io.ReadAll(blob.Content)
+		assert.Nil(t, contentError, "expected to successfully read content")
+		assert.Nil(t, blob.Content.Close(), "failed to close blob content")
+		assert.Equal(t, "testcontent", (string)(content), "content is not reverted")
+
+		unknownVersionError := identity.RestoreVersion(context.Background(), rid, 100, credential.Password)
+		assert.ErrorIs(t, unknownVersionError, gophkeeper.ErrResourceNotFound, "unexpected error")
+
+		_, alianVersionsError := alian.ListVersions(context.Background(), rid)
+		assert.ErrorIs(t, alianVersionsError, gophkeeper.ErrResourceNotFound, "unexpected error")
+
+		badPasswordError := identity.RestoreVersion(context.Background(), rid, versions[0].Version, "")
+		assert.ErrorIs(t, badPasswordError, gophkeeper.ErrBadCredential, "unexpected error")
+	})
+
 	deleteError := identity.Delete(context.Background(), rid)
 	assert.Nil(t, deleteError, "did not expect an error")
 
@@ -114,9 +209,44 @@ func TestIdentity(t *testing.T) {
 	assert.NotNil(t, alianDeleteError, "expected an error")
 	assert.ErrorIs(t, alianDeleteError, gophkeeper.ErrResourceNotFound, "unexpected error")
 
-	resources, listError := identity.List(context.Background())
+	page, listError := identity.List(context.Background(), gophkeeper.ListOptions{})
 	assert.Nil(t, listError, "did not expect an error")
-	assert.Equal(t, 1, len(resources), "expected resources list to be equal to 2")
+	assert.Equal(t, 1, len(page.Resources), "expected resources list to be equal to 2")
+
+	t.Run("Trash", func(t *testing.T) {
+		trash, trashError := identity.ListTrash(context.Background())
+		assert.Nil(t, trashError, "did not expect an error")
+		assert.Equal(t, 1, len(trash), "expected the deleted resource to be in the trash")
+		assert.Equal(t, rid, trash[0].ID, "unexpected resource in the trash")
+		assert.False(t, trash[0].DeletedAt.IsZero(), "expected deletion time to be set")
+
+		_, restoreError := identity.RestorePiece(context.Background(), rid, credential.Password)
+		assert.ErrorIs(t, restoreError, gophkeeper.ErrResourceNotFound, "expected trashed resource to be hidden")
+
+		alianUndeleteError := alian.Undelete(context.Background(), rid)
+		assert.ErrorIs(t, alianUndeleteError, gophkeeper.ErrResourceNotFound, "unexpected error")
+
+		undeleteError := identity.Undelete(context.Background(), rid)
+		assert.Nil(t, undeleteError, "did not expect an error")
+
+		_, restoreError = identity.RestorePiece(context.Background(), rid, credential.Password)
+		assert.Nil(t, restoreError, "expected undeleted resource to be restorable")
+
+		purgeLiveError := identity.Purge(context.Background(), rid)
+		assert.ErrorIs(t, purgeLiveError, gophkeeper.ErrResourceNotFound, "expected only trashed resources to be purged")
+
+		assert.Nil(t, identity.Delete(context.Background(), rid), "did not expect an error")
+
+		purgeError := identity.Purge(context.Background(), rid)
+		assert.Nil(t, purgeError, "did not expect an error")
+
+		trash, trashError = identity.ListTrash(context.Background())
+		assert.Nil(t, trashError, "did not expect an error")
+		assert.Equal(t, 0, len(trash), "expected the trash to be empty")
+
+		undeletePurgedError := identity.Undelete(context.Background(), rid)
+		assert.ErrorIs(t, undeletePurgedError, gophkeeper.ErrResourceNotFound, "unexpected error")
+	})
 
 	t.Run("Invalid RID", func(t *testing.T) {
 		const RID = -1
@@ -151,3 +281,689 @@ func TestIdentity(t *testing.T) {
 		assert.NotNil(t, restoreBlobError)
 	})
 }
+
+func TestIdentitySearch(t *testing.T) {
+	var (
+		g          = virtual.New(time.Hour, t.TempDir())
+		credential = gophkeeper.Credential{
+			Username: "test",
+			Password: "qwerty",
+		}
+	)
+	registerError := g.Register(context.Background(), credential)
+	assert.Nil(t, registerError, "expected to successfully register")
+	token, authenticateError := g.Authenticate(context.Background(), credential)
+	assert.Nil(t, authenticateError, "expected to successfully authenticate")
+	identity, identityError := g.Identity(context.Background(), token)
+	assert.Nil(t, identityError, "expected to successfully get the identity")
+
+	pieceRID, storePieceError := identity.StorePiece(
+		context.Background(),
+		gophkeeper.Piece{Meta: "piece", Content: ([]byte)("piece"), Index: []string{"a", "b"}},
+		credential.Password,
+	)
+	assert.Nil(t, storePieceError, "expected to successfully store a piece")
+	blobRID, storeBlobError := identity.StoreBlob(
+		context.Background(),
+		gophkeeper.Blob{Meta: "blob", Content: io.NopCloser(strings.NewReader("blob")), Index: []string{"b"}},
+		credential.Password,
+	)
+	assert.Nil(t, storeBlobError, "expected to successfully store a blob")
+
+	search := func(tokens ...string) []gophkeeper.ResourceID {
+		resources, searchError := identity.Search(context.Background(), tokens)
+		assert.Nil(t, searchError, "expected to successfully search")
+		rids := make([]gophkeeper.ResourceID, 0, len(resources))
+		for _, resource := range resources {
+			rids = append(rids, resource.ID)
+		}
+		return rids
+	}
+
+	assert.Equal(t, []gophkeeper.ResourceID{pieceRID, blobRID}, search("b"), "expected both resources")
+	assert.Equal(t, []gophkeeper.ResourceID{pieceRID}, search("a", "b"), "expected all the tokens to match")
+	assert.Empty(t, search("c"), "expected no resources")
+
+	tagError := identity.Tag(context.Background(), blobRID, "tag", "c")
+	assert.Nil(t, tagError, "expected to successfully tag the blob")
+	assert.Equal(t, []gophkeeper.ResourceID{blobRID}, search("b", "c"), "expected the tag index to match")
+
+	updateError := identity.UpdatePiece(
+		context.Background(),
+		pieceRID,
+		gophkeeper.Piece{Meta: "piece", Content: ([]byte)("piece"), Index: []string{"d"}},
+		credential.Password,
+	)
+	assert.Nil(t, updateError, "expected to successfully update the piece")
+	assert.Equal(t, []gophkeeper.ResourceID{pieceRID}, search("d"), "expected the index to be updated")
+	assert.Equal(t, []gophkeeper.ResourceID{blobRID}, search("b"), "expected the index to be updated")
+
+	untagError := identity.Untag(context.Background(), blobRID, "tag")
+	assert.Nil(t, untagError, "expected to successfully untag the blob")
+	assert.Empty(t, search("c"), "expected the tag index to be removed")
+
+	assert.Nil(t, identity.Delete(context.Background(), blobRID), "expected to successfully delete the blob")
+	assert.Empty(t, search("b"), "expected trashed resources not to be found")
+}
+
+func TestIdentityVersionsLimit(t *testing.T) {
+	const limit = 2
+	var (
+		g          = virtual.New(time.Hour, t.TempDir(), virtual.WithVersionsLimit(limit))
+		credential = gophkeeper.Credential{
+			Username: "test",
+			Password: "qwerty",
+		}
+	)
+	registerError := g.Register(context.Background(), credential)
+	assert.Nil(t, registerError, "expected to successfully register")
+
+	token, authenticateError := g.Authenticate(context.Background(), credential)
+	assert.Nil(t, authenticateError, "expected to successfully authenticate")
+
+	identity, identityError := g.Identity(context.Background(), token)
+	assert.Nil(t, identityError, "expected to successfully get the identity")
+
+	rid, storeError := identity.StorePiece(
+		context.Background(),
+		gophkeeper.Piece{Meta: "0", Content: ([]byte)("0")},
+		credential.Password,
+	)
+	assert.Nil(t, storeError, "expected to successfully store a piece")
+
+	for _, content := range []string{"1", "2", "3", "4"} {
+		err := identity.UpdatePiece(
+			context.Background(),
+			rid,
+			gophkeeper.Piece{Meta: content, Content: ([]byte)(content)},
+			credential.Password,
+		)
+		assert.Nil(t, err, "expected to successfully update the piece")
+	}
+
+	versions, versionsError := identity.ListVersions(context.Background(), rid)
+	assert.Nil(t, versionsError, "expected to successfully list versions")
+	assert.Equal(t, limit, len(versions), "expected versions to be capped")
+	assert.Equal(t, "3", versions[0].Meta, "expected the latest version first")
+	assert.Equal(t, "2", versions[1].Meta, "expected the oldest versions to be dropped")
+}
+
+func TestPurgeTrash(t *testing.T) {
+	var (
+		g          = virtual.New(time.Hour, t.TempDir())
+		credential = gophkeeper.Credential{
+			Username: "test",
+			Password: "qwerty",
+		}
+	)
+	registerError := g.Register(context.Background(), credential)
+	assert.Nil(t, registerError, "expected to successfully register")
+
+	token, authenticateError := g.Authenticate(context.Background(), credential)
+	assert.Nil(t, authenticateError, "expected to successfully authenticate")
+
+	identity, identityError := g.Identity(context.Background(), token)
+	assert.Nil(t, identityError, "expected to successfully get the identity")
+
+	rid, storeError := identity.StoreBlob(
+		context.Background(),
+		gophkeeper.Blob{Meta: "blob", Content: io.NopCloser(strings.NewReader("content"))},
+		credential.Password,
+	)
+	assert.Nil(t, storeError, "expected to successfully store a blob")
+	assert.Nil(t, identity.Delete(context.Background(), rid), "did not expect an error")
+
+	keepError := g.PurgeTrash(context.Background(), time.Now().Add(-time.Hour))
+	assert.Nil(t, keepError, "did not expect an error")
+	trash, trashError := identity.ListTrash(context.Background())
+	assert.Nil(t, trashError, "did not expect an error")
+	assert.Equal(t, 1, len(trash), "expected fresh resources to be kept")
+
+	purgeError := g.PurgeTrash(context.Background(), time.Now().Add(time.Hour))
+	assert.Nil(t, purgeError, "did not expect an error")
+	trash, trashError = identity.ListTrash(context.Background())
+	assert.Nil(t, trashError, "did not expect an error")
+	assert.Equal(t, 0, len(trash), "expected expired resources to be purged")
+}
+
+func TestIdentityResourceInfo(t *testing.T) {
+	var (
+		g          = virtual.New(time.Hour, t.TempDir())
+		credential = gophkeeper.Credential{
+			Username: "test",
+			Password: "qwerty",
+		}
+	)
+	registerError := g.Register(context.Background(), credential)
+	assert.Nil(t, registerError, "expected to successfully register")
+
+	token, authenticateError := g.Authenticate(context.Background(), credential)
+	assert.Nil(t, authenticateError, "expected to successfully authenticate")
+
+	identity, identityError := g.Identity(context.Background(), token)
+	assert.Nil(t, identityError, "expected to successfully get the identity")
+
+	rid, storeError := identity.StoreBlob(
+		context.Background(),
+		gophkeeper.Blob{Meta: "blob", Content: io.NopCloser(strings.NewReader("content"))},
+		credential.Password,
+	)
+	assert.Nil(t, storeError, "expected to successfully store a blob")
+
+	page, listError := identity.List(context.Background(), gophkeeper.ListOptions{})
+	assert.Nil(t, listError, "did not expect an error")
+	assert.Equal(t, 1, len(page.Resources), "unexpected resources count")
+	hash := sha256.Sum256(([]byte)("content"))
+	assert.Equal(t, rid, page.Resources[0].ID, "unexpected resource")
+	assert.Equal(t, (int64)(len("content")), page.Resources[0].Size, "unexpected size")
+	assert.Equal(t, hash[:], page.Resources[0].Hash, "unexpected hash")
+	assert.False(t, page.Resources[0].CreatedAt.IsZero(), "expected creation time to be set")
+	assert.Equal(t, page.Resources[0].CreatedAt, page.Resources[0].UpdatedAt, "expected new resource to be not updated")
+	assert.True(t, page.Resources[0].AccessedAt.IsZero(), "expected new resource to be not accessed")
+
+	blob, restoreError := identity.RestoreBlob(context.Background(), rid, credential.Password)
+	assert.Nil(t, restoreError, "expected to successfully restore the blob")
+	assert.Nil(t, blob.Content.Close(), "failed to close blob content")
+
+	updateError := identity.UpdateBlob(
+		context.Background(),
+		rid,
+		gophkeeper.Blob{Meta: "blob", Content: io.NopCloser(strings.NewReader("updated content"))},
+		credential.Password,
+	)
+	assert.Nil(t, updateError, "expected to successfully update the blob")
+
+	page, listError = identity.List(context.Background(), gophkeeper.ListOptions{})
+	assert.Nil(t, listError, "did not expect an error")
+	updatedHash := sha256.Sum256(([]byte)("updated content"))
+	assert.Equal(t, (int64)(len("updated content")), page.Resources[0].Size, "size is not updated")
+	assert.Equal(t, updatedHash[:], page.Resources[0].Hash, "hash is not updated")
+	assert.True(t, page.Resources[0].UpdatedAt.After(page.Resources[0].CreatedAt), "update time is not updated")
+	assert.False(t, page.Resources[0].AccessedAt.IsZero(), "access time is not updated")
+
+	versions, versionsError := identity.ListVersions(context.Background(), rid)
+	assert.Nil(t, versionsError, "did not expect an error")
+	revertError := identity.RestoreVersion(context.Background(), rid, versions[0].Version, credential.Password)
+	assert.Nil(t, revertError, "expected to successfully revert the blob")
+
+	page, listError = identity.List(context.Background(), gophkeeper.ListOptions{})
+	assert.Nil(t, listError, "did not expect an error")
+	assert.Equal(t, (int64)(len("content")), page.Resources[0].Size, "size is not reverted")
+	assert.Equal(t, hash[:], page.Resources[0].Hash, "hash is not reverted")
+}
+
+func TestIdentityFolders(t *testing.T) {
+	var (
+		g          = virtual.New(time.Hour, t.TempDir())
+		credential = gophkeeper.Credential{
+			Username: "test",
+			Password: "qwerty",
+		}
+		alianCredential = gophkeeper.Credential{
+			Username: "abc",
+			Password: "nonword",
+		}
+	)
+	identity := func(credential gophkeeper.Credential) gophkeeper.Identity {
+		registerError := g.Register(context.Background(), credential)
+		assert.Nil(t, registerError, "expected to successfully register")
+		token, authenticateError := g.Authenticate(context.Background(), credential)
+		assert.Nil(t, authenticateError, "expected to successfully authenticate")
+		identity, identityError := g.Identity(context.Background(), token)
+		assert.Nil(t, identityError, "expected to successfully get the identity")
+		return identity
+	}
+	var (
+		owner = identity(credential)
+		alian = identity(alianCredential)
+	)
+
+	work, workError := owner.CreateFolder(context.Background(), "work", gophkeeper.RootFolder)
+	assert.Nil(t, workError, "expected to successfully create a folder")
+	project, projectError := owner.CreateFolder(context.Background(), "project", work)
+	assert.Nil(t, projectError, "expected to successfully create a folder")
+
+	_, alianCreateError := alian.CreateFolder(context.Background(), "stolen", work)
+	assert.ErrorIs(t, alianCreateError, gophkeeper.ErrFolderNotFound, "unexpected error")
+
+	folders, foldersError := owner.ListFolders(context.Background())
+	assert.Nil(t, foldersError, "did not expect an error")
+	assert.Equal(
+		t,
+		[]gophkeeper.Folder{
+			{ID: work, Parent: gophkeeper.RootFolder, Name: "work"},
+			{ID: project, Parent: work, Name: "project"},
+		},
+		folders,
+		"unexpected folders",
+	)
+
+	alianFolders, alianFoldersError := alian.ListFolders(context.Background())
+	assert.Nil(t, alianFoldersError, "did not expect an error")
+	assert.Empty(t, alianFolders, "expected other identity's folders to be hidden")
+
+	t.Run("Move folder", func(t *testing.T) {
+		cycleError := owner.MoveFolder(context.Background(), work, project)
+		assert.ErrorIs(t, cycleError, gophkeeper.ErrFolderCycle, "unexpected error")
+
+		selfError := owner.MoveFolder(context.Background(), work, work)
+		assert.ErrorIs(t, selfError, gophkeeper.ErrFolderCycle, "unexpected error")
+
+		rootError := owner.MoveFolder(context.Background(), gophkeeper.RootFolder, work)
+		assert.ErrorIs(t, rootError, gophkeeper.ErrFolderNotFound, "unexpected error")
+
+		alianMoveError := alian.MoveFolder(context.Background(), project, gophkeeper.RootFolder)
+		assert.ErrorIs(t, alianMoveError, gophkeeper.ErrFolderNotFound, "unexpected error")
+
+		moveError := owner.MoveFolder(context.Background(), project, gophkeeper.RootFolder)
+		assert.Nil(t, moveError, "expected to successfully move the folder")
+
+		folders, foldersError := owner.ListFolders(context.Background())
+		assert.Nil(t, foldersError, "did not expect an error")
+		assert.Equal(t, gophkeeper.RootFolder, folders[1].Parent, "folder is not moved")
+	})
+
+	t.Run("Resources", func(t *testing.T) {
+		rid, storeError := owner.StorePiece(
+			context.Background(),
+			gophkeeper.Piece{Meta: "piece", Content: ([]byte)("content")},
+			credential.Password,
+		)
+		assert.Nil(t, storeError, "expected to successfully store a piece")
+		_, otherStoreError := owner.StorePiece(
+			context.Background(),
+			gophkeeper.Piece{Meta: "other", Content: ([]byte)("content")},
+			credential.Password,
+		)
+		assert.Nil(t, otherStoreError, "expected to successfully store a piece")
+
+		moveError := owner.MoveResource(context.Background(), rid, work)
+		assert.Nil(t, moveError, "expected to successfully move the resource")
+
+		unknownFolderError := owner.MoveResource(context.Background(), rid, 100)
+		assert.ErrorIs(t, unknownFolderError, gophkeeper.ErrFolderNotFound, "unexpected error")
+
+		alianMoveError := alian.MoveResource(context.Background(), rid, gophkeeper.RootFolder)
+		assert.ErrorIs(t, alianMoveError, gophkeeper.ErrResourceNotFound, "unexpected error")
+
+		assert.Nil(t, owner.Tag(context.Background(), rid, "important"), "expected to successfully tag")
+		assert.Nil(t, owner.Tag(context.Background(), rid, "important"), "expected tagging to be idempotent")
+		assert.Nil(t, owner.Tag(context.Background(), rid, "shared"), "expected to successfully tag")
+
+		alianTagError := alian.Tag(context.Background(), rid, "mine")
+		assert.ErrorIs(t, alianTagError, gophkeeper.ErrResourceNotFound, "unexpected error")
+
+		inWork, inWorkError := owner.List(context.Background(), gophkeeper.ListOptions{Folder: &work})
+		assert.Nil(t, inWorkError, "did not expect an error")
+		assert.Equal(t, 1, len(inWork.Resources), "unexpected resources count in the folder")
+		assert.Equal(t, rid, inWork.Resources[0].ID, "unexpected resource in the folder")
+		assert.Equal(t, work, inWork.Resources[0].Folder, "unexpected resource folder")
+		assert.Equal(t, []string{"important", "shared"}, inWork.Resources[0].Tags, "unexpected resource tags")
+
+		root := gophkeeper.RootFolder
+		inRoot, inRootError := owner.List(context.Background(), gophkeeper.ListOptions{Folder: &root})
+		assert.Nil(t, inRootError, "did not expect an error")
+		assert.Equal(t, 1, len(inRoot.Resources), "unexpected resources count in the root folder")
+
+		assert.Nil(t, owner.Untag(context.Background(), rid, "important"), "expected to successfully untag")
+
+		tagged, taggedError := owner.List(context.Background(), gophkeeper.ListOptions{Tag: "important"})
+		assert.Nil(t, taggedError, "did not expect an error")
+		assert.Empty(t, tagged.Resources, "expected no resources with the removed tag")
+
+		shared, sharedError := owner.List(context.Background(), gophkeeper.ListOptions{Folder: &work, Tag: "shared"})
+		assert.Nil(t, sharedError, "did not expect an error")
+		assert.Equal(t, 1, len(shared.Resources), "unexpected resources count")
+	})
+}
+
+func TestIdentityListPages(t *testing.T) {
+	var (
+		g          = virtual.New(time.Hour, t.TempDir())
+		credential = gophkeeper.Credential{
+			Username: "test",
+			Password: "qwerty",
+		}
+	)
+	registerError := g.Register(context.Background(), credential)
+	assert.Nil(t, registerError, "expected to successfully register")
+	token, authenticateError := g.Authenticate(context.Background(), credential)
+	assert.Nil(t, authenticateError, "expected to successfully authenticate")
+	identity, identityError := g.Identity(context.Background(), token)
+	assert.Nil(t, identityError, "expected to successfully get the identity")
+
+	rids := make([]gophkeeper.ResourceID, 0, 5)
+	for _, meta := range []string{"note:a", "note:bb", "card:ccc", "note:dddd", "card:eeeee"} {
+		rid, storeError := identity.StorePiece(
+			context.Background(),
+			gophkeeper.Piece{Meta: meta, Content: ([]byte)(meta)},
+			credential.Password,
+		)
+		assert.Nil(t, storeError, "expected to successfully store a piece")
+		rids = append(rids, rid)
+	}
+	blob, storeBlobError := identity.StoreBlob(
+		context.Background(),
+		gophkeeper.Blob{Meta: "file", Content: io.NopCloser(strings.NewReader("f"))},
+		credential.Password,
+	)
+	assert.Nil(t, storeBlobError, "expected to successfully store a blob")
+
+	list := func(options gophkeeper.ListOptions) []gophkeeper.ResourceID {
+		result := make([]gophkeeper.ResourceID, 0)
+		for {
+			page, listError := identity.List(context.Background(), options)
+			assert.Nil(t, listError, "did not expect an error")
+			assert.LessOrEqual(t, len(page.Resources), options.Limit, "page is longer than the limit")
+			for _, resource := range page.Resources {
+				result = append(result, resource.ID)
+			}
+			if page.Next == "" {
+				return result
+			}
+			options.Cursor = page.Next
+		}
+	}
+
+	assert.Equal(
+		t,
+		append(append([]gophkeeper.ResourceID{}, rids...), blob),
+		list(gophkeeper.ListOptions{Limit: 2}),
+		"unexpected resources",
+	)
+	assert.Equal(
+		t,
+		[]gophkeeper.ResourceID{rids[4], rids[3], rids[2], rids[1], rids[0], blob},
+		list(gophkeeper.ListOptions{Order: gophkeeper.ListOrderSize, Descending: true, Limit: 4}),
+		"unexpected resources",
+	)
+	pieceType := gophkeeper.ResourceTypePiece
+	assert.Equal(
+		t,
+		[]gophkeeper.ResourceID{rids[0], rids[1], rids[3]},
+		list(gophkeeper.ListOptions{Type: &pieceType, MetaPrefix: "note:", Limit: 1}),
+		"unexpected resources",
+	)
+	assert.Equal(
+		t,
+		[]gophkeeper.ResourceID{rids[2]},
+		list(gophkeeper.ListOptions{ID: &rids[2], Limit: 1}),
+		"unexpected resources",
+	)
+
+	_, invalidCursorError := identity.List(context.Background(), gophkeeper.ListOptions{Cursor: "invalid"})
+	assert.ErrorIs(t, invalidCursorError, gophkeeper.ErrInvalidCursor, "unexpected error")
+
+	page, pageError := identity.List(context.Background(), gophkeeper.ListOptions{Limit: 1})
+	assert.Nil(t, pageError, "did not expect an error")
+	_, mismatchError := identity.List(
+		context.Background(),
+		gophkeeper.ListOptions{Descending: true, Cursor: page.Next},
+	)
+	assert.ErrorIs(t, mismatchError, gophkeeper.ErrInvalidCursor, "unexpected error")
+}
+
+func TestIdentityUpdateBlobFailure(t *testing.T) {
+	var (
+		dir        = t.TempDir()
+		g          = virtual.New(time.Hour, dir)
+		credential = gophkeeper.Credential{
+			Username: "test",
+			Password: "qwerty",
+		}
+	)
+	registerError := g.Register(context.Background(), credential)
+	assert.Nil(t, registerError, "expected to successfully register")
+	token, authenticateError := g.Authenticate(context.Background(), credential)
+	assert.Nil(t, authenticateError, "expected to successfully authenticate")
+	identity, identityError := g.Identity(context.Background(), token)
+	assert.Nil(t, identityError, "expected to successfully get the identity")
+
+	rid, storeError := identity.StoreBlob(
+		context.Background(),
+		gophkeeper.Blob{Meta: "file", Content: io.NopCloser(strings.NewReader("content"))},
+		credential.Password,
+	)
+	assert.Nil(t, storeError, "expected to successfully store a blob")
+
+	updateError := identity.UpdateBlob(
+		context.Background(),
+		rid,
+		gophkeeper.Blob{
+			Meta:    "file",
+			Content: io.NopCloser(io.MultiReader(strings.NewReader("partial"), iotest.ErrReader(errors.New("broken")))),
+		},
+		credential.Password,
+	)
+	assert.NotNil(t, updateError, "expected the update to fail")
+
+	entries, entriesError := os.ReadDir(dir)
+	assert.Nil(t, entriesError, "expected to read the blobs dir")
+	assert.Equal(t, 1, len(entries), "expected only the stored blob to be kept")
+
+	blob, restoreError := identity.RestoreBlob(context.Background(), rid, credential.Password)
+	assert.Nil(t, restoreError, "expected to successfully restore the blob")
+	content, contentError := io.ReadAll(blob.Content)
+	assert.Nil(t, contentError, "expected to successfully read content")
+	assert.Nil(t, blob.Content.Close(), "failed to close blob content")
+	assert.Equal(t, "content", (string)(content), "expected the blob to be kept")
+}
+
+func TestIdentityBlobStore(t *testing.T) {
+	var (
+		ctx        = context.Background()
+		store      = &blobstore.FS{Dir: t.TempDir()}
+		g          = virtual.New(time.Hour, t.TempDir(), virtual.WithBlobStore(store))
+		credential = gophkeeper.Credential{
+			Username: "test",
+			Password: "qwerty",
+		}
+	)
+	registerError := g.Register(ctx, credential)
+	assert.Nil(t, registerError, "expected to successfully register")
+	token, authenticateError := g.Authenticate(ctx, credential)
+	assert.Nil(t, authenticateError, "expected to successfully authenticate")
+	identity, identityError := g.Identity(ctx, token)
+	assert.Nil(t, identityError, "expected to successfully get the identity")
+
+	rid, storeError := identity.StoreBlob(
+		ctx,
+		gophkeeper.Blob{Meta: "file", Content: io.NopCloser(strings.NewReader("content"))},
+		credential.Password,
+	)
+	assert.Nil(t, storeError, "expected to successfully store a blob")
+	blobs, listError := store.List(ctx)
+	assert.Nil(t, listError, "expected to list the blob store")
+	if assert.Equal(t, 1, len(blobs), "expected the blob to be put into the blob store") {
+		assert.Equal(t, (int64)(len("content")), blobs[0].Size)
+	}
+
+	blob, restoreError := identity.RestoreBlob(ctx, rid, credential.Password)
+	assert.Nil(t, restoreError, "expected to successfully restore the blob")
+	content, contentError := io.ReadAll(blob.Content)
+	assert.Nil(t, contentError, "expected to successfully read content")
+	assert.Nil(t, blob.Content.Close(), "failed to close blob content")
+	assert.Equal(t, "content", (string)(content), "expected the blob to be read from the blob store")
+
+	assert.Nil(t, identity.Delete(ctx, rid), "expected to successfully delete the blob")
+	assert.Nil(t, g.PurgeTrash(ctx, time.Now().Add(time.Hour)), "expected to successfully purge the trash")
+	blobs, listError = store.List(ctx)
+	assert.Nil(t, listError, "expected to list the blob store")
+	assert.Empty(t, blobs, "expected the purged blob to be deleted from the blob store")
+}
+
+func TestIdentityBlobSizes(t *testing.T) {
+	var (
+		g          = virtual.New(time.Hour, t.TempDir())
+		credential = gophkeeper.Credential{
+			Username: "test",
+			Password: "qwerty",
+		}
+	)
+	registerError := g.Register(context.Background(), credential)
+	assert.Nil(t, registerError, "expected to successfully register")
+	token, authenticateError := g.Authenticate(context.Background(), credential)
+	assert.Nil(t, authenticateError, "expected to successfully authenticate")
+	identity, identityError := g.Identity(context.Background(), token)
+	assert.Nil(t, identityError, "expected to successfully get the identity")
+
+	// The content is written whole whatever its size is,
+	// none of it is left in a buffer once the blob is stored.
+	for _, size := range []int{1, 4095, 4096, 4097, 3*4096 + 7} {
+		content := strings.Repeat("a", size)
+		rid, storeError := identity.StoreBlob(
+			context.Background(),
+			gophkeeper.Blob{Meta: "file", Content: io.NopCloser(iotest.OneByteReader(strings.NewReader(content)))},
+			credential.Password,
+		)
+		assert.Nil(t, storeError, "expected to successfully store a blob")
+
+		blob, restoreError := identity.RestoreBlob(context.Background(), rid, credential.Password)
+		assert.Nil(t, restoreError, "expected to successfully restore the blob")
+		restored, restoredError := io.ReadAll(blob.Content)
+		assert.Nil(t, restoredError, "expected to successfully read content")
+		assert.Nil(t, blob.Content.Close(), "failed to close blob content")
+		assert.Equal(t, size, len(restored), "expected the blob to be stored whole")
+	}
+}
+
+func TestIdentityChangePassword(t *testing.T) {
+	var (
+		g          = virtual.New(time.Hour, t.TempDir())
+		credential = gophkeeper.Credential{
+			Username: "test",
+			Password: "qwerty",
+		}
+	)
+	registerError := g.Register(context.Background(), credential)
+	assert.Nil(t, registerError, "expected to successfully register")
+	token, authenticateError := g.Authenticate(context.Background(), credential)
+	assert.Nil(t, authenticateError, "expected to successfully authenticate")
+	identity, identityError := g.Identity(context.Background(), token)
+	assert.Nil(t, identityError, "expected to successfully get the identity")
+
+	pieceRID, storePieceError := identity.StorePiece(
+		context.Background(),
+		gophkeeper.Piece{Meta: "piece", Content: ([]byte)("piece")},
+		credential.Password,
+	)
+	assert.Nil(t, storePieceError, "expected to successfully store a piece")
+	blobRID, storeBlobError := identity.StoreBlob(
+		context.Background(),
+		gophkeeper.Blob{Meta: "blob", Content: io.NopCloser(strings.NewReader("blob"))},
+		credential.Password,
+	)
+	assert.Nil(t, storeBlobError, "expected to successfully store a blob")
+
+	reencrypter, ok := identity.(gophkeeper.Reencrypter)
+	assert.True(t, ok, "expected identity to be a reencrypter")
+	upper := gophkeeper.Reencryption{
+		Piece: func(piece gophkeeper.Piece) (gophkeeper.Piece, error) {
+			return gophkeeper.Piece{Meta: strings.ToUpper(piece.Meta), Content: bytes.ToUpper(piece.Content)}, nil
+		},
+		Blob: func(blob gophkeeper.Blob) (gophkeeper.Blob, error) {
+			content, contentError := io.ReadAll(blob.Content)
+			if contentError != nil {
+				return gophkeeper.Blob{}, contentError
+			}
+			return gophkeeper.Blob{
+				Meta:    strings.ToUpper(blob.Meta),
+				Content: io.NopCloser(bytes.NewReader(bytes.ToUpper(content))),
+			}, blob.Content.Close()
+		},
+	}
+
+	t.Run("Failed", func(t *testing.T) {
+		failing := upper
+		failing.Blob = func(gophkeeper.Blob) (gophkeeper.Blob, error) {
+			return gophkeeper.Blob{}, io.ErrUnexpectedEOF
+		}
+		err := reencrypter.Reencrypt(context.Background(), credential.Password, "new", failing)
+		assert.ErrorIs(t, err, io.ErrUnexpectedEOF, "unexpected error")
+		piece, restoreError := identity.RestorePiece(context.Background(), pieceRID, credential.Password)
+		assert.Nil(t, restoreError, "expected the password to be unchanged")
+		assert.Equal(t, "piece", (string)(piece.Content), "expected the piece to be unchanged")
+	})
+
+	t.Run("Bad password", func(t *testing.T) {
+		err := reencrypter.Reencrypt(context.Background(), "wrong", "new", upper)
+		assert.ErrorIs(t, err, gophkeeper.ErrBadCredential, "unexpected error")
+		err = identity.ChangePassword(context.Background(), "wrong", "new")
+		assert.ErrorIs(t, err, gophkeeper.ErrBadCredential, "unexpected error")
+		err = identity.ChangeVaultPassword(context.Background(), "wrong", "new")
+		assert.ErrorIs(t, err, gophkeeper.ErrBadCredential, "unexpected error")
+	})
+
+	t.Run("Reencrypt", func(t *testing.T) {
+		err := reencrypter.Reencrypt(context.Background(), credential.Password, "new", upper)
+		assert.Nil(t, err, "expected to successfully re-encrypt")
+
+		_, oldPasswordError := identity.RestorePiece(context.Background(), pieceRID, credential.Password)
+		assert.ErrorIs(t, oldPasswordError, gophkeeper.ErrBadCredential, "expected old password to be rejected")
+
+		piece, restorePieceError := identity.RestorePiece(context.Background(), pieceRID, "new")
+		assert.Nil(t, restorePieceError, "did not expect an error")
+		assert.Equal(t, gophkeeper.Piece{Meta: "PIECE", Content: ([]byte)("PIECE")}, piece, "piece is not re-encrypted")
+
+		blob, restoreBlobError := identity.RestoreBlob(context.Background(), blobRID, "new")
+		assert.Nil(t, restoreBlobError, "did not expect an error")
+		content, contentError := io.ReadAll(blob.Content)
+		assert.Nil(t, contentError, "did not expect an error")
+		assert.Nil(t, blob.Content.Close(), "failed to close blob content")
+		assert.Equal(t, "BLOB", (string)(content), "blob is not re-encrypted")
+
+		page, listError := identity.List(context.Background(), gophkeeper.ListOptions{})
+		assert.Nil(t, listError, "did not expect an error")
+		hash := sha256.Sum256(([]byte)("PIECE"))
+		assert.Equal(t, hash[:], page.Resources[0].Hash, "hash is not updated")
+	})
+
+	t.Run("Reencrypt meta", func(t *testing.T) {
+		lower := gophkeeper.Reencryption{
+			Meta: func(meta string) (string, error) {
+				return strings.ToLower(meta), nil
+			},
+		}
+		err := reencrypter.Reencrypt(context.Background(), "new", "newer", lower)
+		assert.Nil(t, err, "expected to successfully re-encrypt")
+
+		piece, restorePieceError := identity.RestorePiece(context.Background(), pieceRID, "newer")
+		assert.Nil(t, restorePieceError, "did not expect an error")
+		assert.Equal(t, gophkeeper.Piece{Meta: "piece", Content: ([]byte)("PIECE")}, piece, "expected the content to be kept")
+
+		blob, restoreBlobError := identity.RestoreBlob(context.Background(), blobRID, "newer")
+		assert.Nil(t, restoreBlobError, "did not expect an error")
+		content, contentError := io.ReadAll(blob.Content)
+		assert.Nil(t, contentError, "did not expect an error")
+		assert.Nil(t, blob.Content.Close(), "failed to close blob content")
+		assert.Equal(t, "blob", blob.Meta, "blob meta is not rewritten")
+		assert.Equal(t, "BLOB", (string)(content), "expected the content to be kept")
+	})
+
+	t.Run("Metas", func(t *testing.T) {
+		metas, metasError := reencrypter.Metas(context.Background(), "newer")
+		assert.Nil(t, metasError, "did not expect an error")
+		assert.Contains(t, metas, "piece", "expected the piece meta")
+		assert.Contains(t, metas, "blob", "expected the blob meta")
+
+		_, badError := reencrypter.Metas(context.Background(), "new")
+		assert.ErrorIs(t, badError, gophkeeper.ErrBadCredential, "unexpected error")
+	})
+
+	t.Run("Change vault password", func(t *testing.T) {
+		err := identity.ChangeVaultPassword(context.Background(), "newer", credential.Password)
+		assert.Nil(t, err, "expected to successfully change the vault password")
+		piece, restoreError := identity.RestorePiece(context.Background(), pieceRID, credential.Password)
+		assert.Nil(t, restoreError, "expected the new password to be accepted")
+		assert.Equal(t, "PIECE", (string)(piece.Content), "expected the content to be left as is")
+	})
+
+	t.Run("Change password", func(t *testing.T) {
+		err := identity.ChangePassword(context.Background(), credential.Password, "login")
+		assert.Nil(t, err, "expected to successfully change the password")
+		_, restoreError := identity.RestorePiece(context.Background(), pieceRID, credential.Password)
+		assert.Nil(t, restoreError, "expected the vault password to be kept")
+		_, storeError := identity.StorePiece(context.Background(), gophkeeper.Piece{}, "login")
+		assert.ErrorIs(t, storeError, gophkeeper.ErrBadCredential, "expected the login password to be rejected")
+	})
+}
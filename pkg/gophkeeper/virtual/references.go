@@ -0,0 +1,55 @@
+package virtual
+
+import (
+	"context"
+	"sync"
+
+	"github.com/kerelape/gophkeeper/internal/blobstore"
+)
+
+// references are the references to the chunks
+// of the deduplicated blobs counted in RAM.
+type references struct {
+	counts map[string]int
+
+	mutex *sync.Mutex
+}
+
+var _ blobstore.References = (*references)(nil)
+
+// Acquire implements blobstore.References.
+func (r *references) Acquire(_ context.Context, hash string) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.counts[hash]++
+	return nil
+}
+
+// Release implements blobstore.References.
+func (r *references) Release(_ context.Context, hashes []string) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	for _, hash := range hashes {
+		r.counts[hash]--
+	}
+	return nil
+}
+
+// Collect implements blobstore.References.
+func (r *references) Collect(_ context.Context, collect func(hash string) error) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	for hash, count := range r.counts {
+		if count > 0 {
+			continue
+		}
+		if err := collect(hash); err != nil {
+			return err
+		}
+		delete(r.counts, hash)
+	}
+	return nil
+}
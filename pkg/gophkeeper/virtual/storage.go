@@ -1,17 +1,40 @@
 package virtual
 
 import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"io"
+	"log"
+	"slices"
 	"sync"
+	"time"
 
+	"github.com/kerelape/gophkeeper/internal/blobstore"
 	"github.com/kerelape/gophkeeper/pkg/gophkeeper"
 )
 
 type (
 	resource struct {
-		meta  string
-		id    int
-		owner string
-		_type gophkeeper.ResourceType
+		meta     string
+		id       int
+		owner    string
+		_type    gophkeeper.ResourceType
+		revision int
+
+		folder gophkeeper.FolderID
+		tags   []string
+
+		index    []string
+		tagIndex map[string][]string
+
+		size int64
+		hash []byte
+
+		createdAt  time.Time
+		updatedAt  time.Time
+		accessedAt time.Time
+		deletedAt  time.Time
 	}
 	piece struct {
 		content []byte
@@ -19,12 +42,259 @@ type (
 	blob struct {
 		location string
 	}
+	folder struct {
+		owner  string
+		parent gophkeeper.FolderID
+		name   string
+	}
+	version struct {
+		number    int
+		meta      string
+		index     []string
+		content   []byte
+		location  string
+		size      int64
+		hash      []byte
+		createdAt time.Time
+	}
 )
 
+// state is a stored state of a resource.
+type state struct {
+	meta     string
+	index    []string
+	content  []byte
+	location string
+	size     int64
+	hash     []byte
+}
+
+// reencrypt rewrites the content with the reencryption,
+// a rewritten blob is written into a new blob of the storage.
+// The state rewritten by reencryption.Meta holds the meta only,
+// the index is kept as is unless reencryption.Index replaces it.
+func (c state) reencrypt(
+	ctx context.Context,
+	resourceType gophkeeper.ResourceType,
+	s *storage,
+	reencryption gophkeeper.Reencryption,
+) (state, error) {
+	reencrypted, reencryptError := c.rewrite(ctx, resourceType, s, reencryption)
+	if reencryptError != nil {
+		return state{}, reencryptError
+	}
+	reencrypted.index = c.index
+	if reencryption.Index != nil {
+		index, indexError := reencryption.Index(reencrypted.meta)
+		if indexError != nil {
+			if reencrypted.location != "" {
+				s.removeBlob(reencrypted.location)
+			}
+			return state{}, indexError
+		}
+		reencrypted.index = index
+	}
+	return reencrypted, nil
+}
+
+// rewrite rewrites the meta and the content of the state.
+func (c state) rewrite(
+	ctx context.Context,
+	resourceType gophkeeper.ResourceType,
+	s *storage,
+	reencryption gophkeeper.Reencryption,
+) (state, error) {
+	if reencryption.Meta != nil {
+		meta, metaError := reencryption.Meta(c.meta)
+		if metaError != nil {
+			return state{}, metaError
+		}
+		return state{meta: meta}, nil
+	}
+	switch resourceType {
+	case gophkeeper.ResourceTypePiece:
+		piece, pieceError := reencryption.Piece(gophkeeper.Piece{Meta: c.meta, Content: c.content})
+		if pieceError != nil {
+			return state{}, pieceError
+		}
+		hash := sha256.Sum256(piece.Content)
+		return state{meta: piece.Meta, content: piece.Content, size: (int64)(len(piece.Content)), hash: hash[:]}, nil
+	case gophkeeper.ResourceTypeBlob:
+		file, fileError := blobstore.Open(ctx, s.blobStore, c.location)
+		if fileError != nil {
+			return state{}, fileError
+		}
+		blob, blobError := reencryption.Blob(gophkeeper.Blob{Meta: c.meta, Content: file})
+		if blobError != nil {
+			file.Close()
+			return state{}, blobError
+		}
+		defer blob.Content.Close()
+		location, size, hash, writeError := s.writeBlob(ctx, blob.Content)
+		if writeError != nil {
+			return state{}, writeError
+		}
+		return state{meta: blob.Meta, location: location, size: size, hash: hash}, nil
+	default:
+		return state{}, errors.New("unknown resource type")
+	}
+}
+
+// info returns public information about the resource.
+func (r *resource) info(rid gophkeeper.ResourceID) gophkeeper.Resource {
+	return gophkeeper.Resource{
+		ID:         rid,
+		Type:       r._type,
+		Meta:       r.meta,
+		Folder:     r.folder,
+		Tags:       append([]string{}, r.tags...),
+		CreatedAt:  r.createdAt,
+		UpdatedAt:  r.updatedAt,
+		AccessedAt: r.accessedAt,
+		Size:       r.size,
+		Hash:       r.hash,
+	}
+}
+
 type storage struct {
 	resources []resource
 	blobs     []blob
 	pieces    []piece
+	folders   []folder
+	versions  map[gophkeeper.ResourceID][]version
+
+	versionsLimit int
+
+	blobStore blobstore.BlobStore
 
 	mutex *sync.Mutex
 }
+
+// lookup returns the resource owned by the owner
+// unless it is moved to the trash.
+func (s *storage) lookup(rid gophkeeper.ResourceID, owner string) (*resource, bool) {
+	if !((int)(rid) < len(s.resources)) || rid < 0 {
+		return nil, false
+	}
+	r := &s.resources[rid]
+	if r.owner != owner || !r.deletedAt.IsZero() {
+		return nil, false
+	}
+	return r, true
+}
+
+// lookupTrash returns the resource owned by the owner
+// only if it is moved to the trash.
+func (s *storage) lookupTrash(rid gophkeeper.ResourceID, owner string) (*resource, bool) {
+	if !((int)(rid) < len(s.resources)) || rid < 0 {
+		return nil, false
+	}
+	r := &s.resources[rid]
+	if r.owner != owner || r.deletedAt.IsZero() {
+		return nil, false
+	}
+	return r, true
+}
+
+// lookupFolder returns the folder owned by the owner.
+// The root folder is owned by everyone and is never returned.
+func (s *storage) lookupFolder(id gophkeeper.FolderID, owner string) (*folder, bool) {
+	if id == gophkeeper.RootFolder {
+		return nil, true
+	}
+	if !((int)(id) <= len(s.folders)) || id < 0 {
+		return nil, false
+	}
+	f := &s.folders[id-1]
+	if f.owner != owner {
+		return nil, false
+	}
+	return f, true
+}
+
+// purge permanently deletes the resource with its history.
+func (s *storage) purge(rid gophkeeper.ResourceID) {
+	r := &s.resources[rid]
+	switch r._type {
+	case gophkeeper.ResourceTypePiece:
+		s.pieces[r.id].content = nil
+	case gophkeeper.ResourceTypeBlob:
+		s.removeBlob(s.blobs[r.id].location)
+		s.blobs[r.id].location = ""
+	}
+	for _, v := range s.versions[rid] {
+		if v.location != "" {
+			s.removeBlob(v.location)
+		}
+	}
+	delete(s.versions, rid)
+	r.owner = ""
+	r.meta = ""
+	r.tags = nil
+	r.index = nil
+	r.tagIndex = nil
+}
+
+// indexed reports whether the resource is indexed by all of the tokens.
+func (r *resource) indexed(tokens []string) bool {
+	for _, token := range tokens {
+		found := slices.Contains(r.index, token)
+		for _, tag := range r.tags {
+			found = found || slices.Contains(r.tagIndex[tag], token)
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// pushVersion saves the current state of the resource
+// into its history and drops the revisions beyond the limit.
+func (s *storage) pushVersion(rid gophkeeper.ResourceID) {
+	r := &s.resources[rid]
+	r.revision++
+	v := version{
+		number:    r.revision,
+		meta:      r.meta,
+		index:     r.index,
+		size:      r.size,
+		hash:      r.hash,
+		createdAt: time.Now(),
+	}
+	switch r._type {
+	case gophkeeper.ResourceTypePiece:
+		v.content = s.pieces[r.id].content
+	case gophkeeper.ResourceTypeBlob:
+		v.location = s.blobs[r.id].location
+	}
+	versions := append(s.versions[rid], v)
+	for len(versions) > s.versionsLimit {
+		if location := versions[0].location; location != "" {
+			s.removeBlob(location)
+		}
+		versions = versions[1:]
+	}
+	s.versions[rid] = versions
+}
+
+// writeBlob puts the content into a new blob of the blob store
+// and returns its location, size and SHA-256 hash.
+func (s *storage) writeBlob(ctx context.Context, content io.Reader) (string, int64, []byte, error) {
+	var (
+		location = s.blobStore.NewKey()
+		hash     = sha256.New()
+	)
+	size, putError := s.blobStore.Put(ctx, location, io.TeeReader(content, hash))
+	if putError != nil {
+		return "", 0, nil, putError
+	}
+	return location, size, hash.Sum(nil), nil
+}
+
+// removeBlob deletes the blob from the blob store.
+func (s *storage) removeBlob(location string) {
+	if err := s.blobStore.Delete(context.Background(), location); err != nil {
+		log.Printf("failed to remove file: %s\n", err.Error())
+	}
+}